@@ -0,0 +1,127 @@
+package authboss
+
+import "net/http"
+
+// ErrorCode is a stable, machine readable name for why a request failed.
+// Unlike the messages shown to users they don't change with the locale or
+// between versions, so API clients can act on them.
+type ErrorCode string
+
+// Error codes sent by the modules
+const (
+	// ErrorCodeFailed is for failures that don't have a more specific code
+	ErrorCodeFailed ErrorCode = "failed"
+	// ErrorCodeValidation is for form values that didn't validate, the
+	// APIError's Fields say which and why
+	ErrorCodeValidation ErrorCode = "validation_failed"
+	// ErrorCodeInvalidCredentials is for a wrong pid, password or one time
+	// password when logging in
+	ErrorCodeInvalidCredentials ErrorCode = "invalid_credentials"
+	// ErrorCodeChallengeRequired is for requests that needed a challenge
+	// (like proof of work) that wasn't completed
+	ErrorCodeChallengeRequired ErrorCode = "challenge_required"
+	// ErrorCodeLocked is for users that are locked
+	ErrorCodeLocked ErrorCode = "account_locked"
+	// ErrorCodeSuspended is for accounts an admin suspended
+	ErrorCodeSuspended ErrorCode = "account_suspended"
+	// ErrorCodeUnconfirmed is for users that haven't confirmed their
+	// e-mail address yet
+	ErrorCodeUnconfirmed ErrorCode = "account_unconfirmed"
+	// ErrorCodeInvalidToken is for confirm, recover, unlock and api tokens
+	// that are wrong or unknown
+	ErrorCodeInvalidToken ErrorCode = "invalid_token"
+	// ErrorCodeExpiredToken is for tokens that were right but have expired
+	ErrorCodeExpiredToken ErrorCode = "expired_token"
+	// ErrorCodeNotAuthorized is for requests that need a (recent) login
+	ErrorCodeNotAuthorized ErrorCode = "not_authorized"
+	// ErrorCodeForbidden is for users without the roles or permissions a
+	// route requires
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// ErrorCodeTooManySessions is for logins over the session limit
+	ErrorCodeTooManySessions ErrorCode = "too_many_sessions"
+	// ErrorCodeRateLimited is for requests that came too soon or too often
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+	// ErrorCodeNotEnabled is for using a feature the user hasn't set up,
+	// like an authenticator app
+	ErrorCodeNotEnabled ErrorCode = "not_enabled"
+	// ErrorCodeMailFailed is for e-mails that couldn't be sent
+	ErrorCodeMailFailed ErrorCode = "mail_failed"
+	// ErrorCodeOAuth2Failed is for oauth2 logins and links that failed or
+	// were cancelled
+	ErrorCodeOAuth2Failed ErrorCode = "oauth2_failed"
+	// ErrorCodeReadOnly is for changes refused in read only mode
+	ErrorCodeReadOnly ErrorCode = "read_only"
+	// ErrorCodeLockdown is for requests to modules that are locked down
+	ErrorCodeLockdown ErrorCode = "lockdown"
+	// ErrorCodeInvalidCSRF is for requests without a valid CSRF token
+	ErrorCodeInvalidCSRF ErrorCode = "invalid_csrf_token"
+	// ErrorCodeInternal is for requests that failed because of a bug, like
+	// a handler that panicked
+	ErrorCodeInternal ErrorCode = "internal_error"
+)
+
+// Status is the HTTP status API responses with the code are sent with,
+// unless the module chose a failure status itself.
+func (e ErrorCode) Status() int {
+	switch e {
+	case ErrorCodeValidation:
+		return http.StatusUnprocessableEntity
+	case ErrorCodeInvalidCredentials, ErrorCodeNotAuthorized:
+		return http.StatusUnauthorized
+	case ErrorCodeLocked, ErrorCodeSuspended, ErrorCodeUnconfirmed, ErrorCodeTooManySessions, ErrorCodeForbidden, ErrorCodeInvalidCSRF:
+		return http.StatusForbidden
+	case ErrorCodeRateLimited:
+		return http.StatusTooManyRequests
+	case ErrorCodeMailFailed, ErrorCodeReadOnly, ErrorCodeLockdown:
+		return http.StatusServiceUnavailable
+	case ErrorCodeInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// APIError is a failure as it's sent to API clients, see
+// defaults.ErrorEnvelope for how it's wrapped.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message,omitempty"`
+	// Fields are the validation errors of each form field, errors that
+	// aren't about a field are under ""
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+// APIErrorFromData makes the APIError for a response's data, ok is false
+// when the data isn't a failure (it has no DataErr, DataValidation or
+// DataErrCode). The code is ErrorCodeValidation for validation errors and
+// ErrorCodeFailed for other errors when DataErrCode isn't set.
+func APIErrorFromData(data HTMLData) (apiErr APIError, ok bool) {
+	if code, has := data[DataErrCode].(ErrorCode); has {
+		apiErr.Code, ok = code, true
+	}
+	if msg, has := data[DataErr].(string); has && len(msg) != 0 {
+		apiErr.Message, ok = msg, true
+	}
+
+	switch validation := data[DataValidation].(type) {
+	case map[string][]string:
+		if len(validation) != 0 {
+			apiErr.Fields, ok = validation, true
+			if len(apiErr.Code) == 0 {
+				apiErr.Code = ErrorCodeValidation
+			}
+		}
+	case string:
+		if len(validation) != 0 {
+			ok = true
+			if len(apiErr.Message) == 0 {
+				apiErr.Message = validation
+			}
+		}
+	}
+
+	if ok && len(apiErr.Code) == 0 {
+		apiErr.Code = ErrorCodeFailed
+	}
+	return apiErr, ok
+}
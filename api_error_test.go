@@ -0,0 +1,72 @@
+package authboss
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAPIErrorFromData(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name string
+		Data HTMLData
+		OK   bool
+		Want APIError
+	}{
+		{"None", HTMLData{DataPreserve: map[string]string{"name": "Jake"}}, false, APIError{}},
+		{
+			"Error",
+			HTMLData{DataErr: "Invalid Credentials", DataErrCode: ErrorCodeInvalidCredentials},
+			true,
+			APIError{Code: ErrorCodeInvalidCredentials, Message: "Invalid Credentials"},
+		},
+		{
+			"ErrorWithoutCode",
+			HTMLData{DataErr: "Something went wrong"},
+			true,
+			APIError{Code: ErrorCodeFailed, Message: "Something went wrong"},
+		},
+		{
+			"Validation",
+			HTMLData{DataValidation: map[string][]string{"email": {"Cannot be blank"}}},
+			true,
+			APIError{Code: ErrorCodeValidation, Fields: map[string][]string{"email": {"Cannot be blank"}}},
+		},
+		{
+			"ValidationMessage",
+			HTMLData{DataValidation: "Too many"},
+			true,
+			APIError{Code: ErrorCodeFailed, Message: "Too many"},
+		},
+		{"EmptyValidation", HTMLData{DataValidation: map[string][]string{}}, false, APIError{}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			got, ok := APIErrorFromData(test.Data)
+			if ok != test.OK {
+				t.Error("ok was wrong:", ok)
+			}
+			if !reflect.DeepEqual(got, test.Want) {
+				t.Errorf("want: %#v\ngot:  %#v", test.Want, got)
+			}
+		})
+	}
+}
+
+func TestErrorCodeStatus(t *testing.T) {
+	t.Parallel()
+
+	if s := ErrorCodeValidation.Status(); s != http.StatusUnprocessableEntity {
+		t.Error("status was wrong:", s)
+	}
+	if s := ErrorCodeLocked.Status(); s != http.StatusForbidden {
+		t.Error("status was wrong:", s)
+	}
+	if s := ErrorCode("made_up").Status(); s != http.StatusBadRequest {
+		t.Error("status was wrong:", s)
+	}
+}
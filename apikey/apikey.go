@@ -0,0 +1,257 @@
+// Package apikey lets users create personal access tokens (api keys) for
+// scripts and other machine clients that can't log in with a session.
+//
+// Keys are random, start with Modules.APIKeyPrefix and are only shown
+// once when they're created, the storer only ever sees their hash. Users
+// list their keys at GET /apikeys, create one with a POST to /apikeys and
+// revoke one at /apikeys/revoke. Middleware authenticates requests that
+// send a key as a bearer token.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// PageAPIKeys lists the user's api keys
+	PageAPIKeys = "apikeys"
+	// PageAPIKeyCreate is for identifying the create request for parsing
+	// & validation, it's also used to respond with the new key
+	PageAPIKeyCreate = "apikey_create"
+	// PageAPIKeyRevoke is for identifying the revoke request for parsing &
+	// validation
+	PageAPIKeyRevoke = "apikey_revoke"
+
+	// DataAPIKeys is the user's keys ([]Key)
+	DataAPIKeys = "api_keys"
+	// DataAPIKey is the key that was just created, it's the only time the
+	// key is shown
+	DataAPIKey = "api_key"
+
+	nKeySize  = 32
+	nIDSize   = 16
+	nHintSize = 6
+)
+
+func init() {
+	authboss.RegisterModule("apikey", &APIKey{})
+}
+
+// Key is an api key as it's shown to its user
+type Key struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Hint    string     `json:"hint"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// APIKey module
+type APIKey struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what creating api keys needs
+func (a *APIKey) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Storage.Server", "Modules.APIKeyPrefix")
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.APIKeyServerStorer)(nil))...)
+}
+
+// Init module
+func (a *APIKey) Init(ab *authboss.Authboss) error {
+	a.Authboss = ab
+
+	if err := a.Config.Core.ViewRenderer.Load(PageAPIKeys, PageAPIKeyCreate, PageAPIKeyRevoke); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if ab.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = ab.Config.Modules.ResponseOnUnauthed
+	} else if ab.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(ab, true, authboss.RequireFullAuth, unauthedResponse)
+
+	a.Config.Core.Router.Get("/apikeys", middleware(a.Core.ErrorHandler.Wrap(a.Get)))
+	a.Config.Core.Router.Post("/apikeys", middleware(a.Core.ErrorHandler.Wrap(a.CreatePost)))
+	a.Config.Core.Router.Post("/apikeys/revoke", middleware(a.Core.ErrorHandler.Wrap(a.RevokePost)))
+
+	return nil
+}
+
+// Get lists the current user's keys
+func (a *APIKey) Get(w http.ResponseWriter, r *http.Request) error {
+	user, err := a.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	keys, err := a.keys(r.Context(), authboss.UserPID(user))
+	if err != nil {
+		return err
+	}
+
+	return a.Core.Responder.Respond(w, r, http.StatusOK, PageAPIKeys, authboss.HTMLData{DataAPIKeys: keys})
+}
+
+// CreatePost creates a key for the current user and responds with it
+func (a *APIKey) CreatePost(w http.ResponseWriter, r *http.Request) error {
+	logger := a.RequestLogger(r)
+
+	user, err := a.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	validatable, err := a.Core.BodyReader.Read(PageAPIKeyCreate, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("api key validation failed: %+v", errs)
+		data := authboss.HTMLData{authboss.DataValidation: a.LocalizeErrors(r.Context(), errs)}
+		return a.Core.Responder.Respond(w, r, http.StatusBadRequest, PageAPIKeyCreate, data)
+	}
+
+	pid := authboss.UserPID(user)
+	token, key, err := a.Create(r.Context(), pid, authboss.MustHaveAPIKeyValues(validatable).GetName())
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("user %s created api key %s", pid, key.ID)
+	keys, err := a.keys(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{DataAPIKey: token, DataAPIKeys: keys}
+	return a.Core.Responder.Respond(w, r, http.StatusOK, PageAPIKeyCreate, data)
+}
+
+// RevokePost revokes one of the current user's keys
+func (a *APIKey) RevokePost(w http.ResponseWriter, r *http.Request) error {
+	logger := a.RequestLogger(r)
+
+	user, err := a.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	validatable, err := a.Core.BodyReader.Read(PageAPIKeyRevoke, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("api key revoke validation failed: %+v", errs)
+		data := authboss.HTMLData{authboss.DataValidation: a.LocalizeErrors(r.Context(), errs)}
+		return a.Core.Responder.Respond(w, r, http.StatusBadRequest, PageAPIKeyRevoke, data)
+	}
+
+	pid := authboss.UserPID(user)
+	id := authboss.MustHaveConfirmValues(validatable).GetToken()
+	storer := authboss.EnsureCanStoreAPIKeys(a.Config.Storage.Server)
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(a.Config.Paths.Mount, "/apikeys"),
+	}
+
+	err = storer.DelAPIKey(r.Context(), pid, id)
+	switch {
+	case err == authboss.ErrTokenNotFound:
+		logger.Infof("user %s tried to revoke an api key they don't have: %s", pid, id)
+		ro.Code = http.StatusNotFound
+		ro.Failure = a.Localize(r.Context(), authboss.TxtAPIKeyNotFound)
+		ro.FailureCode = authboss.ErrorCodeFailed
+	case err != nil:
+		return err
+	default:
+		logger.Infof("user %s revoked api key %s", pid, id)
+		ro.Success = a.Localize(r.Context(), authboss.TxtAPIKeyRevoked)
+	}
+
+	return a.Core.Redirector.Redirect(w, r, ro)
+}
+
+// Create a key for the user, token is the key itself which isn't stored
+// anywhere and must be given to the user.
+func (a *APIKey) Create(ctx context.Context, pid, name string) (token string, key authboss.APIKey, err error) {
+	secret, err := randomString(nKeySize)
+	if err != nil {
+		return "", key, errors.Wrap(err, "failed to create api key")
+	}
+	id, err := randomString(nIDSize)
+	if err != nil {
+		return "", key, errors.Wrap(err, "failed to create api key id")
+	}
+
+	token = a.Config.Modules.APIKeyPrefix + secret
+	now := a.Now().UTC()
+	key = authboss.APIKey{
+		ID:      id,
+		PID:     pid,
+		Name:    name,
+		Hint:    token[:len(a.Config.Modules.APIKeyPrefix)+nHintSize],
+		Hash:    hashKey(ctx, token),
+		Created: now,
+	}
+	if lifetime := a.Config.Modules.APIKeyLifetime; lifetime != 0 {
+		key.Expires = now.Add(lifetime)
+	}
+
+	if err = authboss.EnsureCanStoreAPIKeys(a.Config.Storage.Server).AddAPIKey(ctx, key); err != nil {
+		return "", key, err
+	}
+
+	return token, key, nil
+}
+
+func (a *APIKey) keys(ctx context.Context, pid string) ([]Key, error) {
+	stored, err := authboss.EnsureCanStoreAPIKeys(a.Config.Storage.Server).LoadAPIKeys(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, len(stored))
+	for i, s := range stored {
+		keys[i] = Key{ID: s.ID, Name: s.Name, Hint: s.Hint, Created: s.Created}
+		if !s.Expires.IsZero() {
+			expires := s.Expires
+			keys[i].Expires = &expires
+		}
+	}
+
+	return keys, nil
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashKey is what the key is stored and looked up by, it's scoped to the
+// context's tenant so a key only works for the tenant it was created for.
+func hashKey(ctx context.Context, token string) string {
+	sum := sha512.Sum512(authboss.TenantToken(ctx, []byte(token)))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
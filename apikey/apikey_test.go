@@ -0,0 +1,297 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	if err := (&APIKey{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageAPIKeys, PageAPIKeyCreate, PageAPIKeyRevoke); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/apikeys"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/apikeys", "/apikeys/revoke"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	apikey *APIKey
+	ab     *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	redirector *mocks.Redirector
+	responder  *mocks.Responder
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.redirector = &mocks.Redirector{}
+	harness.responder = &mocks.Responder{}
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Modules.APIKeyPrefix = "abk_"
+	harness.ab.Config.Paths.Mount = "/auth"
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.apikey = &APIKey{harness.ab}
+
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	return harness
+}
+
+func (h *testHarness) request(method string) *http.Request {
+	r := mocks.Request(method)
+	return r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, h.storer.Users["test@test.com"]))
+}
+
+func TestCreatePost(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Name: "deploys"}
+
+	if err := h.apikey.CreatePost(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusOK || h.responder.Page != PageAPIKeyCreate {
+		t.Fatalf("response was wrong: %d %s", h.responder.Status, h.responder.Page)
+	}
+
+	token := h.responder.Data[DataAPIKey].(string)
+	if !strings.HasPrefix(token, "abk_") {
+		t.Error("the key should have the prefix:", token)
+	}
+	if len(h.storer.APIKeys) != 1 {
+		t.Fatal("the key should have been stored")
+	}
+	if _, ok := h.storer.APIKeys[token]; ok {
+		t.Error("the key must not be stored in plain text")
+	}
+
+	keys := h.responder.Data[DataAPIKeys].([]Key)
+	if len(keys) != 1 || keys[0].Name != "deploys" || !strings.HasPrefix(token, keys[0].Hint) {
+		t.Errorf("keys were wrong: %#v", keys)
+	}
+	if keys[0].Expires != nil {
+		t.Error("keys should not expire without a lifetime")
+	}
+}
+
+func TestCreatePostValidation(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Errors: []error{errors.New("name is blank")}}
+
+	if err := h.apikey.CreatePost(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusBadRequest {
+		t.Error("status was wrong:", h.responder.Status)
+	}
+	if len(h.storer.APIKeys) != 0 {
+		t.Error("no key should have been stored")
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.APIKeyLifetime = time.Hour
+	if _, _, err := h.apikey.Create(context.Background(), "test@test.com", "ci"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.apikey.Create(context.Background(), "other@test.com", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.apikey.Get(httptest.NewRecorder(), h.request("GET")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := h.responder.Data[DataAPIKeys].([]Key)
+	if len(keys) != 1 || keys[0].Name != "ci" {
+		t.Fatalf("only the user's keys should be listed: %#v", keys)
+	}
+	if keys[0].Expires == nil {
+		t.Error("the key should expire")
+	}
+}
+
+func TestRevokePost(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	_, key, err := h.apikey.Create(context.Background(), "test@test.com", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: key.ID}
+	if err := h.apikey.RevokePost(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.storer.APIKeys) != 0 {
+		t.Error("the key should have been revoked")
+	}
+	if opts := h.redirector.Options; opts.RedirectPath != "/auth/apikeys" || len(opts.Success) == 0 {
+		t.Errorf("redirect was wrong: %#v", opts)
+	}
+
+	if err := h.apikey.RevokePost(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if opts := h.redirector.Options; opts.Code != http.StatusNotFound || len(opts.Failure) == 0 {
+		t.Errorf("revoking a missing key should fail: %#v", opts)
+	}
+}
+
+func TestRevokePostOtherUser(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	_, key, err := h.apikey.Create(context.Background(), "other@test.com", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: key.ID}
+	if err := h.apikey.RevokePost(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.storer.APIKeys) != 1 {
+		t.Error("users must not revoke each other's keys")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	token, _, err := h.apikey.Create(context.Background(), "test@test.com", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(header string) (*httptest.ResponseRecorder, authboss.User) {
+		var user authboss.User
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(header) != 0 {
+			r.Header.Set("Authorization", header)
+		}
+		Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ = r.Context().Value(authboss.CTXKeyUser).(authboss.User)
+		})).ServeHTTP(rec, r)
+		return rec, user
+	}
+
+	if rec, user := serve("Bearer " + token); rec.Code != http.StatusOK || user == nil || user.GetPID() != "test@test.com" {
+		t.Error("the key's user should have been loaded:", rec.Code, user)
+	}
+	if rec, user := serve(""); rec.Code != http.StatusOK || user != nil {
+		t.Error("requests without a key should pass through:", rec.Code, user)
+	}
+	if rec, user := serve("Bearer some.jwt.token"); rec.Code != http.StatusOK || user != nil {
+		t.Error("other bearer tokens should pass through:", rec.Code, user)
+	}
+	if rec, _ := serve("Bearer abk_wrong"); rec.Code != http.StatusUnauthorized {
+		t.Error("unknown keys should be rejected:", rec.Code)
+	} else if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("it should say why it was rejected")
+	}
+}
+
+func TestMiddlewareLockedOrSuspended(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	token, _, err := h.apikey.Create(context.Background(), "test@test.com", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := h.storer.Users["test@test.com"]
+	for name, bar := range map[string]func(){
+		"locked":    func() { user.Locked = time.Now().Add(time.Hour) },
+		"suspended": func() { user.Locked, user.Suspended = time.Time{}, true },
+	} {
+		bar()
+
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s: the key should not get through", name)
+		})).ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status was wrong: %d", name, rec.Code)
+		}
+	}
+}
+
+func TestMiddlewareExpired(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	clock := authtest.NewClock(time.Now().UTC())
+	h.ab.Config.Core.Clock = clock
+	h.ab.Config.Modules.APIKeyLifetime = time.Hour
+	token, _, err := h.apikey.Create(context.Background(), "test@test.com", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expired keys should not get through")
+	})).ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Error("status was wrong:", rec.Code)
+	}
+}
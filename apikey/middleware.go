@@ -0,0 +1,89 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Middleware authenticates requests that send an api key in an
+// "Authorization: Bearer" header. When the key is valid its user is loaded
+// into the request context the same way a session would (so
+// authboss.Middleware2 and CurrentUser work as usual).
+//
+// Requests without a bearer token, or with one that doesn't start with
+// Modules.APIKeyPrefix, are passed through untouched so this can be used
+// alongside token.Middleware. Keys that are unknown, revoked or expired,
+// or whose user is locked or suspended, are rejected with a 401. It must
+// come after LoadClientStateMiddleware when tenants are used.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok || !strings.HasPrefix(token, ab.Config.Modules.APIKeyPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+
+			storer := authboss.EnsureCanStoreAPIKeys(ab.Config.Storage.Server)
+			key, err := storer.LoadAPIKey(r.Context(), hashKey(r.Context(), token))
+			if err == authboss.ErrTokenNotFound {
+				logger.Infof("rejected api key: not found")
+				unauthorized(w)
+				return
+			} else if err != nil {
+				logger.Errorf("failed to load api key: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !key.Expires.IsZero() && !ab.Now().Before(key.Expires) {
+				logger.Infof("rejected expired api key %s", key.ID)
+				unauthorized(w)
+				return
+			}
+
+			user, err := ab.LoadUser(r.Context(), key.PID)
+			if err == authboss.ErrUserNotFound {
+				logger.Infof("api key user not found: %s", key.PID)
+				unauthorized(w)
+				return
+			} else if err != nil {
+				logger.Errorf("failed to load api key user: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if authboss.IsLockedOrSuspended(user, ab.Now()) {
+				logger.Infof("rejected api key of user %s: locked or suspended", key.PID)
+				unauthorized(w)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authboss.CTXKeyPID, key.PID)
+			ctx = context.WithValue(ctx, authboss.CTXKeyUser, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(prefix):])
+	return token, len(token) != 0
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+}
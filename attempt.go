@@ -0,0 +1,78 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Stages of a login attempt, see AttemptInfo
+const (
+	// AttemptStagePassword is logging in with the first factor: a
+	// password, a one time password or an oauth2 or saml provider
+	AttemptStagePassword = "password"
+	// AttemptStageTwoFactor is validating the second factor of a user
+	// whose first factor was accepted
+	AttemptStageTwoFactor = "2fa"
+)
+
+// AttemptInfo describes a login attempt, the hooks of the login events
+// (EventAuthAttempt, EventAuth, EventAuthFail, EventOAuth2,
+// EventOAuth2Fail) get it with Authboss.Attempt.
+type AttemptInfo struct {
+	// PID is what the user logged in with, it may be another of their
+	// credentials (see Modules.CredentialFields) and for failed attempts
+	// it may not be anyone's.
+	PID string
+	// IP is the client's address, see Authboss.ClientIP
+	IP        string
+	UserAgent string
+	// Module is the module the attempt was made with, like auth, otp,
+	// oauth2 or totp2fa
+	Module string
+	// Stage is AttemptStagePassword or AttemptStageTwoFactor
+	Stage string
+	// Failure is why the attempt failed, it's empty unless it has
+	Failure ErrorCode
+	Time    time.Time
+}
+
+// WithAttempt puts the login attempt in the request's context, the modules
+// do so before they fire the login events. The IP, UserAgent and Time are
+// filled in from the request when they're empty.
+func (a *Authboss) WithAttempt(r *http.Request, attempt AttemptInfo) *http.Request {
+	if len(attempt.IP) == 0 {
+		attempt.IP = a.ClientIP(r)
+	}
+	if len(attempt.UserAgent) == 0 {
+		attempt.UserAgent = r.UserAgent()
+	}
+	if attempt.Time.IsZero() {
+		attempt.Time = a.Now().UTC()
+	}
+	return r.WithContext(context.WithValue(r.Context(), CTXKeyAttempt, attempt))
+}
+
+// Attempt is the login attempt the request's events are fired for. When a
+// module didn't put one in the context (see WithAttempt) it's made from
+// the request: the pid of the values (CTXKeyValues) or the user
+// (CTXKeyUser) and the module of the handler (CTXKeyModule).
+func (a *Authboss) Attempt(r *http.Request) AttemptInfo {
+	if attempt, ok := r.Context().Value(CTXKeyAttempt).(AttemptInfo); ok {
+		return attempt
+	}
+
+	attempt := AttemptInfo{
+		IP:        a.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		Stage:     AttemptStagePassword,
+		Time:      a.Now().UTC(),
+	}
+	attempt.Module, _ = r.Context().Value(CTXKeyModule).(string)
+	if values, ok := r.Context().Value(CTXKeyValues).(UserValuer); ok {
+		attempt.PID = values.GetPID()
+	} else if user, ok := r.Context().Value(CTXKeyUser).(User); ok {
+		attempt.PID = user.GetPID()
+	}
+	return attempt
+}
@@ -0,0 +1,42 @@
+package authboss
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+type testUserValues struct{ pid string }
+
+func (t testUserValues) Validate() []error   { return nil }
+func (t testUserValues) GetPID() string      { return t.pid }
+func (t testUserValues) GetPassword() string { return "" }
+
+func TestAttempt(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "10.0.0.1:4321"
+	r.Header.Set("User-Agent", "test-agent")
+
+	derived := r.WithContext(context.WithValue(r.Context(), CTXKeyModule, "auth"))
+	derived = derived.WithContext(context.WithValue(derived.Context(), CTXKeyValues, testUserValues{pid: "test@test.com"}))
+	attempt := ab.Attempt(derived)
+	if attempt.PID != "test@test.com" || attempt.Module != "auth" || attempt.Stage != AttemptStagePassword {
+		t.Error("attempt was wrong:", attempt)
+	}
+	if attempt.IP != "10.0.0.1" || attempt.UserAgent != "test-agent" || attempt.Time.IsZero() {
+		t.Error("the request's details are missing:", attempt)
+	}
+
+	r = ab.WithAttempt(r, AttemptInfo{PID: "other", Module: "totp2fa", Stage: AttemptStageTwoFactor, Failure: ErrorCodeValidation})
+	attempt = ab.Attempt(r)
+	if attempt.PID != "other" || attempt.Stage != AttemptStageTwoFactor || attempt.Failure != ErrorCodeValidation {
+		t.Error("attempt was wrong:", attempt)
+	}
+	if attempt.IP != "10.0.0.1" || attempt.UserAgent != "test-agent" || attempt.Time.IsZero() {
+		t.Error("the request's details should be filled in:", attempt)
+	}
+}
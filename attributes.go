@@ -0,0 +1,58 @@
+package authboss
+
+// Attributes are the names authboss gives to the data it stores on users,
+// storers that keep users as key-value data (database rows, documents,
+// hashes) can use them as the keys. They're stable across versions,
+// see AttributeMap to store them under different names.
+const (
+	AttributePID      = "pid"
+	AttributeEmail    = "email"
+	AttributeUsername = "username"
+	AttributePassword = "password"
+
+	AttributeAccountType = "account_type"
+	AttributeLastRename  = "last_rename"
+
+	AttributeConfirmed       = "confirmed"
+	AttributeConfirmSelector = "confirm_selector"
+	AttributeConfirmVerifier = "confirm_verifier"
+	AttributeConfirmExpiry   = "confirm_expiry"
+
+	AttributeAttemptCount = "attempt_count"
+	AttributeLastAttempt  = "last_attempt"
+	AttributeLocked       = "locked"
+
+	AttributeRecoverSelector = "recover_selector"
+	AttributeRecoverVerifier = "recover_verifier"
+	AttributeRecoverExpiry   = "recover_expiry"
+
+	AttributeOAuth2UID          = "oauth2_uid"
+	AttributeOAuth2Provider     = "oauth2_provider"
+	AttributeOAuth2AccessToken  = "oauth2_access_token"
+	AttributeOAuth2RefreshToken = "oauth2_refresh_token"
+	AttributeOAuth2Expiry       = "oauth2_expiry"
+
+	AttributeTOTPSecretKey  = "totp_secret_key"
+	AttributeSMSPhoneNumber = "sms_phone_number"
+	AttributeRecoveryCodes  = "recovery_codes"
+)
+
+// AttributeMap maps authboss' attribute names to the names the storer uses
+// for them, like column names that follow the schema's conventions
+// (camelCase, prefixes). Attributes that aren't in the map keep their
+// own name. See Config.Storage.Attributes.
+type AttributeMap map[string]string
+
+// Name is the storer's name for the attribute
+func (a AttributeMap) Name(attribute string) string {
+	if name, ok := a[attribute]; ok {
+		return name
+	}
+	return attribute
+}
+
+// Attribute is the storer's name for one of authboss' attributes, see
+// Config.Storage.Attributes.
+func (a *Authboss) Attribute(attribute string) string {
+	return a.Config.Storage.Attributes.Name(attribute)
+}
@@ -0,0 +1,17 @@
+package authboss
+
+import "testing"
+
+func TestAttributeMapName(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	if name := ab.Attribute(AttributePID); name != AttributePID {
+		t.Error("unmapped attributes should keep their name:", name)
+	}
+
+	ab.Config.Storage.Attributes = AttributeMap{AttributePID: "userId"}
+	if name := ab.Attribute(AttributePID); name != "userId" {
+		t.Error("name was not mapped:", name)
+	}
+}
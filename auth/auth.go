@@ -4,8 +4,7 @@ package auth
 import (
 	"context"
 	"net/http"
-
-	"golang.org/x/crypto/bcrypt"
+	"time"
 
 	"github.com/volatiletech/authboss/v3"
 )
@@ -24,6 +23,12 @@ type Auth struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what logging in needs
+func (a *Auth) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Storage.Server", "Storage.SessionState")
+}
+
 // Init module
 func (a *Auth) Init(ab *authboss.Authboss) (err error) {
 	a.Authboss = ab
@@ -32,19 +37,35 @@ func (a *Auth) Init(ab *authboss.Authboss) (err error) {
 		return err
 	}
 
-	a.Authboss.Config.Core.Router.Get("/login", a.Authboss.Core.ErrorHandler.Wrap(a.LoginGet))
-	a.Authboss.Config.Core.Router.Post("/login", a.Authboss.Core.ErrorHandler.Wrap(a.LoginPost))
+	a.Authboss.Config.Core.Router.Get("/login", a.IPPolicyGuard("/login", a.Authboss.Core.ErrorHandler.Wrap(a.LoginGet)))
+	a.Authboss.Config.Core.Router.Post("/login", a.IPPolicyGuard("/login", a.Authboss.Core.ErrorHandler.Wrap(a.LoginPost)))
 
 	return nil
 }
 
+// APIOperations documents the login route for authboss.OpenAPI
+func (a *Auth) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{{
+		Method:  http.MethodPost,
+		Path:    "/login",
+		Summary: "Log in with a password",
+		Fields: []authboss.APIField{
+			{Name: authboss.APIFieldPID, Required: true},
+			{Name: "password", Required: true, Format: "password"},
+			{Name: authboss.CookieRemember, Type: "boolean"},
+		},
+		Redirects:  true,
+		ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation, authboss.ErrorCodeInvalidCredentials, authboss.ErrorCodeChallengeRequired},
+	}}
+}
+
 // LoginGet simply displays the login form
 func (a *Auth) LoginGet(w http.ResponseWriter, r *http.Request) error {
 	data := authboss.HTMLData{}
 	if redir := r.URL.Query().Get(authboss.FormValueRedirect); len(redir) != 0 {
 		data[authboss.FormValueRedirect] = redir
 	}
-	return a.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+	return a.respond(w, r, data)
 }
 
 // LoginPost attempts to validate the credentials passed in
@@ -61,24 +82,59 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	// password check.
 	creds := authboss.MustHaveUserValues(validatable)
 
+	// The values are in the context for the challenge too, the lock module
+	// requires one for accounts that failed to log in too many times
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
+	attempt := authboss.AttemptInfo{PID: creds.GetPID(), Module: "auth", Stage: authboss.AttemptStagePassword}
+	r = a.WithAttempt(r, attempt)
+
+	// Check the challenge before the password so that guessing passwords
+	// costs the client the work of solving it every time.
+	if ok, err := a.Authboss.VerifyChallenge(r, PageLogin, validatable); err != nil {
+		return err
+	} else if !ok {
+		logger.Infof("login challenge failed for pid: %s", creds.GetPID())
+		return a.respond(w, r, authboss.HTMLData{
+			authboss.DataErr:     a.Localize(r.Context(), authboss.TxtCompleteChallenge),
+			authboss.DataErrCode: authboss.ErrorCodeChallengeRequired,
+		})
+	}
+
+	handled, err := a.Events.FireBefore(authboss.EventAuthAttempt, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
 	pid := creds.GetPID()
-	pidUser, err := a.Authboss.Storage.Server.Load(r.Context(), pid)
+	start := time.Now()
+	pidUser, err := a.Authboss.LoadByCredential(r.Context(), pid)
 	if err == authboss.ErrUserNotFound {
 		logger.Infof("failed to load user requested by pid: %s", pid)
-		data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
-		return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+		a.Authboss.CountLogin("auth", false)
+		if a.Config.Modules.PreventUserEnumeration {
+			a.Authboss.DummyVerifyPassword(creds.GetPassword())
+		}
+		return a.respond(w, r, authboss.HTMLData{
+			authboss.DataErr:     a.Localize(r.Context(), authboss.TxtInvalidCredentials),
+			authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials,
+		})
 	} else if err != nil {
 		return err
 	}
 
-	authUser := authboss.MustBeAuthable(pidUser)
-	password := authUser.GetPassword()
+	// The user may have logged in with another of their credentials
+	pid = pidUser.GetPID()
 
 	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, pidUser))
 
-	var handled bool
-	err = bcrypt.CompareHashAndPassword([]byte(password), []byte(creds.GetPassword()))
-	if err != nil {
+	err = a.Authboss.CheckPassword(r.Context(), pidUser, creds.GetPassword())
+	a.Authboss.ObserveMetric(authboss.HistogramLoginDuration, time.Since(start).Seconds(), authboss.Labels{authboss.LabelModule: "auth"})
+	if err == authboss.ErrInvalidCredentials {
+		a.Authboss.CountLogin("auth", false)
+		attempt.Failure = authboss.ErrorCodeInvalidCredentials
+		r = a.WithAttempt(r, attempt)
 		handled, err = a.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
 		if err != nil {
 			return err
@@ -87,11 +143,18 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		logger.Infof("user %s failed to log in", pid)
-		data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
-		return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+		return a.respond(w, r, authboss.HTMLData{
+			authboss.DataErr:     a.Localize(r.Context(), authboss.TxtInvalidCredentials),
+			authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials,
+		})
+	} else if err != nil {
+		return err
 	}
 
-	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
+	// Kept in the session so that the user is still sent back there after
+	// the steps other modules may put in between, like confirming their
+	// account or a second factor
+	a.Authboss.KeepReturnTo(w, r.FormValue(authboss.FormValueRedirect))
 
 	handled, err = a.Events.FireBefore(authboss.EventAuth, w, r)
 	if err != nil {
@@ -110,6 +173,7 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	logger.Infof("user %s logged in", pid)
 	authboss.PutSession(w, authboss.SessionKey, pid)
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	a.Authboss.CountLogin("auth", true)
 
 	handled, err = a.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
 	if err != nil {
@@ -120,8 +184,17 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 
 	ro := authboss.RedirectOptions{
 		Code:             http.StatusTemporaryRedirect,
-		RedirectPath:     a.Authboss.Paths.AuthLoginOK,
+		RedirectPath:     a.Authboss.ReturnTo(w, r, a.Authboss.RedirectPath(r, "auth", authboss.RedirectOK, pidUser, a.Authboss.AccountTypePath(pidUser, a.Authboss.RequestConfig(r.Context()).Paths.AuthLoginOK))),
 		FollowRedirParam: true,
 	}
 	return a.Authboss.Core.Redirector.Redirect(w, r, ro)
 }
+
+// respond with the login page, adding a new challenge if one is required
+func (a *Auth) respond(w http.ResponseWriter, r *http.Request, data authboss.HTMLData) error {
+	if err := a.Authboss.AddChallenge(r, PageLogin, data); err != nil {
+		return err
+	}
+
+	return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+}
@@ -1,12 +1,15 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/volatiletech/authboss/v3"
 	"github.com/volatiletech/authboss/v3/mocks"
+	"github.com/volatiletech/authboss/v3/pow"
 )
 
 func TestAuthInit(t *testing.T) {
@@ -238,6 +241,50 @@ func TestAuthPostSuccess(t *testing.T) {
 			t.Error("should have left the response alone once teapot was sent")
 		}
 	})
+
+	t.Run("returnTo", func(t *testing.T) {
+		t.Parallel()
+		h := setupMore(testSetup())
+
+		r := mocks.Request("POST")
+		r.URL.RawQuery = "redir=/account"
+		resp := httptest.NewRecorder()
+		w := h.ab.NewResponse(resp)
+
+		if err := h.auth.LoginPost(w, r); err != nil {
+			t.Error(err)
+		}
+
+		if h.redirector.Options.RedirectPath != "/account" {
+			t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+		}
+		if _, ok := h.session.ClientValues[authboss.SessionReturnTo]; ok {
+			t.Error("the return to path should not be left in the session")
+		}
+	})
+
+	t.Run("returnToHijacked", func(t *testing.T) {
+		t.Parallel()
+		h := setupMore(testSetup())
+
+		h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return true, nil
+		})
+
+		r := mocks.Request("POST")
+		r.URL.RawQuery = "redir=/account"
+		resp := httptest.NewRecorder()
+		w := h.ab.NewResponse(resp)
+
+		if err := h.auth.LoginPost(w, r); err != nil {
+			t.Error(err)
+		}
+
+		if returnTo := h.session.ClientValues[authboss.SessionReturnTo]; returnTo != "/account" {
+			t.Error("the return to path should be kept for after the second factor:", returnTo)
+		}
+	})
 }
 
 func TestAuthPostBadPassword(t *testing.T) {
@@ -264,9 +311,14 @@ func TestAuthPostBadPassword(t *testing.T) {
 		resp := httptest.NewRecorder()
 		w := h.ab.NewResponse(resp)
 
+		metrics := mocks.NewMetrics()
+		h.ab.Config.Core.Metrics = metrics
+
 		var afterCalled bool
+		var attempt authboss.AttemptInfo
 		h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
 			afterCalled = true
+			attempt = h.ab.Attempt(r)
 			return false, nil
 		})
 
@@ -274,13 +326,30 @@ func TestAuthPostBadPassword(t *testing.T) {
 			t.Error(err)
 		}
 
+		if attempt.PID != "test@test.com" || attempt.Module != "auth" || attempt.Stage != authboss.AttemptStagePassword {
+			t.Error("attempt was wrong:", attempt)
+		}
+		if attempt.Failure != authboss.ErrorCodeInvalidCredentials {
+			t.Error("failure was wrong:", attempt.Failure)
+		}
+
 		if resp.Code != 200 {
 			t.Error("wanted a 200:", resp.Code)
 		}
 
+		if metrics.Counts[authboss.CounterLogins+":"+authboss.ResultFailure] != 1 {
+			t.Error("failed login was not counted:", metrics.Counts)
+		}
+		if len(metrics.Observations[authboss.HistogramLoginDuration]) != 1 {
+			t.Error("login duration was not observed:", metrics.Observations)
+		}
+
 		if h.responder.Data[authboss.DataErr] != "Invalid Credentials" {
 			t.Error("wrong error:", h.responder.Data)
 		}
+		if h.responder.Data[authboss.DataErrCode] != authboss.ErrorCodeInvalidCredentials {
+			t.Error("wrong error code:", h.responder.Data)
+		}
 
 		if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
 			t.Error("user should not be logged in")
@@ -367,3 +436,126 @@ func TestAuthPostUserNotFound(t *testing.T) {
 		t.Error("after should not have been called")
 	}
 }
+
+func TestAuthPostCredentialFields(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.CredentialFields = []string{"email", "username"}
+	harness.ab.Config.Modules.PIDNormalizer = authboss.LowerTrimPID
+
+	harness.bodyReader.Return = mocks.Values{
+		PID:      " Test ",
+		Password: "hello world",
+	}
+	harness.storer.Users["test@test.com"] = &mocks.User{
+		Email:    "test@test.com",
+		Username: "test",
+		Password: "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji", // hello world
+	}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := harness.ab.NewResponse(resp)
+
+	if err := harness.auth.LoginPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if pid := harness.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("the user should be logged in with their pid:", pid)
+	}
+}
+
+type externalAuthenticator func(ctx context.Context, pid, password string) error
+
+func (e externalAuthenticator) Authenticate(ctx context.Context, pid, password string) error {
+	return e(ctx, pid, password)
+}
+
+func TestAuthPostExternalAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Core.ExternalAuthenticator = externalAuthenticator(func(ctx context.Context, pid, password string) error {
+		switch {
+		case pid != "test@test.com":
+			t.Error("pid was wrong:", pid)
+		case password == "down":
+			return errors.New("directory is down")
+		case password != "directory password":
+			return authboss.ErrInvalidCredentials
+		}
+		return nil
+	})
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	login := func(password string) error {
+		harness.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: password}
+		w := harness.ab.NewResponse(httptest.NewRecorder())
+		err := harness.auth.LoginPost(w, mocks.Request("POST"))
+		w.WriteHeader(http.StatusOK)
+		return err
+	}
+
+	if err := login("wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if harness.responder.Data[authboss.DataErr] != "Invalid Credentials" {
+		t.Error("wrong error:", harness.responder.Data)
+	}
+
+	if err := login("down"); err == nil {
+		t.Error("errors checking the password should be returned")
+	}
+	if _, ok := harness.session.ClientValues[authboss.SessionKey]; ok {
+		t.Fatal("user should not be logged in")
+	}
+
+	if err := login("directory password"); err != nil {
+		t.Fatal(err)
+	}
+	if pid := harness.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("the user should be logged in:", pid)
+	}
+}
+
+func TestAuthPostChallenge(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	verifier := pow.NewVerifier([]byte("key"), 4)
+	h.ab.Config.Modules.ChallengeVerifier = verifier
+	h.storer.Users["test@test.com"] = &mocks.User{
+		Email:    "test@test.com",
+		Password: "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji", // hello world
+	}
+
+	if err := h.auth.LoginGet(nil, mocks.Request("GET")); err != nil {
+		t.Fatal(err)
+	}
+	challenge, ok := h.responder.Data[authboss.DataChallenge].(pow.Challenge)
+	if !ok {
+		t.Fatal("the login page should have a challenge:", h.responder.Data)
+	}
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world"}
+	if err := h.auth.LoginPost(h.ab.NewResponse(httptest.NewRecorder()), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if h.responder.Data[authboss.DataErr] != "Please complete the challenge" {
+		t.Error("the login should fail without a solution:", h.responder.Data)
+	}
+	if _, ok := h.responder.Data[authboss.DataChallenge]; !ok {
+		t.Error("a new challenge should be rendered")
+	}
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world", Challenge: pow.Solve(challenge)}
+	if err := h.auth.LoginPost(h.ab.NewResponse(httptest.NewRecorder()), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if h.redirector.Options.RedirectPath != "/login/ok" {
+		t.Error("the login should succeed with a solution:", h.responder.Data)
+	}
+}
@@ -12,6 +12,10 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/friendsofgo/errors"
 	"golang.org/x/crypto/bcrypt"
@@ -23,6 +27,17 @@ type Authboss struct {
 	Events *Events
 
 	loadedModules map[string]Moduler
+	background    sync.WaitGroup
+
+	// initModule is the module being initialized, see moduleRouter
+	initModule      string
+	modulesMut      sync.RWMutex
+	disabledModules map[string]bool
+	lockdown        bool
+	lockedDown      map[string]bool
+
+	dummyHash     []byte
+	dummyHashOnce sync.Once
 }
 
 // New makes a new instance of authboss with a default
@@ -43,12 +58,170 @@ func (a *Authboss) Init(modulesToLoad ...string) error {
 		modulesToLoad = RegisteredModules()
 	}
 
+	if a.Config.Core.NoRender {
+		if err := a.initNoRender(); err != nil {
+			return err
+		}
+	}
+
+	if err := a.validateConfig(modulesToLoad); err != nil {
+		return err
+	}
+	a.configureCookies()
+	a.configureClocks()
+
+	if _, ok := a.Config.Core.Router.(moduleRouter); !ok {
+		a.Config.Core.Router = moduleRouter{Router: a.Config.Core.Router, ab: a}
+	}
+	if a.Config.Storage.ReadOnly {
+		if _, ok := a.Config.Core.Router.(readOnlyRouter); !ok {
+			a.Config.Core.Router = readOnlyRouter{Router: a.Config.Core.Router, ab: a}
+		}
+	}
+	if !a.Config.Modules.CSRFDisabled {
+		if _, ok := a.Config.Core.Router.(csrfRouter); !ok {
+			a.Config.Core.Router = csrfRouter{Router: a.Config.Core.Router, ab: a}
+		}
+	}
+
+	errorHandler := a.Config.Core.ErrorHandler
+	defer func() {
+		a.Config.Core.ErrorHandler = errorHandler
+		a.initModule = ""
+		a.Events.setInitFilter(nil)
+	}()
+	if a.Config.Core.Tracer != nil {
+		a.Events.Use(a.traceEvents)
+	}
+
 	for _, name := range modulesToLoad {
+		a.wrapModuleHandlers(errorHandler, name)
 		if err := a.loadModule(name); err != nil {
 			return errors.Errorf("module %s failed to load: %+v", name, err)
 		}
 	}
 
+	for name, mod := range a.loadedModules {
+		if mounter, ok := mod.(ModuleMounter); ok {
+			a.wrapModuleHandlers(errorHandler, name)
+			if err := mounter.OnMount(a); err != nil {
+				return errors.Errorf("module %s failed to mount: %+v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// wrapModuleHandlers swaps in an ErrorHandler that puts the module in the
+// context (see CTXKeyModule) and traces the module's handlers while it's
+// initialized. The routes and event hooks it adds meanwhile are only used
+// when it's enabled, see ModuleEnabled.
+func (a *Authboss) wrapModuleHandlers(errorHandler ErrorHandler, name string) {
+	a.initModule = name
+	a.Events.setInitFilter(a.moduleEventFilter(name))
+	if errorHandler != nil {
+		a.Config.Core.ErrorHandler = moduleErrorHandler{ErrorHandler: errorHandler, ab: a, module: name}
+	}
+}
+
+// Shutdown authboss so that the application can stop cleanly. It waits for
+// the e-mails modules are sending in the background, then shuts down the
+// modules (see ModuleShutdowner) and finally the Mailer, storers and
+// Metrics that implement Shutdowner. If ctx is done before the e-mails
+// have been sent its error is returned, otherwise the first error from
+// a shutdown is returned after all of them have been attempted.
+func (a *Authboss) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to wait for background work")
+	}
+
+	var firstErr error
+	for name, mod := range a.loadedModules {
+		if shutdowner, ok := mod.(ModuleShutdowner); ok {
+			if err := shutdowner.OnShutdown(ctx); err != nil && firstErr == nil {
+				firstErr = errors.Wrapf(err, "module %s failed to shut down", name)
+			}
+		}
+	}
+
+	var shutdown []interface{}
+	components := []interface{}{
+		a.Config.Core.Mailer,
+		a.Config.Storage.Server,
+		a.Config.Storage.SessionState,
+		a.Config.Storage.CookieState,
+		a.Config.Core.Metrics,
+	}
+	for _, c := range components {
+		shutdowner, ok := c.(Shutdowner)
+		if !ok || containsComparable(shutdown, c) {
+			continue
+		}
+		shutdown = append(shutdown, c)
+
+		if err := shutdowner.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "failed to shut down %T", c)
+		}
+	}
+
+	return firstErr
+}
+
+// Background runs f in a goroutine that Shutdown waits for, modules use it
+// to send e-mails without holding up the response.
+func (a *Authboss) Background(f func()) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		f()
+	}()
+}
+
+// containsComparable checks if v is in list, the same value is often used
+// for both session and cookie state
+func containsComparable(list []interface{}, v interface{}) bool {
+	if !reflect.TypeOf(v).Comparable() {
+		return false
+	}
+
+	for _, l := range list {
+		if reflect.TypeOf(l) == reflect.TypeOf(v) && l == v {
+			return true
+		}
+	}
+	return false
+}
+
+// initNoRender checks the config is usable without templates and fills
+// in the renderers that weren't set.
+func (a *Authboss) initNoRender() error {
+	mailMethod := a.Config.Modules.ConfirmMethod
+	if mailMethod == http.MethodGet {
+		mailMethod = a.Config.Modules.MailRouteMethod
+	}
+	if mailMethod == http.MethodGet {
+		return errors.New("NoRender requires Modules.MailRouteMethod to be POST, e-mailed links must go to the front-end")
+	}
+	if a.Config.Modules.RoutesRedirectOnUnauthed || a.Config.Modules.ResponseOnUnauthed == RespondRedirect {
+		return errors.New("NoRender cannot redirect unauthed requests to the login page, set Modules.ResponseOnUnauthed")
+	}
+
+	if a.Config.Core.ViewRenderer == nil {
+		a.Config.Core.ViewRenderer = noRenderer{}
+	}
+	if a.Config.Core.MailRenderer == nil {
+		a.Config.Core.MailRenderer = noRenderer{mail: true}
+	}
+
 	return nil
 }
 
@@ -61,9 +234,8 @@ func (a *Authboss) Init(modulesToLoad ...string) error {
 //
 // Note that it's best practice after having called this method to also delete
 // all the user's logged in sessions. The CURRENT logged in session can be
-// deleted with `authboss.DelKnown(Session|Cookie)` but to delete ALL logged
-// in sessions for a user requires special mechanisms not currently provided
-// by authboss.
+// deleted with `authboss.DelKnown(Session|Cookie)`, RevokeSessions deletes
+// the others if the storer records them.
 func (a *Authboss) UpdatePassword(ctx context.Context, user AuthableUser, newPassword string) error {
 	pass, err := bcrypt.GenerateFromPassword([]byte(newPassword), a.Config.Modules.BCryptCost)
 	if err != nil {
@@ -72,12 +244,11 @@ func (a *Authboss) UpdatePassword(ctx context.Context, user AuthableUser, newPas
 
 	user.PutPassword(string(pass))
 
-	storer := a.Config.Storage.Server
-	if err := storer.Save(ctx, user); err != nil {
+	if err := a.SaveUser(ctx, user); err != nil {
 		return err
 	}
 
-	rmStorer, ok := storer.(RememberingServerStorer)
+	rmStorer, ok := a.Storer(ctx).(RememberingServerStorer)
 	if !ok {
 		return nil
 	}
@@ -85,6 +256,100 @@ func (a *Authboss) UpdatePassword(ctx context.Context, user AuthableUser, newPas
 	return rmStorer.DelRememberTokens(ctx, user.GetPID())
 }
 
+// RevokeSessions logs the user out everywhere by deleting all of their
+// remember me tokens, api tokens, api keys and session records, for
+// storers that support them, and their server side sessions when
+// Config.Storage.SessionState is a SessionRevoker. Session records are
+// only checked by the sessionlimit middleware, so with cookie sessions and
+// without it (or a SessionServerStorer) sessions last until they expire.
+func (a *Authboss) RevokeSessions(ctx context.Context, pid string) error {
+	storer := a.Storer(ctx)
+
+	if rmStorer, ok := storer.(RememberingServerStorer); ok {
+		if err := rmStorer.DelRememberTokens(ctx, pid); err != nil {
+			return errors.Wrap(err, "failed to delete remember tokens")
+		}
+	}
+
+	if tokenStorer, ok := storer.(TokenServerStorer); ok {
+		if err := tokenStorer.DelTokens(ctx, pid); err != nil {
+			return errors.Wrap(err, "failed to delete api tokens")
+		}
+	}
+
+	if keyStorer, ok := storer.(APIKeyServerStorer); ok {
+		keys, err := keyStorer.LoadAPIKeys(ctx, pid)
+		if err != nil {
+			return errors.Wrap(err, "failed to load api keys")
+		}
+		for _, key := range keys {
+			if err := keyStorer.DelAPIKey(ctx, pid, key.ID); err != nil && err != ErrTokenNotFound {
+				return errors.Wrap(err, "failed to delete api key")
+			}
+		}
+	}
+
+	if revoker, ok := a.Config.Storage.SessionState.(SessionRevoker); ok {
+		if err := revoker.RevokeSessions(ctx, pid); err != nil {
+			return errors.Wrap(err, "failed to revoke sessions")
+		}
+	}
+
+	sessionStorer, ok := storer.(SessionServerStorer)
+	if !ok {
+		return nil
+	}
+
+	sessions, err := sessionStorer.LoadSessionRecords(ctx, pid)
+	if err != nil {
+		return errors.Wrap(err, "failed to load session records")
+	}
+	for _, session := range sessions {
+		if err := sessionStorer.DelSessionRecord(ctx, pid, session.ID); err != nil {
+			return errors.Wrap(err, "failed to delete session record")
+		}
+	}
+
+	return nil
+}
+
+// FormValuePID is the form value RevokeSessionsHandler reads the pid from
+const FormValuePID = "pid"
+
+// RevokeSessionsHandler calls RevokeSessions for the pid form value of a
+// POST, for admin tools to log out a user whose account was compromised.
+// Requests that allow returns false for are responded to with a 404, it
+// must only allow operators. It responds with a 204 when the sessions were
+// revoked. It's not mounted on the authboss router, mount it where it suits.
+func (a *Authboss) RevokeSessionsHandler(allow func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allow == nil || !allow(r) {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		pid := r.FormValue(FormValuePID)
+		if len(pid) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := a.RevokeSessions(r.Context(), pid); err != nil {
+			a.RequestLogger(r).Errorf("failed to revoke sessions of %s: %+v", pid, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		a.RequestLogger(r).Infof("revoked all sessions of %s", pid)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
 // VerifyPassword uses authboss mechanisms to check that a password is correct.
 // Returns nil on success otherwise there will be an error. Simply a helper
 // to do the bcrypt comparison.
@@ -92,6 +357,87 @@ func VerifyPassword(user AuthableUser, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(user.GetPassword()), []byte(password))
 }
 
+// LowerTrimPID lowercases and trims whitespace from a pid, it can be
+// used as Config.Modules.PIDNormalizer.
+func LowerTrimPID(pid string) string {
+	return strings.ToLower(strings.TrimSpace(pid))
+}
+
+// NormalizePID applies Config.Modules.PIDNormalizer to a pid entered
+// by a user
+func (a *Authboss) NormalizePID(pid string) string {
+	if a.Config.Modules.PIDNormalizer == nil {
+		return pid
+	}
+	return a.Config.Modules.PIDNormalizer(pid)
+}
+
+// Clock tells the time, see Config.Core.Clock
+type Clock interface {
+	Now() time.Time
+}
+
+// Now is the current time according to Config.Core.Clock, or time.Now
+// when it isn't set
+func (a *Authboss) Now() time.Time {
+	if a.Config.Core.Clock == nil {
+		return time.Now()
+	}
+	return a.Config.Core.Clock.Now()
+}
+
+// ClockConfigurer is implemented by the parts of the Config that tell the
+// time themselves (like the defaults' client state read writers and the
+// pow ChallengeVerifier), Init sets their clock to Now.
+type ClockConfigurer interface {
+	ConfigureClock(now func() time.Time)
+}
+
+func (a *Authboss) configureClocks() {
+	for _, part := range []interface{}{
+		a.Config.Storage.SessionState,
+		a.Config.Storage.CookieState,
+		a.Config.Modules.ChallengeVerifier,
+	} {
+		if c, ok := part.(ClockConfigurer); ok {
+			c.ConfigureClock(a.Now)
+		}
+	}
+}
+
+// LoadByCredential loads the user a pid entered by a user refers to. It's
+// normalized with NormalizePID and, when Config.Modules.CredentialFields
+// is set, looked up with AnyCredentialServerStorer.LoadByAny so it can be
+// any of the user's credentials. The user's real pid is user.GetPID().
+func (a *Authboss) LoadByCredential(ctx context.Context, pid string) (User, error) {
+	pid = a.NormalizePID(pid)
+
+	if len(a.Config.Modules.CredentialFields) != 0 {
+		storer := EnsureCanLoadByAny(a.Storer(ctx))
+		ctx, span := a.StartSpan(ctx, "authboss.storer.LoadByAny", nil)
+		user, err := storer.LoadByAny(ctx, a.Config.Modules.CredentialFields, pid)
+		if err == ErrUserNotFound {
+			span.End(nil)
+		} else {
+			span.End(err)
+		}
+		return user, err
+	}
+
+	return a.LoadUser(ctx, pid)
+}
+
+// DummyVerifyPassword takes as long as VerifyPassword for a user's hash
+// would, it's used when there is no user so that the response isn't faster
+// and doesn't reveal that the user doesn't exist.
+func (a *Authboss) DummyVerifyPassword(password string) {
+	a.dummyHashOnce.Do(func() {
+		a.dummyHash, _ = bcrypt.GenerateFromPassword([]byte("authboss dummy password"), a.Config.Modules.BCryptCost)
+	})
+
+	_ = bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+}
+
 // MWRequirements are user requirements for authboss.Middleware
 // in order to access the routes in protects. Requirements is a bit-set integer
 // to be able to easily combine requirements like so:
@@ -196,7 +542,8 @@ func MountedMiddleware2(ab *Authboss, mountPathed bool, reqs MWRequirements, fai
 
 					ro := RedirectOptions{
 						Code:         http.StatusTemporaryRedirect,
-						Failure:      "please re-login",
+						Failure:      ab.Localize(ab.LocaleContext(r), TxtReLogin),
+						FailureCode:  ErrorCodeNotAuthorized,
 						RedirectPath: path.Join(ab.Config.Paths.Mount, fmt.Sprintf("/login?%s", vals.Encode())),
 					}
 
@@ -4,7 +4,12 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestAuthBossInit(t *testing.T) {
@@ -17,6 +22,131 @@ func TestAuthBossInit(t *testing.T) {
 	}
 }
 
+type fixedClock time.Time
+
+func (f fixedClock) Now() time.Time { return time.Time(f) }
+
+type clockStateRW struct {
+	mockClientStateReadWriter
+	now func() time.Time
+}
+
+func (c *clockStateRW) ConfigureClock(now func() time.Time) { c.now = now }
+
+func TestAuthBossInitClocks(t *testing.T) {
+	t.Parallel()
+
+	session := &clockStateRW{}
+	cookies := &clockStateRW{}
+
+	ab := New()
+	ab.Config.Storage.SessionState = session
+	ab.Config.Storage.CookieState = cookies
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.now == nil || cookies.now == nil {
+		t.Fatal("the clocks should be set")
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ab.Config.Core.Clock = fixedClock(now)
+	if got := session.now(); !got.Equal(now) {
+		t.Error("session clock was wrong:", got)
+	}
+	if got := cookies.now(); !got.Equal(now) {
+		t.Error("cookie clock was wrong:", got)
+	}
+}
+
+func TestAuthBossInitNoRender(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.NoRender = true
+
+	if err := ab.Init(); err == nil {
+		t.Error("NoRender should fail with GET mail routes")
+	}
+
+	ab.Config.Modules.MailRouteMethod = http.MethodPost
+	ab.Config.Modules.ResponseOnUnauthed = RespondRedirect
+	if err := ab.Init(); err == nil {
+		t.Error("NoRender should fail when redirecting unauthed requests")
+	}
+
+	ab.Config.Modules.ResponseOnUnauthed = RespondUnauthorized
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ab.Config.Core.ViewRenderer.Load("login"); err != nil {
+		t.Error("loading views should be a noop:", err)
+	}
+	if _, _, err := ab.Config.Core.ViewRenderer.Render(context.Background(), "login", nil); errors.Cause(err) != ErrNoRender {
+		t.Error("rendering should fail with ErrNoRender:", err)
+	}
+	if err := ab.Config.Core.MailRenderer.Load("confirm_html"); errors.Cause(err) != ErrNoRender {
+		t.Error("loading e-mails should fail without a MailRenderer:", err)
+	}
+}
+
+type lifecycleModule struct {
+	mounted, shutdown bool
+}
+
+func (l *lifecycleModule) Init(*Authboss) error                 { return nil }
+func (l *lifecycleModule) OnMount(*Authboss) error              { l.mounted = true; return nil }
+func (l *lifecycleModule) OnShutdown(ctx context.Context) error { l.shutdown = true; return nil }
+
+type shutdownMailer struct {
+	shutdowns int
+}
+
+func (s *shutdownMailer) Send(context.Context, Email) error { return nil }
+func (s *shutdownMailer) Shutdown(context.Context) error {
+	s.shutdowns++
+	return errors.New("mailer failed")
+}
+
+func TestAuthbossLifecycle(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	mod := &lifecycleModule{}
+	ab.loadedModules["lifecycle"] = mod
+	if err := ab.Init(testModName); err != nil {
+		t.Fatal(err)
+	}
+	if !mod.mounted {
+		t.Error("the module should have been mounted")
+	}
+
+	mailer := &shutdownMailer{}
+	ab.Config.Core.Mailer = mailer
+
+	block := make(chan struct{})
+	ab.Background(func() { <-block })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ab.Shutdown(ctx); errors.Cause(err) != context.Canceled {
+		t.Error("shutdown should wait for background work until ctx is done:", err)
+	}
+	if mod.shutdown {
+		t.Error("the module should not have been shut down yet")
+	}
+
+	close(block)
+	if err := ab.Shutdown(context.Background()); err == nil {
+		t.Error("the mailer's error should be returned")
+	}
+	if !mod.shutdown || mailer.shutdowns != 1 {
+		t.Error("the module and mailer should have been shut down:", mod.shutdown, mailer.shutdowns)
+	}
+}
+
 func TestAuthbossUpdatePassword(t *testing.T) {
 	t.Parallel()
 
@@ -35,6 +165,18 @@ func TestAuthbossUpdatePassword(t *testing.T) {
 	}
 }
 
+func TestAuthbossDummyVerifyPassword(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Modules.BCryptCost = bcrypt.MinCost
+
+	ab.DummyVerifyPassword("hello world")
+	if cost, err := bcrypt.Cost(ab.dummyHash); err != nil || cost != bcrypt.MinCost {
+		t.Error("the dummy hash should use the configured cost:", cost, err)
+	}
+}
+
 type testRedirector struct {
 	Opts RedirectOptions
 }
@@ -236,3 +378,128 @@ func TestAuthbossMiddleware(t *testing.T) {
 		}
 	})
 }
+
+type revokingClientStateRW struct {
+	mockClientStateReadWriter
+	revoked []string
+}
+
+func (r *revokingClientStateRW) RevokeSessions(ctx context.Context, pid string) error {
+	r.revoked = append(r.revoked, pid)
+	return nil
+}
+
+func TestAuthbossRevokeSessionsHandler(t *testing.T) {
+	t.Parallel()
+
+	storer := newMockServerStorer()
+	storer.Tokens["test@test.com"] = []string{"token"}
+	sessions := &revokingClientStateRW{mockClientStateReadWriter: newMockClientStateRW()}
+
+	ab := New()
+	ab.Config.Storage.Server = storer
+	ab.Config.Storage.SessionState = sessions
+	ab.Config.Core.Logger = mockLogger{}
+	handler := ab.RevokeSessionsHandler(func(r *http.Request) bool {
+		return r.Header.Get("X-Operator") == "yes"
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader("pid=test@test.com")))
+	if rec.Code != http.StatusNotFound {
+		t.Error("should not be allowed:", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader("pid=test@test.com"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Operator", "yes")
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNoContent {
+		t.Error("wrong code:", rec.Code)
+	}
+	if _, ok := storer.Tokens["test@test.com"]; ok {
+		t.Error("the remember tokens should have been deleted")
+	}
+	if len(sessions.revoked) != 1 || sessions.revoked[0] != "test@test.com" {
+		t.Error("the sessions should have been revoked:", sessions.revoked)
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/?pid=test@test.com", nil)
+	r.Header.Set("X-Operator", "yes")
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("wrong code:", rec.Code)
+	}
+}
+
+type credentialServerStorer struct {
+	*mockServerStorer
+	apiTokens map[string][]string
+	apiKeys   map[string][]string
+}
+
+func (c *credentialServerStorer) AddToken(ctx context.Context, token IssuedToken) error {
+	panic("not impl")
+}
+func (c *credentialServerStorer) LoadToken(ctx context.Context, hash string) (IssuedToken, error) {
+	panic("not impl")
+}
+func (c *credentialServerStorer) UseToken(ctx context.Context, hash string) (IssuedToken, error) {
+	panic("not impl")
+}
+func (c *credentialServerStorer) DelTokenFamily(ctx context.Context, pid, family string) error {
+	panic("not impl")
+}
+func (c *credentialServerStorer) DelTokens(ctx context.Context, pid string) error {
+	delete(c.apiTokens, pid)
+	return nil
+}
+func (c *credentialServerStorer) AddAPIKey(ctx context.Context, key APIKey) error { panic("not impl") }
+func (c *credentialServerStorer) LoadAPIKey(ctx context.Context, hash string) (APIKey, error) {
+	panic("not impl")
+}
+func (c *credentialServerStorer) LoadAPIKeys(ctx context.Context, pid string) ([]APIKey, error) {
+	var keys []APIKey
+	for _, id := range c.apiKeys[pid] {
+		keys = append(keys, APIKey{ID: id, PID: pid})
+	}
+	return keys, nil
+}
+func (c *credentialServerStorer) DelAPIKey(ctx context.Context, pid, id string) error {
+	var keep []string
+	for _, key := range c.apiKeys[pid] {
+		if key != id {
+			keep = append(keep, key)
+		}
+	}
+	c.apiKeys[pid] = keep
+	return nil
+}
+
+func TestAuthbossRevokeSessionsCredentials(t *testing.T) {
+	t.Parallel()
+
+	storer := &credentialServerStorer{
+		mockServerStorer: newMockServerStorer(),
+		apiTokens:        map[string][]string{"test@test.com": {"refresh"}, "other@test.com": {"refresh"}},
+		apiKeys:          map[string][]string{"test@test.com": {"key1", "key2"}},
+	}
+
+	ab := New()
+	ab.Config.Storage.Server = storer
+	if err := ab.RevokeSessions(context.Background(), "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := storer.apiTokens["test@test.com"]; ok {
+		t.Error("the api tokens should have been deleted")
+	}
+	if _, ok := storer.apiTokens["other@test.com"]; !ok {
+		t.Error("other users' api tokens should be kept")
+	}
+	if keys := storer.apiKeys["test@test.com"]; len(keys) != 0 {
+		t.Error("the api keys should have been deleted:", keys)
+	}
+}
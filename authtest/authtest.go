@@ -0,0 +1,103 @@
+// Package authtest helps applications test their authboss integration. It
+// has an in-memory storer that implements every storer interface, a mailer
+// and an sms sender that keep what they send, a fake clock and a client
+// that drives the register, confirm, login and two factor flows over http.
+//
+//	h := authtest.New()
+//	h.Apply(ab) // before ab.Init
+//
+//	c := authtest.NewClient(t, router, "/auth")
+//	c.Register("a@b.com", "password")
+//	c.Confirm(h.Mailer, "a@b.com")
+//	resp := c.Login("a@b.com", "password")
+//
+//	h.Clock.Advance(25 * time.Hour) // the recover token has expired
+package authtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Harness is the fakes an application's authboss is set up with
+type Harness struct {
+	Storer *Storer
+	Mailer *Mailer
+	SMS    *SMSSender
+	Clock  *Clock
+}
+
+// New creates a harness whose clock starts at the current time, to the
+// second
+func New() *Harness {
+	clock := NewClock(time.Now().UTC().Truncate(time.Second))
+	storer := NewStorer()
+	storer.Now = clock.Now
+
+	return &Harness{
+		Storer: storer,
+		Mailer: NewMailer(),
+		SMS:    &SMSSender{},
+		Clock:  clock,
+	}
+}
+
+// Apply sets the harness' fakes as ab's server storer, counter, mailer and
+// clock. It has to be called before ab.Init. The SMSSender is given to the
+// sms2fa module by the application (sms2fa.SMSSender).
+//
+// It also turns off authboss' CSRF protection since the Client doesn't
+// send CSRF tokens.
+func (h *Harness) Apply(ab *authboss.Authboss) {
+	ab.Config.Modules.CSRFDisabled = true
+	ab.Config.Storage.Server = h.Storer
+	ab.Config.Storage.Counter = h.Storer
+	ab.Config.Core.Mailer = h.Mailer
+	ab.Config.Core.Clock = h.Clock
+}
+
+// SMS is a text message sent by the SMSSender
+type SMS struct {
+	Number string
+	Text   string
+}
+
+// SMSSender is an sms2fa.SMSSender that keeps what it's sent
+type SMSSender struct {
+	mut  sync.Mutex
+	sent []SMS
+}
+
+// Send keeps the text message
+func (s *SMSSender) Send(ctx context.Context, number, text string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.sent = append(s.sent, SMS{Number: number, Text: text})
+	return nil
+}
+
+// Sent returns the text messages sent so far
+func (s *SMSSender) Sent() []SMS {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return append([]SMS(nil), s.sent...)
+}
+
+// Code is the last text message sent, which is the code sms2fa sends
+func (s *SMSSender) Code(t testing.TB) string {
+	t.Helper()
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if len(s.sent) == 0 {
+		t.Fatal("no sms was sent")
+	}
+	return s.sent[len(s.sent)-1].Text
+}
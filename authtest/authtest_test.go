@@ -0,0 +1,214 @@
+package authtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/volatiletech/authboss/v3"
+	_ "github.com/volatiletech/authboss/v3/auth"
+	_ "github.com/volatiletech/authboss/v3/confirm"
+	"github.com/volatiletech/authboss/v3/defaults"
+	_ "github.com/volatiletech/authboss/v3/logout"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+	_ "github.com/volatiletech/authboss/v3/register"
+)
+
+const totpSecret = "JBSWY3DPEHPK3PXP"
+
+func testApp(t *testing.T) (*Harness, *Client) {
+	t.Helper()
+
+	ab := authboss.New()
+	ab.Config.Paths.Mount = "/auth"
+	ab.Config.Paths.RootURL = "http://localhost"
+	ab.Config.Core.ViewRenderer = defaults.JSONRenderer{}
+	ab.Config.Core.MailRenderer = defaults.JSONRenderer{}
+	defaults.SetCore(&ab.Config, false, false)
+	ab.Config.Core.Logger = defaults.NewLogger(nilWriter{})
+	ab.Config.Modules.LogoutMethod = "POST"
+
+	ab.Config.Storage.SessionState = defaults.NewServerSessionReadWriter(defaults.NewMemorySessionStore())
+	ab.Config.Storage.CookieState = defaults.NewServerSessionReadWriter(defaults.NewMemorySessionStore())
+	ab.Config.Storage.SessionStateCookie.Secure = false
+	ab.Config.Storage.CookieStateCookie.Name = "ab_cookie"
+	ab.Config.Storage.CookieStateCookie.Secure = false
+
+	h := New()
+	h.Apply(ab)
+
+	if err := (&totp2fa.TOTP{Authboss: ab}).Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/", http.StripPrefix("/auth", ab.Config.Core.Router))
+	mux.HandleFunc("/protected", func(w http.ResponseWriter, r *http.Request) {
+		pid, err := ab.CurrentUserID(r)
+		if err != nil || len(pid) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(pid))
+	})
+
+	return h, NewClient(t, ab.LoadClientStateMiddleware(mux), "/auth")
+}
+
+type nilWriter struct{}
+
+func (nilWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func TestFlows(t *testing.T) {
+	t.Parallel()
+
+	h, c := testApp(t)
+	email, password := "test@test.com", "G00d-Password!"
+
+	resp := c.Register(email, password)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatal("registering should redirect:", resp.StatusCode, resp.Body)
+	}
+	if user, ok := h.Storer.User(email); !ok || user.Confirmed {
+		t.Fatal("the user should have been created unconfirmed")
+	}
+
+	c.Login(email, password)
+	if resp = c.Get("/protected"); resp.StatusCode != http.StatusUnauthorized {
+		t.Error("unconfirmed users shouldn't be logged in")
+	}
+
+	c.Confirm(h.Mailer, email)
+	if user, _ := h.Storer.User(email); !user.Confirmed {
+		t.Fatal("the user should have been confirmed")
+	}
+	c.Logout("POST")
+
+	h.Storer.Update(email, func(u *User) { u.TOTPSecretKey = totpSecret })
+	resp = c.Login(email, password)
+	if loc := resp.Location(); !strings.HasPrefix(loc, "/auth/2fa/totp/validate") {
+		t.Fatal("the login should ask for the second factor:", loc)
+	}
+	if resp = c.Get("/protected"); resp.StatusCode != http.StatusUnauthorized {
+		t.Error("the user shouldn't be logged in before the second factor")
+	}
+
+	c.ValidateTOTP(totpSecret, h.Clock.Now())
+	if resp = c.Get("/protected"); resp.StatusCode != http.StatusOK || resp.Body != email {
+		t.Error("the user should be logged in:", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestTOTPClock(t *testing.T) {
+	t.Parallel()
+
+	h, c := testApp(t)
+	email, password := "test@test.com", "G00d-Password!"
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Storer.PutUser(User{Email: email, Password: string(hash), Confirmed: true, TOTPSecretKey: totpSecret})
+
+	h.Clock.Advance(-time.Hour)
+	c.Login(email, password)
+	c.ValidateTOTP(totpSecret, time.Now())
+	if resp := c.Get("/protected"); resp.StatusCode != http.StatusUnauthorized {
+		t.Error("the code should be checked at the time of the fake clock")
+	}
+
+	c.ValidateTOTP(totpSecret, h.Clock.Now())
+	if resp := c.Get("/protected"); resp.StatusCode != http.StatusOK {
+		t.Error("the user should be logged in:", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestStorer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewStorer()
+	if err := s.Create(ctx, &User{Email: "test@test.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.(*User).Confirmed = true
+	if u, _ := s.User("test@test.com"); u.Confirmed {
+		t.Error("changes should only be kept when they're saved")
+	}
+	if err = s.Save(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.User("test@test.com"); !u.Confirmed {
+		t.Error("the change should have been saved")
+	}
+
+	if _, err = s.LoadByConfirmSelector(ctx, ""); err != authboss.ErrUserNotFound {
+		t.Error("an empty selector shouldn't find users:", err)
+	}
+}
+
+func TestStorerCountDistinct(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := NewClock(time.Now())
+	s := NewStorer()
+	s.Now = clock.Now
+
+	for _, member := range []string{"a", "b", "a"} {
+		if _, err := s.CountDistinct(ctx, "key", member, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(30 * time.Second)
+	if n, _ := s.CountDistinct(ctx, "key", "c", time.Minute); n != 3 {
+		t.Error("want 3, got:", n)
+	}
+	clock.Advance(45 * time.Second)
+	if n, _ := s.CountDistinct(ctx, "key", "d", time.Minute); n != 2 {
+		t.Error("members outside of the window shouldn't be counted, got:", n)
+	}
+}
+
+func TestMailer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := NewMailer()
+	failure := errors.New("failure")
+	m.FailNext(failure)
+
+	if err := m.Send(ctx, authboss.Email{To: []string{"a@a.com"}}); err != failure {
+		t.Error("the scripted failure should be returned:", err)
+	}
+
+	go func() {
+		_ = m.Send(ctx, authboss.Email{To: []string{"b@b.com"}})
+		_ = m.Send(ctx, authboss.Email{
+			To:           []string{"a@a.com"},
+			TextBody:     `{"url":"http://localhost/confirm?a=1\u0026b=2"}`,
+			TemplateData: authboss.HTMLData{"module": "confirm"},
+		})
+	}()
+
+	email := m.Wait(t, "a@a.com")
+	if link := Link(email); link != "http://localhost/confirm?a=1&b=2" {
+		t.Error("link was wrong:", link)
+	}
+	if sent := m.Sent(); len(sent) != 2 {
+		t.Error("both e-mails should have been kept:", len(sent))
+	}
+}
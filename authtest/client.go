@@ -0,0 +1,186 @@
+package authtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// Client drives the flows of an app like a browser would: it keeps cookies
+// between requests but doesn't follow redirects so they can be checked.
+// Forms are posted url encoded, the app's CSRF protection has to be turned
+// off (or its token added to the values) in the tests, Harness.Apply turns
+// off authboss' own.
+type Client struct {
+	T      testing.TB
+	Server *httptest.Server
+	HTTP   *http.Client
+	// Mount is the path authboss' router is mounted on
+	// (Config.Paths.Mount)
+	Mount string
+}
+
+// Response of a request with its body read
+type Response struct {
+	*http.Response
+	Body string
+}
+
+// Location the response redirects to, without the server's address
+func (r *Response) Location() string {
+	loc := r.Header.Get("Location")
+	if u, err := url.Parse(loc); err == nil && u.IsAbs() {
+		loc = u.RequestURI()
+	}
+	return loc
+}
+
+// NewClient starts a server for the app's handler which is closed when the
+// test ends
+func NewClient(t testing.TB, handler http.Handler, mount string) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Client{
+		T:      t,
+		Server: server,
+		HTTP: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Mount: mount,
+	}
+}
+
+func (c *Client) url(p string) string {
+	if u, err := url.Parse(p); err == nil && u.IsAbs() {
+		p = u.RequestURI()
+	}
+	return c.Server.URL + p
+}
+
+func (c *Client) do(req *http.Request) *Response {
+	c.T.Helper()
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		c.T.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.T.Fatal(err)
+	}
+	return &Response{Response: resp, Body: string(body)}
+}
+
+// Get the path, absolute urls (like the links in e-mails) are requested
+// from the test server
+func (c *Client) Get(p string) *Response {
+	c.T.Helper()
+	return c.Do(http.MethodGet, p)
+}
+
+// Post the form values, given as key value pairs, to the path
+func (c *Client) Post(p string, keyValues ...string) *Response {
+	c.T.Helper()
+	return c.Do(http.MethodPost, p, keyValues...)
+}
+
+// Do a request with the method, the form values given as key value pairs
+// are sent in the body
+func (c *Client) Do(method, p string, keyValues ...string) *Response {
+	c.T.Helper()
+
+	values := url.Values{}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		values.Add(keyValues[i], keyValues[i+1])
+	}
+
+	req, err := http.NewRequest(method, c.url(p), strings.NewReader(values.Encode()))
+	if err != nil {
+		c.T.Fatal(err)
+	}
+	if len(values) != 0 {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return c.do(req)
+}
+
+func (c *Client) path(p string) string {
+	return path.Join("/", c.Mount, p)
+}
+
+// Register posts the register form, more form values can be given as key
+// value pairs
+func (c *Client) Register(email, password string, keyValues ...string) *Response {
+	c.T.Helper()
+	return c.Post(c.path("/register"), append([]string{"email", email, "password", password, "confirm_password", password}, keyValues...)...)
+}
+
+// Confirm follows the link of the next confirm e-mail sent to the address
+func (c *Client) Confirm(mailer *Mailer, email string) *Response {
+	c.T.Helper()
+
+	link := Link(mailer.Wait(c.T, email))
+	if len(link) == 0 {
+		c.T.Fatalf("the e-mail to %s had no link", email)
+	}
+	return c.Get(link)
+}
+
+// Login posts the login form
+func (c *Client) Login(email, password string) *Response {
+	c.T.Helper()
+	return c.Post(c.path("/login"), "email", email, "password", password)
+}
+
+// ValidateTOTP posts the totp code for the secret at the time to the
+// second factor of a login
+func (c *Client) ValidateTOTP(secret string, now time.Time) *Response {
+	c.T.Helper()
+	return c.Post(c.path("/2fa/totp/validate"), "code", TOTPCode(c.T, secret, now))
+}
+
+// ValidateSMS posts the code of the last sms the SMSSender sent to the
+// second factor of a login
+func (c *Client) ValidateSMS(sender *SMSSender) *Response {
+	c.T.Helper()
+	return c.Post(c.path("/2fa/sms/validate"), "code", sender.Code(c.T))
+}
+
+// Logout requests the logout route with the method of
+// Config.Modules.LogoutMethod
+func (c *Client) Logout(method string) *Response {
+	c.T.Helper()
+	return c.Do(method, c.path("/logout"))
+}
+
+// TOTPCode generates the code for the secret at the time
+func TOTPCode(t testing.TB, secret string, now time.Time) string {
+	t.Helper()
+
+	code, err := totp.GenerateCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
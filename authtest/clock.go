@@ -0,0 +1,42 @@
+package authtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake authboss.Clock for Config.Core.Clock, it only moves when
+// it's told to so tests can expire tokens and lockouts without sleeping.
+type Clock struct {
+	mut sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock stopped at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now is the clock's time
+func (c *Clock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.now
+}
+
+// Set the clock's time
+func (c *Clock) Set(now time.Time) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.now = now
+}
+
+// Advance the clock by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,121 @@
+package authtest
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// WaitTimeout is how long Mailer.Wait waits for an e-mail
+var WaitTimeout = 5 * time.Second
+
+var rgxLink = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// Mailer is an authboss.Mailer that keeps the e-mails it's sent. Failures
+// can be scripted with FailNext.
+type Mailer struct {
+	mut     sync.Mutex
+	sent    []authboss.Email
+	taken   []bool
+	fail    []error
+	changed chan struct{}
+}
+
+// NewMailer creates a mailer that hasn't sent anything
+func NewMailer() *Mailer {
+	return &Mailer{changed: make(chan struct{})}
+}
+
+// Send keeps the e-mail, or fails with the next error given to FailNext
+func (m *Mailer) Send(ctx context.Context, email authboss.Email) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if len(m.fail) != 0 {
+		err := m.fail[0]
+		m.fail = m.fail[1:]
+		return err
+	}
+
+	m.sent = append(m.sent, email)
+	m.taken = append(m.taken, false)
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return nil
+}
+
+// FailNext makes the next sends fail with errs, in order
+func (m *Mailer) FailNext(errs ...error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.fail = append(m.fail, errs...)
+}
+
+// Sent returns the e-mails sent so far
+func (m *Mailer) Sent() []authboss.Email {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return append([]authboss.Email(nil), m.sent...)
+}
+
+// Wait for the next e-mail to the address, e-mails are only returned once.
+// Modules usually send e-mails in the background so this waits up to
+// WaitTimeout before failing the test.
+func (m *Mailer) Wait(t testing.TB, to string) authboss.Email {
+	t.Helper()
+
+	timeout := time.After(WaitTimeout)
+	for {
+		m.mut.Lock()
+		for i, email := range m.sent {
+			if !m.taken[i] && hasString(email.To, to) {
+				m.taken[i] = true
+				m.mut.Unlock()
+				return email
+			}
+		}
+		changed := m.changed
+		m.mut.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("no e-mail was sent to %s", to)
+			return authboss.Email{}
+		}
+	}
+}
+
+// Link finds the link in an e-mail (like the confirm or recover url): the
+// first url in its template data, or else in its text or html body.
+func Link(email authboss.Email) string {
+	for _, v := range email.TemplateData {
+		if s, ok := v.(string); ok && rgxLink.MatchString(s) {
+			return rgxLink.FindString(s)
+		}
+	}
+
+	for _, body := range []string{email.TextBody, email.HTMLBody} {
+		if link := rgxLink.FindString(body); len(link) != 0 {
+			return html.UnescapeString(strings.Replace(link, `\u0026`, "&", -1))
+		}
+	}
+	return ""
+}
+
+func hasString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
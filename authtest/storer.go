@@ -0,0 +1,423 @@
+package authtest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+// User is the user kept by the Storer, it implements every user interface
+// of authboss and its modules
+type User = mocks.User
+
+var (
+	_ authboss.CreatingServerStorer          = (*Storer)(nil)
+	_ authboss.AnyCredentialServerStorer     = (*Storer)(nil)
+	_ authboss.OAuth2ServerStorer            = (*Storer)(nil)
+	_ authboss.OAuth2LinkServerStorer        = (*Storer)(nil)
+	_ authboss.ConfirmingServerStorer        = (*Storer)(nil)
+	_ authboss.RecoveringServerStorer        = (*Storer)(nil)
+	_ authboss.RememberingFamilyServerStorer = (*Storer)(nil)
+	_ authboss.RememberingDeviceServerStorer = (*Storer)(nil)
+	_ authboss.TokenServerStorer             = (*Storer)(nil)
+	_ authboss.SessionServerStorer           = (*Storer)(nil)
+	_ authboss.KnownDeviceStorer             = (*Storer)(nil)
+	_ authboss.CounterStorer                 = (*Storer)(nil)
+	_ authboss.StatsServerStorer             = (*Storer)(nil)
+)
+
+// Storer is an in-memory storer that implements all of the storer
+// interfaces, it can be used as both Config.Storage.Server and
+// Config.Storage.Counter. It's safe for concurrent use and hands out
+// copies of its users, so like a database changes are only kept when
+// they're saved.
+type Storer struct {
+	// Now is the clock of the counter and the stats, time.Now if nil
+	Now func() time.Time
+
+	mut    sync.Mutex
+	storer *mocks.ServerStorer
+	counts map[string]map[string]time.Time
+}
+
+// NewStorer creates an empty storer
+func NewStorer() *Storer {
+	return &Storer{
+		storer: mocks.NewServerStorer(),
+		counts: make(map[string]map[string]time.Time),
+	}
+}
+
+func (s *Storer) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+// User returns a copy of the user with the pid
+func (s *Storer) User(pid string) (User, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	u, ok := s.storer.Users[pid]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// PutUser creates or replaces a user, to seed the storer
+func (s *Storer) PutUser(user User) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.storer.Users[user.GetPID()] = &user
+}
+
+// Update changes the stored user with the pid, it returns false if there
+// is no such user
+func (s *Storer) Update(pid string, fn func(*User)) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	u, ok := s.storer.Users[pid]
+	if !ok {
+		return false
+	}
+	updated := *u
+	fn(&updated)
+	s.storer.Users[pid] = &updated
+	return true
+}
+
+// copyUser makes sure what's handed out and what's stored don't alias
+func copyUser(user authboss.User) *User {
+	u := *user.(*User)
+	return &u
+}
+
+// New creates a blank user
+func (s *Storer) New(ctx context.Context) authboss.User {
+	return &User{}
+}
+
+// Create the user, ErrUserFound is returned if it exists
+func (s *Storer) Create(ctx context.Context, user authboss.User) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.Create(ctx, copyUser(user))
+}
+
+// Load the user by their pid
+func (s *Storer) Load(ctx context.Context, key string) (authboss.User, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	user, err := s.storer.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// Save the user, ErrUserNotFound is returned if it doesn't exist
+func (s *Storer) Save(ctx context.Context, user authboss.User) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.Save(ctx, copyUser(user))
+}
+
+// LoadByAny finds the user by their e-mail address or username
+func (s *Storer) LoadByAny(ctx context.Context, fields []string, identifier string) (authboss.User, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	user, err := s.storer.LoadByAny(ctx, fields, identifier)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// NewFromOAuth2 finds the oauth2 user or creates a new one
+func (s *Storer) NewFromOAuth2(ctx context.Context, provider string, details map[string]string) (authboss.OAuth2User, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	user, err := s.storer.NewFromOAuth2(ctx, provider, details)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// SaveOAuth2 creates or updates the oauth2 user
+func (s *Storer) SaveOAuth2(ctx context.Context, user authboss.OAuth2User) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.SaveOAuth2(ctx, copyUser(user))
+}
+
+// LoadByOAuth2Link finds the user with the oauth2 identity linked
+func (s *Storer) LoadByOAuth2Link(ctx context.Context, provider, uid string) (authboss.OAuth2LinkableUser, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	user, err := s.storer.LoadByOAuth2Link(ctx, provider, uid)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// LoadByConfirmSelector finds the user with the confirm selector
+func (s *Storer) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	if len(selector) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	user, err := s.storer.LoadByConfirmSelector(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// LoadByRecoverSelector finds the user with the recover selector
+func (s *Storer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	if len(selector) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	user, err := s.storer.LoadByRecoverSelector(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(user), nil
+}
+
+// AddRememberToken for the user
+func (s *Storer) AddRememberToken(ctx context.Context, pid, token string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.AddRememberToken(ctx, pid, token)
+}
+
+// DelRememberTokens of the user
+func (s *Storer) DelRememberTokens(ctx context.Context, pid string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.DelRememberTokens(ctx, pid)
+}
+
+// UseRememberToken deletes the token, ErrTokenNotFound is returned if the
+// user doesn't have it
+func (s *Storer) UseRememberToken(ctx context.Context, pid, token string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.UseRememberToken(ctx, pid, token)
+}
+
+// AddRememberFamilyToken adds the token to the family
+func (s *Storer) AddRememberFamilyToken(ctx context.Context, pid, family, token string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.AddRememberFamilyToken(ctx, pid, family, token)
+}
+
+// UseRememberFamilyToken marks the token used and returns its family
+func (s *Storer) UseRememberFamilyToken(ctx context.Context, pid, token string) (string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.UseRememberFamilyToken(ctx, pid, token)
+}
+
+// DelRememberFamily removes the family's tokens
+func (s *Storer) DelRememberFamily(ctx context.Context, pid, family string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.DelRememberFamily(ctx, pid, family)
+}
+
+// PutRememberDevice creates or updates the device of a family
+func (s *Storer) PutRememberDevice(ctx context.Context, pid string, device authboss.RememberDevice) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.PutRememberDevice(ctx, pid, device)
+}
+
+// LoadRememberDevices of the user
+func (s *Storer) LoadRememberDevices(ctx context.Context, pid string) ([]authboss.RememberDevice, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	devices, err := s.storer.LoadRememberDevices(ctx, pid)
+	return append([]authboss.RememberDevice(nil), devices...), err
+}
+
+// AddToken stores an issued api token
+func (s *Storer) AddToken(ctx context.Context, token authboss.IssuedToken) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.AddToken(ctx, token)
+}
+
+// LoadToken by its hash
+func (s *Storer) LoadToken(ctx context.Context, hash string) (authboss.IssuedToken, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.LoadToken(ctx, hash)
+}
+
+// UseToken marks the token used
+func (s *Storer) UseToken(ctx context.Context, hash string) (authboss.IssuedToken, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.UseToken(ctx, hash)
+}
+
+// DelTokenFamily removes the family's api tokens
+func (s *Storer) DelTokenFamily(ctx context.Context, pid, family string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.DelTokenFamily(ctx, pid, family)
+}
+
+// DelTokens removes all of the user's api tokens
+func (s *Storer) DelTokens(ctx context.Context, pid string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.DelTokens(ctx, pid)
+}
+
+// AddSessionRecord for the user
+func (s *Storer) AddSessionRecord(ctx context.Context, pid string, session authboss.SessionRecord) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.AddSessionRecord(ctx, pid, session)
+}
+
+// LoadSessionRecords of the user
+func (s *Storer) LoadSessionRecords(ctx context.Context, pid string) ([]authboss.SessionRecord, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	sessions, err := s.storer.LoadSessionRecords(ctx, pid)
+	return append([]authboss.SessionRecord(nil), sessions...), err
+}
+
+// TouchSessionRecord of the user
+func (s *Storer) TouchSessionRecord(ctx context.Context, pid, id string, lastSeen time.Time) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.TouchSessionRecord(ctx, pid, id, lastSeen)
+}
+
+// DelSessionRecord of the user
+func (s *Storer) DelSessionRecord(ctx context.Context, pid, id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.DelSessionRecord(ctx, pid, id)
+}
+
+// LoadKnownDevices of the user
+func (s *Storer) LoadKnownDevices(ctx context.Context, pid string) ([]authboss.KnownDevice, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	devices, err := s.storer.LoadKnownDevices(ctx, pid)
+	return append([]authboss.KnownDevice(nil), devices...), err
+}
+
+// PutKnownDevice creates or updates the device
+func (s *Storer) PutKnownDevice(ctx context.Context, pid string, device authboss.KnownDevice) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.PutKnownDevice(ctx, pid, device)
+}
+
+// DelKnownDevice of the user
+func (s *Storer) DelKnownDevice(ctx context.Context, pid, id string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.storer.DelKnownDevice(ctx, pid, id)
+}
+
+// CountDistinct adds the member to the set and counts the members added
+// within the window
+func (s *Storer) CountDistinct(ctx context.Context, key, member string, window time.Duration) (int, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.now()
+	set, ok := s.counts[key]
+	if !ok {
+		set = make(map[string]time.Time)
+		s.counts[key] = set
+	}
+
+	set[member] = now
+	for m, added := range set {
+		if now.Sub(added) > window {
+			delete(set, m)
+		}
+	}
+	return len(set), nil
+}
+
+// Stats counts the remember tokens and the tokens that have expired
+func (s *Storer) Stats(ctx context.Context) (authboss.StorageStats, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.now()
+	var stats authboss.StorageStats
+	for _, tokens := range s.storer.RMTokens {
+		stats.RememberTokens += len(tokens)
+	}
+
+	for _, token := range s.storer.Tokens {
+		if !now.Before(token.Expires) {
+			stats.ExpiredTokens++
+		}
+	}
+	for _, u := range s.storer.Users {
+		if len(u.ConfirmSelector) != 0 && !u.ConfirmExpiry.IsZero() && now.After(u.ConfirmExpiry) {
+			stats.ExpiredTokens++
+		}
+		if len(u.RecoverSelector) != 0 && now.After(u.RecoverTokenExpiry) {
+			stats.ExpiredTokens++
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,338 @@
+// Package backupemail lets users add a second e-mail address to their
+// account for when they can't get to their primary mailbox or it was taken
+// over. The recover module can send its e-mail there instead and the lock
+// module sends its unlock e-mail there too.
+//
+// Logged in users see their backup address at GET /backup-email and post a
+// new one to it. It's only saved once they follow the link e-mailed to the
+// new address to /backup-email/verify. Posting an empty address removes
+// it. Users must be authboss.BackupEmailUsers.
+package backupemail
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// PageBackupEmail shows the user's backup address, it's also for
+	// identifying the request to change it for parsing & validation
+	PageBackupEmail = "backup_email"
+	// PageBackupEmailVerify is only really used for the BodyReader
+	PageBackupEmailVerify = "backup_email_verify"
+
+	// EmailBackupEmailHTML is the name of the html template for e-mails
+	EmailBackupEmailHTML = "backup_email_html"
+	// EmailBackupEmailTxt is the name of the text template for e-mails
+	EmailBackupEmailTxt = "backup_email_txt"
+
+	// FormValueBackupEmail is the name of the form value for the address
+	FormValueBackupEmail = "backup_email"
+	// FormValueToken is the name of the form value for the verify token
+	FormValueToken = "token"
+
+	// DataBackupEmail is the user's current backup address
+	DataBackupEmail = "backup_email"
+	// DataVerifyURL is the name of the e-mail template variable that
+	// gives the url to verify the address with
+	DataVerifyURL = "url"
+
+	nSigSize  = sha512.Size
+	nTimeSize = 8
+)
+
+func init() {
+	authboss.RegisterModule("backupemail", &BackupEmail{})
+}
+
+// BackupEmail module
+type BackupEmail struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what verifying backup addresses
+// needs
+func (b *BackupEmail) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Core.MailRenderer", "Core.Mailer",
+		"Storage.Server", "Modules.BackupEmailKey")
+	problems = append(problems, ab.Config.MissingLinkURL()...)
+	if method := ab.Config.Modules.MailRouteMethod; method != http.MethodGet && method != http.MethodPost {
+		problems = append(problems, authboss.ConfigProblem{Field: "Modules.MailRouteMethod", Problem: "must be GET or POST"})
+	}
+
+	return problems
+}
+
+// Init module
+func (b *BackupEmail) Init(ab *authboss.Authboss) error {
+	b.Authboss = ab
+
+	if err := b.Config.Core.ViewRenderer.Load(PageBackupEmail); err != nil {
+		return err
+	}
+	if err := b.Config.Core.MailRenderer.Load(EmailBackupEmailHTML, EmailBackupEmailTxt); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if ab.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = ab.Config.Modules.ResponseOnUnauthed
+	} else if ab.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(ab, true, authboss.RequireFullAuth, unauthedResponse)
+
+	b.Config.Core.Router.Get("/backup-email", middleware(b.Core.ErrorHandler.Wrap(b.Get)))
+	b.Config.Core.Router.Post("/backup-email", middleware(b.Core.ErrorHandler.Wrap(b.Post)))
+
+	var callbackMethod func(string, http.Handler)
+	switch b.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = b.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = b.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+	callbackMethod("/backup-email/verify", authboss.CSRFExempt(b.ReadOnlyGuard(b.Paths.BackupEmailOK, b.Core.ErrorHandler.Wrap(b.VerifyGet))))
+
+	return nil
+}
+
+// Get shows the current user's backup address
+func (b *BackupEmail) Get(w http.ResponseWriter, r *http.Request) error {
+	user, err := b.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{DataBackupEmail: user.GetBackupEmail()}
+	return b.Core.Responder.Respond(w, r, http.StatusOK, PageBackupEmail, data)
+}
+
+// Post e-mails a link to verify the new backup address to it, or removes
+// the user's backup address if it's empty
+func (b *BackupEmail) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := b.RequestLogger(r)
+
+	user, err := b.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	validatable, err := b.Core.BodyReader.Read(PageBackupEmail, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("backup e-mail validation failed: %+v", errs)
+		data := authboss.HTMLData{
+			authboss.DataValidation: b.LocalizeErrors(r.Context(), errs),
+			DataBackupEmail:         user.GetBackupEmail(),
+		}
+		return b.Core.Responder.Respond(w, r, http.StatusOK, PageBackupEmail, data)
+	}
+
+	email := strings.TrimSpace(authboss.MustHaveBackupEmailValues(validatable).GetBackupEmail())
+	if len(email) == 0 {
+		logger.Infof("user %s removed their backup e-mail address", user.GetPID())
+		user.PutBackupEmail("")
+		if err := b.Authboss.SaveUser(r.Context(), user); err != nil {
+			return err
+		}
+
+		return b.redirect(w, r, user, authboss.TxtBackupEmailRemoved)
+	}
+
+	if primary, ok := user.(interface{ GetEmail() string }); ok && strings.EqualFold(email, primary.GetEmail()) {
+		data := authboss.HTMLData{
+			authboss.DataValidation: map[string][]string{FormValueBackupEmail: {b.Localize(r.Context(), authboss.TxtBackupEmailSame)}},
+			DataBackupEmail:         user.GetBackupEmail(),
+		}
+		return b.Core.Responder.Respond(w, r, http.StatusOK, PageBackupEmail, data)
+	}
+
+	expires := b.Now().Add(b.Config.Modules.BackupEmailTokenDuration)
+	token := signToken(b.Config.Modules.BackupEmailKey, user, email, expires)
+
+	logger.Infof("user %s added a backup e-mail address, sending it a link to verify it", user.GetPID())
+	err = b.Authboss.SendMail(r.Context(), func(ctx context.Context) error {
+		return b.sendVerifyEmail(ctx, email, token)
+	})
+	if _, ok := err.(authboss.MailError); ok {
+		data := authboss.HTMLData{
+			authboss.DataErr:     b.Localize(r.Context(), authboss.TxtBackupEmailFailed),
+			authboss.DataErrCode: authboss.ErrorCodeMailFailed,
+			DataBackupEmail:      user.GetBackupEmail(),
+		}
+		return b.Core.Responder.Respond(w, r, http.StatusOK, PageBackupEmail, data)
+	} else if err != nil {
+		return err
+	}
+
+	return b.redirect(w, r, user, authboss.TxtBackupEmailSent)
+}
+
+// sendVerifyEmail sends the link to verify the new backup address to it
+func (b *BackupEmail) sendVerifyEmail(ctx context.Context, to, token string) error {
+	mail := b.RequestConfig(ctx).Mail
+	email := authboss.Email{
+		To:       []string{to},
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + b.Localize(ctx, authboss.TxtBackupEmailSubject),
+	}
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataVerifyURL, b.mailURL(ctx, token)),
+		HTMLTemplate: EmailBackupEmailHTML,
+		TextTemplate: EmailBackupEmailTxt,
+		Module:       "backupemail",
+	}
+	return b.Authboss.Email(ctx, email, ro)
+}
+
+// VerifyGet saves the backup address with a valid token from the e-mail
+// that was sent to it. The token only works until the user's backup
+// address changes.
+func (b *BackupEmail) VerifyGet(w http.ResponseWriter, r *http.Request) error {
+	logger := b.RequestLogger(r)
+
+	validator, err := b.Core.BodyReader.Read(PageBackupEmailVerify, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validator.Validate(); errs != nil {
+		logger.Infof("validation failed in BackupEmail.VerifyGet, this typically means a bad token: %+v", errs)
+		return b.invalidToken(w, r)
+	}
+
+	token := authboss.MustHaveConfirmValues(validator).GetToken()
+	pid, email, expires, ok := parseToken(token)
+	if !ok || b.Now().After(expires) {
+		logger.Info("invalid or expired backup e-mail token submitted")
+		return b.invalidToken(w, r)
+	}
+
+	loaded, err := b.Authboss.LoadUser(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("backup e-mail token user not found: %s", pid)
+		return b.invalidToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	user, ok := loaded.(authboss.BackupEmailUser)
+	if !ok {
+		return errors.Errorf("user %s is not an authboss.BackupEmailUser (%T)", pid, loaded)
+	}
+
+	if !hmac.Equal([]byte(token), []byte(signToken(b.Config.Modules.BackupEmailKey, user, email, expires))) {
+		logger.Infof("backup e-mail token for user %s is invalid or was already used", pid)
+		return b.invalidToken(w, r)
+	}
+
+	logger.Infof("user %s verified their backup e-mail address", pid)
+	user.PutBackupEmail(email)
+	if err := b.Authboss.SaveUser(r.Context(), user); err != nil {
+		return err
+	}
+
+	return b.redirect(w, r, user, authboss.TxtBackupEmailVerified)
+}
+
+func (b *BackupEmail) currentUser(r *http.Request) (authboss.BackupEmailUser, error) {
+	user, err := b.CurrentUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bu, ok := user.(authboss.BackupEmailUser)
+	if !ok {
+		return nil, errors.Errorf("user %s is not an authboss.BackupEmailUser (%T)", user.GetPID(), user)
+	}
+	return bu, nil
+}
+
+func (b *BackupEmail) redirect(w http.ResponseWriter, r *http.Request, user authboss.User, message authboss.LocalizationKey) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Success:      b.Localize(r.Context(), message),
+		RedirectPath: b.Authboss.RedirectPath(r, "backupemail", authboss.RedirectOK, user, b.RequestConfig(r.Context()).Paths.BackupEmailOK),
+	}
+	return b.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (b *BackupEmail) invalidToken(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      b.Localize(r.Context(), authboss.TxtBackupEmailInvalid),
+		FailureCode:  authboss.ErrorCodeInvalidToken,
+		RedirectPath: b.Authboss.RedirectPath(r, "backupemail", authboss.RedirectNotOK, nil, b.RequestConfig(r.Context()).Paths.BackupEmailOK),
+	}
+	return b.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (b *BackupEmail) mailURL(ctx context.Context, token string) string {
+	config := b.RequestConfig(ctx)
+	query := url.Values{FormValueToken: []string{token}}
+
+	if len(config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", config.Mail.RootURL+"/backup-email/verify", query.Encode())
+	}
+
+	p := path.Join(config.Paths.Mount, "backup-email/verify")
+	return fmt.Sprintf("%s%s?%s", config.Paths.RootURL, p, query.Encode())
+}
+
+// signToken creates a verify token for the new backup address of the
+// user. It's the signature followed by the expiry, the address and the
+// pid. The user's current backup address is signed too but isn't in the
+// token, so the token stops working once it changes.
+func signToken(key []byte, user authboss.BackupEmailUser, email string, expires time.Time) string {
+	payload := make([]byte, nTimeSize, nTimeSize+len(email)+1+len(user.GetPID()))
+	binary.BigEndian.PutUint64(payload, uint64(expires.Unix()))
+	payload = append(payload, email...)
+	payload = append(payload, 0)
+	payload = append(payload, user.GetPID()...)
+
+	mac := hmac.New(sha512.New, key)
+	_, _ = mac.Write(payload)
+	_, _ = mac.Write([]byte{0})
+	_, _ = mac.Write([]byte(user.GetBackupEmail()))
+
+	return base64.URLEncoding.EncodeToString(append(mac.Sum(nil), payload...))
+}
+
+// parseToken returns what the token was signed for, its signature is
+// checked by signing them again
+func parseToken(token string) (pid, email string, expires time.Time, ok bool) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(rawToken) <= nSigSize+nTimeSize {
+		return "", "", time.Time{}, false
+	}
+
+	expires = time.Unix(int64(binary.BigEndian.Uint64(rawToken[nSigSize:])), 0)
+	parts := strings.SplitN(string(rawToken[nSigSize+nTimeSize:]), "\x00", 2)
+	if len(parts) != 2 {
+		return "", "", time.Time{}, false
+	}
+
+	return parts[1], parts[0], expires, true
+}
@@ -0,0 +1,224 @@
+package backupemail
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	if err := (&BackupEmail{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageBackupEmail); err != nil {
+		t.Error(err)
+	}
+	if err := mailRenderer.HasLoadedViews(EmailBackupEmailHTML, EmailBackupEmailTxt); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/backup-email", "/backup-email/verify"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/backup-email"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	backup *BackupEmail
+	ab     *authboss.Authboss
+
+	bodyReader   *mocks.BodyReader
+	clock        *authtest.Clock
+	mailer       *mocks.Mailer
+	mailRenderer *mocks.Renderer
+	redirector   *mocks.Redirector
+	responder    *mocks.Responder
+	storer       *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	h := &testHarness{}
+
+	h.ab = authboss.New()
+	h.bodyReader = &mocks.BodyReader{}
+	h.clock = authtest.NewClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.mailer = mocks.NewMailer()
+	h.mailRenderer = &mocks.Renderer{}
+	h.redirector = &mocks.Redirector{}
+	h.responder = &mocks.Responder{}
+	h.storer = mocks.NewServerStorer()
+
+	h.ab.Config.Paths.Mount = "/auth"
+	h.ab.Config.Paths.RootURL = "https://example.com"
+	h.ab.Config.Core.BodyReader = h.bodyReader
+	h.ab.Config.Core.Clock = h.clock
+	h.ab.Config.Core.Logger = mocks.Logger{}
+	h.ab.Config.Core.Mailer = h.mailer
+	h.ab.Config.Core.MailRenderer = h.mailRenderer
+	h.ab.Config.Core.Redirector = h.redirector
+	h.ab.Config.Core.Responder = h.responder
+	h.ab.Config.Storage.Server = h.storer
+	h.ab.Config.Modules.BackupEmailKey = []byte("key")
+	h.ab.Config.Modules.MailNoGoroutine = true
+
+	h.backup = &BackupEmail{h.ab}
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	return h
+}
+
+func (h *testHarness) request(method string) *http.Request {
+	r := mocks.Request(method)
+	return r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, h.storer.Users["test@test.com"]))
+}
+
+// add posts the address and returns the token from the e-mailed link
+func (h *testHarness) add(t *testing.T, email string) string {
+	t.Helper()
+
+	h.bodyReader.Return = mocks.Values{BackupEmail: email}
+	if err := h.backup.Post(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if to := h.mailer.Last.To; len(to) != 1 || to[0] != email {
+		t.Fatal("the link should have been e-mailed to the address:", to)
+	}
+
+	link, err := url.Parse(h.mailRenderer.Data[DataVerifyURL].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != "/auth/backup-email/verify" {
+		t.Error("link was wrong:", link)
+	}
+	return link.Query().Get(FormValueToken)
+}
+
+func (h *testHarness) verify(t *testing.T, token string) {
+	t.Helper()
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+	if err := h.backup.VerifyGet(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddAndVerify(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.storer.Users["test@test.com"]
+
+	token := h.add(t, "backup@test.com")
+	if len(user.BackupEmail) != 0 {
+		t.Error("the address should not be saved before it's verified")
+	}
+
+	h.verify(t, token)
+	if user.BackupEmail != "backup@test.com" {
+		t.Error("the address should be saved:", user.BackupEmail)
+	}
+	if opts := h.redirector.Options; opts.RedirectPath != h.ab.Config.Paths.BackupEmailOK || len(opts.Success) == 0 {
+		t.Errorf("redirect was wrong: %#v", opts)
+	}
+
+	if err := h.backup.Get(httptest.NewRecorder(), h.request("GET")); err != nil {
+		t.Fatal(err)
+	}
+	if h.responder.Data[DataBackupEmail] != "backup@test.com" {
+		t.Error("the address should be shown:", h.responder.Data)
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.storer.Users["test@test.com"]
+
+	first := h.add(t, "first@test.com")
+	second := h.add(t, "second@test.com")
+	h.verify(t, second)
+
+	h.verify(t, first)
+	if user.BackupEmail != "second@test.com" {
+		t.Error("a token should stop working once the address changes")
+	}
+	if h.redirector.Options.FailureCode != authboss.ErrorCodeInvalidToken {
+		t.Errorf("redirect was wrong: %#v", h.redirector.Options)
+	}
+
+	expired := h.add(t, "third@test.com")
+	h.clock.Advance(h.ab.Config.Modules.BackupEmailTokenDuration + time.Second)
+	h.verify(t, expired)
+	if user.BackupEmail != "second@test.com" {
+		t.Error("an expired token should not work")
+	}
+
+	h.verify(t, "garbage")
+	if user.BackupEmail != "second@test.com" {
+		t.Error("a garbage token should not work")
+	}
+}
+
+func TestPostRemove(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.storer.Users["test@test.com"]
+	user.BackupEmail = "backup@test.com"
+
+	h.bodyReader.Return = mocks.Values{BackupEmail: " "}
+	if err := h.backup.Post(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.BackupEmail) != 0 {
+		t.Error("the address should be removed")
+	}
+	if len(h.mailer.Last.To) != 0 {
+		t.Error("nothing should be e-mailed")
+	}
+}
+
+func TestPostSameAddress(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	h.bodyReader.Return = mocks.Values{BackupEmail: "TEST@test.com"}
+	if err := h.backup.Post(httptest.NewRecorder(), h.request("POST")); err != nil {
+		t.Fatal(err)
+	}
+
+	errs, _ := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if len(errs[FormValueBackupEmail]) == 0 {
+		t.Error("the primary address should be rejected:", h.responder.Data)
+	}
+	if len(h.mailer.Last.To) != 0 {
+		t.Error("nothing should be e-mailed")
+	}
+}
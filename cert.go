@@ -0,0 +1,45 @@
+package authboss
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+// CertResolver finds the pid of the user a verified TLS client certificate
+// belongs to, it returns ErrUserNotFound when the certificate isn't one of
+// a user's.
+type CertResolver func(ctx context.Context, cert *x509.Certificate) (string, error)
+
+// CertBySAN uses the certificate's first e-mail address SAN as the pid,
+// it's how smartcards and most device certificates name their holder.
+func CertBySAN() CertResolver {
+	return func(ctx context.Context, cert *x509.Certificate) (string, error) {
+		if len(cert.EmailAddresses) == 0 || len(cert.EmailAddresses[0]) == 0 {
+			return "", ErrUserNotFound
+		}
+
+		return cert.EmailAddresses[0], nil
+	}
+}
+
+// CertByFingerprint looks the certificate's fingerprint (see
+// CertFingerprint) up in pids, for certificates that are enrolled one by
+// one rather than issued with the user's name in them.
+func CertByFingerprint(pids map[string]string) CertResolver {
+	return func(ctx context.Context, cert *x509.Certificate) (string, error) {
+		pid, ok := pids[CertFingerprint(cert)]
+		if !ok {
+			return "", ErrUserNotFound
+		}
+
+		return pid, nil
+	}
+}
+
+// CertFingerprint is the lowercase hex SHA-256 of the certificate
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
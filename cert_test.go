@@ -0,0 +1,39 @@
+package authboss
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+)
+
+func TestCertResolvers(t *testing.T) {
+	t.Parallel()
+
+	cert := &x509.Certificate{Raw: []byte("cert"), EmailAddresses: []string{"test@test.com"}}
+	fingerprint := CertFingerprint(cert)
+	if len(fingerprint) != 64 {
+		t.Error("fingerprint should be hex sha256:", fingerprint)
+	}
+
+	tests := []struct {
+		Resolver CertResolver
+		Want     string
+	}{
+		{CertBySAN(), "test@test.com"},
+		{CertByFingerprint(map[string]string{fingerprint: "other@test.com"}), "other@test.com"},
+	}
+
+	for i, test := range tests {
+		if got, err := test.Resolver(context.Background(), cert); err != nil || got != test.Want {
+			t.Errorf("%d) want: %s, got: %s (%v)", i, test.Want, got, err)
+		}
+	}
+
+	unknown := &x509.Certificate{Raw: []byte("unknown")}
+	if _, err := CertBySAN()(context.Background(), unknown); err != ErrUserNotFound {
+		t.Error("a certificate without an e-mail should not be a user's:", err)
+	}
+	if _, err := CertByFingerprint(nil)(context.Background(), unknown); err != ErrUserNotFound {
+		t.Error("an unknown fingerprint should not be a user's:", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Package certauth logs users in with the TLS client certificate of the
+// request (mTLS), for internal tools and zero-trust deployments that
+// authenticate with smartcards or device certificates.
+//
+// The certificate must have been verified by the server, so its
+// tls.Config needs ClientCAs and a ClientAuth of VerifyClientCertIfGiven
+// or RequireAndVerifyClientCert. Modules.CertResolver then maps it to a
+// user (see authboss.CertBySAN and authboss.CertByFingerprint).
+package certauth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func init() {
+	authboss.RegisterModule("certauth", &CertAuth{})
+}
+
+// CertAuth module
+type CertAuth struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks a certificate can be mapped to a user and logged in
+func (c *CertAuth) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Logger", "Storage.Server", "Storage.SessionState", "Modules.CertResolver")
+}
+
+// Init module
+func (c *CertAuth) Init(ab *authboss.Authboss) error {
+	c.Authboss = ab
+
+	return nil
+}
+
+// Middleware logs the user of the request's client certificate in when
+// nobody is logged in yet, the session is full auth since the certificate
+// proves the user has its private key. Requests without a verified
+// certificate, or with one Modules.CertResolver doesn't know, are passed
+// through untouched so they can still log in another way. It must come
+// after LoadClientStateMiddleware.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cert := clientCert(r)
+			if cert == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if pid, _ := ab.CurrentUserID(r); len(pid) != 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+
+			pid, err := ab.Config.Modules.CertResolver(r.Context(), cert)
+			if err == authboss.ErrUserNotFound {
+				logger.Infof("client certificate %s is not a user's", authboss.CertFingerprint(cert))
+				next.ServeHTTP(w, r)
+				return
+			} else if err != nil {
+				logger.Errorf("failed to resolve client certificate: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			user, err := ab.LoadUser(r.Context(), pid)
+			if err == authboss.ErrUserNotFound {
+				logger.Infof("client certificate user not found: %s", pid)
+				next.ServeHTTP(w, r)
+				return
+			} else if err != nil {
+				logger.Errorf("failed to load client certificate user: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			logger.Infof("user %s logged in with client certificate %s", pid, authboss.CertFingerprint(cert))
+			authboss.PutSession(w, authboss.SessionKey, pid)
+			authboss.DelSession(w, authboss.SessionHalfAuthKey)
+			authboss.PutSession(w, authboss.SessionLoginTime, ab.Now().UTC().Format(time.RFC3339))
+
+			ctx := context.WithValue(r.Context(), authboss.CTXKeyPID, pid)
+			ctx = context.WithValue(ctx, authboss.CTXKeyUser, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientCert is the leaf of the first chain the server verified the
+// client's certificate with, certificates that weren't verified are
+// ignored.
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
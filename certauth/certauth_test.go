@@ -0,0 +1,111 @@
+package certauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+type testHarness struct {
+	ab      *authboss.Authboss
+	session *mocks.ClientStateRW
+	storer  *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Modules.CertResolver = authboss.CertBySAN()
+	harness.ab.Config.Storage.Server = harness.storer
+	harness.ab.Config.Storage.SessionState = harness.session
+
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	return harness
+}
+
+// serve a request with a client certificate for email, or none if it's
+// empty, and return the user the handler saw
+func (h *testHarness) serve(t *testing.T, email string) (int, authboss.User) {
+	t.Helper()
+
+	r := mocks.Request("GET")
+	if len(email) != 0 {
+		cert := &x509.Certificate{Raw: []byte(email), EmailAddresses: []string{email}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user authboss.User
+	Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ = h.ab.CurrentUser(r)
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, r)
+
+	return rec.Code, user
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.session.ClientValues[authboss.SessionHalfAuthKey] = "true"
+
+	code, user := h.serve(t, "test@test.com")
+	if code != http.StatusOK || user == nil || user.GetPID() != "test@test.com" {
+		t.Fatal("the certificate's user should have been logged in:", code, user)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionLoginTime]; !ok {
+		t.Error("the login time should have been recorded")
+	}
+	if h.session.ClientValues[authboss.SessionKey] != "test@test.com" {
+		t.Error("the user should be in the session")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionHalfAuthKey]; ok {
+		t.Error("a certificate login should be full auth")
+	}
+}
+
+func TestMiddlewarePassThrough(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	if code, user := h.serve(t, ""); code != http.StatusOK || user != nil {
+		t.Error("requests without a certificate should pass through:", code, user)
+	}
+	if code, user := h.serve(t, "unknown@test.com"); code != http.StatusOK || user != nil {
+		t.Error("certificates of unknown users should pass through:", code, user)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("nobody should have been logged in")
+	}
+}
+
+func TestMiddlewareLoggedIn(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Users["other@test.com"] = &mocks.User{Email: "other@test.com"}
+	h.session.ClientValues[authboss.SessionKey] = "other@test.com"
+
+	if _, user := h.serve(t, "test@test.com"); user == nil || user.GetPID() != "other@test.com" {
+		t.Error("the logged in user should be left alone:", user)
+	}
+}
@@ -0,0 +1,66 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+)
+
+// ChallengeVerifier issues and verifies challenges (a CAPTCHA, a
+// proof-of-work) that clients must solve before a login or password
+// recovery is attempted, to slow down brute-force and credential stuffing.
+type ChallengeVerifier interface {
+	// NewChallenge creates a challenge for the page, it's rendered in the
+	// page's data under DataChallenge. Verifiers whose challenges are
+	// fetched by the client itself (like a CAPTCHA widget) may return nil.
+	NewChallenge(ctx context.Context, page string) (interface{}, error)
+	// VerifyChallenge checks the client's solution to a challenge for the
+	// page, returning false if it's wrong.
+	VerifyChallenge(ctx context.Context, page, solution string) (bool, error)
+}
+
+// ChallengeRequired reports whether a challenge is required for the page,
+// it's always false if Modules.ChallengeVerifier is nil.
+func (a *Authboss) ChallengeRequired(r *http.Request, page string) bool {
+	if a.Config.Modules.ChallengeVerifier == nil {
+		return false
+	}
+	if required := a.Config.Modules.ChallengeRequired; required != nil {
+		return required(r, page)
+	}
+
+	return true
+}
+
+// AddChallenge puts a new challenge in the data if one is required for
+// the page.
+func (a *Authboss) AddChallenge(r *http.Request, page string, data HTMLData) error {
+	if !a.ChallengeRequired(r, page) {
+		return nil
+	}
+
+	challenge, err := a.Config.Modules.ChallengeVerifier.NewChallenge(r.Context(), page)
+	if err != nil {
+		return err
+	}
+	if challenge != nil {
+		data[DataChallenge] = challenge
+	}
+
+	return nil
+}
+
+// VerifyChallenge checks the solution in values if a challenge is required
+// for the page. It returns true when no challenge is required, and false if
+// values contains no solution (doesn't implement ChallengeValuer).
+func (a *Authboss) VerifyChallenge(r *http.Request, page string, values Validator) (bool, error) {
+	if !a.ChallengeRequired(r, page) {
+		return true, nil
+	}
+
+	challengeValues, ok := values.(ChallengeValuer)
+	if !ok || len(challengeValues.GetChallengeSolution()) == 0 {
+		return false, nil
+	}
+
+	return a.Config.Modules.ChallengeVerifier.VerifyChallenge(r.Context(), page, challengeValues.GetChallengeSolution())
+}
@@ -0,0 +1,75 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockChallengeVerifier struct{}
+
+func (mockChallengeVerifier) NewChallenge(ctx context.Context, page string) (interface{}, error) {
+	return "challenge-" + page, nil
+}
+
+func (mockChallengeVerifier) VerifyChallenge(ctx context.Context, page, solution string) (bool, error) {
+	return solution == "solved-"+page, nil
+}
+
+type mockChallengeValues string
+
+func (mockChallengeValues) Validate() []error              { return nil }
+func (m mockChallengeValues) GetChallengeSolution() string { return string(m) }
+
+func TestChallenge(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	data := HTMLData{}
+	if err := ab.AddChallenge(r, "login", data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Error("there should be no challenge without a verifier")
+	}
+	if ok, err := ab.VerifyChallenge(r, "login", mockChallengeValues("")); err != nil || !ok {
+		t.Error("it should pass without a verifier")
+	}
+
+	ab.Config.Modules.ChallengeVerifier = mockChallengeVerifier{}
+
+	if err := ab.AddChallenge(r, "login", data); err != nil {
+		t.Fatal(err)
+	}
+	if data[DataChallenge] != "challenge-login" {
+		t.Error("challenge was wrong:", data[DataChallenge])
+	}
+
+	tests := []struct {
+		Values Validator
+		OK     bool
+	}{
+		{mockChallengeValues("solved-login"), true},
+		{mockChallengeValues("solved-recover_start"), false},
+		{mockChallengeValues(""), false},
+		{testAssertionFailValues{}, false},
+	}
+
+	for i, test := range tests {
+		ok, err := ab.VerifyChallenge(r, "login", test.Values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.OK {
+			t.Errorf("%d) want: %t, got: %t", i, test.OK, ok)
+		}
+	}
+
+	ab.Config.Modules.ChallengeRequired = func(r *http.Request, page string) bool { return page != "login" }
+	if ok, _ := ab.VerifyChallenge(r, "login", mockChallengeValues("")); !ok {
+		t.Error("it should pass when ChallengeRequired says no challenge is needed")
+	}
+}
@@ -0,0 +1,218 @@
+// Package checkup serves a summary of a user's account security (second
+// factors, sessions, recent logins, recovery options) so apps can build
+// a "security checkup" page without gathering it from every store.
+package checkup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp/twofactor"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+)
+
+const (
+	// nRecentLogins is how many of the most recently used devices are
+	// listed as recent logins
+	nRecentLogins = 10
+)
+
+func init() {
+	authboss.RegisterModule("checkup", &Checkup{})
+}
+
+// PasswordChangedUser knows when its password was last changed. Authboss
+// doesn't record this itself, the app must put it when the password
+// is set.
+type PasswordChangedUser interface {
+	authboss.User
+
+	GetPasswordChanged() (changed time.Time)
+}
+
+// Report is the user's security posture. Each part is only filled in when
+// the user and the storer support it.
+type Report struct {
+	TwoFactor       TwoFactor  `json:"two_factor"`
+	Recovery        Recovery   `json:"recovery"`
+	PasswordChanged *time.Time `json:"password_changed,omitempty"`
+	// OAuth2Providers the user can log in with
+	OAuth2Providers []string `json:"oauth2_providers,omitempty"`
+	// Sessions are the user's active sessions, from a SessionServerStorer
+	Sessions []Session `json:"sessions,omitempty"`
+	// RecentLogins are the devices the user logged in from most recently,
+	// from a KnownDeviceStorer
+	RecentLogins []Login `json:"recent_logins,omitempty"`
+}
+
+// TwoFactor lists the second factors the user has set up
+type TwoFactor struct {
+	TOTP bool `json:"totp"`
+	SMS  bool `json:"sms"`
+}
+
+// Recovery lists the ways the user can get back into their account
+type Recovery struct {
+	Email string `json:"email,omitempty"`
+	// Phone is masked to its last two digits
+	Phone         string `json:"phone,omitempty"`
+	RecoveryCodes int    `json:"recovery_codes"`
+}
+
+// Session the user is logged in with
+type Session struct {
+	Created time.Time `json:"created"`
+}
+
+// Login from a device
+type Login struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Checkup module
+type Checkup struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what the checkup page needs
+func (c *Checkup) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.Responder", "Core.Logger", "Storage.Server")
+}
+
+// Init module
+func (c *Checkup) Init(ab *authboss.Authboss) error {
+	c.Authboss = ab
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if ab.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = ab.Config.Modules.ResponseOnUnauthed
+	} else if ab.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(ab, true, authboss.RequireFullAuth, unauthedResponse)
+
+	c.Config.Core.Router.Get("/checkup", middleware(c.Core.ErrorHandler.Wrap(c.Get)))
+
+	return nil
+}
+
+// Get responds with the current user's Report as JSON
+func (c *Checkup) Get(w http.ResponseWriter, r *http.Request) error {
+	user, err := c.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	report, err := Collect(r.Context(), c.Authboss, user)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal security checkup")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(b)
+	return err
+}
+
+// Collect the user's Report, for apps that want to render it themselves
+func Collect(ctx context.Context, ab *authboss.Authboss, user authboss.User) (Report, error) {
+	var report Report
+
+	if u, ok := user.(totp2fa.User); ok {
+		report.TwoFactor.TOTP = len(u.GetTOTPSecretKey()) != 0
+	}
+	if u, ok := user.(sms2fa.User); ok {
+		number, _, err := ab.DecryptSecret(ctx, authboss.AttributeSMSPhoneNumber, u.GetSMSPhoneNumber())
+		if err != nil {
+			return report, err
+		}
+		report.TwoFactor.SMS = len(number) != 0
+		report.Recovery.Phone = maskPhone(number)
+	}
+	if u, ok := user.(twofactor.User); ok {
+		report.Recovery.Email = u.GetEmail()
+		if codes := u.GetRecoveryCodes(); len(codes) != 0 {
+			report.Recovery.RecoveryCodes = len(twofactor.DecodeRecoveryCodes(codes))
+		}
+	} else if u, ok := user.(authboss.RecoverableUser); ok {
+		report.Recovery.Email = u.GetEmail()
+	}
+
+	if u, ok := user.(PasswordChangedUser); ok {
+		if changed := u.GetPasswordChanged(); !changed.IsZero() {
+			report.PasswordChanged = &changed
+		}
+	}
+
+	if u, ok := user.(authboss.OAuth2User); ok && u.IsOAuth2User() {
+		report.OAuth2Providers = append(report.OAuth2Providers, u.GetOAuth2Provider())
+	}
+	if u, ok := user.(authboss.OAuth2LinkableUser); ok {
+		for _, identity := range u.GetOAuth2Identities() {
+			if !hasString(report.OAuth2Providers, identity.Provider) {
+				report.OAuth2Providers = append(report.OAuth2Providers, identity.Provider)
+			}
+		}
+	}
+
+	pid := user.GetPID()
+	storer := ab.Config.Storage.Server
+
+	if sessionStorer, ok := storer.(authboss.SessionServerStorer); ok {
+		sessions, err := sessionStorer.LoadSessionRecords(ctx, pid)
+		if err != nil {
+			return Report{}, errors.Wrap(err, "failed to load sessions")
+		}
+		for _, s := range sessions {
+			report.Sessions = append(report.Sessions, Session{Created: s.Created})
+		}
+	}
+
+	if deviceStorer, ok := storer.(authboss.KnownDeviceStorer); ok {
+		devices, err := deviceStorer.LoadKnownDevices(ctx, pid)
+		if err != nil {
+			return Report{}, errors.Wrap(err, "failed to load known devices")
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].LastSeen.After(devices[j].LastSeen)
+		})
+		if len(devices) > nRecentLogins {
+			devices = devices[:nRecentLogins]
+		}
+		for _, d := range devices {
+			report.RecentLogins = append(report.RecentLogins, Login{FirstSeen: d.FirstSeen, LastSeen: d.LastSeen})
+		}
+	}
+
+	return report, nil
+}
+
+func maskPhone(phone string) string {
+	if len(phone) <= 2 {
+		return phone
+	}
+	return strings.Repeat("*", len(phone)-2) + phone[len(phone)-2:]
+}
+
+func hasString(arr []string, s string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
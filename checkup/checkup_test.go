@@ -0,0 +1,107 @@
+package checkup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	router := &mocks.Router{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	c := &Checkup{}
+	if err := c.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.HasGets("/checkup"); err != nil {
+		t.Error(err)
+	}
+}
+
+func testUser() *mocks.User {
+	return &mocks.User{
+		Email:          "test@test.com",
+		TOTPSecretKey:  "secret",
+		SMSPhoneNumber: "5551234567",
+		RecoveryCodes:  "a,b,c",
+		OAuth2Provider: "google",
+		OAuth2Identities: []authboss.OAuth2Identity{
+			{Provider: "google"}, {Provider: "github"},
+		},
+	}
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	storer := mocks.NewServerStorer()
+	ab.Config.Storage.Server = storer
+
+	now := time.Now().UTC()
+	storer.Sessions["test@test.com"] = []authboss.SessionRecord{{ID: "a", Created: now}}
+	storer.Devices["test@test.com"] = []authboss.KnownDevice{
+		{ID: "old", LastSeen: now.Add(-time.Hour)},
+		{ID: "new", LastSeen: now},
+	}
+
+	report, err := Collect(context.Background(), ab, testUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.TwoFactor.TOTP || !report.TwoFactor.SMS {
+		t.Error("two factor was wrong:", report.TwoFactor)
+	}
+	if report.Recovery != (Recovery{Email: "test@test.com", Phone: "********67", RecoveryCodes: 3}) {
+		t.Error("recovery was wrong:", report.Recovery)
+	}
+	if len(report.OAuth2Providers) != 2 || report.OAuth2Providers[1] != "github" {
+		t.Error("oauth2 providers were wrong:", report.OAuth2Providers)
+	}
+	if len(report.Sessions) != 1 || !report.Sessions[0].Created.Equal(now) {
+		t.Error("sessions were wrong:", report.Sessions)
+	}
+	if len(report.RecentLogins) != 2 || !report.RecentLogins[0].LastSeen.Equal(now) {
+		t.Error("recent logins should be newest first:", report.RecentLogins)
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+	c := &Checkup{ab}
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, testUser()))
+	w := httptest.NewRecorder()
+
+	if err := c.Get(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Error("content type was wrong:", ct)
+	}
+
+	var report Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.TwoFactor.TOTP || report.Recovery.RecoveryCodes != 3 {
+		t.Errorf("report was wrong: %#v", report)
+	}
+}
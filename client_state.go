@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
@@ -20,6 +21,9 @@ const (
 	// SessionLastAction is the session key to retrieve the
 	// last action of a user.
 	SessionLastAction = "last_action"
+	// SessionLoginTime is the session key to retrieve when the user
+	// logged in.
+	SessionLoginTime = "login_time"
 	// Session2FA is set when a user has been authenticated with a second factor
 	Session2FA = "twofactor"
 	// Session2FAAuthToken is a random token set in the session to be verified
@@ -31,12 +35,39 @@ const (
 	Session2FAAuthed = "twofactor_authed"
 	// SessionOAuth2State is the xsrf protection key for oauth.
 	SessionOAuth2State = "oauth2_state"
+	// SessionOAuth2StateExpires is when the oauth2 state expires, in unix
+	// seconds, see Config.Modules.OAuth2StateDuration.
+	SessionOAuth2StateExpires = "oauth2_state_expires"
+	// SessionOAuth2Nonce is the OpenID Connect nonce for oauth2 providers
+	// that check it, see OAuth2Provider.Nonce.
+	SessionOAuth2Nonce = "oauth2_nonce"
 	// SessionOAuth2Params is the additional settings for oauth
 	// like redirection/remember.
 	SessionOAuth2Params = "oauth2_params"
+	// SessionOAuth2PKCEVerifier is the PKCE code verifier for oauth2 providers
+	// that have PKCE enabled.
+	SessionOAuth2PKCEVerifier = "oauth2_pkce_verifier"
+	// SessionOAuth2Link is set when the oauth2 flow was started to link
+	// the provider account to the logged in user rather than to log in.
+	SessionOAuth2Link = "oauth2_link"
+	// SessionSAMLRequest is the id of the saml AuthnRequest the user was
+	// sent to the identity provider with, the response must be for it.
+	SessionSAMLRequest = "saml_request"
+	// SessionCSRF is the secret the session's CSRF tokens are made from,
+	// see Authboss.CSRFToken.
+	SessionCSRF = "csrf"
+	// SessionReturnTo is where to send the user back to once they've
+	// logged in, see Authboss.KeepReturnTo.
+	SessionReturnTo = "return_to"
+	// SessionImpersonator is the pid of the user who is impersonating the
+	// user in SessionKey, see Authboss.Impersonate.
+	SessionImpersonator = "impersonator"
 
 	// CookieRemember is used for cookies and form input names.
 	CookieRemember = "rm"
+	// CookieDevice holds the random id that identifies the device for the
+	// device module.
+	CookieDevice = "dv"
 
 	// FlashSuccessKey is used for storing success flash messages on the session
 	FlashSuccessKey = "flash_success"
@@ -85,6 +116,57 @@ type ClientStateReadWriter interface {
 	WriteState(http.ResponseWriter, ClientState, []ClientStateEvent) error
 }
 
+// ErrSessionNotFound should be returned from SessionStore.LoadSession when
+// the session does not exist or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps session state on the server for a
+// ClientStateReadWriter that only stores a session id in the client's
+// cookie (see defaults.ServerSessionReadWriter), which allows sessions to
+// be revoked on the server. It can be implemented with anything that can
+// expire keys like Redis, Memcached or DynamoDB.
+type SessionStore interface {
+	// LoadSession returns the session's values, or ErrSessionNotFound
+	LoadSession(ctx context.Context, id string) (map[string]string, error)
+	// SaveSession creates or replaces the session, it should expire after
+	// ttl.
+	SaveSession(ctx context.Context, id string, values map[string]string, ttl time.Duration) error
+	// DeleteSession removes the session, it's not an error if the session
+	// does not exist.
+	DeleteSession(ctx context.Context, id string) error
+}
+
+// UserSessionStore is a SessionStore that can find the sessions a user is
+// logged in with (the sessions whose SessionKey value is their pid), so
+// Authboss.RevokeSessions can delete them on every server.
+type UserSessionStore interface {
+	SessionStore
+
+	// DeleteUserSessions removes all of the user's sessions
+	DeleteUserSessions(ctx context.Context, pid string) error
+}
+
+// SessionRevoker is implemented by ClientStateReadWriters that keep
+// sessions on the server (see defaults.ServerSessionReadWriter), it's used
+// by Authboss.RevokeSessions on Config.Storage.SessionState.
+type SessionRevoker interface {
+	// RevokeSessions deletes all of the user's sessions
+	RevokeSessions(ctx context.Context, pid string) error
+}
+
+// SessionRegenerator is implemented by session ClientStateReadWriters
+// whose sessions have an identifier (like
+// defaults.ServerSessionReadWriter). The identifier is regenerated when
+// the user's privileges change: when they log in, pass their second factor
+// and log out, so one an attacker planted or learned before (session
+// fixation) is useless afterwards. See RegenerateSession.
+type SessionRegenerator interface {
+	// RegenerateSession invalidates the identifier of the state and returns
+	// the state to write under a new one, WriteState is called with it
+	// after.
+	RegenerateSession(w http.ResponseWriter, state ClientState) (ClientState, error)
+}
+
 // UnderlyingResponseWriter retrieves the response
 // writer underneath the current one. This allows us
 // to wrap and later discover the particular one that we want.
@@ -111,8 +193,10 @@ type ClientStateResponseWriter struct {
 
 	cookieState  ClientState
 	sessionState ClientState
+	tenant       string
 
 	hasWritten         bool
+	regenerateSession  bool
 	cookieStateEvents  []ClientStateEvent
 	sessionStateEvents []ClientStateEvent
 }
@@ -124,7 +208,10 @@ func (a *Authboss) LoadClientStateMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		writer := a.NewResponse(w)
 		request, err := a.LoadClientState(writer, r)
-		if err != nil {
+		if err == ErrTenantNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
 			logger := a.RequestLogger(r)
 			logger.Errorf("failed to load client state %+v", err)
 
@@ -146,8 +233,44 @@ func (a *Authboss) NewResponse(w http.ResponseWriter) *ClientStateResponseWriter
 }
 
 // LoadClientState loads the state from sessions and cookies
-// into the ResponseWriter for later use.
+// into the ResponseWriter for later use. The request's context also gets a
+// cache for the user CurrentUser loads, the request's tenant when there's a
+// Config.Core.TenantResolver, its config when there's a
+// Config.Core.ConfigOverride or it came through a trusted proxy (see
+// ForwardedRootURL) and the pid of the logged in user (see
+// PIDFromContext).
 func (a *Authboss) LoadClientState(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	if _, ok := r.Context().Value(ctxKeyUserCache).(*userCache); !ok {
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyUserCache, &userCache{}))
+	}
+
+	if a.Config.Core.TenantResolver != nil {
+		tenant, err := a.Config.Core.TenantResolver(r)
+		if err != nil {
+			return nil, err
+		}
+		MustClientStateResponseWriter(w).tenant = tenant
+		r = r.WithContext(context.WithValue(r.Context(), CTXKeyTenant, tenant))
+	}
+
+	if prefix, ok := a.ForwardedPrefix(r); ok && len(prefix) != 0 {
+		r = r.WithContext(context.WithValue(r.Context(), CTXKeyPathPrefix, prefix))
+	}
+
+	rootURL, forwarded := a.ForwardedRootURL(r)
+	if a.Config.Core.ConfigOverride != nil || forwarded {
+		config := a.Config
+		if forwarded {
+			config.Paths.RootURL = rootURL
+		}
+		if a.Config.Core.ConfigOverride != nil {
+			if err := a.Config.Core.ConfigOverride(r, &config); err != nil {
+				return nil, err
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), CTXKeyConfig, &config))
+	}
+
 	if a.Storage.SessionState != nil {
 		state, err := a.Storage.SessionState.ReadState(r)
 		if err != nil {
@@ -156,6 +279,11 @@ func (a *Authboss) LoadClientState(w http.ResponseWriter, r *http.Request) (*htt
 			c := MustClientStateResponseWriter(w)
 			c.sessionState = state
 			r = r.WithContext(context.WithValue(r.Context(), CTXKeySessionState, state))
+
+			// A pid from something else, like a bearer token, wins
+			if pid, ok := state.Get(SessionKey); ok && len(pid) != 0 && r.Context().Value(CTXKeyPID) == nil {
+				r = r.WithContext(context.WithValue(r.Context(), CTXKeyPID, pid))
+			}
 		}
 	}
 	if a.Storage.CookieState != nil {
@@ -237,11 +365,22 @@ func (c *ClientStateResponseWriter) putClientState() error {
 	}
 	c.hasWritten = true
 
-	if len(c.cookieStateEvents) == 0 && len(c.sessionStateEvents) == 0 {
+	regenerate := c.regenerateSession || privilegeChanged(c.sessionStateEvents)
+	if regenerator, ok := c.sessionStateRW.(SessionRegenerator); ok && regenerate {
+		state, err := regenerator.RegenerateSession(c, c.sessionState)
+		if err != nil {
+			return err
+		}
+		c.sessionState = state
+	} else {
+		regenerate = false
+	}
+
+	if len(c.cookieStateEvents) == 0 && len(c.sessionStateEvents) == 0 && !regenerate {
 		return nil
 	}
 
-	if c.sessionStateRW != nil && len(c.sessionStateEvents) > 0 {
+	if c.sessionStateRW != nil && (len(c.sessionStateEvents) > 0 || regenerate) {
 		err := c.sessionStateRW.WriteState(c, c.sessionState, c.sessionStateEvents)
 		if err != nil {
 			return err
@@ -257,6 +396,31 @@ func (c *ClientStateResponseWriter) putClientState() error {
 	return nil
 }
 
+// RegenerateSession makes the session's identifier be regenerated when it's
+// written if the session ClientStateReadWriter is a SessionRegenerator.
+// It's done without asking when the user logs in, passes their second
+// factor or logs out (when SessionKey, SessionHalfAuthKey or Session2FA
+// change or the session is cleared with DelAllSession), apps call it for
+// their own privilege changes.
+func RegenerateSession(w http.ResponseWriter) {
+	MustClientStateResponseWriter(w).regenerateSession = true
+}
+
+// privilegeChanged checks if the session events log the user in or out or
+// change how they're authenticated
+func privilegeChanged(events []ClientStateEvent) bool {
+	for _, ev := range events {
+		if ev.Kind == ClientStateEventDelAll {
+			return true
+		}
+		switch ev.Key {
+		case SessionKey, SessionHalfAuthKey, Session2FA:
+			return true
+		}
+	}
+	return false
+}
+
 // IsFullyAuthed returns false if the user has a SessionHalfAuth
 // in his session.
 func IsFullyAuthed(r *http.Request) bool {
@@ -355,6 +519,9 @@ func setState(w http.ResponseWriter, ctxKey contextKey, op ClientStateEventKind,
 	case CTXKeySessionState:
 		csrw.sessionStateEvents = append(csrw.sessionStateEvents, ev)
 	case CTXKeyCookieState:
+		if op != ClientStateEventDelAll {
+			ev.Key = TenantCookie(ev.Key, csrw.tenant)
+		}
 		csrw.cookieStateEvents = append(csrw.cookieStateEvents, ev)
 	}
 }
@@ -365,6 +532,10 @@ func getState(r *http.Request, ctxKey contextKey, key string) (string, bool) {
 		return "", false
 	}
 
+	if ctxKey == CTXKeyCookieState {
+		key = TenantCookie(key, Tenant(r.Context()))
+	}
+
 	state := val.(ClientState)
 	return state.Get(key)
 }
@@ -2,6 +2,7 @@ package authboss
 
 import (
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -177,6 +178,48 @@ func TestDelAllSession(t *testing.T) {
 	}
 }
 
+type regeneratingClientStateRW struct {
+	mockClientStateReadWriter
+	regenerated *int
+}
+
+func (r regeneratingClientStateRW) RegenerateSession(w http.ResponseWriter, state ClientState) (ClientState, error) {
+	*r.regenerated++
+	return state, nil
+}
+
+func TestRegenerateSession(t *testing.T) {
+	t.Parallel()
+
+	regenerated := 0
+	ab := New()
+	ab.Storage.SessionState = regeneratingClientStateRW{newMockClientStateRW(), &regenerated}
+
+	tests := []struct {
+		Name       string
+		Change     func(w http.ResponseWriter)
+		Regenerate bool
+	}{
+		{"Other", func(w http.ResponseWriter) { PutSession(w, "other", "value") }, false},
+		{"Nothing", func(w http.ResponseWriter) {}, false},
+		{"Login", func(w http.ResponseWriter) { PutSession(w, SessionKey, "test@test.com") }, true},
+		{"TwoFactor", func(w http.ResponseWriter) { PutSession(w, Session2FA, "totp") }, true},
+		{"Logout", func(w http.ResponseWriter) { DelAllSession(w, nil) }, true},
+		{"Explicit", RegenerateSession, true},
+	}
+
+	for _, test := range tests {
+		regenerated = 0
+		w := ab.NewResponse(httptest.NewRecorder())
+		test.Change(w)
+		w.WriteHeader(http.StatusOK)
+
+		if (regenerated == 1) != test.Regenerate {
+			t.Errorf("%s: regenerated %d times", test.Name, regenerated)
+		}
+	}
+}
+
 func TestDelKnown(t *testing.T) {
 	t.Parallel()
 
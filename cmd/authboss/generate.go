@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// field is a column of the users table and the user's getter and putter for
+// it
+type field struct {
+	// Name is used for the struct field and the Get/Put methods
+	Name   string
+	Column string
+	// Type is one of string, bool, int and time.Time
+	Type string
+}
+
+// module is what a module needs from the generated code
+type module struct {
+	Fields []field
+	// UserInterfaces are the interfaces the user must implement
+	UserInterfaces []string
+	// StorerInterface is the upgrade of the ServerStorer the module needs
+	StorerInterface string
+	// Import is the module's package
+	Import string
+	// Named is set when main uses the package (for its middleware or
+	// setup), otherwise it's a blank import
+	Named bool
+}
+
+// moduleImport is a module's package imported by main
+type moduleImport struct {
+	Path  string
+	Named bool
+}
+
+var passwordField = field{"Password", "password", "string"}
+var recoveryCodesField = field{"RecoveryCodes", "recovery_codes", "string"}
+
+// modules are the modules the generator knows about
+var modules = map[string]module{
+	"auth": {
+		Fields:         []field{passwordField},
+		UserInterfaces: []string{"authboss.AuthableUser"},
+		Import:         "github.com/volatiletech/authboss/v3/auth",
+	},
+	"register": {
+		Fields:          []field{passwordField},
+		UserInterfaces:  []string{"authboss.AuthableUser"},
+		StorerInterface: "authboss.CreatingServerStorer",
+		Import:          "github.com/volatiletech/authboss/v3/register",
+	},
+	"confirm": {
+		Fields: []field{
+			{"Confirmed", "confirmed", "bool"},
+			{"ConfirmSelector", "confirm_selector", "string"},
+			{"ConfirmVerifier", "confirm_verifier", "string"},
+		},
+		UserInterfaces:  []string{"authboss.ConfirmableUser"},
+		StorerInterface: "authboss.ConfirmingServerStorer",
+		Import:          "github.com/volatiletech/authboss/v3/confirm",
+		Named:           true,
+	},
+	"recover": {
+		Fields: []field{
+			{"RecoverSelector", "recover_selector", "string"},
+			{"RecoverVerifier", "recover_verifier", "string"},
+			{"RecoverExpiry", "recover_expiry", "time.Time"},
+		},
+		UserInterfaces:  []string{"authboss.RecoverableUser"},
+		StorerInterface: "authboss.RecoveringServerStorer",
+		Import:          "github.com/volatiletech/authboss/v3/recover",
+	},
+	"lock": {
+		Fields: []field{
+			{"AttemptCount", "attempt_count", "int"},
+			{"LastAttempt", "last_attempt", "time.Time"},
+			{"Locked", "locked", "time.Time"},
+		},
+		UserInterfaces: []string{"authboss.LockableUser"},
+		Import:         "github.com/volatiletech/authboss/v3/lock",
+		Named:          true,
+	},
+	"remember": {
+		StorerInterface: "authboss.RememberingServerStorer",
+		Import:          "github.com/volatiletech/authboss/v3/remember",
+		Named:           true,
+	},
+	"logout": {
+		Import: "github.com/volatiletech/authboss/v3/logout",
+	},
+	"expire": {
+		Import: "github.com/volatiletech/authboss/v3/expire",
+		Named:  true,
+	},
+	"totp2fa": {
+		Fields: []field{
+			{"TOTPSecretKey", "totp_secret_key", "string"},
+			{"TOTPLastCode", "totp_last_code", "string"},
+			recoveryCodesField,
+		},
+		UserInterfaces: []string{"totp2fa.UserOneTime"},
+		Import:         "github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa",
+		Named:          true,
+	},
+	"sms2fa": {
+		Fields: []field{
+			{"SMSPhoneNumber", "sms_phone_number", "string"},
+			recoveryCodesField,
+		},
+		UserInterfaces: []string{"sms2fa.User"},
+		Import:         "github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa",
+		Named:          true,
+	},
+}
+
+// dialect is the flavour of sql the schema and queries are written in
+type dialect struct {
+	Driver       string
+	DriverImport string
+	ID           string
+	Types        map[string]string
+	// Placeholder is the nth (from 1) query parameter
+	Placeholder func(n int) string
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		Driver:       "postgres",
+		DriverImport: "github.com/lib/pq",
+		ID:           "BIGSERIAL PRIMARY KEY",
+		Types: map[string]string{
+			"string":    "TEXT NOT NULL DEFAULT ''",
+			"bool":      "BOOLEAN NOT NULL DEFAULT FALSE",
+			"int":       "INTEGER NOT NULL DEFAULT 0",
+			"time.Time": "TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT '0001-01-01 00:00:00+00'",
+		},
+		Placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	},
+	"sqlite": {
+		Driver:       "sqlite3",
+		DriverImport: "github.com/mattn/go-sqlite3",
+		ID:           "INTEGER PRIMARY KEY AUTOINCREMENT",
+		Types: map[string]string{
+			"string":    "TEXT NOT NULL DEFAULT ''",
+			"bool":      "BOOLEAN NOT NULL DEFAULT FALSE",
+			"int":       "INTEGER NOT NULL DEFAULT 0",
+			"time.Time": "DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'",
+		},
+		Placeholder: func(_ int) string { return "?" },
+	},
+}
+
+// Options choose what's generated
+type Options struct {
+	// Modules are the names of the modules to generate for, see modules
+	Modules []string
+	// Dialect is postgres or sqlite
+	Dialect string
+	// Package is the package of the user and storer, the example main is
+	// only generated for package main
+	Package string
+}
+
+// generator has what the templates need
+type generator struct {
+	Options
+	dialect dialect
+
+	Fields         []field
+	UserInterfaces []string
+	StorerIfaces   []string
+	HasTime        bool
+	Has            map[string]bool
+	Imports        []moduleImport
+}
+
+// newGenerator checks the options and gathers what the modules need
+func newGenerator(opts Options) (*generator, error) {
+	d, ok := dialects[opts.Dialect]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %q, it must be postgres or sqlite", opts.Dialect)
+	}
+	if len(opts.Package) == 0 {
+		opts.Package = "main"
+	}
+
+	g := &generator{
+		Options: opts,
+		dialect: d,
+		Fields:  []field{{"Email", "email", "string"}},
+		Has:     map[string]bool{},
+	}
+
+	seenField := map[string]bool{"Email": true}
+	seenIface := map[string]bool{}
+	for _, name := range opts.Modules {
+		m, ok := modules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown module %q, it must be one of: %s", name, strings.Join(moduleNames(), ", "))
+		}
+		if g.Has[name] {
+			continue
+		}
+		g.Has[name] = true
+
+		for _, f := range m.Fields {
+			if !seenField[f.Name] {
+				seenField[f.Name] = true
+				g.Fields = append(g.Fields, f)
+				g.HasTime = g.HasTime || f.Type == "time.Time"
+			}
+		}
+		for _, iface := range m.UserInterfaces {
+			if !seenIface[iface] {
+				seenIface[iface] = true
+				g.UserInterfaces = append(g.UserInterfaces, iface)
+			}
+		}
+		if len(m.StorerInterface) != 0 {
+			g.StorerIfaces = append(g.StorerIfaces, m.StorerInterface)
+		}
+		g.Imports = append(g.Imports, moduleImport{Path: m.Import, Named: m.Named})
+	}
+
+	if g.Has["remember"] && g.Has["expire"] {
+		return nil, fmt.Errorf("the remember and expire modules can't be used together")
+	}
+	if g.Has["register"] && !g.Has["auth"] {
+		return nil, fmt.Errorf("the register module needs the auth module")
+	}
+
+	return g, nil
+}
+
+func moduleNames() []string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Files generates the files by their path
+func (g *generator) Files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	sources := map[string]*template.Template{
+		"user.go":   userTemplate,
+		"storer.go": storerTemplate,
+	}
+	if g.Package == "main" {
+		sources["main.go"] = mainTemplate
+	}
+	for name, tpl := range sources {
+		b, err := g.execute(tpl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s: %w", name, err)
+		}
+		if b, err = format.Source(b); err != nil {
+			return nil, fmt.Errorf("failed to format %s: %w", name, err)
+		}
+		files[name] = b
+	}
+
+	if g.Has["confirm"] {
+		files["mail/confirm_txt.tpl"] = []byte("Confirm your account by opening this link:\n\n{{.url}}\n")
+	}
+	if g.Has["recover"] {
+		files["mail/recover_txt.tpl"] = []byte("Reset your password by opening this link:\n\n{{.recover_url}}\n")
+	}
+
+	var err error
+	if files["migrations/0001_authboss.up.sql"], err = g.execute(upTemplate); err != nil {
+		return nil, err
+	}
+	if files["migrations/0001_authboss.down.sql"], err = g.execute(downTemplate); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (g *generator) execute(tpl *template.Template) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Columns are the users table's columns without the id
+func (g *generator) Columns() string {
+	columns := make([]string, len(g.Fields))
+	for i, f := range g.Fields {
+		columns[i] = f.Column
+	}
+	return strings.Join(columns, ", ")
+}
+
+// ColumnDefinition is the field's column in CREATE TABLE
+func (g *generator) ColumnDefinition(f field) string {
+	if f.Name == "Email" {
+		return f.Column + " TEXT NOT NULL UNIQUE"
+	}
+	return f.Column + " " + g.dialect.Types[f.Type]
+}
+
+// HasMail is set when there are modules that send e-mails
+func (g *generator) HasMail() bool { return g.Has["confirm"] || g.Has["recover"] }
+
+// ID is the id column's definition
+func (g *generator) ID() string { return "id " + g.dialect.ID }
+
+// Driver is the database/sql driver's name
+func (g *generator) Driver() string { return g.dialect.Driver }
+
+// DriverImport is the driver's package
+func (g *generator) DriverImport() string { return g.dialect.DriverImport }
+
+// SelectBy is the query that loads the user whose column is a parameter
+func (g *generator) SelectBy(column string) string {
+	return fmt.Sprintf("SELECT id, %s FROM users WHERE %s = %s", g.Columns(), column, g.dialect.Placeholder(1))
+}
+
+// Insert is the query that creates a user
+func (g *generator) Insert() string {
+	placeholders := make([]string, len(g.Fields))
+	for i := range g.Fields {
+		placeholders[i] = g.dialect.Placeholder(i + 1)
+	}
+	return fmt.Sprintf("INSERT INTO users (%s) VALUES (%s)", g.Columns(), strings.Join(placeholders, ", "))
+}
+
+// Update is the query that saves a user, the id is the last parameter
+func (g *generator) Update() string {
+	sets := make([]string, len(g.Fields))
+	for i, f := range g.Fields {
+		sets[i] = f.Column + " = " + g.dialect.Placeholder(i+1)
+	}
+	return fmt.Sprintf("UPDATE users SET %s WHERE id = %s", strings.Join(sets, ", "), g.dialect.Placeholder(len(g.Fields)+1))
+}
+
+// Query is a query with the placeholders of the dialect, ? in the query are
+// the parameters
+func (g *generator) Query(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(g.dialect.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var funcs = template.FuncMap{"quote": strconv.Quote}
+
+var userTemplate = template.Must(template.New("user.go").Funcs(funcs).Parse(`// Code generated by authboss, edit it to fit your app.
+
+package {{.Package}}
+
+import (
+	{{if .HasTime}}"time"{{end}}
+
+	"github.com/volatiletech/authboss/v3"
+	{{- if .Has.totp2fa}}
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+	{{- end}}
+	{{- if .Has.sms2fa}}
+	"github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa"
+	{{- end}}
+)
+
+// User is a row of the users table, its pid is the e-mail address
+type User struct {
+	ID int64
+{{range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+}
+
+var (
+	_ authboss.User = &User{}
+{{- range .UserInterfaces}}
+	_ {{.}} = &User{}
+{{- end}}
+)
+
+// GetPID from user
+func (u User) GetPID() string { return u.Email }
+
+// PutPID into user
+func (u *User) PutPID(pid string) { u.Email = pid }
+{{range .Fields}}
+// Get{{.Name}} from user
+func (u User) Get{{.Name}}() {{.Type}} { return u.{{.Name}} }
+
+// Put{{.Name}} into user
+func (u *User) Put{{.Name}}(value {{.Type}}) { u.{{.Name}} = value }
+{{end}}`))
+
+var storerTemplate = template.Must(template.New("storer.go").Funcs(funcs).Parse(`// Code generated by authboss, edit it to fit your app.
+
+package {{.Package}}
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Storer keeps the users in the users table{{if .Has.remember}} and the
+// remember me tokens in the remember_tokens table{{end}}, see migrations.
+type Storer struct {
+	db *sql.DB
+}
+
+var (
+	_ authboss.ServerStorer = &Storer{}
+{{- range .StorerIfaces}}
+	_ {{.}} = &Storer{}
+{{- end}}
+)
+
+// NewStorer creates a Storer
+func NewStorer(db *sql.DB) *Storer {
+	return &Storer{db: db}
+}
+
+// Load the user by their pid
+func (s *Storer) Load(ctx context.Context, key string) (authboss.User, error) {
+	return s.loadBy(ctx, {{quote (.SelectBy "email")}}, key)
+}
+
+// Save the user
+func (s *Storer) Save(ctx context.Context, user authboss.User) error {
+	u := user.(*User)
+	result, err := s.db.ExecContext(ctx, {{quote .Update}},
+		{{range .Fields}}u.{{.Name}}, {{end}}u.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return authboss.ErrUserNotFound
+	}
+	return nil
+}
+{{if .Has.register}}
+// New creates a blank user
+func (s *Storer) New(_ context.Context) authboss.User {
+	return &User{}
+}
+
+// Create the user
+func (s *Storer) Create(ctx context.Context, user authboss.User) error {
+	u := user.(*User)
+	if _, err := s.loadBy(ctx, {{quote (.SelectBy "email")}}, u.Email); err == nil {
+		return authboss.ErrUserFound
+	} else if err != authboss.ErrUserNotFound {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, {{quote .Insert}},
+		{{range $i, $f := .Fields}}{{if $i}}, {{end}}u.{{$f.Name}}{{end}})
+	return err
+}
+{{end}}{{if .Has.confirm}}
+// LoadByConfirmSelector finds the user by their confirm selector
+func (s *Storer) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	return s.loadBy(ctx, {{quote (.SelectBy "confirm_selector")}}, selector)
+}
+{{end}}{{if .Has.recover}}
+// LoadByRecoverSelector finds the user by their recover selector
+func (s *Storer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	return s.loadBy(ctx, {{quote (.SelectBy "recover_selector")}}, selector)
+}
+{{end}}{{if .Has.remember}}
+// AddRememberToken to a user
+func (s *Storer) AddRememberToken(ctx context.Context, pid, token string) error {
+	_, err := s.db.ExecContext(ctx, {{quote (.Query "INSERT INTO remember_tokens (pid, token) VALUES (?, ?)")}}, pid, token)
+	return err
+}
+
+// DelRememberTokens removes all of the user's tokens
+func (s *Storer) DelRememberTokens(ctx context.Context, pid string) error {
+	_, err := s.db.ExecContext(ctx, {{quote (.Query "DELETE FROM remember_tokens WHERE pid = ?")}}, pid)
+	return err
+}
+
+// UseRememberToken finds the pid-token pair and deletes it
+func (s *Storer) UseRememberToken(ctx context.Context, pid, token string) error {
+	result, err := s.db.ExecContext(ctx, {{quote (.Query "DELETE FROM remember_tokens WHERE pid = ? AND token = ?")}}, pid, token)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return authboss.ErrTokenNotFound
+	}
+	return nil
+}
+{{end}}
+// loadBy loads the user the query finds
+func (s *Storer) loadBy(ctx context.Context, query string, arg string) (*User, error) {
+	u := &User{}
+	err := s.db.QueryRowContext(ctx, query, arg).Scan(&u.ID{{range .Fields}}, &u.{{.Name}}{{end}})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, authboss.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+`))
+
+var mainTemplate = template.Must(template.New("main.go").Funcs(funcs).Parse(`// Code generated by authboss, edit it to fit your app.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+{{- if .Has.sms2fa}}
+	"context"
+{{- end}}
+{{- if .HasMail}}
+	"embed"
+	"io/fs"
+{{- end}}
+
+	_ {{quote .DriverImport}}
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/defaults"
+{{- range .Imports}}
+	{{if not .Named}}_ {{end}}{{quote .Path}}
+{{- end}}
+)
+
+{{- if .HasMail}}
+//go:embed mail
+var mailTemplates embed.FS
+
+{{end -}}
+// main serves the authboss routes as a JSON API under /auth and a page that
+// needs a logged in user at /. The database and the key that encrypts the
+// cookies come from the environment:
+//
+//	DATABASE_URL the {{.Driver}} data source name
+//	SESSION_KEY  32 random bytes in base64 (openssl rand -base64 32)
+func main() {
+	db, err := sql.Open({{quote .Driver}}, os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("SESSION_KEY"))
+	if err != nil {
+		log.Fatal("SESSION_KEY must be base64: ", err)
+	}
+	session, err := defaults.NewCookieStateReadWriter(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The session is forgotten when the browser is closed
+	session.MaxAge = 0
+	session.Cookie.Name = "session"
+
+	ab := authboss.New()
+	ab.Config.Paths.Mount = "/auth"
+	ab.Config.Paths.RootURL = "http://localhost:3000"
+	// There are no templates, e-mailed links go to the front-end which posts
+	// them back
+	ab.Config.Core.NoRender = true
+	ab.Config.Modules.MailRouteMethod = http.MethodPost
+	ab.Config.Modules.ResponseOnUnauthed = authboss.RespondUnauthorized
+	ab.Config.Storage.Server = NewStorer(db)
+	ab.Config.Storage.SessionState = session
+{{- if .Has.remember}}
+	remembered, err := defaults.NewCookieStateReadWriter(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ab.Config.Storage.CookieState = remembered
+{{- end}}
+{{- if .Has.register}}
+	ab.Config.Modules.RegisterPreserveFields = []string{"email"}
+{{- end}}
+{{- if .Has.logout}}
+	ab.Config.Modules.LogoutMethod = http.MethodPost
+{{- end}}
+
+	// The default pieces, reading JSON bodies. Mail is logged to stdout
+	// until Config.Core.Mailer is set to a real mailer.
+	defaults.SetCore(&ab.Config, true, false)
+{{- if .HasMail}}
+	mail, err := fs.Sub(mailTemplates, "mail")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ab.Config.Core.MailRenderer = defaults.NewMailRenderer(mail)
+{{- end}}
+
+	if err := ab.Init(); err != nil {
+		log.Fatal(err)
+	}
+{{- if .Has.totp2fa}}
+
+	totp := &totp2fa.TOTP{Authboss: ab}
+	if err := totp.Setup(); err != nil {
+		log.Fatal(err)
+	}
+{{- end}}
+{{- if .Has.sms2fa}}
+
+	sms := &sms2fa.SMS{Authboss: ab, Sender: logSender{}}
+	if err := sms.Setup(); err != nil {
+		log.Fatal(err)
+	}
+{{- end}}
+
+	mux := http.NewServeMux()
+	mux.Handle("/auth/", http.StripPrefix("/auth", ab.Config.Core.Router))
+
+	var home http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s\n", ab.CurrentUserIDP(r))
+	})
+{{- if .Has.confirm}}
+	home = confirm.Middleware(ab)(home)
+{{- end}}
+{{- if .Has.lock}}
+	home = lock.Middleware(ab)(home)
+{{- end}}
+	home = authboss.Middleware2(ab, authboss.RequireFullAuth, authboss.RespondUnauthorized)(home)
+	mux.Handle("/", home)
+
+	var handler http.Handler = mux
+{{- if .Has.remember}}
+	handler = remember.Middleware(ab)(handler)
+{{- end}}
+{{- if .Has.expire}}
+	handler = expire.Middleware(ab)(handler)
+{{- end}}
+	handler = ab.LoadClientStateMiddleware(handler)
+
+	log.Println("listening on :3000")
+	log.Fatal(http.ListenAndServe(":3000", handler))
+}
+{{- if .Has.sms2fa}}
+
+// logSender logs the text messages, replace it with an SMS provider
+type logSender struct{}
+
+func (logSender) Send(_ context.Context, number, text string) error {
+	log.Printf("sms to %s: %s", number, text)
+	return nil
+}
+{{- end}}
+`))
+
+var upTemplate = template.Must(template.New("up").Funcs(funcs).Parse(`CREATE TABLE users (
+	{{.ID}}{{range .Fields}},
+	{{$.ColumnDefinition .}}{{end}}
+);
+{{- if .Has.confirm}}
+
+CREATE INDEX users_confirm_selector ON users (confirm_selector);
+{{- end}}
+{{- if .Has.recover}}
+
+CREATE INDEX users_recover_selector ON users (recover_selector);
+{{- end}}
+{{- if .Has.remember}}
+
+CREATE TABLE remember_tokens (
+	pid TEXT NOT NULL,
+	token TEXT NOT NULL,
+	PRIMARY KEY (pid, token)
+);
+{{- end}}
+`))
+
+var downTemplate = template.Must(template.New("down").Parse(`{{if .Has.remember}}DROP TABLE remember_tokens;
+{{end}}DROP TABLE users;
+`))
@@ -0,0 +1,87 @@
+// Command authboss generates the code an app needs to start using authboss
+// with a sql database: a user that implements what the chosen modules need,
+// a storer for it, the migration that creates its tables, the e-mail
+// templates and an example main that wires them up with the defaults as a
+// JSON API.
+//
+//	go run github.com/volatiletech/authboss/v3/cmd/authboss \
+//		-modules auth,register,confirm,recover,lock,remember,logout \
+//		-dialect postgres -out ./server
+//
+// The generated code is a starting point that's meant to be edited, files
+// that already exist aren't overwritten unless -force is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "authboss:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("authboss", flag.ContinueOnError)
+	moduleList := flags.String("modules", "auth,register,logout", "the modules to generate for: "+strings.Join(moduleNames(), ", "))
+	dialect := flags.String("dialect", "postgres", "the sql dialect: postgres or sqlite")
+	pkg := flags.String("package", "main", "the package of the generated code, main.go is only generated for main")
+	dir := flags.String("out", ".", "the directory the files are written to")
+	force := flags.Bool("force", false, "overwrite files that exist")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var names []string
+	for _, name := range strings.Split(*moduleList, ",") {
+		if name = strings.TrimSpace(name); len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	g, err := newGenerator(Options{Modules: names, Dialect: *dialect, Package: *pkg})
+	if err != nil {
+		return err
+	}
+	files, err := g.Files()
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	if !*force {
+		for _, name := range paths {
+			path := filepath.Join(*dir, filepath.FromSlash(name))
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+			}
+		}
+	}
+
+	for _, name := range paths {
+		path := filepath.Join(*dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, files[name], 0644); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "wrote", path)
+	}
+
+	return nil
+}
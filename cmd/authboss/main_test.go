@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	g, err := newGenerator(Options{
+		Modules: []string{"auth", "register", "confirm", "recover", "lock", "remember", "logout", "totp2fa", "sms2fa"},
+		Dialect: "postgres",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := g.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"user.go", "storer.go", "main.go"} {
+		if _, err := parser.ParseFile(token.NewFileSet(), name, files[name], 0); err != nil {
+			t.Errorf("%s doesn't parse: %v", name, err)
+		}
+	}
+
+	user := string(files["user.go"])
+	for _, method := range []string{"GetPassword", "PutConfirmSelector", "GetRecoverExpiry", "PutLocked", "GetTOTPLastCode", "PutSMSPhoneNumber"} {
+		if !strings.Contains(user, "func (u User) "+method) && !strings.Contains(user, "func (u *User) "+method) {
+			t.Error("the user is missing", method)
+		}
+	}
+	if strings.Count(user, "func (u User) GetRecoveryCodes") != 1 {
+		t.Error("fields needed by many modules should only be generated once")
+	}
+
+	storer := string(files["storer.go"])
+	for _, method := range []string{"Create", "LoadByConfirmSelector", "LoadByRecoverSelector", "UseRememberToken"} {
+		if !strings.Contains(storer, ") "+method+"(") {
+			t.Error("the storer is missing", method)
+		}
+	}
+	if !strings.Contains(storer, "WHERE id = $16") {
+		t.Error("the postgres placeholders were wrong")
+	}
+
+	up := string(files["migrations/0001_authboss.up.sql"])
+	for _, column := range []string{"email TEXT NOT NULL UNIQUE", "recover_expiry TIMESTAMP", "CREATE TABLE remember_tokens"} {
+		if !strings.Contains(up, column) {
+			t.Error("the migration is missing", column)
+		}
+	}
+
+	if _, ok := files["mail/confirm_txt.tpl"]; !ok {
+		t.Error("the e-mail templates should be generated")
+	}
+}
+
+func TestGenerateMinimal(t *testing.T) {
+	t.Parallel()
+
+	g, err := newGenerator(Options{Modules: []string{"auth"}, Dialect: "sqlite", Package: "models"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := g.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := files["main.go"]; ok {
+		t.Error("main.go should only be generated for package main")
+	}
+	if _, ok := files["mail/confirm_txt.tpl"]; ok {
+		t.Error("there should be no e-mail templates")
+	}
+
+	storer := string(files["storer.go"])
+	if !strings.HasPrefix(strings.SplitN(storer, "\n", 3)[2], "package models") {
+		t.Error("the package was wrong")
+	}
+	if strings.Contains(storer, "Create(") || strings.Contains(storer, "$1") {
+		t.Error("the storer should only load and save users with sqlite placeholders")
+	}
+	if strings.Contains(string(files["user.go"]), `"time"`) {
+		t.Error("time should only be imported when it's used")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []Options{
+		{Modules: []string{"auth"}, Dialect: "oracle"},
+		{Modules: []string{"nope"}, Dialect: "postgres"},
+		{Modules: []string{"auth", "remember", "expire"}, Dialect: "postgres"},
+		{Modules: []string{"register"}, Dialect: "postgres"},
+	}
+
+	for _, test := range tests {
+		if _, err := newGenerator(test); err == nil {
+			t.Errorf("%v should fail", test)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "authboss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	args := []string{"-modules", "auth, register,recover", "-out", dir}
+	if err := run(args, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "migrations", "0001_authboss.up.sql")); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(out.String(), filepath.Join(dir, "user.go")) {
+		t.Error("the files written should be listed:", out.String())
+	}
+
+	if err := run(args, out); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Error("files should not be overwritten:", err)
+	}
+	if err := run(append(args, "-force"), out); err != nil {
+		t.Error(err)
+	}
+}
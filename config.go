@@ -1,10 +1,13 @@
 package authboss
 
 import (
+	"crypto"
 	"net/http"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/volatiletech/authboss/v3/jwt"
 )
 
 // Config holds all the configuration for both authboss and it's modules.
@@ -21,6 +24,10 @@ type Config struct {
 		// AuthLoginOK is the redirect path after a successful authentication.
 		AuthLoginOK string
 
+		// BackupEmailOK is where the user is redirected after verifying or
+		// removing their backup e-mail address.
+		BackupEmailOK string
+
 		// ConfirmOK once a user has confirmed their account
 		// this says where they should go
 		ConfirmOK string
@@ -30,6 +37,12 @@ type Config struct {
 
 		// LockNotOK is a path to go to when the user fails
 		LockNotOK string
+		// UnlockOK is where the user is redirected after unlocking their
+		// account with the link from the unlock e-mail.
+		UnlockOK string
+		// SuspendNotOK is where the suspend module sends suspended users
+		// that log in or use a route behind suspend.Middleware.
+		SuspendNotOK string
 
 		// LogoutOK is the redirect path after a log out.
 		LogoutOK string
@@ -39,6 +52,17 @@ type Config struct {
 		// OAuth2LoginNotOK is the redirect path after
 		// an unsuccessful oauth2 login
 		OAuth2LoginNotOK string
+		// SAMLLoginOK is the redirect path after a successful saml login
+		SAMLLoginOK string
+		// SAMLLoginNotOK is the redirect path after an unsuccessful saml
+		// login
+		SAMLLoginNotOK string
+		// OAuth2LinkOK is the redirect path after an oauth2 account
+		// has been linked to or unlinked from a user
+		OAuth2LinkOK string
+		// OAuth2LinkNotOK is the redirect path after an oauth2 account
+		// could not be linked or unlinked
+		OAuth2LinkNotOK string
 
 		// RecoverOK is the redirect path after a successful recovery of a
 		// password.
@@ -47,6 +71,23 @@ type Config struct {
 		// RegisterOK is the redirect path after a successful registration.
 		RegisterOK string
 
+		// RenameOK is where the user is redirected after changing their
+		// name with the rename module.
+		RenameOK string
+
+		// AccountTypeOK maps account types to where users of that type are
+		// redirected after registering or logging in, in place of
+		// RegisterOK and AuthLoginOK. See Modules.AccountTypes.
+		AccountTypeOK map[string]string
+
+		// SessionLimitNotOK is where users are redirected when their login
+		// was rejected because they're logged in with too many sessions.
+		SessionLimitNotOK string
+
+		// LockdownNotOK is where users are redirected when the module they
+		// used is locked down, see Authboss.Lockdown.
+		LockdownNotOK string
+
 		// RootURL is the scheme+host+port of the web application
 		// (eg https://www.happiness.com:8080) for url generation.
 		// No trailing slash.
@@ -57,12 +98,73 @@ type Config struct {
 		// their e-mail OR when they've completed the first step towards
 		// verification and need to check their e-mail to proceed.
 		TwoFactorEmailAuthNotOK string
+
+		// Redirects decides where each module redirects to after each of
+		// its outcomes (see RedirectOK and the other outcomes), for example
+		// RedirectKey{"confirm", RedirectOK}. The paths above are used for
+		// the ones that aren't in it.
+		Redirects map[RedirectKey]RedirectFunc
 	}
 
 	Modules struct {
+		// BackupEmailKey is the key the backupemail module signs the
+		// tokens of its verification links with (HMAC-SHA512).
+		BackupEmailKey []byte
+		// BackupEmailTokenDuration is how long the verification links of
+		// backup e-mail addresses are valid for.
+		BackupEmailTokenDuration time.Duration
+
 		// BCryptCost is the cost of the bcrypt password hashing function.
 		BCryptCost int
 
+		// ChallengeVerifier if set requires clients to solve a challenge
+		// (see ChallengeVerifier) to log in or start a password recovery.
+		ChallengeVerifier ChallengeVerifier
+		// ChallengeRequired decides whether a challenge is required for the
+		// request to the page, for example only for suspicious traffic. If
+		// it's nil a challenge is always required when ChallengeVerifier
+		// is set, except for logins when the lock module requires them
+		// after failures (see LockChallengeAfter).
+		ChallengeRequired func(r *http.Request, page string) bool
+
+		// CSRFDisabled turns off the CSRF protection of authboss' POST and
+		// DELETE routes, for apps that already protect them with their own
+		// middleware.
+		CSRFDisabled bool
+		// CSRFField is the form field the CSRF token is posted in
+		CSRFField string
+		// CSRFHeader is the header the CSRF token is sent in by API clients
+		// that post JSON
+		CSRFHeader string
+
+		// ConfirmTokenDuration is how long confirm tokens are valid for, if
+		// it's 0 they never expire. Users must implement
+		// ExpiringConfirmableUser when it's set. Confirming with an expired
+		// token sends the user a new one.
+		ConfirmTokenDuration time.Duration
+		// ConfirmResendLimit is how many confirm e-mails can be resent to
+		// a user within ConfirmResendWindow, the resend route is only
+		// mounted when Storage.Counter is set to count them.
+		ConfirmResendLimit int
+		// ConfirmResendWindow is the window ConfirmResendLimit applies to
+		ConfirmResendWindow time.Duration
+		// ReconfirmAttributes are the attributes of a user (see
+		// AttributeEmail) that make the confirm module unconfirm the user
+		// and e-mail them a new confirm link when they change, either with
+		// Authboss.UserChanged or with the rename module (AttributeUsername,
+		// or AttributePID with RenamePID). Routes behind confirm.Middleware
+		// are off limits until they've confirmed again.
+		ReconfirmAttributes []string
+
+		// CredentialFields lets users log in and recover their account with
+		// any of several credentials, for example []string{"email",
+		// "username"}. The PID entered is looked up with the storer's
+		// LoadByAny (see AnyCredentialServerStorer) which must be
+		// implemented when this is set. Since a user may enter either, it's
+		// best to set PIDField to something neutral like "login" so the
+		// form field isn't validated as an e-mail address.
+		CredentialFields []string
+
 		// ConfirmMethod IS DEPRECATED! See MailRouteMethod instead.
 		//
 		// ConfirmMethod controls which http method confirm expects.
@@ -74,6 +176,52 @@ type Config struct {
 		// ExpireAfter controls the time an account is idle before being
 		// logged out by the ExpireMiddleware.
 		ExpireAfter time.Duration
+		// ExpireMaxAge if set is the longest a session can last after the
+		// user logged in, regardless of activity, before being logged out
+		// by the ExpireMiddleware.
+		ExpireMaxAge time.Duration
+		// ExpireWarnBefore if set makes the ExpireMiddleware tell the views
+		// how long is left in the session once it's within this duration
+		// of expiring, see expire.DataExpiresIn.
+		ExpireWarnBefore time.Duration
+		// ExpireRefreshAfter if set makes the ExpireMiddleware only update
+		// the session's last action once it's older than this, so that busy
+		// clients don't write the session on every request. Idle sessions
+		// can then expire up to this much sooner than ExpireAfter.
+		ExpireRefreshAfter time.Duration
+		// ExpireRefresh if set decides whether the ExpireMiddleware updates
+		// the session's last action on a request, instead of
+		// ExpireRefreshAfter. lastAction is zero if there isn't one.
+		ExpireRefresh func(r *http.Request, lastAction time.Time) bool
+
+		// IPPolicy decides which addresses can use the auth, register and
+		// recover routes, see IPPolicyGuard. The zero value allows all of
+		// them.
+		IPPolicy IPPolicy
+		// RouteIPPolicies are the policies of specific routes by their path
+		// under the mount (like "/register"), they're used instead of
+		// IPPolicy for the route.
+		RouteIPPolicies map[string]IPPolicy
+		// TrustedProxies are the networks of the proxies in front of the app
+		// (as CIDRs or addresses), the client's address is taken from the
+		// ClientIPHeader of requests from them (see Authboss.ClientIP).
+		TrustedProxies []string
+		// ClientIPHeader is the header trusted proxies put the client's
+		// address in.
+		ClientIPHeader string
+		// ForwardedProtoHeader, ForwardedHostHeader and
+		// ForwardedPrefixHeader are the headers trusted proxies put the
+		// scheme, host and path prefix the client used in. The request's
+		// Paths.RootURL is made from them, and redirects get the prefix
+		// (see Authboss.ForwardedRootURL). They're ignored when they're
+		// empty, which they are by default.
+		ForwardedProtoHeader  string
+		ForwardedHostHeader   string
+		ForwardedPrefixHeader string
+		// AllowedHosts are the hosts the ForwardedHostHeader can name (with
+		// a port, or without one to allow any port), other hosts are
+		// ignored. No forwarded host is used when it's empty.
+		AllowedHosts []string
 
 		// LockAfter this many tries.
 		LockAfter int
@@ -81,6 +229,29 @@ type Config struct {
 		LockWindow time.Duration
 		// LockDuration is how long an account is locked for.
 		LockDuration time.Duration
+		// LockUnlockKey if set makes the lock module e-mail users a link
+		// to unlock their account when it's locked, rather than having to
+		// wait out the LockDuration. The link's token is signed with
+		// HMAC-SHA512 under this key. Users must be UnlockableUsers.
+		LockUnlockKey []byte
+		// LockChallengeAfter if set requires a challenge (see
+		// ChallengeVerifier) to log in to an account that has failed to log
+		// in this many times within LockWindow, as a softer step before it's
+		// locked after LockAfter tries. The challenge is checked before the
+		// password. Since it's only required for accounts that exist it's
+		// best left off with PreventUserEnumeration.
+		LockChallengeAfter int
+		// LockChallengeAfterIP if set requires a challenge to log in from an
+		// address (see Authboss.ClientIP) that this many logins have failed
+		// from within LockWindow. The failures are counted in
+		// Storage.Counter.
+		LockChallengeAfterIP int
+
+		// LockdownHandler responds to the requests for the routes of locked
+		// down modules (see Authboss.Lockdown) in place of the default
+		// redirect to Paths.LockdownNotOK with TxtLockdown, for example with
+		// a 503 maintenance page.
+		LockdownHandler http.Handler
 
 		// LogoutMethod is the method the logout route should use
 		// (default should be DELETE)
@@ -113,6 +284,58 @@ type Config struct {
 		// and it may interrupt your use of the context that the Authboss module
 		// is passing to you, preventing proper use of it.
 		MailNoGoroutine bool
+		// MailFailurePolicy is what happens when an e-mail that a flow
+		// depends on (confirming, recovering and double opt-in
+		// registration) can't be sent, see MailFailurePolicy.
+		MailFailurePolicy MailFailurePolicy
+		// MailRetries is how many times sending an e-mail is retried with
+		// MailFailureRetry.
+		MailRetries int
+		// MailRetryBackoff is how long to wait before retrying an e-mail,
+		// it doubles with each retry.
+		MailRetryBackoff time.Duration
+
+		// NotifyLock makes the notify module e-mail users when their
+		// account is locked.
+		NotifyLock bool
+		// NotifyPasswordChange makes the notify module e-mail users when
+		// their password is changed.
+		NotifyPasswordChange bool
+		// NotifyTwoFactor makes the notify module e-mail users when a
+		// second factor is added to or removed from their account.
+		NotifyTwoFactor bool
+		// NotifyNewDevice makes the notify module e-mail users when they
+		// log in from a device they haven't logged in from before.
+		NotifyNewDevice bool
+
+		// OTPRequirePassword if set is asked when a user without a password
+		// logs in with a one time password whether they must set a password
+		// before they're logged in, so that accounts don't stay recoverable
+		// by e-mail only. Returning true sends them to /otp/password.
+		OTPRequirePassword func(r *http.Request, user User) bool
+
+		// PIDField is the name of the form field the user's PID is entered
+		// in when it's neither an e-mail address nor a username, for example
+		// employee_id or phone. defaults.SetCore reads the PID from it and
+		// ModuleListMiddleware passes it to the views as DataPIDField so the
+		// templates can name their inputs after it.
+		PIDField string
+
+		// PIDNormalizer if set is applied to the PIDs users enter to
+		// register, log in and recover their account with, so that
+		// "User@Example.com " and "user@example.com" are the same account.
+		// LowerTrimPID lowercases and trims, apps that want unicode NFKC
+		// normalization as well can wrap golang.org/x/text/unicode/norm.
+		PIDNormalizer func(pid string) string
+
+		// PreventUserEnumeration makes login, recover and register respond
+		// the same way and take comparable time whether or not an account
+		// exists. Unknown users get a dummy password comparison, recover
+		// and register use generic "if an account exists we e-mailed you"
+		// messages and failed logins that lock an account aren't told so.
+		// Register can only respond uniformly with the confirm module
+		// loaded, since otherwise new users are logged in straight away.
+		PreventUserEnumeration bool
 
 		// RegisterPreserveFields are fields used with registration that are
 		// to be rendered when post fails in a normal way
@@ -128,6 +351,109 @@ type Config struct {
 		// then it would be available to be whitelisted by this
 		// configuration variable.
 		RegisterPreserveFields []string
+		// RegisterExtraFields declares the fields of the register form besides
+		// the pid and the password. The BodyReader parses and validates them,
+		// see ExtraFieldsValuer, and register gives them to the user with
+		// ExtraFieldsUser.PutExtraFields. defaults.SetCore passes them to the
+		// defaults' body reader, so set them before calling it.
+		RegisterExtraFields []ExtraField
+		// RegisterVerifyKey if set turns on double opt-in registration:
+		// instead of creating the user, register e-mails them a link and the
+		// user is only created once it's followed. Until then the
+		// registration is kept in the link's token, encrypted with AES-GCM
+		// under this key. Users must be ConfirmableUsers.
+		RegisterVerifyKey []byte
+		// RegisterVerifyDuration is how long the link sent for double
+		// opt-in registration works for.
+		RegisterVerifyDuration time.Duration
+		// RegisterHoneypot if set is the name of a form field on the
+		// register page that's hidden from people, registrations that fill
+		// it in are rejected as bots.
+		RegisterHoneypot string
+		// RegisterMinFillTime if set rejects registrations submitted sooner
+		// than this after the register page was rendered as bots. The time
+		// is kept in the form signed with RegisterFormKey.
+		RegisterMinFillTime time.Duration
+		// RegisterFormKey signs the time the register page was rendered
+		// with HMAC-SHA512, it's required for RegisterMinFillTime.
+		RegisterFormKey []byte
+		// AccountTypes if set are the account types (eg. buyer and seller)
+		// users choose from when registering, registering then fails
+		// validation without one of them. The register values must be an
+		// AccountTypeValuer and the user an AccountTypeUser.
+		AccountTypes []string
+
+		// RolePermissions are the permissions each role grants, for
+		// RequirePermissions. Users get their roles from RolesUser.
+		RolePermissions map[string][]string
+		// Authorize if set decides whether the user may use a route
+		// protected by RequireRoles, RequirePermissions or
+		// RequireAuthorization instead of checking the user's roles. It
+		// can use Authboss.HasAuthorization for the default decision.
+		Authorize func(r *http.Request, user User, need Authorization) (bool, error)
+
+		// SessionLimit is how many sessions a user can be logged in with at
+		// once when the sessionlimit module is loaded, 0 is no limit.
+		SessionLimit int
+		// SessionLimitRejectNew decides what happens when a user with
+		// SessionLimit sessions logs in again. If it returns true the new
+		// login is rejected, otherwise the oldest sessions are logged out to
+		// make room for it. If it's nil the oldest sessions are logged out.
+		SessionLimitRejectNew func(r *http.Request, pid string, sessions []SessionRecord) bool
+		// SessionLimitIdle is how long a session can go unused before its
+		// record is taken to be stale (its browser was closed or the
+		// session expired): stale sessions don't count towards the limit
+		// and are logged out by the sessionlimit middleware. Set it to no
+		// less than how long the app's sessions last, 0 keeps records until
+		// the user logs out.
+		SessionLimitIdle time.Duration
+
+		// SprayThreshold is how many accounts one password can be tried
+		// against from a network within SprayWindow before the spray
+		// module considers it a password spray.
+		SprayThreshold int
+		// SprayWindow is how long password attempts are counted for, and
+		// how long the friction lasts once a spray is detected.
+		SprayWindow time.Duration
+		// SprayTarpit is how long every login attempt is delayed while
+		// a spray is ongoing.
+		SprayTarpit time.Duration
+
+		// RememberTokenKey if set hashes remember tokens with HMAC-SHA512
+		// under this key instead of plain SHA512, so the stored hashes can't
+		// be checked against guessed tokens without the key. Setting or
+		// changing it invalidates the tokens that are already stored.
+		RememberTokenKey []byte
+
+		// RememberIdleTimeout if set only lets remember tokens log users in
+		// if they were issued within this duration. Each use issues a new
+		// token so this is a sliding expiration that's renewed by activity.
+		RememberIdleTimeout time.Duration
+		// RememberMaxLifetime if set is how long after logging in users can
+		// be logged in by remember tokens, regardless of activity. After
+		// this they must log in again.
+		RememberMaxLifetime time.Duration
+		// RememberInBody lets clients that can't store cookies ask for the
+		// remember token in the response body (see RememberInBodyValuer)
+		// and send it back in an "Authorization: Remember <token>" header.
+		// The rotated token is returned in the Remember-Token header.
+		RememberInBody bool
+
+		// RiskAssessor decides what the risk module does about each login
+		// once the password was checked.
+		RiskAssessor RiskAssessor
+		// RiskGeoHint if set finds where a request comes from for
+		// RiskSignals.GeoHint.
+		RiskGeoHint func(r *http.Request) string
+		// RiskCodeKey is the key the codes the risk module e-mails are
+		// signed with (HMAC-SHA256) so they aren't kept in the session.
+		RiskCodeKey []byte
+		// RiskCodeLifetime is how long the e-mailed codes are valid for.
+		RiskCodeLifetime time.Duration
+		// RiskCodeAttempts is how many times a code can be entered before
+		// the user has to log in again, they're counted in the
+		// Storage.Counter.
+		RiskCodeAttempts int
 
 		// RecoverTokenDuration controls how long a token sent via
 		// email for password recovery is valid for.
@@ -136,20 +462,91 @@ type Config struct {
 		// recovery, if false they will be redirected and need to log in
 		// again manually.
 		RecoverLoginAfterRecovery bool
+		// RecoverVerifier if set asks users to answer a prompt along with
+		// their new password before it's reset, see RecoverVerifier.
+		RecoverVerifier RecoverVerifier
+		// RecoverVerifyAttempts is how many wrong answers to the
+		// RecoverVerifier are allowed before the recovery token stops
+		// working, they're counted in the Storage.Counter.
+		RecoverVerifyAttempts int
+
+		// RenamePID makes the rename module change the user's pid rather
+		// than their username, it needs a RenamingServerStorer that can
+		// ChangePID. The user is logged out everywhere else after it.
+		RenamePID bool
+		// RenameCooldown is how long users must wait after changing their
+		// name before they can change it again.
+		RenameCooldown time.Duration
+
+		// ExportReauthWindow is how recently users without a password
+		// must have logged in to export their data, users with one enter
+		// it again instead.
+		ExportReauthWindow time.Duration
 
 		// OAuth2Providers lists all providers that can be used. See
 		// OAuthProvider documentation for more details.
 		OAuth2Providers map[string]OAuth2Provider
+		// OAuth2StateDuration is how long users have to come back from the
+		// provider after starting to log in with it, the state in the
+		// session can't be used after that.
+		OAuth2StateDuration time.Duration
+
+		// SAMLProviders lists the SAML identity providers users can log in
+		// with, see SAMLProvider and the saml module. Their names must not
+		// be used by OAuth2Providers too since SAML users are stored like
+		// oauth2 users.
+		SAMLProviders map[string]SAMLProvider
+		// SAMLEntityID is the entity id the identity providers know the app
+		// (the service provider) by. If it's empty it's the url of the
+		// provider's metadata, Paths.RootURL + /saml/metadata/{provider}.
+		SAMLEntityID string
 
 		// TwoFactorEmailAuthRequired forces users to first confirm they have
 		// access to their e-mail with the current device by clicking a link
 		// and confirming a token stored in the session.
 		TwoFactorEmailAuthRequired bool
 
+		// TokenAccessLifetime is how long an access token issued by the
+		// token module is valid for.
+		TokenAccessLifetime time.Duration
+		// TokenRefreshLifetime is how long a refresh token issued by the
+		// token module is valid for.
+		TokenRefreshLifetime time.Duration
+		// TokenSigner if set makes the token module issue access tokens as
+		// JWTs signed with it, which can be verified without a database
+		// lookup but can't be revoked before they expire. If it's nil opaque
+		// access tokens are issued and stored with the TokenServerStorer.
+		TokenSigner jwt.Signer
+		// TokenVerifyKey verifies the JWTs signed by TokenSigner, it's the
+		// secret for HS256 or the public key otherwise.
+		TokenVerifyKey crypto.PublicKey
+
+		// APIKeyPrefix starts every api key created by the apikey module,
+		// it tells its middleware which bearer tokens are api keys and
+		// lets secret scanners find leaked keys.
+		APIKeyPrefix string
+		// APIKeyLifetime is how long an api key is valid for, keys don't
+		// expire when it's zero.
+		APIKeyLifetime time.Duration
+
+		// CertResolver maps the verified TLS client certificate of a request
+		// to the pid of its user for the certauth module, see CertBySAN and
+		// CertByFingerprint.
+		CertResolver CertResolver
+
 		// TOTP2FAIssuer is the issuer that appears in the url when scanning
 		// a qr code for google authenticator.
 		TOTP2FAIssuer string
 
+		// Webhooks are the endpoints the webhook module posts events to
+		Webhooks []Webhook
+		// WebhookRetries is how many times a webhook delivery that fails is
+		// retried.
+		WebhookRetries int
+		// WebhookBackoff is how long to wait before retrying a webhook
+		// delivery, it doubles with each retry.
+		WebhookBackoff time.Duration
+
 		// DEPRECATED: See ResponseOnUnauthed
 		// RoutesRedirectOnUnauthed controls whether or not a user is redirected
 		// or given a 404 when they are unauthenticated and attempting to access
@@ -202,11 +599,37 @@ type Config struct {
 		// from the request.
 		SessionState ClientStateReadWriter
 
+		// SessionStateCookie and CookieStateCookie are the attributes of the
+		// cookies SessionState and CookieState keep their state in when they
+		// implement CookieConfigurer. By default they're Secure, HttpOnly,
+		// SameSite=Lax and for the Path "/" and Init rejects combinations
+		// browsers would ignore. A cross-site login (like a saml identity
+		// provider's post) needs a SameSite None session cookie.
+		SessionStateCookie CookieConfig
+		CookieStateCookie  CookieConfig
+
 		// SessionStateWhitelistKeys are set to preserve keys in the session
 		// when authboss.DelAllSession is called. A correct implementation
 		// of ClientStateReadWriter will delete ALL session key-value pairs
 		// unless that key is whitelisted here.
 		SessionStateWhitelistKeys []string
+
+		// Attributes maps authboss' attribute names to the names the
+		// Server storer uses for them. It's used by defaults.MapUser and
+		// can be used by custom storers through Authboss.Attribute.
+		Attributes AttributeMap
+
+		// Counter counts things across all instances of the app, it's
+		// required by the spray module.
+		Counter CounterStorer
+
+		// ReadOnly is for running against a read replica while the primary
+		// database is down. Logged in users keep working but every
+		// route that writes to the ServerStorer (register, login, recovery,
+		// token issuance and so on) is rejected with a friendly error, and
+		// remember me tokens aren't used since they're rotated on use. It
+		// must be set before Init.
+		ReadOnly bool
 	}
 
 	Core struct {
@@ -230,6 +653,15 @@ type Config struct {
 		// be able to get data from the user's client.
 		BodyReader BodyReader
 
+		// NoRender is for API only deployments that have no templates, every
+		// response is JSON. ViewRenderer and MailRenderer may be left nil
+		// (though modules that send e-mail still need a MailRenderer) and
+		// Init fails if options that only make sense for html pages are
+		// used: MailRouteMethod must be POST so the front-end can post the
+		// token from e-mailed links, and ResponseOnUnauthed must not be
+		// RespondRedirect.
+		NoRender bool
+
 		// ViewRenderer loads the templates for the application.
 		ViewRenderer Renderer
 		// MailRenderer loads the templates for mail. If this is nil, it will
@@ -243,6 +675,60 @@ type Config struct {
 		// also implement the ContextLogger to be able to upgrade to a
 		// request specific logger.
 		Logger Logger
+
+		// Metrics receives the gauges reported by CollectMetrics and the
+		// counters and histograms reported by the modules. If it's nil no
+		// metrics are collected.
+		Metrics Metrics
+
+		// Tracer starts spans for handlers, events, storer loads and saves
+		// and e-mails sent. If it's nil nothing is traced.
+		Tracer Tracer
+
+		// Localizer translates the messages shown to users. If it's nil
+		// they're in english.
+		Localizer Localizer
+		// Locale detects the locale of a request, for example from its
+		// Accept-Language header, it's put in the context of the modules'
+		// requests (see Authboss.Locale). If it's nil the locale is only
+		// what the app puts in the context under CTXKeyLocale.
+		Locale func(r *http.Request) string
+
+		// Clock is what the modules read the time from to issue and expire
+		// tokens, sessions and remember me logins, count login attempts,
+		// lock accounts and validate totp codes. If it's nil it's the
+		// system clock, tests can set a fake one to move time forward.
+		Clock Clock
+
+		// TenantResolver finds the tenant of each request so one Authboss
+		// can serve many tenants. LoadClientState puts the tenant in the
+		// context (see Tenant) for the storers, and it scopes the cookies
+		// and the confirm and recover tokens to the tenant. If it's nil
+		// there are no tenants.
+		TenantResolver TenantResolver
+
+		// ConfigOverride if set changes a copy of the Config for each
+		// request, like a different Paths.RootURL or Mail.From for each
+		// brand or locale. LoadClientState calls it after the tenant is
+		// resolved and puts the copy in the context, see RequestConfig.
+		ConfigOverride ConfigOverride
+
+		// ExternalAuthenticator if set checks the passwords of users logging
+		// in with the auth module instead of the hash stored with the user,
+		// for logging in with a corporate directory (see the ldap package).
+		// The users must still be in the ServerStorer but they don't need
+		// to be AuthableUsers.
+		ExternalAuthenticator ExternalAuthenticator
+
+		// SecretEncryptor if set encrypts the secrets of second factors
+		// (the totp2fa secret and the sms2fa phone number) before they're
+		// stored, see defaults.AESSecretEncryptor. Secrets that were stored
+		// in plaintext are encrypted when they're next used.
+		SecretEncryptor SecretEncryptor
+
+		// TokenGenerator creates the confirm, recover and remember me
+		// tokens. If it's nil they're made by the DefaultTokenGenerator.
+		TokenGenerator TokenGenerator
 	}
 }
 
@@ -251,25 +737,66 @@ func (c *Config) Defaults() {
 	c.Paths.Mount = "/auth"
 	c.Paths.NotAuthorized = "/"
 	c.Paths.AuthLoginOK = "/"
+	c.Paths.BackupEmailOK = "/"
 	c.Paths.ConfirmOK = "/"
 	c.Paths.ConfirmNotOK = "/"
 	c.Paths.LockNotOK = "/"
+	c.Paths.UnlockOK = "/"
+	c.Paths.SuspendNotOK = "/"
 	c.Paths.LogoutOK = "/"
 	c.Paths.OAuth2LoginOK = "/"
 	c.Paths.OAuth2LoginNotOK = "/"
+	c.Paths.SAMLLoginOK = "/"
+	c.Paths.SAMLLoginNotOK = "/"
+	c.Paths.OAuth2LinkOK = "/"
+	c.Paths.OAuth2LinkNotOK = "/"
 	c.Paths.RecoverOK = "/"
 	c.Paths.RegisterOK = "/"
+	c.Paths.RenameOK = "/"
+	c.Paths.SessionLimitNotOK = "/"
+	c.Paths.LockdownNotOK = "/"
 	c.Paths.RootURL = "http://localhost:8080"
 	c.Paths.TwoFactorEmailAuthNotOK = "/"
 
+	c.Storage.SessionStateCookie = CookieConfig{Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+	c.Storage.CookieStateCookie = CookieConfig{Path: "/", Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+
+	c.Modules.BackupEmailTokenDuration = 24 * time.Hour
 	c.Modules.BCryptCost = bcrypt.DefaultCost
 	c.Modules.ConfirmMethod = http.MethodGet
+	c.Modules.CSRFField = "csrf_token"
+	c.Modules.CSRFHeader = "X-CSRF-Token"
+	c.Modules.ClientIPHeader = "X-Forwarded-For"
+	c.Modules.ConfirmResendLimit = 3
+	c.Modules.ConfirmResendWindow = time.Hour
 	c.Modules.ExpireAfter = time.Hour
+	c.Modules.SessionLimitIdle = 24 * time.Hour
 	c.Modules.LockAfter = 3
 	c.Modules.LockWindow = 5 * time.Minute
 	c.Modules.LockDuration = 12 * time.Hour
 	c.Modules.LogoutMethod = "DELETE"
 	c.Modules.MailRouteMethod = http.MethodGet
+	c.Modules.NotifyLock = true
+	c.Modules.NotifyPasswordChange = true
+	c.Modules.NotifyTwoFactor = true
+	c.Modules.NotifyNewDevice = true
 	c.Modules.RecoverLoginAfterRecovery = false
 	c.Modules.RecoverTokenDuration = 24 * time.Hour
+	c.Modules.RecoverVerifyAttempts = 3
+	c.Modules.RegisterVerifyDuration = 24 * time.Hour
+	c.Modules.RenameCooldown = 7 * 24 * time.Hour
+	c.Modules.ExportReauthWindow = 5 * time.Minute
+	c.Modules.OAuth2StateDuration = 10 * time.Minute
+	c.Modules.RiskCodeLifetime = 10 * time.Minute
+	c.Modules.RiskCodeAttempts = 5
+	c.Modules.SprayThreshold = 10
+	c.Modules.SprayWindow = time.Hour
+	c.Modules.SprayTarpit = 2 * time.Second
+	c.Modules.TokenAccessLifetime = 15 * time.Minute
+	c.Modules.TokenRefreshLifetime = 30 * 24 * time.Hour
+	c.Modules.APIKeyPrefix = "abk_"
+	c.Modules.MailRetries = 3
+	c.Modules.MailRetryBackoff = 10 * time.Second
+	c.Modules.WebhookRetries = 3
+	c.Modules.WebhookBackoff = time.Second
 }
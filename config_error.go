@@ -0,0 +1,160 @@
+package authboss
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigProblem is something wrong with the Config that Init found before
+// initializing the modules
+type ConfigProblem struct {
+	// Module that needs the field, empty for authboss itself
+	Module string
+	// Field is the path of the field in the Config, like "Core.Mailer"
+	Field string
+	// Problem is what's wrong with it, like "is not set"
+	Problem string
+}
+
+// String describes the problem, e.g.
+// "confirm: Config.Core.Mailer is not set"
+func (p ConfigProblem) String() string {
+	s := fmt.Sprintf("Config.%s %s", p.Field, p.Problem)
+	if len(p.Module) != 0 {
+		s = p.Module + ": " + s
+	}
+	return s
+}
+
+// ConfigError is returned by Init when the Config can't work with the
+// modules being loaded. It lists every problem that was found rather than
+// only the first one.
+type ConfigError []ConfigProblem
+
+// Error satisfies the error interface.
+func (c ConfigError) Error() string {
+	lines := make([]string, len(c))
+	for i, p := range c {
+		lines[i] = p.String()
+	}
+	return "invalid authboss config:\n\t" + strings.Join(lines, "\n\t")
+}
+
+// ModuleValidator can be implemented by modules to check the Config before
+// any module is initialized, so that Init can report everything that's
+// missing at once instead of a module failing to load, or panicking at
+// request time. ValidateConfig is called on the registered module and
+// doesn't need to fill in ConfigProblem.Module.
+type ModuleValidator interface {
+	ValidateConfig(*Authboss) []ConfigProblem
+}
+
+// Missing returns a problem for each of the fields that isn't set. Fields
+// are named by their path in the Config: "Core.Mailer", "Storage.Server".
+func (c *Config) Missing(fields ...string) []ConfigProblem {
+	var problems []ConfigProblem
+	for _, field := range fields {
+		if c.field(field).IsZero() {
+			problems = append(problems, ConfigProblem{Field: field, Problem: "is not set"})
+		}
+	}
+
+	return problems
+}
+
+// NotImplementing returns a problem when the field is set but doesn't
+// implement the interface that iface points to, for example:
+//
+//	c.NotImplementing("Storage.Server", (*CreatingServerStorer)(nil))
+//
+// A field that isn't set is left to Missing.
+func (c *Config) NotImplementing(field string, iface interface{}) []ConfigProblem {
+	value := c.field(field)
+	if value.IsNil() {
+		return nil
+	}
+
+	ifaceType := reflect.TypeOf(iface).Elem()
+	if value.Elem().Type().Implements(ifaceType) {
+		return nil
+	}
+
+	return []ConfigProblem{{
+		Field:   field,
+		Problem: fmt.Sprintf("(%s) must implement %s", value.Elem().Type(), ifaceType),
+	}}
+}
+
+// MissingLinkURL returns a problem when neither Paths.RootURL nor
+// Mail.RootURL are set, modules that e-mail links need one of them.
+func (c *Config) MissingLinkURL() []ConfigProblem {
+	if len(c.Paths.RootURL) != 0 || len(c.Mail.RootURL) != 0 {
+		return nil
+	}
+
+	return []ConfigProblem{{Field: "Paths.RootURL", Problem: "or Config.Mail.RootURL must be set for the links in e-mails"}}
+}
+
+// field finds a field of the config by its path, it panics if there's no
+// such field since that's a mistake in the module asking for it
+func (c *Config) field(path string) reflect.Value {
+	value := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(path, ".") {
+		value = value.FieldByName(name)
+		if !value.IsValid() {
+			panic("authboss config has no field: " + path)
+		}
+	}
+
+	return value
+}
+
+// validateConfig checks the parts of the config authboss itself uses and
+// asks the modules about what they need
+func (a *Authboss) validateConfig(modules []string) error {
+	var problems []ConfigProblem
+
+	for _, field := range []string{"Paths.RootURL", "Mail.RootURL"} {
+		rootURL := a.Config.field(field).String()
+		if len(rootURL) == 0 {
+			continue
+		}
+		if u, err := url.Parse(rootURL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			problems = append(problems, ConfigProblem{Field: field, Problem: fmt.Sprintf("(%q) must be an absolute url like https://example.com", rootURL)})
+		}
+	}
+
+	problems = append(problems, a.Config.Storage.SessionStateCookie.problems("Storage.SessionStateCookie")...)
+	problems = append(problems, a.Config.Storage.CookieStateCookie.problems("Storage.CookieStateCookie")...)
+	problems = append(problems, a.Config.Modules.IPPolicy.problems("Modules.IPPolicy")...)
+	for route, policy := range a.Config.Modules.RouteIPPolicies {
+		problems = append(problems, policy.problems("Modules.RouteIPPolicies["+route+"]")...)
+	}
+	problems = append(problems, networkProblems("Modules.TrustedProxies", a.Config.Modules.TrustedProxies)...)
+
+	sorted := make([]string, len(modules))
+	copy(sorted, modules)
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		validator, ok := registeredModules[name].(ModuleValidator)
+		if !ok {
+			continue
+		}
+
+		for _, p := range validator.ValidateConfig(a) {
+			if len(p.Module) == 0 {
+				p.Module = name
+			}
+			problems = append(problems, p)
+		}
+	}
+
+	if len(problems) != 0 {
+		return ConfigError(problems)
+	}
+	return nil
+}
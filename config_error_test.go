@@ -0,0 +1,94 @@
+package authboss
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigMissing(t *testing.T) {
+	t.Parallel()
+
+	c := &Config{}
+	c.Core.Logger = mockLogger{}
+
+	problems := c.Missing("Core.Logger", "Core.Mailer", "Paths.Mount")
+	if len(problems) != 2 {
+		t.Fatalf("wrong number of problems: %v", problems)
+	}
+	if p := problems[0].String(); p != "Config.Core.Mailer is not set" {
+		t.Error("problem was wrong:", p)
+	}
+	if p := problems[1]; p.Field != "Paths.Mount" {
+		t.Error("field was wrong:", p.Field)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("an unknown field should panic")
+		}
+	}()
+	c.Missing("Core.Nope")
+}
+
+func TestConfigNotImplementing(t *testing.T) {
+	t.Parallel()
+
+	c := &Config{}
+	if problems := c.NotImplementing("Storage.Server", (*CreatingServerStorer)(nil)); len(problems) != 0 {
+		t.Error("a storer that isn't set is Missing's problem:", problems)
+	}
+
+	c.Storage.Server = newMockServerStorer()
+	if problems := c.NotImplementing("Storage.Server", (*CreatingServerStorer)(nil)); len(problems) != 0 {
+		t.Error("the storer can create users:", problems)
+	}
+
+	c.Storage.Server = struct{ ServerStorer }{newMockServerStorer()}
+	problems := c.NotImplementing("Storage.Server", (*CreatingServerStorer)(nil))
+	if len(problems) != 1 {
+		t.Fatalf("wrong number of problems: %v", problems)
+	}
+	if p := problems[0].Problem; p != "(struct { authboss.ServerStorer }) must implement authboss.CreatingServerStorer" {
+		t.Error("problem was wrong:", p)
+	}
+}
+
+func TestConfigMissingLinkURL(t *testing.T) {
+	t.Parallel()
+
+	c := &Config{}
+	if problems := c.MissingLinkURL(); len(problems) != 1 {
+		t.Error("one of the root urls should be needed")
+	}
+
+	c.Mail.RootURL = "https://example.com/app"
+	if problems := c.MissingLinkURL(); len(problems) != 0 {
+		t.Error("the mail root url should do:", problems)
+	}
+}
+
+func TestInitValidatesConfig(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Paths.RootURL = "localhost:8080"
+	ab.Config.Mail.RootURL = "/app"
+
+	err := ab.Init()
+	configErr, ok := err.(ConfigError)
+	if !ok {
+		t.Fatalf("expected a ConfigError: %#v", err)
+	}
+	if len(configErr) != 2 {
+		t.Fatalf("both root urls should be invalid: %v", configErr)
+	}
+	if !strings.Contains(err.Error(), `Config.Mail.RootURL ("/app") must be an absolute url`) {
+		t.Error("error was wrong:", err)
+	}
+
+	ab.Config.Paths.RootURL = "http://localhost:8080"
+	ab.Config.Mail.RootURL = ""
+	if err := ab.Init(); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,15 +3,13 @@ package confirm
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/sha512"
 	"crypto/subtle"
-	"encoding/base64"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/friendsofgo/errors"
 
@@ -21,6 +19,8 @@ import (
 const (
 	// PageConfirm is only really used for the BodyReader
 	PageConfirm = "confirm"
+	// PageConfirmResend is only really used for the BodyReader
+	PageConfirmResend = "confirm_resend"
 
 	// EmailConfirmHTML is the name of the html template for e-mails
 	EmailConfirmHTML = "confirm_html"
@@ -33,9 +33,6 @@ const (
 	// DataConfirmURL is the name of the e-mail template variable
 	// that gives the url to send to the user for confirmation.
 	DataConfirmURL = "url"
-
-	confirmTokenSize  = 64
-	confirmTokenSplit = confirmTokenSize / 2
 )
 
 func init() {
@@ -47,6 +44,24 @@ type Confirm struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what confirming needs
+func (c *Confirm) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.BodyReader", "Core.Responder",
+		"Core.Redirector", "Core.Logger", "Core.MailRenderer", "Core.Mailer", "Storage.Server", "Storage.SessionState")
+	problems = append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.ConfirmingServerStorer)(nil))...)
+	problems = append(problems, ab.Config.MissingLinkURL()...)
+
+	field, method := "Modules.ConfirmMethod", ab.Config.Modules.ConfirmMethod
+	if method == http.MethodGet {
+		field, method = "Modules.MailRouteMethod", ab.Config.Modules.MailRouteMethod
+	}
+	if method != http.MethodGet && method != http.MethodPost {
+		problems = append(problems, authboss.ConfigProblem{Field: field, Problem: "must be GET or POST"})
+	}
+
+	return problems
+}
+
 // Init module
 func (c *Confirm) Init(ab *authboss.Authboss) (err error) {
 	c.Authboss = ab
@@ -68,10 +83,19 @@ func (c *Confirm) Init(ab *authboss.Authboss) (err error) {
 	default:
 		panic("invalid config for ConfirmMethod/MailRouteMethod")
 	}
-	callbackMethod("/confirm", c.Authboss.Config.Core.ErrorHandler.Wrap(c.Get))
+	callbackMethod("/confirm", authboss.CSRFExempt(c.ReadOnlyGuard(c.Paths.ConfirmNotOK, c.Authboss.Config.Core.ErrorHandler.Wrap(c.Get))))
+
+	// Resending must be rate limited so it can't be used to flood inboxes
+	if c.Config.Storage.Counter != nil {
+		c.Authboss.Config.Core.Router.Post("/confirm/resend", c.Authboss.Config.Core.ErrorHandler.Wrap(c.ResendPost))
+	}
 
 	c.Events.Before(authboss.EventAuth, c.PreventAuth)
 	c.Events.After(authboss.EventRegister, c.StartConfirmationWeb)
+	if len(c.Config.Modules.ReconfirmAttributes) != 0 {
+		c.Events.After(authboss.EventUserChange, c.ReconfirmChange)
+		c.Events.After(authboss.EventRename, c.ReconfirmRename)
+	}
 
 	return nil
 }
@@ -96,8 +120,9 @@ func (c *Confirm) PreventAuth(w http.ResponseWriter, r *http.Request, handled bo
 	logger.Infof("user %s was not confirmed, preventing auth", user.GetPID())
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: c.Authboss.Config.Paths.ConfirmNotOK,
-		Failure:      "Your account has not been confirmed, please check your e-mail.",
+		RedirectPath: c.Authboss.RedirectPath(r, "confirm", authboss.RedirectNotOK, user, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmNotOK),
+		Failure:      c.Localize(r.Context(), authboss.TxtNotConfirmed),
+		FailureCode:  authboss.ErrorCodeUnconfirmed,
 	}
 	return true, c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
@@ -110,25 +135,102 @@ func (c *Confirm) StartConfirmationWeb(w http.ResponseWriter, r *http.Request, h
 		return false, err
 	}
 
+	// Users that registered with a verified e-mail (see
+	// Modules.RegisterVerifyKey) are already confirmed
 	cuser := authboss.MustBeConfirmable(user)
-	if err = c.StartConfirmation(r.Context(), cuser, true); err != nil {
+	if cuser.GetConfirmed() {
+		return false, nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: c.Authboss.RedirectPath(r, "confirm", authboss.RedirectNotOK, user, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmNotOK),
+		Success:      c.Localize(r.Context(), authboss.TxtConfirmSent),
+	}
+
+	err = c.StartConfirmation(r.Context(), cuser, true)
+	if _, ok := err.(authboss.MailError); ok {
+		ro.Success = ""
+		ro.Failure = c.Localize(r.Context(), authboss.TxtConfirmMailFailed)
+		return true, c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
 		return false, err
 	}
 
+	if c.Authboss.Config.Modules.PreventUserEnumeration {
+		// The register module says the same (TxtConfirmGeneric) when
+		// preventing user enumeration, it doesn't say whether the account
+		// was created
+		ro.Success = c.Localize(r.Context(), authboss.TxtConfirmGeneric)
+	}
+	return true, c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// ReconfirmChange makes the user confirm their account again when one of
+// Modules.ReconfirmAttributes is among the attributes that
+// authboss.UserChanged was called with.
+func (c *Confirm) ReconfirmChange(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	changed, _ := r.Context().Value(authboss.CTXKeyChangedAttributes).([]string)
+	return c.reconfirm(w, r, changed...)
+}
+
+// ReconfirmRename makes the user confirm their account again after they
+// changed their username (or pid with Modules.RenamePID) with the rename
+// module, when it's one of Modules.ReconfirmAttributes.
+func (c *Confirm) ReconfirmRename(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if c.Config.Modules.RenamePID {
+		return c.reconfirm(w, r, authboss.AttributePID)
+	}
+	return c.reconfirm(w, r, authboss.AttributeUsername)
+}
+
+// reconfirm starts confirmation on the current user if any of the changed
+// attributes need it and redirects them to ConfirmNotOK
+func (c *Confirm) reconfirm(w http.ResponseWriter, r *http.Request, changed ...string) (bool, error) {
+	if !c.needsReconfirm(changed) {
+		return false, nil
+	}
+
+	user, err := c.Authboss.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	c.Authboss.RequestLogger(r).Infof("user %s changed %v, they must confirm their account again", user.GetPID(), changed)
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: c.Authboss.Config.Paths.ConfirmNotOK,
-		Success:      "Please verify your account, an e-mail has been sent to you.",
+		RedirectPath: c.Authboss.RedirectPath(r, "confirm", authboss.RedirectNotOK, user, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmNotOK),
+		Success:      c.Localize(r.Context(), authboss.TxtReconfirmSent),
 	}
+
+	err = c.StartConfirmation(r.Context(), authboss.MustBeConfirmable(user), true)
+	if _, ok := err.(authboss.MailError); ok {
+		ro.Success = ""
+		ro.Failure = c.Localize(r.Context(), authboss.TxtConfirmMailFailed)
+	} else if err != nil {
+		return false, err
+	}
+
 	return true, c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+func (c *Confirm) needsReconfirm(changed []string) bool {
+	for _, attribute := range changed {
+		for _, reconfirm := range c.Config.Modules.ReconfirmAttributes {
+			if attribute == reconfirm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // StartConfirmation begins confirmation on a user by setting them to require
 // confirmation via a created token, and optionally sending them an e-mail.
 func (c *Confirm) StartConfirmation(ctx context.Context, user authboss.ConfirmableUser, sendEmail bool) error {
 	logger := c.Authboss.Logger(ctx)
 
-	selector, verifier, token, err := GenerateConfirmCreds()
+	selector, verifier, token, err := generateConfirmCreds(ctx, c.Authboss.TokenGenerator())
 	if err != nil {
 		return err
 	}
@@ -136,32 +238,39 @@ func (c *Confirm) StartConfirmation(ctx context.Context, user authboss.Confirmab
 	user.PutConfirmed(false)
 	user.PutConfirmSelector(selector)
 	user.PutConfirmVerifier(verifier)
+	if duration := c.Config.Modules.ConfirmTokenDuration; duration != 0 {
+		authboss.MustBeExpiringConfirmable(user).PutConfirmExpiry(c.Now().UTC().Add(duration))
+	}
 
 	logger.Infof("generated new confirm token for user: %s", user.GetPID())
-	if err := c.Authboss.Config.Storage.Server.Save(ctx, user); err != nil {
+	if err := c.Authboss.SaveUser(ctx, user); err != nil {
 		return errors.Wrap(err, "failed to save user during StartConfirmation, user data may be in weird state")
 	}
 
-	if c.Authboss.Config.Modules.MailNoGoroutine {
-		c.SendConfirmEmail(ctx, user.GetEmail(), token)
-	} else {
-		go c.SendConfirmEmail(ctx, user.GetEmail(), token)
-	}
-
-	return nil
+	to := user.GetEmail()
+	return c.Authboss.SendMail(ctx, func(ctx context.Context) error {
+		return c.sendConfirmEmail(ctx, to, token)
+	})
 }
 
 // SendConfirmEmail sends a confirmation e-mail to a user
 func (c *Confirm) SendConfirmEmail(ctx context.Context, to, token string) {
+	if err := c.sendConfirmEmail(ctx, to, token); err != nil {
+		c.Authboss.Logger(ctx).Errorf("%+v", err)
+	}
+}
+
+func (c *Confirm) sendConfirmEmail(ctx context.Context, to, token string) error {
 	logger := c.Authboss.Logger(ctx)
 
-	mailURL := c.mailURL(token)
+	mailURL := c.mailURL(ctx, token)
 
+	mail := c.RequestConfig(ctx).Mail
 	email := authboss.Email{
 		To:       []string{to},
-		From:     c.Config.Mail.From,
-		FromName: c.Config.Mail.FromName,
-		Subject:  c.Config.Mail.SubjectPrefix + "Confirm New Account",
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + c.Localize(ctx, authboss.TxtConfirmSubject),
 	}
 
 	logger.Infof("sending confirm e-mail to: %s", to)
@@ -170,10 +279,37 @@ func (c *Confirm) SendConfirmEmail(ctx context.Context, to, token string) {
 		Data:         authboss.NewHTMLData(DataConfirmURL, mailURL),
 		HTMLTemplate: EmailConfirmHTML,
 		TextTemplate: EmailConfirmTxt,
+		Module:       "confirm",
 	}
-	if err := c.Authboss.Email(ctx, email, ro); err != nil {
-		logger.Errorf("failed to send confirm e-mail to %s: %+v", to, err)
+	return errors.Wrapf(c.Authboss.Email(ctx, email, ro), "failed to send confirm e-mail to %s", to)
+}
+
+// APIOperations documents the confirm routes for authboss.OpenAPI
+func (c *Confirm) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	method := ab.Config.Modules.ConfirmMethod
+	if method == http.MethodGet {
+		method = ab.Config.Modules.MailRouteMethod
 	}
+
+	ops := []authboss.APIOperation{{
+		Method:     method,
+		Path:       "/confirm",
+		Summary:    "Confirm the account with the e-mailed token",
+		Fields:     []authboss.APIField{{Name: FormValueConfirm, Required: true}},
+		Redirects:  true,
+		ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeInvalidToken, authboss.ErrorCodeExpiredToken},
+	}}
+	if ab.Config.Storage.Counter != nil {
+		ops = append(ops, authboss.APIOperation{
+			Method:     http.MethodPost,
+			Path:       "/confirm/resend",
+			Summary:    "E-mail a new confirmation link",
+			Fields:     []authboss.APIField{{Name: authboss.APIFieldPID, Required: true}},
+			Redirects:  true,
+			ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation, authboss.ErrorCodeRateLimited},
+		})
+	}
+	return ops
 }
 
 // Get is a request that confirms a user with a valid token
@@ -192,22 +328,20 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 
 	values := authboss.MustHaveConfirmValues(validator)
 
-	rawToken, err := base64.URLEncoding.DecodeString(values.GetToken())
+	tokens := c.Authboss.TokenGenerator()
+	rawToken, err := tokens.Decode(values.GetToken())
 	if err != nil {
 		logger.Infof("error decoding token in Confirm.Get, this typically means a bad token: %s %+v", values.GetToken(), err)
 		return c.invalidToken(w, r)
 	}
 
-	if len(rawToken) != confirmTokenSize {
-		logger.Infof("invalid confirm token submitted, size was wrong: %d", len(rawToken))
+	selector, verifier, err := tokens.Split(r.Context(), authboss.TokenConfirm, rawToken)
+	if err != nil {
+		logger.Infof("invalid confirm token submitted: %+v", err)
 		return c.invalidToken(w, r)
 	}
 
-	selectorBytes := sha512.Sum512(rawToken[:confirmTokenSplit])
-	verifierBytes := sha512.Sum512(rawToken[confirmTokenSplit:])
-	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
-
-	storer := authboss.EnsureCanConfirm(c.Authboss.Config.Storage.Server)
+	storer := authboss.EnsureCanConfirm(c.Authboss.Storer(r.Context()))
 	user, err := storer.LoadByConfirmSelector(r.Context(), selector)
 	if err == authboss.ErrUserNotFound {
 		logger.Infof("confirm selector was not found in database: %s", selector)
@@ -216,16 +350,26 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	dbVerifierBytes, err := base64.StdEncoding.DecodeString(user.GetConfirmVerifier())
-	if err != nil {
-		logger.Infof("invalid confirm verifier stored in database: %s", user.GetConfirmVerifier())
+	if subtle.ConstantTimeCompare([]byte(verifier), []byte(user.GetConfirmVerifier())) != 1 {
+		logger.Info("stored confirm verifier does not match provided one")
 		return c.invalidToken(w, r)
 	}
 
-	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
-		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
-		logger.Info("stored confirm verifier does not match provided one")
-		return c.invalidToken(w, r)
+	if c.Config.Modules.ConfirmTokenDuration != 0 {
+		if c.Now().UTC().After(authboss.MustBeExpiringConfirmable(user).GetConfirmExpiry()) {
+			logger.Infof("user %s used an expired confirm token, sending a new one", user.GetPID())
+			if err = c.StartConfirmation(r.Context(), user, true); err != nil {
+				return err
+			}
+
+			ro := authboss.RedirectOptions{
+				Code:         http.StatusTemporaryRedirect,
+				Failure:      c.Localize(r.Context(), authboss.TxtConfirmExpired),
+				FailureCode:  authboss.ErrorCodeExpiredToken,
+				RedirectPath: c.Authboss.RedirectPath(r, "confirm", authboss.RedirectNotOK, user, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmNotOK),
+			}
+			return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+		}
 	}
 
 	user.PutConfirmSelector("")
@@ -233,34 +377,102 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 	user.PutConfirmed(true)
 
 	logger.Infof("user %s confirmed their account", user.GetPID())
-	if err = c.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+	if err = c.Authboss.SaveUser(r.Context(), user); err != nil {
 		return err
 	}
 
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		Success:      "You have successfully confirmed your account.",
-		RedirectPath: c.Authboss.Config.Paths.ConfirmOK,
+		Success:      c.Localize(r.Context(), authboss.TxtConfirmed),
+		RedirectPath: c.Authboss.ReturnTo(w, r, c.Authboss.RedirectPath(r, "confirm", authboss.RedirectOK, user, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmOK)),
+	}
+	return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// ResendPost sends a new confirm e-mail to a user that hasn't confirmed
+// their account yet. It responds the same way whether or not the user
+// exists or needs confirming.
+func (c *Confirm) ResendPost(w http.ResponseWriter, r *http.Request) error {
+	logger := c.RequestLogger(r)
+
+	validatable, err := c.Authboss.Config.Core.BodyReader.Read(PageConfirmResend, r)
+	if err != nil {
+		return err
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: c.Authboss.RedirectPath(r, "confirm", authboss.RedirectNotOK, nil, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmNotOK),
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("confirm resend validation failed")
+		ro.Failure = c.Localize(r.Context(), authboss.TxtInvalidEmail)
+		return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 	}
+
+	pid := c.NormalizePID(authboss.MustHaveRecoverStartValues(validatable).GetPID())
+
+	// Every request is a distinct member so the set counts requests
+	sent, err := c.Config.Storage.Counter.CountDistinct(r.Context(), "confirm_resend:"+pid,
+		strconv.FormatInt(time.Now().UnixNano(), 10), c.Config.Modules.ConfirmResendWindow)
+	if err != nil {
+		return errors.Wrap(err, "failed to count confirm resends")
+	}
+	if sent > c.Config.Modules.ConfirmResendLimit {
+		logger.Infof("confirm resend for %s was rate limited", pid)
+		ro.Code = http.StatusTooManyRequests
+		ro.Failure = c.Localize(r.Context(), authboss.TxtConfirmResendLimit)
+		return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	// The same whether or not the user exists and needs confirming so
+	// resending can't be used to find accounts
+	ro.Success = c.Localize(r.Context(), authboss.TxtConfirmResend)
+
+	user, err := c.LoadByCredential(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("confirm resend requested for unknown user %s", pid)
+		return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	cuser := authboss.MustBeConfirmable(user)
+	if cuser.GetConfirmed() {
+		logger.Infof("confirm resend requested for confirmed user %s", pid)
+		return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	err = c.StartConfirmation(r.Context(), cuser, true)
+	if _, ok := err.(authboss.MailError); ok {
+		ro.Success = ""
+		ro.Failure = c.Localize(r.Context(), authboss.TxtConfirmMailFailed)
+	} else if err != nil {
+		return err
+	}
+
 	return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
-func (c *Confirm) mailURL(token string) string {
+func (c *Confirm) mailURL(ctx context.Context, token string) string {
+	config := c.RequestConfig(ctx)
 	query := url.Values{FormValueConfirm: []string{token}}
 
-	if len(c.Config.Mail.RootURL) != 0 {
-		return fmt.Sprintf("%s?%s", c.Config.Mail.RootURL+"/confirm", query.Encode())
+	if len(config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", config.Mail.RootURL+"/confirm", query.Encode())
 	}
 
-	p := path.Join(c.Config.Paths.Mount, "confirm")
-	return fmt.Sprintf("%s%s?%s", c.Config.Paths.RootURL, p, query.Encode())
+	p := path.Join(config.Paths.Mount, "confirm")
+	return fmt.Sprintf("%s%s?%s", config.Paths.RootURL, p, query.Encode())
 }
 
 func (c *Confirm) invalidToken(w http.ResponseWriter, r *http.Request) error {
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		Failure:      "confirm token is invalid",
-		RedirectPath: c.Authboss.Config.Paths.ConfirmNotOK,
+		Failure:      c.Localize(r.Context(), authboss.TxtConfirmInvalid),
+		FailureCode:  authboss.ErrorCodeInvalidToken,
+		RedirectPath: c.Authboss.RedirectPath(r, "confirm", authboss.RedirectNotOK, nil, c.Authboss.RequestConfig(r.Context()).Paths.ConfirmNotOK),
 	}
 	return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
@@ -285,10 +497,16 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 
 			logger := ab.RequestLogger(r)
 			logger.Infof("user %s prevented from accessing %s: not confirmed", user.GetPID(), r.URL.Path)
+			if r.Method == http.MethodGet {
+				// Back to the page they were after once they've confirmed
+				ab.KeepReturnTo(w, r.URL.RequestURI())
+			}
+
 			ro := authboss.RedirectOptions{
 				Code:         http.StatusTemporaryRedirect,
-				Failure:      "Your account has not been confirmed, please check your e-mail.",
-				RedirectPath: ab.Config.Paths.ConfirmNotOK,
+				Failure:      ab.Localize(ab.LocaleContext(r), authboss.TxtNotConfirmed),
+				FailureCode:  authboss.ErrorCodeUnconfirmed,
+				RedirectPath: ab.RedirectPath(r, "confirm", authboss.RedirectNotOK, user, ab.RequestConfig(r.Context()).Paths.ConfirmNotOK),
 			}
 			if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
 				logger.Errorf("error redirecting in confirm.Middleware: #%v", err)
@@ -303,16 +521,21 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 // verifier: hash of the second half of a 64 byte value
 // (to be stored in database but never used in SELECT query)
 // token: the user-facing base64 encoded selector+verifier
+// They're made by the authboss.DefaultTokenGenerator.
 func GenerateConfirmCreds() (selector, verifier, token string, err error) {
-	rawToken := make([]byte, confirmTokenSize)
-	if _, err = io.ReadFull(rand.Reader, rawToken); err != nil {
+	return generateConfirmCreds(context.Background(), authboss.DefaultTokenGenerator{})
+}
+
+// generateConfirmCreds creates the creds with the TokenGenerator, the
+// selector is scoped to the context's tenant
+func generateConfirmCreds(ctx context.Context, tokens authboss.TokenGenerator) (selector, verifier, token string, err error) {
+	rawToken, err := tokens.NewToken(ctx, authboss.TokenConfirm)
+	if err != nil {
+		return "", "", "", err
+	}
+	if selector, verifier, err = tokens.Split(ctx, authboss.TokenConfirm, rawToken); err != nil {
 		return "", "", "", err
 	}
-	selectorBytes := sha512.Sum512(rawToken[:confirmTokenSplit])
-	verifierBytes := sha512.Sum512(rawToken[confirmTokenSplit:])
 
-	return base64.StdEncoding.EncodeToString(selectorBytes[:]),
-		base64.StdEncoding.EncodeToString(verifierBytes[:]),
-		base64.URLEncoding.EncodeToString(rawToken),
-		nil
+	return selector, verifier, tokens.Encode(rawToken), nil
 }
@@ -8,7 +8,10 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/volatiletech/authboss/v3"
 	"github.com/volatiletech/authboss/v3/mocks"
@@ -25,6 +28,7 @@ func TestInit(t *testing.T) {
 	ab.Config.Core.Router = router
 	ab.Config.Core.MailRenderer = renderer
 	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Storage.Counter = mocks.NewCounter()
 
 	c := &Confirm{}
 	if err := c.Init(ab); err != nil {
@@ -38,6 +42,47 @@ func TestInit(t *testing.T) {
 	if err := router.HasGets("/confirm"); err != nil {
 		t.Error(err)
 	}
+	if err := router.HasPosts("/confirm/resend"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.Router = &mocks.Router{}
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Core.MailRenderer = &mocks.Renderer{}
+	ab.Config.Storage.Server = struct{ authboss.ServerStorer }{mocks.NewServerStorer()}
+	ab.Config.Modules.MailRouteMethod = "PUT"
+
+	err := ab.Init("confirm")
+	problems, ok := err.(authboss.ConfigError)
+	if !ok {
+		t.Fatalf("expected a config error: %#v", err)
+	}
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"confirm: Config.Core.BodyReader is not set",
+		"confirm: Config.Core.Responder is not set",
+		"confirm: Config.Core.Redirector is not set",
+		"confirm: Config.Core.Logger is not set",
+		"confirm: Config.Core.Mailer is not set",
+		"confirm: Config.Storage.SessionState is not set",
+		"confirm: Config.Storage.Server (struct { authboss.ServerStorer }) must implement authboss.ConfirmingServerStorer",
+		"confirm: Config.Modules.MailRouteMethod must be GET or POST",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems were wrong:\n%s", strings.Join(got, "\n"))
+	}
+	if ab.IsLoaded("confirm") {
+		t.Error("the module should not have been loaded")
+	}
 }
 
 type testHarness struct {
@@ -171,6 +216,36 @@ func TestStartConfirmationWeb(t *testing.T) {
 	}
 }
 
+func TestStartConfirmationWebMailFailure(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Core.Mailer = &mocks.Mailer{SendErr: "mailer is down"}
+	harness.ab.Config.Modules.MailFailurePolicy = authboss.MailFailureError
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := harness.confirm.StartConfirmationWeb(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled {
+		t.Error("it should be handled")
+	}
+
+	if harness.redirector.Options.Failure != authboss.TxtConfirmMailFailed.Default {
+		t.Error("the user should have been told to ask for a new e-mail:", harness.redirector.Options.Failure)
+	}
+	if len(user.ConfirmSelector) == 0 {
+		t.Error("the user should still need confirming")
+	}
+}
+
 func TestGetSuccess(t *testing.T) {
 	t.Parallel()
 
@@ -212,6 +287,86 @@ func TestGetSuccess(t *testing.T) {
 	}
 }
 
+func TestGetExpired(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.ConfirmTokenDuration = time.Hour
+
+	selector, verifier, token, err := GenerateConfirmCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email: "test@test.com", ConfirmSelector: selector, ConfirmVerifier: verifier,
+		ConfirmExpiry: time.Now().UTC().Add(-time.Minute),
+	}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{
+		Token: token,
+	}
+
+	if err := harness.confirm.Get(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+		t.Error(err)
+	}
+
+	if user.Confirmed {
+		t.Error("the user should not have been confirmed")
+	}
+	if user.ConfirmSelector == selector || !user.ConfirmExpiry.After(time.Now()) {
+		t.Error("a new token should have been created")
+	}
+	if len(harness.mailer.Email.To) == 0 {
+		t.Error("the new token should have been e-mailed")
+	}
+	if p := harness.redirector.Options.RedirectPath; p != harness.ab.Paths.ConfirmNotOK {
+		t.Error("redir path was wrong:", p)
+	}
+}
+
+func TestResendPost(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Storage.Counter = mocks.NewCounter()
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+
+	resend := func(pid string) authboss.RedirectOptions {
+		t.Helper()
+
+		harness.mailer.Email = authboss.Email{}
+		harness.bodyReader.Return = &mocks.Values{PID: pid}
+		if err := harness.confirm.ResendPost(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+			t.Fatal(err)
+		}
+		return harness.redirector.Options
+	}
+
+	unknown := resend("unknown@test.com")
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("unknown users should not be e-mailed")
+	}
+
+	if known := resend("test@test.com"); known != unknown {
+		t.Errorf("responses should be the same, got: %#v and %#v", unknown, known)
+	}
+	if len(harness.mailer.Email.To) == 0 || len(user.ConfirmSelector) == 0 {
+		t.Error("the user should have been sent a new token")
+	}
+
+	resend("test@test.com")
+	resend("test@test.com")
+	if limited := resend("test@test.com"); limited.Code != http.StatusTooManyRequests {
+		t.Error("resending should be rate limited:", limited)
+	}
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("a rate limited resend should not send an e-mail")
+	}
+}
+
 func TestGetValidationFailure(t *testing.T) {
 	t.Parallel()
 
@@ -327,9 +482,11 @@ func TestMiddlewareDisallow(t *testing.T) {
 
 	ab := authboss.New()
 	redirector := &mocks.Redirector{}
+	session := mocks.NewClientRW()
 	ab.Config.Paths.ConfirmNotOK = "/confirm/not/ok"
 	ab.Config.Core.Logger = mocks.Logger{}
 	ab.Config.Core.Redirector = redirector
+	ab.Config.Storage.SessionState = session
 
 	called := false
 	server := Middleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -340,9 +497,9 @@ func TestMiddlewareDisallow(t *testing.T) {
 		Confirmed: false,
 	}
 
-	r := mocks.Request("GET")
+	r := httptest.NewRequest("GET", "/account?tab=security", nil)
 	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
-	w := httptest.NewRecorder()
+	w := ab.NewResponse(httptest.NewRecorder())
 
 	server.ServeHTTP(w, r)
 
@@ -355,6 +512,9 @@ func TestMiddlewareDisallow(t *testing.T) {
 	if p := redirector.Options.RedirectPath; p != "/confirm/not/ok" {
 		t.Error("redirect path wrong:", p)
 	}
+	if returnTo := session.ClientValues[authboss.SessionReturnTo]; returnTo != "/account?tab=security" {
+		t.Error("the page should be kept to return to after confirming:", returnTo)
+	}
 }
 
 func TestMailURL(t *testing.T) {
@@ -365,14 +525,14 @@ func TestMailURL(t *testing.T) {
 	h.ab.Config.Paths.Mount = "/v1/auth"
 
 	want := "https://api.test.com:6343/v1/auth/confirm?cnf=abc"
-	if got := h.confirm.mailURL("abc"); got != want {
+	if got := h.confirm.mailURL(context.Background(), "abc"); got != want {
 		t.Error("want:", want, "got:", got)
 	}
 
 	h.ab.Config.Mail.RootURL = "https://test.com:3333/testauth"
 
 	want = "https://test.com:3333/testauth/confirm?cnf=abc"
-	if got := h.confirm.mailURL("abc"); got != want {
+	if got := h.confirm.mailURL(context.Background(), "abc"); got != want {
 		t.Error("want:", want, "got:", got)
 	}
 }
@@ -418,12 +578,112 @@ func TestGenerateRecoverCreds(t *testing.T) {
 		t.Error(err)
 	}
 
-	checkSelector := sha512.Sum512(rawToken[:confirmTokenSplit])
+	checkSelector := sha512.Sum512(rawToken[:32])
 	if 0 != bytes.Compare(checkSelector[:], rawSelector) {
 		t.Error("expected selector to match")
 	}
-	checkVerifier := sha512.Sum512(rawToken[confirmTokenSplit:])
+	checkVerifier := sha512.Sum512(rawToken[32:])
 	if 0 != bytes.Compare(checkVerifier[:], rawVerifier) {
 		t.Error("expected verifier to match")
 	}
 }
+
+func TestGenerateConfirmCredsTenant(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.WithValue(context.Background(), authboss.CTXKeyTenant, "acme")
+	selector, _, token, err := generateConfirmCreds(ctx, authboss.DefaultTokenGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unscoped := sha512.Sum512(rawToken[:32])
+	if base64.StdEncoding.EncodeToString(unscoped[:]) == selector {
+		t.Error("the selector should be scoped to the tenant")
+	}
+	scoped := sha512.Sum512(authboss.TenantToken(ctx, rawToken[:32]))
+	if base64.StdEncoding.EncodeToString(scoped[:]) != selector {
+		t.Error("the selector should be the tenant's")
+	}
+}
+
+func TestReconfirmChange(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.ReconfirmAttributes = []string{authboss.AttributeEmail}
+
+	user := &mocks.User{Email: "new@test.com", Confirmed: true}
+	harness.storer.Users["new@test.com"] = user
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	w := httptest.NewRecorder()
+	req := r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyChangedAttributes, []string{"name"}))
+	handled, err := harness.confirm.ReconfirmChange(w, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled || !user.Confirmed {
+		t.Error("changing other attributes shouldn't need confirming")
+	}
+
+	req = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyChangedAttributes, []string{"name", authboss.AttributeEmail}))
+	handled, err = harness.confirm.ReconfirmChange(w, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled {
+		t.Error("it should have been handled")
+	}
+
+	if user.Confirmed || len(user.ConfirmSelector) == 0 {
+		t.Error("the user should need confirming again")
+	}
+	if to := harness.mailer.Email.To[0]; to != "new@test.com" {
+		t.Error("mailer sent e-mail to wrong address:", to)
+	}
+	if p := harness.redirector.Options.RedirectPath; p != "/confirm/not/ok" {
+		t.Error("redirect path was wrong:", p)
+	}
+	if harness.redirector.Options.Success != authboss.TxtReconfirmSent.Default {
+		t.Error("the message was wrong:", harness.redirector.Options.Success)
+	}
+}
+
+func TestReconfirmRename(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.ReconfirmAttributes = []string{authboss.AttributePID}
+
+	user := &mocks.User{Email: "test@test.com", Confirmed: true}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := harness.confirm.ReconfirmRename(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled || !user.Confirmed {
+		t.Error("changing the username shouldn't need confirming")
+	}
+
+	harness.ab.Config.Modules.RenamePID = true
+	handled, err = harness.confirm.ReconfirmRename(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled || user.Confirmed {
+		t.Error("changing the pid should need confirming")
+	}
+}
@@ -0,0 +1,293 @@
+// Package connect serves the flows of authboss that clients other than
+// browsers need (logging in, refreshing and revoking tokens, validating a
+// second factor and recovering a password) as a Connect service, so they
+// can be called with the clients generated for Connect and gRPC-Web
+// backends.
+//
+// It speaks the unary Connect protocol with the JSON codec, it doesn't
+// implement the gRPC protocol itself (that needs protobuf and HTTP/2
+// trailers). Each procedure is passed on to the authboss route that
+// implements it, so the flows use the same Config, storers, modules and
+// events as the routes do. The request message's fields are the route's
+// form values and the response message is the route's JSON response, so
+// the Renderer of the Responder and Redirector should be a
+// defaults.JSONRenderer and logging in needs the token module to respond
+// with tokens.
+//
+//	mux.Handle(connect.Path, ab.LoadClientStateMiddleware(connect.NewHandler(ab)))
+package connect
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// ServiceName is the fully qualified name of the service
+	ServiceName = "authboss.v1.AuthService"
+	// Path is where the service's procedures are served, procedures are
+	// called by posting to Path + their name (eg.
+	// /authboss.v1.AuthService/Login).
+	Path = "/" + ServiceName + "/"
+
+	// HeaderErrorCode is the response header that has the
+	// authboss.ErrorCode of failures, it's Connect error metadata.
+	HeaderErrorCode = "Authboss-Error-Code"
+
+	maxMessageSize = 1 << 20
+)
+
+// Procedure is the authboss route a procedure of the service is passed on
+// to
+type Procedure struct {
+	Method string
+	Path   string
+}
+
+// DefaultProcedures are the procedures NewHandler serves. Procedures of
+// modules that aren't loaded respond with unimplemented.
+var DefaultProcedures = map[string]Procedure{
+	"Login":        {http.MethodPost, "/login"},
+	"Refresh":      {http.MethodPost, "/token/refresh"},
+	"Logout":       {http.MethodPost, "/token/revoke"},
+	"ValidateTOTP": {http.MethodPost, "/2fa/totp/validate"},
+	"ValidateSMS":  {http.MethodPost, "/2fa/sms/validate"},
+	"RecoverStart": {http.MethodPost, "/recover"},
+	"RecoverEnd":   {http.MethodPost, "/recover/end"},
+}
+
+// Handler serves the Connect service, it has to be mounted at Path behind
+// authboss.LoadClientStateMiddleware like the authboss routes.
+type Handler struct {
+	ab *authboss.Authboss
+
+	// Procedures maps the names of the procedures to the routes they're
+	// passed on to, more can be added before it's serving requests.
+	Procedures map[string]Procedure
+}
+
+// NewHandler creates a Handler serving the DefaultProcedures
+func NewHandler(ab *authboss.Authboss) *Handler {
+	procedures := make(map[string]Procedure, len(DefaultProcedures))
+	for name, procedure := range DefaultProcedures {
+		procedures[name] = procedure
+	}
+
+	return &Handler{ab: ab, Procedures: procedures}
+}
+
+// ServeHTTP calls the procedure. The route is served with
+// Config.Core.Router with the request's context, headers and cookies but
+// without CSRF protection: requests of the Connect protocol are JSON posts
+// that other sites can't make without a CORS preflight.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "", "only POST is supported")
+		return
+	}
+
+	procedure, ok := h.Procedures[strings.TrimPrefix(r.URL.Path, Path)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "unknown procedure")
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		w.Header().Set("Accept-Post", "application/json")
+		writeError(w, http.StatusUnsupportedMediaType, "", "only the json codec is supported")
+		return
+	}
+
+	values, err := readMessage(w, r)
+	if err != nil {
+		h.ab.RequestLogger(r).Infof("failed to read connect request: %v", err)
+		writeError(w, http.StatusBadRequest, "", "the request message must be a json object")
+		return
+	}
+
+	req, err := routeRequest(r, procedure, values)
+	if err != nil {
+		h.ab.RequestLogger(r).Errorf("failed to create connect route request: %+v", err)
+		writeError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+
+	rec := &recorder{ResponseWriter: w, header: http.Header{}}
+	h.ab.Config.Core.Router.ServeHTTP(rec, req)
+	rec.respond(w)
+}
+
+// readMessage reads the request message as the route's form values, values
+// that aren't strings are kept as json (true, 12)
+func readMessage(w http.ResponseWriter, r *http.Request) (map[string]string, error) {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var message map[string]json.RawMessage
+	if len(bytes.TrimSpace(b)) != 0 {
+		if err = json.Unmarshal(b, &message); err != nil {
+			return nil, err
+		}
+	}
+
+	values := make(map[string]string, len(message))
+	for name, raw := range message {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			values[name] = s
+		} else if string(raw) != "null" {
+			values[name] = string(raw)
+		}
+	}
+	return values, nil
+}
+
+// routeRequest is the request for the procedure's route. The values are
+// both posted as json and parsed as a form already, so they're read
+// whether the BodyReader reads json or forms.
+func routeRequest(r *http.Request, procedure Procedure, values map[string]string) (*http.Request, error) {
+	body, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	for name, value := range values {
+		form.Set(name, value)
+	}
+
+	req := authboss.CSRFExemptRequest(r.Clone(r.Context()))
+	req.Method = procedure.Method
+	req.URL = &url.URL{Path: procedure.Path}
+	req.RequestURI = ""
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("Content-Encoding")
+	req.Form = form
+	req.PostForm = form
+	return req, nil
+}
+
+// recorder keeps the route's response to turn it into the procedure's.
+// State changes still go to the client state writer beneath it.
+type recorder struct {
+	http.ResponseWriter
+
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// UnderlyingResponseWriter for authboss.MustClientStateResponseWriter
+func (r *recorder) UnderlyingResponseWriter() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// respond with the route's response: the json it responded with when it
+// succeeded (redirects included) or a Connect error
+func (r *recorder) respond(w http.ResponseWriter) {
+	for name, values := range r.header {
+		if name == "Content-Type" || name == "Content-Length" || name == "Location" {
+			continue
+		}
+		w.Header()[name] = values
+	}
+
+	if r.code >= http.StatusBadRequest {
+		code, message := routeError(r.body.Bytes())
+		writeError(w, r.code, code, message)
+		return
+	}
+
+	body := r.body.Bytes()
+	if mediaType, _, _ := mime.ParseMediaType(r.header.Get("Content-Type")); mediaType != "application/json" || len(body) == 0 {
+		body = []byte("{}")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// routeError finds the authboss.APIError in a failed route's response, it
+// expects the defaults.DefaultErrorEnvelope
+func routeError(body []byte) (authboss.ErrorCode, string) {
+	var envelope struct {
+		Error authboss.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return "", ""
+	}
+	return envelope.Error.Code, envelope.Error.Message
+}
+
+// writeError writes a Connect error for the http status, the message is
+// the status' text if it's empty
+func writeError(w http.ResponseWriter, status int, code authboss.ErrorCode, message string) {
+	if len(message) == 0 {
+		message = http.StatusText(status)
+	}
+	if len(code) != 0 {
+		w.Header().Set(HeaderErrorCode, string(code))
+	}
+
+	b, _ := json.Marshal(map[string]string{
+		"code":    connectCode(status),
+		"message": message,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// connectCode is the Connect error code for an http status, as in the
+// Connect protocol's table
+func connectCode(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "invalid_argument"
+	case http.StatusUnauthorized:
+		return "unauthenticated"
+	case http.StatusForbidden:
+		return "permission_denied"
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusUnsupportedMediaType, http.StatusNotImplemented:
+		return "unimplemented"
+	case http.StatusConflict:
+		return "already_exists"
+	case http.StatusTooManyRequests:
+		return "resource_exhausted"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	case http.StatusGatewayTimeout:
+		return "deadline_exceeded"
+	case http.StatusInternalServerError:
+		return "internal"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,135 @@
+package connect
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/defaults"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func testSetup(t *testing.T) (*authboss.Authboss, *mocks.ClientStateRW, http.Handler) {
+	t.Helper()
+
+	session := mocks.NewClientRW()
+	renderer := defaults.JSONRenderer{}
+
+	ab := authboss.New()
+	ab.Config.Core.Logger = mocks.Logger{}
+	ab.Config.Core.Router = defaults.NewRouter()
+	ab.Config.Core.Responder = defaults.NewResponder(renderer)
+	ab.Config.Core.Redirector = defaults.NewRedirector(renderer, authboss.FormValueRedirect)
+	ab.Config.Storage.SessionState = session
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stands in for the auth module's route, it's CSRF protected like it
+	ab.Config.Core.Router.Post("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("email") != "test@test.com" || r.FormValue("password") != "hello world" {
+			data := authboss.HTMLData{
+				authboss.DataErr:     "Invalid Credentials",
+				authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials,
+			}
+			if err := ab.Config.Core.Responder.Respond(w, r, http.StatusUnauthorized, "login", data); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		if r.FormValue("rm") != "true" {
+			t.Error("the remember me value was wrong:", r.FormValue("rm"))
+		}
+
+		authboss.PutSession(w, authboss.SessionKey, "test@test.com")
+		data := authboss.HTMLData{"access_token": "token"}
+		if err := ab.Config.Core.Responder.Respond(w, r, http.StatusOK, "token", data); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	return ab, session, ab.LoadClientStateMiddleware(NewHandler(ab))
+}
+
+func call(handler http.Handler, procedure, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", Path+procedure, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Connect-Protocol-Version", "1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	_, session, handler := testSetup(t)
+
+	rec := call(handler, "Login", `{"email": "test@test.com", "password": "hello world", "rm": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatal("wrong code:", rec.Code, rec.Body.String())
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response["access_token"] != "token" {
+		t.Error("the response message should be the route's response:", response)
+	}
+	if session.ClientValues[authboss.SessionKey] != "test@test.com" {
+		t.Error("the session should have been written")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	_, _, handler := testSetup(t)
+
+	tests := []struct {
+		Name      string
+		Procedure string
+		Body      string
+		Status    int
+		Code      string
+		ErrorCode authboss.ErrorCode
+	}{
+		{"Failure", "Login", `{"email": "test@test.com", "password": "nope"}`, http.StatusUnauthorized, "unauthenticated", authboss.ErrorCodeInvalidCredentials},
+		{"BadMessage", "Login", `["nope"]`, http.StatusBadRequest, "invalid_argument", ""},
+		{"UnknownProcedure", "Nope", `{}`, http.StatusNotFound, "unimplemented", ""},
+		{"RouteNotMounted", "Refresh", `{"token": "token"}`, http.StatusNotFound, "unimplemented", ""},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			rec := call(handler, test.Procedure, test.Body)
+			if rec.Code != test.Status {
+				t.Error("wrong status:", rec.Code)
+			}
+
+			var connectErr map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &connectErr); err != nil {
+				t.Fatal(err)
+			}
+			if connectErr["code"] != test.Code || len(connectErr["message"]) == 0 {
+				t.Error("the error was wrong:", connectErr)
+			}
+			if code := rec.Header().Get(HeaderErrorCode); code != string(test.ErrorCode) {
+				t.Error("wrong error code:", code)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("POST", Path+"Login", strings.NewReader("email=test@test.com"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Error("only json should be accepted:", rec.Code)
+	}
+}
@@ -3,6 +3,7 @@ package authboss
 import (
 	"context"
 	"net/http"
+	"sync"
 )
 
 type contextKey string
@@ -25,12 +26,126 @@ const (
 	// user information currently is remember so only auth/oauth2 are currently
 	// going to use this.
 	CTXKeyValues contextKey = "values"
+
+	// CTXKeyBearerToken is where the oauth2 bearer middleware stores the
+	// information about the verified access token used for the request.
+	CTXKeyBearerToken contextKey = "bearer"
+
+	// CTXKeyDevice is where device.Middleware stores the *Device the
+	// request is made from.
+	CTXKeyDevice contextKey = "device"
+
+	// CTXKeyRequestID is where the app can put the request's id (a
+	// string) for RequestLogger, otherwise HeaderRequestID is used.
+	CTXKeyRequestID contextKey = "request_id"
+	// CTXKeyModule is the name of the module whose handler is serving the
+	// request.
+	CTXKeyModule contextKey = "module"
+	// CTXKeyLocale is the locale (a string) text shown to the user is
+	// localized into, see Authboss.Locale.
+	CTXKeyLocale contextKey = "locale"
+	// CTXKeyTx is the TxStorer of the transaction the request's changes
+	// are made in, see Authboss.InTx.
+	CTXKeyTx contextKey = "tx"
+	// CTXKeyImpersonator is where ImpersonationMiddleware puts the user
+	// who is impersonating the current user, see Authboss.Impersonate.
+	CTXKeyImpersonator contextKey = "impersonator"
+	// CTXKeyTenant is the tenant (a string) LoadClientState resolved the
+	// request to, see Tenant.
+	CTXKeyTenant contextKey = "tenant"
+	// CTXKeyGuestID is the id (a string) of the guest session the guest
+	// module gave a visitor who isn't logged in, see EventAccountUpgrade.
+	CTXKeyGuestID contextKey = "guest_id"
+	// CTXKeyRenamedFrom is the name (a string) a user had before they
+	// changed it, see EventRename.
+	CTXKeyRenamedFrom contextKey = "renamed_from"
+	// CTXKeyChangedAttributes are the attributes (a []string, see
+	// AttributeEmail) of a user that changed, see EventUserChange.
+	CTXKeyChangedAttributes contextKey = "changed_attributes"
+	// CTXKeyMount is the *Mount that serves the request, see
+	// ModuleEnabled.
+	CTXKeyMount contextKey = "mount"
+	// CTXKeyConfig is the *Config Config.Core.ConfigOverride made for the
+	// request, see RequestConfig.
+	CTXKeyConfig contextKey = "config"
+	// CTXKeyOAuth2Rejected is the error an oauth2 callback was rejected
+	// with, see EventOAuth2Rejected.
+	CTXKeyOAuth2Rejected contextKey = "oauth2_rejected"
+	// CTXKeyOAuth2Profile is the OAuth2Profile fetched from the provider
+	// the user logged in with, see OAuth2Provider.FindProfile.
+	CTXKeyOAuth2Profile contextKey = "oauth2_profile"
+	// CTXKeyPanic is the *PanicError a module's handler panicked with, see
+	// EventPanic
+	CTXKeyPanic contextKey = "panic"
+	// CTXKeyAttempt is the AttemptInfo of a login attempt, see
+	// Authboss.Attempt
+	CTXKeyAttempt contextKey = "attempt"
+	// CTXKeyPathPrefix is the path prefix a reverse proxy mounted the app
+	// under, see PathPrefix
+	CTXKeyPathPrefix contextKey = "path_prefix"
+
+	// ctxKeyUserCache is where LoadClientState puts the request's
+	// *userCache
+	ctxKeyUserCache contextKey = "user_cache"
+	// ctxKeyCSRFExempt marks requests that were made CSRFExemptRequests
+	ctxKeyCSRFExempt contextKey = "csrf_exempt"
 )
 
+// userCache is the user CurrentUser loaded during a request. It's kept
+// with its pid so that logging in or out during the request doesn't return
+// the wrong user.
+type userCache struct {
+	mut  sync.Mutex
+	pid  string
+	user User
+}
+
+// forget drops the cached user if it's pid's
+func (u *userCache) forget(pid string) {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	if u.pid == pid {
+		u.pid, u.user = "", nil
+	}
+}
+
+// Device is the device a request is made from, see CurrentDevice.
+type Device struct {
+	// ID is the random id from the device's cookie
+	ID string
+	// UserAgent of the request
+	UserAgent string
+	// IP address of the request
+	IP string
+
+	// New is set by the device module during a login when the user logging
+	// in hasn't logged in from this device before. It can be checked by
+	// EventAuth and EventOAuth2 after handlers.
+	New bool
+}
+
+// CurrentDevice returns the device the request is made from, or nil if
+// device.Middleware isn't used.
+func CurrentDevice(r *http.Request) *Device {
+	device, _ := r.Context().Value(CTXKeyDevice).(*Device)
+	return device
+}
+
 func (c contextKey) String() string {
 	return "authboss ctx key " + string(c)
 }
 
+// PIDFromContext returns the pid of the logged in user without loading them,
+// for request loggers, rate limiters and the like. It's put in the context
+// by LoadClientState from the session, or by the middlewares that
+// authenticate requests in other ways (bearer tokens, api keys, client
+// certificates). It's empty if no one is logged in.
+func PIDFromContext(ctx context.Context) string {
+	pid, _ := ctx.Value(CTXKeyPID).(string)
+	return pid
+}
+
 // CurrentUserID retrieves the current user from the session.
 // TODO(aarondl): This method never returns an error, one day we'll change
 // the function signature.
@@ -86,8 +201,80 @@ func (a *Authboss) CurrentUserP(r *http.Request) User {
 	return i
 }
 
+// currentUser loads the user, or returns the one that was already loaded
+// during the request. Loads in a transaction always go to its storer.
 func (a *Authboss) currentUser(ctx context.Context, pid string) (User, error) {
-	return a.Storage.Server.Load(ctx, pid)
+	cache, ok := ctx.Value(ctxKeyUserCache).(*userCache)
+	if !ok || ctx.Value(CTXKeyTx) != nil {
+		return a.LoadUser(ctx, pid)
+	}
+
+	cache.mut.Lock()
+	defer cache.mut.Unlock()
+
+	if cache.user != nil && cache.pid == pid {
+		return cache.user, nil
+	}
+
+	user, err := a.LoadUser(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.pid, cache.user = pid, user
+	return user, nil
+}
+
+// cachedUser is the user loaded during the request, if it's still the
+// user whose pid is in the context
+func cachedUser(r *http.Request) (User, bool) {
+	cache, ok := r.Context().Value(ctxKeyUserCache).(*userCache)
+	if !ok {
+		return nil, false
+	}
+	pid, _ := r.Context().Value(CTXKeyPID).(string)
+
+	cache.mut.Lock()
+	defer cache.mut.Unlock()
+
+	if cache.user == nil || len(pid) == 0 || cache.pid != pid {
+		return nil, false
+	}
+	return cache.user, true
+}
+
+// InvalidateCurrentUser makes the next CurrentUser call of the request
+// load the user from the storer again, for when it was changed without
+// SaveUser (which invalidates it itself). A user LoadCurrentUser put in
+// the request's context isn't affected.
+func (a *Authboss) InvalidateCurrentUser(r *http.Request) {
+	if cache, ok := r.Context().Value(ctxKeyUserCache).(*userCache); ok {
+		cache.mut.Lock()
+		cache.pid, cache.user = "", nil
+		cache.mut.Unlock()
+	}
+}
+
+// LoadCurrentUserMiddleware loads the logged in user once for the handlers
+// below it, CurrentUser then returns it without going to the storer. The
+// pid is put in the context like LoadCurrentUserID does. It has to be used
+// after LoadClientStateMiddleware.
+func (a *Authboss) LoadCurrentUserMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := a.LoadCurrentUserID(&r); err != nil {
+			a.RequestLogger(r).Errorf("failed to load current user id: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := a.CurrentUser(r); err != nil && err != ErrUserNotFound {
+			a.RequestLogger(r).Errorf("failed to load current user: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
 }
 
 // LoadCurrentUserID takes a pointer to a pointer to the request in order to
@@ -80,6 +80,37 @@ func TestCurrentUserIDContext(t *testing.T) {
 	}
 }
 
+func TestPIDFromContext(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Storage.SessionState = newMockClientStateRW(SessionKey, "george-pid")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if pid := PIDFromContext(r.Context()); len(pid) != 0 {
+		t.Error("there should be no pid before the client state is loaded:", pid)
+	}
+
+	r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := PIDFromContext(r.Context()); pid != "george-pid" {
+		t.Error("pid was wrong:", pid)
+	}
+
+	// A pid put in the context before, by a bearer token say, is kept
+	r = httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyPID, "token-pid"))
+	r, err = ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := PIDFromContext(r.Context()); pid != "token-pid" {
+		t.Error("pid was wrong:", pid)
+	}
+}
+
 func TestCurrentUserIDP(t *testing.T) {
 	t.Parallel()
 
@@ -241,6 +272,90 @@ func TestLoadCurrentUserP(t *testing.T) {
 	_ = ab.LoadCurrentUserP(&r)
 }
 
+type countingStorer struct {
+	*mockServerStorer
+	loads int
+}
+
+func (c *countingStorer) Load(ctx context.Context, key string) (User, error) {
+	c.loads++
+	return c.mockServerStorer.Load(ctx, key)
+}
+
+func TestCurrentUserCache(t *testing.T) {
+	t.Parallel()
+
+	ab, r := testSetupContext()
+	storer := &countingStorer{mockServerStorer: ab.Storage.Server.(*mockServerStorer)}
+	ab.Storage.Server = storer
+
+	first, err := ab.CurrentUser(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ab.CurrentUser(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || storer.loads != 1 {
+		t.Error("the user should have been loaded once:", storer.loads)
+	}
+
+	ab.InvalidateCurrentUser(r)
+	if _, err := ab.CurrentUser(r); err != nil {
+		t.Fatal(err)
+	}
+	if storer.loads != 2 {
+		t.Error("the user should have been loaded again:", storer.loads)
+	}
+
+	if err := ab.SaveUser(r.Context(), first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ab.CurrentUser(r); err != nil {
+		t.Fatal(err)
+	}
+	if storer.loads != 3 {
+		t.Error("saving the user should invalidate it:", storer.loads)
+	}
+}
+
+func TestLoadCurrentUserMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ab, r := testSetupContext()
+	ab.Config.Core.Logger = mockLogger{}
+	storer := &countingStorer{mockServerStorer: ab.Storage.Server.(*mockServerStorer)}
+	ab.Storage.Server = storer
+
+	called := false
+	ab.LoadCurrentUserMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		for i := 0; i < 3; i++ {
+			if user, err := ab.CurrentUser(r); err != nil || user.GetPID() != "george-pid" {
+				t.Error("the user should be available:", err)
+			}
+		}
+	})).ServeHTTP(httptest.NewRecorder(), r)
+
+	if !called {
+		t.Error("the handler should have been called")
+	}
+	if storer.loads != 1 {
+		t.Error("the user should have been loaded once:", storer.loads)
+	}
+
+	ab.Storage.SessionState = newMockClientStateRW()
+	r = loadClientStateP(ab, ab.NewResponse(httptest.NewRecorder()), httptest.NewRequest("GET", "/", nil))
+	called = false
+	ab.LoadCurrentUserMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if !called {
+		t.Error("requests without a user should be let through")
+	}
+}
+
 func TestCTXKeyString(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,84 @@
+package authboss
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Cookie name prefixes that browsers enforce the attributes of: __Secure-
+// cookies must be Secure and __Host- cookies must also have the Path "/"
+// and no Domain, so a subdomain or an insecure page can't overwrite them.
+const (
+	CookiePrefixSecure = "__Secure-"
+	CookiePrefixHost   = "__Host-"
+)
+
+// CookieConfig is the attributes of the cookie a ClientStateReadWriter keeps
+// its state in, see Config.Storage.SessionStateCookie and
+// Config.Storage.CookieStateCookie.
+type CookieConfig struct {
+	// Name of the cookie, the ClientStateReadWriter's own is kept if it's
+	// empty. It may start with CookiePrefixSecure or CookiePrefixHost.
+	Name     string
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// Apply the attributes to the cookie
+func (c CookieConfig) Apply(cookie *http.Cookie) {
+	if len(c.Name) != 0 {
+		cookie.Name = c.Name
+	}
+	cookie.Path = c.Path
+	cookie.Domain = c.Domain
+	cookie.Secure = c.Secure
+	cookie.HttpOnly = c.HttpOnly
+	cookie.SameSite = c.SameSite
+}
+
+// CookieConfigurer is implemented by ClientStateReadWriters that keep their
+// state in a cookie, Init configures their cookie with the Config (like the
+// defaults' ServerSessionReadWriter and JWTStateReadWriter).
+type CookieConfigurer interface {
+	ConfigureCookie(CookieConfig)
+}
+
+// problems are the combinations of attributes browsers reject or ignore
+func (c CookieConfig) problems(field string) []ConfigProblem {
+	var problems []ConfigProblem
+	add := func(problem string) {
+		problems = append(problems, ConfigProblem{Field: field, Problem: problem})
+	}
+
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		add("must be Secure when SameSite is None")
+	}
+	switch {
+	case strings.HasPrefix(c.Name, CookiePrefixHost):
+		if !c.Secure || c.Path != "/" || len(c.Domain) != 0 {
+			add("must be Secure, have the Path \"/\" and no Domain to use the " + CookiePrefixHost + " prefix")
+		}
+	case strings.HasPrefix(c.Name, CookiePrefixSecure):
+		if !c.Secure {
+			add("must be Secure to use the " + CookiePrefixSecure + " prefix")
+		}
+	}
+	if len(c.Path) != 0 && !strings.HasPrefix(c.Path, "/") {
+		add("Path must start with /")
+	}
+
+	return problems
+}
+
+// configureCookies gives the state read writers their cookie config
+func (a *Authboss) configureCookies() {
+	if c, ok := a.Config.Storage.SessionState.(CookieConfigurer); ok {
+		c.ConfigureCookie(a.Config.Storage.SessionStateCookie)
+	}
+	if c, ok := a.Config.Storage.CookieState.(CookieConfigurer); ok {
+		c.ConfigureCookie(a.Config.Storage.CookieStateCookie)
+	}
+}
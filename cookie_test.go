@@ -0,0 +1,68 @@
+package authboss
+
+import (
+	"net/http"
+	"testing"
+)
+
+type cookieStateRW struct {
+	mockClientStateReadWriter
+	cookie http.Cookie
+}
+
+func (c *cookieStateRW) ConfigureCookie(cfg CookieConfig) { cfg.Apply(&c.cookie) }
+
+func TestCookieConfigProblems(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Config   CookieConfig
+		Problems int
+	}{
+		{"default", New().Config.Storage.SessionStateCookie, 0},
+		{"none insecure", CookieConfig{SameSite: http.SameSiteNoneMode}, 1},
+		{"none", CookieConfig{SameSite: http.SameSiteNoneMode, Secure: true}, 0},
+		{"host", CookieConfig{Name: "__Host-ab", Path: "/", Secure: true}, 0},
+		{"host domain", CookieConfig{Name: "__Host-ab", Path: "/", Domain: "example.com", Secure: true}, 1},
+		{"host path", CookieConfig{Name: "__Host-ab", Path: "/auth", Secure: true}, 1},
+		{"secure", CookieConfig{Name: "__Secure-ab", Domain: "example.com", Secure: true}, 0},
+		{"secure insecure", CookieConfig{Name: "__Secure-ab"}, 1},
+		{"relative path", CookieConfig{Path: "auth"}, 1},
+	}
+
+	for _, test := range tests {
+		if problems := test.Config.problems("Storage.SessionStateCookie"); len(problems) != test.Problems {
+			t.Errorf("%s: problems were wrong: %v", test.Name, problems)
+		}
+	}
+}
+
+func TestInitConfiguresCookies(t *testing.T) {
+	t.Parallel()
+
+	session := &cookieStateRW{cookie: http.Cookie{Name: "ab_session"}}
+	cookies := &cookieStateRW{cookie: http.Cookie{Name: "ab_cookie"}}
+
+	ab := New()
+	ab.Config.Storage.SessionState = session
+	ab.Config.Storage.CookieState = cookies
+	ab.Config.Storage.SessionStateCookie.SameSite = http.SameSiteNoneMode
+	ab.Config.Storage.CookieStateCookie.Name = "__Host-remember"
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := session.cookie; c.Name != "ab_session" || c.SameSite != http.SameSiteNoneMode || !c.Secure || !c.HttpOnly || c.Path != "/" {
+		t.Errorf("session cookie was wrong: %#v", c)
+	}
+	if c := cookies.cookie; c.Name != "__Host-remember" || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie was wrong: %#v", c)
+	}
+
+	ab.Config.Storage.CookieStateCookie.Secure = false
+	if err := ab.Init(); err == nil {
+		t.Error("an insecure __Host- cookie should be rejected")
+	}
+}
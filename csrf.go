@@ -0,0 +1,194 @@
+package authboss
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/friendsofgo/errors"
+)
+
+const csrfSecretSize = 32
+
+// csrfRouter protects every POST and DELETE route from cross-site request
+// forgery unless the handler is CSRFExempt, and puts the token in the data
+// of every page so its forms can post it back.
+type csrfRouter struct {
+	Router
+	ab *Authboss
+}
+
+func (r csrfRouter) Get(p string, handler http.Handler) {
+	if _, ok := handler.(csrfExempt); !ok {
+		handler = CSRFMiddleware(r.ab)(handler)
+	}
+	r.Router.Get(p, handler)
+}
+
+func (r csrfRouter) Post(p string, handler http.Handler) {
+	r.Router.Post(p, r.protect(p, handler))
+}
+
+func (r csrfRouter) Delete(p string, handler http.Handler) {
+	r.Router.Delete(p, r.protect(p, handler))
+}
+
+func (r csrfRouter) Routes() []string {
+	if lister, ok := r.Router.(RouteLister); ok {
+		return lister.Routes()
+	}
+	return nil
+}
+
+// protect rejects requests without the session's token, redirecting back to
+// the route's page. Requests that pass get a token in their data for the
+// page they may render.
+func (r csrfRouter) protect(p string, handler http.Handler) http.Handler {
+	if _, ok := handler.(csrfExempt); ok {
+		return handler
+	}
+
+	ab := r.ab
+	redirectPath := path.Join("/", ab.Config.Paths.Mount, p)
+	withToken := CSRFMiddleware(ab)(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Context().Value(ctxKeyCSRFExempt) != nil || ab.verifyCSRF(req) {
+			withToken.ServeHTTP(w, req)
+			return
+		}
+
+		logger := ab.RequestLogger(req)
+		logger.Infof("rejected %s %s without a valid csrf token", req.Method, req.URL.Path)
+
+		ro := RedirectOptions{
+			Code:         http.StatusForbidden,
+			RedirectPath: redirectPath,
+			Failure:      ab.Localize(ab.LocaleContext(req), TxtInvalidCSRF),
+			FailureCode:  ErrorCodeInvalidCSRF,
+		}
+		if err := ab.Config.Core.Redirector.Redirect(w, req, ro); err != nil {
+			logger.Errorf("failed to redirect after a csrf failure: %+v", err)
+		}
+	})
+}
+
+type csrfExempt struct {
+	http.Handler
+}
+
+// CSRFExempt marks the handler of a route so the CSRF protection leaves it
+// alone. It's for routes that browsers are sent to from other sites (like
+// an identity provider's form post), routes for API clients that don't use
+// the session and the e-mail links whose secret token already proves the
+// user meant to follow them. It has to be the outermost handler given to
+// the Router.
+func CSRFExempt(handler http.Handler) http.Handler {
+	return csrfExempt{Handler: handler}
+}
+
+// CSRFExemptRequest exempts the request from CSRF protection, for adapters
+// that pass requests on to authboss' routes for clients whose requests
+// can't be forged by other sites (like the connect package, whose requests
+// need a CORS preflight). Unlike CSRFExempt it applies to one request
+// rather than a route.
+func CSRFExemptRequest(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxKeyCSRFExempt, true))
+}
+
+// CSRFMiddleware puts a CSRF token for the request in the HTMLData under
+// DataCSRFToken, along with the form field it's posted in under
+// DataCSRFField. Authboss' own routes have it already, it's for the app's
+// pages that post to them (like a logout button in the layout). It does
+// nothing when Config.Modules.CSRFDisabled is set or the session wasn't
+// loaded (see LoadClientStateMiddleware).
+func CSRFMiddleware(ab *Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ab.Config.Modules.CSRFDisabled || r.Context().Value(CTXKeySessionState) == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, err := ab.CSRFToken(w, r)
+			if err != nil {
+				ab.RequestLogger(r).Errorf("failed to create csrf token: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			MergeDataInRequest(&r, HTMLData{
+				DataCSRFToken: token,
+				DataCSRFField: ab.Config.Modules.CSRFField,
+			})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRFToken returns a CSRF token for a form on the request's page, it has
+// to be posted in the Config.Modules.CSRFField form field or sent in the
+// Config.Modules.CSRFHeader header. The secret it's made from is kept in
+// the session and created the first time. Each token is masked with a new
+// random pad so they don't give the secret away to compression attacks
+// (like BREACH) on pages that show them.
+func (a *Authboss) CSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	secret, ok := a.csrfSecret(r)
+	if !ok {
+		secret = make([]byte, csrfSecretSize)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return "", errors.Wrap(err, "failed to create csrf secret")
+		}
+		PutSession(w, SessionCSRF, base64.RawURLEncoding.EncodeToString(secret))
+	}
+
+	token := make([]byte, 2*csrfSecretSize)
+	if _, err := io.ReadFull(rand.Reader, token[:csrfSecretSize]); err != nil {
+		return "", errors.Wrap(err, "failed to create csrf token")
+	}
+	for i, b := range secret {
+		token[csrfSecretSize+i] = token[i] ^ b
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// verifyCSRF checks that the request has a token made from the session's
+// secret, in the header or the form
+func (a *Authboss) verifyCSRF(r *http.Request) bool {
+	secret, ok := a.csrfSecret(r)
+	if !ok {
+		return false
+	}
+
+	value := r.Header.Get(a.Config.Modules.CSRFHeader)
+	if len(value) == 0 {
+		value = r.PostFormValue(a.Config.Modules.CSRFField)
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(token) != 2*csrfSecretSize {
+		return false
+	}
+	for i := range secret {
+		token[csrfSecretSize+i] ^= token[i]
+	}
+
+	return subtle.ConstantTimeCompare(token[csrfSecretSize:], secret) == 1
+}
+
+func (a *Authboss) csrfSecret(r *http.Request) ([]byte, bool) {
+	encoded, ok := GetSession(r, SessionCSRF)
+	if !ok {
+		return nil, false
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(secret) != csrfSecretSize {
+		return nil, false
+	}
+	return secret, true
+}
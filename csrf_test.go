@@ -0,0 +1,117 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	redirector := &testRedirector{}
+	session := newMockClientStateRW()
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.Redirector = redirector
+	ab.Config.Core.Router = testRouter{}
+	ab.Config.Storage.SessionState = session
+	ab.Config.Paths.Mount = "/auth"
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var data HTMLData
+	called := 0
+	ab.Config.Core.Router.Get("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ = r.Context().Value(CTXKeyData).(HTMLData)
+		w.WriteHeader(http.StatusOK) // Flush the session
+	}))
+	ab.Config.Core.Router.Post("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ }))
+	ab.Config.Core.Router.Post("/callback", CSRFExempt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })))
+	server := ab.LoadClientStateMiddleware(ab.Config.Core.Router)
+
+	post := func(token string, header bool) *httptest.ResponseRecorder {
+		form := url.Values{}
+		if !header {
+			form.Set("csrf_token", token)
+		}
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header {
+			r.Header.Set("X-CSRF-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, r)
+		return rec
+	}
+
+	// There's no secret in the session before a page was rendered
+	if rec := post("", false); rec.Code != http.StatusForbidden || called != 0 {
+		t.Error("the post without a token should have been rejected:", rec.Code)
+	}
+	if redirector.Opts.RedirectPath != "/auth/login" || redirector.Opts.FailureCode != ErrorCodeInvalidCSRF {
+		t.Errorf("redirect was wrong: %#v", redirector.Opts)
+	}
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/login", nil))
+	token, _ := data[DataCSRFToken].(string)
+	if len(token) == 0 || data[DataCSRFField] != "csrf_token" {
+		t.Fatalf("the page should have a token: %#v", data)
+	}
+	if len(session.state[SessionCSRF]) == 0 {
+		t.Fatal("the secret should be in the session")
+	}
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/login", nil))
+	if other := data[DataCSRFToken]; other == token {
+		t.Error("each token should be masked differently")
+	}
+
+	if post(token, false); called != 1 {
+		t.Error("the form's token should be accepted")
+	}
+	if post(data[DataCSRFToken].(string), true); called != 2 {
+		t.Error("the header's token should be accepted")
+	}
+
+	bad := []byte(token)
+	bad[len(bad)-1] ^= 1
+	for _, token := range []string{string(bad), "nope", token[:10]} {
+		if rec := post(token, false); rec.Code != http.StatusForbidden {
+			t.Errorf("token %q should have been rejected", token)
+		}
+	}
+	if called != 2 {
+		t.Error("wrong tokens should have been rejected")
+	}
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/callback", nil))
+	if called != 3 {
+		t.Error("exempt routes should not need a token")
+	}
+
+	server.ServeHTTP(httptest.NewRecorder(), CSRFExemptRequest(httptest.NewRequest("POST", "/login", nil)))
+	if called != 4 {
+		t.Error("exempt requests should not need a token")
+	}
+}
+
+func TestCSRFDisabled(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Router = testRouter{}
+	ab.Config.Modules.CSRFDisabled = true
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ab.Config.Core.Router.(csrfRouter); ok {
+		t.Error("the router should not be protected")
+	}
+}
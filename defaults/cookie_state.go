@@ -0,0 +1,276 @@
+package defaults
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// DefaultCookieStateCookie is the cookie NewCookieStateReadWriter uses
+	DefaultCookieStateCookie = "ab_cookie"
+	// DefaultCookieStateMaxAge is the MaxAge NewCookieStateReadWriter uses
+	DefaultCookieStateMaxAge = 30 * 24 * time.Hour
+
+	// maxCookieSize is the size browsers are guaranteed to keep
+	maxCookieSize = 4096
+)
+
+// CookieState is the client state read by CookieStateReadWriter
+type CookieState map[string]string
+
+// Get a value from the state
+func (c CookieState) Get(key string) (string, bool) {
+	val, ok := c[key]
+	return val, ok
+}
+
+// CookieStateReadWriter is a ClientStateReadWriter that keeps the whole
+// client state in one cookie encrypted and authenticated with AES-GCM, so
+// the client can neither read nor change what's in it (like flash messages
+// or the state between a password and a second factor). The cookie's name,
+// scoped to the tenant, is authenticated with it so a value can't be moved
+// to another cookie or tenant.
+//
+// It's meant for the CookieState (remember me) but can be the SessionState
+// too, usually with a MaxAge of 0. Like with JWTStateReadWriter the state
+// can't be revoked before it expires.
+type CookieStateReadWriter struct {
+	// Keys encrypt the state, they must be 16, 24 or 32 bytes long. The
+	// first key encrypts and all of them are tried to decrypt, so a new key
+	// is rotated in by adding it to the front and the old one is removed
+	// once the cookies it sealed have expired. Cookies are sealed with the
+	// first key again whenever they change.
+	Keys [][]byte
+	// MaxAge is how long the state is valid after it was last changed. If
+	// it's 0 the cookie is a session cookie the browser forgets when it's
+	// closed, and is valid until then.
+	MaxAge time.Duration
+
+	// Cookie is the template for the cookie the state is kept in, only the
+	// Name, Path, Domain, Secure, HttpOnly and SameSite fields are used.
+	// With tenants the name is scoped to the tenant, see
+	// authboss.TenantCookie. authboss.Init sets its attributes from the
+	// Config, see ConfigureCookie.
+	Cookie http.Cookie
+
+	// Now tells the time, it's time.Now if it's nil. authboss.Init sets it
+	// to Authboss.Now, see ConfigureClock.
+	Now func() time.Time
+}
+
+// cookieStatePayload is what's sealed in the cookie
+type cookieStatePayload struct {
+	Expires int64             `json:"exp,omitempty"`
+	Values  map[string]string `json:"values"`
+}
+
+// NewCookieStateReadWriter creates a CookieStateReadWriter that keeps the
+// state in a secure, http only cookie for DefaultCookieStateMaxAge. It
+// fails if there are no keys or one of them isn't a valid AES key.
+func NewCookieStateReadWriter(keys ...[]byte) (*CookieStateReadWriter, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a client state key is required")
+	}
+	for _, key := range keys {
+		if _, err := newGCM(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &CookieStateReadWriter{
+		Keys:   keys,
+		MaxAge: DefaultCookieStateMaxAge,
+		Cookie: http.Cookie{
+			Name:     DefaultCookieStateCookie,
+			Path:     "/",
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}, nil
+}
+
+// ConfigureCookie sets the cookie's attributes, the Name is only changed if
+// the config has one
+func (c *CookieStateReadWriter) ConfigureCookie(cfg authboss.CookieConfig) {
+	cfg.Apply(&c.Cookie)
+}
+
+// ConfigureClock sets Now
+func (c *CookieStateReadWriter) ConfigureClock(now func() time.Time) {
+	c.Now = now
+}
+
+func (c *CookieStateReadWriter) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// ReadState from the request. A missing, expired, tampered with or
+// undecryptable cookie is treated as an empty state, the same as a client
+// without a cookie.
+func (c *CookieStateReadWriter) ReadState(r *http.Request) (authboss.ClientState, error) {
+	state := CookieState{}
+
+	name := authboss.TenantCookie(c.Cookie.Name, authboss.Tenant(r.Context()))
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return state, nil
+	}
+
+	plain, ok := openState(c.Keys, cookie.Value, []byte(name))
+	if !ok {
+		return state, nil
+	}
+
+	var payload cookieStatePayload
+	if err = json.Unmarshal(plain, &payload); err != nil {
+		return state, nil
+	}
+	if payload.Expires != 0 && c.now().Unix() >= payload.Expires {
+		return state, nil
+	}
+
+	for k, v := range payload.Values {
+		state[k] = v
+	}
+	return state, nil
+}
+
+// WriteState to the response, the cookie is only rewritten when the state
+// was changed.
+func (c *CookieStateReadWriter) WriteState(w http.ResponseWriter, cstate authboss.ClientState, events []authboss.ClientStateEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	state := map[string]string{}
+	if existing, ok := cstate.(CookieState); ok {
+		for k, v := range existing {
+			state[k] = v
+		}
+	}
+	applyStateEvents(state, events)
+
+	name := authboss.TenantCookie(c.Cookie.Name, authboss.ResponseTenant(w))
+	if len(state) == 0 {
+		c.write(w, name, "", -1)
+		return nil
+	}
+
+	payload := cookieStatePayload{Values: state}
+	if c.MaxAge > 0 {
+		payload.Expires = c.now().Add(c.MaxAge).Unix()
+	}
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode client state")
+	}
+
+	sealed, err := sealState(c.Keys, plain, []byte(name))
+	if err != nil {
+		return err
+	}
+	if len(name)+len(sealed) > maxCookieSize {
+		return errors.Errorf("client state is too large for the %s cookie", name)
+	}
+
+	c.write(w, name, sealed, int(c.MaxAge/time.Second))
+	return nil
+}
+
+func (c *CookieStateReadWriter) write(w http.ResponseWriter, name, value string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.Cookie.Path,
+		Domain:   c.Cookie.Domain,
+		MaxAge:   maxAge,
+		Secure:   c.Cookie.Secure,
+		HttpOnly: c.Cookie.HttpOnly,
+		SameSite: c.Cookie.SameSite,
+	}
+	if maxAge > 0 {
+		cookie.Expires = c.now().UTC().Add(time.Duration(maxAge) * time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// applyStateEvents to the state of a ClientStateReadWriter
+func applyStateEvents(state map[string]string, events []authboss.ClientStateEvent) {
+	for _, ev := range events {
+		switch ev.Kind {
+		case authboss.ClientStateEventPut:
+			state[ev.Key] = ev.Value
+		case authboss.ClientStateEventDel:
+			delete(state, ev.Key)
+		case authboss.ClientStateEventDelAll:
+			whitelist := strings.Split(ev.Key, ",")
+			for k := range state {
+				if !contains(whitelist, k) {
+					delete(state, k)
+				}
+			}
+		}
+	}
+}
+
+// sealState encrypts plain with the first key, the nonce is put in front
+func sealState(keys [][]byte, plain, additional []byte) (string, error) {
+	aead, err := newGCM(keys[0])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "failed to create client state nonce")
+	}
+
+	sealed := aead.Seal(nonce, nonce, plain, additional)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openState decrypts what sealState sealed with any of the keys
+func openState(keys [][]byte, value string, additional []byte) ([]byte, bool) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil || len(sealed) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plain, err := aead.Open(nil, nonce, ciphertext, additional); err == nil {
+			return plain, true
+		}
+	}
+
+	return nil, false
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid client state encryption key")
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,271 @@
+package defaults
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func testCookieState(t *testing.T, keys ...string) *CookieStateReadWriter {
+	t.Helper()
+
+	var bkeys [][]byte
+	for _, key := range keys {
+		bkeys = append(bkeys, []byte(key))
+	}
+	c, err := NewCookieStateReadWriter(bkeys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// readCookieState reads the state from the cookies of the response
+func readCookieState(t *testing.T, c *CookieStateReadWriter, rec *httptest.ResponseRecorder) authboss.ClientState {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	state, err := c.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestCookieStateRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	c := testCookieState(t, "0123456789abcdef")
+
+	rec := httptest.NewRecorder()
+	err := c.WriteState(rec, nil, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test@test.com"},
+		{Kind: authboss.ClientStateEventPut, Key: "flash_success", Value: "welcome"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := rec.Result().Cookies()[0]
+	if cookie.Name != DefaultCookieStateCookie || !cookie.HttpOnly || !cookie.Secure || cookie.MaxAge <= 0 {
+		t.Errorf("cookie was wrong: %#v", cookie)
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if bytes.Contains(raw, []byte("test@test.com")) || bytes.Contains(raw, []byte("welcome")) {
+		t.Error("the state should not be readable in the cookie")
+	}
+
+	state := readCookieState(t, c, rec)
+	if uid, ok := state.Get("uid"); !ok || uid != "test@test.com" {
+		t.Error("uid was wrong:", uid)
+	}
+
+	rec = httptest.NewRecorder()
+	err = c.WriteState(rec, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDelAll, Key: "flash_success"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state = readCookieState(t, c, rec)
+	if _, ok := state.Get("uid"); ok {
+		t.Error("uid should have been deleted")
+	}
+
+	rec = httptest.NewRecorder()
+	err = c.WriteState(rec, state, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventDel, Key: "flash_success"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie := rec.Result().Cookies()[0]; cookie.MaxAge >= 0 {
+		t.Error("the cookie should be deleted when the state is empty")
+	}
+}
+
+func TestCookieStateClock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := testCookieState(t, "0123456789abcdef")
+	c.ConfigureClock(func() time.Time { return now })
+
+	rec := httptest.NewRecorder()
+	err := c.WriteState(rec, nil, []authboss.ClientStateEvent{
+		{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test@test.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := readCookieState(t, c, rec).Get("uid"); !ok {
+		t.Error("the state should be valid before it expires")
+	}
+	now = now.Add(c.MaxAge)
+	if _, ok := readCookieState(t, c, rec).Get("uid"); ok {
+		t.Error("the state should expire by the read writer's clock")
+	}
+}
+
+func TestCookieStateInvalid(t *testing.T) {
+	t.Parallel()
+
+	c := testCookieState(t, "0123456789abcdef")
+	put := []authboss.ClientStateEvent{{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test"}}
+
+	rec := httptest.NewRecorder()
+	if err := c.WriteState(rec, nil, put); err != nil {
+		t.Fatal(err)
+	}
+	value := rec.Result().Cookies()[0].Value
+
+	read := func(name, value string) bool {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: name, Value: value})
+		state, err := c.ReadState(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := state.Get("uid")
+		return ok
+	}
+
+	if !read(DefaultCookieStateCookie, value) {
+		t.Error("the cookie should be read")
+	}
+
+	tampered := []byte(value)
+	tampered[len(tampered)-2] ^= 1
+	if read(DefaultCookieStateCookie, string(tampered)) {
+		t.Error("a tampered with state should be ignored")
+	}
+
+	// A state sealed for one cookie can't be used as another one
+	other := testCookieState(t, "0123456789abcdef")
+	other.Cookie.Name = "ab_session"
+	rec = httptest.NewRecorder()
+	if err := other.WriteState(rec, nil, put); err != nil {
+		t.Fatal(err)
+	}
+	if read(DefaultCookieStateCookie, rec.Result().Cookies()[0].Value) {
+		t.Error("a state sealed for another cookie should be ignored")
+	}
+
+	expired, err := json.Marshal(cookieStatePayload{
+		Expires: time.Now().Add(-time.Minute).Unix(),
+		Values:  map[string]string{"uid": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealState(c.Keys, expired, []byte(DefaultCookieStateCookie))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read(DefaultCookieStateCookie, sealed) {
+		t.Error("an expired state should be ignored")
+	}
+}
+
+func TestCookieStateRotation(t *testing.T) {
+	t.Parallel()
+
+	c := testCookieState(t, "0123456789abcdef")
+
+	rec := httptest.NewRecorder()
+	put := []authboss.ClientStateEvent{{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test"}}
+	if err := c.WriteState(rec, nil, put); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Keys = [][]byte{[]byte("fedcba9876543210fedcba9876543210"), []byte("0123456789abcdef")}
+	state := readCookieState(t, c, rec)
+	if uid, ok := state.Get("uid"); !ok || uid != "test" {
+		t.Error("state encrypted with an old key should be read:", uid)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := c.WriteState(rec, state, put); err != nil {
+		t.Fatal(err)
+	}
+	c.Keys = c.Keys[:1]
+	if state := readCookieState(t, c, rec); len(state.(CookieState)) != 1 {
+		t.Error("state should be written with the new key")
+	}
+}
+
+func TestCookieStateErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewCookieStateReadWriter(); err == nil {
+		t.Error("a key should be required")
+	}
+	if _, err := NewCookieStateReadWriter([]byte("0123456789abcdef"), []byte("short")); err == nil {
+		t.Error("invalid keys should be rejected")
+	}
+
+	c := testCookieState(t, "0123456789abcdef")
+	put := []authboss.ClientStateEvent{{Kind: authboss.ClientStateEventPut, Key: "big", Value: strings.Repeat("a", maxCookieSize)}}
+	if err := c.WriteState(httptest.NewRecorder(), nil, put); err == nil {
+		t.Error("a state too large for a cookie should be an error")
+	}
+}
+
+func TestCookieStateSession(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.TenantResolver = authboss.TenantFromHost()
+	session := testCookieState(t, "0123456789abcdef")
+	session.MaxAge = 0
+	ab.Config.Storage.SessionState = session
+	ab.Config.Storage.SessionStateCookie.Name = "ab_session"
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	w := ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "http://acme.example.com/", nil)
+	if _, err := ab.LoadClientState(w, r); err != nil {
+		t.Fatal(err)
+	}
+	authboss.PutSession(w, authboss.SessionKey, "test@test.com")
+	w.WriteHeader(http.StatusOK)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "ab_session_acme.example.com" || cookies[0].MaxAge != 0 {
+		t.Fatal("the cookie should be a session cookie named for the tenant:", cookies)
+	}
+
+	read := func(host, cookie string) bool {
+		r := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		r.AddCookie(&http.Cookie{Name: cookie, Value: cookies[0].Value})
+		r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := authboss.GetSession(r, authboss.SessionKey)
+		return ok
+	}
+
+	if !read("acme.example.com", cookies[0].Name) {
+		t.Error("the state should be read for its tenant")
+	}
+	if read("other.example.com", "ab_session_other.example.com") {
+		t.Error("the state should not be valid for another tenant")
+	}
+}
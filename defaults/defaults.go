@@ -16,14 +16,32 @@ import (
 // SetCore creates instances of all the default pieces
 // with the exception of ViewRenderer which should be already set
 // before calling this method.
+//
+// In NoRender mode (see Config.Core.NoRender) the ViewRenderer doesn't
+// need to be set, responses are rendered with the JSONRenderer.
+//
+// If Config.Modules.PIDField is set the PID is read from that field and
+// useUsername is ignored.
 func SetCore(config *authboss.Config, readJSON, useUsername bool) {
 	logger := NewLogger(os.Stdout)
 
+	renderer := config.Core.ViewRenderer
+	if renderer == nil && config.Core.NoRender {
+		renderer = JSONRenderer{}
+	}
+
 	config.Core.Router = NewRouter()
 	config.Core.ErrorHandler = NewErrorHandler(logger)
-	config.Core.Responder = NewResponder(config.Core.ViewRenderer)
-	config.Core.Redirector = NewRedirector(config.Core.ViewRenderer, authboss.FormValueRedirect)
-	config.Core.BodyReader = NewHTTPBodyReader(readJSON, useUsername)
+	config.Core.Responder = NewResponder(renderer)
+	config.Core.Redirector = NewRedirector(renderer, authboss.FormValueRedirect)
+	var bodyReader *HTTPBodyReader
+	if len(config.Modules.PIDField) != 0 {
+		bodyReader = NewHTTPBodyReaderPID(readJSON, Rules{FieldName: config.Modules.PIDField, Required: true})
+	} else {
+		bodyReader = NewHTTPBodyReader(readJSON, useUsername)
+	}
+	bodyReader.ExtraFields = config.Modules.RegisterExtraFields
+	config.Core.BodyReader = bodyReader
 	config.Core.Mailer = NewLogMailer(os.Stdout)
 	config.Core.Logger = logger
 }
@@ -37,3 +37,20 @@ func TestSetCore(t *testing.T) {
 		t.Error("logger should be set")
 	}
 }
+
+func TestSetCoreNoRender(t *testing.T) {
+	t.Parallel()
+
+	config := &authboss.Config{}
+	config.Core.NoRender = true
+	SetCore(config, true, false)
+
+	responder := config.Core.Responder.(*Responder)
+	if _, ok := responder.Renderer.(JSONRenderer); !ok {
+		t.Errorf("responder should render json: %T", responder.Renderer)
+	}
+	redirector := config.Core.Redirector.(*Redirector)
+	if _, ok := redirector.Renderer.(JSONRenderer); !ok {
+		t.Errorf("redirector should render json: %T", redirector.Renderer)
+	}
+}
@@ -0,0 +1,202 @@
+//go:build go1.16
+// +build go1.16
+
+package defaults
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"io/fs"
+	"sync"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// HTMLRenderer defaults
+const (
+	// DefaultHTMLExt is the extension of the files HTMLRenderer loads
+	// templates from
+	DefaultHTMLExt = ".tpl"
+	// DefaultLayout is the name of the layout template
+	DefaultLayout = "layout"
+	// DefaultContentBlock is the block of the layout pages fill in
+	DefaultContentBlock = "content"
+)
+
+// HTMLRenderer renders the pages of the modules from html templates in
+// file systems, like an embed.FS of the app's templates. It's a
+// ViewRenderer, see MailRenderer for e-mails.
+//
+// Pages share a layout: layout.tpl has the html document and blocks that
+// the pages fill in, at least a content block:
+//
+//	<html><head><title>{{block "title" .}}Log in{{end}}</title></head>
+//	<body>{{block "content" .}}{{end}}</body></html>
+//
+// and login.tpl defines them:
+//
+//	{{define "content"}}<form method="POST">...</form>{{end}}
+//
+// Pages without a content block are rendered as they are. Only the content
+// block is rendered when there's no layout file and for partial renders
+// (authboss.DataPartial, see Responder.Partials).
+//
+// Each template is looked for in the file systems in order, so the app's
+// templates can come before a set of stock ones: overriding the login page
+// only takes a login.tpl, and the layout only a layout.tpl.
+type HTMLRenderer struct {
+	// FS are the file systems to load templates from, earlier ones
+	// override later ones.
+	FS []fs.FS
+	// Ext is the extension of the template files, DefaultHTMLExt if empty
+	Ext string
+	// Layout is the name of the layout template, DefaultLayout if empty
+	Layout string
+	// Funcs are given to every template
+	Funcs template.FuncMap
+	// Reload parses the templates again for every render so that changes
+	// show up without restarting, it's meant for development.
+	Reload bool
+
+	mut       sync.RWMutex
+	templates map[string]*template.Template
+}
+
+// NewHTMLRenderer creates an HTMLRenderer for the file systems, earlier
+// ones override later ones.
+func NewHTMLRenderer(fsys ...fs.FS) *HTMLRenderer {
+	return &HTMLRenderer{FS: fsys}
+}
+
+// Load parses the pages with the layout, it fails if one of them has no
+// template file.
+func (h *HTMLRenderer) Load(names ...string) error {
+	for _, name := range names {
+		tpl, err := h.parse(name)
+		if err != nil {
+			return err
+		}
+
+		if !h.Reload {
+			h.store(name, tpl)
+		}
+	}
+
+	return nil
+}
+
+// Render the page
+func (h *HTMLRenderer) Render(ctx context.Context, page string, data authboss.HTMLData) ([]byte, string, error) {
+	tpl, err := h.template(page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if partial, _ := data[authboss.DataPartial].(bool); partial {
+		if content := tpl.Lookup(DefaultContentBlock); content != nil {
+			tpl = content
+		}
+	}
+
+	b := &bytes.Buffer{}
+	if err := tpl.Execute(b, data); err != nil {
+		return nil, "", errors.Wrapf(err, "failed to render page %q", page)
+	}
+
+	return b.Bytes(), "text/html", nil
+}
+
+// template is the parsed page, parsed templates are kept for next time
+// unless Reload is set
+func (h *HTMLRenderer) template(page string) (*template.Template, error) {
+	if !h.Reload {
+		h.mut.RLock()
+		tpl, ok := h.templates[page]
+		h.mut.RUnlock()
+		if ok {
+			return tpl, nil
+		}
+	}
+
+	tpl, err := h.parse(page)
+	if err != nil {
+		return nil, err
+	}
+
+	if !h.Reload {
+		h.store(page, tpl)
+	}
+	return tpl, nil
+}
+
+func (h *HTMLRenderer) store(page string, tpl *template.Template) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	if h.templates == nil {
+		h.templates = make(map[string]*template.Template)
+	}
+	h.templates[page] = tpl
+}
+
+// parse the page into the layout
+func (h *HTMLRenderer) parse(page string) (*template.Template, error) {
+	pageText, err := h.readFile(page)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl, err := template.New(page).Funcs(h.Funcs).Parse(pageText)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse page %q", page)
+	}
+	if tpl.Lookup(DefaultContentBlock) == nil {
+		return tpl, nil
+	}
+
+	layout := h.Layout
+	if len(layout) == 0 {
+		layout = DefaultLayout
+	}
+	layoutText, err := h.readFile(layout)
+	if errors.Is(err, authboss.ErrTemplateNotFound) {
+		return tpl.Lookup(DefaultContentBlock), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The page's blocks are parsed after the layout's to override them
+	tpl, err = template.New(layout).Funcs(h.Funcs).Parse(layoutText)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse layout %q", layout)
+	}
+	if _, err = tpl.New(page).Parse(pageText); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse page %q", page)
+	}
+
+	return tpl, nil
+}
+
+// readFile reads the template from the first file system that has it
+func (h *HTMLRenderer) readFile(name string) (string, error) {
+	ext := h.Ext
+	if len(ext) == 0 {
+		ext = DefaultHTMLExt
+	}
+
+	file := name + ext
+	for _, fsys := range h.FS {
+		b, err := fs.ReadFile(fsys, file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", errors.Wrapf(err, "failed to read template %s", file)
+		}
+		return string(b), nil
+	}
+
+	return "", errors.Wrapf(authboss.ErrTemplateNotFound, "no template file found for page %q", name)
+}
@@ -0,0 +1,109 @@
+//go:build go1.16
+// +build go1.16
+
+package defaults
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestHTMLRendererLayout(t *testing.T) {
+	t.Parallel()
+
+	stock := fstest.MapFS{
+		"layout.tpl":  {Data: []byte(`<title>{{block "title" .}}Auth{{end}}</title><main>{{block "content" .}}{{end}}</main>`)},
+		"login.tpl":   {Data: []byte(`{{define "content"}}stock login {{.error}}{{end}}`)},
+		"recover.tpl": {Data: []byte(`{{define "title"}}Recover{{end}}{{define "content"}}stock recover{{end}}`)},
+		"plain.tpl":   {Data: []byte(`<p>no layout {{upper "here"}}</p>`)},
+	}
+	app := fstest.MapFS{
+		"login.tpl": {Data: []byte(`{{define "title"}}Log in{{end}}{{define "content"}}app login {{.error}}{{end}}`)},
+	}
+
+	h := NewHTMLRenderer(app, stock)
+	h.Funcs = map[string]interface{}{"upper": func(s string) string { return "HERE" }}
+	if err := h.Load("login", "recover", "plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Page string
+		Data authboss.HTMLData
+		Want string
+	}{
+		{"login", authboss.HTMLData{authboss.DataErr: "oops"}, `<title>Log in</title><main>app login oops</main>`},
+		{"recover", nil, `<title>Recover</title><main>stock recover</main>`},
+		{"plain", nil, `<p>no layout HERE</p>`},
+		{"login", authboss.HTMLData{authboss.DataPartial: true}, `app login `},
+	}
+
+	for _, test := range tests {
+		b, mime, err := h.Render(context.Background(), test.Page, test.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mime != "text/html" {
+			t.Error("mime was wrong:", mime)
+		}
+		if string(b) != test.Want {
+			t.Errorf("%s was wrong:\nwant: %s\ngot:  %s", test.Page, test.Want, b)
+		}
+	}
+}
+
+func TestHTMLRendererNoLayout(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTMLRenderer(fstest.MapFS{
+		"login.tpl": {Data: []byte(`{{define "content"}}login{{end}}`)},
+	})
+
+	b, _, err := h.Render(context.Background(), "login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "login" {
+		t.Error("without a layout the content should be rendered:", string(b))
+	}
+}
+
+func TestHTMLRendererNotFound(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTMLRenderer(fstest.MapFS{})
+	if err := h.Load("login"); !errors.Is(err, authboss.ErrTemplateNotFound) {
+		t.Error("load should fail for missing pages:", err)
+	}
+	if _, _, err := h.Render(context.Background(), "login_partial", nil); !errors.Is(err, authboss.ErrTemplateNotFound) {
+		t.Error("it should be ErrTemplateNotFound:", err)
+	}
+}
+
+func TestHTMLRendererReload(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{"login.tpl": {Data: []byte(`one`)}}
+	h := NewHTMLRenderer(fsys)
+	h.Reload = true
+
+	if b, _, _ := h.Render(context.Background(), "login", nil); string(b) != "one" {
+		t.Error("wrong render:", string(b))
+	}
+	fsys["login.tpl"] = &fstest.MapFile{Data: []byte(`two`)}
+	if b, _, _ := h.Render(context.Background(), "login", nil); string(b) != "two" {
+		t.Error("the change should show up with Reload:", string(b))
+	}
+
+	h.Reload = false
+	_, _, _ = h.Render(context.Background(), "login", nil)
+	fsys["login.tpl"] = &fstest.MapFile{Data: []byte(`three`)}
+	if b, _, _ := h.Render(context.Background(), "login", nil); string(b) != "two" {
+		t.Error("the template should be cached without Reload:", string(b))
+	}
+}
@@ -0,0 +1,244 @@
+package defaults
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+const (
+	// DefaultJWTStateCookie is the cookie NewJWTStateReadWriter uses
+	DefaultJWTStateCookie = "ab_state"
+	// DefaultJWTStateLifetime is the lifetime NewJWTStateReadWriter uses
+	DefaultJWTStateLifetime = 24 * time.Hour
+
+	jwtStateClaim = "state"
+)
+
+// JWTState is the client state read by JWTStateReadWriter
+type JWTState map[string]string
+
+// Get a value from the state
+func (j JWTState) Get(key string) (string, bool) {
+	val, ok := j[key]
+	return val, ok
+}
+
+// JWTStateReadWriter is a ClientStateReadWriter that keeps the whole
+// client state in a signed JWT carried by the client in a cookie or a
+// header. Nothing is stored on the server so any server behind a load
+// balancer can read the state as long as they share the keys.
+//
+// The state can't be revoked before it expires, a copy of an old token
+// is valid until then even after the user logs out.
+type JWTStateReadWriter struct {
+	// Signer signs the state
+	Signer jwt.Signer
+	// Keys verify the state. To rotate keys give the new Signer a different
+	// key id and keep the old key here (see jwt.KeyIDs) until the states
+	// signed with it have expired.
+	Keys jwt.KeyFunc
+
+	// EncryptionKeys if set encrypt the state with AES-GCM so the client
+	// can't read it. The first key encrypts and all of them are tried to
+	// decrypt, so a new key is rotated in by adding it to the front. Keys
+	// must be 16, 24 or 32 bytes long.
+	EncryptionKeys [][]byte
+
+	// Lifetime is how long the state is valid after it was last changed
+	Lifetime time.Duration
+
+	// Cookie is the template for the cookie the state is kept in, only the
+	// Name, Path, Domain, Secure, HttpOnly and SameSite fields are used.
+	// With tenants the name is scoped to the tenant (see
+	// authboss.TenantCookie) and the state is only valid for its tenant.
+	// authboss.Init sets its attributes from the Config, see
+	// ConfigureCookie.
+	Cookie http.Cookie
+	// Header if set is used to carry the state instead of the cookie, it's
+	// read from the request header and written to the response header of
+	// the same name. For the Authorization header the state is read from
+	// a Bearer token.
+	Header string
+
+	// Now tells the time, it's time.Now if it's nil. authboss.Init sets it
+	// to Authboss.Now, see ConfigureClock.
+	Now func() time.Time
+}
+
+// NewJWTStateReadWriter creates a JWTStateReadWriter that keeps the state
+// in a secure, http only cookie for DefaultJWTStateLifetime.
+func NewJWTStateReadWriter(signer jwt.Signer, keys jwt.KeyFunc) *JWTStateReadWriter {
+	return &JWTStateReadWriter{
+		Signer:   signer,
+		Keys:     keys,
+		Lifetime: DefaultJWTStateLifetime,
+		Cookie: http.Cookie{
+			Name:     DefaultJWTStateCookie,
+			Path:     "/",
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// ConfigureCookie sets the cookie's attributes, the Name is only changed if
+// the config has one
+func (j *JWTStateReadWriter) ConfigureCookie(c authboss.CookieConfig) {
+	c.Apply(&j.Cookie)
+}
+
+// ConfigureClock sets Now
+func (j *JWTStateReadWriter) ConfigureClock(now func() time.Time) {
+	j.Now = now
+}
+
+func (j *JWTStateReadWriter) now() time.Time {
+	if j.Now == nil {
+		return time.Now()
+	}
+	return j.Now()
+}
+
+// ReadState from the request. A missing, expired or invalid token is
+// treated as an empty state, the same as a client without a cookie.
+func (j *JWTStateReadWriter) ReadState(r *http.Request) (authboss.ClientState, error) {
+	state := JWTState{}
+
+	token := j.read(r)
+	if len(token) == 0 {
+		return state, nil
+	}
+
+	if len(j.EncryptionKeys) != 0 {
+		decrypted, ok := openState(j.EncryptionKeys, token, nil)
+		if !ok {
+			return state, nil
+		}
+		token = string(decrypted)
+	}
+
+	_, claims, err := jwt.Parse(token, j.Keys)
+	if err != nil {
+		return state, nil
+	}
+	var expect jwt.Expectations
+	if tenant := authboss.Tenant(r.Context()); len(tenant) != 0 {
+		expect.Audiences = []string{tenant}
+	}
+	if err = claims.Validate(j.now(), expect); err != nil {
+		return state, nil
+	}
+
+	values, _ := claims[jwtStateClaim].(map[string]interface{})
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			state[k] = s
+		}
+	}
+
+	return state, nil
+}
+
+// WriteState to the response, the token is only rewritten when the state
+// was changed.
+func (j *JWTStateReadWriter) WriteState(w http.ResponseWriter, cstate authboss.ClientState, events []authboss.ClientStateEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	state := JWTState{}
+	if existing, ok := cstate.(JWTState); ok {
+		for k, v := range existing {
+			state[k] = v
+		}
+	}
+
+	applyStateEvents(state, events)
+
+	if len(state) == 0 {
+		j.write(w, "", -1)
+		return nil
+	}
+
+	now := j.now().UTC()
+	claims := jwt.Claims{
+		"iat":         now.Unix(),
+		"exp":         now.Add(j.Lifetime).Unix(),
+		jwtStateClaim: map[string]string(state),
+	}
+	if tenant := authboss.ResponseTenant(w); len(tenant) != 0 {
+		claims["aud"] = tenant
+	}
+	token, err := jwt.Sign(claims, j.Signer)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign client state")
+	}
+
+	if len(j.EncryptionKeys) != 0 {
+		if token, err = sealState(j.EncryptionKeys, []byte(token), nil); err != nil {
+			return err
+		}
+	}
+
+	j.write(w, token, int(j.Lifetime/time.Second))
+	return nil
+}
+
+func (j *JWTStateReadWriter) read(r *http.Request) string {
+	if len(j.Header) == 0 {
+		cookie, err := r.Cookie(authboss.TenantCookie(j.Cookie.Name, authboss.Tenant(r.Context())))
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+
+	value := r.Header.Get(j.Header)
+	if strings.EqualFold(j.Header, "Authorization") {
+		const prefix = "bearer "
+		if len(value) <= len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+			return ""
+		}
+		value = value[len(prefix):]
+	}
+
+	return strings.TrimSpace(value)
+}
+
+func (j *JWTStateReadWriter) write(w http.ResponseWriter, token string, maxAge int) {
+	if len(j.Header) != 0 {
+		w.Header().Set(j.Header, token)
+		return
+	}
+
+	cookie := &http.Cookie{
+		Name:     authboss.TenantCookie(j.Cookie.Name, authboss.ResponseTenant(w)),
+		Value:    token,
+		Path:     j.Cookie.Path,
+		Domain:   j.Cookie.Domain,
+		MaxAge:   maxAge,
+		Secure:   j.Cookie.Secure,
+		HttpOnly: j.Cookie.HttpOnly,
+		SameSite: j.Cookie.SameSite,
+	}
+	if maxAge > 0 {
+		cookie.Expires = j.now().UTC().Add(time.Duration(maxAge) * time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
+
+func contains(arr []string, s string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
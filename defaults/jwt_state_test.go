@@ -0,0 +1,219 @@
+package defaults
+
+import (
+	"crypto"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+func testJWTState() *JWTStateReadWriter {
+	secret := []byte("secret")
+	return NewJWTStateReadWriter(jwt.HMACSigner{Key: secret, KID: "1"}, jwt.KeyIDs(map[string]crypto.PublicKey{"1": secret}))
+}
+
+// roundTrip writes the events and reads the state back from the response
+func roundTrip(t *testing.T, j *JWTStateReadWriter, state authboss.ClientState, events ...authboss.ClientStateEvent) (authboss.ClientState, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := j.WriteState(rec, state, events); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if len(j.Header) != 0 {
+		r.Header.Set(j.Header, rec.Header().Get(j.Header))
+	} else {
+		for _, c := range rec.Result().Cookies() {
+			r.AddCookie(c)
+		}
+	}
+
+	read, err := j.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read, rec
+}
+
+func TestJWTStateRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	j := testJWTState()
+
+	state, rec := roundTrip(t, j, nil,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test@test.com"},
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "other", Value: "value"},
+	)
+
+	if uid, ok := state.Get("uid"); !ok || uid != "test@test.com" {
+		t.Error("uid was wrong:", uid)
+	}
+
+	cookie := rec.Result().Cookies()[0]
+	if cookie.Name != DefaultJWTStateCookie || !cookie.HttpOnly || !cookie.Secure || cookie.MaxAge <= 0 {
+		t.Errorf("cookie was wrong: %#v", cookie)
+	}
+
+	state, _ = roundTrip(t, j, state,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventDelAll, Key: "other"},
+	)
+	if _, ok := state.Get("uid"); ok {
+		t.Error("uid should have been deleted")
+	}
+	if _, ok := state.Get("other"); !ok {
+		t.Error("whitelisted keys should be kept")
+	}
+
+	_, rec = roundTrip(t, j, state, authboss.ClientStateEvent{Kind: authboss.ClientStateEventDel, Key: "other"})
+	if cookie := rec.Result().Cookies()[0]; cookie.MaxAge >= 0 {
+		t.Error("the cookie should be deleted when the state is empty")
+	}
+}
+
+func TestJWTStateNoEvents(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	if err := testJWTState().WriteState(rec, JWTState{"uid": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("nothing should be written when the state hasn't changed")
+	}
+}
+
+func TestJWTStateInvalid(t *testing.T) {
+	t.Parallel()
+
+	j := testJWTState()
+	put := authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test"}
+
+	other := testJWTState()
+	other.Signer = jwt.HMACSigner{Key: []byte("forged"), KID: "1"}
+	rec := httptest.NewRecorder()
+	if err := other.WriteState(rec, nil, []authboss.ClientStateEvent{put}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	if state, err := j.ReadState(r); err != nil {
+		t.Fatal(err)
+	} else if _, ok := state.Get("uid"); ok {
+		t.Error("a state with a bad signature should be ignored")
+	}
+
+	j.Lifetime = -time.Minute
+	if state, _ := roundTrip(t, j, nil, put); len(state.(JWTState)) != 0 {
+		t.Error("an expired state should be ignored")
+	}
+}
+
+func TestJWTStateRotation(t *testing.T) {
+	t.Parallel()
+
+	old, renewed := []byte("old"), []byte("new")
+	keys := jwt.KeyIDs(map[string]crypto.PublicKey{"old": old, "new": renewed})
+
+	j := NewJWTStateReadWriter(jwt.HMACSigner{Key: old, KID: "old"}, keys)
+	j.EncryptionKeys = [][]byte{[]byte("0123456789abcdef")}
+
+	rec := httptest.NewRecorder()
+	put := authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test"}
+	if err := j.WriteState(rec, nil, []authboss.ClientStateEvent{put}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate both keys, the old state should still be readable
+	j.Signer = jwt.HMACSigner{Key: renewed, KID: "new"}
+	j.EncryptionKeys = [][]byte{[]byte("fedcba9876543210"), []byte("0123456789abcdef")}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	state, err := j.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, ok := state.Get("uid"); !ok || uid != "test" {
+		t.Error("state signed and encrypted with an old key should be read:", uid)
+	}
+
+	if state, _ := roundTrip(t, j, state, put); len(state.(JWTState)) != 1 {
+		t.Error("state should be written with the new keys")
+	}
+}
+
+func TestJWTStateHeader(t *testing.T) {
+	t.Parallel()
+
+	j := testJWTState()
+	j.Header = "Authorization"
+
+	rec := httptest.NewRecorder()
+	put := authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "uid", Value: "test"}
+	if err := j.WriteState(rec, nil, []authboss.ClientStateEvent{put}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+rec.Header().Get("Authorization"))
+	state, err := j.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, ok := state.Get("uid"); !ok || uid != "test" {
+		t.Error("uid was wrong:", uid)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultJWTStateCookie, Value: rec.Header().Get("Authorization")})
+	if state, _ := j.ReadState(r); len(state.(JWTState)) != 0 {
+		t.Error("the cookie should not be read in header mode")
+	}
+}
+
+func TestJWTStateTenant(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.TenantResolver = authboss.TenantFromHost()
+	ab.Config.Storage.SessionState = testJWTState()
+
+	rec := httptest.NewRecorder()
+	w := ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "http://acme.example.com/", nil)
+	if _, err := ab.LoadClientState(w, r); err != nil {
+		t.Fatal(err)
+	}
+	authboss.PutSession(w, authboss.SessionKey, "test@test.com")
+	w.WriteHeader(http.StatusOK)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultJWTStateCookie+"_acme.example.com" {
+		t.Fatal("the cookie should be named for the tenant:", cookies)
+	}
+
+	read := func(host, cookie string) bool {
+		r := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		r.AddCookie(&http.Cookie{Name: cookie, Value: cookies[0].Value})
+		r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := authboss.GetSession(r, authboss.SessionKey)
+		return ok
+	}
+
+	if !read("acme.example.com", cookies[0].Name) {
+		t.Error("the state should be read for its tenant")
+	}
+	if read("other.example.com", DefaultJWTStateCookie+"_other.example.com") {
+		t.Error("the state should not be valid for another tenant")
+	}
+}
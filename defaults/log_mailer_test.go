@@ -59,7 +59,7 @@ func TestMailer(t *testing.T) {
 func TestBoundary(t *testing.T) {
 	t.Parallel()
 
-	mailer := &SMTPMailer{"server", nil, rand.New(rand.NewSource(3))}
+	mailer := &SMTPMailer{Server: "server", rand: rand.New(rand.NewSource(3))}
 	if got := mailer.boundary(); got != "fe3fhpsm69lx8jvnrnju0wr" {
 		t.Error("boundary was wrong", got)
 	}
@@ -0,0 +1,230 @@
+//go:build go1.16
+// +build go1.16
+
+package defaults
+
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// DefaultMailExt is the extension of the files MailRenderer loads
+// templates from
+const DefaultMailExt = ".tpl"
+
+// MailRenderer renders e-mail templates from file systems, like an embed.FS
+// of the app's templates. Templates whose names end in _html are html
+// templates, the others are text templates. Each module's e-mail
+// templates come as a pair (confirm_html and confirm_txt), authboss.Email
+// makes the missing part when there's only one of them.
+//
+// A template can be overridden for a module and a locale by putting it in
+// a directory named after them. For the confirm_html template sent by the
+// confirm module in the fr-CA locale these are tried in order:
+//
+//	confirm/fr-CA/confirm_html.tpl
+//	confirm/fr/confirm_html.tpl
+//	confirm/confirm_html.tpl
+//	fr-CA/confirm_html.tpl
+//	fr/confirm_html.tpl
+//	confirm_html.tpl
+//
+// Each path is looked for in the file systems in order, so the app's
+// templates can come before a set of stock ones and only override some.
+type MailRenderer struct {
+	// FS are the file systems to load templates from, earlier ones
+	// override later ones.
+	FS []fs.FS
+	// Ext is the extension of the template files, DefaultMailExt if empty
+	Ext string
+	// Funcs are given to every template
+	Funcs map[string]interface{}
+
+	mut       sync.RWMutex
+	templates map[string]executor
+}
+
+// executor is what html and text templates have in common
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// NewMailRenderer creates a MailRenderer for the file systems, earlier
+// ones override later ones.
+func NewMailRenderer(fsys ...fs.FS) *MailRenderer {
+	return &MailRenderer{FS: fsys}
+}
+
+// Load checks that there's a template file for each of the names, with
+// or without a module and locale. Only one of an _html and _txt pair is
+// needed.
+func (m *MailRenderer) Load(names ...string) error {
+	found := make(map[string]bool)
+	for _, name := range names {
+		ok, err := m.exists(name)
+		if err != nil {
+			return err
+		}
+		found[pairName(name)] = found[pairName(name)] || ok
+	}
+
+	for _, name := range names {
+		if !found[pairName(name)] {
+			return errors.Errorf("no template file found for e-mail template %q", name)
+		}
+	}
+
+	return nil
+}
+
+// Render the template for the module and locale of the e-mail, see
+// authboss.DataModule and authboss.DataLocale
+func (m *MailRenderer) Render(ctx context.Context, name string, data authboss.HTMLData) ([]byte, string, error) {
+	module, _ := data[authboss.DataModule].(string)
+	locale, _ := data[authboss.DataLocale].(string)
+	if len(locale) == 0 {
+		locale, _ = ctx.Value(authboss.CTXKeyLocale).(string)
+	}
+
+	tpl, err := m.template(name, module, locale)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := &bytes.Buffer{}
+	if err := tpl.Execute(b, data); err != nil {
+		return nil, "", errors.Wrapf(err, "failed to render e-mail template %q", name)
+	}
+
+	if isHTMLTemplate(name) {
+		return b.Bytes(), "text/html", nil
+	}
+	return b.Bytes(), "text/plain", nil
+}
+
+// template finds the template's file for the module and locale and parses
+// it, parsed templates are kept for next time
+func (m *MailRenderer) template(name, module, locale string) (executor, error) {
+	for _, p := range m.candidates(name, module, locale) {
+		m.mut.RLock()
+		tpl, ok := m.templates[p]
+		m.mut.RUnlock()
+		if ok {
+			return tpl, nil
+		}
+
+		for _, fsys := range m.FS {
+			b, err := fs.ReadFile(fsys, p)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "failed to read e-mail template %s", p)
+			}
+
+			tpl, err := m.parse(name, string(b))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse e-mail template %s", p)
+			}
+
+			m.mut.Lock()
+			if m.templates == nil {
+				m.templates = make(map[string]executor)
+			}
+			m.templates[p] = tpl
+			m.mut.Unlock()
+
+			return tpl, nil
+		}
+	}
+
+	return nil, errors.Wrapf(authboss.ErrTemplateNotFound, "no template file found for e-mail template %q", name)
+}
+
+func (m *MailRenderer) parse(name, text string) (executor, error) {
+	if isHTMLTemplate(name) {
+		tpl, err := htmltemplate.New(name).Funcs(m.Funcs).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return tpl, nil
+	}
+
+	tpl, err := texttemplate.New(name).Funcs(m.Funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+// candidates are the paths tried for the template, most specific first
+func (m *MailRenderer) candidates(name, module, locale string) []string {
+	file := name + m.ext()
+
+	var locales []string
+	if len(locale) != 0 {
+		locales = append(locales, locale)
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			locales = append(locales, locale[:i])
+		}
+	}
+
+	var dirs []string
+	if len(module) != 0 {
+		for _, l := range locales {
+			dirs = append(dirs, path.Join(module, l))
+		}
+		dirs = append(dirs, module)
+	}
+	dirs = append(dirs, locales...)
+
+	paths := make([]string, 0, len(dirs)+1)
+	for _, dir := range dirs {
+		paths = append(paths, path.Join(dir, file))
+	}
+	return append(paths, file)
+}
+
+// exists looks for the template's file at the top of the file systems and
+// in directories up to two deep (module and locale)
+func (m *MailRenderer) exists(name string) (bool, error) {
+	file := name + m.ext()
+	for _, fsys := range m.FS {
+		for _, pattern := range []string{file, path.Join("*", file), path.Join("*", "*", file)} {
+			matches, err := fs.Glob(fsys, pattern)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to look for e-mail template %q", name)
+			}
+			if len(matches) != 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *MailRenderer) ext() string {
+	if len(m.Ext) == 0 {
+		return DefaultMailExt
+	}
+	return m.Ext
+}
+
+// pairName is the name of the template without its _html or _txt suffix
+func pairName(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, "_html"), "_txt")
+}
+
+func isHTMLTemplate(name string) bool {
+	return strings.HasSuffix(name, "_html")
+}
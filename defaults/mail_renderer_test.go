@@ -0,0 +1,70 @@
+//go:build go1.16
+// +build go1.16
+
+package defaults
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestMailRenderer(t *testing.T) {
+	t.Parallel()
+
+	app := fstest.MapFS{
+		"confirm/fr/confirm_html.tpl": {Data: []byte(`<a href="{{.url}}">Confirmez</a>`)},
+		"fr/recover_txt.tpl":          {Data: []byte(`Réinitialiser: {{.url}}`)},
+	}
+	stock := fstest.MapFS{
+		"confirm_html.tpl": {Data: []byte(`<a href="{{.url}}">Confirm</a>`)},
+		"confirm_txt.tpl":  {Data: []byte(`Confirm: {{.url}}`)},
+		"recover_html.tpl": {Data: []byte(`<a href="{{.url}}">Reset</a>`)},
+	}
+	m := NewMailRenderer(app, stock)
+
+	if err := m.Load("confirm_html", "confirm_txt", "recover_html", "recover_txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load("unlock_html", "unlock_txt"); err == nil {
+		t.Error("templates without files should fail to load")
+	}
+
+	tests := []struct {
+		Name   string
+		Module string
+		Locale string
+		Want   string
+		Type   string
+	}{
+		{"confirm_html", "confirm", "fr-CA", `<a href="http://localhost/?a=1&amp;b=2">Confirmez</a>`, "text/html"},
+		{"confirm_html", "confirm", "de", `<a href="http://localhost/?a=1&amp;b=2">Confirm</a>`, "text/html"},
+		{"confirm_txt", "confirm", "fr", `Confirm: http://localhost/?a=1&b=2`, "text/plain"},
+		{"recover_txt", "recover", "fr", `Réinitialiser: http://localhost/?a=1&b=2`, "text/plain"},
+	}
+
+	for _, test := range tests {
+		data := authboss.HTMLData{
+			"url":               "http://localhost/?a=1&b=2",
+			authboss.DataModule: test.Module,
+			authboss.DataLocale: test.Locale,
+		}
+		b, contentType, err := m.Render(context.Background(), test.Name, data)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.Name, test.Locale, err)
+			continue
+		}
+		if string(b) != test.Want || contentType != test.Type {
+			t.Errorf("%s %s: got %s (%s)", test.Name, test.Locale, b, contentType)
+		}
+	}
+
+	_, _, err := m.Render(context.Background(), "recover_txt", authboss.HTMLData{authboss.DataLocale: "de"})
+	if !errors.Is(err, authboss.ErrTemplateNotFound) {
+		t.Error("missing templates should be ErrTemplateNotFound:", err)
+	}
+}
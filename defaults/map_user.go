@@ -0,0 +1,255 @@
+package defaults
+
+import (
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// MapUser is a user kept as key-value data, for storers that load users
+// as maps (database rows, documents, hashes) instead of structs. The keys
+// are authboss' attribute names (see authboss.AttributePID and friends)
+// mapped with Attributes, so a schema with its own naming conventions can
+// be used without a wrapper struct.
+//
+// It implements the authboss user interfaces as well as the totp2fa and
+// sms2fa ones. Values are expected to be strings, bools, ints and
+// time.Times, anything else reads as the zero value.
+type MapUser struct {
+	Attributes authboss.AttributeMap
+	Values     map[string]interface{}
+}
+
+// NewMapUser creates a user from values loaded by the storer, usually with
+// ab.Config.Storage.Attributes. If values is nil a new map is made.
+func NewMapUser(attributes authboss.AttributeMap, values map[string]interface{}) *MapUser {
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+
+	return &MapUser{Attributes: attributes, Values: values}
+}
+
+func (m *MapUser) get(attribute string) interface{} {
+	return m.Values[m.Attributes.Name(attribute)]
+}
+
+func (m *MapUser) put(attribute string, value interface{}) {
+	m.Values[m.Attributes.Name(attribute)] = value
+}
+
+func (m *MapUser) getString(attribute string) string {
+	s, _ := m.get(attribute).(string)
+	return s
+}
+
+func (m *MapUser) getBool(attribute string) bool {
+	b, _ := m.get(attribute).(bool)
+	return b
+}
+
+func (m *MapUser) getTime(attribute string) time.Time {
+	t, _ := m.get(attribute).(time.Time)
+	return t
+}
+
+func (m *MapUser) getInt(attribute string) int {
+	switch i := m.get(attribute).(type) {
+	case int:
+		return i
+	case int64:
+		return int(i)
+	case int32:
+		return int(i)
+	case float64:
+		return int(i)
+	}
+	return 0
+}
+
+// GetPID from user
+func (m *MapUser) GetPID() string { return m.getString(authboss.AttributePID) }
+
+// PutPID into user
+func (m *MapUser) PutPID(pid string) { m.put(authboss.AttributePID, pid) }
+
+// GetEmail from user
+func (m *MapUser) GetEmail() string { return m.getString(authboss.AttributeEmail) }
+
+// PutEmail into user
+func (m *MapUser) PutEmail(email string) { m.put(authboss.AttributeEmail, email) }
+
+// GetUsername from user
+func (m *MapUser) GetUsername() string { return m.getString(authboss.AttributeUsername) }
+
+// PutUsername into user
+func (m *MapUser) PutUsername(username string) { m.put(authboss.AttributeUsername, username) }
+
+// GetLastRename from user
+func (m *MapUser) GetLastRename() time.Time { return m.getTime(authboss.AttributeLastRename) }
+
+// PutLastRename into user
+func (m *MapUser) PutLastRename(last time.Time) { m.put(authboss.AttributeLastRename, last) }
+
+// GetPassword from user
+func (m *MapUser) GetPassword() string { return m.getString(authboss.AttributePassword) }
+
+// PutPassword into user
+func (m *MapUser) PutPassword(password string) { m.put(authboss.AttributePassword, password) }
+
+// GetAccountType from user
+func (m *MapUser) GetAccountType() string { return m.getString(authboss.AttributeAccountType) }
+
+// PutAccountType into user
+func (m *MapUser) PutAccountType(accountType string) {
+	m.put(authboss.AttributeAccountType, accountType)
+}
+
+// GetConfirmed from user
+func (m *MapUser) GetConfirmed() bool { return m.getBool(authboss.AttributeConfirmed) }
+
+// PutConfirmed into user
+func (m *MapUser) PutConfirmed(confirmed bool) { m.put(authboss.AttributeConfirmed, confirmed) }
+
+// GetConfirmSelector from user
+func (m *MapUser) GetConfirmSelector() string {
+	return m.getString(authboss.AttributeConfirmSelector)
+}
+
+// PutConfirmSelector into user
+func (m *MapUser) PutConfirmSelector(selector string) {
+	m.put(authboss.AttributeConfirmSelector, selector)
+}
+
+// GetConfirmVerifier from user
+func (m *MapUser) GetConfirmVerifier() string {
+	return m.getString(authboss.AttributeConfirmVerifier)
+}
+
+// PutConfirmVerifier into user
+func (m *MapUser) PutConfirmVerifier(verifier string) {
+	m.put(authboss.AttributeConfirmVerifier, verifier)
+}
+
+// GetConfirmExpiry from user
+func (m *MapUser) GetConfirmExpiry() time.Time { return m.getTime(authboss.AttributeConfirmExpiry) }
+
+// PutConfirmExpiry into user
+func (m *MapUser) PutConfirmExpiry(expiry time.Time) {
+	m.put(authboss.AttributeConfirmExpiry, expiry)
+}
+
+// GetAttemptCount from user
+func (m *MapUser) GetAttemptCount() int { return m.getInt(authboss.AttributeAttemptCount) }
+
+// PutAttemptCount into user
+func (m *MapUser) PutAttemptCount(attempts int) { m.put(authboss.AttributeAttemptCount, attempts) }
+
+// GetLastAttempt from user
+func (m *MapUser) GetLastAttempt() time.Time { return m.getTime(authboss.AttributeLastAttempt) }
+
+// PutLastAttempt into user
+func (m *MapUser) PutLastAttempt(last time.Time) { m.put(authboss.AttributeLastAttempt, last) }
+
+// GetLocked from user
+func (m *MapUser) GetLocked() time.Time { return m.getTime(authboss.AttributeLocked) }
+
+// PutLocked into user
+func (m *MapUser) PutLocked(locked time.Time) { m.put(authboss.AttributeLocked, locked) }
+
+// GetRecoverSelector from user
+func (m *MapUser) GetRecoverSelector() string {
+	return m.getString(authboss.AttributeRecoverSelector)
+}
+
+// PutRecoverSelector into user
+func (m *MapUser) PutRecoverSelector(selector string) {
+	m.put(authboss.AttributeRecoverSelector, selector)
+}
+
+// GetRecoverVerifier from user
+func (m *MapUser) GetRecoverVerifier() string {
+	return m.getString(authboss.AttributeRecoverVerifier)
+}
+
+// PutRecoverVerifier into user
+func (m *MapUser) PutRecoverVerifier(verifier string) {
+	m.put(authboss.AttributeRecoverVerifier, verifier)
+}
+
+// GetRecoverExpiry from user
+func (m *MapUser) GetRecoverExpiry() time.Time { return m.getTime(authboss.AttributeRecoverExpiry) }
+
+// PutRecoverExpiry into user
+func (m *MapUser) PutRecoverExpiry(expiry time.Time) {
+	m.put(authboss.AttributeRecoverExpiry, expiry)
+}
+
+// IsOAuth2User returns true if the user was created with oauth2
+func (m *MapUser) IsOAuth2User() bool { return len(m.GetOAuth2UID()) != 0 }
+
+// GetOAuth2UID from user
+func (m *MapUser) GetOAuth2UID() string { return m.getString(authboss.AttributeOAuth2UID) }
+
+// PutOAuth2UID into user
+func (m *MapUser) PutOAuth2UID(uid string) { m.put(authboss.AttributeOAuth2UID, uid) }
+
+// GetOAuth2Provider from user
+func (m *MapUser) GetOAuth2Provider() string {
+	return m.getString(authboss.AttributeOAuth2Provider)
+}
+
+// PutOAuth2Provider into user
+func (m *MapUser) PutOAuth2Provider(provider string) {
+	m.put(authboss.AttributeOAuth2Provider, provider)
+}
+
+// GetOAuth2AccessToken from user
+func (m *MapUser) GetOAuth2AccessToken() string {
+	return m.getString(authboss.AttributeOAuth2AccessToken)
+}
+
+// PutOAuth2AccessToken into user
+func (m *MapUser) PutOAuth2AccessToken(token string) {
+	m.put(authboss.AttributeOAuth2AccessToken, token)
+}
+
+// GetOAuth2RefreshToken from user
+func (m *MapUser) GetOAuth2RefreshToken() string {
+	return m.getString(authboss.AttributeOAuth2RefreshToken)
+}
+
+// PutOAuth2RefreshToken into user
+func (m *MapUser) PutOAuth2RefreshToken(refreshToken string) {
+	m.put(authboss.AttributeOAuth2RefreshToken, refreshToken)
+}
+
+// GetOAuth2Expiry from user
+func (m *MapUser) GetOAuth2Expiry() time.Time { return m.getTime(authboss.AttributeOAuth2Expiry) }
+
+// PutOAuth2Expiry into user
+func (m *MapUser) PutOAuth2Expiry(expiry time.Time) {
+	m.put(authboss.AttributeOAuth2Expiry, expiry)
+}
+
+// GetTOTPSecretKey from user
+func (m *MapUser) GetTOTPSecretKey() string { return m.getString(authboss.AttributeTOTPSecretKey) }
+
+// PutTOTPSecretKey into user
+func (m *MapUser) PutTOTPSecretKey(key string) { m.put(authboss.AttributeTOTPSecretKey, key) }
+
+// GetSMSPhoneNumber from user
+func (m *MapUser) GetSMSPhoneNumber() string {
+	return m.getString(authboss.AttributeSMSPhoneNumber)
+}
+
+// PutSMSPhoneNumber into user
+func (m *MapUser) PutSMSPhoneNumber(number string) {
+	m.put(authboss.AttributeSMSPhoneNumber, number)
+}
+
+// GetRecoveryCodes from user
+func (m *MapUser) GetRecoveryCodes() string { return m.getString(authboss.AttributeRecoveryCodes) }
+
+// PutRecoveryCodes into user
+func (m *MapUser) PutRecoveryCodes(codes string) { m.put(authboss.AttributeRecoveryCodes, codes) }
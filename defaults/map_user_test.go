@@ -0,0 +1,53 @@
+package defaults
+
+import (
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+var (
+	_ authboss.AuthableUser            = &MapUser{}
+	_ authboss.ExpiringConfirmableUser = &MapUser{}
+	_ authboss.UnlockableUser          = &MapUser{}
+	_ authboss.RecoverableUser         = &MapUser{}
+	_ authboss.OAuth2User              = &MapUser{}
+)
+
+func TestMapUser(t *testing.T) {
+	t.Parallel()
+
+	attributes := authboss.AttributeMap{
+		authboss.AttributePID:      "userEmail",
+		authboss.AttributePassword: "passwordHash",
+	}
+
+	values := map[string]interface{}{
+		"userEmail":     "test@test.com",
+		"attempt_count": int64(2),
+	}
+	user := NewMapUser(attributes, values)
+
+	if pid := user.GetPID(); pid != "test@test.com" {
+		t.Error("pid was wrong:", pid)
+	}
+	if attempts := user.GetAttemptCount(); attempts != 2 {
+		t.Error("attempt count was wrong:", attempts)
+	}
+
+	now := time.Now()
+	user.PutPassword("hash")
+	user.PutLocked(now)
+	user.PutConfirmed(true)
+
+	if values["passwordHash"] != "hash" {
+		t.Error("password should be stored under the mapped name:", values)
+	}
+	if !user.GetLocked().Equal(now) || !user.GetConfirmed() {
+		t.Error("values were not read back")
+	}
+	if user.IsOAuth2User() || !user.GetRecoverExpiry().IsZero() {
+		t.Error("missing values should be zero")
+	}
+}
@@ -0,0 +1,348 @@
+package defaults
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// QueueingMailer defaults
+const (
+	DefaultMailQueueRetries      = 5
+	DefaultMailQueueBackoff      = 30 * time.Second
+	DefaultMailQueueMaxBackoff   = time.Hour
+	DefaultMailQueuePollInterval = time.Second
+)
+
+// QueuedEmail is an e-mail waiting to be sent in a MailQueue
+type QueuedEmail struct {
+	ID    string
+	Email authboss.Email
+
+	// Attempts is how many times sending it has failed
+	Attempts int
+	// NextAttempt is when it should be sent
+	NextAttempt time.Time
+	// LastError is the error from the last attempt
+	LastError string
+}
+
+// MailQueue keeps the e-mails a QueueingMailer hasn't sent yet.
+// MemoryMailQueue loses them when the app stops, implement it with a
+// database table or a message broker to keep them.
+type MailQueue interface {
+	// Push adds an e-mail to the queue, or puts it back with its Attempts
+	// and NextAttempt updated after a failed attempt.
+	Push(ctx context.Context, mail QueuedEmail) error
+	// Pop removes and returns the e-mail that's been due the longest, ok
+	// is false when there are none due at now.
+	Pop(ctx context.Context, now time.Time) (mail QueuedEmail, ok bool, err error)
+	// Len is the number of e-mails in the queue
+	Len(ctx context.Context) (int, error)
+}
+
+// MailFailure is put in the context as CTXKeyValues for
+// authboss.EventMailFailed
+type MailFailure struct {
+	Mail QueuedEmail
+	Err  error
+}
+
+// QueueingMailer sends e-mails with another Mailer in the background so
+// that a slow mail server doesn't hold up requests. Send only queues the
+// e-mail, workers send it and retry transient failures with exponential
+// backoff. E-mails that fail permanently (see Permanent) or run out of
+// retries fire authboss.EventMailFailed.
+//
+// The workers start with the first e-mail or with Start, and stop when
+// authboss shuts down (see Authboss.Shutdown).
+type QueueingMailer struct {
+	Mailer authboss.Mailer
+	Queue  MailQueue
+
+	// Workers is how many e-mails are sent at once, 1 if it's 0
+	Workers int
+	// Retries is how many times an e-mail is retried after a transient
+	// failure
+	Retries int
+	// Backoff is how long to wait before the first retry, it's doubled
+	// for every retry after that up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often the queue is checked for e-mails that are
+	// due to be retried
+	PollInterval time.Duration
+	// Permanent decides whether an error from Mailer is permanent, so the
+	// e-mail isn't retried. IsPermanentMailError if it's nil.
+	Permanent func(error) bool
+
+	ab *authboss.Authboss
+
+	start    sync.Once
+	stop     chan struct{}
+	wake     chan struct{}
+	workers  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewQueueingMailer creates a QueueingMailer that sends with mailer, the
+// e-mails are queued in a MemoryMailQueue if queue is nil.
+func NewQueueingMailer(ab *authboss.Authboss, mailer authboss.Mailer, queue MailQueue) *QueueingMailer {
+	if queue == nil {
+		queue = NewMemoryMailQueue()
+	}
+
+	return &QueueingMailer{
+		Mailer:       mailer,
+		Queue:        queue,
+		Workers:      1,
+		Retries:      DefaultMailQueueRetries,
+		Backoff:      DefaultMailQueueBackoff,
+		MaxBackoff:   DefaultMailQueueMaxBackoff,
+		PollInterval: DefaultMailQueuePollInterval,
+		ab:           ab,
+		stop:         make(chan struct{}),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Send queues the e-mail
+func (q *QueueingMailer) Send(ctx context.Context, mail authboss.Email) error {
+	q.Start()
+
+	id, err := mailID()
+	if err != nil {
+		return err
+	}
+
+	queued := QueuedEmail{ID: id, Email: mail, NextAttempt: time.Now().UTC()}
+	if err := q.Queue.Push(ctx, queued); err != nil {
+		return errors.Wrap(err, "failed to queue e-mail")
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// QueueLen is the number of e-mails waiting to be sent, see
+// authboss.QueueingMailer
+func (q *QueueingMailer) QueueLen() int {
+	n, err := q.Queue.Len(context.Background())
+	if err != nil {
+		q.ab.Logger(context.Background()).Errorf("failed to get mail queue length: %+v", err)
+		return 0
+	}
+	return n
+}
+
+// Start the workers, e-mails left in a persistent queue are sent without
+// waiting for a new one
+func (q *QueueingMailer) Start() {
+	q.start.Do(func() {
+		workers := q.Workers
+		if workers < 1 {
+			workers = 1
+		}
+
+		q.workers.Add(workers)
+		for i := 0; i < workers; i++ {
+			go q.work()
+		}
+	})
+}
+
+// Shutdown stops the workers once they've finished the e-mails they're
+// sending, the rest stay in the queue
+func (q *QueueingMailer) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to wait for mail queue workers")
+	}
+}
+
+func (q *QueueingMailer) work() {
+	defer q.workers.Done()
+
+	ctx := context.Background()
+	logger := q.ab.Logger(ctx)
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		mail, ok, err := q.Queue.Pop(ctx, time.Now().UTC())
+		if err != nil {
+			logger.Errorf("failed to take e-mail from the queue: %+v", err)
+		} else if ok {
+			q.send(ctx, mail)
+			continue
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// send the e-mail, putting it back in the queue if it failed and can
+// be retried
+func (q *QueueingMailer) send(ctx context.Context, mail QueuedEmail) {
+	logger := q.ab.Logger(ctx)
+
+	err := q.Mailer.Send(ctx, mail.Email)
+	if err == nil {
+		return
+	}
+
+	mail.Attempts++
+	mail.LastError = err.Error()
+
+	permanent := q.Permanent
+	if permanent == nil {
+		permanent = IsPermanentMailError
+	}
+	if permanent(err) || mail.Attempts > q.Retries {
+		logger.Errorf("failed to send e-mail %s to %v after %d attempts: %+v", mail.ID, mail.Email.To, mail.Attempts, err)
+		q.failed(ctx, mail, err)
+		return
+	}
+
+	mail.NextAttempt = time.Now().UTC().Add(q.backoff(mail.Attempts))
+	logger.Infof("failed to send e-mail %s, retrying at %s: %v", mail.ID, mail.NextAttempt.Format(time.RFC3339), err)
+	if err := q.Queue.Push(ctx, mail); err != nil {
+		logger.Errorf("failed to put e-mail %s back in the queue: %+v", mail.ID, err)
+		q.failed(ctx, mail, err)
+	}
+}
+
+// backoff before the retry after the attempt
+func (q *QueueingMailer) backoff(attempt int) time.Duration {
+	backoff := q.Backoff
+	for i := 1; i < attempt && (q.MaxBackoff == 0 || backoff < q.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if q.MaxBackoff != 0 && backoff > q.MaxBackoff {
+		backoff = q.MaxBackoff
+	}
+	return backoff
+}
+
+// failed fires EventMailFailed, there's no request so the handlers get a
+// placeholder one
+func (q *QueueingMailer) failed(ctx context.Context, mail QueuedEmail, err error) {
+	ctx = context.WithValue(ctx, authboss.CTXKeyValues, MailFailure{Mail: mail, Err: err})
+	r, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if reqErr != nil {
+		q.ab.Logger(ctx).Errorf("failed to create request for EventMailFailed: %+v", reqErr)
+		return
+	}
+
+	if _, err := q.ab.Events.FireAfter(authboss.EventMailFailed, discardResponseWriter{}, r); err != nil {
+		q.ab.Logger(ctx).Errorf("EventMailFailed handler failed: %+v", err)
+	}
+}
+
+// IsPermanentMailError is true for SMTP errors that won't go away by
+// retrying (5xx replies) and errors with a Permanent method that returns
+// true (like mailers.APIError), everything else is assumed to be
+// transient.
+func IsPermanentMailError(err error) bool {
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 500
+	}
+
+	var permanent interface{ Permanent() bool }
+	return errors.As(err, &permanent) && permanent.Permanent()
+}
+
+func mailID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to create e-mail id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// discardResponseWriter is the response writer for events fired outside
+// of a request
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
+
+// MemoryMailQueue is a MailQueue in memory, e-mails that haven't been sent
+// are lost when the app stops.
+type MemoryMailQueue struct {
+	mut  sync.Mutex
+	mail []QueuedEmail
+}
+
+// NewMemoryMailQueue creates an empty queue
+func NewMemoryMailQueue() *MemoryMailQueue {
+	return &MemoryMailQueue{}
+}
+
+// Push an e-mail
+func (m *MemoryMailQueue) Push(ctx context.Context, mail QueuedEmail) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.mail = append(m.mail, mail)
+	sort.SliceStable(m.mail, func(i, j int) bool {
+		return m.mail[i].NextAttempt.Before(m.mail[j].NextAttempt)
+	})
+	return nil
+}
+
+// Pop the e-mail that's been due the longest
+func (m *MemoryMailQueue) Pop(ctx context.Context, now time.Time) (QueuedEmail, bool, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if len(m.mail) == 0 || m.mail[0].NextAttempt.After(now) {
+		return QueuedEmail{}, false, nil
+	}
+
+	mail := m.mail[0]
+	m.mail = m.mail[1:]
+	return mail, true, nil
+}
+
+// Len is the number of e-mails in the queue
+func (m *MemoryMailQueue) Len(ctx context.Context) (int, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	return len(m.mail), nil
+}
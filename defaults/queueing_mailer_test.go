@@ -0,0 +1,243 @@
+package defaults
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+type flakyMailer struct {
+	mut      sync.Mutex
+	failures int
+	err      error
+	attempts int
+	sent     []authboss.Email
+}
+
+func (f *flakyMailer) Send(ctx context.Context, mail authboss.Email) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.attempts++
+	if f.attempts <= f.failures {
+		return f.err
+	}
+	f.sent = append(f.sent, mail)
+	return nil
+}
+
+func (f *flakyMailer) counts() (int, int) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.attempts, len(f.sent)
+}
+
+func testQueueingMailer(ab *authboss.Authboss, mailer authboss.Mailer) *QueueingMailer {
+	q := NewQueueingMailer(ab, mailer, nil)
+	q.Backoff = time.Millisecond
+	q.MaxBackoff = 4 * time.Millisecond
+	q.PollInterval = time.Millisecond
+	return q
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueingMailerRetries(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.Logger = NewLogger(ioutil.Discard)
+	mailer := &flakyMailer{failures: 2, err: errors.New("connection refused")}
+	q := testQueueingMailer(ab, mailer)
+
+	if err := q.Send(context.Background(), authboss.Email{To: []string{"a@a.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		_, sent := mailer.counts()
+		return sent == 1
+	})
+
+	if attempts, _ := mailer.counts(); attempts != 3 {
+		t.Error("it should have taken 3 attempts:", attempts)
+	}
+	if n := q.QueueLen(); n != 0 {
+		t.Error("queue should be empty:", n)
+	}
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueingMailerFailed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Err      error
+		Attempts int
+	}{
+		{"Permanent", &textproto.Error{Code: 550, Msg: "no such user"}, 1},
+		{"OutOfRetries", errors.New("connection refused"), 3},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ab := authboss.New()
+			ab.Config.Core.Logger = NewLogger(ioutil.Discard)
+
+			failures := make(chan MailFailure, 1)
+			ab.Events.After(authboss.EventMailFailed, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+				failures <- r.Context().Value(authboss.CTXKeyValues).(MailFailure)
+				return false, nil
+			})
+
+			mailer := &flakyMailer{failures: 10, err: test.Err}
+			q := testQueueingMailer(ab, mailer)
+			q.Retries = 2
+
+			if err := q.Send(context.Background(), authboss.Email{To: []string{"a@a.com"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			var failure MailFailure
+			select {
+			case failure = <-failures:
+			case <-time.After(5 * time.Second):
+				t.Fatal("EventMailFailed was not fired")
+			}
+
+			if failure.Mail.Attempts != test.Attempts {
+				t.Error("attempts was wrong:", failure.Mail.Attempts)
+			}
+			if failure.Mail.Email.To[0] != "a@a.com" {
+				t.Error("e-mail was wrong:", failure.Mail.Email)
+			}
+			if failure.Err != test.Err {
+				t.Error("error was wrong:", failure.Err)
+			}
+
+			if err := q.Shutdown(context.Background()); err != nil {
+				t.Error(err)
+			}
+			if attempts, _ := mailer.counts(); attempts != test.Attempts {
+				t.Error("it should not have been retried after failing:", attempts)
+			}
+		})
+	}
+}
+
+func TestQueueingMailerShutdown(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	mailer := &flakyMailer{}
+	q := testQueueingMailer(ab, mailer)
+	q.Start()
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The workers are gone so it stays queued
+	if err := q.Send(context.Background(), authboss.Email{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.QueueLen(); n != 1 {
+		t.Error("it should still be queued:", n)
+	}
+}
+
+func TestQueueingMailerBackoff(t *testing.T) {
+	t.Parallel()
+
+	q := &QueueingMailer{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := q.backoff(i + 1); got != w {
+			t.Errorf("attempt %d: backoff was %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestMemoryMailQueue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	m := NewMemoryMailQueue()
+
+	_ = m.Push(ctx, QueuedEmail{ID: "later", NextAttempt: now.Add(time.Hour)})
+	_ = m.Push(ctx, QueuedEmail{ID: "due", NextAttempt: now.Add(-time.Minute)})
+	_ = m.Push(ctx, QueuedEmail{ID: "overdue", NextAttempt: now.Add(-time.Hour)})
+
+	if n, _ := m.Len(ctx); n != 3 {
+		t.Error("length was wrong:", n)
+	}
+
+	for _, id := range []string{"overdue", "due"} {
+		mail, ok, err := m.Pop(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || mail.ID != id {
+			t.Errorf("want %s, got %s (%t)", id, mail.ID, ok)
+		}
+	}
+
+	if mail, ok, _ := m.Pop(ctx, now); ok {
+		t.Error("nothing else should be due:", mail.ID)
+	}
+	if n, _ := m.Len(ctx); n != 1 {
+		t.Error("length was wrong:", n)
+	}
+}
+
+func TestIsPermanentMailError(t *testing.T) {
+	t.Parallel()
+
+	if !IsPermanentMailError(errors.Wrap(&textproto.Error{Code: 550}, "send")) {
+		t.Error("5xx should be permanent")
+	}
+	if IsPermanentMailError(&textproto.Error{Code: 421}) {
+		t.Error("4xx should be transient")
+	}
+	if IsPermanentMailError(errors.New("connection reset")) {
+		t.Error("other errors should be transient")
+	}
+	if !IsPermanentMailError(errors.Wrap(permanentError(true), "send")) {
+		t.Error("errors that say they're permanent should be")
+	}
+	if IsPermanentMailError(permanentError(false)) {
+		t.Error("errors that say they're transient should be")
+	}
+}
+
+type permanentError bool
+
+func (p permanentError) Error() string   { return "api error" }
+func (p permanentError) Permanent() bool { return bool(p) }
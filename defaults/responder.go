@@ -1,17 +1,87 @@
 package defaults
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
 )
 
+// ErrorEnvelope makes the body of an API response that failed from its
+// APIError, data is what would have been rendered (for redirects it has
+// the "location"). It's marshaled to JSON.
+type ErrorEnvelope func(req *http.Request, apiErr authboss.APIError, data authboss.HTMLData) interface{}
+
+// DefaultErrorEnvelope wraps the error in an object with a failure status:
+//
+//	{"status": "failure", "error": {"code": "validation_failed",
+//	  "message": "...", "fields": {"email": ["Cannot be blank"]}},
+//	  "location": "/login"}
+//
+// The location is only there for redirects.
+func DefaultErrorEnvelope(req *http.Request, apiErr authboss.APIError, data authboss.HTMLData) interface{} {
+	envelope := map[string]interface{}{
+		"status": "failure",
+		"error":  apiErr,
+	}
+	if location, ok := data["location"]; ok {
+		envelope["location"] = location
+	}
+	return envelope
+}
+
+// respondEnvelope writes the failure with the envelope, the status is the
+// error code's unless a failure status was given
+func respondEnvelope(w http.ResponseWriter, req *http.Request, envelope ErrorEnvelope, code int, apiErr authboss.APIError, data authboss.HTMLData) error {
+	if envelope == nil {
+		envelope = DefaultErrorEnvelope
+	}
+	if code < http.StatusBadRequest {
+		code = apiErr.Code.Status()
+	}
+
+	b, err := json.Marshal(envelope(req, apiErr, data))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(b)
+	return err
+}
+
 // Responder helps respond to http requests
 type Responder struct {
 	Renderer authboss.Renderer
+
+	// Envelope makes the body of failures (see authboss.APIErrorFromData)
+	// for API requests and when the Renderer is a JSONRenderer.
+	// DefaultErrorEnvelope is used if it's nil.
+	Envelope ErrorEnvelope
+
+	// Partials renders partial templates for HTMX and Turbo Frame
+	// requests: the page's name followed by PartialSuffix, or the page
+	// itself if the Renderer returns authboss.ErrTemplateNotFound for it.
+	// authboss.DataPartial is set either way. Failures for Turbo requests
+	// are sent with 422 Unprocessable Entity since Turbo only renders
+	// failed form submissions that have an error status.
+	Partials bool
+	// PartialSuffix is DefaultPartialSuffix if it's empty
+	PartialSuffix string
+
+	// Negotiate responds to requests that want JSON (see WantsJSON) with
+	// a JSONRenderer instead of the Renderer, so one deployment can serve
+	// pages and a JSON front-end from the same routes.
+	Negotiate bool
 }
 
+// DefaultPartialSuffix is added to a page's name for its partial template
+const DefaultPartialSuffix = "_partial"
+
 // NewResponder constructor
 func NewResponder(renderer authboss.Renderer) *Responder {
 	return &Responder{Renderer: renderer}
@@ -29,11 +99,39 @@ func (r *Responder) Respond(w http.ResponseWriter, req *http.Request, code int,
 		data.Merge(ctxData.(authboss.HTMLData))
 	}
 
-	rendered, mime, err := r.Renderer.Render(req.Context(), page, data)
+	renderer := r.Renderer
+	negotiatedJSON := r.Negotiate && WantsJSON(req)
+	if negotiatedJSON {
+		renderer = JSONRenderer{}
+	}
+
+	if negotiatedJSON || isAPIRequest(req) || isJSONRenderer(renderer) {
+		if apiErr, ok := authboss.APIErrorFromData(data); ok {
+			if r.Negotiate {
+				w.Header().Add("Vary", varyNegotiate)
+			}
+			return respondEnvelope(w, req, r.Envelope, code, apiErr, data)
+		}
+	}
+
+	var rendered []byte
+	var mime string
+	var err error
+	if r.Partials && !negotiatedJSON && isPartialRequest(req) {
+		code, rendered, mime, err = r.renderPartial(req, code, page, data)
+	} else {
+		rendered, mime, err = renderer.Render(req.Context(), page, data)
+	}
 	if err != nil {
 		return err
 	}
 
+	if r.Partials {
+		w.Header().Add("Vary", "HX-Request, Turbo-Frame")
+	}
+	if r.Negotiate {
+		w.Header().Add("Vary", varyNegotiate)
+	}
 	w.Header().Set("Content-Type", mime)
 	w.WriteHeader(code)
 
@@ -41,10 +139,124 @@ func (r *Responder) Respond(w http.ResponseWriter, req *http.Request, code int,
 	return err
 }
 
+// renderPartial renders the page's partial template, or the page if it
+// has none
+func (r *Responder) renderPartial(req *http.Request, code int, page string, data authboss.HTMLData) (int, []byte, string, error) {
+	if data == nil {
+		data = authboss.HTMLData{}
+	}
+	data[authboss.DataPartial] = true
+
+	if isTurboRequest(req) && code < http.StatusBadRequest {
+		if _, failed := authboss.APIErrorFromData(data); failed {
+			code = http.StatusUnprocessableEntity
+		}
+	}
+
+	suffix := r.PartialSuffix
+	if len(suffix) == 0 {
+		suffix = DefaultPartialSuffix
+	}
+
+	rendered, mime, err := r.Renderer.Render(req.Context(), page+suffix, data)
+	if errors.Is(err, authboss.ErrTemplateNotFound) {
+		rendered, mime, err = r.Renderer.Render(req.Context(), page, data)
+	}
+	return code, rendered, mime, err
+}
+
+// isPartialRequest is true for requests from HTMX and Turbo that swap in
+// part of the page
+func isPartialRequest(r *http.Request) bool {
+	return isHTMXRequest(r) || len(r.Header.Get("Turbo-Frame")) != 0
+}
+
+func isHTMXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+func isTurboRequest(r *http.Request) bool {
+	return len(r.Header.Get("Turbo-Frame")) != 0 ||
+		strings.Contains(r.Header.Get("Accept"), "text/vnd.turbo-stream.html")
+}
+
 func isAPIRequest(r *http.Request) bool {
 	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
 }
 
+// varyNegotiate are the headers responses depend on when they're
+// negotiated
+const varyNegotiate = "Accept, Content-Type, X-Requested-With"
+
+// WantsJSON reports whether the request should be responded to with JSON:
+// it has a JSON body, it's an XMLHttpRequest (X-Requested-With) or its
+// Accept header prefers application/json to text/html, or names it when
+// they're as good (axios' application/json, text/plain, */*). Requests that
+// accept anything are answered with html.
+func WantsJSON(r *http.Request) bool {
+	if isAPIRequest(r) || r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+
+	accept := r.Header.Get("Accept")
+	if len(accept) == 0 {
+		return false
+	}
+	jsonQuality, jsonSpecificity := acceptQuality(accept, "application", "json")
+	htmlQuality, htmlSpecificity := acceptQuality(accept, "text", "html")
+	return jsonQuality > htmlQuality || jsonQuality > 0 && jsonQuality == htmlQuality && jsonSpecificity > htmlSpecificity
+}
+
+// acceptQuality is the quality the Accept header gives the media type, from
+// its most specific media range that matches it, and how specific the
+// range is (2 for type/subtype, 1 for type/*, 0 for */* and -1 when none
+// match)
+func acceptQuality(accept, typ, subtype string) (float64, int) {
+	quality, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		rangeType, rangeSubtype := strings.TrimSpace(params[0]), ""
+		if i := strings.IndexByte(rangeType, '/'); i >= 0 {
+			rangeType, rangeSubtype = rangeType[:i], rangeType[i+1:]
+		}
+
+		var s int
+		switch {
+		case strings.EqualFold(rangeType, typ) && strings.EqualFold(rangeSubtype, subtype):
+			s = 2
+		case strings.EqualFold(rangeType, typ) && rangeSubtype == "*":
+			s = 1
+		case rangeType == "*" && rangeSubtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality, specificity = q, s
+	}
+	return quality, specificity
+}
+
+func isJSONRenderer(renderer authboss.Renderer) bool {
+	switch renderer.(type) {
+	case JSONRenderer, *JSONRenderer:
+		return true
+	}
+	return false
+}
+
 // Redirector for http requests
 type Redirector struct {
 	Renderer authboss.Renderer
@@ -55,6 +267,21 @@ type Redirector struct {
 	// CoerceRedirectTo200 forces http.StatusTemporaryRedirect and
 	// and http.StatusPermanentRedirect to http.StatusOK
 	CorceRedirectTo200 bool
+
+	// Envelope makes the body of failed API redirects, like
+	// Responder.Envelope. DefaultErrorEnvelope is used if it's nil.
+	Envelope ErrorEnvelope
+
+	// Partials redirects HTMX requests with the HX-Redirect header
+	// instead of a 302 that HTMX would follow and swap into the page, and
+	// Turbo requests with 303 See Other like Turbo expects after a form
+	// submission.
+	Partials bool
+
+	// Negotiate redirects requests that want JSON (see WantsJSON) like API
+	// requests, with a JSONRenderer instead of the Renderer, like
+	// Responder.Negotiate.
+	Negotiate bool
 }
 
 // NewRedirector constructor
@@ -67,7 +294,12 @@ func NewRedirector(renderer authboss.Renderer, formValueName string) *Redirector
 // to do.
 func (r *Redirector) Redirect(w http.ResponseWriter, req *http.Request, ro authboss.RedirectOptions) error {
 	var redirectFunction = r.redirectNonAPI
-	if isAPIRequest(req) {
+	if r.Negotiate {
+		w.Header().Add("Vary", varyNegotiate)
+		if WantsJSON(req) {
+			redirectFunction = r.redirectAPI
+		}
+	} else if isAPIRequest(req) {
 		redirectFunction = r.redirectAPI
 	}
 
@@ -75,9 +307,9 @@ func (r *Redirector) Redirect(w http.ResponseWriter, req *http.Request, ro authb
 }
 
 func (r Redirector) redirectAPI(w http.ResponseWriter, req *http.Request, ro authboss.RedirectOptions) error {
-	path := ro.RedirectPath
+	path := authboss.WithPathPrefix(req, ro.RedirectPath)
 	redir := req.FormValue(r.FormValueName)
-	if strings.Contains(redir, "://") {
+	if len(redir) != 0 && !authboss.IsLocalRedirect(redir) {
 		// Guard against Open Redirect: https://cwe.mitre.org/data/definitions/601.html
 		redir = ""
 	}
@@ -85,26 +317,28 @@ func (r Redirector) redirectAPI(w http.ResponseWriter, req *http.Request, ro aut
 		path = redir
 	}
 
-	var status = "success"
-	var message string
-	if len(ro.Success) != 0 {
-		message = ro.Success
+	data := authboss.HTMLData{
+		"location": path,
 	}
+
 	if len(ro.Failure) != 0 {
-		status = "failure"
-		message = ro.Failure
+		apiErr := authboss.APIError{Code: ro.FailureCode, Message: ro.Failure}
+		if len(apiErr.Code) == 0 {
+			apiErr.Code = authboss.ErrorCodeFailed
+		}
+		return respondEnvelope(w, req, r.Envelope, ro.Code, apiErr, data)
 	}
 
-	data := authboss.HTMLData{
-		"location": path,
+	data["status"] = "success"
+	if len(ro.Success) != 0 {
+		data["message"] = ro.Success
 	}
 
-	data["status"] = status
-	if len(message) != 0 {
-		data["message"] = message
+	renderer := r.Renderer
+	if r.Negotiate {
+		renderer = JSONRenderer{}
 	}
-
-	body, mime, err := r.Renderer.Render(req.Context(), "redirect", data)
+	body, mime, err := renderer.Render(req.Context(), "redirect", data)
 	if err != nil {
 		return err
 	}
@@ -125,9 +359,9 @@ func (r Redirector) redirectAPI(w http.ResponseWriter, req *http.Request, ro aut
 }
 
 func (r Redirector) redirectNonAPI(w http.ResponseWriter, req *http.Request, ro authboss.RedirectOptions) error {
-	path := ro.RedirectPath
+	path := authboss.WithPathPrefix(req, ro.RedirectPath)
 	redir := req.FormValue(r.FormValueName)
-	if strings.Contains(redir, "://") {
+	if len(redir) != 0 && !authboss.IsLocalRedirect(redir) {
 		// Guard against Open Redirect: https://cwe.mitre.org/data/definitions/601.html
 		redir = ""
 	}
@@ -142,6 +376,14 @@ func (r Redirector) redirectNonAPI(w http.ResponseWriter, req *http.Request, ro
 		authboss.PutSession(w, authboss.FlashErrorKey, ro.Failure)
 	}
 
-	http.Redirect(w, req, path, http.StatusFound)
+	switch {
+	case r.Partials && isHTMXRequest(req):
+		w.Header().Set("HX-Redirect", path)
+		w.WriteHeader(http.StatusOK)
+	case r.Partials && isTurboRequest(req):
+		http.Redirect(w, req, path, http.StatusSeeOther)
+	default:
+		http.Redirect(w, req, path, http.StatusFound)
+	}
 	return nil
 }
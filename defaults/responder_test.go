@@ -159,22 +159,99 @@ func TestResponseRedirectAPIFollowRedir(t *testing.T) {
 		t.Error("code is wrong:", w.Code)
 	}
 
-	var gotData map[string]string
+	var gotData struct {
+		Status   string
+		Error    authboss.APIError
+		Location string
+	}
 	if err := json.Unmarshal(w.Body.Bytes(), &gotData); err != nil {
 		t.Fatal(err)
 	}
 
-	if got := gotData["status"]; got != "failure" {
+	if got := gotData.Status; got != "failure" {
 		t.Error("status was wrong:", got)
 	}
-	if got := gotData["message"]; got != ":(" {
+	if got := gotData.Error.Message; got != ":(" {
 		t.Error("message was wrong:", got)
 	}
-	if got := gotData["location"]; got != "/pow" {
+	if got := gotData.Error.Code; got != authboss.ErrorCodeFailed {
+		t.Error("code was wrong:", got)
+	}
+	if got := gotData.Location; got != "/pow" {
 		t.Error("location was wrong:", got)
 	}
 }
 
+func TestResponderErrorEnvelope(t *testing.T) {
+	t.Parallel()
+
+	responder := NewResponder(JSONRenderer{})
+
+	r := httptest.NewRequest("POST", "/", nil)
+	w := httptest.NewRecorder()
+
+	err := responder.Respond(w, r, http.StatusOK, "register", authboss.HTMLData{
+		authboss.DataValidation: map[string][]string{"email": {"Cannot be blank"}},
+		authboss.DataPreserve:   map[string]string{"name": "Jake"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Error("code was wrong:", w.Code)
+	}
+	want := `{"error":{"code":"validation_failed","fields":{"email":["Cannot be blank"]}},"status":"failure"}`
+	if got := w.Body.String(); got != want {
+		t.Errorf("body was wrong:\nwant: %s\ngot:  %s", want, got)
+	}
+
+	w = httptest.NewRecorder()
+	err = responder.Respond(w, r, http.StatusOK, "login", authboss.HTMLData{
+		authboss.DataErr:     "Invalid Credentials",
+		authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Error("code was wrong:", w.Code)
+	}
+	want = `{"error":{"code":"invalid_credentials","message":"Invalid Credentials"},"status":"failure"}`
+	if got := w.Body.String(); got != want {
+		t.Errorf("body was wrong:\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestRedirectorErrorEnvelope(t *testing.T) {
+	t.Parallel()
+
+	redir := NewRedirector(JSONRenderer{}, "redir")
+	redir.Envelope = func(req *http.Request, apiErr authboss.APIError, data authboss.HTMLData) interface{} {
+		return map[string]interface{}{"ok": false, "code": apiErr.Code}
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	ro := authboss.RedirectOptions{
+		Failure:      "Your account has been locked",
+		FailureCode:  authboss.ErrorCodeLocked,
+		RedirectPath: "/",
+	}
+	if err := redir.Redirect(w, r, ro); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != http.StatusForbidden {
+		t.Error("code was wrong:", w.Code)
+	}
+	if got := w.Body.String(); got != `{"code":"account_locked","ok":false}` {
+		t.Error("body was wrong:", got)
+	}
+}
+
 func TestResponseRedirectNonAPI(t *testing.T) {
 	t.Parallel()
 
@@ -214,6 +291,27 @@ func TestResponseRedirectNonAPI(t *testing.T) {
 	}
 }
 
+func TestResponseRedirectNonAPIPathPrefix(t *testing.T) {
+	t.Parallel()
+
+	redir := NewRedirector(testRenderer{}, "redir")
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPathPrefix, "/app"))
+	w := httptest.NewRecorder()
+
+	ab := authboss.New()
+	ab.Config.Storage.SessionState = mocks.NewClientRW()
+	aw := ab.NewResponse(w)
+
+	if err := redir.Redirect(aw, r, authboss.RedirectOptions{RedirectPath: "/redirect"}); err != nil {
+		t.Error(err)
+	}
+	if got := w.Header().Get("Location"); got != "/app/redirect" {
+		t.Error("redirect location should have the prefix:", got)
+	}
+}
+
 func TestResponseRedirectNonAPIFollowRedir(t *testing.T) {
 	t.Parallel()
 
@@ -250,3 +348,205 @@ func TestResponseRedirectNonAPIFollowRedir(t *testing.T) {
 		t.Error("redirect location was wrong:", got)
 	}
 }
+
+func TestResponderPartials(t *testing.T) {
+	t.Parallel()
+
+	var rendered []string
+	renderer := testRenderer{
+		Callback: func(ctx context.Context, name string, data authboss.HTMLData) ([]byte, string, error) {
+			if name == "recover_start_partial" {
+				return nil, "", authboss.ErrTemplateNotFound
+			}
+			rendered = append(rendered, name)
+			if data[authboss.DataPartial] != true {
+				t.Error("partial should be set for", name)
+			}
+			return []byte(name), "text/html", nil
+		},
+	}
+
+	responder := NewResponder(renderer)
+	responder.Partials = true
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	if err := responder.Respond(w, r, http.StatusOK, "login", authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK {
+		t.Error("htmx failures should be 200 so they're swapped in:", w.Code)
+	}
+	if w.Header().Get("Vary") != "HX-Request, Turbo-Frame" {
+		t.Error("vary was wrong:", w.Header().Get("Vary"))
+	}
+
+	// No partial template so the page is rendered
+	r = httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Turbo-Frame", "recover")
+	w = httptest.NewRecorder()
+	data := authboss.HTMLData{authboss.DataValidation: map[string][]string{"email": {"Cannot be blank"}}}
+	if err := responder.Respond(w, r, http.StatusOK, "recover_start", data); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Error("turbo failures should be 422:", w.Code)
+	}
+
+	if want := []string{"login_partial", "recover_start"}; !reflect.DeepEqual(rendered, want) {
+		t.Errorf("rendered was wrong:\nwant: %v\ngot:  %v", want, rendered)
+	}
+}
+
+func TestRedirectorPartials(t *testing.T) {
+	t.Parallel()
+
+	redir := NewRedirector(nil, "redir")
+	redir.Partials = true
+
+	ab := authboss.New()
+	ab.Config.Storage.SessionState = mocks.NewClientRW()
+	ab.Config.Storage.CookieState = mocks.NewClientRW()
+	ro := authboss.RedirectOptions{Success: "Logged in", RedirectPath: "/dashboard"}
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	if err := redir.Redirect(ab.NewResponse(w), r, ro); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("HX-Redirect") != "/dashboard" {
+		t.Error("htmx should be redirected with HX-Redirect:", w.Code, w.Header())
+	}
+
+	r = httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("Accept", "text/vnd.turbo-stream.html, text/html, application/xhtml+xml")
+	w = httptest.NewRecorder()
+	if err := redir.Redirect(ab.NewResponse(w), r, ro); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/dashboard" {
+		t.Error("turbo should be redirected with 303:", w.Code, w.Header())
+	}
+
+	redir.Partials = false
+	r = httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("HX-Request", "true")
+	w = httptest.NewRecorder()
+	if err := redir.Redirect(ab.NewResponse(w), r, ro); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusFound {
+		t.Error("without partials it should be a normal redirect:", w.Code)
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Header string
+		Value  string
+		Want   bool
+	}{
+		{"", "", false},
+		{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"Accept", "*/*", false},
+		{"Accept", "application/json", true},
+		{"Accept", "application/json, text/plain, */*", true},
+		{"Accept", "text/html;q=0.5, application/json", true},
+		{"Accept", "application/*;q=0.9, text/html;q=0.8", true},
+		{"Accept", "application/json;q=0, */*", false},
+		{"X-Requested-With", "XMLHttpRequest", true},
+		{"Content-Type", "application/json; charset=utf-8", true},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(test.Header) != 0 {
+			r.Header.Set(test.Header, test.Value)
+		}
+		if got := WantsJSON(r); got != test.Want {
+			t.Errorf("%s: %q = %t", test.Header, test.Value, got)
+		}
+	}
+}
+
+func TestResponderNegotiate(t *testing.T) {
+	t.Parallel()
+
+	renderer := testRenderer{Callback: func(ctx context.Context, name string, data authboss.HTMLData) ([]byte, string, error) {
+		return []byte("<p>" + name + "</p>"), "text/html", nil
+	}}
+	responder := NewResponder(renderer)
+	responder.Negotiate = true
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	if err := responder.Respond(w, r, http.StatusOK, "login", authboss.HTMLData{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != "<p>login</p>" {
+		t.Error("pages should be rendered with the renderer:", w.Body.String())
+	}
+	if w.Header().Get("Vary") != varyNegotiate {
+		t.Error("the response should vary by the negotiated headers:", w.Header())
+	}
+
+	r = httptest.NewRequest("GET", "/login", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	if err := responder.Respond(w, r, http.StatusOK, "login", authboss.HTMLData{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Content-Type") != "application/json" || w.Body.String() != `{"a":"b","status":"success"}` {
+		t.Error("json should be rendered for requests that want it:", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials", authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials}
+	if err := responder.Respond(w, r, http.StatusOK, "login", data); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Error("failures should have the error envelope:", w.Code, w.Body.String())
+	}
+}
+
+func TestRedirectorNegotiate(t *testing.T) {
+	t.Parallel()
+
+	redir := NewRedirector(testRenderer{}, "redir")
+	redir.Negotiate = true
+
+	ab := authboss.New()
+	ab.Config.Storage.SessionState = mocks.NewClientRW()
+	ab.Config.Storage.CookieState = mocks.NewClientRW()
+	ro := authboss.RedirectOptions{Code: http.StatusTemporaryRedirect, Success: "Logged in", RedirectPath: "/dashboard"}
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("X-Requested-With", "XMLHttpRequest")
+	w := httptest.NewRecorder()
+	if err := redir.Redirect(ab.NewResponse(w), r, ro); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotData map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &gotData); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTemporaryRedirect || gotData["location"] != "/dashboard" {
+		t.Error("requests that want json should get the redirect as json:", w.Code, gotData)
+	}
+
+	r = httptest.NewRequest("POST", "/login", nil)
+	w = httptest.NewRecorder()
+	if err := redir.Redirect(ab.NewResponse(w), r, ro); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+		t.Error("other requests should be redirected:", w.Code, w.Header())
+	}
+}
@@ -11,6 +11,8 @@ type Router struct {
 	gets    *http.ServeMux
 	posts   *http.ServeMux
 	deletes *http.ServeMux
+
+	routes []string
 }
 
 // NewRouter creates a new router
@@ -32,16 +34,26 @@ func NewRouter() *Router {
 // Get method route
 func (r *Router) Get(path string, handler http.Handler) {
 	r.gets.Handle(path, handler)
+	r.routes = append(r.routes, "GET "+path)
 }
 
 // Post method route
 func (r *Router) Post(path string, handler http.Handler) {
 	r.posts.Handle(path, handler)
+	r.routes = append(r.routes, "POST "+path)
 }
 
 // Delete method route
 func (r *Router) Delete(path string, handler http.Handler) {
 	r.deletes.Handle(path, handler)
+	r.routes = append(r.routes, "DELETE "+path)
+}
+
+// Routes lists the routes that have been added as "METHOD /path"
+func (r *Router) Routes() []string {
+	routes := make([]string, len(r.routes))
+	copy(routes, r.routes)
+	return routes
 }
 
 // ServeHTTP for http.Handler
@@ -66,6 +66,10 @@ func TestRouter(t *testing.T) {
 	if del != wantDelete {
 		t.Error("want:", wantDelete, "got:", del)
 	}
+
+	if routes := strings.Join(r.Routes(), ","); routes != "GET /test,POST /test,DELETE /test" {
+		t.Error("routes were wrong:", routes)
+	}
 }
 
 func TestRouterBadMethod(t *testing.T) {
@@ -1,7 +1,6 @@
 package defaults
 
 import (
-	"fmt"
 	"regexp"
 	"unicode"
 
@@ -12,7 +11,8 @@ import (
 var blankRegex = regexp.MustCompile(`^\s*$`)
 
 // Rules defines a ruleset by which a string can be validated.
-// The errors it produces are english only, with some basic pluralization.
+// The errors it produces are authboss.LocalizedErrors, english unless
+// they're localized with Authboss.LocalizeErrors.
 type Rules struct {
 	// FieldName is the name of the field this is intended to validate.
 	FieldName string
@@ -27,6 +27,10 @@ type Rules struct {
 	MinNumeric           int
 	MinSymbols           int
 	AllowWhitespace      bool
+
+	// Validators are the names of validators added with RegisterValidator
+	// that the field is checked with as well.
+	Validators []string
 }
 
 // Errors returns an array of errors for each validation error that
@@ -36,7 +40,7 @@ func (r Rules) Errors(toValidate string) authboss.ErrorList {
 
 	ln := len(toValidate)
 	if r.Required && (ln == 0 || blankRegex.MatchString(toValidate)) {
-		return append(errs, FieldError{r.FieldName, errors.New("Cannot be blank")})
+		return append(errs, FieldError{r.FieldName, authboss.NewLocalizedError(authboss.TxtRequired)})
 	}
 
 	if r.MustMatch != nil {
@@ -46,27 +50,33 @@ func (r Rules) Errors(toValidate string) authboss.ErrorList {
 	}
 
 	if (r.MinLength > 0 && ln < r.MinLength) || (r.MaxLength > 0 && ln > r.MaxLength) {
-		errs = append(errs, FieldError{r.FieldName, errors.New(r.lengthErr())})
+		errs = append(errs, FieldError{r.FieldName, r.lengthErr()})
 	}
 
 	upper, lower, numeric, symbols, whitespace := tallyCharacters(toValidate)
 	if upper+lower < r.MinLetters {
-		errs = append(errs, FieldError{r.FieldName, errors.New(r.charErr())})
+		errs = append(errs, FieldError{r.FieldName, r.charErr()})
 	}
 	if upper < r.MinUpper {
-		errs = append(errs, FieldError{r.FieldName, errors.New(r.upperErr())})
+		errs = append(errs, FieldError{r.FieldName, r.upperErr()})
 	}
 	if lower < r.MinLower {
-		errs = append(errs, FieldError{r.FieldName, errors.New(r.lowerErr())})
+		errs = append(errs, FieldError{r.FieldName, r.lowerErr()})
 	}
 	if numeric < r.MinNumeric {
-		errs = append(errs, FieldError{r.FieldName, errors.New(r.numericErr())})
+		errs = append(errs, FieldError{r.FieldName, r.numericErr()})
 	}
 	if symbols < r.MinSymbols {
-		errs = append(errs, FieldError{r.FieldName, errors.New(r.symbolErr())})
+		errs = append(errs, FieldError{r.FieldName, r.symbolErr()})
 	}
 	if !r.AllowWhitespace && whitespace > 0 {
-		errs = append(errs, FieldError{r.FieldName, errors.New("No whitespace permitted")})
+		errs = append(errs, FieldError{r.FieldName, authboss.NewLocalizedError(authboss.TxtNoWhitespace)})
+	}
+
+	for _, name := range r.Validators {
+		if err := lookupValidator(name)(toValidate); err != nil {
+			errs = append(errs, FieldError{r.FieldName, err})
+		}
 	}
 
 	if len(errs) == 0 {
@@ -89,95 +99,53 @@ func (r Rules) Rules() []string {
 		rules = append(rules, r.MatchError)
 	}
 
-	if e := r.lengthErr(); len(e) > 0 {
-		rules = append(rules, e)
-	}
-	if e := r.charErr(); len(e) > 0 {
-		rules = append(rules, e)
-	}
-	if e := r.upperErr(); len(e) > 0 {
-		rules = append(rules, e)
-	}
-	if e := r.lowerErr(); len(e) > 0 {
-		rules = append(rules, e)
-	}
-	if e := r.numericErr(); len(e) > 0 {
-		rules = append(rules, e)
-	}
-	if e := r.symbolErr(); len(e) > 0 {
-		rules = append(rules, e)
+	for _, err := range []error{r.lengthErr(), r.charErr(), r.upperErr(), r.lowerErr(), r.numericErr(), r.symbolErr()} {
+		if err != nil {
+			rules = append(rules, err.Error())
+		}
 	}
 
 	return rules
 }
 
-func (r Rules) lengthErr() (err string) {
+func (r Rules) lengthErr() error {
 	switch {
 	case r.MinLength > 0 && r.MaxLength > 0:
-		err = fmt.Sprintf("Must be between %d and %d characters", r.MinLength, r.MaxLength)
+		return authboss.NewLocalizedError(authboss.TxtLengthRange, "Min", r.MinLength, "Max", r.MaxLength)
 	case r.MinLength > 0:
-		err = fmt.Sprintf("Must be at least %d character", r.MinLength)
-		if r.MinLength > 1 {
-			err += "s"
-		}
+		return authboss.NewLocalizedError(authboss.TxtMinLength, "Min", r.MinLength)
 	case r.MaxLength > 0:
-		err = fmt.Sprintf("Must be at most %d character", r.MaxLength)
-		if r.MaxLength > 1 {
-			err += "s"
-		}
+		return authboss.NewLocalizedError(authboss.TxtMaxLength, "Max", r.MaxLength)
 	}
 
-	return err
+	return nil
 }
 
-func (r Rules) charErr() (err string) {
-	if r.MinLetters > 0 {
-		err = fmt.Sprintf("Must contain at least %d letter", r.MinLetters)
-		if r.MinLetters > 1 {
-			err += "s"
-		}
-	}
-	return err
+func (r Rules) charErr() error {
+	return minErr(authboss.TxtMinLetters, r.MinLetters)
 }
 
-func (r Rules) upperErr() (err string) {
-	if r.MinUpper > 0 {
-		err = fmt.Sprintf("Must contain at least %d uppercase letter", r.MinUpper)
-		if r.MinUpper > 1 {
-			err += "s"
-		}
-	}
-	return err
+func (r Rules) upperErr() error {
+	return minErr(authboss.TxtMinUpper, r.MinUpper)
 }
 
-func (r Rules) lowerErr() (err string) {
-	if r.MinLower > 0 {
-		err = fmt.Sprintf("Must contain at least %d lowercase letter", r.MinLower)
-		if r.MinLower > 1 {
-			err += "s"
-		}
-	}
-	return err
+func (r Rules) lowerErr() error {
+	return minErr(authboss.TxtMinLower, r.MinLower)
 }
 
-func (r Rules) numericErr() (err string) {
-	if r.MinNumeric > 0 {
-		err = fmt.Sprintf("Must contain at least %d number", r.MinNumeric)
-		if r.MinNumeric > 1 {
-			err += "s"
-		}
-	}
-	return err
+func (r Rules) numericErr() error {
+	return minErr(authboss.TxtMinNumeric, r.MinNumeric)
 }
 
-func (r Rules) symbolErr() (err string) {
-	if r.MinSymbols > 0 {
-		err = fmt.Sprintf("Must contain at least %d symbol", r.MinSymbols)
-		if r.MinSymbols > 1 {
-			err += "s"
-		}
+func (r Rules) symbolErr() error {
+	return minErr(authboss.TxtMinSymbols, r.MinSymbols)
+}
+
+func minErr(key authboss.LocalizationKey, min int) error {
+	if min > 0 {
+		return authboss.NewLocalizedError(key, "Min", min)
 	}
-	return err
+	return nil
 }
 
 func tallyCharacters(s string) (upper, lower, numeric, symbols, whitespace int) {
@@ -0,0 +1,67 @@
+package defaults
+
+import (
+	"context"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// secretPrefix starts every secret the AESSecretEncryptor encrypted, so
+// the plaintext secrets stored before it was used can be told apart
+const secretPrefix = "aesgcm:"
+
+// AESSecretEncryptor is an authboss.SecretEncryptor that encrypts secrets
+// with AES-GCM. The secret's name is authenticated with it.
+//
+// Secrets are encrypted with the first of the Keys and decrypted with any
+// of them, to rotate keys put the new key first and keep the old ones
+// after it until every secret was encrypted again (they're encrypted again
+// with the first key when they're next used, see authboss.LoadSecret).
+type AESSecretEncryptor struct {
+	Keys [][]byte
+}
+
+// NewAESSecretEncryptor creates an AESSecretEncryptor, it fails if there
+// are no keys or one of them isn't a valid AES key (16, 24 or 32 bytes).
+func NewAESSecretEncryptor(keys ...[]byte) (*AESSecretEncryptor, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("a secret encryption key is required")
+	}
+	for _, key := range keys {
+		if _, err := newGCM(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &AESSecretEncryptor{Keys: keys}, nil
+}
+
+// EncryptSecret encrypts the secret with the first key
+func (a *AESSecretEncryptor) EncryptSecret(ctx context.Context, name, secret string) (string, error) {
+	sealed, err := sealState(a.Keys, []byte(secret), []byte(name))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encrypt %s", name)
+	}
+	return secretPrefix + sealed, nil
+}
+
+// DecryptSecret decrypts the secret with whichever key it was encrypted
+// with, secrets that weren't encrypted are returned as they are. Either
+// way when it wasn't encrypted with the first key it must be encrypted
+// again.
+func (a *AESSecretEncryptor) DecryptSecret(ctx context.Context, name, value string) (string, bool, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, true, nil
+	}
+	value = strings.TrimPrefix(value, secretPrefix)
+
+	if plain, ok := openState(a.Keys[:1], value, []byte(name)); ok {
+		return string(plain), false, nil
+	}
+	if plain, ok := openState(a.Keys[1:], value, []byte(name)); ok {
+		return string(plain), true, nil
+	}
+
+	return "", false, errors.Errorf("failed to decrypt %s, it was encrypted with another key or tampered with", name)
+}
@@ -0,0 +1,55 @@
+package defaults
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestAESSecretEncryptor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+
+	old, err := NewAESSecretEncryptor(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.EncryptSecret(ctx, authboss.AttributeTOTPSecretKey, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed == "JBSWY3DPEHPK3PXP" {
+		t.Error("the secret should be encrypted")
+	}
+
+	secret, reencrypt, err := old.DecryptSecret(ctx, authboss.AttributeTOTPSecretKey, sealed)
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" || reencrypt {
+		t.Error("decrypted wrong:", secret, reencrypt, err)
+	}
+
+	if _, _, err = old.DecryptSecret(ctx, authboss.AttributeSMSPhoneNumber, sealed); err == nil {
+		t.Error("a secret shouldn't decrypt under another name")
+	}
+
+	rotated, err := NewAESSecretEncryptor(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, reencrypt, err = rotated.DecryptSecret(ctx, authboss.AttributeTOTPSecretKey, sealed)
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" || !reencrypt {
+		t.Error("secrets of old keys should decrypt and be encrypted again:", secret, reencrypt, err)
+	}
+
+	secret, reencrypt, err = rotated.DecryptSecret(ctx, authboss.AttributeSMSPhoneNumber, "+15551234567")
+	if err != nil || secret != "+15551234567" || !reencrypt {
+		t.Error("plaintext secrets should be encrypted:", secret, reencrypt, err)
+	}
+
+	if _, err = NewAESSecretEncryptor([]byte("short")); err == nil {
+		t.Error("invalid keys should be rejected")
+	}
+}
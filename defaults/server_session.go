@@ -0,0 +1,426 @@
+package defaults
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// DefaultSessionCookie is the cookie NewServerSessionReadWriter uses
+	DefaultSessionCookie = "ab_session"
+	// DefaultSessionTTL is the ttl NewServerSessionReadWriter uses
+	DefaultSessionTTL = 24 * time.Hour
+
+	nSessionIDSize = 32
+)
+
+// ServerSession is the client state read by ServerSessionReadWriter
+type ServerSession struct {
+	ID     string
+	Values map[string]string
+}
+
+// Get a value from the session
+func (s ServerSession) Get(key string) (string, bool) {
+	val, ok := s.Values[key]
+	return val, ok
+}
+
+// ServerSessionReadWriter is a ClientStateReadWriter for sessions that
+// keeps the session's values in an authboss.SessionStore and only the
+// session id in the cookie.
+//
+// The session id is changed whenever the user logs in or out, or
+// authboss otherwise regenerates it (see authboss.SessionRegenerator), so
+// a session id known before logging in is useless afterwards.
+type ServerSessionReadWriter struct {
+	Store authboss.SessionStore
+	// TTL is how long a session lives after it was last changed
+	TTL time.Duration
+
+	// Cookie is the template for the session id cookie, only the Name,
+	// Path, Domain, Secure, HttpOnly and SameSite fields are used. With
+	// tenants the name is scoped to the tenant, see authboss.TenantCookie.
+	// authboss.Init sets its attributes from the Config, see
+	// ConfigureCookie.
+	Cookie http.Cookie
+
+	// Now tells the time, it's time.Now if it's nil. authboss.Init sets it
+	// to Authboss.Now, see ConfigureClock.
+	Now func() time.Time
+}
+
+// NewServerSessionReadWriter creates a ServerSessionReadWriter that keeps
+// the session id in a secure, http only cookie and sessions for
+// DefaultSessionTTL.
+func NewServerSessionReadWriter(store authboss.SessionStore) *ServerSessionReadWriter {
+	return &ServerSessionReadWriter{
+		Store: store,
+		TTL:   DefaultSessionTTL,
+		Cookie: http.Cookie{
+			Name:     DefaultSessionCookie,
+			Path:     "/",
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// ConfigureCookie sets the cookie's attributes, the Name is only changed if
+// the config has one
+func (s *ServerSessionReadWriter) ConfigureCookie(c authboss.CookieConfig) {
+	c.Apply(&s.Cookie)
+}
+
+// ConfigureClock sets Now, and the Store's clock if it has one
+func (s *ServerSessionReadWriter) ConfigureClock(now func() time.Time) {
+	s.Now = now
+	if c, ok := s.Store.(authboss.ClockConfigurer); ok {
+		c.ConfigureClock(now)
+	}
+}
+
+func (s *ServerSessionReadWriter) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+// ReadState loads the session, an unknown or expired session id is treated
+// as a client without a session.
+func (s *ServerSessionReadWriter) ReadState(r *http.Request) (authboss.ClientState, error) {
+	cookie, err := r.Cookie(authboss.TenantCookie(s.Cookie.Name, authboss.Tenant(r.Context())))
+	if err != nil || len(cookie.Value) == 0 {
+		return ServerSession{Values: map[string]string{}}, nil
+	}
+
+	values, err := s.Store.LoadSession(r.Context(), cookie.Value)
+	if err == authboss.ErrSessionNotFound {
+		return ServerSession{Values: map[string]string{}}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load session")
+	}
+
+	return ServerSession{ID: cookie.Value, Values: values}, nil
+}
+
+// WriteState saves the session to the store, the session is only saved
+// when it was changed.
+func (s *ServerSessionReadWriter) WriteState(w http.ResponseWriter, cstate authboss.ClientState, events []authboss.ClientStateEvent) error {
+	// A regenerated session must be saved under its new id even when
+	// nothing else changed
+	existing, _ := cstate.(ServerSession)
+	if len(events) == 0 && (len(existing.ID) != 0 || len(existing.Values) == 0) {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	session := ServerSession{Values: map[string]string{}}
+	if existing, ok := cstate.(ServerSession); ok {
+		session.ID = existing.ID
+		for k, v := range existing.Values {
+			session.Values[k] = v
+		}
+	}
+
+	rotate := false
+	for _, ev := range events {
+		switch ev.Kind {
+		case authboss.ClientStateEventPut:
+			session.Values[ev.Key] = ev.Value
+			rotate = rotate || ev.Key == authboss.SessionKey
+		case authboss.ClientStateEventDel:
+			delete(session.Values, ev.Key)
+		case authboss.ClientStateEventDelAll:
+			whitelist := strings.Split(ev.Key, ",")
+			for k := range session.Values {
+				if !contains(whitelist, k) {
+					delete(session.Values, k)
+				}
+			}
+			rotate = true
+		}
+	}
+
+	if len(session.ID) != 0 && (rotate || len(session.Values) == 0) {
+		if err := s.Store.DeleteSession(ctx, session.ID); err != nil {
+			return errors.Wrap(err, "failed to delete session")
+		}
+		session.ID = ""
+	}
+
+	if len(session.Values) == 0 {
+		s.write(w, "", -1)
+		return nil
+	}
+
+	if len(session.ID) == 0 {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	if err := s.Store.SaveSession(ctx, session.ID, session.Values, s.TTL); err != nil {
+		return errors.Wrap(err, "failed to save session")
+	}
+
+	s.write(w, session.ID, int(s.TTL/time.Second))
+	return nil
+}
+
+// RegenerateSession deletes the session from the store, WriteState saves
+// its values under a new id.
+func (s *ServerSessionReadWriter) RegenerateSession(w http.ResponseWriter, cstate authboss.ClientState) (authboss.ClientState, error) {
+	existing, ok := cstate.(ServerSession)
+	if !ok || len(existing.ID) == 0 {
+		return cstate, nil
+	}
+
+	if err := s.Store.DeleteSession(context.Background(), existing.ID); err != nil {
+		return nil, errors.Wrap(err, "failed to delete session")
+	}
+	return ServerSession{Values: existing.Values}, nil
+}
+
+// RevokeSessions deletes all of the user's sessions when the Store is an
+// authboss.UserSessionStore, otherwise they're left to expire.
+func (s *ServerSessionReadWriter) RevokeSessions(ctx context.Context, pid string) error {
+	store, ok := s.Store.(authboss.UserSessionStore)
+	if !ok {
+		return nil
+	}
+
+	return store.DeleteUserSessions(ctx, pid)
+}
+
+func (s *ServerSessionReadWriter) write(w http.ResponseWriter, id string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     authboss.TenantCookie(s.Cookie.Name, authboss.ResponseTenant(w)),
+		Value:    id,
+		Path:     s.Cookie.Path,
+		Domain:   s.Cookie.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.Cookie.Secure,
+		HttpOnly: s.Cookie.HttpOnly,
+		SameSite: s.Cookie.SameSite,
+	}
+	if maxAge > 0 {
+		cookie.Expires = s.now().UTC().Add(time.Duration(maxAge) * time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, nSessionIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", errors.Wrap(err, "failed to create session id")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// MemorySessionStore is a SessionStore kept in memory, it's only suitable
+// for development and single server deployments.
+type MemorySessionStore struct {
+	// Now tells the time, it's time.Now if it's nil. authboss.Init sets it
+	// to Authboss.Now through the ServerSessionReadWriter.
+	Now func() time.Time
+
+	mut      sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	values  map[string]string
+	expires time.Time
+}
+
+// NewMemorySessionStore constructor
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// ConfigureClock sets Now
+func (m *MemorySessionStore) ConfigureClock(now func() time.Time) {
+	m.Now = now
+}
+
+func (m *MemorySessionStore) now() time.Time {
+	if m.Now == nil {
+		return time.Now()
+	}
+	return m.Now()
+}
+
+// LoadSession from memory
+func (m *MemorySessionStore) LoadSession(ctx context.Context, id string) (map[string]string, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok || !m.now().Before(session.expires) {
+		delete(m.sessions, id)
+		return nil, authboss.ErrSessionNotFound
+	}
+
+	values := make(map[string]string, len(session.values))
+	for k, v := range session.values {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// SaveSession to memory
+func (m *MemorySessionStore) SaveSession(ctx context.Context, id string, values map[string]string, ttl time.Duration) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	now := m.now()
+	for id, session := range m.sessions {
+		if !now.Before(session.expires) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.sessions[id] = memorySession{values: values, expires: now.Add(ttl)}
+	return nil
+}
+
+// DeleteSession from memory
+func (m *MemorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// DeleteUserSessions from memory
+func (m *MemorySessionStore) DeleteUserSessions(ctx context.Context, pid string) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for id, session := range m.sessions {
+		if session.values[authboss.SessionKey] == pid {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// RedisClient is the small part of a redis client that RedisSessionStore
+// needs so that any redis library can be adapted to it. Get must return
+// ok as false when the key does not exist.
+type RedisClient interface {
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+	SetEX(ctx context.Context, key, value string, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+}
+
+// RedisSetClient is a RedisClient that can also keep sets, which
+// RedisSessionStore needs to find a user's sessions. SAddExpire adds the
+// member to the set and expires the whole set after ttl (SADD and EXPIRE).
+type RedisSetClient interface {
+	RedisClient
+	SAddExpire(ctx context.Context, key, member string, ttl time.Duration) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+}
+
+// RedisSessionStore is a SessionStore that keeps each session as a JSON
+// object under Prefix + id, expired by redis. When the Client is a
+// RedisSetClient the ids of a user's sessions are also kept in a set under
+// Prefix + "user:" + pid so DeleteUserSessions can find them, with other
+// clients DeleteUserSessions does nothing.
+type RedisSessionStore struct {
+	Client RedisClient
+	Prefix string
+}
+
+// NewRedisSessionStore creates a store with the "ab_session:" key prefix
+func NewRedisSessionStore(client RedisClient) *RedisSessionStore {
+	return &RedisSessionStore{Client: client, Prefix: "ab_session:"}
+}
+
+// LoadSession from redis
+func (r *RedisSessionStore) LoadSession(ctx context.Context, id string) (map[string]string, error) {
+	value, ok, err := r.Client.Get(ctx, r.Prefix+id)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, authboss.ErrSessionNotFound
+	}
+
+	var values map[string]string
+	if err = json.Unmarshal([]byte(value), &values); err != nil {
+		return nil, errors.Wrap(err, "failed to decode session")
+	}
+	return values, nil
+}
+
+// SaveSession to redis
+func (r *RedisSessionStore) SaveSession(ctx context.Context, id string, values map[string]string, ttl time.Duration) error {
+	value, err := json.Marshal(values)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode session")
+	}
+
+	if err = r.Client.SetEX(ctx, r.Prefix+id, string(value), ttl); err != nil {
+		return err
+	}
+
+	setClient, ok := r.Client.(RedisSetClient)
+	pid := values[authboss.SessionKey]
+	if !ok || len(pid) == 0 {
+		return nil
+	}
+	// The set outlives each of its sessions, ids of sessions that are gone
+	// are harmless since deleting them does nothing
+	return setClient.SAddExpire(ctx, r.userKey(pid), id, ttl)
+}
+
+// DeleteSession from redis
+func (r *RedisSessionStore) DeleteSession(ctx context.Context, id string) error {
+	return r.Client.Del(ctx, r.Prefix+id)
+}
+
+// DeleteUserSessions from redis
+func (r *RedisSessionStore) DeleteUserSessions(ctx context.Context, pid string) error {
+	setClient, ok := r.Client.(RedisSetClient)
+	if !ok {
+		return nil
+	}
+
+	ids, err := setClient.SMembers(ctx, r.userKey(pid))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := r.Client.Del(ctx, r.Prefix+id); err != nil {
+			return err
+		}
+	}
+
+	return r.Client.Del(ctx, r.userKey(pid))
+}
+
+// userKey is the key of the set of the user's session ids, session ids are
+// base64 so they can't collide with it
+func (r *RedisSessionStore) userKey(pid string) string {
+	return r.Prefix + "user:" + pid
+}
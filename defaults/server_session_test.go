@@ -0,0 +1,291 @@
+package defaults
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+type testRedisClient map[string]string
+
+func (t testRedisClient) Get(ctx context.Context, key string) (string, bool, error) {
+	value, ok := t[key]
+	return value, ok, nil
+}
+
+func (t testRedisClient) SetEX(ctx context.Context, key, value string, ttl time.Duration) error {
+	t[key] = value
+	return nil
+}
+
+func (t testRedisClient) Del(ctx context.Context, key string) error {
+	delete(t, key)
+	return nil
+}
+
+type testRedisSetClient struct {
+	testRedisClient
+	sets map[string][]string
+}
+
+func (t testRedisSetClient) SAddExpire(ctx context.Context, key, member string, ttl time.Duration) error {
+	t.sets[key] = append(t.sets[key], member)
+	return nil
+}
+
+func (t testRedisSetClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	return t.sets[key], nil
+}
+
+func (t testRedisSetClient) Del(ctx context.Context, key string) error {
+	delete(t.sets, key)
+	return t.testRedisClient.Del(ctx, key)
+}
+
+func sessionRoundTrip(t *testing.T, s *ServerSessionReadWriter, state authboss.ClientState, events ...authboss.ClientStateEvent) (authboss.ClientState, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := s.WriteState(rec, state, events); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	read, err := s.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read, rec
+}
+
+func TestServerSessionRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	redis := testRedisClient{}
+	s := NewServerSessionReadWriter(NewRedisSessionStore(redis))
+
+	state, rec := sessionRoundTrip(t, s, nil,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "other", Value: "value"},
+	)
+	if other, ok := state.Get("other"); !ok || other != "value" {
+		t.Error("value was wrong:", other)
+	}
+
+	cookie := rec.Result().Cookies()[0]
+	if cookie.Name != DefaultSessionCookie || !cookie.HttpOnly || !cookie.Secure || cookie.MaxAge <= 0 {
+		t.Errorf("cookie was wrong: %#v", cookie)
+	}
+	if cookie.Value != state.(ServerSession).ID {
+		t.Error("the cookie should only hold the session id")
+	}
+	if _, ok := redis["ab_session:"+cookie.Value]; !ok || len(redis) != 1 {
+		t.Error("the session should be saved in redis:", redis)
+	}
+
+	before := state.(ServerSession).ID
+	state, _ = sessionRoundTrip(t, s, state,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test@test.com"},
+	)
+	if uid, ok := state.Get(authboss.SessionKey); !ok || uid != "test@test.com" {
+		t.Error("uid was wrong:", uid)
+	}
+	if state.(ServerSession).ID == before || len(redis) != 1 {
+		t.Error("the session id should be changed on login")
+	}
+
+	state, _ = sessionRoundTrip(t, s, state,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventDelAll, Key: "other"},
+	)
+	if _, ok := state.Get(authboss.SessionKey); ok {
+		t.Error("uid should have been deleted")
+	}
+	if _, ok := state.Get("other"); !ok {
+		t.Error("whitelisted keys should be kept")
+	}
+
+	_, rec = sessionRoundTrip(t, s, state, authboss.ClientStateEvent{Kind: authboss.ClientStateEventDel, Key: "other"})
+	if cookie := rec.Result().Cookies()[0]; cookie.MaxAge >= 0 {
+		t.Error("the cookie should be deleted when the session is empty")
+	}
+	if len(redis) != 0 {
+		t.Error("the session should be deleted from redis:", redis)
+	}
+}
+
+func TestServerSessionConfigureCookie(t *testing.T) {
+	t.Parallel()
+
+	s := NewServerSessionReadWriter(NewMemorySessionStore())
+	s.ConfigureCookie(authboss.CookieConfig{Path: "/app", Domain: "example.com", SameSite: http.SameSiteStrictMode})
+
+	_, rec := sessionRoundTrip(t, s, nil,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "other", Value: "value"},
+	)
+	cookie := rec.Result().Cookies()[0]
+	if cookie.Name != DefaultSessionCookie || cookie.Path != "/app" || cookie.Domain != "example.com" ||
+		cookie.Secure || cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("cookie was wrong: %#v", cookie)
+	}
+}
+
+func TestServerSessionRegenerate(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemorySessionStore()
+	s := NewServerSessionReadWriter(store)
+
+	state, _ := sessionRoundTrip(t, s, nil,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "other", Value: "value"},
+	)
+	before := state.(ServerSession).ID
+
+	regenerated, err := s.RegenerateSession(httptest.NewRecorder(), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadSession(context.Background(), before); err != authboss.ErrSessionNotFound {
+		t.Error("the old session should be deleted:", err)
+	}
+
+	state, _ = sessionRoundTrip(t, s, regenerated)
+	if id := state.(ServerSession).ID; len(id) == 0 || id == before {
+		t.Error("the session should have a new id:", id)
+	}
+	if other, ok := state.Get("other"); !ok || other != "value" {
+		t.Error("the values should be kept:", other)
+	}
+}
+
+func TestServerSessionRevoked(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemorySessionStore()
+	s := NewServerSessionReadWriter(store)
+
+	state, rec := sessionRoundTrip(t, s, nil,
+		authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "test"},
+	)
+	if err := store.DeleteSession(context.Background(), state.(ServerSession).ID); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	state, err := s.ReadState(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := state.Get(authboss.SessionKey); ok {
+		t.Error("a revoked session should be empty")
+	}
+}
+
+func TestServerSessionRevokeSessions(t *testing.T) {
+	t.Parallel()
+
+	redis := testRedisSetClient{testRedisClient: testRedisClient{}, sets: map[string][]string{}}
+	stores := map[string]authboss.SessionStore{
+		"memory": NewMemorySessionStore(),
+		"redis":  NewRedisSessionStore(redis),
+	}
+
+	for name, store := range stores {
+		s := NewServerSessionReadWriter(store)
+		login := func(pid string) *http.Cookie {
+			_, rec := sessionRoundTrip(t, s, nil,
+				authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: pid},
+			)
+			return rec.Result().Cookies()[0]
+		}
+		loggedIn := func(cookie *http.Cookie) bool {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(cookie)
+			state, err := s.ReadState(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, ok := state.Get(authboss.SessionKey)
+			return ok
+		}
+
+		phone, laptop, other := login("test"), login("test"), login("other")
+		if err := s.RevokeSessions(context.Background(), "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		if loggedIn(phone) || loggedIn(laptop) {
+			t.Errorf("%s: the user's sessions should have been deleted", name)
+		}
+		if !loggedIn(other) {
+			t.Errorf("%s: other users' sessions should be kept", name)
+		}
+	}
+
+	if _, ok := redis.sets["ab_session:user:test"]; ok {
+		t.Error("the user's session set should be deleted")
+	}
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemorySessionStore()
+	if err := store.SaveSession(ctx, "id", map[string]string{"uid": "test"}, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.LoadSession(ctx, "id"); err != authboss.ErrSessionNotFound {
+		t.Error("expired sessions should not be found:", err)
+	}
+}
+
+func TestServerSessionTenant(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.TenantResolver = authboss.TenantFromHeader("X-Tenant")
+	ab.Config.Storage.SessionState = NewServerSessionReadWriter(NewRedisSessionStore(testRedisClient{}))
+
+	rec := httptest.NewRecorder()
+	w := ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	if _, err := ab.LoadClientState(w, r); err != nil {
+		t.Fatal(err)
+	}
+	authboss.PutSession(w, authboss.SessionKey, "test@test.com")
+	w.WriteHeader(http.StatusOK)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultSessionCookie+"_acme" {
+		t.Fatal("the cookie should be named for the tenant:", cookies)
+	}
+
+	read := func(tenant string) bool {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Tenant", tenant)
+		r.AddCookie(cookies[0])
+		r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ok := authboss.GetSession(r, authboss.SessionKey)
+		return ok
+	}
+
+	if !read("acme") {
+		t.Error("the session should be read for its tenant")
+	}
+	if read("other") {
+		t.Error("another tenant should not read the session")
+	}
+}
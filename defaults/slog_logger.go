@@ -0,0 +1,57 @@
+//go:build go1.21
+// +build go1.21
+
+package defaults
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// SlogLogger logs with log/slog. It's an authboss.FieldLogger so request
+// loggers carry the request's id, the user's pid and the module as
+// attributes, and an authboss.ContextLogger so the handler is given the
+// request's context.
+type SlogLogger struct {
+	Logger *slog.Logger
+
+	ctx context.Context
+}
+
+// NewSlogLogger creates a logger from a slog.Logger, slog.Default() if
+// it's nil
+func NewSlogLogger(logger *slog.Logger) SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return SlogLogger{Logger: logger}
+}
+
+// Info logs go here
+func (s SlogLogger) Info(msg string) {
+	s.Logger.InfoContext(s.context(), msg)
+}
+
+// Error logs go here
+func (s SlogLogger) Error(msg string) {
+	s.Logger.ErrorContext(s.context(), msg)
+}
+
+// With returns a logger that logs the key/value pairs as attributes
+func (s SlogLogger) With(keyvals ...interface{}) authboss.Logger {
+	return SlogLogger{Logger: s.Logger.With(keyvals...), ctx: s.ctx}
+}
+
+// FromContext returns a logger that passes ctx to the handler
+func (s SlogLogger) FromContext(ctx context.Context) authboss.Logger {
+	return SlogLogger{Logger: s.Logger, ctx: ctx}
+}
+
+func (s SlogLogger) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
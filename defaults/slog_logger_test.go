@@ -0,0 +1,46 @@
+//go:build go1.21
+// +build go1.21
+
+package defaults
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestSlogLogger(t *testing.T) {
+	t.Parallel()
+
+	b := &bytes.Buffer{}
+	ab := authboss.New()
+	ab.Config.Core.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(b, nil)))
+
+	r := httptest.NewRequest("POST", "/auth/login", nil)
+	r.Header.Set(authboss.HeaderRequestID, "abc")
+	ctx := context.WithValue(r.Context(), authboss.CTXKeyPID, "test@test.com")
+	ctx = context.WithValue(ctx, authboss.CTXKeyModule, "auth")
+	r = r.WithContext(ctx)
+
+	ab.RequestLogger(r).Infof("user %s logged in", "test@test.com")
+	ab.RequestLogger(r).With("provider", "google").Errorf("failed")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrong number of lines:\n%s", b.String())
+	}
+
+	want := `level=INFO msg="user test@test.com logged in" request_id=abc pid=test@test.com module=auth`
+	if !strings.HasSuffix(lines[0], want) {
+		t.Errorf("info line was wrong, want suffix:\n%s\ngot:\n%s", want, lines[0])
+	}
+	want = `level=ERROR msg=failed request_id=abc pid=test@test.com module=auth provider=google`
+	if !strings.HasSuffix(lines[1], want) {
+		t.Errorf("error line was wrong, want suffix:\n%s\ngot:\n%s", want, lines[1])
+	}
+}
@@ -3,35 +3,110 @@ package defaults
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/smtp"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
+	"golang.org/x/oauth2"
+)
+
+// SMTPMailer defaults
+const (
+	DefaultSMTPPoolSize    = 2
+	DefaultSMTPTimeout     = 30 * time.Second
+	DefaultSMTPIdleTimeout = 30 * time.Second
+)
+
+// SMTPTLS is how an SMTPMailer secures its connections
+type SMTPTLS int
+
+// SMTPTLS modes
+const (
+	// SMTPTLSOpportunistic upgrades with STARTTLS when the server offers it
+	SMTPTLSOpportunistic SMTPTLS = iota
+	// SMTPStartTLS requires the server to offer STARTTLS, usually on
+	// port 587
+	SMTPStartTLS
+	// SMTPImplicitTLS connects with TLS from the start, usually on
+	// port 465
+	SMTPImplicitTLS
+	// SMTPNoTLS never uses TLS, only for local relays and testing
+	SMTPNoTLS
 )
 
 // NewSMTPMailer creates an SMTP Mailer to send emails with.
 // An example usage might be something like:
 //
-//   NewSMTPMailer("smtp.gmail.com",
+//   NewSMTPMailer("smtp.gmail.com:587",
 //     smtp.PlainAuth("", "admin@yoursite.com", "password", "smtp.gmail.com"))
 func NewSMTPMailer(server string, auth smtp.Auth) *SMTPMailer {
 	if len(server) == 0 {
 		panic("SMTP Mailer must be created with a server string.")
 	}
 	random := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return &SMTPMailer{server, auth, random}
+	return &SMTPMailer{
+		Server:      server,
+		Auth:        auth,
+		PoolSize:    DefaultSMTPPoolSize,
+		Timeout:     DefaultSMTPTimeout,
+		IdleTimeout: DefaultSMTPIdleTimeout,
+		rand:        random,
+		pool:        &smtpPool{},
+	}
 }
 
-// SMTPMailer uses smtp to actually send e-mails
+// SMTPMailer uses smtp to actually send e-mails. Connections are kept open
+// and reused for the next e-mails, up to PoolSize of them.
 type SMTPMailer struct {
+	// Server is the host:port of the smtp server
 	Server string
 	Auth   smtp.Auth
-	rand   *rand.Rand
+
+	// TLS is how connections are secured, by default they're upgraded
+	// with STARTTLS if the server offers it
+	TLS SMTPTLS
+	// TLSConfig is used for STARTTLS and implicit TLS, the server name is
+	// the host of Server if it isn't set
+	TLSConfig *tls.Config
+	// LocalName is the host name sent with EHLO, localhost if empty
+	LocalName string
+
+	// PoolSize is how many idle connections are kept, a connection is
+	// made for each e-mail if it's 0
+	PoolSize int
+	// Timeout is the longest connecting or sending an e-mail may take,
+	// as well as the deadline of ctx
+	Timeout time.Duration
+	// IdleTimeout is how long an idle connection is kept
+	IdleTimeout time.Duration
+
+	rand *rand.Rand
+	pool *smtpPool
+}
+
+// smtpPool are the idle connections of an SMTPMailer
+type smtpPool struct {
+	mut    sync.Mutex
+	idle   []*smtpConn
+	closed bool
+}
+
+type smtpConn struct {
+	conn   net.Conn
+	client *smtp.Client
+	used   time.Time
+}
+
+func (c *smtpConn) close() {
+	_ = c.client.Close()
 }
 
 // Send an e-mail
@@ -57,7 +132,249 @@ func (s SMTPMailer) Send(ctx context.Context, mail authboss.Email) error {
 
 	toSend := bytes.Replace(buf.Bytes(), []byte{'\n'}, []byte{'\r', '\n'}, -1)
 
-	return smtp.SendMail(s.Server, s.Auth, mail.From, mail.To, toSend)
+	conn, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.send(ctx, conn, mail.From, mail.To, toSend); err != nil {
+		conn.close()
+		return err
+	}
+
+	s.put(conn)
+	return nil
+}
+
+// Shutdown closes the idle connections, see authboss.Shutdowner
+func (s SMTPMailer) Shutdown(ctx context.Context) error {
+	if s.pool == nil {
+		return nil
+	}
+
+	s.pool.mut.Lock()
+	idle := s.pool.idle
+	s.pool.idle = nil
+	s.pool.closed = true
+	s.pool.mut.Unlock()
+
+	for _, c := range idle {
+		_ = c.client.Quit()
+	}
+	return nil
+}
+
+func (s SMTPMailer) send(ctx context.Context, c *smtpConn, from string, to []string, msg []byte) error {
+	if err := c.conn.SetDeadline(s.deadline(ctx)); err != nil {
+		return errors.Wrap(err, "failed to set smtp deadline")
+	}
+
+	if err := c.client.Mail(from); err != nil {
+		return errors.Wrap(err, "smtp MAIL failed")
+	}
+	for _, addr := range to {
+		if err := c.client.Rcpt(addr); err != nil {
+			return errors.Wrapf(err, "smtp RCPT failed for %s", addr)
+		}
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return errors.Wrap(err, "smtp DATA failed")
+	}
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "failed to write e-mail")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "failed to send e-mail")
+	}
+
+	return nil
+}
+
+// conn takes an idle connection that's still alive from the pool, or
+// makes a new one
+func (s SMTPMailer) conn(ctx context.Context) (*smtpConn, error) {
+	for {
+		c := s.take()
+		if c == nil {
+			break
+		}
+
+		// RSET checks the server hasn't dropped the connection
+		if err := c.conn.SetDeadline(s.deadline(ctx)); err == nil {
+			if err = c.client.Reset(); err == nil {
+				return c, nil
+			}
+		}
+		c.close()
+	}
+
+	return s.dial(ctx)
+}
+
+func (s SMTPMailer) take() *smtpConn {
+	if s.pool == nil {
+		return nil
+	}
+
+	s.pool.mut.Lock()
+	defer s.pool.mut.Unlock()
+
+	for len(s.pool.idle) != 0 {
+		c := s.pool.idle[len(s.pool.idle)-1]
+		s.pool.idle = s.pool.idle[:len(s.pool.idle)-1]
+
+		if s.IdleTimeout == 0 || time.Since(c.used) < s.IdleTimeout {
+			return c
+		}
+		go c.close()
+	}
+
+	return nil
+}
+
+// put the connection back in the pool, or close it if the pool is full
+func (s SMTPMailer) put(c *smtpConn) {
+	if s.pool != nil {
+		s.pool.mut.Lock()
+		if !s.pool.closed && len(s.pool.idle) < s.PoolSize {
+			c.used = time.Now()
+			s.pool.idle = append(s.pool.idle, c)
+			s.pool.mut.Unlock()
+			return
+		}
+		s.pool.mut.Unlock()
+	}
+
+	_ = c.client.Quit()
+}
+
+func (s SMTPMailer) dial(ctx context.Context) (*smtpConn, error) {
+	host, _, err := net.SplitHostPort(s.Server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "smtp server %q must be host:port", s.Server)
+	}
+
+	tlsConfig := &tls.Config{}
+	if s.TLSConfig != nil {
+		tlsConfig = s.TLSConfig.Clone()
+	}
+	if len(tlsConfig.ServerName) == 0 {
+		tlsConfig.ServerName = host
+	}
+
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to smtp server %s", s.Server)
+	}
+	if err := conn.SetDeadline(s.deadline(ctx)); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to set smtp deadline")
+	}
+	if s.TLS == SMTPImplicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "failed to connect to smtp server %s", s.Server)
+	}
+	c := &smtpConn{conn: conn, client: client}
+
+	if err := s.hello(c, tlsConfig); err != nil {
+		c.close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// hello greets the server, upgrades the connection and authenticates
+func (s SMTPMailer) hello(c *smtpConn, tlsConfig *tls.Config) error {
+	if len(s.LocalName) != 0 {
+		if err := c.client.Hello(s.LocalName); err != nil {
+			return errors.Wrap(err, "smtp EHLO failed")
+		}
+	}
+
+	if s.TLS == SMTPTLSOpportunistic || s.TLS == SMTPStartTLS {
+		if ok, _ := c.client.Extension("STARTTLS"); ok {
+			if err := c.client.StartTLS(tlsConfig); err != nil {
+				return errors.Wrap(err, "smtp STARTTLS failed")
+			}
+		} else if s.TLS == SMTPStartTLS {
+			return errors.Errorf("smtp server %s does not support STARTTLS", s.Server)
+		}
+	}
+
+	if s.Auth != nil {
+		if ok, _ := c.client.Extension("AUTH"); !ok {
+			return errors.Errorf("smtp server %s does not support AUTH", s.Server)
+		}
+		if err := c.client.Auth(s.Auth); err != nil {
+			return errors.Wrap(err, "smtp AUTH failed")
+		}
+	}
+
+	return nil
+}
+
+// deadline is the sooner of Timeout from now and the ctx deadline
+func (s SMTPMailer) deadline(ctx context.Context) time.Time {
+	var deadline time.Time
+	if s.Timeout != 0 {
+		deadline = time.Now().Add(s.Timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return deadline
+}
+
+// NewXOAuth2Auth authenticates with an oauth2 access token using the
+// XOAUTH2 mechanism that Gmail and Microsoft 365 support instead of
+// passwords. A token is taken from tokens for each new connection so it's
+// refreshed when it expires, use something like:
+//
+//   conf.TokenSource(ctx, refreshToken)
+func NewXOAuth2Auth(username string, tokens oauth2.TokenSource) smtp.Auth {
+	return xoauth2Auth{username: username, tokens: tokens}
+}
+
+type xoauth2Auth struct {
+	username string
+	tokens   oauth2.TokenSource
+}
+
+func (x xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like smtp.PlainAuth, don't send the token in the clear
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	token, err := x.tokens.Token()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get oauth2 token for smtp")
+	}
+
+	resp := "user=" + x.username + "\x01auth=Bearer " + token.AccessToken + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+func (x xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server sends its error as a challenge, an empty response
+		// gets the failure reply
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
 }
 
 // boundary makes mime boundaries, these are largely useless strings that just
@@ -2,14 +2,20 @@ package defaults
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/volatiletech/authboss/v3"
+	"golang.org/x/oauth2"
 )
 
 var (
@@ -90,3 +96,175 @@ func TestSMTPMailerPanic(t *testing.T) {
 		t.Error("Should have panicked.")
 	}
 }
+
+// smtpServer is a fake smtp server that records what it's sent
+type smtpServer struct {
+	listener net.Listener
+
+	mut      sync.Mutex
+	conns    int
+	auth     []string
+	messages []string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: l}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mut.Lock()
+			s.conns++
+			s.mut.Unlock()
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	_ = text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			_ = text.PrintfLine("250-localhost")
+			_ = text.PrintfLine("250 AUTH XOAUTH2")
+		case "AUTH":
+			parts := strings.Fields(line)
+			b, _ := base64.StdEncoding.DecodeString(parts[2])
+			s.mut.Lock()
+			s.auth = append(s.auth, parts[1]+" "+string(b))
+			s.mut.Unlock()
+			_ = text.PrintfLine("235 accepted")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			b, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mut.Lock()
+			s.messages = append(s.messages, string(b))
+			s.mut.Unlock()
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpServer) counts() (int, int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.conns, len(s.messages)
+}
+
+func TestSMTPMailerPool(t *testing.T) {
+	t.Parallel()
+
+	server := newSMTPServer(t)
+	mailer := NewSMTPMailer(server.listener.Addr().String(), nil)
+
+	mail := authboss.Email{From: "a@a.com", To: []string{"b@b.com"}, Subject: "Hello", TextBody: "hi"}
+	for i := 0; i < 3; i++ {
+		if err := mailer.Send(context.Background(), mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conns, messages := server.counts()
+	if conns != 1 {
+		t.Error("the connection should have been reused:", conns)
+	}
+	if messages != 3 {
+		t.Error("wrong number of messages:", messages)
+	}
+	server.mut.Lock()
+	if !strings.Contains(server.messages[0], "Subject: Hello") {
+		t.Error("message was wrong:", server.messages[0])
+	}
+	server.mut.Unlock()
+
+	if err := mailer.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	mailer.PoolSize = 0
+	mailer.pool = &smtpPool{}
+	for i := 0; i < 2; i++ {
+		if err := mailer.Send(context.Background(), mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns, _ := server.counts(); conns != 3 {
+		t.Error("without a pool each e-mail should connect:", conns)
+	}
+}
+
+func TestSMTPMailerStartTLSRequired(t *testing.T) {
+	t.Parallel()
+
+	server := newSMTPServer(t)
+	mailer := NewSMTPMailer(server.listener.Addr().String(), nil)
+	mailer.TLS = SMTPStartTLS
+
+	err := mailer.Send(context.Background(), authboss.Email{From: "a@a.com", To: []string{"b@b.com"}, TextBody: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "does not support STARTTLS") {
+		t.Error("it should refuse to send without STARTTLS:", err)
+	}
+	if _, messages := server.counts(); messages != 0 {
+		t.Error("nothing should have been sent")
+	}
+}
+
+func TestSMTPMailerXOAuth2(t *testing.T) {
+	t.Parallel()
+
+	server := newSMTPServer(t)
+	tokens := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	mailer := NewSMTPMailer(server.listener.Addr().String(), NewXOAuth2Auth("a@a.com", tokens))
+
+	if err := mailer.Send(context.Background(), authboss.Email{From: "a@a.com", To: []string{"b@b.com"}, TextBody: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mut.Lock()
+	defer server.mut.Unlock()
+	if len(server.auth) != 1 || server.auth[0] != "XOAUTH2 user=a@a.com\x01auth=Bearer token\x01\x01" {
+		t.Errorf("auth was wrong: %q", server.auth)
+	}
+}
+
+func TestXOAuth2AuthUnencrypted(t *testing.T) {
+	t.Parallel()
+
+	auth := NewXOAuth2Auth("a@a.com", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.gmail.com"}); err == nil {
+		t.Error("it should not send the token without TLS")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.gmail.com", TLS: true}); err != nil {
+		t.Error(err)
+	}
+}
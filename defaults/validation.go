@@ -1,7 +1,9 @@
 package defaults
 
 import (
+	"context"
 	"fmt"
+	"mime/multipart"
 
 	"github.com/volatiletech/authboss/v3"
 )
@@ -9,14 +11,36 @@ import (
 // HTTPFormValidator validates HTTP post type inputs
 type HTTPFormValidator struct {
 	Values map[string]string
+	// Files uploaded with a multipart/form-data form
+	Files map[string][]*multipart.FileHeader
 
 	Ruleset       []Rules
 	ConfirmFields []string
+	// FormRules check the fields against each other, see FormRule
+	FormRules []FormRule
+	// AsyncRules are run concurrently with Context after the other rules
+	AsyncRules []AsyncRule
+	// Context of the request the form is from, for the AsyncRules
+	Context context.Context
+	// ReadErrors are validation errors found while the form was read, like
+	// the FieldErrors of HTTPBodyReader.Files
+	ReadErrors []error
+}
+
+// GetFiles uploaded with the form
+func (h HTTPFormValidator) GetFiles() map[string][]*multipart.FileHeader {
+	return h.Files
+}
+
+// GetChallengeSolution from the form values
+func (h HTTPFormValidator) GetChallengeSolution() string {
+	return h.Values[FormValueChallenge]
 }
 
 // Validate validates a request using the given ruleset.
 func (h HTTPFormValidator) Validate() []error {
 	var errList authboss.ErrorList
+	errList = append(errList, h.ReadErrors...)
 
 	for _, rule := range h.Ruleset {
 		field := rule.FieldName
@@ -39,8 +63,22 @@ func (h HTTPFormValidator) Validate() []error {
 
 		confirm := h.Values[h.ConfirmFields[i+1]]
 		if len(confirm) == 0 || main != confirm {
-			errList = append(errList, FieldError{h.ConfirmFields[i+1], fmt.Errorf("Does not match %s", h.ConfirmFields[i])})
+			errList = append(errList, FieldError{h.ConfirmFields[i+1], authboss.NewLocalizedError(authboss.TxtNoMatch, "Field", h.ConfirmFields[i])})
+		}
+	}
+
+	for _, rule := range h.FormRules {
+		errList = append(errList, rule(h.Values)...)
+	}
+
+	if len(h.AsyncRules) != 0 {
+		failed := make(map[string]bool)
+		for _, err := range errList {
+			if fieldErr, ok := err.(authboss.FieldError); ok {
+				failed[fieldErr.Name()] = true
+			}
 		}
+		errList = append(errList, runAsyncRules(h.Context, h.AsyncRules, h.Values, failed)...)
 	}
 
 	return errList
@@ -1,6 +1,9 @@
 package defaults
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
 	"testing"
 
 	"github.com/volatiletech/authboss/v3"
@@ -85,3 +88,115 @@ func TestValidate_Confirm(t *testing.T) {
 		t.Error("Want a panic due to bad confirm fields slice")
 	}
 }
+
+func TestValidate_Validators(t *testing.T) {
+	t.Parallel()
+
+	RegisterValidator("test_even", func(value string) error {
+		if len(value)%2 != 0 {
+			return errors.New("must be even")
+		}
+		return nil
+	})
+
+	validator := HTTPFormValidator{
+		Values:  map[string]string{"code": "abc"},
+		Ruleset: []Rules{{FieldName: "code", Validators: []string{"test_even"}}},
+	}
+
+	mapped := authboss.ErrorList(validator.Validate()).Map()
+	if len(mapped["code"]) != 1 || mapped["code"][0] != "must be even" {
+		t.Error("the registered validator's error was wrong:", mapped)
+	}
+
+	validator.Values["code"] = "abcd"
+	if errs := validator.Validate(); len(errs) != 0 {
+		t.Error("expected no errors:", errs)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("want a panic for a validator that isn't registered")
+		}
+	}()
+	validator.Ruleset[0].Validators = []string{"test_missing"}
+	validator.Validate()
+}
+
+func TestValidate_FormRules(t *testing.T) {
+	t.Parallel()
+
+	validator := HTTPFormValidator{
+		Values: map[string]string{
+			"email":            "a@a.com",
+			"password":         "a@a.com",
+			"confirm_password": "b",
+		},
+		FormRules: []FormRule{
+			FieldsMustDiffer("password", "email"),
+			FieldsMustMatch("password", "confirm_password"),
+		},
+	}
+
+	mapped := authboss.ErrorList(validator.Validate()).Map()
+	if mapped["password"][0] != "Must be different from email" {
+		t.Error("the password should differ from the email:", mapped)
+	}
+	if mapped["confirm_password"][0] != "Does not match password" {
+		t.Error("the confirm field should match:", mapped)
+	}
+
+	validator.Values = map[string]string{
+		"email":            "a@a.com",
+		"password":         "b",
+		"confirm_password": "b",
+	}
+	if errs := validator.Validate(); len(errs) != 0 {
+		t.Error("expected no errors:", errs)
+	}
+}
+
+type testCtxKey struct{}
+
+func TestValidate_AsyncRules(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	taken := func(ctx context.Context, value string) error {
+		atomic.AddInt32(&calls, 1)
+		if ctx.Value(testCtxKey{}) != "request" {
+			t.Error("the rule should get the request's context")
+		}
+		if value == "taken" {
+			return authboss.NewLocalizedError(authboss.TxtRenameTaken)
+		}
+		return nil
+	}
+
+	validator := HTTPFormValidator{
+		Values: map[string]string{
+			"username": "taken",
+			"nickname": "x",
+			"other":    "free",
+		},
+		Ruleset: []Rules{{FieldName: "nickname", MinLength: 2}},
+		AsyncRules: []AsyncRule{
+			{FieldName: "username", Validator: taken},
+			{FieldName: "nickname", Validator: taken},
+			{FieldName: "other", Validator: taken},
+		},
+		Context: context.WithValue(context.Background(), testCtxKey{}, "request"),
+	}
+
+	errs := validator.Validate()
+	mapped := authboss.ErrorList(errs).Map()
+	if len(errs) != 2 || mapped["username"][0] != authboss.TxtRenameTaken.Default {
+		t.Error("the async rule's error was wrong:", errs)
+	}
+	if _, ok := errs[1].(FieldError); !ok {
+		t.Errorf("the error should be a FieldError: %T", errs[1])
+	}
+	if calls != 2 {
+		t.Error("the async rule should not run for fields that failed already:", calls)
+	}
+}
@@ -0,0 +1,122 @@
+package defaults
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// ValidatorFunc checks a field's value, the error it returns is the field's
+// validation error. Like the other rules' errors it should be an
+// authboss.LocalizedError so that it can be translated.
+type ValidatorFunc func(value string) error
+
+var (
+	validatorsMut sync.RWMutex
+	validators    = make(map[string]ValidatorFunc)
+)
+
+// RegisterValidator makes a validator available to Rules.Validators by
+// name, for checks the Rules can't express like a phone number format.
+// It's meant to be called from an init function, registering a name again
+// replaces the validator.
+func RegisterValidator(name string, validator ValidatorFunc) {
+	validatorsMut.Lock()
+	defer validatorsMut.Unlock()
+	validators[name] = validator
+}
+
+func lookupValidator(name string) ValidatorFunc {
+	validatorsMut.RLock()
+	defer validatorsMut.RUnlock()
+
+	validator, ok := validators[name]
+	if !ok {
+		panic(fmt.Sprintf("validator %q is not registered, see RegisterValidator", name))
+	}
+	return validator
+}
+
+// FormRule validates the form as a whole, for rules that depend on more than
+// one field like the password not being the e-mail address. The errors it
+// returns should be FieldErrors so they're shown next to the right field.
+type FormRule func(values map[string]string) []error
+
+// FieldsMustDiffer fails the field when it has the same value as the other
+// field, for example to keep users from using their e-mail address as their
+// password. Nothing is checked while either of them is blank.
+func FieldsMustDiffer(field, other string) FormRule {
+	return func(values map[string]string) []error {
+		value := values[field]
+		if len(value) == 0 || len(values[other]) == 0 || value != values[other] {
+			return nil
+		}
+		return []error{FieldError{field, authboss.NewLocalizedError(authboss.TxtMustDiffer, "Field", other)}}
+	}
+}
+
+// FieldsMustMatch fails the confirm field when it doesn't have the value of
+// the field, it's what HTTPFormValidator.ConfirmFields does for each pair.
+func FieldsMustMatch(field, confirm string) FormRule {
+	return func(values map[string]string) []error {
+		value := values[field]
+		if len(value) == 0 {
+			return nil
+		}
+		if c := values[confirm]; len(c) == 0 || value != c {
+			return []error{FieldError{confirm, authboss.NewLocalizedError(authboss.TxtNoMatch, "Field", field)}}
+		}
+		return nil
+	}
+}
+
+// AsyncValidatorFunc checks a field's value against something slow, like
+// the database to see if a username is available. The error it returns is
+// shown to the user as the field's error, so a failure of the check itself
+// should be logged and either ignored or turned into a message for the user.
+type AsyncValidatorFunc func(ctx context.Context, value string) error
+
+// AsyncRule validates a field with an AsyncValidatorFunc. The async rules
+// of a form are run concurrently with the request's context, after the
+// other rules and only for fields that passed them.
+type AsyncRule struct {
+	FieldName string
+	Validator AsyncValidatorFunc
+}
+
+// runAsyncRules runs the rules concurrently for the fields without errors,
+// the errors are returned in the order of the rules
+func runAsyncRules(ctx context.Context, rules []AsyncRule, values map[string]string, failed map[string]bool) []error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	results := make([]error, len(rules))
+	var wg sync.WaitGroup
+	for i, rule := range rules {
+		if failed[rule.FieldName] {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, rule AsyncRule) {
+			defer wg.Done()
+			results[i] = rule.Validator(ctx, values[rule.FieldName])
+		}(i, rule)
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, err := range results {
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(authboss.FieldError); !ok {
+			err = FieldError{rules[i].FieldName, err}
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
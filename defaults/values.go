@@ -3,6 +3,8 @@ package defaults
 import (
 	"encoding/json"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -22,16 +24,31 @@ const (
 	FormValueCode         = "code"
 	FormValueRecoveryCode = "recovery_code"
 	FormValuePhoneNumber  = "phone_number"
+	FormValueIDToken      = "id_token"
+	FormValueAccessToken  = "access_token"
+	FormValueRefreshToken = "refresh_token"
+	FormValueChallenge    = "challenge"
+	FormValueAnswer       = "answer"
+	FormValueBackupEmail  = "backup_email"
+	FormValueUseBackup    = "use_backup_email"
+	FormValueDeviceName   = "device_name"
+	FormValueInBody       = "rm_in_body"
+	FormValueAccountType  = "account_type"
+	FormValueName         = "name"
+	FormValueNewName      = "new_name"
+	FormValueID           = "id"
 )
 
 // UserValues from the login form
 type UserValues struct {
 	HTTPFormValidator
 
-	PID      string
-	Password string
+	PID         string
+	Password    string
+	AccountType string
 
 	Arbitrary map[string]string
+	Extra     authboss.ExtraFields
 }
 
 // GetPID from the values
@@ -44,17 +61,37 @@ func (u UserValues) GetPassword() string {
 	return u.Password
 }
 
+// GetAccountType from the values
+func (u UserValues) GetAccountType() string {
+	return u.AccountType
+}
+
 // GetValues from the form.
 func (u UserValues) GetValues() map[string]string {
 	return u.Arbitrary
 }
 
+// GetExtraFields from the form
+func (u UserValues) GetExtraFields() authboss.ExtraFields {
+	return u.Extra
+}
+
 // GetShouldRemember checks the form values for
 func (u UserValues) GetShouldRemember() bool {
 	rm, ok := u.Values[authboss.CookieRemember]
 	return ok && rm == "true"
 }
 
+// GetRememberDeviceName from the form values
+func (u UserValues) GetRememberDeviceName() string {
+	return u.Values[FormValueDeviceName]
+}
+
+// GetRememberInBody checks the form values for the body token opt-in
+func (u UserValues) GetRememberInBody() bool {
+	return u.Values[FormValueInBody] == "true"
+}
+
 // ConfirmValues retrieves values on the confirm page.
 type ConfirmValues struct {
 	HTTPFormValidator
@@ -77,6 +114,32 @@ type RecoverStartValues struct {
 // GetPID for recovery
 func (r RecoverStartValues) GetPID() string { return r.PID }
 
+// GetUseBackupEmail checks the form values for the choice of sending the
+// recover e-mail to the backup address
+func (r RecoverStartValues) GetUseBackupEmail() bool {
+	return r.Values[FormValueUseBackup] == "true"
+}
+
+// BackupEmailValues for the backup_email page
+type BackupEmailValues struct {
+	HTTPFormValidator
+
+	BackupEmail string
+}
+
+// GetBackupEmail from the form values
+func (b BackupEmailValues) GetBackupEmail() string { return b.BackupEmail }
+
+// RenameValues for the rename page
+type RenameValues struct {
+	HTTPFormValidator
+
+	NewName string
+}
+
+// GetNewName from the form values
+func (r RenameValues) GetNewName() string { return r.NewName }
+
 // RecoverMiddleValues for recover_middle page
 type RecoverMiddleValues struct {
 	HTTPFormValidator
@@ -93,6 +156,7 @@ type RecoverEndValues struct {
 
 	Token       string
 	NewPassword string
+	Answer      string
 }
 
 // GetToken for recovery
@@ -101,6 +165,19 @@ func (r RecoverEndValues) GetToken() string { return r.Token }
 // GetPassword for recovery
 func (r RecoverEndValues) GetPassword() string { return r.NewPassword }
 
+// GetRecoverAnswer for recovery
+func (r RecoverEndValues) GetRecoverAnswer() string { return r.Answer }
+
+// APIKeyValues for the apikey_create page
+type APIKeyValues struct {
+	HTTPFormValidator
+
+	Name string
+}
+
+// GetName of the api key
+func (a APIKeyValues) GetName() string { return a.Name }
+
 // TwoFA for totp2fa_validate page
 type TwoFA struct {
 	HTTPFormValidator
@@ -133,51 +210,116 @@ func (s SMSTwoFA) GetRecoveryCode() string { return s.RecoveryCode }
 // GetPhoneNumber from authenticator
 func (s SMSTwoFA) GetPhoneNumber() string { return s.PhoneNumber }
 
+// OAuth2NativeValues for the oauth2_native page
+type OAuth2NativeValues struct {
+	HTTPFormValidator
+
+	IDToken     string
+	AccessToken string
+}
+
+// GetIDToken from the provider
+func (o OAuth2NativeValues) GetIDToken() string { return o.IDToken }
+
+// GetAccessToken from the provider
+func (o OAuth2NativeValues) GetAccessToken() string { return o.AccessToken }
+
+// GetShouldRemember checks the form values for
+func (o OAuth2NativeValues) GetShouldRemember() bool {
+	rm, ok := o.Values[authboss.CookieRemember]
+	return ok && rm == "true"
+}
+
+// GetRememberInBody checks the form values for the body token opt-in
+func (o OAuth2NativeValues) GetRememberInBody() bool {
+	return o.Values[FormValueInBody] == "true"
+}
+
 // HTTPBodyReader reads forms from various pages and decodes
 // them.
 type HTTPBodyReader struct {
 	// ReadJSON if turned on reads json from the http request
 	// instead of a encoded form.
 	ReadJSON bool
+	// Negotiate reads json from requests with a json Content-Type and
+	// forms from the others, whatever ReadJSON is. It's used with
+	// Responder.Negotiate to serve pages and a JSON front-end together.
+	Negotiate bool
 
 	// UseUsername instead of e-mail address
 	UseUsername bool
+	// PIDField is the name of the field the PID is read from, it takes
+	// precedence over UseUsername when set.
+	PIDField string
 
 	// Rulesets for each page.
 	Rulesets map[string][]Rules
 	// Confirm fields for each page.
 	Confirms map[string][]string
+	// FormRules for each page, to check fields against each other like
+	// FieldsMustDiffer(FormValuePassword, FormValueEmail) on the register
+	// page.
+	FormRules map[string][]FormRule
+	// AsyncRules for each page, for checks that are slow like whether the
+	// username is available. They're run with the request's context.
+	AsyncRules map[string][]AsyncRule
 	// Whitelist values for each page through the html forms
 	// this is for security so that we can properly protect the
 	// arbitrary user API. In reality this really only needs to be set
 	// for the register page since everything else is expecting
 	// a hardcoded set of values.
 	Whitelist map[string][]string
+	// ExtraFields are read from the register page's form, parsed and
+	// validated, see authboss.ParseExtraFields. Unlike the Whitelist their
+	// values are typed and only the declared fields are let through.
+	ExtraFields []authboss.ExtraField
+
+	// Files if set is given the files uploaded with multipart/form-data
+	// forms, like an avatar on the register page. See FileReader.
+	Files FileReader
+	// MaxMultipartMemory is how much of a multipart/form-data body is kept
+	// in memory, the rest of its files are stored in temporary files. It's
+	// 32 MB if it's 0.
+	MaxMultipartMemory int64
 }
 
+// FileReader is given the files uploaded with a multipart/form-data form
+// on the page, for example to store them. The values it returns are added
+// to the form's values, so a url of the stored file can be whitelisted on
+// the register page to end up in ArbitraryUser.PutArbitrary. Errors that
+// are authboss.FieldErrors fail the form's validation, others fail the
+// request. The files are also available from the values' GetFiles.
+type FileReader func(page string, r *http.Request, files map[string][]*multipart.FileHeader) (map[string]string, error)
+
 // NewHTTPBodyReader creates a form reader with default validation rules
 // and fields for each page. If no defaults are required, simply construct
 // this using the struct members itself for more control.
 func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
-	var pid string
 	var pidRules Rules
 
 	if useUsernameNotEmail {
-		pid = "username"
 		pidRules = Rules{
-			FieldName: pid, Required: true,
+			FieldName: FormValueUsername, Required: true,
 			MatchError: "Usernames must only start with letters, and contain letters and numbers",
 			MustMatch:  regexp.MustCompile(`(?i)[a-z][a-z0-9]?`),
 		}
 	} else {
-		pid = "email"
 		pidRules = Rules{
-			FieldName: pid, Required: true,
+			FieldName: FormValueEmail, Required: true,
 			MatchError: "Must be a valid e-mail address",
 			MustMatch:  regexp.MustCompile(`.*@.*\.[a-z]+`),
 		}
 	}
 
+	h := NewHTTPBodyReaderPID(readJSON, pidRules)
+	h.UseUsername = useUsernameNotEmail
+	return h
+}
+
+// NewHTTPBodyReaderPID creates a form reader like NewHTTPBodyReader that
+// reads the PID from the field named by pidRules.FieldName (for example
+// employee_id or phone) and validates it with pidRules.
+func NewHTTPBodyReaderPID(readJSON bool, pidRules Rules) *HTTPBodyReader {
 	passwordRule := Rules{
 		FieldName:  "password",
 		MinLength:  8,
@@ -188,8 +330,8 @@ func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
 	}
 
 	return &HTTPBodyReader{
-		UseUsername: useUsernameNotEmail,
-		ReadJSON:    readJSON,
+		ReadJSON: readJSON,
+		PIDField: pidRules.FieldName,
 		Rulesets: map[string][]Rules{
 			"login":         {pidRules},
 			"register":      {pidRules, passwordRule},
@@ -197,23 +339,66 @@ func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
 			"recover_start": {pidRules},
 			"recover_end":   {passwordRule},
 
+			"confirm_resend": {pidRules},
+			"otppassword":    {passwordRule},
+
 			"twofactor_verify_end": {Rules{FieldName: FormValueToken, Required: true}},
+
+			"token_refresh": {Rules{FieldName: FormValueRefreshToken, Required: true}},
+			"token_revoke":  {Rules{FieldName: FormValueToken, Required: true}},
+
+			"apikey_create": {Rules{FieldName: FormValueName, Required: true, MaxLength: 100}},
+			"apikey_revoke": {Rules{FieldName: FormValueID, Required: true}},
 		},
 		Confirms: map[string][]string{
 			"register":    {FormValuePassword, authboss.ConfirmPrefix + FormValuePassword},
 			"recover_end": {FormValuePassword, authboss.ConfirmPrefix + FormValuePassword},
+			"otppassword": {FormValuePassword, authboss.ConfirmPrefix + FormValuePassword},
 		},
 		Whitelist: map[string][]string{
-			"register": {FormValueEmail, FormValuePassword},
+			"register": registerWhitelist(pidRules.FieldName),
 		},
 	}
 }
 
+// registerWhitelist keeps the e-mail for confirm and recover when the pid
+// is read from another field
+func registerWhitelist(pidField string) []string {
+	if pidField == FormValueEmail {
+		return []string{FormValueEmail, FormValuePassword}
+	}
+	return []string{FormValueEmail, pidField, FormValuePassword}
+}
+
 // Read the form pages
 func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator, error) {
 	var values map[string]string
+	var files map[string][]*multipart.FileHeader
+	var readErrs []error
 
-	if h.ReadJSON {
+	if isMultipart(r) {
+		maxMemory := h.MaxMultipartMemory
+		if maxMemory == 0 {
+			maxMemory = 32 << 20
+		}
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse multipart form on page: %s", page)
+		}
+		values = URLValuesToMap(r.Form)
+		files = r.MultipartForm.File
+
+		if h.Files != nil && len(files) != 0 {
+			fileValues, err := h.Files(page, r, files)
+			if fieldErr, ok := err.(authboss.FieldError); ok {
+				readErrs = append(readErrs, fieldErr)
+			} else if err != nil {
+				return nil, errors.Wrapf(err, "failed to read files on page: %s", page)
+			}
+			for k, v := range fileValues {
+				values[k] = v
+			}
+		}
+	} else if h.Negotiate && isAPIRequest(r) || !h.Negotiate && h.ReadJSON {
 		b, err := ioutil.ReadAll(r.Body)
 		r.Body.Close()
 		if err != nil {
@@ -233,6 +418,11 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 	rules := h.Rulesets[page]
 	confirms := h.Confirms[page]
 	whitelist := h.Whitelist[page]
+	form := HTTPFormValidator{
+		Values: values, Ruleset: rules, ConfirmFields: confirms,
+		FormRules: h.FormRules[page], AsyncRules: h.AsyncRules[page], Context: r.Context(),
+		Files: files, ReadErrors: readErrs,
+	}
 
 	switch page {
 	case "confirm":
@@ -240,62 +430,99 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules},
 			Token:             values[FormValueConfirm],
 		}, nil
-	case "login":
-		var pid string
-		if h.UseUsername {
-			pid = values[FormValueUsername]
-		} else {
-			pid = values[FormValueEmail]
-		}
-
+	case "login", "export":
+		// export reuses UserValues, it only needs the password
 		return UserValues{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
-			PID:               pid,
+			HTTPFormValidator: form,
+			PID:               values[h.pidField()],
 			Password:          values[FormValuePassword],
 		}, nil
-	case "recover_start":
-		var pid string
-		if h.UseUsername {
-			pid = values[FormValueUsername]
-		} else {
-			pid = values[FormValueEmail]
-		}
-
+	case "recover_start", "confirm_resend":
+		// confirm_resend reuses RecoverStartValues, it only needs the pid
 		return RecoverStartValues{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
-			PID:               pid,
+			HTTPFormValidator: form,
+			PID:               values[h.pidField()],
 		}, nil
 	case "recover_middle":
 		return RecoverMiddleValues{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			HTTPFormValidator: form,
 			Token:             values[FormValueToken],
 		}, nil
-	case "recover_end":
+	case "recover_end", "otppassword":
+		// otppassword reuses RecoverEndValues, it only needs the password
 		return RecoverEndValues{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			HTTPFormValidator: form,
 			Token:             values[FormValueToken],
 			NewPassword:       values[FormValuePassword],
+			Answer:            values[FormValueAnswer],
 		}, nil
 	case "twofactor_verify_end":
 		// Reuse ConfirmValues here, it's the same values we need
 		return ConfirmValues{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			HTTPFormValidator: form,
+			Token:             values[FormValueToken],
+		}, nil
+	case "token_refresh":
+		// Reuse ConfirmValues here, it's the same values we need
+		return ConfirmValues{
+			HTTPFormValidator: form,
+			Token:             values[FormValueRefreshToken],
+		}, nil
+	case "login_verify":
+		// Reuse ConfirmValues here, the e-mailed code is the token
+		return ConfirmValues{
+			HTTPFormValidator: form,
+			Token:             values[FormValueCode],
+		}, nil
+	case "backup_email":
+		return BackupEmailValues{
+			HTTPFormValidator: form,
+			BackupEmail:       values[FormValueBackupEmail],
+		}, nil
+	case "rename":
+		return RenameValues{
+			HTTPFormValidator: form,
+			NewName:           values[FormValueNewName],
+		}, nil
+	case "token_revoke", "unlock", "register_verify", "backup_email_verify":
+		return ConfirmValues{
+			HTTPFormValidator: form,
 			Token:             values[FormValueToken],
 		}, nil
+	case "apikey_create":
+		return APIKeyValues{
+			HTTPFormValidator: form,
+			Name:              values[FormValueName],
+		}, nil
+	case "apikey_revoke":
+		// Reuse ConfirmValues here, the key's id is the token
+		return ConfirmValues{
+			HTTPFormValidator: form,
+			Token:             values[FormValueID],
+		}, nil
 	case "totp2fa_confirm", "totp2fa_remove", "totp2fa_validate":
 		return TwoFA{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			HTTPFormValidator: form,
 			Code:              values[FormValueCode],
 			RecoveryCode:      values[FormValueRecoveryCode],
 		}, nil
 	case "sms2fa_setup", "sms2fa_remove", "sms2fa_confirm", "sms2fa_validate":
 		return SMSTwoFA{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
+			HTTPFormValidator: form,
 			Code:              values[FormValueCode],
 			PhoneNumber:       values[FormValuePhoneNumber],
 			RecoveryCode:      values[FormValueRecoveryCode],
 		}, nil
+	case "oauth2_native":
+		return OAuth2NativeValues{
+			HTTPFormValidator: form,
+			IDToken:           values[FormValueIDToken],
+			AccessToken:       values[FormValueAccessToken],
+		}, nil
 	case "register":
+		extra, extraErrs := authboss.ParseExtraFields(h.ExtraFields, values)
+		form.ReadErrors = append(form.ReadErrors, extraErrs...)
+
 		arbitrary := make(map[string]string)
 
 		for k, v := range values {
@@ -307,24 +534,35 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			}
 		}
 
-		var pid string
-		if h.UseUsername {
-			pid = values[FormValueUsername]
-		} else {
-			pid = values[FormValueEmail]
-		}
-
 		return UserValues{
-			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
-			PID:               pid,
+			HTTPFormValidator: form,
+			PID:               values[h.pidField()],
 			Password:          values[FormValuePassword],
+			AccountType:       values[FormValueAccountType],
 			Arbitrary:         arbitrary,
+			Extra:             extra,
 		}, nil
 	default:
 		return nil, errors.Errorf("failed to parse unknown page's form: %s", page)
 	}
 }
 
+func (h HTTPBodyReader) pidField() string {
+	switch {
+	case len(h.PIDField) != 0:
+		return h.PIDField
+	case h.UseUsername:
+		return FormValueUsername
+	default:
+		return FormValueEmail
+	}
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
 // URLValuesToMap helps create a map from url.Values
 func URLValuesToMap(form url.Values) map[string]string {
 	values := make(map[string]string)
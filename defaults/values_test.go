@@ -1,6 +1,10 @@
 package defaults
 
 import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -29,6 +33,56 @@ func TestHTTPBodyReaderLogin(t *testing.T) {
 	}
 }
 
+func TestHTTPBodyReaderPIDField(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReaderPID(false, Rules{FieldName: "employee_id", Required: true})
+
+	r := mocks.Request("POST", "employee_id", "e1234", "password", "flowers")
+	validator, err := h.Read("login", r)
+	if err != nil {
+		t.Error(err)
+	}
+	if pid := validator.(authboss.UserValuer).GetPID(); pid != "e1234" {
+		t.Error("wrong pid:", pid)
+	}
+
+	r = mocks.Request("POST", "email", "john@john.john", "employee_id", "e1234")
+	validator, err = h.Read("register", r)
+	if err != nil {
+		t.Error(err)
+	}
+	arbitrary := validator.(authboss.ArbitraryValuer).GetValues()
+	if len(arbitrary) != 2 || arbitrary["employee_id"] != "e1234" || arbitrary["email"] != "john@john.john" {
+		t.Error("the pid field and the e-mail should be whitelisted:", arbitrary)
+	}
+
+	r = mocks.Request("POST", "email", "john@john.john")
+	validator, err = h.Read("recover_start", r)
+	if err != nil {
+		t.Error(err)
+	}
+	if errs := validator.Validate(); len(errs) != 1 {
+		t.Error("the pid field should be required:", errs)
+	}
+}
+
+func TestHTTPBodyReaderUsernameRegister(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, true)
+
+	r := mocks.Request("POST", "username", "john", "email", "john@john.john")
+	validator, err := h.Read("register", r)
+	if err != nil {
+		t.Error(err)
+	}
+	arbitrary := validator.(authboss.ArbitraryValuer).GetValues()
+	if arbitrary["username"] != "john" || arbitrary["email"] != "john@john.john" {
+		t.Error("the username and e-mail should be whitelisted:", arbitrary)
+	}
+}
+
 func TestHTTPBodyReaderJSON(t *testing.T) {
 	t.Parallel()
 
@@ -49,6 +103,33 @@ func TestHTTPBodyReaderJSON(t *testing.T) {
 	}
 }
 
+func TestHTTPBodyReaderNegotiate(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	h.Negotiate = true
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"email":"john@john.john","password":"flowers"}`))
+	r.Header.Set("Content-Type", "application/json")
+	validator, err := h.Read("login", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uv := validator.(authboss.UserValuer); uv.GetPID() != "john@john.john" {
+		t.Error("json should be read from json requests:", uv.GetPID())
+	}
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader(`email=john@john.john&password=flowers`))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	validator, err = h.Read("login", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uv := validator.(authboss.UserValuer); uv.GetPID() != "john@john.john" {
+		t.Error("forms should be read from the other requests:", uv.GetPID())
+	}
+}
+
 func TestHTTPBodyReaderConfirm(t *testing.T) {
 	t.Parallel()
 
@@ -66,6 +147,29 @@ func TestHTTPBodyReaderConfirm(t *testing.T) {
 	}
 }
 
+func TestHTTPBodyReaderOAuth2Native(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	r := mocks.Request("POST", FormValueIDToken, "id", FormValueAccessToken, "access", "rm", "true")
+
+	validator, err := h.Read("oauth2_native", r)
+	if err != nil {
+		t.Error(err)
+	}
+
+	nv := validator.(authboss.OAuth2NativeValuer)
+	if "id" != nv.GetIDToken() {
+		t.Error("id token was wrong:", nv.GetIDToken())
+	}
+	if "access" != nv.GetAccessToken() {
+		t.Error("access token was wrong:", nv.GetAccessToken())
+	}
+	if rm := validator.(authboss.RememberValuer); !rm.GetShouldRemember() {
+		t.Error("it should have wanted to be remembered")
+	}
+}
+
 func TestHTTPBodyReaderRecoverStart(t *testing.T) {
 	t.Parallel()
 
@@ -83,6 +187,45 @@ func TestHTTPBodyReaderRecoverStart(t *testing.T) {
 	}
 }
 
+func TestHTTPBodyReaderBackupEmail(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	r := mocks.Request("POST", FormValueBackupEmail, "backup@test.com")
+
+	validator, err := h.Read("backup_email", r)
+	if err != nil {
+		t.Error(err)
+	}
+	if email := authboss.MustHaveBackupEmailValues(validator).GetBackupEmail(); email != "backup@test.com" {
+		t.Error("backup e-mail was wrong:", email)
+	}
+
+	r = mocks.Request("POST", FormValueEmail, "email", FormValueUseBackup, "true")
+	validator, err = h.Read("recover_start", r)
+	if err != nil {
+		t.Error(err)
+	}
+	if !validator.(authboss.BackupEmailChooser).GetUseBackupEmail() {
+		t.Error("the backup address should have been chosen")
+	}
+}
+
+func TestHTTPBodyReaderRename(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	r := mocks.Request("POST", FormValueNewName, "newname")
+
+	validator, err := h.Read("rename", r)
+	if err != nil {
+		t.Error(err)
+	}
+	if name := authboss.MustHaveRenameValues(validator).GetNewName(); name != "newname" {
+		t.Error("new name was wrong:", name)
+	}
+}
+
 func TestHTTPBodyReaderRecoverMiddle(t *testing.T) {
 	t.Parallel()
 
@@ -120,6 +263,89 @@ func TestHTTPBodyReaderRecoverEnd(t *testing.T) {
 	}
 }
 
+// multipartRequest posts the values and an avatar.png file as
+// multipart/form-data
+func multipartRequest(t *testing.T, values map[string]string) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for k, v := range values {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fw, err := mw.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestHTTPBodyReaderMultipart(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	h.Whitelist["register"] = append(h.Whitelist["register"], "avatar_url")
+	h.Files = func(page string, r *http.Request, files map[string][]*multipart.FileHeader) (map[string]string, error) {
+		if page != "register" || len(files["avatar"]) != 1 || files["avatar"][0].Filename != "avatar.png" {
+			t.Errorf("files were wrong on %s: %#v", page, files)
+		}
+		return map[string]string{"avatar_url": "https://cdn.test.com/avatar.png"}, nil
+	}
+
+	r := multipartRequest(t, map[string]string{"email": "a@a.com", "password": "1!aA1!aA", "confirm_password": "1!aA1!aA"})
+	validator, err := h.Read("register", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := validator.Validate(); errs != nil {
+		t.Error("the form should be valid:", errs)
+	}
+
+	values := validator.(authboss.ArbitraryValuer).GetValues()
+	if values["email"] != "a@a.com" || values["avatar_url"] != "https://cdn.test.com/avatar.png" {
+		t.Error("values were wrong:", values)
+	}
+	if files := validator.(authboss.FileValuer).GetFiles(); len(files["avatar"]) != 1 {
+		t.Error("the files should be available from the values:", files)
+	}
+}
+
+func TestHTTPBodyReaderMultipartErrors(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	h.Files = func(page string, r *http.Request, files map[string][]*multipart.FileHeader) (map[string]string, error) {
+		return nil, NewFieldError("avatar", errors.New("must be smaller"))
+	}
+
+	validator, err := h.Read("register", multipartRequest(t, map[string]string{"email": "a@a.com", "password": "1!aA1!aA", "confirm_password": "1!aA1!aA"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := validator.Validate()
+	if len(errs) != 1 || errs[0].(authboss.FieldError).Name() != "avatar" {
+		t.Error("the file's error should fail validation:", errs)
+	}
+
+	h.Files = func(page string, r *http.Request, files map[string][]*multipart.FileHeader) (map[string]string, error) {
+		return nil, errors.New("storage is down")
+	}
+	if _, err := h.Read("register", multipartRequest(t, nil)); err == nil {
+		t.Error("other errors should fail the request")
+	}
+}
+
 func TestHTTPBodyReaderRegister(t *testing.T) {
 	t.Parallel()
 
@@ -146,3 +372,37 @@ func TestHTTPBodyReaderRegister(t *testing.T) {
 		t.Error("address was wrong:", address)
 	}
 }
+
+func TestHTTPBodyReaderRegisterExtraFields(t *testing.T) {
+	t.Parallel()
+
+	h := NewHTTPBodyReader(false, false)
+	h.ExtraFields = []authboss.ExtraField{
+		{Name: "age", Type: authboss.ExtraFieldInt, Required: true},
+		{Name: "newsletter", Type: authboss.ExtraFieldBool},
+	}
+	r := mocks.Request("POST", "email", "a@a.com", "password", "Passw0rd!", "confirm_password", "Passw0rd!",
+		"age", "30", "newsletter", "on", "admin", "true")
+
+	validator, err := h.Read("register", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := validator.Validate(); len(errs) != 0 {
+		t.Error("expected no errors:", errs)
+	}
+
+	extra := validator.(authboss.ExtraFieldsValuer).GetExtraFields()
+	if len(extra) != 2 || extra.Int("age") != 30 || !extra.Bool("newsletter") {
+		t.Errorf("extra fields were wrong: %#v", extra)
+	}
+
+	r = mocks.Request("POST", "email", "a@a.com", "password", "Passw0rd!", "confirm_password", "Passw0rd!", "age", "old")
+	validator, err = h.Read("register", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := authboss.ErrorMap(validator.Validate()); len(errs["age"]) != 1 {
+		t.Error("the age should fail validation:", errs)
+	}
+}
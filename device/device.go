@@ -0,0 +1,188 @@
+// Package device recognizes the devices users log in from so that logins
+// from new devices can be detected and, if the app wants, verified.
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	nIDSize = 32
+)
+
+func init() {
+	authboss.RegisterModule("device", &Device{})
+}
+
+// Device module
+type Device struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the storer can keep the known devices
+func (d *Device) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Logger", "Storage.Server")
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.KnownDeviceStorer)(nil))...)
+}
+
+// Init module
+func (d *Device) Init(ab *authboss.Authboss) error {
+	d.Authboss = ab
+
+	d.Events.Before(authboss.EventAuth, d.BeforeAuth)
+	d.Events.Before(authboss.EventOAuth2, d.BeforeAuth)
+	d.Events.After(authboss.EventAuth, d.AfterAuth)
+	d.Events.After(authboss.EventOAuth2, d.AfterAuth)
+
+	return nil
+}
+
+// BeforeAuth marks the device as new if the user hasn't logged in from it
+// before and fires EventNewDevice, whose handlers can stop the login.
+func (d *Device) BeforeAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	device := authboss.CurrentDevice(r)
+	if device == nil {
+		return false, nil
+	}
+
+	user, err := d.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	storer := authboss.EnsureCanKnowDevices(d.Config.Storage.Server)
+	devices, err := storer.LoadKnownDevices(r.Context(), user.GetPID())
+	if err != nil {
+		return false, errors.Wrap(err, "failed to load known devices")
+	}
+
+	if match(devices, device) >= 0 {
+		return false, nil
+	}
+
+	device.New = true
+	d.RequestLogger(r).Infof("user %s is logging in from a new device", user.GetPID())
+
+	return d.Events.FireBefore(authboss.EventNewDevice, w, r)
+}
+
+// AfterAuth records the device the user logged in from
+func (d *Device) AfterAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if authboss.CurrentDevice(r) == nil {
+		return false, nil
+	}
+
+	user, err := d.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	return false, Trust(d.Authboss, r, user.GetPID())
+}
+
+// Trust records the device the request is made from as one the user has
+// logged in from. Apps that verify new devices with an EventNewDevice
+// handler should call it once the device is verified so that the login
+// isn't stopped again.
+//
+// A device is recognized by the id in its cookie or, if the cookie was
+// cleared, by having the same user agent and ip address.
+func Trust(ab *authboss.Authboss, r *http.Request, pid string) error {
+	device := authboss.CurrentDevice(r)
+	if device == nil {
+		return errors.New("no device in the request, device.Middleware must be used")
+	}
+
+	storer := authboss.EnsureCanKnowDevices(ab.Config.Storage.Server)
+	devices, err := storer.LoadKnownDevices(r.Context(), pid)
+	if err != nil {
+		return errors.Wrap(err, "failed to load known devices")
+	}
+
+	now := ab.Now().UTC()
+	known := authboss.KnownDevice{FirstSeen: now}
+	if i := match(devices, device); i >= 0 {
+		known = devices[i]
+		if known.ID != hash(device.ID) {
+			// The cookie was cleared, move the device to the new id
+			if err = storer.DelKnownDevice(r.Context(), pid, known.ID); err != nil {
+				return errors.Wrap(err, "failed to delete known device")
+			}
+		}
+	}
+
+	known.ID = hash(device.ID)
+	known.UserAgentHash = hash(device.UserAgent)
+	known.IPHash = hash(device.IP)
+	known.LastSeen = now
+
+	return errors.Wrap(storer.PutKnownDevice(r.Context(), pid, known), "failed to save known device")
+}
+
+// Middleware identifies the device a request is made from and puts it in
+// the context, see authboss.CurrentDevice. Devices without an id are given
+// one in the authboss.CookieDevice cookie. It must be after the
+// LoadClientStateMiddleware and in front of the authboss routes.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := authboss.GetCookie(r, authboss.CookieDevice)
+			if !ok || len(id) == 0 {
+				var err error
+				if id, err = generateID(); err != nil {
+					logger := ab.RequestLogger(r)
+					logger.Errorf("failed to create device id: %+v", err)
+					next.ServeHTTP(w, r)
+					return
+				}
+				authboss.PutCookie(w, authboss.CookieDevice, id)
+			}
+
+			device := &authboss.Device{ID: id, UserAgent: r.UserAgent(), IP: ab.ClientIP(r)}
+			r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyDevice, device))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// match finds the device by id first, then by user agent and ip address.
+// It returns -1 if the device isn't known.
+func match(devices []authboss.KnownDevice, device *authboss.Device) int {
+	id := hash(device.ID)
+	for i, d := range devices {
+		if d.ID == id {
+			return i
+		}
+	}
+
+	userAgent, ip := hash(device.UserAgent), hash(device.IP)
+	for i, d := range devices {
+		if d.UserAgentHash == userAgent && d.IPHash == ip {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func hash(value string) string {
+	sum := sha512.Sum512([]byte(value))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func generateID() (string, error) {
+	id := make([]byte, nIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
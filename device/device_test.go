@@ -0,0 +1,158 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	d := &Device{}
+	if err := d.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testHarness struct {
+	device *Device
+	ab     *authboss.Authboss
+
+	cookies *mocks.ClientStateRW
+	storer  *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.cookies = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Storage.CookieState = harness.cookies
+	harness.ab.Config.Storage.SessionState = mocks.NewClientRW()
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.device = &Device{harness.ab}
+
+	return harness
+}
+
+// request goes through the middleware and returns the request it passed on
+func (h *testHarness) request(t *testing.T, user authboss.User) (http.ResponseWriter, *http.Request) {
+	t.Helper()
+
+	r := mocks.Request("POST")
+	r.Header.Set("User-Agent", "agent")
+	r.RemoteAddr = "1.2.3.4:5678"
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	var err error
+	if r, err = h.ab.LoadClientState(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var passed *http.Request
+	Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = r
+	})).ServeHTTP(w, r)
+	w.WriteHeader(http.StatusOK)
+
+	return w, passed
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	_, r := h.request(t, nil)
+	device := authboss.CurrentDevice(r)
+	if device == nil {
+		t.Fatal("the device should be in the context")
+	}
+	if device.ID != h.cookies.ClientValues[authboss.CookieDevice] || len(device.ID) == 0 {
+		t.Error("the device should have been given an id:", device.ID)
+	}
+	if device.UserAgent != "agent" || device.IP != "1.2.3.4" {
+		t.Errorf("device was wrong: %#v", device)
+	}
+
+	_, r = h.request(t, nil)
+	if again := authboss.CurrentDevice(r); again.ID != device.ID {
+		t.Error("the id should have been kept:", again.ID)
+	}
+}
+
+func TestBeforeAuthNewDevice(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	fired := 0
+	h.ab.Events.Before(authboss.EventNewDevice, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		fired++
+		return true, nil
+	})
+
+	user := &mocks.User{Email: "test@test.com"}
+	w, r := h.request(t, user)
+
+	if handled, err := h.device.BeforeAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	} else if !handled {
+		t.Error("the EventNewDevice handler should stop the login")
+	}
+	if !authboss.CurrentDevice(r).New || fired != 1 {
+		t.Error("the device should be new and the event fired")
+	}
+
+	if err := Trust(h.ab, r, user.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	w, r = h.request(t, user)
+	if handled, err := h.device.BeforeAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	} else if handled || authboss.CurrentDevice(r).New || fired != 1 {
+		t.Error("a trusted device should not be new")
+	}
+}
+
+func TestAfterAuthClearedCookie(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	w, r := h.request(t, user)
+	if _, err := h.device.AfterAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(h.cookies.ClientValues, authboss.CookieDevice)
+
+	w, r = h.request(t, user)
+	if _, err := h.device.BeforeAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+	if authboss.CurrentDevice(r).New {
+		t.Error("the device should be recognized by its user agent and ip")
+	}
+	if _, err := h.device.AfterAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := h.storer.Devices[user.Email]
+	if len(devices) != 1 || devices[0].ID != hash(authboss.CurrentDevice(r).ID) {
+		t.Errorf("the device should have moved to the new id: %#v", devices)
+	}
+}
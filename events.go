@@ -1,7 +1,13 @@
 package authboss
 
 import (
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/friendsofgo/errors"
 )
 
 //go:generate stringer -output stringers.go -type "Event"
@@ -27,11 +33,150 @@ const (
 	EventRecoverEnd
 	EventGetUser
 	EventGetUserSession
-	// Deprecated: EventPasswordReset is used nowhere
+	// EventPasswordReset is fired after the recover module has reset a
+	// user's password and logged them out everywhere (see
+	// Authboss.RevokeSessions), the user is in the context.
 	EventPasswordReset
 	EventLogout
+	EventOAuth2Link
+	EventOAuth2Unlink
+	// EventTokenReuse is fired when a token that is rotated on use (like a
+	// remember me or refresh token) is presented after it has already been
+	// used. That means the token was stolen: the token's family and the
+	// user's sessions are revoked (see Authboss.RevokeSessions) before
+	// it's fired, handlers should treat it as a critical security event
+	// (eg. alert the user).
+	EventTokenReuse
+	// EventExpireIdle is fired by the expire middleware when it logs out a
+	// user that has been idle for longer than Modules.ExpireAfter.
+	EventExpireIdle
+	// EventExpireLifetime is fired by the expire middleware when it logs
+	// out a user that logged in longer than Modules.ExpireMaxAge ago.
+	EventExpireLifetime
+	// EventLock is fired after the lock module locks a user for too many
+	// failed logins, the user is in the context.
+	EventLock
+	// EventUnlock is fired after a user unlocks their account with the
+	// link from the unlock e-mail, the user is in the context.
+	EventUnlock
+	// EventTwoFactorAdd is fired after a user enables a second factor
+	// (totp or sms).
+	EventTwoFactorAdd
+	// EventTwoFactorRemove is fired after a user disables a second factor
+	// (totp or sms).
+	EventTwoFactorRemove
+	// EventNewDevice is fired by the device module during a login from a
+	// device the user hasn't logged in from before. Before handlers can
+	// require extra verification by responding and returning handled, which
+	// stops the login.
+	EventNewDevice
+	// EventAuthAttempt is fired before the password of a login attempt is
+	// checked, with the values entered in the context (CTXKeyValues).
+	// Before handlers can stop the attempt by responding and returning
+	// handled.
+	EventAuthAttempt
+	// EventPasswordSpray is fired by the spray module when one password is
+	// tried against many accounts, which is a critical security event since
+	// it evades per-account locking.
+	EventPasswordSpray
+	// EventRegisterBot is fired when the register module rejects a
+	// registration as a bot (see Modules.RegisterHoneypot and
+	// Modules.RegisterMinFillTime). Unless a handler responds and returns
+	// handled, the bot is sent the usual registration response without
+	// an account being created.
+	EventRegisterBot
+	// EventMailFailed is fired by defaults.QueueingMailer when an e-mail
+	// fails permanently or runs out of retries. It happens outside of a
+	// request: the request only carries a context with the failure
+	// (defaults.MailFailure) in CTXKeyValues, and the response writer
+	// discards what's written to it.
+	EventMailFailed
+	// EventPasswordChange is fired after a logged in user sets a new
+	// password, like after logging in with an otp, the user is in the
+	// context. Resets by the recover module fire EventPasswordReset instead.
+	EventPasswordChange
+	// EventSessionDestroy is fired after authboss ends the current session
+	// of a user, whatever the reason: logging out, expiring, being evicted
+	// by the session limit or a password reset. It's fired along with the
+	// event for the reason (EventLogout, EventExpireIdle...).
+	EventSessionDestroy
+	// EventImpersonateStart is fired when a user starts impersonating
+	// another (see Authboss.Impersonate), the user being impersonated is in
+	// the context (CTXKeyUser) along with the impersonator
+	// (CTXKeyImpersonator). Before handlers can stop it by responding and
+	// returning handled.
+	EventImpersonateStart
+	// EventImpersonateEnd is fired when the impersonator goes back to
+	// being themselves (see Authboss.StopImpersonating), the context is
+	// like EventImpersonateStart's.
+	EventImpersonateEnd
+	// EventAccountUpgrade is fired by the guest module when a visitor
+	// with a guest session registers or logs in, so the app can move what
+	// it kept for the guest (a cart, preferences) to the user. The user is
+	// in the context (CTXKeyUser) with the guest id (CTXKeyGuestID).
+	EventAccountUpgrade
+	// EventRename is fired after a user changes their username, or their
+	// pid if Modules.RenamePID is set, with the rename module. The user is
+	// in the context (CTXKeyUser) with the name they had before
+	// (CTXKeyRenamedFrom), so the app can update what refers to it.
+	EventRename
+	// EventOAuth2Rejected is fired when the oauth2 callback is rejected
+	// because its state is missing, doesn't match the session's, has
+	// expired or was already used, or because the ID token's nonce doesn't
+	// match. The error is in the context under CTXKeyOAuth2Rejected. Unless
+	// a handler responds and returns handled the error is returned.
+	EventOAuth2Rejected
+	// EventOAuth2RefreshFailed is fired by Authboss.OAuth2Token when a
+	// user's oauth2 access token can't be refreshed because the refresh
+	// token is missing or the provider refused it, usually because the user
+	// revoked the app's access. It's fired outside of a request, the user is
+	// in the context.
+	EventOAuth2RefreshFailed
+	// EventPanic is fired when a module's handler panics, the PanicError
+	// is in the context under CTXKeyPanic. Unless a handler responds and
+	// returns handled the request is responded to with a 500, and the
+	// PanicError is returned to the ErrorHandler either way.
+	EventPanic
+	// EventSuspend is fired by Authboss.Suspend after a user was
+	// suspended, EventUnsuspend by Authboss.Unsuspend after it was lifted.
+	// Like EventMailFailed they're fired outside of a request, the user is
+	// in the context under CTXKeyUser.
+	EventSuspend
+	EventUnsuspend
+	// EventUserChange is fired by Authboss.UserChanged after the app
+	// changed a user's data, with the user in the context (CTXKeyUser) and
+	// the attributes that changed (CTXKeyChangedAttributes).
+	EventUserChange
+	// EventRememberLogin is fired after the remember module logged a user
+	// in with their remember me token, the user's pid is in the context
+	// (CTXKeyPID). Logins through it don't fire EventAuth.
+	EventRememberLogin
+)
+
+// EventTiming is whether a hook runs before or after the module's logic
+type EventTiming int
+
+// Event timings
+const (
+	// EventBefore hooks are called with Events.FireBefore, before the
+	// module acts. They can stop it by handling the request.
+	EventBefore EventTiming = iota
+	// EventAfter hooks are called with Events.FireAfter, once the module
+	// has acted.
+	EventAfter
 )
 
+func (t EventTiming) String() string {
+	switch t {
+	case EventBefore:
+		return "before"
+	case EventAfter:
+		return "after"
+	default:
+		return fmt.Sprintf("EventTiming(%d)", int(t))
+	}
+}
+
 // EventHandler reacts to events that are fired by Authboss controllers.
 // These controllers will normally process a request by themselves, but if
 // there is special consideration for example a successful login, but the
@@ -41,32 +186,145 @@ const (
 // Very much a controller level middleware.
 type EventHandler func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error)
 
+// EventFilter decides whether a handler (or middleware) is called when an
+// event is fired, see Events.Before and Events.Use.
+type EventFilter func(e Event, r *http.Request) bool
+
+// EventMiddleware wraps the calls to event handlers, for things that apply
+// to many handlers like logging, metrics or recovering from panics. See
+// Events.Use.
+type EventMiddleware func(e Event, next EventHandler) EventHandler
+
+// Hook is an event handler with a name and a priority, see Events.Register
+type Hook struct {
+	// Name identifies the hook to Events.Remove, it must be unique among
+	// the hooks of the event and timing. Hooks added with Events.Before and
+	// Events.After have no name.
+	Name string
+	// Priority orders the hooks of an event: higher priorities are called
+	// first, hooks with the same priority in the order they were added.
+	// Events.Before and Events.After add hooks with priority 0.
+	Priority int
+	// When the hook is called
+	When    EventTiming
+	Handler EventHandler
+	// Filters the hook is only called for when they all match
+	Filters []EventFilter
+}
+
+type eventMiddleware struct {
+	mw      EventMiddleware
+	filters []EventFilter
+}
+
+func matchFilters(filters []EventFilter, e Event, r *http.Request) bool {
+	for _, filter := range filters {
+		if !filter(e, r) {
+			return false
+		}
+	}
+	return true
+}
+
 // Events is a collection of Events that fire before and after certain methods.
+// Hooks can be added and removed while events are being fired.
 type Events struct {
-	before map[Event][]EventHandler
-	after  map[Event][]EventHandler
+	mut        sync.RWMutex
+	before     map[Event][]Hook
+	after      map[Event][]Hook
+	middleware []eventMiddleware
+
+	// initFilter is added to the hooks of the module being initialized
+	initFilter EventFilter
 }
 
 // NewEvents creates a new set of before and after Events.
 func NewEvents() *Events {
 	return &Events{
-		before: make(map[Event][]EventHandler),
-		after:  make(map[Event][]EventHandler),
+		before: make(map[Event][]Hook),
+		after:  make(map[Event][]Hook),
+	}
+}
+
+// Before event, call f. If filters are given f is only called when they
+// all match.
+func (c *Events) Before(e Event, f EventHandler, filters ...EventFilter) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.add(e, Hook{When: EventBefore, Handler: f, Filters: filters})
+}
+
+// After event, call f. If filters are given f is only called when they
+// all match.
+func (c *Events) After(e Event, f EventHandler, filters ...EventFilter) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.add(e, Hook{When: EventAfter, Handler: f, Filters: filters})
+}
+
+// Register a hook for an event. It fails if the hook has no handler or if
+// the event already has a hook with the same name and timing.
+func (c *Events) Register(e Event, hook Hook) error {
+	if hook.Handler == nil {
+		return errors.Errorf("hook %q for %s has no handler", hook.Name, e)
+	}
+	if hook.When != EventBefore && hook.When != EventAfter {
+		return errors.Errorf("hook %q for %s has an unknown timing: %s", hook.Name, e, hook.When)
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if len(hook.Name) != 0 {
+		for _, h := range c.hooks(hook.When)[e] {
+			if h.Name == hook.Name {
+				return errors.Errorf("%s already has a %s hook named %q", e, hook.When, hook.Name)
+			}
+		}
+	}
+
+	c.add(e, hook)
+	return nil
+}
+
+// Remove the hook with the name from the event, it returns false if there
+// was no such hook.
+func (c *Events) Remove(e Event, when EventTiming, name string) bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	hooks := c.hooks(when)
+	for i, h := range hooks[e] {
+		if len(name) == 0 || h.Name != name {
+			continue
+		}
+
+		// Copied rather than changed in place, events that are being
+		// fired may still be going through the old list
+		list := make([]Hook, 0, len(hooks[e])-1)
+		list = append(list, hooks[e][:i]...)
+		hooks[e] = append(list, hooks[e][i+1:]...)
+		return true
 	}
+
+	return false
 }
 
-// Before event, call f.
-func (c *Events) Before(e Event, f EventHandler) {
-	events := c.before[e]
-	events = append(events, f)
-	c.before[e] = events
+// Hooks lists the hooks of the event in the order they're called
+func (c *Events) Hooks(e Event, when EventTiming) []Hook {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	return append([]Hook(nil), c.hooks(when)[e]...)
 }
 
-// After event, call f.
-func (c *Events) After(e Event, f EventHandler) {
-	events := c.after[e]
-	events = append(events, f)
-	c.after[e] = events
+// Use wraps the calls to every event handler with mw, or only the calls
+// for which the filters all match. Middleware is called in the order it's
+// added, the first is the outermost.
+func (c *Events) Use(mw EventMiddleware, filters ...EventFilter) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.middleware = append(c.middleware, eventMiddleware{mw: mw, filters: filters})
 }
 
 // FireBefore executes the handlers that were registered to fire before
@@ -79,19 +337,64 @@ func (c *Events) After(e Event, f EventHandler) {
 // to handlers further down the chain (to let them know that w has been used)
 // as well as set w to nil as a precaution.
 func (c *Events) FireBefore(e Event, w http.ResponseWriter, r *http.Request) (bool, error) {
-	return c.call(c.before[e], w, r)
+	return c.call(e, EventBefore, w, r)
 }
 
 // FireAfter event to all the Events with a context. The error can safely be
 // ignored as it is logged.
 func (c *Events) FireAfter(e Event, w http.ResponseWriter, r *http.Request) (bool, error) {
-	return c.call(c.after[e], w, r)
+	return c.call(e, EventAfter, w, r)
+}
+
+func (c *Events) hooks(when EventTiming) map[Event][]Hook {
+	if when == EventBefore {
+		return c.before
+	}
+	return c.after
+}
+
+func (c *Events) setInitFilter(filter EventFilter) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.initFilter = filter
 }
 
-func (c *Events) call(evs []EventHandler, w http.ResponseWriter, r *http.Request) (bool, error) {
+// add the hook after the ones with the same or a higher priority, the list
+// is copied so that events being fired aren't affected
+func (c *Events) add(e Event, hook Hook) {
+	if c.initFilter != nil {
+		hook.Filters = append(append([]EventFilter(nil), hook.Filters...), c.initFilter)
+	}
+
+	hooks := c.hooks(hook.When)
+	i := sort.Search(len(hooks[e]), func(i int) bool {
+		return hooks[e][i].Priority < hook.Priority
+	})
+
+	list := make([]Hook, 0, len(hooks[e])+1)
+	list = append(list, hooks[e][:i]...)
+	list = append(list, hook)
+	hooks[e] = append(list, hooks[e][i:]...)
+}
+
+func (c *Events) call(e Event, when EventTiming, w http.ResponseWriter, r *http.Request) (bool, error) {
+	c.mut.RLock()
+	hooks, middleware := c.hooks(when)[e], c.middleware
+	c.mut.RUnlock()
+
 	handled := false
+	for _, h := range hooks {
+		if !matchFilters(h.Filters, e, r) {
+			continue
+		}
+
+		fn := h.Handler
+		for i := len(middleware) - 1; i >= 0; i-- {
+			if m := middleware[i]; matchFilters(m.filters, e, r) {
+				fn = m.mw(e, fn)
+			}
+		}
 
-	for _, fn := range evs {
 		interrupt, err := fn(w, r, handled)
 		if err != nil {
 			return false, err
@@ -103,3 +406,61 @@ func (c *Events) call(evs []EventHandler, w http.ResponseWriter, r *http.Request
 
 	return handled, nil
 }
+
+// EventPathPrefix matches requests whose path starts with prefix, for
+// example ab.Config.Paths.Mount+"/otp" for events fired by the otp module.
+func EventPathPrefix(prefix string) EventFilter {
+	return func(e Event, r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, prefix)
+	}
+}
+
+// EventContextValue matches requests whose context has value under key,
+// for example the tenant a multi-tenant app puts in the context.
+func EventContextValue(key, value interface{}) EventFilter {
+	return func(e Event, r *http.Request) bool {
+		return r.Context().Value(key) == value
+	}
+}
+
+// EventFailures matches the events that report a failure or an attack:
+// failed logins, locks, reused tokens, password sprays, bots and rejected
+// oauth2 callbacks.
+func EventFailures(e Event, r *http.Request) bool {
+	switch e {
+	case EventAuthFail, EventOAuth2Fail, EventLock, EventSuspend, EventTokenReuse, EventPasswordSpray, EventRegisterBot, EventOAuth2Rejected:
+		return true
+	}
+	return false
+}
+
+// RecoverEventPanics is EventMiddleware that turns panics in event handlers
+// into errors.
+func RecoverEventPanics(e Event, next EventHandler) EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (interrupt bool, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = errors.Errorf("panic in %s handler: %v", e, p)
+			}
+		}()
+
+		return next(w, r, handled)
+	}
+}
+
+// LogEvents is EventMiddleware that logs the event handlers that are
+// called and the errors they return with the request's logger.
+func (a *Authboss) LogEvents(e Event, next EventHandler) EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		logger := a.RequestLogger(r)
+
+		interrupt, err := next(w, r, handled)
+		if err != nil {
+			logger.Errorf("%s handler failed: %+v", e, err)
+		} else {
+			logger.Infof("%s handler called, handled: %t", e, interrupt)
+		}
+
+		return interrupt, err
+	}
+}
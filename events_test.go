@@ -1,7 +1,10 @@
 package authboss
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/friendsofgo/errors"
@@ -140,6 +143,25 @@ func TestEventString(t *testing.T) {
 		{EventGetUser, "EventGetUser"},
 		{EventGetUserSession, "EventGetUserSession"},
 		{EventPasswordReset, "EventPasswordReset"},
+		{EventLogout, "EventLogout"},
+		{EventOAuth2Link, "EventOAuth2Link"},
+		{EventOAuth2Unlink, "EventOAuth2Unlink"},
+		{EventTokenReuse, "EventTokenReuse"},
+		{EventExpireIdle, "EventExpireIdle"},
+		{EventExpireLifetime, "EventExpireLifetime"},
+		{EventMailFailed, "EventMailFailed"},
+		{EventPasswordChange, "EventPasswordChange"},
+		{EventSessionDestroy, "EventSessionDestroy"},
+		{EventImpersonateStart, "EventImpersonateStart"},
+		{EventImpersonateEnd, "EventImpersonateEnd"},
+		{EventAccountUpgrade, "EventAccountUpgrade"},
+		{EventRename, "EventRename"},
+		{EventOAuth2Rejected, "EventOAuth2Rejected"},
+		{EventOAuth2RefreshFailed, "EventOAuth2RefreshFailed"},
+		{EventPanic, "EventPanic"},
+		{EventSuspend, "EventSuspend"},
+		{EventUnsuspend, "EventUnsuspend"},
+		{EventUserChange, "EventUserChange"},
 	}
 
 	for i, test := range tests {
@@ -148,3 +170,163 @@ func TestEventString(t *testing.T) {
 		}
 	}
 }
+
+func TestEventsFilters(t *testing.T) {
+	t.Parallel()
+
+	type tenantKey struct{}
+
+	ab := New()
+	var called []string
+	handler := func(name string) EventHandler {
+		return func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+			called = append(called, name)
+			return false, nil
+		}
+	}
+
+	ab.Events.After(EventAuth, handler("all"))
+	ab.Events.After(EventAuth, handler("otp"), EventPathPrefix("/auth/otp"))
+	ab.Events.After(EventAuth, handler("tenant"), EventContextValue(tenantKey{}, "a"))
+
+	r := httptest.NewRequest("POST", "/auth/otp/login", nil)
+	if _, err := ab.Events.FireAfter(EventAuth, nil, r); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("POST", "/auth/login", nil)
+	r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, "a"))
+	if _, err := ab.Events.FireAfter(EventAuth, nil, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(called, ","); got != "all,otp,all,tenant" {
+		t.Error("the wrong handlers were called:", got)
+	}
+
+	if EventFailures(EventAuth, r) || !EventFailures(EventAuthFail, r) {
+		t.Error("EventFailures matched the wrong events")
+	}
+}
+
+func TestEventsMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	var order []string
+	ab.Events.Use(func(e Event, next EventHandler) EventHandler {
+		return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			order = append(order, "outer")
+			return next(w, r, handled)
+		}
+	})
+	ab.Events.Use(func(e Event, next EventHandler) EventHandler {
+		return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			order = append(order, "failures "+e.String())
+			return next(w, r, handled)
+		}
+	}, EventFailures)
+	ab.Events.Use(RecoverEventPanics)
+
+	ab.Events.After(EventAuthFail, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		order = append(order, "handler")
+		panic("oops")
+	})
+	ab.Events.After(EventAuth, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		order = append(order, "handler")
+		return false, nil
+	})
+
+	r := httptest.NewRequest("POST", "/", nil)
+	if _, err := ab.Events.FireAfter(EventAuthFail, nil, r); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Error("the panic should have been returned as an error:", err)
+	}
+	if _, err := ab.Events.FireAfter(EventAuth, nil, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(order, ","); got != "outer,failures EventAuthFail,handler,outer,handler" {
+		t.Error("middleware was called wrong:", got)
+	}
+}
+
+func TestEventsRegister(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	var order []string
+	hook := func(name string) EventHandler {
+		return func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+			order = append(order, name)
+			return false, nil
+		}
+	}
+
+	ab.Events.After(EventAuth, hook("unnamed"))
+	hooks := []Hook{
+		{Name: "audit", Priority: 10, When: EventAfter, Handler: hook("audit")},
+		{Name: "metrics", Priority: -5, When: EventAfter, Handler: hook("metrics")},
+		{Name: "alert", Priority: 10, When: EventAfter, Handler: hook("alert")},
+		{Name: "audit", When: EventBefore, Handler: hook("audit before")},
+	}
+	for _, h := range hooks {
+		if err := ab.Events.Register(EventAuth, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ab.Events.Register(EventAuth, Hook{Name: "audit", When: EventAfter, Handler: hook("again")}); err == nil {
+		t.Error("names should be unique for the event and timing")
+	}
+	if err := ab.Events.Register(EventAuth, Hook{Name: "nothing"}); err == nil {
+		t.Error("hooks without a handler should be refused")
+	}
+
+	if _, err := ab.Events.FireAfter(EventAuth, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, ","); got != "audit,alert,unnamed,metrics" {
+		t.Error("hooks were called in the wrong order:", got)
+	}
+
+	var names []string
+	for _, h := range ab.Events.Hooks(EventAuth, EventAfter) {
+		names = append(names, h.Name)
+	}
+	if got := strings.Join(names, ","); got != "audit,alert,,metrics" {
+		t.Error("hooks were listed wrong:", got)
+	}
+	if len(ab.Events.Hooks(EventAuth, EventBefore)) != 1 {
+		t.Error("the before hook should be listed on its own")
+	}
+}
+
+func TestEventsRemove(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	called := false
+	err := ab.Events.Register(EventLogout, Hook{Name: "audit", When: EventAfter, Handler: func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		called = true
+		return false, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ab.Events.Remove(EventLogout, EventBefore, "audit") {
+		t.Error("the hook was registered after, not before")
+	}
+	if !ab.Events.Remove(EventLogout, EventAfter, "audit") {
+		t.Error("the hook should have been removed")
+	}
+	if ab.Events.Remove(EventLogout, EventAfter, "audit") {
+		t.Error("the hook should already be gone")
+	}
+
+	if _, err := ab.Events.FireAfter(EventLogout, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("a removed hook should not be called")
+	}
+}
@@ -9,31 +9,52 @@ import (
 	"github.com/volatiletech/authboss/v3"
 )
 
-var nowTime = time.Now
+// DataExpiresIn is the number of seconds left before the session expires,
+// it's only put in the data once the session is within
+// Modules.ExpireWarnBefore of expiring so that views can warn the user.
+const DataExpiresIn = "session_expires_in"
 
 // Setup the expire module
 //
-// This installs a hook into the login process so that the
-// LastAction is recorded immediately.
+// This installs hooks into the login processes (auth, oauth2 and saml,
+// remember me, and the logins after registering or recovering) so that the
+// LastAction and LoginTime are recorded immediately. The certauth module
+// records the LoginTime itself.
 func Setup(ab *authboss.Authboss) error {
-	ab.Events.After(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
-		refreshExpiry(w)
+	recordLogin := func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		now := ab.Now()
+		RefreshExpiry(w, now)
+		authboss.PutSession(w, authboss.SessionLoginTime, now.UTC().Format(time.RFC3339))
 		return false, nil
-	})
+	}
+
+	for _, event := range []authboss.Event{authboss.EventAuth, authboss.EventOAuth2, authboss.EventRememberLogin, authboss.EventRegister, authboss.EventRecoverEnd} {
+		ab.Events.After(event, recordLogin)
+	}
 
 	return nil
 }
 
-// TimeToExpiry returns zero if the user session is expired else the time
-// until expiry. Takes in the allowed idle duration.
-func TimeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
-	return timeToExpiry(r, expireAfter)
+// TimeToExpiry returns zero if the user session is expired at now (usually
+// ab.Now()) else the time until expiry. Takes in the allowed idle duration.
+func TimeToExpiry(r *http.Request, expireAfter time.Duration, now time.Time) time.Duration {
+	date, ok := lastAction(r)
+	if !ok {
+		return expireAfter
+	}
+
+	remaining := date.Add(expireAfter).Sub(now.UTC())
+	if remaining > 0 {
+		return remaining
+	}
+
+	return 0
 }
 
-func timeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
+func lastAction(r *http.Request) (time.Time, bool) {
 	dateStr, ok := authboss.GetSession(r, authboss.SessionLastAction)
 	if !ok {
-		return expireAfter
+		return time.Time{}, false
 	}
 
 	date, err := time.Parse(time.RFC3339, dateStr)
@@ -41,7 +62,23 @@ func timeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
 		panic("last_action is not a valid RFC3339 date")
 	}
 
-	remaining := date.Add(expireAfter).Sub(nowTime().UTC())
+	return date, true
+}
+
+// TimeToMaxAge returns zero if the user session is older than maxAge at now
+// (usually ab.Now()) else the time until it will be.
+func TimeToMaxAge(r *http.Request, maxAge time.Duration, now time.Time) time.Duration {
+	dateStr, ok := authboss.GetSession(r, authboss.SessionLoginTime)
+	if !ok {
+		return maxAge
+	}
+
+	date, err := time.Parse(time.RFC3339, dateStr)
+	if err != nil {
+		panic("login_time is not a valid RFC3339 date")
+	}
+
+	remaining := date.Add(maxAge).Sub(now.UTC())
 	if remaining > 0 {
 		return remaining
 	}
@@ -49,31 +86,58 @@ func timeToExpiry(r *http.Request, expireAfter time.Duration) time.Duration {
 	return 0
 }
 
-// RefreshExpiry updates the last action for the user, so he doesn't
-// become expired.
-func RefreshExpiry(w http.ResponseWriter, r *http.Request) {
-	refreshExpiry(w)
+// RefreshExpiry updates the last action for the user to now (usually
+// ab.Now()), so he doesn't become expired.
+func RefreshExpiry(w http.ResponseWriter, now time.Time) {
+	authboss.PutSession(w, authboss.SessionLastAction, now.UTC().Format(time.RFC3339))
+}
+
+// RefreshAfter is the default refresh strategy of the Middleware
+// (Modules.ExpireRefresh), it updates the last action once it's older than
+// interval. An interval of zero updates it on every request.
+func RefreshAfter(interval time.Duration) func(r *http.Request, lastAction time.Time) bool {
+	return refreshAfter(time.Now, interval)
 }
 
-func refreshExpiry(w http.ResponseWriter) {
-	authboss.PutSession(w, authboss.SessionLastAction, nowTime().UTC().Format(time.RFC3339))
+func refreshAfter(now func() time.Time, interval time.Duration) func(r *http.Request, lastAction time.Time) bool {
+	return func(r *http.Request, lastAction time.Time) bool {
+		return now().UTC().Sub(lastAction) >= interval
+	}
 }
 
 type expireMiddleware struct {
+	ab               *authboss.Authboss
 	expireAfter      time.Duration
+	maxAge           time.Duration
+	warnBefore       time.Duration
+	refresh          func(r *http.Request, lastAction time.Time) bool
 	next             http.Handler
 	sessionWhitelist []string
 }
 
 // Middleware ensures that the user's expiry information is kept up-to-date
 // on each request. Deletes the SessionKey from the session if the user is
-// expired (a.ExpireAfter duration since SessionLastAction).
+// expired (a.ExpireAfter duration since SessionLastAction) or their session
+// is too old (a.ExpireMaxAge duration since SessionLoginTime), firing
+// EventExpireIdle or EventExpireLifetime respectively. The event's request
+// still has the user's pid in its context.
+// The last action is only updated when Modules.ExpireRefresh (or
+// RefreshAfter(Modules.ExpireRefreshAfter)) says so.
 // This middleware conflicts with use of the Remember module, don't enable both
 // at the same time.
 func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	refresh := ab.Config.Modules.ExpireRefresh
+	if refresh == nil {
+		refresh = refreshAfter(ab.Now, ab.Config.Modules.ExpireRefreshAfter)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return expireMiddleware{
+			ab:               ab,
 			expireAfter:      ab.Config.Modules.ExpireAfter,
+			maxAge:           ab.Config.Modules.ExpireMaxAge,
+			warnBefore:       ab.Config.Modules.ExpireWarnBefore,
+			refresh:          refresh,
 			next:             next,
 			sessionWhitelist: ab.Config.Storage.SessionStateWhitelistKeys,
 		}
@@ -84,12 +148,39 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 // below it.
 func (m expireMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if _, ok := authboss.GetSession(r, authboss.SessionKey); ok {
-		ttl := timeToExpiry(r, m.expireAfter)
+		now := m.ab.Now()
+		ttl := TimeToExpiry(r, m.expireAfter, now)
+
+		lifetime := m.maxAge
+		if m.maxAge > 0 {
+			if _, ok := authboss.GetSession(r, authboss.SessionLoginTime); !ok {
+				// Sessions from before the login time was recorded start now
+				authboss.PutSession(w, authboss.SessionLoginTime, now.UTC().Format(time.RFC3339))
+			}
+			lifetime = TimeToMaxAge(r, m.maxAge, now)
+		}
+
+		if ttl == 0 || (m.maxAge > 0 && lifetime == 0) {
+			event := authboss.EventExpireIdle
+			if ttl != 0 {
+				event = authboss.EventExpireLifetime
+			}
 
-		if ttl == 0 {
 			authboss.DelAllSession(w, m.sessionWhitelist)
 			authboss.DelSession(w, authboss.SessionKey)
 			authboss.DelSession(w, authboss.SessionLastAction)
+			authboss.DelSession(w, authboss.SessionLoginTime)
+
+			for _, e := range []authboss.Event{event, authboss.EventSessionDestroy} {
+				handled, err := m.ab.Events.FireAfter(e, w, r)
+				if err != nil {
+					logger := m.ab.RequestLogger(r)
+					logger.Errorf("failed to fire %s: %+v", e, err)
+				} else if handled {
+					return
+				}
+			}
+
 			ctx := context.WithValue(r.Context(), authboss.CTXKeyPID, nil)
 			ctx = context.WithValue(ctx, authboss.CTXKeyUser, nil)
 
@@ -108,7 +199,18 @@ func (m expireMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 			r = r.WithContext(ctx)
 		} else {
-			refreshExpiry(w)
+			remaining := ttl
+			if last, _ := lastAction(r); m.refresh(r, last) {
+				RefreshExpiry(w, now)
+				remaining = m.expireAfter
+			}
+
+			if m.maxAge > 0 && lifetime < remaining {
+				remaining = lifetime
+			}
+			if m.warnBefore > 0 && remaining <= m.warnBefore {
+				authboss.MergeDataInRequest(&r, authboss.HTMLData{DataExpiresIn: int(remaining / time.Second)})
+			}
 		}
 	}
 
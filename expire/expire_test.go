@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
 	"github.com/volatiletech/authboss/v3/mocks"
 )
 
@@ -36,7 +37,33 @@ func TestExpireSetup(t *testing.T) {
 	}
 }
 
+func TestExpireSetupLoginEvents(t *testing.T) {
+	t.Parallel()
+
+	for _, event := range []authboss.Event{authboss.EventOAuth2, authboss.EventRememberLogin, authboss.EventRegister, authboss.EventRecoverEnd} {
+		ab := authboss.New()
+		clientRW := mocks.NewClientRW()
+		ab.Storage.SessionState = clientRW
+
+		if err := Setup(ab); err != nil {
+			t.Fatal(err)
+		}
+
+		wr := ab.NewResponse(httptest.NewRecorder())
+		if _, err := ab.Events.FireAfter(event, wr, httptest.NewRequest("POST", "/", nil)); err != nil {
+			t.Fatal(err)
+		}
+
+		wr.WriteHeader(http.StatusOK)
+		if _, ok := clientRW.ClientValues[authboss.SessionLoginTime]; !ok {
+			t.Errorf("%s: the login time should have been set", event)
+		}
+	}
+}
+
 func TestExpireIsExpired(t *testing.T) {
+	t.Parallel()
+
 	ab := authboss.New()
 
 	clientRW := mocks.NewClientRW()
@@ -53,13 +80,7 @@ func TestExpireIsExpired(t *testing.T) {
 		t.Error(err)
 	}
 
-	// No t.Parallel() - Also must be after refreshExpiry() call
-	nowTime = func() time.Time {
-		return time.Now().UTC().Add(time.Hour * 2)
-	}
-	defer func() {
-		nowTime = time.Now
-	}()
+	ab.Config.Core.Clock = authtest.NewClock(time.Now().UTC().Add(time.Hour * 2))
 
 	called := false
 	hadUser := false
@@ -93,6 +114,8 @@ func TestExpireIsExpired(t *testing.T) {
 }
 
 func TestExpireNotExpired(t *testing.T) {
+	t.Parallel()
+
 	ab := authboss.New()
 	clientRW := mocks.NewClientRW()
 	clientRW.ClientValues[authboss.SessionKey] = "username"
@@ -110,14 +133,8 @@ func TestExpireNotExpired(t *testing.T) {
 		t.Error(err)
 	}
 
-	// No t.Parallel() - Also must be after refreshExpiry() call
 	newTime := time.Now().UTC().Add(ab.Modules.ExpireAfter / 2)
-	nowTime = func() time.Time {
-		return newTime
-	}
-	defer func() {
-		nowTime = time.Now
-	}()
+	ab.Config.Core.Clock = authtest.NewClock(newTime)
 
 	called := false
 	hadUser := true
@@ -156,7 +173,7 @@ func TestExpireTimeToExpiry(t *testing.T) {
 	r := httptest.NewRequest("GET", "/", nil)
 
 	want := 5 * time.Second
-	dur := TimeToExpiry(r, want)
+	dur := TimeToExpiry(r, want, time.Now())
 	if dur != want {
 		t.Error("duration was wrong:", dur)
 	}
@@ -168,12 +185,117 @@ func TestExpireRefreshExpiry(t *testing.T) {
 	ab := authboss.New()
 	clientRW := mocks.NewClientRW()
 	ab.Storage.SessionState = clientRW
-	r := httptest.NewRequest("GET", "/", nil)
 	w := ab.NewResponse(httptest.NewRecorder())
 
-	RefreshExpiry(w, r)
+	RefreshExpiry(w, time.Now())
 	w.WriteHeader(200)
 	if _, ok := clientRW.ClientValues[authboss.SessionLastAction]; !ok {
 		t.Error("this key should have been set")
 	}
 }
+
+func TestExpireMaxAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	setup := func(loggedIn time.Time) (*authboss.Authboss, *mocks.ClientStateRW, *authboss.ClientStateResponseWriter, *http.Request) {
+		ab := authboss.New()
+		ab.Modules.ExpireMaxAge = 2 * time.Hour
+		ab.Modules.ExpireWarnBefore = 15 * time.Minute
+		ab.Config.Core.Logger = mocks.Logger{}
+		ab.Config.Core.Clock = authtest.NewClock(now)
+
+		clientRW := mocks.NewClientRW()
+		clientRW.ClientValues[authboss.SessionKey] = "username"
+		clientRW.ClientValues[authboss.SessionLastAction] = now.Format(time.RFC3339)
+		clientRW.ClientValues[authboss.SessionLoginTime] = loggedIn.Format(time.RFC3339)
+		ab.Storage.SessionState = clientRW
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, "primaryid"))
+		w := ab.NewResponse(httptest.NewRecorder())
+		r, err := ab.LoadClientState(w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ab, clientRW, w, r
+	}
+
+	ab, clientRW, w, r := setup(now.Add(-3 * time.Hour))
+
+	fired := authboss.Event(-1)
+	ab.Events.After(authboss.EventExpireIdle, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		fired = authboss.EventExpireIdle
+		return false, nil
+	})
+	ab.Events.After(authboss.EventExpireLifetime, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		fired = authboss.EventExpireLifetime
+		return false, nil
+	})
+
+	hadUser := true
+	Middleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hadUser = r.Context().Value(authboss.CTXKeyPID) != nil
+	})).ServeHTTP(w, r)
+
+	if hadUser {
+		t.Error("the user should have been logged out")
+	}
+	if fired != authboss.EventExpireLifetime {
+		t.Error("the lifetime event should have fired, got:", fired)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, ok := clientRW.ClientValues[authboss.SessionLoginTime]; ok {
+		t.Error("the login time should have been deleted")
+	}
+
+	ab, _, w, r = setup(now.Add(-110 * time.Minute))
+
+	var data authboss.HTMLData
+	Middleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ = r.Context().Value(authboss.CTXKeyData).(authboss.HTMLData)
+	})).ServeHTTP(w, r)
+
+	if expiresIn := data[DataExpiresIn]; expiresIn != int((10 * time.Minute).Seconds()) {
+		t.Error("the session should be about to expire, got:", expiresIn)
+	}
+}
+
+func TestExpireRefreshAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	serve := func(lastAction time.Time) string {
+		ab := authboss.New()
+		ab.Config.Core.Clock = authtest.NewClock(now)
+		ab.Modules.ExpireRefreshAfter = time.Minute
+
+		clientRW := mocks.NewClientRW()
+		clientRW.ClientValues[authboss.SessionKey] = "username"
+		clientRW.ClientValues[authboss.SessionLastAction] = lastAction.Format(time.RFC3339)
+		ab.Storage.SessionState = clientRW
+
+		r := httptest.NewRequest("GET", "/", nil)
+		w := ab.NewResponse(httptest.NewRecorder())
+		r, err := ab.LoadClientState(w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Middleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		w.WriteHeader(http.StatusOK)
+
+		return clientRW.ClientValues[authboss.SessionLastAction]
+	}
+
+	recent := now.Add(-30 * time.Second)
+	if last := serve(recent); last != recent.Format(time.RFC3339) {
+		t.Error("a recent last action should not be written:", last)
+	}
+	if last := serve(now.Add(-2 * time.Minute)); last != now.Format(time.RFC3339) {
+		t.Error("an old last action should be refreshed:", last)
+	}
+}
@@ -0,0 +1,434 @@
+// Package export lets logged in users download the data authboss keeps
+// about them as JSON, to help answer data portability requests.
+//
+// GET /export shows the page that starts the export and POST /export
+// streams it. Users with a password must enter it again to export their
+// data, those without one (like users who log in with oauth2) must have
+// logged in within Config.Modules.ExportReauthWindow. A wrong password
+// fires EventAuthFail so it's counted by the lock module like a failed
+// login.
+//
+// The export has the user's profile, oauth2 identities, second factors,
+// sessions, devices and api keys, never their password hash, secrets or
+// tokens. Everything else the app keeps about them, like the rest of
+// their profile and their audit events, is added by the
+// authboss.ExportingServerStorer.
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp/twofactor"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+)
+
+const (
+	// PageExport starts the export, it's also for identifying the request
+	// to export for parsing & validation
+	PageExport = "export"
+
+	// DataPasswordRequired is true when the user must enter their password
+	// to export their data
+	DataPasswordRequired = "password_required"
+)
+
+// Sections of the export the module writes itself, the
+// ExportingServerStorer's sections come after them.
+const (
+	SectionUser             = "user"
+	SectionOAuth2Identities = "oauth2_identities"
+	SectionTwoFactor        = "two_factor"
+	SectionSessions         = "sessions"
+	SectionDevices          = "devices"
+	SectionAPIKeys          = "api_keys"
+)
+
+func init() {
+	authboss.RegisterModule("export", &Export{})
+}
+
+// Profile is what the user's authboss interfaces know about them
+type Profile struct {
+	PID         string            `json:"pid"`
+	Email       string            `json:"email,omitempty"`
+	BackupEmail string            `json:"backup_email,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Confirmed   *bool             `json:"confirmed,omitempty"`
+	AccountType string            `json:"account_type,omitempty"`
+	Roles       []string          `json:"roles,omitempty"`
+	Arbitrary   map[string]string `json:"arbitrary,omitempty"`
+}
+
+// Identity is an oauth2 provider account linked to the user
+type Identity struct {
+	Provider string `json:"provider"`
+	UID      string `json:"uid"`
+}
+
+// TwoFactor lists the second factors the user has set up
+type TwoFactor struct {
+	TOTP          bool   `json:"totp"`
+	SMSPhone      string `json:"sms_phone,omitempty"`
+	RecoveryCodes int    `json:"recovery_codes"`
+}
+
+// Session the user is logged in with
+type Session struct {
+	Created time.Time `json:"created"`
+}
+
+// Device the user logged in from
+type Device struct {
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// APIKey the user created, without its hash
+type APIKey struct {
+	Name    string     `json:"name"`
+	Hint    string     `json:"hint"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Export module
+type Export struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what exporting needs
+func (e *Export) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Logger", "Storage.Server", "Storage.SessionState")
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.ExportingServerStorer)(nil))...)
+}
+
+// Init module
+func (e *Export) Init(ab *authboss.Authboss) error {
+	e.Authboss = ab
+
+	if err := e.Config.Core.ViewRenderer.Load(PageExport); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if ab.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = ab.Config.Modules.ResponseOnUnauthed
+	} else if ab.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(ab, true, authboss.RequireFullAuth, unauthedResponse)
+
+	e.Config.Core.Router.Get("/export", middleware(e.Core.ErrorHandler.Wrap(e.Get)))
+	e.Config.Core.Router.Post("/export", middleware(e.Core.ErrorHandler.Wrap(e.Post)))
+
+	// Users without a password reauthenticate by logging in again
+	e.Events.After(authboss.EventAuth, e.RecordLogin)
+	e.Events.After(authboss.EventOAuth2, e.RecordLogin)
+
+	return nil
+}
+
+// RecordLogin puts the login time in the session, like the expire module
+func (e *Export) RecordLogin(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	authboss.PutSession(w, authboss.SessionLoginTime, e.Now().UTC().Format(time.RFC3339))
+	return false, nil
+}
+
+// Get shows the page that starts the export
+func (e *Export) Get(w http.ResponseWriter, r *http.Request) error {
+	user, err := e.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{DataPasswordRequired: hasPassword(user)}
+	return e.Core.Responder.Respond(w, r, http.StatusOK, PageExport, data)
+}
+
+// Post reauthenticates the user and streams their export
+func (e *Export) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := e.RequestLogger(r)
+
+	user, err := e.CurrentUser(r)
+	if err != nil {
+		return err
+	}
+
+	validatable, err := e.Core.BodyReader.Read(PageExport, r)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := e.reauthenticate(w, r, user, authboss.MustHaveUserValues(validatable).GetPassword()); err != nil || !ok {
+		return err
+	}
+
+	logger.Infof("user %s exported their data", user.GetPID())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &stream{w: w, flusher: flusher(w), sections: make(map[string]bool)}
+	if err := e.write(r, user, stream.put); err != nil {
+		return err
+	}
+	return stream.close()
+}
+
+// reauthenticate checks the user's password, or that they logged in
+// recently if they don't have one. When they haven't it responds and
+// returns false.
+func (e *Export) reauthenticate(w http.ResponseWriter, r *http.Request, user authboss.User, password string) (bool, error) {
+	logger := e.RequestLogger(r)
+
+	if !hasPassword(user) {
+		if login, ok := e.loginTime(r); ok && e.Now().Before(login.Add(e.Config.Modules.ExportReauthWindow)) {
+			return true, nil
+		}
+
+		logger.Infof("user %s must log in again to export their data", user.GetPID())
+		data := authboss.HTMLData{
+			authboss.DataErr:     e.Localize(r.Context(), authboss.TxtExportLoginAgain),
+			DataPasswordRequired: false,
+		}
+		return false, e.Core.Responder.Respond(w, r, http.StatusOK, PageExport, data)
+	}
+
+	if err := authboss.VerifyPassword(user.(authboss.AuthableUser), password); err == nil {
+		return true, nil
+	}
+
+	logger.Infof("user %s failed to reauthenticate to export their data", user.GetPID())
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	r = e.WithAttempt(r, authboss.AttemptInfo{
+		PID:     user.GetPID(),
+		Module:  "export",
+		Stage:   authboss.AttemptStagePassword,
+		Failure: authboss.ErrorCodeInvalidCredentials,
+	})
+	handled, err := e.Events.FireAfter(authboss.EventAuthFail, w, r)
+	if err != nil || handled {
+		return false, err
+	}
+
+	data := authboss.HTMLData{
+		authboss.DataErr:     e.Localize(r.Context(), authboss.TxtInvalidCredentials),
+		DataPasswordRequired: true,
+	}
+	return false, e.Core.Responder.Respond(w, r, http.StatusOK, PageExport, data)
+}
+
+func (e *Export) loginTime(r *http.Request) (time.Time, bool) {
+	value, ok := authboss.GetSession(r, authboss.SessionLoginTime)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	login, err := time.Parse(time.RFC3339, value)
+	return login, err == nil
+}
+
+// write puts every section of the user's export
+func (e *Export) write(r *http.Request, user authboss.User, put func(section string, data interface{}) error) error {
+	ctx := r.Context()
+	pid := authboss.UserPID(user)
+
+	if err := put(SectionUser, profile(user)); err != nil {
+		return err
+	}
+
+	identities := []Identity{}
+	if u, ok := user.(authboss.OAuth2User); ok && u.IsOAuth2User() {
+		identities = append(identities, Identity{Provider: u.GetOAuth2Provider(), UID: u.GetOAuth2UID()})
+	}
+	if u, ok := user.(authboss.OAuth2LinkableUser); ok {
+		for _, identity := range u.GetOAuth2Identities() {
+			identities = append(identities, Identity{Provider: identity.Provider, UID: identity.UID})
+		}
+	}
+	if err := put(SectionOAuth2Identities, identities); err != nil {
+		return err
+	}
+
+	var twoFactor TwoFactor
+	if u, ok := user.(totp2fa.User); ok {
+		twoFactor.TOTP = len(u.GetTOTPSecretKey()) != 0
+	}
+	if u, ok := user.(sms2fa.User); ok {
+		number, _, err := e.DecryptSecret(ctx, authboss.AttributeSMSPhoneNumber, u.GetSMSPhoneNumber())
+		if err != nil {
+			return err
+		}
+		twoFactor.SMSPhone = number
+	}
+	if u, ok := user.(twofactor.User); ok {
+		if codes := u.GetRecoveryCodes(); len(codes) != 0 {
+			twoFactor.RecoveryCodes = len(twofactor.DecodeRecoveryCodes(codes))
+		}
+	}
+	if err := put(SectionTwoFactor, twoFactor); err != nil {
+		return err
+	}
+
+	storer := e.Config.Storage.Server
+
+	if sessionStorer, ok := storer.(authboss.SessionServerStorer); ok {
+		records, err := sessionStorer.LoadSessionRecords(ctx, pid)
+		if err != nil {
+			return errors.Wrap(err, "failed to load sessions")
+		}
+		sessions := make([]Session, 0, len(records))
+		for _, s := range records {
+			sessions = append(sessions, Session{Created: s.Created})
+		}
+		if err := put(SectionSessions, sessions); err != nil {
+			return err
+		}
+	}
+
+	if deviceStorer, ok := storer.(authboss.KnownDeviceStorer); ok {
+		known, err := deviceStorer.LoadKnownDevices(ctx, pid)
+		if err != nil {
+			return errors.Wrap(err, "failed to load known devices")
+		}
+		devices := make([]Device, 0, len(known))
+		for _, d := range known {
+			devices = append(devices, Device{FirstSeen: d.FirstSeen, LastSeen: d.LastSeen})
+		}
+		if err := put(SectionDevices, devices); err != nil {
+			return err
+		}
+	}
+
+	if keyStorer, ok := storer.(authboss.APIKeyServerStorer); ok {
+		stored, err := keyStorer.LoadAPIKeys(ctx, pid)
+		if err != nil {
+			return errors.Wrap(err, "failed to load api keys")
+		}
+		keys := make([]APIKey, 0, len(stored))
+		for _, k := range stored {
+			key := APIKey{Name: k.Name, Hint: k.Hint, Created: k.Created}
+			if !k.Expires.IsZero() {
+				expires := k.Expires
+				key.Expires = &expires
+			}
+			keys = append(keys, key)
+		}
+		if err := put(SectionAPIKeys, keys); err != nil {
+			return err
+		}
+	}
+
+	if err := authboss.EnsureCanExport(storer).ExportUser(ctx, pid, put); err != nil {
+		return errors.Wrap(err, "failed to export user")
+	}
+	return nil
+}
+
+func profile(user authboss.User) Profile {
+	p := Profile{PID: user.GetPID()}
+
+	if u, ok := user.(authboss.RecoverableUser); ok {
+		p.Email = u.GetEmail()
+	}
+	if u, ok := user.(authboss.ConfirmableUser); ok {
+		p.Email = u.GetEmail()
+		confirmed := u.GetConfirmed()
+		p.Confirmed = &confirmed
+	}
+	if u, ok := user.(authboss.BackupEmailUser); ok {
+		p.BackupEmail = u.GetBackupEmail()
+	}
+	if u, ok := user.(authboss.RenamableUser); ok {
+		p.Username = u.GetUsername()
+	}
+	if u, ok := user.(authboss.AccountTypeUser); ok {
+		p.AccountType = u.GetAccountType()
+	}
+	if u, ok := user.(authboss.RolesUser); ok {
+		p.Roles = u.GetRoles()
+	}
+	if u, ok := user.(authboss.ArbitraryUser); ok {
+		p.Arbitrary = u.GetArbitrary()
+	}
+
+	return p
+}
+
+func hasPassword(user authboss.User) bool {
+	u, ok := user.(authboss.AuthableUser)
+	return ok && len(u.GetPassword()) != 0
+}
+
+// stream writes the sections of the export as the members of a JSON
+// object as they're put, flushing after each one
+type stream struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	sections map[string]bool
+}
+
+func (s *stream) put(section string, data interface{}) error {
+	if s.sections[section] {
+		return errors.Errorf("section %s of the export was put twice", section)
+	}
+
+	name, err := json.Marshal(section)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal section %s of the export", section)
+	}
+
+	separator := ","
+	if len(s.sections) == 0 {
+		separator = "{"
+	}
+	s.sections[section] = true
+
+	if _, err = s.w.Write([]byte(separator)); err != nil {
+		return err
+	}
+	if _, err = s.w.Write(append(append(name, ':'), value...)); err != nil {
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+func (s *stream) close() error {
+	end := "}"
+	if len(s.sections) == 0 {
+		end = "{}"
+	}
+	_, err := s.w.Write([]byte(end + "\n"))
+	return err
+}
+
+// flusher finds the http.Flusher under authboss' response writers
+func flusher(w http.ResponseWriter) http.Flusher {
+	for {
+		if f, ok := w.(http.Flusher); ok {
+			return f
+		}
+		u, ok := w.(interface{ UnderlyingResponseWriter() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.UnderlyingResponseWriter()
+	}
+}
@@ -0,0 +1,203 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	if err := (&Export{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageExport); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/export"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/export"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	export *Export
+	ab     *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	clock      *authtest.Clock
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+
+	authFails int
+}
+
+func testSetup() *testHarness {
+	h := &testHarness{}
+
+	h.ab = authboss.New()
+	h.bodyReader = &mocks.BodyReader{}
+	h.clock = authtest.NewClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.responder = &mocks.Responder{}
+	h.session = mocks.NewClientRW()
+	h.storer = mocks.NewServerStorer()
+
+	h.ab.Config.Core.BodyReader = h.bodyReader
+	h.ab.Config.Core.Clock = h.clock
+	h.ab.Config.Core.Logger = mocks.Logger{}
+	h.ab.Config.Core.Responder = h.responder
+	h.ab.Config.Modules.ExportReauthWindow = 5 * time.Minute
+	h.ab.Config.Storage.Server = h.storer
+	h.ab.Config.Storage.SessionState = h.session
+
+	h.ab.Events.After(authboss.EventAuthFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		h.authFails++
+		return false, nil
+	})
+
+	h.export = &Export{h.ab}
+
+	password, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	h.storer.Users["test@test.com"] = &mocks.User{
+		Email:         "test@test.com",
+		Password:      string(password),
+		Confirmed:     true,
+		TOTPSecretKey: "secret",
+		OAuth2Identities: []authboss.OAuth2Identity{
+			{Provider: "github", UID: "42", AccessToken: "access"},
+		},
+	}
+	h.storer.Sessions["test@test.com"] = []authboss.SessionRecord{{ID: "session", Created: h.clock.Now()}}
+	h.storer.Exports["test@test.com"] = map[string]interface{}{
+		"audit_events": []string{"logged in"},
+	}
+
+	return h
+}
+
+func (h *testHarness) post(t *testing.T, pid, password string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := mocks.Request("POST")
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, h.storer.Users[pid]))
+
+	h.bodyReader.Return = mocks.Values{Password: password}
+	if err := h.export.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestPost(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	rec := h.post(t, "test@test.com", "password")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("the export should be sent:", rec.Code, rec.Header())
+	}
+
+	body := rec.Body.String()
+	if strings.Contains(body, "$2a$") || strings.Contains(body, "secret") || strings.Contains(body, "access") {
+		t.Error("secrets should not be exported:", body)
+	}
+
+	var export struct {
+		User             Profile    `json:"user"`
+		OAuth2Identities []Identity `json:"oauth2_identities"`
+		TwoFactor        TwoFactor  `json:"two_factor"`
+		Sessions         []Session  `json:"sessions"`
+		AuditEvents      []string   `json:"audit_events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatal(err, body)
+	}
+
+	if export.User.PID != "test@test.com" || export.User.Confirmed == nil || !*export.User.Confirmed {
+		t.Errorf("profile was wrong: %#v", export.User)
+	}
+	if len(export.OAuth2Identities) != 1 || export.OAuth2Identities[0].UID != "42" {
+		t.Errorf("identities were wrong: %#v", export.OAuth2Identities)
+	}
+	if !export.TwoFactor.TOTP {
+		t.Error("totp should be set up")
+	}
+	if len(export.Sessions) != 1 {
+		t.Errorf("sessions were wrong: %#v", export.Sessions)
+	}
+	if len(export.AuditEvents) != 1 {
+		t.Errorf("the storer's sections should be exported: %#v", export.AuditEvents)
+	}
+}
+
+func TestPostWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	rec := h.post(t, "test@test.com", "wrong")
+	if rec.Body.Len() != 0 {
+		t.Error("nothing should be exported:", rec.Body.String())
+	}
+	if h.responder.Page != PageExport || h.responder.Data[authboss.DataErr] != authboss.TxtInvalidCredentials.Default {
+		t.Errorf("the password should be asked for again: %s %#v", h.responder.Page, h.responder.Data)
+	}
+	if h.authFails != 1 {
+		t.Error("the failure should fire EventAuthFail:", h.authFails)
+	}
+}
+
+func TestPostNoPassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Users["oauth2@test.com"] = &mocks.User{OAuth2UID: "1", OAuth2Provider: "google"}
+
+	rec := h.post(t, "oauth2@test.com", "")
+	if rec.Body.Len() != 0 || h.responder.Data[authboss.DataErr] != authboss.TxtExportLoginAgain.Default {
+		t.Errorf("users who haven't logged in recently should log in again: %#v", h.responder.Data)
+	}
+
+	h.session.ClientValues[authboss.SessionLoginTime] = h.clock.Now().Add(-time.Minute).Format(time.RFC3339)
+	rec = h.post(t, "oauth2@test.com", "")
+	if !strings.Contains(rec.Body.String(), `"oauth2_identities":[{"provider":"google","uid":"1"}]`) {
+		t.Error("users who logged in recently should get their export:", rec.Body.String())
+	}
+
+	h.clock.Advance(10 * time.Minute)
+	h.responder.Data = nil
+	rec = h.post(t, "oauth2@test.com", "")
+	if rec.Body.Len() != 0 || h.responder.Data[authboss.DataErr] != authboss.TxtExportLoginAgain.Default {
+		t.Error("the login should be too old")
+	}
+}
@@ -0,0 +1,39 @@
+package authboss
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned when a password is wrong, see
+// ExternalAuthenticator and Authboss.CheckPassword.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ExternalAuthenticator checks passwords against a system outside of
+// authboss, like an LDAP directory (see the ldap package), instead of the
+// hash stored with the user. Authboss still loads the user from the
+// ServerStorer and manages their sessions, lockout, 2fa and remember me.
+type ExternalAuthenticator interface {
+	// Authenticate returns ErrInvalidCredentials when the password isn't
+	// the user's, other errors mean it couldn't be checked.
+	Authenticate(ctx context.Context, pid, password string) error
+}
+
+// CheckPassword checks the user's password with
+// Config.Core.ExternalAuthenticator if it's set, otherwise against the
+// user's bcrypt hash (which means the user must be an AuthableUser). It
+// returns ErrInvalidCredentials when the password is wrong.
+func (a *Authboss) CheckPassword(ctx context.Context, user User, password string) error {
+	if a.Config.Core.ExternalAuthenticator != nil {
+		return a.Config.Core.ExternalAuthenticator.Authenticate(ctx, user.GetPID(), password)
+	}
+
+	hash := MustBeAuthable(user).GetPassword()
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package authboss
+
+import (
+	"context"
+	"testing"
+)
+
+type testAuthenticator string
+
+func (a testAuthenticator) Authenticate(ctx context.Context, pid, password string) error {
+	if password != string(a) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+func TestCheckPassword(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	user := &mockUser{
+		Email:    "test@test.com",
+		Password: "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji", // hello world
+	}
+	ctx := context.Background()
+
+	if err := ab.CheckPassword(ctx, user, "hello world"); err != nil {
+		t.Error("the password should match its hash:", err)
+	}
+	if err := ab.CheckPassword(ctx, user, "world hello"); err != ErrInvalidCredentials {
+		t.Error("a wrong password should be invalid credentials:", err)
+	}
+
+	ab.Config.Core.ExternalAuthenticator = testAuthenticator("directory")
+	if err := ab.CheckPassword(ctx, user, "hello world"); err != ErrInvalidCredentials {
+		t.Error("the hash should not be used with an external authenticator:", err)
+	}
+	if err := ab.CheckPassword(ctx, user, "directory"); err != nil {
+		t.Error("the external authenticator should be used:", err)
+	}
+}
@@ -0,0 +1,178 @@
+package authboss
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExtraFieldType is what the value of an ExtraField is parsed as
+type ExtraFieldType int
+
+// Extra field types, the values in ExtraFields are a string, an int64, a
+// float64, a bool or a time.Time respectively
+const (
+	ExtraFieldString ExtraFieldType = iota
+	ExtraFieldInt
+	ExtraFieldFloat
+	ExtraFieldBool
+	ExtraFieldTime
+)
+
+// ExtraField declares a field of the register form besides the pid and the
+// password, see Config.Modules.RegisterExtraFields. Only the declared fields
+// are read from the form, parsed as their Type and given to the user with
+// ExtraFieldsUser.PutExtraFields.
+type ExtraField struct {
+	Name string
+	Type ExtraFieldType
+	// Required fields can't be blank
+	Required bool
+	// Validators check the parsed value, the errors they return are the
+	// field's validation errors
+	Validators []ExtraFieldValidator
+}
+
+// ExtraFieldValidator checks the parsed value of an extra field, it's one
+// of the types of ExtraFields
+type ExtraFieldValidator func(value interface{}) error
+
+// ExtraFields are the parsed values of the extra registration fields by
+// name, blank fields that aren't required are left out.
+type ExtraFields map[string]interface{}
+
+// String value of the field
+func (e ExtraFields) String(name string) string {
+	s, _ := e[name].(string)
+	return s
+}
+
+// Int value of the field
+func (e ExtraFields) Int(name string) int64 {
+	i, _ := e[name].(int64)
+	return i
+}
+
+// Float value of the field
+func (e ExtraFields) Float(name string) float64 {
+	f, _ := e[name].(float64)
+	return f
+}
+
+// Bool value of the field
+func (e ExtraFields) Bool(name string) bool {
+	b, _ := e[name].(bool)
+	return b
+}
+
+// Time value of the field
+func (e ExtraFields) Time(name string) time.Time {
+	t, _ := e[name].(time.Time)
+	return t
+}
+
+// Strings formats the values the way ParseExtraFields reads them, for
+// example to render them back into the form.
+func (e ExtraFields) Strings() map[string]string {
+	strs := make(map[string]string, len(e))
+	for name, value := range e {
+		switch v := value.(type) {
+		case string:
+			strs[name] = v
+		case int64:
+			strs[name] = strconv.FormatInt(v, 10)
+		case float64:
+			strs[name] = strconv.FormatFloat(v, 'g', -1, 64)
+		case bool:
+			strs[name] = strconv.FormatBool(v)
+		case time.Time:
+			strs[name] = v.Format(time.RFC3339Nano)
+		default:
+			strs[name] = fmt.Sprint(v)
+		}
+	}
+	return strs
+}
+
+// ParseExtraFields reads the fields of the schema from the form's values.
+// The errors are FieldErrors for the fields that are blank but required,
+// can't be parsed as their type or fail their validators. Times can be
+// RFC3339 or dates like 2006-01-02 (an html date input), bools are what
+// strconv.ParseBool takes or "on" (an html checkbox).
+func ParseExtraFields(schema []ExtraField, values map[string]string) (ExtraFields, []error) {
+	fields := make(ExtraFields, len(schema))
+	var errs []error
+
+	for _, field := range schema {
+		raw := strings.TrimSpace(values[field.Name])
+		if len(raw) == 0 {
+			if field.Required {
+				errs = append(errs, extraFieldError{field.Name, NewLocalizedError(TxtRequired)})
+			}
+			continue
+		}
+
+		value, err := field.parse(raw)
+		if err != nil {
+			errs = append(errs, extraFieldError{field.Name, err})
+			continue
+		}
+
+		valid := true
+		for _, validator := range field.Validators {
+			if err := validator(value); err != nil {
+				errs = append(errs, extraFieldError{field.Name, err})
+				valid = false
+			}
+		}
+		if valid {
+			fields[field.Name] = value
+		}
+	}
+
+	return fields, errs
+}
+
+func (e ExtraField) parse(raw string) (interface{}, error) {
+	switch e.Type {
+	case ExtraFieldInt:
+		if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return i, nil
+		}
+		return nil, NewLocalizedError(TxtExtraFieldInt)
+	case ExtraFieldFloat:
+		if f, err := strconv.ParseFloat(raw, 64); err == nil {
+			return f, nil
+		}
+		return nil, NewLocalizedError(TxtExtraFieldFloat)
+	case ExtraFieldBool:
+		if raw == "on" {
+			return true, nil
+		}
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b, nil
+		}
+		return nil, NewLocalizedError(TxtExtraFieldBool)
+	case ExtraFieldTime:
+		if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse("2006-01-02", raw); err == nil {
+			return t, nil
+		}
+		return nil, NewLocalizedError(TxtExtraFieldTime)
+	default:
+		return raw, nil
+	}
+}
+
+// extraFieldError is the FieldError of an extra field
+type extraFieldError struct {
+	name string
+	err  error
+}
+
+func (e extraFieldError) Name() string  { return e.name }
+func (e extraFieldError) Err() error    { return e.err }
+func (e extraFieldError) Error() string { return fmt.Sprintf("%s: %v", e.name, e.err) }
@@ -0,0 +1,97 @@
+package authboss
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseExtraFields(t *testing.T) {
+	t.Parallel()
+
+	adult := func(value interface{}) error {
+		if value.(int64) < 18 {
+			return errors.New("too young")
+		}
+		return nil
+	}
+
+	schema := []ExtraField{
+		{Name: "name", Required: true},
+		{Name: "age", Type: ExtraFieldInt, Validators: []ExtraFieldValidator{adult}},
+		{Name: "height", Type: ExtraFieldFloat},
+		{Name: "newsletter", Type: ExtraFieldBool},
+		{Name: "birthday", Type: ExtraFieldTime},
+		{Name: "nickname"},
+	}
+
+	fields, errs := ParseExtraFields(schema, map[string]string{
+		"name":       " Jo ",
+		"age":        "30",
+		"height":     "1.8",
+		"newsletter": "on",
+		"birthday":   "1990-02-03",
+		"admin":      "true",
+	})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	want := ExtraFields{
+		"name":       "Jo",
+		"age":        int64(30),
+		"height":     1.8,
+		"newsletter": true,
+		"birthday":   time.Date(1990, 2, 3, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields were wrong: %#v", fields)
+	}
+	if fields.String("name") != "Jo" || fields.Int("age") != 30 || !fields.Bool("newsletter") || fields.Float("nickname") != 0 {
+		t.Error("the getters were wrong")
+	}
+
+	_, errs = ParseExtraFields(schema, map[string]string{
+		"age":        "12",
+		"height":     "tall",
+		"newsletter": "maybe",
+		"birthday":   "yesterday",
+	})
+	got := ErrorMap(errs)
+	wantErrs := map[string][]string{
+		"name":       {TxtRequired.Default},
+		"age":        {"too young"},
+		"height":     {TxtExtraFieldFloat.Default},
+		"newsletter": {TxtExtraFieldBool.Default},
+		"birthday":   {TxtExtraFieldTime.Default},
+	}
+	if !reflect.DeepEqual(got, wantErrs) {
+		t.Errorf("errors were wrong: %#v", got)
+	}
+}
+
+func TestExtraFieldsStrings(t *testing.T) {
+	t.Parallel()
+
+	schema := []ExtraField{
+		{Name: "age", Type: ExtraFieldInt},
+		{Name: "height", Type: ExtraFieldFloat},
+		{Name: "newsletter", Type: ExtraFieldBool},
+		{Name: "birthday", Type: ExtraFieldTime},
+	}
+	fields := ExtraFields{
+		"age":        int64(30),
+		"height":     1.8,
+		"newsletter": false,
+		"birthday":   time.Date(1990, 2, 3, 4, 5, 6, 7, time.UTC),
+	}
+
+	parsed, errs := ParseExtraFields(schema, fields.Strings())
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if !reflect.DeepEqual(parsed, fields) {
+		t.Errorf("the fields should parse back from their strings: %#v", parsed)
+	}
+}
@@ -0,0 +1,159 @@
+package authboss
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// fromTrustedProxy checks if the request was made by one of
+// Config.Modules.TrustedProxies
+func (a *Authboss) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && inNetworks(ip, a.Config.Modules.TrustedProxies)
+}
+
+// forwardedHeader is the last value of a header a trusted proxy set. The
+// ones before it could have come from the client when the proxy appends to
+// the header rather than overwrite it.
+func forwardedHeader(r *http.Request, name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+	values := strings.Split(r.Header.Get(name), ",")
+	return strings.TrimSpace(values[len(values)-1])
+}
+
+// allowedHost checks the host (with or without a port) is one of
+// Config.Modules.AllowedHosts. Entries without a port match any port.
+func (a *Authboss) allowedHost(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, allowed := range a.Config.Modules.AllowedHosts {
+		if strings.EqualFold(allowed, host) || strings.EqualFold(allowed, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForwardedRootURL is the root url the client used when the request came
+// through one of Config.Modules.TrustedProxies: Config.Paths.RootURL with
+// the scheme, host and path prefix of the ForwardedProtoHeader,
+// ForwardedHostHeader and ForwardedPrefixHeader in place of its own. ok is
+// false when the request didn't come from a trusted proxy, the proxy
+// didn't set any of the headers or they aren't valid. The forwarded host
+// must be one of Config.Modules.AllowedHosts so a request can't make the
+// links point elsewhere.
+//
+// LoadClientState makes it the request's Paths.RootURL (see
+// RequestConfig) so the links the modules e-mail point to the domain and
+// prefix the app was reached on.
+func (a *Authboss) ForwardedRootURL(r *http.Request) (rootURL string, ok bool) {
+	if !a.fromTrustedProxy(r) {
+		return "", false
+	}
+
+	modules := a.Config.Modules
+	proto := strings.ToLower(forwardedHeader(r, modules.ForwardedProtoHeader))
+	host := forwardedHeader(r, modules.ForwardedHostHeader)
+	prefix, prefixOK := a.ForwardedPrefix(r)
+	if len(proto) == 0 && len(host) == 0 && !prefixOK {
+		return "", false
+	}
+
+	root := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		root.Scheme = "https"
+	}
+	if len(a.Config.Paths.RootURL) != 0 {
+		configured, err := url.Parse(a.Config.Paths.RootURL)
+		if err != nil {
+			return "", false
+		}
+		root.Scheme, root.Host, root.Path = configured.Scheme, configured.Host, configured.Path
+	}
+
+	if len(proto) != 0 {
+		if proto != "http" && proto != "https" {
+			return "", false
+		}
+		root.Scheme = proto
+	}
+	if len(host) != 0 {
+		if strings.ContainsAny(host, "/\\@?#") {
+			return "", false
+		}
+		if u, err := url.Parse("//" + host); err != nil || u.Host != host {
+			return "", false
+		}
+		if !a.allowedHost(host) {
+			return "", false
+		}
+		root.Host = host
+	}
+	if prefixOK {
+		root.Path = prefix
+	}
+
+	return strings.TrimSuffix(root.String(), "/"), true
+}
+
+// ForwardedPrefix is the path prefix a trusted proxy mounted the app
+// under (the ForwardedPrefixHeader), without a trailing slash. ok is false
+// when the request didn't come from a trusted proxy or it didn't set a
+// valid prefix.
+func (a *Authboss) ForwardedPrefix(r *http.Request) (prefix string, ok bool) {
+	if !a.fromTrustedProxy(r) {
+		return "", false
+	}
+
+	prefix = forwardedHeader(r, a.Config.Modules.ForwardedPrefixHeader)
+	if len(prefix) == 0 {
+		return "", false
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !IsLocalRedirect(prefix) || strings.ContainsAny(prefix, "?#") {
+		return "", false
+	}
+
+	prefix = path.Clean(prefix)
+	if prefix == "/" {
+		return "", true
+	}
+	return prefix, true
+}
+
+// PathPrefix is the prefix LoadClientState found the app is mounted under
+// by a reverse proxy (see Authboss.ForwardedPrefix), the redirects to
+// paths on the site must start with it. It's empty when there's none.
+func PathPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(CTXKeyPathPrefix).(string)
+	return prefix
+}
+
+// WithPathPrefix puts the prefix in front of a path on the site that
+// doesn't have it yet, see PathPrefix. Other redirects (like absolute
+// urls) are returned as they are.
+func WithPathPrefix(r *http.Request, redir string) string {
+	prefix := PathPrefix(r)
+	if len(prefix) == 0 || !IsLocalRedirect(redir) {
+		return redir
+	}
+	if redir == prefix || strings.HasPrefix(redir, prefix+"/") || strings.HasPrefix(redir, prefix+"?") {
+		return redir
+	}
+	return prefix + redir
+}
@@ -0,0 +1,123 @@
+package authboss
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func newForwardedAuthboss() *Authboss {
+	ab := New()
+	ab.Config.Paths.RootURL = "http://localhost:8080"
+	ab.Config.Modules.TrustedProxies = []string{"10.0.0.0/8"}
+	ab.Config.Modules.ForwardedProtoHeader = "X-Forwarded-Proto"
+	ab.Config.Modules.ForwardedHostHeader = "X-Forwarded-Host"
+	ab.Config.Modules.ForwardedPrefixHeader = "X-Forwarded-Prefix"
+	ab.Config.Modules.AllowedHosts = []string{"example.com", "brand.test"}
+	return ab
+}
+
+func TestForwardedRootURL(t *testing.T) {
+	t.Parallel()
+
+	ab := newForwardedAuthboss()
+
+	tests := []struct {
+		Name       string
+		RemoteAddr string
+		Proto      string
+		Host       string
+		Prefix     string
+		Want       string
+	}{
+		{"untrusted", "192.0.2.1:1234", "https", "example.com", "/app", ""},
+		{"no headers", "10.0.0.1:1234", "", "", "", ""},
+		{"all", "10.0.0.1:1234", "https", "example.com", "/app/", "https://example.com/app"},
+		{"host only", "10.0.0.1:1234", "", "example.com:8443", "", "http://example.com:8443"},
+		{"appended", "10.0.0.1:1234", "http, https", "evil.com, example.com", "", "https://example.com"},
+		{"host not allowed", "10.0.0.1:1234", "https", "evil.com", "", ""},
+		{"allowed host any case", "10.0.0.1:1234", "https", "Example.COM", "", "https://Example.COM"},
+		{"bad proto", "10.0.0.1:1234", "javascript", "example.com", "", ""},
+		{"bad host", "10.0.0.1:1234", "https", "evil.com/path", "", ""},
+		{"host with user", "10.0.0.1:1234", "https", "user@evil.com", "", ""},
+		{"bad prefix", "10.0.0.1:1234", "https", "example.com", "//evil.com", "https://example.com"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.RemoteAddr
+		for header, value := range map[string]string{"X-Forwarded-Proto": test.Proto, "X-Forwarded-Host": test.Host, "X-Forwarded-Prefix": test.Prefix} {
+			if len(value) != 0 {
+				r.Header.Set(header, value)
+			}
+		}
+
+		got, ok := ab.ForwardedRootURL(r)
+		if got != test.Want || ok != (len(test.Want) != 0) {
+			t.Errorf("%s: want %q, got %q (%t)", test.Name, test.Want, got, ok)
+		}
+	}
+}
+
+func TestForwardedLoadClientState(t *testing.T) {
+	t.Parallel()
+
+	ab := newForwardedAuthboss()
+
+	r := httptest.NewRequest("GET", "/auth/login", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "brand.test")
+	r.Header.Set("X-Forwarded-Prefix", "/app")
+
+	r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := ab.RequestConfig(r.Context()).Paths.RootURL; root != "https://brand.test/app" {
+		t.Error("the root url should be the forwarded one:", root)
+	}
+	if ab.Config.Paths.RootURL != "http://localhost:8080" {
+		t.Error("Authboss.Config should not change:", ab.Config.Paths.RootURL)
+	}
+
+	tests := map[string]string{
+		"/":                 "/app/",
+		"/auth/login?a=b":   "/app/auth/login?a=b",
+		"/app/dashboard":    "/app/dashboard",
+		"/application":      "/app/application",
+		"https://other.com": "https://other.com",
+	}
+	for redir, want := range tests {
+		if got := WithPathPrefix(r, redir); got != want {
+			t.Errorf("%s: want %s, got %s", redir, want, got)
+		}
+	}
+
+	direct, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config := ab.RequestConfig(direct.Context()); config != &ab.Config {
+		t.Error("requests that weren't forwarded should use Authboss.Config")
+	}
+	if got := WithPathPrefix(direct, "/"); got != "/" {
+		t.Error("there should be no prefix:", got)
+	}
+}
+
+func TestForwardedOptIn(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Paths.RootURL = "https://example.com"
+	ab.Config.Modules.TrustedProxies = []string{"10.0.0.0/8"}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-Host", "evil.com")
+	r.Header.Set("X-Forwarded-Prefix", "/app")
+
+	if got, ok := ab.ForwardedRootURL(r); ok {
+		t.Error("the forwarded headers should be ignored by default:", got)
+	}
+}
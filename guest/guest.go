@@ -0,0 +1,122 @@
+// Package guest gives visitors who aren't logged in a guest session with a
+// stable id, and hands it over to the account they register or log in
+// with (see authboss.EventAccountUpgrade).
+package guest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// SessionGuestKey is the session key that holds the guest id
+	SessionGuestKey = "guest_id"
+
+	nIDSize = 32
+)
+
+func init() {
+	authboss.RegisterModule("guest", &Guest{})
+}
+
+// Guest module
+type Guest struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the guest id can be kept in the session
+func (g *Guest) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Logger", "Storage.SessionState")
+}
+
+// Init module
+func (g *Guest) Init(ab *authboss.Authboss) error {
+	g.Authboss = ab
+
+	g.Events.After(authboss.EventRegister, g.Upgrade)
+	g.Events.After(authboss.EventOAuth2, g.Upgrade)
+	g.Events.After(authboss.EventAuth, g.Upgrade)
+
+	return nil
+}
+
+// Upgrade fires EventAccountUpgrade when the user that just registered or
+// logged in had a guest session, and removes the guest id from the
+// session. Registration happens in a transaction (see Authboss.InTx), so
+// when the storer supports them what the app moves to the user with
+// Authboss.Storer(ctx) is saved along with the user.
+func (g *Guest) Upgrade(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	id, ok := authboss.GetSession(r, SessionGuestKey)
+	if !ok || len(id) == 0 {
+		return false, nil
+	}
+
+	user, ok := r.Context().Value(authboss.CTXKeyUser).(authboss.User)
+	if !ok {
+		return false, nil
+	}
+
+	g.RequestLogger(r).Infof("guest %s upgraded to user %s", id, user.GetPID())
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyGuestID, id))
+	if _, err := g.Events.FireAfter(authboss.EventAccountUpgrade, w, r); err != nil {
+		return false, err
+	}
+
+	authboss.DelSession(w, SessionGuestKey)
+	return false, nil
+}
+
+// ID returns the guest id of the request, Middleware puts it in the
+// context and it's in the session for requests it didn't wrap. It's empty
+// when the visitor has no guest session.
+func ID(r *http.Request) string {
+	if id, ok := r.Context().Value(authboss.CTXKeyGuestID).(string); ok {
+		return id
+	}
+
+	id, _ := authboss.GetSession(r, SessionGuestKey)
+	return id
+}
+
+// Middleware gives visitors who aren't logged in a guest id, it's put in
+// the session the first time and in the context of every request (see
+// ID). It must come after LoadClientStateMiddleware.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pid, _ := ab.CurrentUserID(r); len(pid) != 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id, ok := authboss.GetSession(r, SessionGuestKey)
+			if !ok || len(id) == 0 {
+				var err error
+				if id, err = newID(); err != nil {
+					ab.RequestLogger(r).Errorf("failed to create guest id: %+v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				authboss.PutSession(w, SessionGuestKey, id)
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyGuestID, id))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func newID() (string, error) {
+	id := make([]byte, nIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", errors.Wrap(err, "failed to read random bytes")
+	}
+
+	return base64.URLEncoding.EncodeToString(id), nil
+}
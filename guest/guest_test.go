@@ -0,0 +1,141 @@
+package guest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	g := &Guest{}
+	if err := g.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []authboss.Event{authboss.EventRegister, authboss.EventOAuth2, authboss.EventAuth} {
+		if len(ab.Events.Hooks(e, authboss.EventAfter)) != 1 {
+			t.Errorf("%s should have an after hook", e)
+		}
+	}
+}
+
+type testHarness struct {
+	guest   *Guest
+	ab      *authboss.Authboss
+	session *mocks.ClientStateRW
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.session = mocks.NewClientRW()
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Storage.SessionState = harness.session
+
+	harness.guest = &Guest{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) serve(t *testing.T) string {
+	t.Helper()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("GET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id string
+	Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = ID(r)
+	})).ServeHTTP(w, r)
+	w.WriteHeader(http.StatusOK)
+
+	return id
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	id := h.serve(t)
+	if len(id) == 0 {
+		t.Fatal("the visitor should have been given a guest id")
+	}
+	if h.session.ClientValues[SessionGuestKey] != id {
+		t.Error("the guest id should be in the session")
+	}
+	if again := h.serve(t); again != id {
+		t.Error("the guest id should be stable:", id, again)
+	}
+
+	h.session.ClientValues = map[string]string{authboss.SessionKey: "test@test.com"}
+	if id := h.serve(t); len(id) != 0 {
+		t.Error("users who are logged in should not be guests:", id)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.session.ClientValues[SessionGuestKey] = "guest-id"
+
+	var gotID, gotPID string
+	h.ab.Events.After(authboss.EventAccountUpgrade, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		gotID = r.Context().Value(authboss.CTXKeyGuestID).(string)
+		gotPID = r.Context().Value(authboss.CTXKeyUser).(authboss.User).GetPID()
+		return false, nil
+	})
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, &mocks.User{Email: "test@test.com"}))
+
+	if handled, err := h.guest.Upgrade(w, r, false); err != nil || handled {
+		t.Fatal("upgrade should not handle the request:", handled, err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if gotID != "guest-id" || gotPID != "test@test.com" {
+		t.Error("the event should have the guest id and user:", gotID, gotPID)
+	}
+	if _, ok := h.session.ClientValues[SessionGuestKey]; ok {
+		t.Error("the guest id should have been removed from the session")
+	}
+}
+
+func TestUpgradeNoGuest(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Events.After(authboss.EventAccountUpgrade, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		t.Error("there was no guest to upgrade")
+		return false, nil
+	})
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, &mocks.User{Email: "test@test.com"}))
+
+	if _, err := h.guest.Upgrade(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+}
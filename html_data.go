@@ -24,6 +24,10 @@ const (
 	// the empty string ("") is used as a key in the map for those
 	// errors that couldn't be fit to a specific field.
 	DataValidation = "errors"
+	// DataErrCode is the ErrorCode of a failure, it goes with DataErr or
+	// DataValidation so API clients can tell failures apart without
+	// reading the messages. See APIErrorFromData.
+	DataErrCode = "error_code"
 	// DataPreserve preserves fields during large form exercises
 	// like user registration so we don't have to re-type safe
 	// information like addresses etc.
@@ -35,6 +39,31 @@ const (
 	// The bool is largely extraneous and can be ignored, if the module is
 	// loaded it will be present in the map, if not it will be missing.
 	DataModules = "modules"
+	// DataPIDField is the name of the form field the PID is entered in, it's
+	// only set when Config.Modules.PIDField is.
+	DataPIDField = "pid_field"
+	// DataChallenge is the challenge from the ChallengeVerifier the client
+	// must solve before submitting the form.
+	DataChallenge = "challenge"
+	// DataLocale is the locale of the request in e-mail data, see
+	// Authboss.Locale.
+	DataLocale = "locale"
+	// DataModule is the name of the module sending an e-mail in e-mail
+	// data, see EmailResponseOptions.Module.
+	DataModule = "module"
+	// DataPartial is true when the page is rendered for an HTMX or Turbo
+	// request that only needs part of it, so the template can leave out
+	// its layout.
+	DataPartial = "partial"
+	// DataImpersonator is the pid of the user impersonating the current
+	// user and DataImpersonating the pid of the user being impersonated,
+	// ImpersonationMiddleware sets them so the layout can show a banner.
+	DataImpersonator  = "impersonator"
+	DataImpersonating = "impersonating"
+	// DataCSRFToken is the CSRF token forms must post in the field named
+	// by DataCSRFField, see CSRFMiddleware.
+	DataCSRFToken = "csrf_token"
+	DataCSRFField = "csrf_field"
 )
 
 // HTMLData is used to render templates with.
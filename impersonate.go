@@ -0,0 +1,161 @@
+package authboss
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+)
+
+var (
+	// ErrImpersonating is returned by Impersonate when the session is
+	// already impersonating a user.
+	ErrImpersonating = errors.New("already impersonating a user")
+	// ErrNotImpersonating is returned by StopImpersonating when the session
+	// isn't impersonating a user.
+	ErrNotImpersonating = errors.New("not impersonating a user")
+)
+
+// ImpersonatorID returns the pid of the user who is impersonating the
+// current user, ok is false when nobody is.
+func ImpersonatorID(r *http.Request) (pid string, ok bool) {
+	pid, ok = GetSession(r, SessionImpersonator)
+	return pid, ok && len(pid) != 0
+}
+
+// Impersonate logs the current user in as the user pid for the following
+// requests, keeping their own pid in the session (SessionImpersonator) so
+// StopImpersonating can log them back in. Authboss doesn't decide who may
+// impersonate whom: protect the route calling this with something like
+// RequirePermissions and RequireFullAuth.
+//
+// ErrUserNotFound is returned when nobody is logged in or pid doesn't
+// exist. EventImpersonateStart is fired around the change and handled is
+// true when a before handler responded.
+func (a *Authboss) Impersonate(w http.ResponseWriter, r *http.Request, pid string) (handled bool, err error) {
+	if _, ok := ImpersonatorID(r); ok {
+		return false, ErrImpersonating
+	}
+
+	impersonatorPID, err := a.CurrentUserID(r)
+	if err != nil {
+		return false, err
+	} else if len(impersonatorPID) == 0 {
+		return false, ErrUserNotFound
+	}
+
+	impersonator, err := a.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+	user, err := a.LoadUser(r.Context(), pid)
+	if err != nil {
+		return false, err
+	}
+
+	r = r.WithContext(context.WithValue(context.WithValue(r.Context(), CTXKeyUser, user), CTXKeyImpersonator, impersonator))
+	handled, err = a.Events.FireBefore(EventImpersonateStart, w, r)
+	if err != nil || handled {
+		return handled, err
+	}
+
+	a.RequestLogger(r).Infof("user %s started impersonating %s", impersonatorPID, pid)
+	PutSession(w, SessionImpersonator, impersonatorPID)
+	PutSession(w, SessionKey, pid)
+
+	return a.Events.FireAfter(EventImpersonateStart, w, r)
+}
+
+// StopImpersonating logs the impersonator back in as themselves.
+// ErrNotImpersonating is returned when the session isn't impersonating
+// anyone. EventImpersonateEnd is fired around the change and handled is
+// true when a before handler responded.
+func (a *Authboss) StopImpersonating(w http.ResponseWriter, r *http.Request) (handled bool, err error) {
+	impersonatorPID, ok := ImpersonatorID(r)
+	if !ok {
+		return false, ErrNotImpersonating
+	}
+
+	ctx := r.Context()
+	if user, err := a.CurrentUser(r); err == nil {
+		ctx = context.WithValue(ctx, CTXKeyUser, user)
+	} else if err != ErrUserNotFound {
+		return false, err
+	}
+	if _, ok := ctx.Value(CTXKeyImpersonator).(User); !ok {
+		impersonator, err := a.LoadUser(ctx, impersonatorPID)
+		if err != nil {
+			return false, err
+		}
+		ctx = context.WithValue(ctx, CTXKeyImpersonator, impersonator)
+	}
+	r = r.WithContext(ctx)
+
+	handled, err = a.Events.FireBefore(EventImpersonateEnd, w, r)
+	if err != nil || handled {
+		return handled, err
+	}
+
+	pid, _ := GetSession(r, SessionKey)
+	a.RequestLogger(r).Infof("user %s stopped impersonating %s", impersonatorPID, pid)
+	PutSession(w, SessionKey, impersonatorPID)
+	DelSession(w, SessionImpersonator)
+
+	return a.Events.FireAfter(EventImpersonateEnd, w, r)
+}
+
+// ImpersonationMiddleware puts the impersonator in the request's context
+// (CTXKeyImpersonator) when the current user is being impersonated, and
+// both pids in the HTMLData (DataImpersonator and DataImpersonating) for a
+// banner. If the impersonator no longer exists the session is logged out
+// and redirected to the login page.
+func ImpersonationMiddleware(ab *Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			impersonatorPID, ok := ImpersonatorID(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+			impersonator, err := ab.LoadUser(r.Context(), impersonatorPID)
+			if err == ErrUserNotFound {
+				logger.Infof("impersonator %s no longer exists, logging out", impersonatorPID)
+				DelAllSession(w, ab.Config.Storage.SessionStateWhitelistKeys)
+				DelKnownSession(w)
+				DelSession(w, SessionImpersonator)
+
+				handled, err := ab.Events.FireAfter(EventSessionDestroy, w, r)
+				if err != nil {
+					logger.Errorf("failed to fire %s: %+v", EventSessionDestroy, err)
+				} else if handled {
+					return
+				}
+
+				ro := RedirectOptions{
+					Code:         http.StatusTemporaryRedirect,
+					Failure:      ab.Localize(ab.LocaleContext(r), TxtReLogin),
+					FailureCode:  ErrorCodeNotAuthorized,
+					RedirectPath: path.Join(ab.Config.Paths.Mount, "/login"),
+				}
+				if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+					logger.Errorf("failed to redirect during authboss.ImpersonationMiddleware: %+v", err)
+				}
+				return
+			} else if err != nil {
+				logger.Errorf("failed to load impersonator: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			pid, _ := GetSession(r, SessionKey)
+			r = r.WithContext(context.WithValue(r.Context(), CTXKeyImpersonator, impersonator))
+			MergeDataInRequest(&r, HTMLData{
+				DataImpersonator:  impersonatorPID,
+				DataImpersonating: pid,
+			})
+			next.ServeHTTP(w, r)
+		})
+	}
+}
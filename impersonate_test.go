@@ -0,0 +1,139 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testSetupImpersonate() (*Authboss, mockClientState) {
+	ab := New()
+	session := newMockClientStateRW(SessionKey, "admin@test.com")
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Storage.SessionState = session
+	ab.Config.Storage.Server = &mockServerStorer{
+		Users: map[string]*mockUser{
+			"admin@test.com": {Email: "admin@test.com"},
+			"test@test.com":  {Email: "test@test.com"},
+		},
+	}
+
+	return ab, session.state
+}
+
+func TestImpersonate(t *testing.T) {
+	t.Parallel()
+
+	ab, session := testSetupImpersonate()
+
+	var events []Event
+	for _, e := range []Event{EventImpersonateStart, EventImpersonateEnd} {
+		e := e
+		ab.Events.After(e, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			user := r.Context().Value(CTXKeyUser).(User)
+			impersonator := r.Context().Value(CTXKeyImpersonator).(User)
+			if user.GetPID() != "test@test.com" || impersonator.GetPID() != "admin@test.com" {
+				t.Error("users were wrong:", user.GetPID(), impersonator.GetPID())
+			}
+			events = append(events, e)
+			return false, nil
+		})
+	}
+
+	w := ab.NewResponse(httptest.NewRecorder())
+	r := loadClientStateP(ab, w, httptest.NewRequest("POST", "/", nil))
+	if _, err := ab.StopImpersonating(w, r); err != ErrNotImpersonating {
+		t.Error("expected ErrNotImpersonating:", err)
+	}
+	if _, err := ab.Impersonate(w, r, "nope@test.com"); err != ErrUserNotFound {
+		t.Error("expected ErrUserNotFound:", err)
+	}
+	if _, err := ab.Impersonate(w, r, "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if session[SessionKey] != "test@test.com" || session[SessionImpersonator] != "admin@test.com" {
+		t.Error("session was wrong:", session)
+	}
+
+	w = ab.NewResponse(httptest.NewRecorder())
+	r = loadClientStateP(ab, w, httptest.NewRequest("POST", "/", nil))
+	if _, err := ab.Impersonate(w, r, "admin@test.com"); err != ErrImpersonating {
+		t.Error("expected ErrImpersonating:", err)
+	}
+	if _, err := ab.StopImpersonating(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if _, ok := session[SessionImpersonator]; ok || session[SessionKey] != "admin@test.com" {
+		t.Error("session was wrong:", session)
+	}
+	if len(events) != 2 || events[0] != EventImpersonateStart || events[1] != EventImpersonateEnd {
+		t.Error("events were wrong:", events)
+	}
+}
+
+func TestImpersonateBeforeHandled(t *testing.T) {
+	t.Parallel()
+
+	ab, session := testSetupImpersonate()
+	ab.Events.Before(EventImpersonateStart, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		w.WriteHeader(http.StatusForbidden)
+		return true, nil
+	})
+
+	w := ab.NewResponse(httptest.NewRecorder())
+	r := loadClientStateP(ab, w, httptest.NewRequest("POST", "/", nil))
+	if handled, err := ab.Impersonate(w, r, "test@test.com"); err != nil || !handled {
+		t.Error("the before handler should have handled it:", handled, err)
+	}
+	if session[SessionKey] != "admin@test.com" {
+		t.Error("the session should not have changed:", session)
+	}
+}
+
+func TestImpersonationMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ab, session := testSetupImpersonate()
+	redirector := &testRedirector{}
+	ab.Config.Core.Redirector = redirector
+	session[SessionKey] = "test@test.com"
+	session[SessionImpersonator] = "admin@test.com"
+
+	serve := func() bool {
+		w := ab.NewResponse(httptest.NewRecorder())
+		r := loadClientStateP(ab, w, httptest.NewRequest("GET", "/", nil))
+
+		called := false
+		ImpersonationMiddleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			if user, ok := r.Context().Value(CTXKeyImpersonator).(User); !ok || user.GetPID() != "admin@test.com" {
+				t.Error("the impersonator should be in the context")
+			}
+			data := r.Context().Value(CTXKeyData).(HTMLData)
+			if data[DataImpersonator] != "admin@test.com" || data[DataImpersonating] != "test@test.com" {
+				t.Error("data was wrong:", data)
+			}
+		})).ServeHTTP(w, r)
+		w.WriteHeader(http.StatusOK)
+		return called
+	}
+
+	if !serve() {
+		t.Error("the handler should have been called")
+	}
+
+	delete(ab.Config.Storage.Server.(*mockServerStorer).Users, "admin@test.com")
+	if serve() {
+		t.Error("the handler should not be called once the impersonator is gone")
+	}
+	if _, ok := session[SessionKey]; ok {
+		t.Error("the session should have been logged out:", session)
+	}
+	if redirector.Opts.RedirectPath != "/auth/login" {
+		t.Error("redirect was wrong:", redirector.Opts.RedirectPath)
+	}
+}
@@ -0,0 +1,202 @@
+package authboss
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Introspection describes how authboss is set up, for operators to find
+// out why a module isn't doing what they expect. See Authboss.Introspect.
+type Introspection struct {
+	// Modules that are loaded
+	Modules []string `json:"modules"`
+	// Config is the effective configuration with secrets redacted.
+	// Interfaces are described by their type and functions by whether
+	// they're set.
+	Config map[string]interface{} `json:"config"`
+	// Routes that are mounted, if the Router implements RouteLister
+	Routes []string `json:"routes,omitempty"`
+	// Events lists the names of the handlers registered for each event
+	Events map[string]IntrospectedEvent `json:"events"`
+	// StorerCapabilities are the optional storer interfaces the
+	// ServerStorer implements
+	StorerCapabilities []string `json:"storer_capabilities"`
+}
+
+// IntrospectedEvent lists the handlers registered for an event
+type IntrospectedEvent struct {
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// redacted replaces secrets in the Introspection
+const redacted = "<redacted>"
+
+// storerCapabilities are the optional ServerStorer upgrades
+var storerCapabilities = []reflect.Type{
+	reflect.TypeOf((*CreatingServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*AnyCredentialServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*ConfirmingServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*RecoveringServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*RememberingServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*RememberingFamilyServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*RememberingDeviceServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*OAuth2ServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*OAuth2LinkServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*TokenServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*SessionServerStorer)(nil)).Elem(),
+	reflect.TypeOf((*KnownDeviceStorer)(nil)).Elem(),
+	reflect.TypeOf((*StatsServerStorer)(nil)).Elem(),
+}
+
+// Introspect describes the loaded modules, configuration, routes, event
+// handlers and storer capabilities. Secrets (fields whose name ends in Key
+// or contains Secret or Password, and byte slices) are redacted.
+func (a *Authboss) Introspect() Introspection {
+	in := Introspection{
+		Modules: a.LoadedModules(),
+		Config:  introspectValue("", reflect.ValueOf(a.Config)).(map[string]interface{}),
+		Events:  make(map[string]IntrospectedEvent),
+	}
+	sort.Strings(in.Modules)
+
+	if lister, ok := a.Config.Core.Router.(RouteLister); ok {
+		in.Routes = lister.Routes()
+	}
+
+	if a.Events != nil {
+		a.Events.mut.RLock()
+		for e, hooks := range a.Events.before {
+			ev := in.Events[e.String()]
+			ev.Before = handlerNames(hooks)
+			in.Events[e.String()] = ev
+		}
+		for e, hooks := range a.Events.after {
+			ev := in.Events[e.String()]
+			ev.After = handlerNames(hooks)
+			in.Events[e.String()] = ev
+		}
+		a.Events.mut.RUnlock()
+	}
+
+	if a.Config.Storage.Server != nil {
+		storerType := reflect.TypeOf(a.Config.Storage.Server)
+		for _, capability := range storerCapabilities {
+			if storerType.Implements(capability) {
+				in.StorerCapabilities = append(in.StorerCapabilities, capability.Name())
+			}
+		}
+	}
+
+	return in
+}
+
+// IntrospectHandler responds with Introspect as JSON. Requests that allow
+// returns false for are responded to with a 404, it must only allow
+// operators since the configuration reveals a lot about the application.
+// It's not mounted on the authboss router, mount it where it suits.
+func (a *Authboss) IntrospectHandler(allow func(r *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allow == nil || !allow(r) {
+			http.NotFound(w, r)
+			return
+		}
+
+		b, err := json.MarshalIndent(a.Introspect(), "", "  ")
+		if err != nil {
+			a.RequestLogger(r).Errorf("failed to marshal introspection: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	})
+}
+
+// introspectValue turns a config value into something that can be
+// marshalled to JSON without revealing secrets
+func introspectValue(name string, v reflect.Value) interface{} {
+	if isSecret(name) {
+		if v.IsZero() {
+			return nil
+		}
+		return redacted
+	}
+
+	switch v.Kind() {
+	case reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return nil
+		}
+		return "<set>"
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return fmt.Sprintf("%T", v.Interface())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return introspectValue(name, v.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if len(field.PkgPath) != 0 {
+				continue
+			}
+			fields[field.Name] = introspectValue(field.Name, v.Field(i))
+		}
+		return fields
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		entries := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = introspectValue(name, iter.Value())
+		}
+		return entries
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return introspectValue("Key", v)
+		}
+		if v.IsNil() {
+			return nil
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = introspectValue(name, v.Index(i))
+		}
+		return items
+	}
+
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return v.Interface()
+}
+
+func isSecret(name string) bool {
+	return strings.HasSuffix(name, "Key") || strings.Contains(name, "Secret") || strings.Contains(name, "Password")
+}
+
+func handlerNames(hooks []Hook) []string {
+	names := make([]string, len(hooks))
+	for i, h := range hooks {
+		names[i] = h.Name
+		if len(names[i]) == 0 {
+			names[i] = runtime.FuncForPC(reflect.ValueOf(h.Handler).Pointer()).Name()
+		}
+	}
+	return names
+}
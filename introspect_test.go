@@ -0,0 +1,92 @@
+package authboss
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type listingRouter struct {
+	Router
+}
+
+func (listingRouter) Routes() []string { return []string{"GET /login"} }
+
+func TestIntrospect(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Modules.RememberTokenKey = []byte("secret")
+	ab.Config.Modules.OAuth2Providers = map[string]OAuth2Provider{
+		"google": {PKCE: true},
+	}
+	ab.Config.Core.Router = listingRouter{}
+	ab.Config.Storage.Server = &mockServerStorer{}
+	ab.Events.After(EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return false, nil
+	})
+
+	in := ab.Introspect()
+
+	modules := in.Config["Modules"].(map[string]interface{})
+	if modules["RememberTokenKey"] != redacted {
+		t.Error("the key should be redacted:", modules["RememberTokenKey"])
+	}
+	if modules["LockDuration"] != (12 * time.Hour).String() {
+		t.Error("durations should be readable:", modules["LockDuration"])
+	}
+	if modules["ChallengeVerifier"] != nil {
+		t.Error("unset interfaces should be nil:", modules["ChallengeVerifier"])
+	}
+	provider := modules["OAuth2Providers"].(map[string]interface{})["google"].(map[string]interface{})
+	if provider["PKCE"] != true {
+		t.Error("providers should be described:", provider)
+	}
+	core := in.Config["Core"].(map[string]interface{})
+	if core["Router"] != "authboss.listingRouter" {
+		t.Error("interfaces should be described by type:", core["Router"])
+	}
+
+	if len(in.Routes) != 1 || in.Routes[0] != "GET /login" {
+		t.Error("routes were wrong:", in.Routes)
+	}
+	if after := in.Events[EventAuth.String()].After; len(after) != 1 || !strings.Contains(after[0], "TestIntrospect") {
+		t.Error("event handlers were wrong:", after)
+	}
+
+	capabilities := strings.Join(in.StorerCapabilities, ",")
+	if !strings.Contains(capabilities, "RememberingServerStorer") || strings.Contains(capabilities, "TokenServerStorer") {
+		t.Error("storer capabilities were wrong:", capabilities)
+	}
+}
+
+func TestIntrospectHandler(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	handler := ab.IntrospectHandler(func(r *http.Request) bool {
+		return r.Header.Get("X-Operator") == "yes"
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Error("should not be allowed:", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Operator", "yes")
+	handler.ServeHTTP(rec, r)
+
+	var in Introspection
+	if err := json.Unmarshal(rec.Body.Bytes(), &in); err != nil {
+		t.Fatal(err)
+	}
+	if in.Config["Paths"].(map[string]interface{})["Mount"] != "/auth" {
+		t.Error("the config should be in the response:", rec.Body.String())
+	}
+}
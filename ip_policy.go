@@ -0,0 +1,176 @@
+package authboss
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// IPPolicy allows or denies requests by the address of the client, see
+// Authboss.ClientIP. Networks are CIDRs like "10.0.0.0/8" or single
+// addresses. The zero value allows every request.
+type IPPolicy struct {
+	// Allow if set are the only networks requests are allowed from
+	Allow []string
+	// Deny are networks requests are never allowed from, even if they're
+	// in Allow
+	Deny []string
+	// Decide if set makes the final decision, it's given the decision of
+	// the lists. It's for decisions that can't be made with a list, like
+	// looking the address up in a reputation service. ip is nil if the
+	// client's address couldn't be parsed.
+	Decide func(r *http.Request, ip net.IP, allowed bool) bool
+}
+
+// Allows reports whether the policy allows the request from ip. Requests
+// from an address that couldn't be parsed (nil) are only allowed by the
+// lists if there's no Allow list.
+func (p IPPolicy) Allows(r *http.Request, ip net.IP) bool {
+	allowed := len(p.Allow) == 0 || (ip != nil && inNetworks(ip, p.Allow))
+	if allowed && ip != nil && inNetworks(ip, p.Deny) {
+		allowed = false
+	}
+
+	if p.Decide != nil {
+		allowed = p.Decide(r, ip, allowed)
+	}
+	return allowed
+}
+
+func (p IPPolicy) empty() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0 && p.Decide == nil
+}
+
+func (p IPPolicy) problems(field string) []ConfigProblem {
+	problems := networkProblems(field+".Allow", p.Allow)
+	return append(problems, networkProblems(field+".Deny", p.Deny)...)
+}
+
+// IPPolicyGuard rejects the requests to the handler that the route's
+// policy doesn't allow, route is the route's path under the mount (like
+// "/login"). The route's policy is the one in
+// Config.Modules.RouteIPPolicies, or Config.Modules.IPPolicy if it has
+// none, when this is called. If it's empty the handler is returned as is.
+//
+// The auth, register and recover modules guard their routes with it.
+func (a *Authboss) IPPolicyGuard(route string, handler http.Handler) http.Handler {
+	policy, ok := a.Config.Modules.RouteIPPolicies[route]
+	if !ok {
+		policy = a.Config.Modules.IPPolicy
+	}
+	if policy.empty() {
+		return handler
+	}
+
+	return IPPolicyMiddleware(a, policy)(handler)
+}
+
+// IPPolicyMiddleware rejects the requests that the policy doesn't allow,
+// for the app's own routes. They're redirected to Paths.NotAuthorized with
+// a failure (ErrorCodeForbidden), the defaults' Redirector responds to API
+// requests with a 403 and the failure as JSON instead.
+func IPPolicyMiddleware(ab *Authboss, policy IPPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := ab.ClientIP(r)
+			if policy.Allows(r, net.ParseIP(addr)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+			logger.Infof("rejected %s %s from %s by the ip policy", r.Method, r.URL.Path, addr)
+
+			ro := RedirectOptions{
+				Code:         http.StatusForbidden,
+				RedirectPath: ab.RequestConfig(r.Context()).Paths.NotAuthorized,
+				Failure:      ab.Localize(ab.LocaleContext(r), TxtNetworkDenied),
+				FailureCode:  ErrorCodeForbidden,
+			}
+			if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+				logger.Errorf("failed to redirect after an ip policy rejection: %+v", err)
+			}
+		})
+	}
+}
+
+// ClientIP is the address of the client that made the request. It's the
+// host of the request's RemoteAddr unless that's one of
+// Config.Modules.TrustedProxies, then it's the last address in the
+// Config.Modules.ClientIPHeader header that isn't a trusted proxy. Each
+// proxy appends the address it got the request from to the header, so
+// the addresses before the last untrusted one could be made up by the
+// client and are ignored.
+//
+// The modules use it for the addresses they record and match, so proxies
+// only need to be configured here.
+func (a *Authboss) ClientIP(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	trusted := a.Config.Modules.TrustedProxies
+	if ip := net.ParseIP(client); ip == nil || !inNetworks(ip, trusted) {
+		return client
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values(a.Config.Modules.ClientIPHeader) {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+
+		client = ip.String()
+		if !inNetworks(ip, trusted) {
+			break
+		}
+	}
+
+	return client
+}
+
+// parseNetwork parses a CIDR or a single address
+func parseNetwork(network string) (*net.IPNet, error) {
+	if strings.Contains(network, "/") {
+		_, ipNet, err := net.ParseCIDR(network)
+		return ipNet, err
+	}
+
+	ip := net.ParseIP(network)
+	if ip == nil {
+		return nil, errors.Errorf("invalid address %q", network)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// inNetworks ignores the networks that don't parse, they're reported by
+// the config validation
+func inNetworks(ip net.IP, networks []string) bool {
+	for _, network := range networks {
+		if ipNet, err := parseNetwork(network); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func networkProblems(field string, networks []string) []ConfigProblem {
+	var problems []ConfigProblem
+	for _, network := range networks {
+		if _, err := parseNetwork(network); err != nil {
+			problems = append(problems, ConfigProblem{Field: field, Problem: "(" + network + ") must be a CIDR or an ip address"})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,142 @@
+package authboss
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPPolicyAllows(t *testing.T) {
+	t.Parallel()
+
+	policy := IPPolicy{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"},
+		Deny:  []string{"10.1.0.0/16"},
+	}
+
+	tests := map[string]bool{
+		"10.2.3.4":    true,
+		"10.1.2.3":    false,
+		"192.0.2.7":   true,
+		"192.0.2.8":   false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+		"not an ip":   false,
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	for addr, want := range tests {
+		if got := policy.Allows(r, net.ParseIP(addr)); got != want {
+			t.Errorf("%s: want %t, got %t", addr, want, got)
+		}
+	}
+
+	if !(IPPolicy{Deny: []string{"10.0.0.0/8"}}).Allows(r, nil) {
+		t.Error("an unknown address should be allowed without an allow list")
+	}
+
+	policy.Decide = func(r *http.Request, ip net.IP, allowed bool) bool {
+		return allowed || ip.Equal(net.ParseIP("192.0.2.8"))
+	}
+	if !policy.Allows(r, net.ParseIP("192.0.2.8")) || policy.Allows(r, net.ParseIP("10.1.2.3")) {
+		t.Error("the callback should make the final decision")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Modules.TrustedProxies = []string{"10.0.0.0/8", "::1"}
+
+	tests := []struct {
+		Name       string
+		RemoteAddr string
+		Forwarded  []string
+		Want       string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted forwarder", "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hops", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"several headers", "[::1]:1234", []string{"203.0.113.9", "198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage hop", "10.0.0.1:1234", []string{"198.51.100.1, nope, 10.0.0.2"}, "10.0.0.2"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"no port", "192.0.2.1", nil, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.RemoteAddr
+		for _, value := range test.Forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+
+		if got := ab.ClientIP(r); got != test.Want {
+			t.Errorf("%s: want %s, got %s", test.Name, test.Want, got)
+		}
+	}
+}
+
+func TestIPPolicyGuard(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	redirector := &testRedirector{}
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.Redirector = redirector
+	ab.Config.Paths.NotAuthorized = "/denied"
+	ab.Config.Modules.IPPolicy = IPPolicy{Deny: []string{"192.0.2.0/24"}}
+	ab.Config.Modules.RouteIPPolicies = map[string]IPPolicy{
+		"/register": {Allow: []string{"10.0.0.0/8"}},
+		"/recover":  {},
+	}
+
+	called := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })
+
+	serve := func(route, addr string) int {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", route, nil)
+		r.RemoteAddr = addr + ":1234"
+		ab.IPPolicyGuard(route, handler).ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if serve("/login", "198.51.100.1"); called != 1 {
+		t.Error("the login should be allowed")
+	}
+	if code := serve("/login", "192.0.2.1"); code != http.StatusForbidden || called != 1 {
+		t.Error("the denied network should be rejected:", code)
+	}
+	if redirector.Opts.RedirectPath != "/denied" || redirector.Opts.FailureCode != ErrorCodeForbidden {
+		t.Errorf("redirect was wrong: %#v", redirector.Opts)
+	}
+
+	if serve("/register", "198.51.100.1"); called != 1 {
+		t.Error("the route's own policy should be used")
+	}
+	if serve("/register", "10.0.0.1"); called != 2 {
+		t.Error("the route's allow list should be allowed")
+	}
+	if serve("/recover", "192.0.2.1"); called != 3 {
+		t.Error("an empty route policy should allow everything")
+	}
+}
+
+func TestIPPolicyConfig(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Modules.IPPolicy = IPPolicy{Allow: []string{"10.0.0.0/8", "10.0.0.0/33"}, Deny: []string{"nope"}}
+	ab.Config.Modules.RouteIPPolicies = map[string]IPPolicy{"/login": {Deny: []string{"1.2.3"}}}
+	ab.Config.Modules.TrustedProxies = []string{"::1", "localhost"}
+
+	if err := ab.validateConfig(nil); err == nil {
+		t.Fatal("the invalid networks should be problems")
+	} else if problems := err.(ConfigError); len(problems) != 4 {
+		t.Error("there should be a problem for each invalid network:", problems)
+	}
+}
@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ErrKeyNotFound is returned when a key id is not present in a KeySet
+var ErrKeyNotFound = errors.New("jwt: key not found in key set")
+
+// JWK is a single JSON Web Key (RFC 7517), only the fields needed
+// for RSA and EC public keys are present.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the JWK into an *rsa.PublicKey or *ecdsa.PublicKey
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode rsa modulus")
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode rsa exponent")
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if j.Curve != "P-256" {
+			return nil, errors.Errorf("unsupported ec curve: %s", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode ec x coordinate")
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode ec y coordinate")
+		}
+
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec public key is not on the curve")
+		}
+		return pub, nil
+	}
+
+	return nil, errors.Errorf("unsupported key type: %s", j.KeyType)
+}
+
+// NewRSAJWK creates a JWK from an rsa public key
+func NewRSAJWK(kid string, pub *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: RS256,
+		N:         encode(pub.N.Bytes()),
+		E:         encode(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+// NewECJWK creates a JWK from a P-256 ecdsa public key
+func NewECJWK(kid string, pub *ecdsa.PublicKey) JWK {
+	x, y := make([]byte, 32), make([]byte, 32)
+	xBytes, yBytes := pub.X.Bytes(), pub.Y.Bytes()
+	copy(x[32-len(xBytes):], xBytes)
+	copy(y[32-len(yBytes):], yBytes)
+
+	return JWK{
+		KeyType:   "EC",
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: ES256,
+		Curve:     "P-256",
+		X:         encode(x),
+		Y:         encode(y),
+	}
+}
+
+// JWKS is a JSON Web Key Set document
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet is a remote JSON Web Key Set, typically published by an OpenID
+// Connect provider. Keys are fetched lazily and re-fetched when a token
+// with an unknown key id is encountered (no more often than MinRefresh)
+// or when MaxAge has elapsed.
+//
+// It's safe for concurrent use.
+type KeySet struct {
+	URL    string
+	Client *http.Client
+
+	// MinRefresh is the minimum time between fetches caused by unknown
+	// key ids, this prevents hammering the provider with bad tokens.
+	MinRefresh time.Duration
+	// MaxAge is how long fetched keys are trusted before fetching them again.
+	MaxAge time.Duration
+
+	mut     sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewKeySet creates a key set for the url with sensible refresh defaults
+func NewKeySet(url string) *KeySet {
+	return &KeySet{
+		URL:        url,
+		MinRefresh: time.Minute,
+		MaxAge:     24 * time.Hour,
+	}
+}
+
+// for testing
+var nowTime = time.Now
+
+// Key finds a key by its key id, fetching the key set if required.
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mut.Lock()
+	defer k.mut.Unlock()
+
+	now := nowTime()
+	if k.keys == nil || (k.MaxAge > 0 && now.Sub(k.fetched) > k.MaxAge) {
+		if err := k.fetch(ctx, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := k.find(kid); ok {
+		return key, nil
+	}
+
+	// Providers rotate keys, so an unknown kid may mean our copy is stale
+	if now.Sub(k.fetched) < k.MinRefresh {
+		return nil, ErrKeyNotFound
+	}
+	if err := k.fetch(ctx, now); err != nil {
+		return nil, err
+	}
+
+	if key, ok := k.find(kid); ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// KeyFunc creates a KeyFunc for use with Parse
+func (k *KeySet) KeyFunc(ctx context.Context) KeyFunc {
+	return func(h Header) (crypto.PublicKey, error) {
+		return k.Key(ctx, h.KeyID)
+	}
+}
+
+func (k *KeySet) find(kid string) (crypto.PublicKey, bool) {
+	if len(kid) == 0 && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *KeySet) fetch(ctx context.Context, now time.Time) error {
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodGet, k.URL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch jwks")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to fetch jwks, status: %d", resp.StatusCode)
+	}
+
+	byt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read jwks body")
+	}
+
+	var set JWKS
+	if err = json.Unmarshal(byt, &set); err != nil {
+		return errors.Wrap(err, "failed to parse jwks")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) != 0 && jwk.Use != "sig" {
+			continue
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			// Skip keys we don't understand rather than failing them all
+			continue
+		}
+		keys[jwk.KeyID] = pub
+	}
+
+	k.keys = keys
+	k.fetched = now
+	return nil
+}
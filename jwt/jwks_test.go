@@ -0,0 +1,97 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := NewRSAJWK("r", &rsaKey.PublicKey).PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pub.(*rsa.PublicKey); got.N.Cmp(rsaKey.N) != 0 || got.E != rsaKey.E {
+		t.Error("rsa key did not round trip")
+	}
+
+	pub, err = NewECJWK("e", &ecKey.PublicKey).PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pub.(*ecdsa.PublicKey); got.X.Cmp(ecKey.X) != 0 || got.Y.Cmp(ecKey.Y) != 0 {
+		t.Error("ec key did not round trip")
+	}
+
+	if _, err = (JWK{KeyType: "oct"}).PublicKey(); err == nil {
+		t.Error("expected an error for unsupported key types")
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_ = json.NewEncoder(w).Encode(JWKS{Keys: []JWK{NewRSAJWK("kid", &rsaKey.PublicKey)}})
+	}))
+	defer server.Close()
+
+	set := NewKeySet(server.URL)
+
+	token, err := Sign(Claims{"sub": "pid"}, RSASigner{Key: rsaKey, KID: "kid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, _, err = Parse(token, set.KeyFunc(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Parse(token, set.KeyFunc(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Error("keys should have been cached, fetches:", n)
+	}
+
+	// An unknown key id within MinRefresh should not cause another fetch
+	if _, err = set.Key(ctx, "unknown"); err != ErrKeyNotFound {
+		t.Error("wrong error:", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Error("unknown kid should not refetch so soon, fetches:", n)
+	}
+
+	set.MinRefresh = 0
+	if _, err = set.Key(ctx, "unknown"); err != ErrKeyNotFound {
+		t.Error("wrong error:", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Error("unknown kid should refetch, fetches:", n)
+	}
+}
@@ -0,0 +1,426 @@
+// Package jwt implements the small subset of JSON Web Tokens (RFC 7519)
+// and JSON Web Signatures (RFC 7515) that authboss needs to verify tokens
+// from OAuth2/OpenID Connect providers and to sign tokens of its own.
+//
+// Only the HS256, RS256 and ES256 algorithms are supported, the "none"
+// algorithm is always rejected.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	// ErrMalformed is returned when a token cannot be decoded
+	ErrMalformed = errors.New("jwt: malformed token")
+	// ErrSignature is returned when a token's signature does not verify
+	ErrSignature = errors.New("jwt: invalid signature")
+	// ErrAlgorithm is returned when a token uses an unsupported algorithm or
+	// one that does not match the key used to verify it.
+	ErrAlgorithm = errors.New("jwt: unsupported or mismatched algorithm")
+	// ErrExpired is returned when the exp claim is in the past
+	ErrExpired = errors.New("jwt: token expired")
+	// ErrNotYetValid is returned when the nbf or iat claim is in the future
+	ErrNotYetValid = errors.New("jwt: token not yet valid")
+	// ErrIssuer is returned when the iss claim is not an accepted issuer
+	ErrIssuer = errors.New("jwt: invalid issuer")
+	// ErrAudience is returned when the aud claim has no accepted audience
+	ErrAudience = errors.New("jwt: invalid audience")
+)
+
+// Header of a token
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Claims of a token, the registered claim names can be retrieved with the
+// helper methods.
+type Claims map[string]interface{}
+
+// String returns the claim as a string, or the empty string if it's not
+// present or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Time returns a NumericDate claim (exp, nbf, iat) as a time.
+func (c Claims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0).UTC(), true
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(i, 0).UTC(), true
+	case int64:
+		return time.Unix(v, 0).UTC(), true
+	case int:
+		return time.Unix(int64(v), 0).UTC(), true
+	}
+
+	return time.Time{}, false
+}
+
+// Subject returns the sub claim
+func (c Claims) Subject() string { return c.String("sub") }
+
+// Issuer returns the iss claim
+func (c Claims) Issuer() string { return c.String("iss") }
+
+// Audience returns the aud claim, which may either be a single string
+// or an array of strings in the token.
+func (c Claims) Audience() []string {
+	switch v := c["aud"].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		auds := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	}
+
+	return nil
+}
+
+// Expectations describe how Validate checks the registered claims.
+// Empty fields are not checked.
+type Expectations struct {
+	// Issuers that are accepted
+	Issuers []string
+	// Audiences that are accepted, at least one of the token's audiences
+	// must be in this list.
+	Audiences []string
+	// Leeway allowed when checking the time based claims to account for
+	// clock skew.
+	Leeway time.Duration
+}
+
+// Validate the registered claims of the token against now and the
+// given expectations. exp is always required.
+func (c Claims) Validate(now time.Time, e Expectations) error {
+	exp, ok := c.Time("exp")
+	if !ok {
+		return errors.Wrap(ErrMalformed, "missing exp claim")
+	}
+	if !now.Before(exp.Add(e.Leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(e.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(e.Leeway).Before(iat) {
+		return ErrNotYetValid
+	}
+
+	if len(e.Issuers) != 0 && !contains(e.Issuers, c.Issuer()) {
+		return ErrIssuer
+	}
+
+	if len(e.Audiences) != 0 {
+		found := false
+		for _, aud := range c.Audience() {
+			if contains(e.Audiences, aud) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrAudience
+		}
+	}
+
+	return nil
+}
+
+// Signer signs tokens
+type Signer interface {
+	// Algorithm is the alg header value
+	Algorithm() string
+	// KeyID is the kid header value, may be empty
+	KeyID() string
+	// Sign the signing input (base64 header + "." + base64 claims)
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+// KeyFunc returns the key to verify a token with given its header.
+// The key must be a []byte for HS256, an *rsa.PublicKey for RS256 or
+// an *ecdsa.PublicKey for ES256.
+type KeyFunc func(Header) (crypto.PublicKey, error)
+
+// Sign the claims creating a compact serialized token
+func Sign(claims Claims, signer Signer) (string, error) {
+	header := Header{Algorithm: signer.Algorithm(), Type: "JWT", KeyID: signer.KeyID()}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode jwt header")
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode jwt claims")
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(encode(headerJSON))
+	buf.WriteByte('.')
+	buf.WriteString(encode(claimsJSON))
+
+	sig, err := signer.Sign(buf.Bytes())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign jwt")
+	}
+
+	buf.WriteByte('.')
+	buf.WriteString(encode(sig))
+	return buf.String(), nil
+}
+
+// Parse a token and verify its signature with the key returned from keyFn.
+// It does not validate any claims, see Claims.Validate for that.
+func Parse(token string, keyFn KeyFunc) (Header, Claims, error) {
+	parts, header, err := split(token)
+	if err != nil {
+		return header, nil, err
+	}
+
+	sig, err := decode(parts[2])
+	if err != nil {
+		return header, nil, ErrMalformed
+	}
+
+	key, err := keyFn(header)
+	if err != nil {
+		return header, nil, err
+	}
+
+	signingInput := []byte(token[:len(parts[0])+1+len(parts[1])])
+	if err = verify(header.Algorithm, key, signingInput, sig); err != nil {
+		return header, nil, err
+	}
+
+	claims, err := decodeClaims(parts[1])
+	return header, claims, err
+}
+
+// ParseUnverified reads a token without verifying its signature. It's only
+// for tokens received straight from their issuer over TLS, like the ID
+// token in an OpenID Connect token response.
+func ParseUnverified(token string) (Header, Claims, error) {
+	parts, header, err := split(token)
+	if err != nil {
+		return header, nil, err
+	}
+
+	claims, err := decodeClaims(parts[1])
+	return header, claims, err
+}
+
+// split the token into its parts and decode its header
+func split(token string) ([]string, Header, error) {
+	var header Header
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, header, ErrMalformed
+	}
+
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return nil, header, ErrMalformed
+	}
+	if err = json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, header, ErrMalformed
+	}
+
+	return parts, header, nil
+}
+
+func decodeClaims(part string) (Claims, error) {
+	claimsJSON, err := decode(part)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var claims Claims
+	dec := json.NewDecoder(bytes.NewReader(claimsJSON))
+	if err = dec.Decode(&claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	return claims, nil
+}
+
+func verify(alg string, key crypto.PublicKey, signingInput, sig []byte) error {
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrAlgorithm
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrAlgorithm
+		}
+		sum := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+			return ErrSignature
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrAlgorithm
+		}
+		if len(sig) != 64 {
+			return ErrSignature
+		}
+		sum := sha256.Sum256(signingInput)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		return ErrAlgorithm
+	}
+
+	return nil
+}
+
+// HMACSigner signs tokens using HS256
+type HMACSigner struct {
+	Key []byte
+	KID string
+}
+
+// Algorithm is HS256
+func (h HMACSigner) Algorithm() string { return HS256 }
+
+// KeyID of the key
+func (h HMACSigner) KeyID() string { return h.KID }
+
+// Sign the input
+func (h HMACSigner) Sign(signingInput []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.Key)
+	mac.Write(signingInput)
+	return mac.Sum(nil), nil
+}
+
+// RSASigner signs tokens using RS256
+type RSASigner struct {
+	Key *rsa.PrivateKey
+	KID string
+}
+
+// Algorithm is RS256
+func (r RSASigner) Algorithm() string { return RS256 }
+
+// KeyID of the key
+func (r RSASigner) KeyID() string { return r.KID }
+
+// Sign the input
+func (r RSASigner) Sign(signingInput []byte) ([]byte, error) {
+	sum := sha256.Sum256(signingInput)
+	return rsa.SignPKCS1v15(rand.Reader, r.Key, crypto.SHA256, sum[:])
+}
+
+// ECDSASigner signs tokens using ES256, the key must use the P-256 curve.
+type ECDSASigner struct {
+	Key *ecdsa.PrivateKey
+	KID string
+}
+
+// Algorithm is ES256
+func (e ECDSASigner) Algorithm() string { return ES256 }
+
+// KeyID of the key
+func (e ECDSASigner) KeyID() string { return e.KID }
+
+// Sign the input
+func (e ECDSASigner) Sign(signingInput []byte) ([]byte, error) {
+	sum := sha256.Sum256(signingInput)
+	r, s, err := ecdsa.Sign(rand.Reader, e.Key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// JWS uses the fixed width R || S form rather than ASN.1
+	sig := make([]byte, 64)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rBytes):32], rBytes)
+	copy(sig[64-len(sBytes):], sBytes)
+	return sig, nil
+}
+
+// StaticKey returns a KeyFunc that always uses the same key
+func StaticKey(key crypto.PublicKey) KeyFunc {
+	return func(Header) (crypto.PublicKey, error) {
+		return key, nil
+	}
+}
+
+// KeyIDs returns a KeyFunc that picks the key by the token's kid, which
+// allows keys to be rotated: sign with the new key and keep the old one
+// until the tokens signed with it have expired.
+func KeyIDs(keys map[string]crypto.PublicKey) KeyFunc {
+	return func(h Header) (crypto.PublicKey, error) {
+		key, ok := keys[h.KeyID]
+		if !ok {
+			return nil, errors.Wrapf(ErrSignature, "unknown kid %q", h.KeyID)
+		}
+		return key, nil
+	}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func contains(arr []string, s string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+func TestSignParse(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name   string
+		Signer Signer
+		Key    interface{}
+	}{
+		{"HS256", HMACSigner{Key: []byte("secret"), KID: "h"}, []byte("secret")},
+		{"RS256", RSASigner{Key: rsaKey, KID: "r"}, &rsaKey.PublicKey},
+		{"ES256", ECDSASigner{Key: ecKey, KID: "e"}, &ecKey.PublicKey},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := Sign(Claims{"sub": "pid", "exp": 10}, test.Signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header, claims, err := Parse(token, StaticKey(test.Key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Algorithm != test.Name {
+				t.Error("alg was wrong:", header.Algorithm)
+			}
+			if header.KeyID != test.Signer.KeyID() {
+				t.Error("kid was wrong:", header.KeyID)
+			}
+			if claims.Subject() != "pid" {
+				t.Error("sub was wrong:", claims.Subject())
+			}
+
+			tampered := token[:len(token)-4] + "AAAA"
+			if _, _, err = Parse(tampered, StaticKey(test.Key)); err != ErrSignature && err != ErrMalformed {
+				t.Error("tampered signature should fail:", err)
+			}
+		})
+	}
+}
+
+func TestParseAlgorithmMismatch(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Classic alg confusion, an HS256 token signed with the public key
+	token, err := Sign(Claims{"exp": 10}, HMACSigner{Key: rsaKey.PublicKey.N.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Parse(token, StaticKey(&rsaKey.PublicKey)); err != ErrAlgorithm {
+		t.Error("it should reject mismatched algorithms:", err)
+	}
+
+	none := encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(`{"exp":10}`)) + "."
+	if _, _, err = Parse(none, StaticKey([]byte("x"))); err != ErrAlgorithm {
+		t.Error("it should reject the none algorithm:", err)
+	}
+
+	if _, _, err = Parse("a.b", StaticKey([]byte("x"))); err != ErrMalformed {
+		t.Error("it should reject malformed tokens:", err)
+	}
+}
+
+func TestParseUnverified(t *testing.T) {
+	t.Parallel()
+
+	token, err := Sign(Claims{"sub": "pid"}, HMACSigner{Key: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, claims, err := ParseUnverified(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject() != "pid" {
+		t.Error("sub was wrong:", claims.Subject())
+	}
+
+	if _, _, err = ParseUnverified("a.b"); err != ErrMalformed {
+		t.Error("want a malformed token error:", err)
+	}
+}
+
+func TestClaimsValidate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	claims := Claims{
+		"iss": "https://issuer",
+		"aud": []interface{}{"one", "two"},
+		"exp": float64(now.Add(time.Minute).Unix()),
+		"iat": float64(now.Unix()),
+	}
+
+	if err := claims.Validate(now, Expectations{Issuers: []string{"https://issuer"}, Audiences: []string{"two"}}); err != nil {
+		t.Error(err)
+	}
+	if err := claims.Validate(now, Expectations{Issuers: []string{"bad"}}); err != ErrIssuer {
+		t.Error("wrong error:", err)
+	}
+	if err := claims.Validate(now, Expectations{Audiences: []string{"three"}}); err != ErrAudience {
+		t.Error("wrong error:", err)
+	}
+	if err := claims.Validate(now.Add(time.Hour), Expectations{}); err != ErrExpired {
+		t.Error("wrong error:", err)
+	}
+	if err := claims.Validate(now.Add(-time.Hour), Expectations{}); err != ErrNotYetValid {
+		t.Error("wrong error:", err)
+	}
+	if err := claims.Validate(now.Add(61*time.Second), Expectations{Leeway: 5 * time.Second}); err != nil {
+		t.Error("leeway should have allowed it:", err)
+	}
+
+	if err := (Claims{}).Validate(now, Expectations{}); err == nil || !strings.Contains(err.Error(), "exp") {
+		t.Error("exp should be required:", err)
+	}
+}
+
+func TestClaimsAudience(t *testing.T) {
+	t.Parallel()
+
+	if aud := (Claims{"aud": "one"}).Audience(); len(aud) != 1 || aud[0] != "one" {
+		t.Error("wrong audience:", aud)
+	}
+	if aud := (Claims{"aud": []interface{}{"one", "two"}}).Audience(); len(aud) != 2 || aud[1] != "two" {
+		t.Error("wrong audience:", aud)
+	}
+	if aud := (Claims{}).Audience(); aud != nil {
+		t.Error("wrong audience:", aud)
+	}
+}
+
+func TestKeyIDs(t *testing.T) {
+	t.Parallel()
+
+	keys := KeyIDs(map[string]crypto.PublicKey{"old": []byte("old secret"), "new": []byte("new secret")})
+
+	for _, signer := range []HMACSigner{{Key: []byte("old secret"), KID: "old"}, {Key: []byte("new secret"), KID: "new"}} {
+		token, err := Sign(Claims{"sub": "test"}, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = Parse(token, keys); err != nil {
+			t.Errorf("%s: %v", signer.KID, err)
+		}
+	}
+
+	token, err := Sign(Claims{"sub": "test"}, HMACSigner{Key: []byte("old secret"), KID: "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Parse(token, keys); errors.Cause(err) != ErrSignature {
+		t.Error("an unknown kid should fail:", err)
+	}
+}
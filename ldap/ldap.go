@@ -0,0 +1,353 @@
+// Package ldap is an authboss.ExternalAuthenticator that checks passwords
+// by binding to an LDAP server, like Active Directory, as the user. Set it
+// as Config.Core.ExternalAuthenticator to log users in with their
+// corporate directory password, no password hashes are stored.
+//
+// Only the simple bind and StartTLS operations of LDAPv3 are implemented,
+// which is all checking a password takes.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// DefaultTimeout is how long checking a password may take when
+	// Authenticator.Timeout isn't set
+	DefaultTimeout = 10 * time.Second
+
+	// The result codes of RFC 4511 that matter here
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+
+	startTLSOID    = "1.3.6.1.4.1.1466.20037"
+	maxMessageSize = 1 << 20
+)
+
+// Authenticator checks passwords with an LDAP simple bind as the user
+type Authenticator struct {
+	// URL of the server, ldap://host:389 or ldaps://host:636
+	URL string
+	// BindDN is the name the user binds as, %s is replaced with their pid
+	// escaped for use in a DN. For example "uid=%s,ou=people,dc=example,dc=com"
+	// or "%s@corp.example.com" for Active Directory's user principal names.
+	BindDN string
+
+	// StartTLS upgrades ldap:// connections to TLS before binding, without
+	// it (or ldaps://) passwords are sent in the clear.
+	StartTLS bool
+	// TLSConfig is used for ldaps:// and StartTLS, if it's nil the server's
+	// certificate is verified against the system roots.
+	TLSConfig *tls.Config
+
+	// Timeout is how long checking a password may take, it's
+	// DefaultTimeout when zero.
+	Timeout time.Duration
+}
+
+// Authenticate binds as the user with their password, it returns
+// authboss.ErrInvalidCredentials when the server rejects it.
+func (a *Authenticator) Authenticate(ctx context.Context, pid, password string) error {
+	// An empty password is an unauthenticated bind that most servers
+	// accept for any name, it must never log anyone in.
+	if len(password) == 0 {
+		return authboss.ErrInvalidCredentials
+	}
+
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	code, message, err := conn.bind(fmt.Sprintf(a.BindDN, EscapeDN(pid)), password)
+	if err != nil {
+		return err
+	}
+	conn.unbind()
+
+	switch code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCredentials:
+		return authboss.ErrInvalidCredentials
+	default:
+		return errors.Errorf("ldap bind failed with result %d: %s", code, message)
+	}
+}
+
+// EscapeDN escapes a value for use in a distinguished name (RFC 4514)
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`"+,;<=>\`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+type conn struct {
+	net.Conn
+	r  *bufio.Reader
+	id int
+}
+
+func (a *Authenticator) dial(ctx context.Context) (*conn, error) {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse ldap url")
+	}
+
+	host, port := u.Host, "389"
+	if u.Scheme == "ldaps" {
+		port = "636"
+	} else if u.Scheme != "ldap" {
+		return nil, errors.Errorf("ldap url must be ldap:// or ldaps://, not %s", a.URL)
+	}
+	if len(u.Port()) == 0 {
+		host = net.JoinHostPort(host, port)
+	}
+
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to ldap server")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = c.SetDeadline(deadline); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	tlsConfig := a.tlsConfig(u.Hostname())
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(c, tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "ldaps handshake failed")
+		}
+		c = tlsConn
+	}
+
+	lc := &conn{Conn: c, r: bufio.NewReader(c)}
+	if u.Scheme == "ldap" && a.StartTLS {
+		if err = lc.startTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return lc, nil
+}
+
+func (a *Authenticator) tlsConfig(serverName string) *tls.Config {
+	if a.TLSConfig == nil {
+		return &tls.Config{ServerName: serverName}
+	}
+
+	config := a.TLSConfig.Clone()
+	if len(config.ServerName) == 0 {
+		config.ServerName = serverName
+	}
+	return config
+}
+
+type bindRequest struct {
+	Version  int
+	Name     []byte
+	Password []byte `asn1:"tag:0"`
+}
+
+func (c *conn) bind(name, password string) (int, string, error) {
+	op, err := asn1.Marshal(bindRequest{Version: 3, Name: []byte(name), Password: []byte(password)})
+	if err != nil {
+		return 0, "", err
+	}
+
+	// The bind request is [APPLICATION 0] and its response [APPLICATION 1]
+	return c.roundTrip(withTag(op, asn1.ClassApplication, 0), 1)
+}
+
+func (c *conn) startTLS(config *tls.Config) error {
+	op, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(startTLSOID)})
+	if err != nil {
+		return err
+	}
+	op, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 23, IsCompound: true, Bytes: op})
+	if err != nil {
+		return err
+	}
+
+	// The extended request is [APPLICATION 23] and its response
+	// [APPLICATION 24]
+	code, message, err := c.roundTrip(op, 24)
+	if err != nil {
+		return err
+	} else if code != resultSuccess {
+		return errors.Errorf("ldap starttls failed with result %d: %s", code, message)
+	}
+
+	tlsConn := tls.Client(c.Conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		return errors.Wrap(err, "ldap starttls handshake failed")
+	}
+	c.Conn = tlsConn
+	c.r = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// unbind tells the server the connection is being closed, there's no
+// response to wait for
+func (c *conn) unbind() {
+	if op, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 2}); err == nil {
+		_ = c.send(op)
+	}
+}
+
+// roundTrip sends the operation and returns the result code and message of
+// the response, which must have the application tag respTag
+func (c *conn) roundTrip(op []byte, respTag int) (int, string, error) {
+	if err := c.send(op); err != nil {
+		return 0, "", err
+	}
+
+	packet, err := c.read()
+	if err != nil {
+		return 0, "", errors.Wrap(err, "failed to read ldap response")
+	}
+
+	var msg asn1.RawValue
+	if _, err = asn1.Unmarshal(packet, &msg); err != nil {
+		return 0, "", errors.Wrap(err, "failed to read ldap response")
+	}
+
+	var id int
+	rest, err := asn1.Unmarshal(msg.Bytes, &id)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "failed to read ldap response id")
+	} else if id != c.id {
+		return 0, "", errors.Errorf("ldap response was for message %d, not %d", id, c.id)
+	}
+
+	var resp asn1.RawValue
+	if _, err = asn1.Unmarshal(rest, &resp); err != nil {
+		return 0, "", errors.Wrap(err, "failed to read ldap response")
+	} else if resp.Class != asn1.ClassApplication || resp.Tag != respTag {
+		return 0, "", errors.Errorf("ldap response had tag %d, not %d", resp.Tag, respTag)
+	}
+
+	// Every LDAPResult starts with resultCode, matchedDN and
+	// diagnosticMessage
+	var code asn1.Enumerated
+	var matched, message []byte
+	if rest, err = asn1.Unmarshal(resp.Bytes, &code); err == nil {
+		if rest, err = asn1.Unmarshal(rest, &matched); err == nil {
+			_, err = asn1.Unmarshal(rest, &message)
+		}
+	}
+	if err != nil {
+		return 0, "", errors.Wrap(err, "failed to read ldap result")
+	}
+
+	return int(code), string(message), nil
+}
+
+// send wraps the operation in an LDAPMessage with the next message id
+func (c *conn) send(op []byte) error {
+	c.id++
+	id, err := asn1.Marshal(c.id)
+	if err != nil {
+		return err
+	}
+
+	msg, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(id, op...)})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Write(msg)
+	return errors.Wrap(err, "failed to send ldap request")
+}
+
+// read one BER element off the connection, it's returned whole (with its
+// tag and length)
+func (c *conn) read() ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("unsupported ber length")
+		}
+		extra := make([]byte, n)
+		if _, err := io.ReadFull(c.r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxMessageSize {
+		return nil, errors.Errorf("ldap message of %d bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+
+	return append(header, body...), nil
+}
+
+// withTag replaces the universal SEQUENCE tag asn1.Marshal gives structs
+func withTag(sequence []byte, class, tag int) []byte {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(sequence, &raw); err != nil {
+		return sequence
+	}
+
+	out, err := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: raw.Bytes})
+	if err != nil {
+		return sequence
+	}
+	return out
+}
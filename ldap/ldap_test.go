@@ -0,0 +1,152 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"encoding/asn1"
+	"net"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// testServer answers simple binds, the password of every user is "secret"
+// except for "busy" who the server is too busy for
+func testServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	names := make(chan string, 10)
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(nc, names)
+		}
+	}()
+
+	return "ldap://" + l.Addr().String(), names
+}
+
+func serve(nc net.Conn, names chan<- string) {
+	defer nc.Close()
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	packet, err := c.read()
+	if err != nil {
+		return
+	}
+
+	var msg asn1.RawValue
+	if _, err = asn1.Unmarshal(packet, &msg); err != nil {
+		return
+	}
+	var id int
+	rest, err := asn1.Unmarshal(msg.Bytes, &id)
+	if err != nil {
+		return
+	}
+	var req bindRequest
+	if _, err = asn1.Unmarshal(withTag(rest, asn1.ClassUniversal, asn1.TagSequence), &req); err != nil {
+		return
+	}
+	names <- string(req.Name)
+
+	result := struct {
+		Code    asn1.Enumerated
+		Matched []byte
+		Message []byte
+	}{Code: resultSuccess, Matched: []byte{}, Message: []byte{}}
+	switch {
+	case string(req.Name) == "uid=busy,dc=example":
+		result.Code, result.Message = 51, []byte("busy")
+	case string(req.Password) != "secret":
+		result.Code = resultInvalidCredentials
+	}
+
+	op, err := asn1.Marshal(result)
+	if err != nil {
+		return
+	}
+	c.id = id - 1
+	_ = c.send(withTag(op, asn1.ClassApplication, 1))
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	url, names := testServer(t)
+	a := &Authenticator{URL: url, BindDN: "uid=%s,dc=example"}
+	ctx := context.Background()
+
+	if err := a.Authenticate(ctx, "test", "secret"); err != nil {
+		t.Error("the right password should bind:", err)
+	}
+	if name := <-names; name != "uid=test,dc=example" {
+		t.Error("bind dn was wrong:", name)
+	}
+
+	if err := a.Authenticate(ctx, "test", "wrong"); err != authboss.ErrInvalidCredentials {
+		t.Error("the wrong password should be invalid credentials:", err)
+	}
+	<-names
+
+	if err := a.Authenticate(ctx, "a,b", "secret"); err != nil {
+		t.Error(err)
+	}
+	if name := <-names; name != `uid=a\,b,dc=example` {
+		t.Error("the pid should be escaped:", name)
+	}
+
+	if err := a.Authenticate(ctx, "busy", "secret"); err == nil || err == authboss.ErrInvalidCredentials {
+		t.Error("other results should be errors:", err)
+	}
+	<-names
+
+	if err := a.Authenticate(ctx, "test", ""); err != authboss.ErrInvalidCredentials {
+		t.Error("empty passwords must not bind:", err)
+	}
+	select {
+	case name := <-names:
+		t.Error("an empty password should not be sent to the server:", name)
+	default:
+	}
+}
+
+func TestAuthenticateBadURL(t *testing.T) {
+	t.Parallel()
+
+	a := &Authenticator{URL: "http://example.com", BindDN: "uid=%s"}
+	if err := a.Authenticate(context.Background(), "test", "secret"); err == nil {
+		t.Error("it should only connect to ldap urls")
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		In, Out string
+	}{
+		{"plain", "plain"},
+		{"a,b+c", `a\,b\+c`},
+		{`"q"<>;=\`, `\"q\"\<\>\;\=\\`},
+		{" #lead", `\ #lead`},
+		{"#hash", `\#hash`},
+		{"trail ", `trail\ `},
+		{"nul\x00", `nul\00`},
+	}
+
+	for _, test := range tests {
+		if got := EscapeDN(test.In); got != test.Out {
+			t.Errorf("%q want: %q, got: %q", test.In, test.Out, got)
+		}
+	}
+}
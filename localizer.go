@@ -0,0 +1,264 @@
+package authboss
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Localizer translates the text authboss shows to users: flash messages,
+// errors on pages and fields, and e-mail subjects. E-mail bodies are
+// rendered by the MailRenderer, which can pick templates with
+// Authboss.Locale or the DataLocale in the e-mail's data.
+type Localizer interface {
+	// Localize the message with the id into the locale, data has the
+	// values the message refers to by name. An empty string means there's
+	// no translation and the message's default is used.
+	Localize(locale, id string, data map[string]interface{}) string
+}
+
+// LocalizationKey is a message shown to users. ID is what the Localizer
+// translates, Default is the english text that's used without a
+// translation, it's a text/template executed with the message's data.
+type LocalizationKey struct {
+	ID      string
+	Default string
+}
+
+// Format the default text with the data, for when there's no Localizer
+func (l LocalizationKey) Format(data map[string]interface{}) string {
+	if !strings.Contains(l.Default, "{{") {
+		return l.Default
+	}
+
+	tpl, err := template.New(l.ID).Parse(l.Default)
+	if err != nil {
+		return l.Default
+	}
+
+	b := &bytes.Buffer{}
+	if err := tpl.Execute(b, data); err != nil {
+		return l.Default
+	}
+	return b.String()
+}
+
+// Messages the modules show to users, translations are keyed by the IDs
+var (
+	TxtInvalidCredentials = LocalizationKey{"invalid_credentials", "Invalid Credentials"}
+	TxtCompleteChallenge  = LocalizationKey{"complete_challenge", "Please complete the challenge"}
+	TxtInvalidToken       = LocalizationKey{"invalid_token", "Invalid token"}
+	TxtLoggedOut          = LocalizationKey{"logged_out", "You have been logged out"}
+	TxtReLogin            = LocalizationKey{"relogin", "please re-login"}
+	// TxtReadOnly's default is ReadOnlyMessage
+	TxtReadOnly      = LocalizationKey{"read_only", "This is temporarily unavailable, please try again later."}
+	TxtForbidden     = LocalizationKey{"forbidden", "You don't have permission to do that."}
+	TxtInvalidCSRF   = LocalizationKey{"invalid_csrf", "The form has expired, please try again."}
+	TxtNetworkDenied = LocalizationKey{"network_denied", "You can't do that from your network."}
+	TxtLockdown      = LocalizationKey{"lockdown", "Signing in and signing up are temporarily disabled, please try again later."}
+	TxtInternalError = LocalizationKey{"internal_error", "Something went wrong, please try again later."}
+
+	TxtConfirmSubject     = LocalizationKey{"confirm_subject", "Confirm New Account"}
+	TxtConfirmSent        = LocalizationKey{"confirm_sent", "Please verify your account, an e-mail has been sent to you."}
+	TxtConfirmGeneric     = LocalizationKey{"confirm_generic", "If that e-mail address can be registered, we've sent it a link to verify the account."}
+	TxtConfirmResend      = LocalizationKey{"confirm_resend", "If that account needs confirming, we've sent it a new link to verify it."}
+	TxtConfirmMailFailed  = LocalizationKey{"confirm_mail_failed", "We couldn't send the e-mail to verify your account, please ask for a new one later."}
+	TxtConfirmResendLimit = LocalizationKey{"confirm_resend_limit", "Too many confirmation e-mails have been sent, please try again later."}
+	TxtConfirmExpired     = LocalizationKey{"confirm_expired", "Your confirmation link has expired, we've sent you a new one."}
+	TxtReconfirmSent      = LocalizationKey{"reconfirm_sent", "Your account changed, please verify it again with the e-mail that has been sent to you."}
+	TxtConfirmInvalid     = LocalizationKey{"confirm_invalid", "confirm token is invalid"}
+	TxtConfirmed          = LocalizationKey{"confirmed", "You have successfully confirmed your account."}
+	TxtNotConfirmed       = LocalizationKey{"not_confirmed", "Your account has not been confirmed, please check your e-mail."}
+	TxtInvalidEmail       = LocalizationKey{"invalid_email", "Please enter a valid e-mail address."}
+
+	TxtBackupEmailSubject  = LocalizationKey{"backup_email_subject", "Verify Your Backup E-mail Address"}
+	TxtBackupEmailSent     = LocalizationKey{"backup_email_sent", "An e-mail has been sent to your backup address with a link to verify it."}
+	TxtBackupEmailFailed   = LocalizationKey{"backup_email_mail_failed", "We couldn't send the e-mail to verify your backup address, please try again later."}
+	TxtBackupEmailVerified = LocalizationKey{"backup_email_verified", "Your backup e-mail address has been verified."}
+	TxtBackupEmailRemoved  = LocalizationKey{"backup_email_removed", "Your backup e-mail address has been removed."}
+	TxtBackupEmailSame     = LocalizationKey{"backup_email_same", "Your backup e-mail address must be different from your e-mail address."}
+	TxtBackupEmailInvalid  = LocalizationKey{"backup_email_invalid", "backup e-mail token is invalid or has expired"}
+
+	TxtRenamed        = LocalizationKey{"renamed", "Your name has been changed."}
+	TxtRenameTaken    = LocalizationKey{"rename_taken", "That name is already taken."}
+	TxtRenameSame     = LocalizationKey{"rename_same", "That is already your name."}
+	TxtRenameCooldown = LocalizationKey{"rename_cooldown", "You changed your name recently, please try again later."}
+
+	TxtExportLoginAgain = LocalizationKey{"export_login_again", "Please log in again to export your data."}
+
+	TxtLocked                = LocalizationKey{"locked", "Your account has been locked, please contact the administrator."}
+	TxtLockedUnlock          = LocalizationKey{"locked_unlock", "Your account has been locked, please check your e-mail to unlock it."}
+	TxtSuspended             = LocalizationKey{"suspended", "Your account has been suspended, please contact the administrator."}
+	TxtSuspendedUntil        = LocalizationKey{"suspended_until", "Your account has been suspended until {{.Until}}."}
+	TxtUnlockSubject         = LocalizationKey{"unlock_subject", "Unlock Your Account"}
+	TxtUnlocked              = LocalizationKey{"unlocked", "Your account has been unlocked."}
+	TxtUnlockInvalid         = LocalizationKey{"unlock_invalid", "unlock token is invalid or has expired"}
+	TxtNotifyLock            = LocalizationKey{"notify_lock_subject", "Your Account Has Been Locked"}
+	TxtNotifyPassword        = LocalizationKey{"notify_password_change_subject", "Your Password Has Been Changed"}
+	TxtNotifyTwoFactorAdd    = LocalizationKey{"notify_twofactor_add_subject", "Two Factor Authentication Enabled"}
+	TxtNotifyTwoFactorRemove = LocalizationKey{"notify_twofactor_remove_subject", "Two Factor Authentication Disabled"}
+	TxtNotifyNewDevice       = LocalizationKey{"notify_new_device_subject", "New Login To Your Account"}
+
+	TxtRecoverSubject    = LocalizationKey{"recover_subject", "Password Reset"}
+	TxtRecoverSent       = LocalizationKey{"recover_sent", "An email has been sent to you with further instructions on how to reset your password."}
+	TxtRecoverGeneric    = LocalizationKey{"recover_generic", "If an account exists for that address, an email has been sent to it with further instructions on how to reset your password."}
+	TxtRecoverMailFailed = LocalizationKey{"recover_mail_failed", "We couldn't send the email to reset your password, please try again later."}
+	TxtRecoverInvalid    = LocalizationKey{"recover_invalid", "recovery token is invalid"}
+	TxtRecoverAnswer     = LocalizationKey{"recover_answer", "The answer is wrong"}
+	TxtPasswordUpdated   = LocalizationKey{"password_updated", "Successfully updated password"}
+	TxtPasswordLoggedIn  = LocalizationKey{"password_updated_logged_in", "Successfully updated password and logged in"}
+
+	TxtRegistered          = LocalizationKey{"registered", "Account successfully created, you are now logged in"}
+	TxtRegisterMailFailed  = LocalizationKey{"register_mail_failed", "We couldn't send the e-mail to finish registering, please try again later."}
+	TxtRegisterLinkInvalid = LocalizationKey{"register_link_invalid", "registration link is invalid or has expired"}
+	TxtAccountType         = LocalizationKey{"account_type", "Must be one of: {{.AccountTypes}}"}
+
+	TxtAPIKeyRevoked  = LocalizationKey{"api_key_revoked", "The API key has been revoked."}
+	TxtAPIKeyNotFound = LocalizationKey{"api_key_not_found", "That API key doesn't exist."}
+
+	TxtLoginDenied       = LocalizationKey{"login_denied", "This login was blocked, please contact us if it was you."}
+	TxtLoginCodeSubject  = LocalizationKey{"login_code_subject", "Your Login Code"}
+	TxtLoginCodeSent     = LocalizationKey{"login_code_sent", "We've e-mailed you a code to finish logging in."}
+	TxtLoginCodeInvalid  = LocalizationKey{"login_code_invalid", "That code is wrong or has expired."}
+	TxtLoginCodeAttempts = LocalizationKey{"login_code_attempts", "Too many wrong codes, please log in again."}
+
+	TxtTooManySessions = LocalizationKey{"too_many_sessions", "You're logged in on too many devices, log out of one of them to log in here."}
+	TxtSessionEvicted  = LocalizationKey{"session_evicted", "You've been logged out because you logged in on another device."}
+
+	TxtLogInFirst       = LocalizationKey{"log_in_first", "Please log in first."}
+	TxtTooManyOTPs      = LocalizationKey{"too_many_otps", "you cannot have more than {{.Max}} one time passwords"}
+	TxtInvalid2FACode   = LocalizationKey{"invalid_2fa_code", "2fa code was invalid"}
+	TxtRepeated2FACode  = LocalizationKey{"repeated_2fa_code", "2fa code was previously used"}
+	TxtTOTPNotActive    = LocalizationKey{"totp_not_active", "totp 2fa not active"}
+	TxtPhoneRequired    = LocalizationKey{"phone_required", "must provide a phone number"}
+	TxtSMSWait          = LocalizationKey{"sms_wait", "please wait a few moments before resending SMS code"}
+	Txt2FAEmailSubject  = LocalizationKey{"twofactor_email_subject", "Add 2FA to Account"}
+	Txt2FAEmailSent     = LocalizationKey{"twofactor_email_sent", "An e-mail has been sent to confirm 2FA activation."}
+	Txt2FAEmailInvalid  = LocalizationKey{"twofactor_email_invalid", "invalid 2fa e-mail verification token"}
+	Txt2FAEmailRequired = LocalizationKey{"twofactor_email_required", "You must first authorize adding 2fa by e-mail."}
+
+	TxtOAuth2LoggedIn      = LocalizationKey{"oauth2_logged_in", "Logged in successfully with {{.Provider}}."}
+	TxtOAuth2LoginFailed   = LocalizationKey{"oauth2_login_failed", "{{.Provider}} login failed"}
+	TxtOAuth2LoginCanceled = LocalizationKey{"oauth2_login_canceled", "{{.Provider}} login cancelled or failed"}
+	TxtOAuth2LinkCanceled  = LocalizationKey{"oauth2_link_canceled", "{{.Provider}} link cancelled or failed"}
+	TxtOAuth2Linked        = LocalizationKey{"oauth2_linked", "Linked your {{.Provider}} account."}
+	TxtOAuth2Unlinked      = LocalizationKey{"oauth2_unlinked", "Unlinked your {{.Provider}} account."}
+	TxtOAuth2LinkedOther   = LocalizationKey{"oauth2_linked_other", "That {{.Provider}} account is already linked to another user."}
+	TxtOAuth2NotLinked     = LocalizationKey{"oauth2_not_linked", "No {{.Provider}} account is linked."}
+
+	TxtRequired     = LocalizationKey{"required", "Cannot be blank"}
+	TxtNoWhitespace = LocalizationKey{"no_whitespace", "No whitespace permitted"}
+	TxtNoMatch      = LocalizationKey{"no_match", "Does not match {{.Field}}"}
+	TxtMustDiffer   = LocalizationKey{"must_differ", "Must be different from {{.Field}}"}
+	TxtLengthRange  = LocalizationKey{"length_range", "Must be between {{.Min}} and {{.Max}} characters"}
+	TxtMinLength    = LocalizationKey{"min_length", "Must be at least {{.Min}} character{{if gt .Min 1}}s{{end}}"}
+	TxtMaxLength    = LocalizationKey{"max_length", "Must be at most {{.Max}} character{{if gt .Max 1}}s{{end}}"}
+	TxtMinLetters   = LocalizationKey{"min_letters", "Must contain at least {{.Min}} letter{{if gt .Min 1}}s{{end}}"}
+	TxtMinUpper     = LocalizationKey{"min_upper", "Must contain at least {{.Min}} uppercase letter{{if gt .Min 1}}s{{end}}"}
+	TxtMinLower     = LocalizationKey{"min_lower", "Must contain at least {{.Min}} lowercase letter{{if gt .Min 1}}s{{end}}"}
+	TxtMinNumeric   = LocalizationKey{"min_numeric", "Must contain at least {{.Min}} number{{if gt .Min 1}}s{{end}}"}
+	TxtMinSymbols   = LocalizationKey{"min_symbols", "Must contain at least {{.Min}} symbol{{if gt .Min 1}}s{{end}}"}
+
+	TxtExtraFieldInt   = LocalizationKey{"extra_field_int", "Must be a whole number"}
+	TxtExtraFieldFloat = LocalizationKey{"extra_field_float", "Must be a number"}
+	TxtExtraFieldBool  = LocalizationKey{"extra_field_bool", "Must be true or false"}
+	TxtExtraFieldTime  = LocalizationKey{"extra_field_time", "Must be a date"}
+)
+
+// Locale of the request the context is for, it's put in the context for the
+// modules' handlers by Config.Core.Locale. Apps can put it in the context
+// themselves under CTXKeyLocale, for example from the user's settings.
+func (a *Authboss) Locale(ctx context.Context) string {
+	locale, _ := ctx.Value(CTXKeyLocale).(string)
+	return locale
+}
+
+// LocaleContext returns the request's context with the locale from
+// Config.Core.Locale in it, unless there's one already.
+func (a *Authboss) LocaleContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if a.Config.Core.Locale == nil || len(a.Locale(ctx)) != 0 {
+		return ctx
+	}
+	if locale := a.Config.Core.Locale(r); len(locale) != 0 {
+		ctx = context.WithValue(ctx, CTXKeyLocale, locale)
+	}
+	return ctx
+}
+
+// Localize the message into the locale of the context with the
+// Config.Core.Localizer, falling back to the message's default text.
+// data is key/value pairs like NewHTMLData's.
+func (a *Authboss) Localize(ctx context.Context, key LocalizationKey, data ...interface{}) string {
+	values := localizationData(data)
+
+	if a.Config.Core.Localizer != nil {
+		if text := a.Config.Core.Localizer.Localize(a.Locale(ctx), key.ID, values); len(text) != 0 {
+			return text
+		}
+	}
+
+	return key.Format(values)
+}
+
+// LocalizeErrors groups the errors by field like ErrorMap, with the
+// LocalizedErrors localized into the locale of the context.
+func (a *Authboss) LocalizeErrors(ctx context.Context, errs []error) map[string][]string {
+	m := make(map[string][]string)
+
+	for _, err := range errs {
+		name := ""
+		if fieldErr, ok := err.(FieldError); ok {
+			name, err = fieldErr.Name(), fieldErr.Err()
+		}
+
+		if locErr, ok := err.(LocalizedError); ok {
+			m[name] = append(m[name], a.Localize(ctx, locErr.Key, locErr.Data...))
+		} else {
+			m[name] = append(m[name], err.Error())
+		}
+	}
+
+	return m
+}
+
+// LocalizedError is an error shown to users that can be localized, like
+// the FieldErrors from the defaults' validation rules. Error() is the
+// message's default text.
+type LocalizedError struct {
+	Key LocalizationKey
+	// Data is key/value pairs like NewHTMLData's
+	Data []interface{}
+}
+
+// NewLocalizedError creates a LocalizedError
+func NewLocalizedError(key LocalizationKey, data ...interface{}) LocalizedError {
+	return LocalizedError{Key: key, Data: data}
+}
+
+// Error is the message's default text
+func (l LocalizedError) Error() string {
+	return l.Key.Format(localizationData(l.Data))
+}
+
+func localizationData(data []interface{}) map[string]interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	if len(data)%2 != 0 {
+		panic("localization data must be key/value pairs")
+	}
+
+	values := make(map[string]interface{}, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		key, ok := data[i].(string)
+		if !ok {
+			panic(fmt.Sprintf("localization data keys must be strings, got: %T", data[i]))
+		}
+		values[key] = data[i+1]
+	}
+	return values
+}
@@ -0,0 +1,110 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/friendsofgo/errors"
+)
+
+type testLocalizer map[string]string
+
+func (t testLocalizer) Localize(locale, id string, data map[string]interface{}) string {
+	text := t[locale+":"+id]
+	if provider, ok := data["Provider"]; ok {
+		text += " " + provider.(string)
+	}
+	return text
+}
+
+func TestLocalizeDefaults(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ctx := context.Background()
+
+	if got := ab.Localize(ctx, TxtInvalidCredentials); got != "Invalid Credentials" {
+		t.Error("wrong message:", got)
+	}
+	if got := ab.Localize(ctx, TxtOAuth2Linked, "Provider", "Google"); got != "Linked your Google account." {
+		t.Error("wrong message:", got)
+	}
+	if got := ab.Localize(ctx, TxtMinLength, "Min", 1); got != "Must be at least 1 character" {
+		t.Error("wrong message:", got)
+	}
+	if got := ab.Localize(ctx, TxtMinLength, "Min", 2); got != "Must be at least 2 characters" {
+		t.Error("wrong message:", got)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Localizer = testLocalizer{
+		"fr:" + TxtInvalidCredentials.ID: "Identifiants invalides",
+		"fr:" + TxtOAuth2Linked.ID:       "Compte lié :",
+	}
+
+	ctx := context.WithValue(context.Background(), CTXKeyLocale, "fr")
+	if got := ab.Localize(ctx, TxtInvalidCredentials); got != "Identifiants invalides" {
+		t.Error("wrong message:", got)
+	}
+	if got := ab.Localize(ctx, TxtOAuth2Linked, "Provider", "Google"); got != "Compte lié : Google" {
+		t.Error("wrong message:", got)
+	}
+	if got := ab.Localize(ctx, TxtLoggedOut); got != TxtLoggedOut.Default {
+		t.Error("messages without a translation should use the default:", got)
+	}
+}
+
+func TestLocaleContext(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "fr-CA,fr;q=0.8")
+
+	if locale := ab.Locale(ab.LocaleContext(r)); len(locale) != 0 {
+		t.Error("there should be no locale without a callback:", locale)
+	}
+
+	ab.Config.Core.Locale = func(r *http.Request) string {
+		return r.Header.Get("Accept-Language")[:2]
+	}
+	if locale := ab.Locale(ab.LocaleContext(r)); locale != "fr" {
+		t.Error("locale was wrong:", locale)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyLocale, "de"))
+	if locale := ab.Locale(ab.LocaleContext(r)); locale != "de" {
+		t.Error("the app's locale should be kept:", locale)
+	}
+}
+
+func TestLocalizeErrors(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Localizer = testLocalizer{"fr:" + TxtRequired.ID: "Obligatoire"}
+	ctx := context.WithValue(context.Background(), CTXKeyLocale, "fr")
+
+	errs := []error{
+		mockFieldError{"email", NewLocalizedError(TxtRequired)},
+		mockFieldError{"password", NewLocalizedError(TxtMinLength, "Min", 8)},
+		errors.New("not localized"),
+	}
+
+	m := ab.LocalizeErrors(ctx, errs)
+	if got := m["email"]; len(got) != 1 || got[0] != "Obligatoire" {
+		t.Error("email errors were wrong:", got)
+	}
+	if got := m["password"]; len(got) != 1 || got[0] != "Must be at least 8 characters" {
+		t.Error("password errors were wrong:", got)
+	}
+	if got := m[""]; len(got) != 1 || got[0] != "not localized" {
+		t.Error("other errors were wrong:", got)
+	}
+}
@@ -3,9 +3,19 @@ package lock
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"time"
 
+	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
 )
 
@@ -16,6 +26,28 @@ const (
 	StoreLocked        = "locked"
 )
 
+const (
+	// PageUnlock is only really used for the BodyReader
+	PageUnlock = "unlock"
+
+	// EmailUnlockHTML is the name of the html template for e-mails
+	EmailUnlockHTML = "unlock_html"
+	// EmailUnlockTxt is the name of the text template for e-mails
+	EmailUnlockTxt = "unlock_txt"
+
+	// FormValueToken is the name of the form value for the unlock token
+	FormValueToken = "token"
+
+	// DataUnlockURL is the name of the e-mail template variable
+	// that gives the url to send to the user to unlock their account.
+	DataUnlockURL = "url"
+
+	nSigSize  = sha512.Size
+	nTimeSize = 8
+
+	pageLogin = "login"
+)
+
 func init() {
 	authboss.RegisterModule("lock", &Lock{})
 }
@@ -25,6 +57,31 @@ type Lock struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what locking accounts needs, what
+// challenging logins after failures needs when Modules.LockChallengeAfter
+// or Modules.LockChallengeAfterIP are set, and what unlock e-mails need
+// when Modules.LockUnlockKey is set
+func (l *Lock) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Redirector", "Core.Logger", "Storage.Server")
+	if ab.Config.Modules.LockChallengeAfter > 0 || ab.Config.Modules.LockChallengeAfterIP > 0 {
+		problems = append(problems, ab.Config.Missing("Modules.ChallengeVerifier")...)
+	}
+	if ab.Config.Modules.LockChallengeAfterIP > 0 {
+		problems = append(problems, ab.Config.Missing("Storage.Counter")...)
+	}
+	if len(ab.Config.Modules.LockUnlockKey) == 0 {
+		return problems
+	}
+
+	problems = append(problems, ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.MailRenderer", "Core.Mailer")...)
+	problems = append(problems, ab.Config.MissingLinkURL()...)
+	if method := ab.Config.Modules.MailRouteMethod; method != http.MethodGet && method != http.MethodPost {
+		problems = append(problems, authboss.ConfigProblem{Field: "Modules.MailRouteMethod", Problem: "must be GET or POST"})
+	}
+
+	return problems
+}
+
 // Init the module
 func (l *Lock) Init(ab *authboss.Authboss) error {
 	l.Authboss = ab
@@ -34,6 +91,35 @@ func (l *Lock) Init(ab *authboss.Authboss) error {
 	l.Events.After(authboss.EventAuth, l.AfterAuthSuccess)
 	l.Events.After(authboss.EventAuthFail, l.AfterAuthFail)
 
+	if l.Config.Modules.LockChallengeAfter > 0 || l.Config.Modules.LockChallengeAfterIP > 0 {
+		required := l.Config.Modules.ChallengeRequired
+		l.Config.Modules.ChallengeRequired = func(r *http.Request, page string) bool {
+			if page != pageLogin {
+				return required == nil || required(r, page)
+			}
+			return (required != nil && required(r, page)) || l.ChallengeRequired(r)
+		}
+	}
+
+	if len(l.Config.Modules.LockUnlockKey) == 0 {
+		return nil
+	}
+
+	if err := l.Authboss.Config.Core.MailRenderer.Load(EmailUnlockHTML, EmailUnlockTxt); err != nil {
+		return err
+	}
+
+	var callbackMethod func(string, http.Handler)
+	switch l.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = l.Authboss.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = l.Authboss.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+	callbackMethod("/unlock", authboss.CSRFExempt(l.ReadOnlyGuard(l.Paths.LockNotOK, l.Authboss.Config.Core.ErrorHandler.Wrap(l.UnlockGet))))
+
 	return nil
 }
 
@@ -51,17 +137,85 @@ func (l *Lock) AfterAuthSuccess(w http.ResponseWriter, r *http.Request, handled
 
 	lu := authboss.MustBeLockable(user)
 	lu.PutAttemptCount(0)
-	lu.PutLastAttempt(time.Now().UTC())
+	lu.PutLastAttempt(l.Now().UTC())
 
-	return false, l.Authboss.Config.Storage.Server.Save(r.Context(), lu)
+	return false, l.Authboss.SaveUser(r.Context(), lu)
 }
 
 // AfterAuthFail adjusts the attempt number and time negatively
-// and locks the user if they're beyond limits.
+// and locks the user if they're beyond limits. The failure is also counted
+// for the client's address if Modules.LockChallengeAfterIP is set.
 func (l *Lock) AfterAuthFail(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if l.Config.Modules.LockChallengeAfterIP > 0 {
+		if err := l.countIPFailure(r); err != nil {
+			return false, err
+		}
+	}
+
 	return l.updateLockedState(w, r, false)
 }
 
+// ChallengeRequired reports whether a login must solve a challenge because
+// of the failures of the account (with the values entered in the context)
+// or of the client's address. It fails closed: if the failures can't be
+// looked up a challenge is required.
+func (l *Lock) ChallengeRequired(r *http.Request) bool {
+	logger := l.RequestLogger(r)
+
+	if after := l.Config.Modules.LockChallengeAfterIP; after > 0 {
+		failures, err := l.ipFailures(r)
+		if err != nil {
+			logger.Errorf("failed to count login failures from %s: %+v", l.ClientIP(r), err)
+			return true
+		}
+		if failures >= after {
+			return true
+		}
+	}
+
+	after := l.Config.Modules.LockChallengeAfter
+	pid := l.Attempt(r).PID
+	if after == 0 || len(pid) == 0 {
+		return false
+	}
+
+	user, err := l.Authboss.LoadByCredential(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		return false
+	} else if err != nil {
+		logger.Errorf("failed to load user to check their login failures: %+v", err)
+		return true
+	}
+
+	lu, ok := user.(authboss.LockableUser)
+	if !ok {
+		return false
+	}
+	return lu.GetAttemptCount() >= after && l.Now().UTC().Sub(lu.GetLastAttempt()) <= l.Modules.LockWindow
+}
+
+// ipFailures counts the login failures from the client's address. The
+// counter can only add to a count, so an empty failure is added to look
+// it up and isn't counted.
+func (l *Lock) ipFailures(r *http.Request) (int, error) {
+	failures, err := l.Config.Storage.Counter.CountDistinct(r.Context(), ipKey(l.ClientIP(r)), "", l.Modules.LockWindow)
+	if err != nil {
+		return 0, err
+	}
+	return failures - 1, nil
+}
+
+// countIPFailure adds a login failure from the client's address
+func (l *Lock) countIPFailure(r *http.Request) error {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return errors.Wrap(err, "failed to create login failure id")
+	}
+
+	_, err := l.Config.Storage.Counter.CountDistinct(r.Context(), ipKey(l.ClientIP(r)), base64.RawURLEncoding.EncodeToString(b), l.Modules.LockWindow)
+	return errors.Wrap(err, "failed to count login failure")
+}
+
 // updateLockedState exists to minimize any differences between a success and
 // a failure path in the case where a correct/incorrect password is entered
 func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorrectPassword bool) (bool, error) {
@@ -72,14 +226,15 @@ func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorr
 
 	// Fetch things
 	lu := authboss.MustBeLockable(user)
+	wasLocked := IsLocked(lu, l.Now())
 	last := lu.GetLastAttempt()
 	attempts := lu.GetAttemptCount()
 	attempts++
 
 	if !wasCorrectPassword {
-		if time.Now().UTC().Sub(last) <= l.Modules.LockWindow {
+		if l.Now().UTC().Sub(last) <= l.Modules.LockWindow {
 			if attempts >= l.Modules.LockAfter {
-				lu.PutLocked(time.Now().UTC().Add(l.Modules.LockDuration))
+				lu.PutLocked(l.Now().UTC().Add(l.Modules.LockDuration))
 			}
 
 			lu.PutAttemptCount(attempts)
@@ -87,56 +242,214 @@ func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorr
 			lu.PutAttemptCount(1)
 		}
 	}
-	lu.PutLastAttempt(time.Now().UTC())
+	lu.PutLastAttempt(l.Now().UTC())
 
-	if err := l.Authboss.Config.Storage.Server.Save(r.Context(), lu); err != nil {
+	if err := l.Authboss.SaveUser(r.Context(), lu); err != nil {
 		return false, err
 	}
 
-	if !IsLocked(lu) {
+	if !IsLocked(lu, l.Now()) {
+		return false, nil
+	}
+
+	if !wasLocked {
+		if err := l.locked(w, r, lu); err != nil {
+			return false, err
+		}
+	}
+
+	// A wrong password gets the same response whether or not the user
+	// exists, see Config.Modules.PreventUserEnumeration
+	if !wasCorrectPassword && l.Config.Modules.PreventUserEnumeration {
 		return false, nil
 	}
 
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		Failure:      "Your account has been locked, please contact the administrator.",
-		RedirectPath: l.Authboss.Config.Paths.LockNotOK,
+		Failure:      lockedMessage(r.Context(), l.Authboss),
+		FailureCode:  authboss.ErrorCodeLocked,
+		RedirectPath: l.Authboss.RedirectPath(r, "lock", authboss.RedirectNotOK, user, l.Authboss.RequestConfig(r.Context()).Paths.LockNotOK),
 	}
 	return true, l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// locked fires EventLock for a user that was just locked and sends them
+// the unlock e-mail if Config.Modules.LockUnlockKey is set, to their
+// backup address too if they're a BackupEmailUser with one.
+func (l *Lock) locked(w http.ResponseWriter, r *http.Request, lu authboss.LockableUser) error {
+	logger := l.Authboss.RequestLogger(r)
+	logger.Infof("user %s was locked after too many failed logins", lu.GetPID())
+	l.Authboss.CountMetric(authboss.CounterLockouts, nil)
+
+	if _, err := l.Events.FireAfter(authboss.EventLock, w, r); err != nil {
+		return err
+	}
+
+	if len(l.Config.Modules.LockUnlockKey) == 0 {
+		return nil
+	}
+
+	uu := authboss.MustBeUnlockable(lu)
+	token := signToken(l.Config.Modules.LockUnlockKey, uu.GetPID(), uu.GetLocked())
+
+	// The user may not be able to get to their primary mailbox, the backup
+	// address gets its own e-mail so neither address is shown to the other
+	to := []string{uu.GetEmail()}
+	if bu, ok := lu.(authboss.BackupEmailUser); ok && len(bu.GetBackupEmail()) != 0 {
+		to = append(to, bu.GetBackupEmail())
+	}
+
+	for _, address := range to {
+		address := address
+		if l.Authboss.Config.Modules.MailNoGoroutine {
+			l.SendUnlockEmail(r.Context(), address, token)
+		} else {
+			l.Authboss.Background(func() { l.SendUnlockEmail(r.Context(), address, token) })
+		}
+	}
+
+	return nil
+}
+
+// SendUnlockEmail sends an unlock e-mail to a user
+func (l *Lock) SendUnlockEmail(ctx context.Context, to, token string) {
+	logger := l.Authboss.Logger(ctx)
+
+	mail := l.RequestConfig(ctx).Mail
+	email := authboss.Email{
+		To:       []string{to},
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + l.Localize(ctx, authboss.TxtUnlockSubject),
+	}
+
+	logger.Infof("sending unlock e-mail to: %s", to)
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataUnlockURL, l.mailURL(ctx, token)),
+		HTMLTemplate: EmailUnlockHTML,
+		TextTemplate: EmailUnlockTxt,
+		Module:       "lock",
+	}
+	if err := l.Authboss.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send unlock e-mail to %s: %+v", to, err)
+	}
+}
+
+// UnlockGet unlocks a user with a valid token from the unlock e-mail. The
+// token is only valid while the lock it was sent for lasts.
+func (l *Lock) UnlockGet(w http.ResponseWriter, r *http.Request) error {
+	logger := l.RequestLogger(r)
+
+	validator, err := l.Authboss.Config.Core.BodyReader.Read(PageUnlock, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validator.Validate(); errs != nil {
+		logger.Infof("validation failed in Lock.UnlockGet, this typically means a bad token: %+v", errs)
+		return l.invalidToken(w, r)
+	}
+
+	values := authboss.MustHaveConfirmValues(validator)
+
+	pid, locked, ok := verifyToken(l.Config.Modules.LockUnlockKey, values.GetToken())
+	if !ok {
+		logger.Info("invalid unlock token submitted")
+		return l.invalidToken(w, r)
+	}
+
+	user, err := l.Authboss.LoadUser(r.Context(), pid)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("unlock token user not found: %s", pid)
+		return l.invalidToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	lu := authboss.MustBeLockable(user)
+	if !IsLocked(lu, l.Now()) || lu.GetLocked().Unix() != locked.Unix() {
+		logger.Infof("unlock token for user %s is for a lock that's over", pid)
+		return l.invalidToken(w, r)
+	}
+
+	logger.Infof("user %s unlocked their account", pid)
+	if err = l.unlock(r.Context(), lu); err != nil {
+		return err
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := l.Events.FireAfter(authboss.EventUnlock, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Success:      l.Localize(r.Context(), authboss.TxtUnlocked),
+		RedirectPath: l.Authboss.RedirectPath(r, "lock", authboss.RedirectOK, user, l.Authboss.RequestConfig(r.Context()).Paths.UnlockOK),
+	}
+	return l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (l *Lock) mailURL(ctx context.Context, token string) string {
+	config := l.RequestConfig(ctx)
+	query := url.Values{FormValueToken: []string{token}}
+
+	if len(config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", config.Mail.RootURL+"/unlock", query.Encode())
+	}
+
+	p := path.Join(config.Paths.Mount, "unlock")
+	return fmt.Sprintf("%s%s?%s", config.Paths.RootURL, p, query.Encode())
+}
+
+func (l *Lock) invalidToken(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      l.Localize(r.Context(), authboss.TxtUnlockInvalid),
+		FailureCode:  authboss.ErrorCodeInvalidToken,
+		RedirectPath: l.Authboss.RedirectPath(r, "lock", authboss.RedirectNotOK, nil, l.Authboss.RequestConfig(r.Context()).Paths.LockNotOK),
+	}
+	return l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
 // Lock a user manually.
 func (l *Lock) Lock(ctx context.Context, key string) error {
-	user, err := l.Authboss.Config.Storage.Server.Load(ctx, key)
+	user, err := l.Authboss.LoadUser(ctx, key)
 	if err != nil {
 		return err
 	}
 
 	lu := authboss.MustBeLockable(user)
-	lu.PutLocked(time.Now().UTC().Add(l.Authboss.Config.Modules.LockDuration))
+	lu.PutLocked(l.Now().UTC().Add(l.Authboss.Config.Modules.LockDuration))
 
-	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
+	return l.Authboss.SaveUser(ctx, lu)
 }
 
 // Unlock a user that was locked by this module.
 func (l *Lock) Unlock(ctx context.Context, key string) error {
-	user, err := l.Authboss.Config.Storage.Server.Load(ctx, key)
+	user, err := l.Authboss.LoadUser(ctx, key)
 	if err != nil {
 		return err
 	}
 
-	lu := authboss.MustBeLockable(user)
+	return l.unlock(ctx, authboss.MustBeLockable(user))
+}
 
+func (l *Lock) unlock(ctx context.Context, lu authboss.LockableUser) error {
 	// Set the last attempt to be -window*2 to avoid immediately
 	// giving another login failure. Don't reset Locked to Zero time
 	// because some databases may have trouble storing values before
 	// unix_time(0): Jan 1st, 1970
-	now := time.Now().UTC()
+	now := l.Now().UTC()
 	lu.PutAttemptCount(0)
 	lu.PutLastAttempt(now.Add(-l.Authboss.Config.Modules.LockWindow * 2))
 	lu.PutLocked(now.Add(-l.Authboss.Config.Modules.LockDuration))
 
-	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
+	return l.Authboss.SaveUser(ctx, lu)
 }
 
 // Middleware ensures that a user is not locked, or else it will intercept
@@ -149,7 +462,7 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 			user := ab.LoadCurrentUserP(&r)
 
 			lu := authboss.MustBeLockable(user)
-			if !IsLocked(lu) {
+			if !IsLocked(lu, ab.Now()) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -158,8 +471,9 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 			logger.Infof("user %s prevented from accessing %s: locked", user.GetPID(), r.URL.Path)
 			ro := authboss.RedirectOptions{
 				Code:         http.StatusTemporaryRedirect,
-				Failure:      "Your account has been locked, please contact the administrator.",
-				RedirectPath: ab.Config.Paths.LockNotOK,
+				Failure:      lockedMessage(ab.LocaleContext(r), ab),
+				FailureCode:  authboss.ErrorCodeLocked,
+				RedirectPath: ab.RedirectPath(r, "lock", authboss.RedirectNotOK, user, ab.RequestConfig(r.Context()).Paths.LockNotOK),
 			}
 			if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
 				logger.Errorf("error redirecting in lock.Middleware: #%v", err)
@@ -168,7 +482,50 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 	}
 }
 
-// IsLocked checks if a user is locked
-func IsLocked(lu authboss.LockableUser) bool {
-	return lu.GetLocked().After(time.Now().UTC())
+// IsLocked checks if a user is locked at now, usually ab.Now()
+func IsLocked(lu authboss.LockableUser, now time.Time) bool {
+	return lu.GetLocked().After(now.UTC())
+}
+
+func ipKey(addr string) string {
+	return "lock:" + addr
+}
+
+func lockedMessage(ctx context.Context, ab *authboss.Authboss) string {
+	if len(ab.Config.Modules.LockUnlockKey) != 0 {
+		return ab.Localize(ctx, authboss.TxtLockedUnlock)
+	}
+	return ab.Localize(ctx, authboss.TxtLocked)
+}
+
+// signToken creates an unlock token for the lock that lasts until locked.
+// It's the signature followed by the lock time and the pid.
+func signToken(key []byte, pid string, locked time.Time) string {
+	rawToken := make([]byte, nSigSize+nTimeSize+len(pid))
+	binary.BigEndian.PutUint64(rawToken[nSigSize:], uint64(locked.Unix()))
+	copy(rawToken[nSigSize+nTimeSize:], pid)
+
+	mac := hmac.New(sha512.New, key)
+	_, _ = mac.Write(rawToken[nSigSize:])
+	copy(rawToken, mac.Sum(nil))
+
+	return base64.URLEncoding.EncodeToString(rawToken)
+}
+
+// verifyToken checks the token's signature and returns what it was
+// signed for.
+func verifyToken(key []byte, token string) (pid string, locked time.Time, ok bool) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(rawToken) <= nSigSize+nTimeSize {
+		return "", time.Time{}, false
+	}
+
+	mac := hmac.New(sha512.New, key)
+	_, _ = mac.Write(rawToken[nSigSize:])
+	if !hmac.Equal(mac.Sum(nil), rawToken[:nSigSize]) {
+		return "", time.Time{}, false
+	}
+
+	locked = time.Unix(int64(binary.BigEndian.Uint64(rawToken[nSigSize:])), 0)
+	return string(rawToken[nSigSize+nTimeSize:]), locked, true
 }
@@ -4,10 +4,12 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
 	"github.com/volatiletech/authboss/v3/mocks"
 )
 
@@ -125,6 +127,39 @@ func TestBeforeAuthDisallow(t *testing.T) {
 	if len(opts.Failure) == 0 {
 		t.Error("expected a failure message")
 	}
+	if opts.FailureCode != authboss.ErrorCodeLocked {
+		t.Error("failure code was wrong:", opts.FailureCode)
+	}
+}
+
+func TestBeforeAuthClock(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	clock := authtest.NewClock(time.Now().UTC())
+	harness.ab.Config.Core.Clock = clock
+
+	user := &mocks.User{
+		Email:  "test@test.com",
+		Locked: clock.Now().Add(time.Hour),
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	if handled, err := harness.lock.BeforeAuth(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	} else if !handled {
+		t.Error("the user should be locked")
+	}
+
+	clock.Advance(harness.ab.Modules.LockDuration)
+	if handled, err := harness.lock.BeforeAuth(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	} else if handled {
+		t.Error("the lock should have expired")
+	}
 }
 
 func TestAfterAuthSuccess(t *testing.T) {
@@ -172,7 +207,7 @@ func TestAfterAuthFailure(t *testing.T) {
 	}
 	harness.storer.Users["test@test.com"] = user
 
-	if IsLocked(harness.storer.Users["test@test.com"]) {
+	if IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 		t.Error("should not be locked")
 	}
 
@@ -183,7 +218,7 @@ func TestAfterAuthFailure(t *testing.T) {
 	var err error
 
 	for i := 1; i <= 3; i++ {
-		if IsLocked(harness.storer.Users["test@test.com"]) {
+		if IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 			t.Error("should not be locked")
 		}
 
@@ -202,7 +237,7 @@ func TestAfterAuthFailure(t *testing.T) {
 			if user.GetAttemptCount() != i {
 				t.Errorf("attempt count wrong, want: %d, got: %d", i, user.GetAttemptCount())
 			}
-			if IsLocked(user) {
+			if IsLocked(user, time.Now()) {
 				t.Error("should not be locked")
 			}
 		}
@@ -212,7 +247,7 @@ func TestAfterAuthFailure(t *testing.T) {
 		t.Error("should have been handled at the end")
 	}
 
-	if !IsLocked(harness.storer.Users["test@test.com"]) {
+	if !IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 		t.Error("should be locked at the end")
 	}
 
@@ -230,6 +265,250 @@ func TestAfterAuthFailure(t *testing.T) {
 	}
 }
 
+func TestAfterAuthFailurePreventEnumeration(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.PreventUserEnumeration = true
+
+	user := &mocks.User{
+		Email:        "test@test.com",
+		AttemptCount: 2,
+		LastAttempt:  time.Now().UTC(),
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	handled, err := harness.lock.AfterAuthFail(httptest.NewRecorder(), r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled {
+		t.Error("a wrong password should not reveal the account was locked")
+	}
+	if !IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
+		t.Error("should be locked")
+	}
+}
+
+func TestChallengeRequiredAccount(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.LockChallengeAfter = 2
+	if err := harness.lock.Init(harness.ab); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{Email: "test@test.com", AttemptCount: 1, LastAttempt: time.Now().UTC()}
+	harness.storer.Users["test@test.com"] = user
+
+	required := harness.ab.Config.Modules.ChallengeRequired
+	if !required(mocks.Request("GET"), "recover_start") {
+		t.Error("other pages should still always require a challenge")
+	}
+
+	login := func(pid string) bool {
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{PID: pid}))
+		return required(r, pageLogin)
+	}
+
+	if required(mocks.Request("GET"), pageLogin) || login("test@test.com") || login("nobody@test.com") {
+		t.Error("a challenge should not be required yet")
+	}
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if _, err := harness.lock.AfterAuthFail(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	}
+	if !login("test@test.com") {
+		t.Error("a challenge should be required after the failures")
+	}
+
+	user.LastAttempt = time.Now().UTC().Add(-2 * harness.ab.Modules.LockWindow)
+	if login("test@test.com") {
+		t.Error("failures outside of the window should not count")
+	}
+}
+
+func TestChallengeRequiredIP(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Storage.Counter = mocks.NewCounter()
+	harness.ab.Config.Modules.LockChallengeAfterIP = 2
+	harness.ab.Config.Modules.ChallengeRequired = func(r *http.Request, page string) bool { return false }
+	if err := harness.lock.Init(harness.ab); err != nil {
+		t.Fatal(err)
+	}
+
+	required := harness.ab.Config.Modules.ChallengeRequired
+	request := func(addr string) *http.Request {
+		r := mocks.Request("POST")
+		r.RemoteAddr = addr + ":1234"
+		return r
+	}
+
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	for i := 0; i < 2; i++ {
+		if required(request("192.0.2.1"), pageLogin) {
+			t.Errorf("%d) a challenge should not be required yet", i)
+		}
+
+		r := request("192.0.2.1")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, harness.storer.Users["test@test.com"]))
+		if _, err := harness.lock.AfterAuthFail(httptest.NewRecorder(), r, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !required(request("192.0.2.1"), pageLogin) {
+		t.Error("a challenge should be required after the failures")
+	}
+	if required(request("192.0.2.2"), pageLogin) || required(request("192.0.2.1"), "recover_start") {
+		t.Error("other addresses and pages should be left alone")
+	}
+}
+
+func TestChallengeConfig(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Core.Redirector = &mocks.Redirector{}
+	ab.Config.Core.Logger = mocks.Logger{}
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+	ab.Config.Modules.LockChallengeAfterIP = 5
+
+	problems := (&Lock{}).ValidateConfig(ab)
+	if len(problems) != 2 {
+		t.Error("the challenge verifier and counter should be missing:", problems)
+	}
+}
+
+func TestAfterAuthFailureUnlockEmail(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.LockUnlockKey = []byte("key")
+	harness.ab.Modules.MailNoGoroutine = true
+
+	fired := false
+	harness.ab.Events.After(authboss.EventLock, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		fired = true
+		return false, nil
+	})
+
+	user := &mocks.User{
+		Email:        "test@test.com",
+		AttemptCount: 2,
+		LastAttempt:  time.Now().UTC(),
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if _, err := harness.lock.AfterAuthFail(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsLocked(user, time.Now()) {
+		t.Error("should be locked")
+	}
+	if !fired {
+		t.Error("EventLock should have been fired")
+	}
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("unlock e-mail was not sent:", to)
+	}
+	if !strings.Contains(harness.redirector.Options.Failure, "e-mail") {
+		t.Error("the failure should point to the e-mail:", harness.redirector.Options.Failure)
+	}
+}
+
+func TestAfterAuthFailureUnlockBackupEmail(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.LockUnlockKey = []byte("key")
+	harness.ab.Modules.MailNoGoroutine = true
+
+	user := &mocks.User{
+		Email:        "test@test.com",
+		BackupEmail:  "backup@test.com",
+		AttemptCount: 2,
+		LastAttempt:  time.Now().UTC(),
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if _, err := harness.lock.AfterAuthFail(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary address is sent its own e-mail first
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "backup@test.com" {
+		t.Error("unlock e-mail was not sent to the backup address:", to)
+	}
+}
+
+func TestUnlockGet(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.LockUnlockKey = []byte("key")
+	harness.ab.Paths.UnlockOK = "/unlock/ok"
+
+	fired := false
+	harness.ab.Events.After(authboss.EventUnlock, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		fired = true
+		return false, nil
+	})
+
+	locked := time.Now().UTC().Add(time.Hour)
+	user := &mocks.User{
+		Email:  "test@test.com",
+		Locked: locked,
+	}
+	harness.storer.Users["test@test.com"] = user
+
+	tests := []struct {
+		Token string
+		OK    bool
+	}{
+		{signToken([]byte("other"), "test@test.com", locked), false},
+		{signToken([]byte("key"), "test@test.com", locked.Add(-time.Minute)), false},
+		{signToken([]byte("key"), "test@test.com", locked)[4:], false},
+		{signToken([]byte("key"), "test@test.com", locked), true},
+		// The lock the token was sent for is over
+		{signToken([]byte("key"), "test@test.com", locked), false},
+	}
+
+	for i, test := range tests {
+		harness.bodyReader.Return = mocks.Values{Token: test.Token}
+
+		if err := harness.lock.UnlockGet(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+			t.Fatal(i, err)
+		}
+
+		opts := harness.redirector.Options
+		if test.OK {
+			if opts.RedirectPath != "/unlock/ok" || len(opts.Success) == 0 {
+				t.Errorf("%d) should have unlocked: %#v", i, opts)
+			}
+			if IsLocked(user, time.Now()) || !fired {
+				t.Errorf("%d) the user should be unlocked and the event fired", i)
+			}
+		} else if opts.RedirectPath != harness.ab.Paths.LockNotOK || len(opts.Failure) == 0 {
+			t.Errorf("%d) the token should have been rejected: %#v", i, opts)
+		}
+	}
+}
+
 func TestLock(t *testing.T) {
 	t.Parallel()
 
@@ -240,7 +519,7 @@ func TestLock(t *testing.T) {
 	}
 	harness.storer.Users["test@test.com"] = user
 
-	if IsLocked(harness.storer.Users["test@test.com"]) {
+	if IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 		t.Error("should not be locked")
 	}
 
@@ -248,7 +527,7 @@ func TestLock(t *testing.T) {
 		t.Error(err)
 	}
 
-	if !IsLocked(harness.storer.Users["test@test.com"]) {
+	if !IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 		t.Error("should be locked")
 	}
 }
@@ -264,7 +543,7 @@ func TestUnlock(t *testing.T) {
 	}
 	harness.storer.Users["test@test.com"] = user
 
-	if !IsLocked(harness.storer.Users["test@test.com"]) {
+	if !IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 		t.Error("should be locked")
 	}
 
@@ -272,7 +551,7 @@ func TestUnlock(t *testing.T) {
 		t.Error(err)
 	}
 
-	if IsLocked(harness.storer.Users["test@test.com"]) {
+	if IsLocked(harness.storer.Users["test@test.com"], time.Now()) {
 		t.Error("should no longer be locked")
 	}
 }
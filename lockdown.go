@@ -0,0 +1,78 @@
+package authboss
+
+import "net/http"
+
+// lockdownExempt are the modules that are never locked down, logging out
+// only deletes client state
+var lockdownExempt = map[string]bool{"logout": true}
+
+// Lockdown locks down the modules, or every module but logout when none are
+// given, for incident response like a credential stuffing attack. The
+// routes of locked down modules are responded to with
+// Config.Modules.LockdownHandler, or redirected to Paths.LockdownNotOK with
+// TxtLockdown and a 503 for API clients. Users that are already logged in
+// stay logged in. It can be called while serving requests and lasts until
+// EndLockdown, it isn't shared with the app's other servers.
+func (a *Authboss) Lockdown(modules ...string) {
+	a.modulesMut.Lock()
+	defer a.modulesMut.Unlock()
+
+	if len(modules) == 0 {
+		a.lockdown = true
+		return
+	}
+
+	if a.lockedDown == nil {
+		a.lockedDown = make(map[string]bool)
+	}
+	for _, name := range modules {
+		a.lockedDown[name] = true
+	}
+}
+
+// EndLockdown ends the lockdown of the modules, or every lockdown when none
+// are given.
+func (a *Authboss) EndLockdown(modules ...string) {
+	a.modulesMut.Lock()
+	defer a.modulesMut.Unlock()
+
+	if len(modules) == 0 {
+		a.lockdown = false
+		a.lockedDown = nil
+		return
+	}
+
+	for _, name := range modules {
+		delete(a.lockedDown, name)
+	}
+}
+
+// LockedDown checks if the module is locked down, by itself or because
+// everything is.
+func (a *Authboss) LockedDown(name string) bool {
+	a.modulesMut.RLock()
+	defer a.modulesMut.RUnlock()
+
+	return (a.lockdown && !lockdownExempt[name]) || a.lockedDown[name]
+}
+
+// respondLockdown responds to a request for a locked down module's route
+func (a *Authboss) respondLockdown(w http.ResponseWriter, r *http.Request, name string) {
+	logger := a.RequestLogger(r)
+	logger.Infof("module %s is locked down, rejected %s %s", name, r.Method, r.URL.Path)
+
+	if a.Config.Modules.LockdownHandler != nil {
+		a.Config.Modules.LockdownHandler.ServeHTTP(w, r)
+		return
+	}
+
+	ro := RedirectOptions{
+		Code:         http.StatusServiceUnavailable,
+		RedirectPath: a.Config.Paths.LockdownNotOK,
+		Failure:      a.Localize(a.LocaleContext(r), TxtLockdown),
+		FailureCode:  ErrorCodeLockdown,
+	}
+	if err := a.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+		logger.Errorf("failed to redirect in lockdown: %+v", err)
+	}
+}
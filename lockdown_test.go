@@ -0,0 +1,55 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLockdown(t *testing.T) {
+	t.Parallel()
+
+	ab, routeCalls, _ := testMountSetup(t)
+	redirector := &testRedirector{}
+	ab.Config.Core.Redirector = redirector
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		ab.Config.Core.Router.(http.Handler).ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+		return rec.Code
+	}
+
+	ab.Lockdown()
+	if code := serve(); code != http.StatusServiceUnavailable || *routeCalls != 0 {
+		t.Error("the route should be locked down:", code, *routeCalls)
+	}
+	if redirector.Opts.RedirectPath != "/" || redirector.Opts.FailureCode != ErrorCodeLockdown {
+		t.Errorf("redirect was wrong: %#v", redirector.Opts)
+	}
+	if ab.LockedDown("logout") {
+		t.Error("logout should never be locked down")
+	}
+
+	ab.EndLockdown()
+	if serve(); *routeCalls != 1 {
+		t.Error("the route should be served after the lockdown")
+	}
+
+	ab.Lockdown("register")
+	if serve(); *routeCalls != 2 {
+		t.Error("only the module that's locked down should be rejected")
+	}
+
+	ab.Lockdown(testModName)
+	ab.Config.Modules.LockdownHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	if code := serve(); code != http.StatusTeapot || *routeCalls != 2 {
+		t.Error("the LockdownHandler should respond:", code, *routeCalls)
+	}
+
+	ab.EndLockdown(testModName)
+	if serve(); *routeCalls != 3 || !ab.LockedDown("register") {
+		t.Error("only the module's lockdown should have ended")
+	}
+}
@@ -4,14 +4,41 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
+// Fields that RequestLogger adds to FieldLoggers
+const (
+	// LogFieldRequestID is the request's id, see CTXKeyRequestID
+	LogFieldRequestID = "request_id"
+	// LogFieldPID is the pid of the user the request is for, once a module
+	// or middleware has put them in the context
+	LogFieldPID = "pid"
+	// LogFieldModule is the module whose handler is serving the request
+	LogFieldModule = "module"
+)
+
+// HeaderRequestID is where the request's id is read from when it isn't
+// in the context under CTXKeyRequestID
+const HeaderRequestID = "X-Request-Id"
+
 // Logger is the basic logging structure that's required
 type Logger interface {
 	Info(string)
 	Error(string)
 }
 
+// FieldLogger is a Logger that logs key/value fields along with the
+// message, like log/slog (see defaults.SlogLogger). RequestLogger derives
+// loggers with the request's fields from it.
+type FieldLogger interface {
+	Logger
+
+	// With returns a logger that logs the fields with every message,
+	// keyvals alternate between keys and values.
+	With(keyvals ...interface{}) Logger
+}
+
 // ContextLogger creates a logger from a request context
 type ContextLogger interface {
 	FromContext(context.Context) Logger
@@ -25,13 +52,46 @@ type RequestLogger interface {
 // RequestLogger returns a request logger if possible, if not
 // it calls Logger which tries to do a ContextLogger, and if
 // that fails it will finally get a normal logger.
+//
+// When the logger is a FieldLogger the request's id, the pid of the user
+// and the module are added to it as fields, see the LogField constants.
 func (a *Authboss) RequestLogger(r *http.Request) FmtLogger {
-	logger := a.Config.Core.Logger
-	if reqLogger, ok := logger.(RequestLogger); ok {
-		return FmtLogger{reqLogger.FromRequest(r)}
+	var logger FmtLogger
+	if reqLogger, ok := a.Config.Core.Logger.(RequestLogger); ok {
+		logger = FmtLogger{reqLogger.FromRequest(r)}
+	} else {
+		logger = a.Logger(r.Context())
 	}
 
-	return FmtLogger{a.Logger(r.Context())}
+	if _, ok := logger.Logger.(FieldLogger); !ok {
+		return logger
+	}
+	return logger.With(requestFields(r)...)
+}
+
+// requestFields are the key/value pairs RequestLogger adds
+func requestFields(r *http.Request) []interface{} {
+	var fields []interface{}
+
+	requestID, _ := r.Context().Value(CTXKeyRequestID).(string)
+	if len(requestID) == 0 {
+		requestID = r.Header.Get(HeaderRequestID)
+	}
+	if len(requestID) != 0 {
+		fields = append(fields, LogFieldRequestID, requestID)
+	}
+
+	if user, ok := r.Context().Value(CTXKeyUser).(User); ok {
+		fields = append(fields, LogFieldPID, user.GetPID())
+	} else if pid, ok := r.Context().Value(CTXKeyPID).(string); ok {
+		fields = append(fields, LogFieldPID, pid)
+	}
+
+	if module, ok := r.Context().Value(CTXKeyModule).(string); ok {
+		fields = append(fields, LogFieldModule, module)
+	}
+
+	return fields
 }
 
 // Logger returns an appopriate logger for the context:
@@ -69,3 +129,38 @@ func (f FmtLogger) Errorf(format string, values ...interface{}) {
 func (f FmtLogger) Infof(format string, values ...interface{}) {
 	f.Logger.Info(fmt.Sprintf(format, values...))
 }
+
+// With adds the key/value pairs to every message. A FieldLogger gets them
+// as fields, other loggers get them appended to the message as key=value.
+func (f FmtLogger) With(keyvals ...interface{}) FmtLogger {
+	if len(keyvals) == 0 {
+		return f
+	}
+	if fieldLogger, ok := f.Logger.(FieldLogger); ok {
+		return FmtLogger{fieldLogger.With(keyvals...)}
+	}
+	return FmtLogger{suffixLogger{Logger: f.Logger, suffix: formatFields(keyvals)}}
+}
+
+// suffixLogger appends formatted fields to the messages of a Logger that
+// doesn't take fields
+type suffixLogger struct {
+	Logger
+
+	suffix string
+}
+
+func (s suffixLogger) Info(msg string)  { s.Logger.Info(msg + s.suffix) }
+func (s suffixLogger) Error(msg string) { s.Logger.Error(msg + s.suffix) }
+
+func formatFields(keyvals []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", keyvals[i], value)
+	}
+	return b.String()
+}
@@ -64,3 +64,56 @@ func TestFmtLogger(t *testing.T) {
 		t.Error("wrong output", logger.info)
 	}
 }
+
+func TestFmtLoggerWith(t *testing.T) {
+	t.Parallel()
+
+	logger := &testLogger{}
+	FmtLogger{logger}.With("pid", "test@test.com", "odd").Infof("logged %s", "in")
+
+	if logger.info != "logged in pid=test@test.com odd=!MISSING" {
+		t.Error("fields should be appended for loggers without fields:", logger.info)
+	}
+}
+
+type testFieldLogger struct {
+	info   string
+	fields []interface{}
+}
+
+func (t *testFieldLogger) Info(s string)  { t.info += s }
+func (t *testFieldLogger) Error(s string) {}
+
+func (t *testFieldLogger) With(keyvals ...interface{}) Logger {
+	t.fields = append(t.fields, keyvals...)
+	return t
+}
+
+func TestRequestLoggerFields(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	logger := &testFieldLogger{}
+	ab.Config.Core.Logger = logger
+
+	r := httptest.NewRequest("GET", "/", nil)
+	ctx := context.WithValue(r.Context(), CTXKeyRequestID, "abc")
+	ctx = context.WithValue(ctx, CTXKeyUser, &mockUser{Email: "test@test.com"})
+	ctx = context.WithValue(ctx, CTXKeyModule, "auth")
+
+	ab.RequestLogger(r.WithContext(ctx)).Info("hello")
+
+	want := []interface{}{LogFieldRequestID, "abc", LogFieldPID, "test@test.com", LogFieldModule, "auth"}
+	if len(logger.fields) != len(want) {
+		t.Fatal("fields were wrong:", logger.fields)
+	}
+	for i := range want {
+		if logger.fields[i] != want[i] {
+			t.Error("fields were wrong:", logger.fields)
+			break
+		}
+	}
+	if logger.info != "hello" {
+		t.Error("message was wrong:", logger.info)
+	}
+}
@@ -17,6 +17,19 @@ type Logout struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what logging out needs
+func (l *Logout) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.Redirector", "Core.Logger", "Storage.SessionState")
+
+	switch ab.Config.Modules.LogoutMethod {
+	case "GET", "POST", "DELETE":
+	default:
+		problems = append(problems, authboss.ConfigProblem{Field: "Modules.LogoutMethod", Problem: "must be GET, POST or DELETE"})
+	}
+
+	return problems
+}
+
 // Init the module
 func (l *Logout) Init(ab *authboss.Authboss) error {
 	l.Authboss = ab
@@ -38,6 +51,16 @@ func (l *Logout) Init(ab *authboss.Authboss) error {
 	return nil
 }
 
+// APIOperations documents the logout route for authboss.OpenAPI
+func (l *Logout) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{{
+		Method:    ab.Config.Modules.LogoutMethod,
+		Path:      "/logout",
+		Summary:   "Log out of the session",
+		Redirects: true,
+	}}
+}
+
 // Logout the user
 func (l *Logout) Logout(w http.ResponseWriter, r *http.Request) error {
 	logger := l.RequestLogger(r)
@@ -68,10 +91,17 @@ func (l *Logout) Logout(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	handled, err = l.Authboss.Events.FireAfter(authboss.EventSessionDestroy, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: l.Authboss.Paths.LogoutOK,
-		Success:      "You have been logged out",
+		RedirectPath: l.Authboss.RedirectPath(r, "logout", authboss.RedirectOK, user, l.Authboss.RequestConfig(r.Context()).Paths.LogoutOK),
+		Success:      l.Localize(r.Context(), authboss.TxtLoggedOut),
 	}
 	return l.Authboss.Core.Redirector.Redirect(w, r, ro)
 }
@@ -105,6 +105,15 @@ func TestLogoutLogout(t *testing.T) {
 	h.session.ClientValues[authboss.SessionLastAction] = time.Now().UTC().Format(time.RFC3339)
 	h.cookies.ClientValues[authboss.CookieRemember] = "token"
 
+	var events []authboss.Event
+	for _, e := range []authboss.Event{authboss.EventLogout, authboss.EventSessionDestroy} {
+		e := e
+		h.ab.Events.After(e, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+			events = append(events, e)
+			return false, nil
+		})
+	}
+
 	r := mocks.Request("POST")
 	resp := httptest.NewRecorder()
 	w := h.ab.NewResponse(resp)
@@ -143,4 +152,7 @@ func TestLogoutLogout(t *testing.T) {
 	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
 		t.Error("want remember me cookies gone")
 	}
+	if len(events) != 2 || events[0] != authboss.EventLogout || events[1] != authboss.EventSessionDestroy {
+		t.Error("events were wrong:", events)
+	}
 }
@@ -0,0 +1,72 @@
+package authboss
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlDropRegex   = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)>|<!--.*?-->`)
+	htmlLinkRegex   = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	htmlBreakRegex  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlBlockRegex  = regexp.MustCompile(`(?i)</?(p|div|h[1-6]|ul|ol|table|tr|blockquote)\b[^>]*>`)
+	htmlItemRegex   = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	htmlTagRegex    = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRegex      = regexp.MustCompile(`[ \t\r\f\v]+`)
+	blankLinesRegex = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+	paragraphRegex  = regexp.MustCompile(`\n\s*\n`)
+	textURLRegex    = regexp.MustCompile(`(https?://[^\s<]+)`)
+)
+
+// htmlToText makes the plaintext part of an e-mail that only has an html
+// template. Links keep their url after their text since that's usually
+// what the e-mail is for (confirming, recovering).
+func htmlToText(body string) string {
+	body = htmlDropRegex.ReplaceAllString(body, "")
+	body = htmlLinkRegex.ReplaceAllStringFunc(body, func(link string) string {
+		m := htmlLinkRegex.FindStringSubmatch(link)
+		href, text := m[1], strings.TrimSpace(htmlTagRegex.ReplaceAllString(m[2], ""))
+		if len(text) == 0 || text == href {
+			return href
+		}
+		return text + " (" + href + ")"
+	})
+
+	// Whitespace in html is insignificant, newlines come from the tags
+	body = strings.Join(strings.Fields(body), " ")
+	body = htmlBreakRegex.ReplaceAllString(body, "\n")
+	body = htmlBlockRegex.ReplaceAllString(body, "\n\n")
+	body = htmlItemRegex.ReplaceAllString(body, "\n- ")
+	body = htmlTagRegex.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+
+	body = spaceRegex.ReplaceAllString(body, " ")
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	body = blankLinesRegex.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return strings.TrimSpace(body) + "\n"
+}
+
+// textToHTML makes the html part of an e-mail that only has a text
+// template, paragraphs and line breaks are kept and urls are linked.
+func textToHTML(body string) string {
+	b := &strings.Builder{}
+	b.WriteString("<html><body>")
+
+	for _, paragraph := range paragraphRegex.Split(strings.TrimSpace(body), -1) {
+		paragraph = html.EscapeString(paragraph)
+		paragraph = textURLRegex.ReplaceAllString(paragraph, `<a href="$1">$1</a>`)
+		paragraph = strings.Replace(paragraph, "\n", "<br>\n", -1)
+
+		b.WriteString("<p>")
+		b.WriteString(paragraph)
+		b.WriteString("</p>\n")
+	}
+
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package authboss
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	t.Parallel()
+
+	body := `<html><head><title>Hi</title><style>p { color: red; }</style></head>
+<body>
+	<h1>Reset   your password</h1>
+	<p>Someone asked to reset your password,<br>follow
+	<a href="http://localhost/recover?token=a&amp;b">this link</a> to do it.</p>
+	<ul><li>It expires &lt;soon&gt;</li><li><a href="http://localhost">http://localhost</a></li></ul>
+</body></html>`
+
+	want := `Reset your password
+
+Someone asked to reset your password,
+follow this link (http://localhost/recover?token=a&b) to do it.
+
+- It expires <soon>
+- http://localhost
+`
+	if got := htmlToText(body); got != want {
+		t.Errorf("text was wrong:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTextToHTML(t *testing.T) {
+	t.Parallel()
+
+	body := "Confirm your account:\nhttp://localhost/confirm?token=a&b\n\nThanks <3\n"
+	want := `<html><body><p>Confirm your account:<br>
+<a href="http://localhost/confirm?token=a&amp;b">http://localhost/confirm?token=a&amp;b</a></p>
+<p>Thanks &lt;3</p>
+</body></html>
+`
+	if got := textToHTML(body); got != want {
+		t.Errorf("html was wrong:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -2,13 +2,59 @@ package authboss
 
 import (
 	"context"
+	"time"
 )
 
+// MailFailurePolicy decides what happens when an e-mail that a flow
+// depends on can't be sent, see Config.Modules.MailFailurePolicy.
+type MailFailurePolicy int
+
+// Mail failure policies
+const (
+	// MailFailureLog logs the error and carries on as if the e-mail was
+	// sent, it's the default.
+	MailFailureLog MailFailurePolicy = iota
+	// MailFailureRetry sends the e-mail in the background and retries it
+	// Modules.MailRetries times, waiting Modules.MailRetryBackoff in
+	// between, while the flow carries on.
+	MailFailureRetry
+	// MailFailureError sends the e-mail during the request and tells the
+	// user when it couldn't be sent so they can try again. Since that
+	// reveals whether an account exists while the mailer is down it's
+	// MailFailureRetry when Modules.PreventUserEnumeration is set.
+	MailFailureError
+)
+
+// MailError is returned by SendMail when an e-mail couldn't be sent with
+// MailFailureError, the module should tell the user to try again.
+type MailError struct {
+	Err error
+}
+
+// Error satisfies the error interface
+func (m MailError) Error() string {
+	return "failed to send e-mail: " + m.Err.Error()
+}
+
+// Unwrap the mailer's error
+func (m MailError) Unwrap() error {
+	return m.Err
+}
+
 // Mailer is a type that is capable of sending an e-mail.
 type Mailer interface {
 	Send(context.Context, Email) error
 }
 
+// QueueingMailer is a Mailer that queues e-mails to be sent later, it can
+// report how many are waiting for CollectMetrics.
+type QueueingMailer interface {
+	Mailer
+
+	// QueueLen is the number of e-mails waiting to be sent
+	QueueLen() int
+}
+
 // Email all the things. The ToNames and friends are parallel arrays and must
 // be 0-length or the same length as their counterpart. To omit a name
 // for a user at an index in To simply use an empty string at that
@@ -22,4 +68,83 @@ type Email struct {
 
 	TextBody string
 	HTMLBody string
+
+	// Template is the name of the e-mail's templates without their _html
+	// or _txt suffix and TemplateData is what they were rendered with, so
+	// that mailers for e-mail services can send with templates stored
+	// there instead.
+	Template     string
+	TemplateData HTMLData
+	// Tags categorize the e-mail in e-mail services, the module sending
+	// it is added by Authboss.Email.
+	Tags []string
 }
+
+// SendMail sends an e-mail that a flow depends on with send according to
+// Modules.MailFailurePolicy. It only returns an error (a MailError) with
+// MailFailureError, otherwise failures are logged.
+func (a *Authboss) SendMail(ctx context.Context, send func(context.Context) error) error {
+	policy := a.Config.Modules.MailFailurePolicy
+	if policy == MailFailureError && a.Config.Modules.PreventUserEnumeration {
+		policy = MailFailureRetry
+	}
+
+	switch policy {
+	case MailFailureError:
+		if err := send(ctx); err != nil {
+			a.Logger(ctx).Errorf("%+v", err)
+			return MailError{Err: err}
+		}
+	case MailFailureRetry:
+		// The request's context is cancelled once it's been responded to,
+		// well before the retries are done
+		detached := detachedContext{ctx}
+		a.Background(func() { a.retryMail(detached, send) })
+	default:
+		if a.Config.Modules.MailNoGoroutine {
+			a.logMailErr(ctx, send(ctx))
+		} else {
+			a.Background(func() { a.logMailErr(ctx, send(ctx)) })
+		}
+	}
+
+	return nil
+}
+
+// retryMail sends the e-mail, retrying with backoff
+// (Modules.MailRetries and Modules.MailRetryBackoff) until it's sent.
+func (a *Authboss) retryMail(ctx context.Context, send func(context.Context) error) {
+	logger := a.Logger(ctx)
+
+	backoff := a.Config.Modules.MailRetryBackoff
+	var err error
+	for attempt := 0; attempt <= a.Config.Modules.MailRetries; attempt++ {
+		if attempt > 0 {
+			logger.Infof("retrying in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		if err = send(ctx); err == nil {
+			return
+		}
+	}
+
+	logger.Errorf("gave up after %d retries: %+v", a.Config.Modules.MailRetries, err)
+}
+
+func (a *Authboss) logMailErr(ctx context.Context, err error) {
+	if err != nil {
+		a.Logger(ctx).Errorf("%+v", err)
+	}
+}
+
+// detachedContext keeps a context's values without its deadline or
+// cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
@@ -0,0 +1,80 @@
+package authboss
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/friendsofgo/errors"
+)
+
+func TestSendMail(t *testing.T) {
+	t.Parallel()
+
+	failing := func(attempts *int32, failures int32) func(context.Context) error {
+		return func(context.Context) error {
+			if atomic.AddInt32(attempts, 1) <= failures {
+				return errors.New("mailer is down")
+			}
+			return nil
+		}
+	}
+
+	t.Run("log", func(t *testing.T) {
+		t.Parallel()
+
+		ab := New()
+		ab.Config.Core.Logger = mockLogger{}
+		ab.Config.Modules.MailNoGoroutine = true
+
+		var attempts int32
+		if err := ab.SendMail(context.Background(), failing(&attempts, 1)); err != nil {
+			t.Error("errors should only be logged:", err)
+		}
+		if attempts != 1 {
+			t.Error("it should have tried once:", attempts)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		ab := New()
+		ab.Config.Core.Logger = mockLogger{}
+		ab.Config.Modules.MailFailurePolicy = MailFailureError
+
+		var attempts int32
+		err := ab.SendMail(context.Background(), failing(&attempts, 1))
+		if _, ok := err.(MailError); !ok {
+			t.Errorf("it should return a MailError: %#v", err)
+		}
+
+		if err := ab.SendMail(context.Background(), failing(&attempts, 1)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		t.Parallel()
+
+		ab := New()
+		ab.Config.Core.Logger = mockLogger{}
+		ab.Config.Modules.MailFailurePolicy = MailFailureError
+		ab.Config.Modules.PreventUserEnumeration = true
+		ab.Config.Modules.MailRetryBackoff = 0
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var attempts int32
+		if err := ab.SendMail(ctx, failing(&attempts, 2)); err != nil {
+			t.Error("preventing user enumeration should retry instead:", err)
+		}
+		cancel()
+
+		if err := ab.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 3 {
+			t.Error("it should have retried until it was sent:", attempts)
+		}
+	})
+}
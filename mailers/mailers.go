@@ -0,0 +1,87 @@
+// Package mailers implements authboss.Mailer for the APIs of e-mail
+// services (Amazon SES, SendGrid and Mailgun) without depending on their
+// client libraries.
+//
+// Each mailer can send with templates stored in the service instead of the
+// bodies authboss rendered: Templates maps authboss' template names (like
+// "confirm", see authboss.Email.Template) to the service's templates, which
+// are given authboss.Email.TemplateData. authboss.Email.Tags are sent as
+// the service's tags or categories.
+package mailers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// maxErrorBody is how much of an error response is kept in APIError
+const maxErrorBody = 1024
+
+// APIError is returned when an e-mail service doesn't accept an e-mail
+type APIError struct {
+	Service    string
+	StatusCode int
+	Body       string
+}
+
+// Error satisfies the error interface
+func (a APIError) Error() string {
+	return fmt.Sprintf("%s responded with %d: %s", a.Service, a.StatusCode, a.Body)
+}
+
+// Permanent is true when retrying won't help, the service refused the
+// e-mail rather than being unavailable or rate limiting. See
+// defaults.IsPermanentMailError.
+func (a APIError) Permanent() bool {
+	return a.StatusCode >= 400 && a.StatusCode < 500 &&
+		a.StatusCode != http.StatusRequestTimeout && a.StatusCode != http.StatusTooManyRequests
+}
+
+// do sends the request and turns responses other than 2xx into APIErrors
+func do(ctx context.Context, client *http.Client, service string, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "failed to send e-mail with %s", service)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return APIError{Service: service, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return nil
+}
+
+// template is the service's template for the e-mail, if there is one
+func template(templates map[string]string, mail authboss.Email) (string, bool) {
+	if len(mail.Template) == 0 {
+		return "", false
+	}
+	name, ok := templates[mail.Template]
+	return name, ok
+}
+
+// templateData is the e-mail's template data without the values that
+// can't be sent as JSON, like the request's values
+func templateData(mail authboss.Email) map[string]interface{} {
+	data := make(map[string]interface{}, len(mail.TemplateData))
+	for k, v := range mail.TemplateData {
+		switch v.(type) {
+		case string, bool, int, int64, float64, []string, map[string]string, map[string]interface{}:
+			data[k] = v
+		}
+	}
+	return data
+}
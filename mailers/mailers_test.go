@@ -0,0 +1,282 @@
+package mailers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+type capturedRequest struct {
+	Request *http.Request
+	Body    []byte
+}
+
+func testServer(t *testing.T, status int) (*httptest.Server, *capturedRequest) {
+	t.Helper()
+
+	captured := &capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured.Request = r
+		captured.Body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"message":"nope"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, captured
+}
+
+func testEmail() authboss.Email {
+	return authboss.Email{
+		To:           []string{"a@a.com", "b@b.com"},
+		ToNames:      []string{"Alice", ""},
+		Bcc:          []string{"c@c.com"},
+		From:         "noreply@site.com",
+		FromName:     "Site",
+		ReplyTo:      "help@site.com",
+		Subject:      "Confirm your account",
+		TextBody:     "text",
+		HTMLBody:     "<p>html</p>",
+		Template:     "confirm",
+		TemplateData: authboss.HTMLData{"url": "https://site.com/confirm", "user": struct{}{}},
+		Tags:         []string{"confirm"},
+	}
+}
+
+func TestSendGrid(t *testing.T) {
+	t.Parallel()
+
+	server, captured := testServer(t, http.StatusAccepted)
+	mailer := NewSendGrid("key")
+	mailer.Endpoint = server.URL
+
+	if err := mailer.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := captured.Request.Header.Get("Authorization"); auth != "Bearer key" {
+		t.Error("authorization was wrong:", auth)
+	}
+
+	var msg sendGridMessage
+	if err := json.Unmarshal(captured.Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	p := msg.Personalizations[0]
+	if len(p.To) != 2 || p.To[0].Name != "Alice" || p.To[1].Email != "b@b.com" || len(p.Bcc) != 1 {
+		t.Error("recipients were wrong:", p)
+	}
+	if msg.From.Email != "noreply@site.com" || msg.ReplyTo.Email != "help@site.com" {
+		t.Error("from or reply to was wrong:", msg.From, msg.ReplyTo)
+	}
+	if len(msg.Content) != 2 || msg.Content[0].Value != "text" || msg.Content[1].Type != "text/html" {
+		t.Error("content was wrong:", msg.Content)
+	}
+	if len(msg.Categories) != 1 || msg.Categories[0] != "confirm" {
+		t.Error("categories were wrong:", msg.Categories)
+	}
+}
+
+func TestSendGridTemplate(t *testing.T) {
+	t.Parallel()
+
+	server, captured := testServer(t, http.StatusAccepted)
+	mailer := NewSendGrid("key")
+	mailer.Endpoint = server.URL
+	mailer.Templates = map[string]string{"confirm": "d-123"}
+
+	if err := mailer.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg sendGridMessage
+	if err := json.Unmarshal(captured.Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.TemplateID != "d-123" || len(msg.Content) != 0 {
+		t.Error("it should send with the template:", msg.TemplateID, msg.Content)
+	}
+	data := msg.Personalizations[0].DynamicTemplateData
+	if data["url"] != "https://site.com/confirm" {
+		t.Error("template data was wrong:", data)
+	}
+	if _, ok := data["user"]; ok {
+		t.Error("values that aren't plain data should be left out")
+	}
+}
+
+func TestMailgun(t *testing.T) {
+	t.Parallel()
+
+	server, captured := testServer(t, http.StatusOK)
+	mailer := NewMailgun("mg.site.com", "key")
+	mailer.Endpoint = server.URL
+	mailer.Templates = map[string]string{"recover": "password-reset"}
+
+	if err := mailer.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if captured.Request.URL.Path != "/mg.site.com/messages" {
+		t.Error("path was wrong:", captured.Request.URL.Path)
+	}
+	if user, pass, _ := captured.Request.BasicAuth(); user != "api" || pass != "key" {
+		t.Error("auth was wrong:", user, pass)
+	}
+
+	form, err := url.ParseQuery(string(captured.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to := form["to"]; len(to) != 2 || to[0] != `"Alice" <a@a.com>` || to[1] != "b@b.com" {
+		t.Error("to was wrong:", to)
+	}
+	if form.Get("from") != `"Site" <noreply@site.com>` || form.Get("h:Reply-To") != "help@site.com" {
+		t.Error("from or reply to was wrong:", form)
+	}
+	if form.Get("text") != "text" || form.Get("html") != "<p>html</p>" || len(form.Get("template")) != 0 {
+		t.Error("it should send the bodies when there's no template for the e-mail:", form)
+	}
+	if form.Get("o:tag") != "confirm" {
+		t.Error("tag was wrong:", form.Get("o:tag"))
+	}
+}
+
+func TestMailgunTemplate(t *testing.T) {
+	t.Parallel()
+
+	server, captured := testServer(t, http.StatusOK)
+	mailer := NewMailgun("mg.site.com", "key")
+	mailer.Endpoint = server.URL
+	mailer.Templates = map[string]string{"confirm": "confirm-account"}
+
+	if err := mailer.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := url.ParseQuery(string(captured.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("template") != "confirm-account" || len(form.Get("html")) != 0 {
+		t.Error("it should send with the template:", form)
+	}
+	if vars := form.Get("t:variables"); vars != `{"url":"https://site.com/confirm"}` {
+		t.Error("variables were wrong:", vars)
+	}
+}
+
+func TestSES(t *testing.T) {
+	t.Parallel()
+
+	server, captured := testServer(t, http.StatusOK)
+	mailer := NewSES("us-east-1", SESCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"})
+	mailer.Endpoint = server.URL
+	mailer.ConfigurationSet = "auth"
+
+	if err := mailer.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	if captured.Request.URL.Path != "/v2/email/outbound-emails" {
+		t.Error("path was wrong:", captured.Request.URL.Path)
+	}
+	auth := captured.Request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/ses/aws4_request") {
+		t.Error("authorization was wrong:", auth)
+	}
+	if !strings.Contains(auth, "x-amz-security-token") || captured.Request.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Error("the session token should be sent and signed:", auth)
+	}
+
+	var msg sesSendEmail
+	if err := json.Unmarshal(captured.Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.FromEmailAddress != `"Site" <noreply@site.com>` || len(msg.Destination.ToAddresses) != 2 || len(msg.Destination.BccAddresses) != 1 {
+		t.Error("addresses were wrong:", msg.FromEmailAddress, msg.Destination)
+	}
+	if msg.Content.Simple == nil || msg.Content.Simple.Body.HTML.Data != "<p>html</p>" || msg.Content.Simple.Subject.Data != "Confirm your account" {
+		t.Error("content was wrong:", msg.Content)
+	}
+	if len(msg.EmailTags) != 1 || msg.EmailTags[0].Name != "confirm" || msg.ConfigurationSetName != "auth" {
+		t.Error("tags or configuration set were wrong:", msg.EmailTags, msg.ConfigurationSetName)
+	}
+}
+
+func TestSESTemplate(t *testing.T) {
+	t.Parallel()
+
+	server, captured := testServer(t, http.StatusOK)
+	mailer := NewSES("us-east-1", SESCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	mailer.Endpoint = server.URL
+	mailer.Templates = map[string]string{"confirm": "ConfirmAccount"}
+
+	if err := mailer.Send(context.Background(), testEmail()); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg sesSendEmail
+	if err := json.Unmarshal(captured.Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Content.Simple != nil || msg.Content.Template == nil {
+		t.Fatal("it should send with the template:", msg.Content)
+	}
+	if msg.Content.Template.TemplateName != "ConfirmAccount" || msg.Content.Template.TemplateData != `{"url":"https://site.com/confirm"}` {
+		t.Error("template was wrong:", msg.Content.Template)
+	}
+}
+
+func TestSignV4(t *testing.T) {
+	t.Parallel()
+
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	req.Header = http.Header{}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	creds := SESCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+	signV4(req, nil, creds, "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("signature was wrong:\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	t.Parallel()
+
+	server, _ := testServer(t, http.StatusBadRequest)
+	mailer := NewSendGrid("key")
+	mailer.Endpoint = server.URL
+
+	err := mailer.Send(context.Background(), testEmail())
+	var apiErr APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatal("it should be an APIError:", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Body != `{"message":"nope"}` || !apiErr.Permanent() {
+		t.Error("error was wrong:", apiErr)
+	}
+
+	if (APIError{StatusCode: http.StatusTooManyRequests}).Permanent() {
+		t.Error("rate limiting should not be permanent")
+	}
+	if (APIError{StatusCode: http.StatusServiceUnavailable}).Permanent() {
+		t.Error("server errors should not be permanent")
+	}
+}
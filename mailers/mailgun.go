@@ -0,0 +1,109 @@
+package mailers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Mailgun API base urls
+const (
+	MailgunEndpoint   = "https://api.mailgun.net/v3"
+	MailgunEUEndpoint = "https://api.eu.mailgun.net/v3"
+)
+
+// Mailgun sends e-mails with Mailgun's messages API. Tags are sent as
+// tags and Templates are the names of templates stored in Mailgun.
+type Mailgun struct {
+	// Domain is the sending domain configured in Mailgun
+	Domain string
+	APIKey string
+	// Templates maps authboss' template names to Mailgun's
+	Templates map[string]string
+
+	// Endpoint is MailgunEndpoint if it's empty, use MailgunEUEndpoint
+	// for domains in the EU region
+	Endpoint string
+	// Client is http.DefaultClient if it's nil
+	Client *http.Client
+}
+
+// NewMailgun creates a Mailgun mailer
+func NewMailgun(domain, apiKey string) *Mailgun {
+	return &Mailgun{Domain: domain, APIKey: apiKey}
+}
+
+// Send an e-mail
+func (m *Mailgun) Send(ctx context.Context, mail authboss.Email) error {
+	form := url.Values{}
+	form.Set("from", namedAddress(mail.FromName, mail.From))
+	form.Set("subject", mail.Subject)
+	for i, address := range mail.To {
+		form.Add("to", namedAddress(nameAt(mail.ToNames, i), address))
+	}
+	for i, address := range mail.Cc {
+		form.Add("cc", namedAddress(nameAt(mail.CcNames, i), address))
+	}
+	for i, address := range mail.Bcc {
+		form.Add("bcc", namedAddress(nameAt(mail.BccNames, i), address))
+	}
+	if len(mail.ReplyTo) != 0 {
+		form.Set("h:Reply-To", namedAddress(mail.ReplyToName, mail.ReplyTo))
+	}
+	for _, tag := range mail.Tags {
+		form.Add("o:tag", tag)
+	}
+
+	if name, ok := template(m.Templates, mail); ok {
+		variables, err := json.Marshal(templateData(mail))
+		if err != nil {
+			return errors.Wrap(err, "failed to encode mailgun template variables")
+		}
+		form.Set("template", name)
+		form.Set("t:variables", string(variables))
+	} else {
+		if len(mail.TextBody) != 0 {
+			form.Set("text", mail.TextBody)
+		}
+		if len(mail.HTMLBody) != 0 {
+			form.Set("html", mail.HTMLBody)
+		}
+	}
+
+	endpoint := m.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = MailgunEndpoint
+	}
+	endpoint = fmt.Sprintf("%s/%s/messages", strings.TrimSuffix(endpoint, "/"), m.Domain)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to create mailgun request")
+	}
+	req.SetBasicAuth("api", m.APIKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return do(ctx, m.Client, "mailgun", req)
+}
+
+func namedAddress(name, address string) string {
+	if len(name) == 0 {
+		return address
+	}
+	return (&mail.Address{Name: name, Address: address}).String()
+}
+
+func nameAt(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return ""
+}
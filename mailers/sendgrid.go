@@ -0,0 +1,123 @@
+package mailers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// SendGridEndpoint is SendGrid's v3 mail send endpoint
+const SendGridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid sends e-mails with SendGrid's v3 API. Tags are sent as
+// categories and Templates are dynamic template ids.
+type SendGrid struct {
+	APIKey string
+	// Templates maps authboss' template names to dynamic template ids
+	Templates map[string]string
+
+	// Endpoint is SendGridEndpoint if it's empty
+	Endpoint string
+	// Client is http.DefaultClient if it's nil
+	Client *http.Client
+}
+
+// NewSendGrid creates a SendGrid mailer
+func NewSendGrid(apiKey string) *SendGrid {
+	return &SendGrid{APIKey: apiKey}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridPersonalization struct {
+	To                  []sendGridAddress      `json:"to"`
+	Cc                  []sendGridAddress      `json:"cc,omitempty"`
+	Bcc                 []sendGridAddress      `json:"bcc,omitempty"`
+	DynamicTemplateData map[string]interface{} `json:"dynamic_template_data,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject,omitempty"`
+	Content          []sendGridContent         `json:"content,omitempty"`
+	TemplateID       string                    `json:"template_id,omitempty"`
+	Categories       []string                  `json:"categories,omitempty"`
+}
+
+// Send an e-mail
+func (s *SendGrid) Send(ctx context.Context, mail authboss.Email) error {
+	msg := sendGridMessage{
+		Personalizations: []sendGridPersonalization{{
+			To:  sendGridAddresses(mail.ToNames, mail.To),
+			Cc:  sendGridAddresses(mail.CcNames, mail.Cc),
+			Bcc: sendGridAddresses(mail.BccNames, mail.Bcc),
+		}},
+		From:       sendGridAddress{Email: mail.From, Name: mail.FromName},
+		Subject:    mail.Subject,
+		Categories: mail.Tags,
+	}
+	if len(mail.ReplyTo) != 0 {
+		msg.ReplyTo = &sendGridAddress{Email: mail.ReplyTo, Name: mail.ReplyToName}
+	}
+
+	if id, ok := template(s.Templates, mail); ok {
+		msg.TemplateID = id
+		msg.Personalizations[0].DynamicTemplateData = templateData(mail)
+	} else {
+		if len(mail.TextBody) != 0 {
+			msg.Content = append(msg.Content, sendGridContent{Type: "text/plain", Value: mail.TextBody})
+		}
+		if len(mail.HTMLBody) != 0 {
+			msg.Content = append(msg.Content, sendGridContent{Type: "text/html", Value: mail.HTMLBody})
+		}
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode sendgrid e-mail")
+	}
+
+	endpoint := s.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = SendGridEndpoint
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create sendgrid request")
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	return do(ctx, s.Client, "sendgrid", req)
+}
+
+func sendGridAddresses(names, addresses []string) []sendGridAddress {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	list := make([]sendGridAddress, len(addresses))
+	for i, address := range addresses {
+		list[i].Email = address
+		if i < len(names) {
+			list[i].Name = names[i]
+		}
+	}
+	return list
+}
@@ -0,0 +1,238 @@
+package mailers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// SESCredentials are the AWS credentials requests to SES are signed with,
+// SessionToken is only needed for temporary credentials.
+type SESCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// SES sends e-mails with the Amazon SES v2 API. Tags are sent as message
+// tags named after the tag (with the value "true") and Templates are the
+// names of templates stored in SES.
+type SES struct {
+	Region      string
+	Credentials SESCredentials
+	// CredentialsFunc is called for each e-mail instead of using
+	// Credentials when it's set, for credentials that are rotated
+	CredentialsFunc func(ctx context.Context) (SESCredentials, error)
+	// ConfigurationSet is the name of the SES configuration set to send
+	// with, if any
+	ConfigurationSet string
+	// Templates maps authboss' template names to SES'
+	Templates map[string]string
+
+	// Endpoint is https://email.<Region>.amazonaws.com if it's empty
+	Endpoint string
+	// Client is http.DefaultClient if it's nil
+	Client *http.Client
+}
+
+// NewSES creates an SES mailer
+func NewSES(region string, credentials SESCredentials) *SES {
+	return &SES{Region: region, Credentials: credentials}
+}
+
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset,omitempty"`
+}
+
+type sesBody struct {
+	Text *sesContent `json:"Text,omitempty"`
+	HTML *sesContent `json:"Html,omitempty"`
+}
+
+type sesMessage struct {
+	Subject sesContent `json:"Subject"`
+	Body    sesBody    `json:"Body"`
+}
+
+type sesTemplate struct {
+	TemplateName string `json:"TemplateName"`
+	TemplateData string `json:"TemplateData"`
+}
+
+type sesEmailContent struct {
+	Simple   *sesMessage  `json:"Simple,omitempty"`
+	Template *sesTemplate `json:"Template,omitempty"`
+}
+
+type sesDestination struct {
+	ToAddresses  []string `json:"ToAddresses,omitempty"`
+	CcAddresses  []string `json:"CcAddresses,omitempty"`
+	BccAddresses []string `json:"BccAddresses,omitempty"`
+}
+
+type sesTag struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type sesSendEmail struct {
+	FromEmailAddress     string          `json:"FromEmailAddress"`
+	Destination          sesDestination  `json:"Destination"`
+	ReplyToAddresses     []string        `json:"ReplyToAddresses,omitempty"`
+	Content              sesEmailContent `json:"Content"`
+	EmailTags            []sesTag        `json:"EmailTags,omitempty"`
+	ConfigurationSetName string          `json:"ConfigurationSetName,omitempty"`
+}
+
+// Send an e-mail
+func (s *SES) Send(ctx context.Context, mail authboss.Email) error {
+	msg := sesSendEmail{
+		FromEmailAddress: namedAddress(mail.FromName, mail.From),
+		Destination: sesDestination{
+			ToAddresses:  namedAddresses(mail.ToNames, mail.To),
+			CcAddresses:  namedAddresses(mail.CcNames, mail.Cc),
+			BccAddresses: namedAddresses(mail.BccNames, mail.Bcc),
+		},
+		ConfigurationSetName: s.ConfigurationSet,
+	}
+	if len(mail.ReplyTo) != 0 {
+		msg.ReplyToAddresses = []string{namedAddress(mail.ReplyToName, mail.ReplyTo)}
+	}
+	for _, tag := range mail.Tags {
+		msg.EmailTags = append(msg.EmailTags, sesTag{Name: tag, Value: "true"})
+	}
+
+	if name, ok := template(s.Templates, mail); ok {
+		data, err := json.Marshal(templateData(mail))
+		if err != nil {
+			return errors.Wrap(err, "failed to encode ses template data")
+		}
+		msg.Content.Template = &sesTemplate{TemplateName: name, TemplateData: string(data)}
+	} else {
+		simple := &sesMessage{Subject: sesContent{Data: mail.Subject, Charset: "UTF-8"}}
+		if len(mail.TextBody) != 0 {
+			simple.Body.Text = &sesContent{Data: mail.TextBody, Charset: "UTF-8"}
+		}
+		if len(mail.HTMLBody) != 0 {
+			simple.Body.HTML = &sesContent{Data: mail.HTMLBody, Charset: "UTF-8"}
+		}
+		msg.Content.Simple = simple
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode ses e-mail")
+	}
+
+	endpoint := s.Endpoint
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://email.%s.amazonaws.com", s.Region)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create ses request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	creds := s.Credentials
+	if s.CredentialsFunc != nil {
+		if creds, err = s.CredentialsFunc(ctx); err != nil {
+			return errors.Wrap(err, "failed to get ses credentials")
+		}
+	}
+	signV4(req, body, creds, s.Region, "ses", time.Now().UTC())
+
+	return do(ctx, s.Client, "ses", req)
+}
+
+func namedAddresses(names, addresses []string) []string {
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	list := make([]string, len(addresses))
+	for i, address := range addresses {
+		list[i] = namedAddress(nameAt(names, i), address)
+	}
+	return list
+}
+
+// signV4 signs the request with AWS Signature Version 4, the host and
+// every header already set on the request are signed.
+func signV4(req *http.Request, body []byte, creds SESCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(creds.SessionToken) != 0 {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(headers[name]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,132 @@
+package authboss
+
+import (
+	"context"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Gauges reported by CollectMetrics
+const (
+	// GaugeActiveSessions is the number of sessions in the session store
+	GaugeActiveSessions = "authboss_active_sessions"
+	// GaugeRememberTokens is the number of remember me tokens stored
+	GaugeRememberTokens = "authboss_remember_tokens"
+	// GaugeExpiredTokens is the number of tokens past their expiry that
+	// have not been pruned yet, a number that keeps growing means pruning
+	// isn't happening.
+	GaugeExpiredTokens = "authboss_expired_tokens"
+	// GaugeMailQueueDepth is the number of e-mails waiting to be sent
+	GaugeMailQueueDepth = "authboss_mail_queue_depth"
+)
+
+// Counters and histograms reported by the modules as things happen
+const (
+	// CounterLogins counts logins by LabelModule and LabelResult, failures
+	// are both unknown users and wrong passwords (or codes).
+	CounterLogins = "authboss_logins_total"
+	// CounterTwoFactorValidations counts second factor codes checked when
+	// logging in by LabelModule and LabelResult
+	CounterTwoFactorValidations = "authboss_twofactor_validations_total"
+	// CounterRegistrations counts users that registered
+	CounterRegistrations = "authboss_registrations_total"
+	// CounterLockouts counts users locked after too many failed logins
+	CounterLockouts = "authboss_lockouts_total"
+	// CounterEmails counts e-mails sent by LabelResult
+	CounterEmails = "authboss_emails_total"
+
+	// HistogramLoginDuration is how long checking a user's credentials
+	// takes in seconds by LabelModule, which is mostly password hashing.
+	HistogramLoginDuration = "authboss_login_duration_seconds"
+)
+
+// Labels and their values
+const (
+	// LabelModule is the module that counted the measurement
+	LabelModule = "module"
+	// LabelResult is either ResultSuccess or ResultFailure
+	LabelResult = "result"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Labels tell apart measurements with the same name, like the
+// module that reported them.
+type Labels map[string]string
+
+// Metrics receives measurements from authboss so they can be exported to
+// a monitoring system. See the prometheus package for an implementation.
+type Metrics interface {
+	// Gauge sets the current value of the named measurement
+	Gauge(name string, value float64)
+	// Count adds one to the named counter
+	Count(name string, labels Labels)
+	// Observe adds the value to the named histogram
+	Observe(name string, value float64, labels Labels)
+}
+
+// CountMetric adds one to the named counter in Config.Core.Metrics, it
+// does nothing when there's no Metrics.
+func (a *Authboss) CountMetric(name string, labels Labels) {
+	if a.Config.Core.Metrics != nil {
+		a.Config.Core.Metrics.Count(name, labels)
+	}
+}
+
+// ObserveMetric adds the value to the named histogram in
+// Config.Core.Metrics, it does nothing when there's no Metrics.
+func (a *Authboss) ObserveMetric(name string, value float64, labels Labels) {
+	if a.Config.Core.Metrics != nil {
+		a.Config.Core.Metrics.Observe(name, value, labels)
+	}
+}
+
+// CountLogin counts a login for the module in CounterLogins
+func (a *Authboss) CountLogin(module string, success bool) {
+	a.CountMetric(CounterLogins, Labels{LabelModule: module, LabelResult: result(success)})
+}
+
+// CountTwoFactorValidation counts a second factor code checked by the
+// module in CounterTwoFactorValidations
+func (a *Authboss) CountTwoFactorValidation(module string, success bool) {
+	a.CountMetric(CounterTwoFactorValidations, Labels{LabelModule: module, LabelResult: result(success)})
+}
+
+func result(success bool) string {
+	if success {
+		return ResultSuccess
+	}
+	return ResultFailure
+}
+
+// CollectMetrics reports the gauges to Config.Core.Metrics. The counts come
+// from the ServerStorer if it implements StatsServerStorer and the mail
+// queue depth from the Mailer if it implements QueueingMailer, anything
+// else is skipped.
+//
+// Authboss doesn't call this itself, it should be called periodically or
+// whenever the metrics are scraped.
+func (a *Authboss) CollectMetrics(ctx context.Context) error {
+	metrics := a.Config.Core.Metrics
+	if metrics == nil {
+		return nil
+	}
+
+	if storer, ok := a.Config.Storage.Server.(StatsServerStorer); ok {
+		stats, err := storer.Stats(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to collect storage stats")
+		}
+
+		metrics.Gauge(GaugeActiveSessions, float64(stats.ActiveSessions))
+		metrics.Gauge(GaugeRememberTokens, float64(stats.RememberTokens))
+		metrics.Gauge(GaugeExpiredTokens, float64(stats.ExpiredTokens))
+	}
+
+	if mailer, ok := a.Config.Core.Mailer.(QueueingMailer); ok {
+		metrics.Gauge(GaugeMailQueueDepth, float64(mailer.QueueLen()))
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package authboss
+
+import (
+	"context"
+	"testing"
+
+	"github.com/friendsofgo/errors"
+)
+
+type mockMetrics map[string]float64
+
+func (m mockMetrics) Gauge(name string, value float64)                  { m[name] = value }
+func (m mockMetrics) Count(name string, labels Labels)                  { m[name]++ }
+func (m mockMetrics) Observe(name string, value float64, labels Labels) { m[name] += value }
+
+type mockStatsStorer struct {
+	*mockServerStorer
+
+	stats StorageStats
+	err   error
+}
+
+func (m mockStatsStorer) Stats(ctx context.Context) (StorageStats, error) { return m.stats, m.err }
+
+type mockQueueMailer struct {
+	queued int
+}
+
+func (m mockQueueMailer) Send(context.Context, Email) error { return nil }
+func (m mockQueueMailer) QueueLen() int                     { return m.queued }
+
+func TestCollectMetrics(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	if err := ab.CollectMetrics(context.Background()); err != nil {
+		t.Error("it should do nothing without metrics:", err)
+	}
+
+	metrics := mockMetrics{}
+	ab.Config.Core.Metrics = metrics
+	ab.Config.Storage.Server = newMockServerStorer()
+
+	if err := ab.CollectMetrics(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 0 {
+		t.Error("nothing should be reported without a stats storer or queueing mailer:", metrics)
+	}
+
+	ab.Config.Storage.Server = mockStatsStorer{
+		mockServerStorer: newMockServerStorer(),
+		stats:            StorageStats{ActiveSessions: 3, RememberTokens: 2, ExpiredTokens: 1},
+	}
+	ab.Config.Core.Mailer = mockQueueMailer{queued: 5}
+
+	if err := ab.CollectMetrics(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := mockMetrics{
+		GaugeActiveSessions: 3,
+		GaugeRememberTokens: 2,
+		GaugeExpiredTokens:  1,
+		GaugeMailQueueDepth: 5,
+	}
+	for name, value := range want {
+		if metrics[name] != value {
+			t.Errorf("%s was wrong, want %v, got %v", name, value, metrics[name])
+		}
+	}
+
+	ab.Config.Storage.Server = mockStatsStorer{mockServerStorer: newMockServerStorer(), err: errors.New("failed")}
+	if err := ab.CollectMetrics(context.Background()); err == nil {
+		t.Error("it should return the storer's error")
+	}
+}
+
+func TestCountMetric(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.CountLogin("auth", true)
+
+	metrics := mockMetrics{}
+	ab.Config.Core.Metrics = metrics
+
+	ab.CountLogin("auth", true)
+	ab.CountTwoFactorValidation("totp2fa", false)
+	ab.ObserveMetric(HistogramLoginDuration, 0.5, nil)
+
+	if metrics[CounterLogins] != 1 || metrics[CounterTwoFactorValidations] != 1 {
+		t.Error("counts were wrong:", metrics)
+	}
+	if metrics[HistogramLoginDuration] != 0.5 {
+		t.Error("observation was wrong:", metrics)
+	}
+}
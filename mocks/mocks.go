@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,10 +24,14 @@ type User struct {
 	RecoverTokenExpiry time.Time
 	ConfirmSelector    string
 	ConfirmVerifier    string
+	ConfirmExpiry      time.Time
 	Confirmed          bool
 	AttemptCount       int
 	LastAttempt        time.Time
 	Locked             time.Time
+	Suspended          bool
+	SuspendReason      string
+	SuspendedUntil     time.Time
 
 	OAuth2UID      string
 	OAuth2Provider string
@@ -34,6 +39,9 @@ type User struct {
 	OAuth2Refresh  string
 	OAuth2Expiry   time.Time
 
+	OAuth2Identities []authboss.OAuth2Identity
+	OAuth2Profile    authboss.OAuth2Profile
+
 	OTPs           string
 	TOTPSecretKey  string
 	TOTPLastCode   string
@@ -42,7 +50,11 @@ type User struct {
 
 	SMSPhoneNumberSeed string
 
-	Arbitrary map[string]string
+	BackupEmail string
+	LastRename  time.Time
+	AccountType string
+	Arbitrary   map[string]string
+	Extra       authboss.ExtraFields
 }
 
 // GetPID from user
@@ -54,6 +66,12 @@ func (u User) GetEmail() string { return u.Email }
 // GetUsername from user
 func (u User) GetUsername() string { return u.Username }
 
+// GetBackupEmail from user
+func (u User) GetBackupEmail() string { return u.BackupEmail }
+
+// GetLastRename from user
+func (u User) GetLastRename() time.Time { return u.LastRename }
+
 // GetPassword from user
 func (u User) GetPassword() string { return u.Password }
 
@@ -72,6 +90,9 @@ func (u User) GetConfirmSelector() string { return u.ConfirmSelector }
 // GetConfirmVerifier from user
 func (u User) GetConfirmVerifier() string { return u.ConfirmVerifier }
 
+// GetConfirmExpiry from user
+func (u User) GetConfirmExpiry() time.Time { return u.ConfirmExpiry }
+
 // GetConfirmed from user
 func (u User) GetConfirmed() bool { return u.Confirmed }
 
@@ -84,6 +105,15 @@ func (u User) GetLastAttempt() time.Time { return u.LastAttempt }
 // GetLocked from user
 func (u User) GetLocked() time.Time { return u.Locked }
 
+// GetSuspended from user
+func (u User) GetSuspended() bool { return u.Suspended }
+
+// GetSuspendReason from user
+func (u User) GetSuspendReason() string { return u.SuspendReason }
+
+// GetSuspendedUntil from user
+func (u User) GetSuspendedUntil() time.Time { return u.SuspendedUntil }
+
 // IsOAuth2User returns true if the user is an oauth2 user
 func (u User) IsOAuth2User() bool { return len(u.OAuth2Provider) != 0 }
 
@@ -102,6 +132,12 @@ func (u User) GetOAuth2RefreshToken() string { return u.OAuth2Refresh }
 // GetOAuth2Expiry from user
 func (u User) GetOAuth2Expiry() time.Time { return u.OAuth2Expiry }
 
+// GetOAuth2Identities from user
+func (u User) GetOAuth2Identities() []authboss.OAuth2Identity { return u.OAuth2Identities }
+
+// GetAccountType from user
+func (u User) GetAccountType() string { return u.AccountType }
+
 // GetArbitrary from user
 func (u User) GetArbitrary() map[string]string { return u.Arbitrary }
 
@@ -132,6 +168,12 @@ func (u *User) PutUsername(username string) { u.Username = username }
 // PutEmail into user
 func (u *User) PutEmail(email string) { u.Email = email }
 
+// PutBackupEmail into user
+func (u *User) PutBackupEmail(email string) { u.BackupEmail = email }
+
+// PutLastRename into user
+func (u *User) PutLastRename(last time.Time) { u.LastRename = last }
+
 // PutPassword into user
 func (u *User) PutPassword(password string) { u.Password = password }
 
@@ -152,6 +194,9 @@ func (u *User) PutConfirmSelector(confirmSelector string) { u.ConfirmSelector =
 // PutConfirmVerifier into user
 func (u *User) PutConfirmVerifier(confirmVerifier string) { u.ConfirmVerifier = confirmVerifier }
 
+// PutConfirmExpiry into user
+func (u *User) PutConfirmExpiry(expiry time.Time) { u.ConfirmExpiry = expiry }
+
 // PutConfirmed into user
 func (u *User) PutConfirmed(confirmed bool) { u.Confirmed = confirmed }
 
@@ -164,6 +209,15 @@ func (u *User) PutLastAttempt(attemptTime time.Time) { u.LastAttempt = attemptTi
 // PutLocked into user
 func (u *User) PutLocked(locked time.Time) { u.Locked = locked }
 
+// PutSuspended into user
+func (u *User) PutSuspended(suspended bool) { u.Suspended = suspended }
+
+// PutSuspendReason into user
+func (u *User) PutSuspendReason(reason string) { u.SuspendReason = reason }
+
+// PutSuspendedUntil into user
+func (u *User) PutSuspendedUntil(until time.Time) { u.SuspendedUntil = until }
+
 // PutOAuth2UID into user
 func (u *User) PutOAuth2UID(uid string) { u.OAuth2UID = uid }
 
@@ -179,9 +233,36 @@ func (u *User) PutOAuth2RefreshToken(refresh string) { u.OAuth2Refresh = refresh
 // PutOAuth2Expiry into user
 func (u *User) PutOAuth2Expiry(expiry time.Time) { u.OAuth2Expiry = expiry }
 
+// PutOAuth2Profile into user
+func (u *User) PutOAuth2Profile(provider string, profile authboss.OAuth2Profile) {
+	u.OAuth2Profile = profile
+}
+
+// PutOAuth2Identity into user
+func (u *User) PutOAuth2Identity(identity authboss.OAuth2Identity) {
+	u.DelOAuth2Identity(identity.Provider)
+	u.OAuth2Identities = append(u.OAuth2Identities, identity)
+}
+
+// DelOAuth2Identity from user
+func (u *User) DelOAuth2Identity(provider string) {
+	for i, identity := range u.OAuth2Identities {
+		if identity.Provider == provider {
+			u.OAuth2Identities = append(u.OAuth2Identities[:i], u.OAuth2Identities[i+1:]...)
+			return
+		}
+	}
+}
+
+// PutAccountType into user
+func (u *User) PutAccountType(accountType string) { u.AccountType = accountType }
+
 // PutArbitrary into user
 func (u *User) PutArbitrary(arb map[string]string) { u.Arbitrary = arb }
 
+// PutExtraFields into user
+func (u *User) PutExtraFields(extra authboss.ExtraFields) { u.Extra = extra }
+
 // PutOTPs into user
 func (u *User) PutOTPs(otps string) { u.OTPs = otps }
 
@@ -201,16 +282,92 @@ func (u *User) PutRecoveryCodes(codes string) { u.RecoveryCodes = codes }
 type ServerStorer struct {
 	Users    map[string]*User
 	RMTokens map[string][]string
+
+	// RMFamilies maps remember tokens to their family, RMUsed holds the
+	// tokens of a family that have already been used.
+	RMFamilies map[string]string
+	RMUsed     map[string]string
+	RMDevices  map[string][]authboss.RememberDevice
+
+	// Tokens are the api tokens by hash, UsedTokens holds the hashes of
+	// the ones that have been used.
+	Tokens     map[string]authboss.IssuedToken
+	UsedTokens map[string]bool
+
+	Sessions map[string][]authboss.SessionRecord
+	Devices  map[string][]authboss.KnownDevice
+
+	// APIKeys are the personal api keys by hash
+	APIKeys map[string]authboss.APIKey
+
+	// Exports are the sections ExportUser puts for each user
+	Exports map[string]map[string]interface{}
 }
 
 // NewServerStorer constructor
 func NewServerStorer() *ServerStorer {
 	return &ServerStorer{
-		Users:    make(map[string]*User),
-		RMTokens: make(map[string][]string),
+		Users:      make(map[string]*User),
+		RMTokens:   make(map[string][]string),
+		RMFamilies: make(map[string]string),
+		RMUsed:     make(map[string]string),
+		RMDevices:  make(map[string][]authboss.RememberDevice),
+		Tokens:     make(map[string]authboss.IssuedToken),
+		UsedTokens: make(map[string]bool),
+		Sessions:   make(map[string][]authboss.SessionRecord),
+		Devices:    make(map[string][]authboss.KnownDevice),
+		APIKeys:    make(map[string]authboss.APIKey),
+		Exports:    make(map[string]map[string]interface{}),
 	}
 }
 
+// TxServerStorer is a ServerStorer with transactions, the transaction's
+// storer changes the ServerStorer directly and rolling back restores the
+// users to what they were when it began.
+type TxServerStorer struct {
+	*ServerStorer
+
+	Commits   int
+	Rollbacks int
+}
+
+// NewTxServerStorer constructor
+func NewTxServerStorer() *TxServerStorer {
+	return &TxServerStorer{ServerStorer: NewServerStorer()}
+}
+
+// BeginTx starts a transaction
+func (s *TxServerStorer) BeginTx(context.Context) (authboss.TxStorer, error) {
+	users := make(map[string]*User, len(s.Users))
+	for pid, user := range s.Users {
+		u := *user
+		users[pid] = &u
+	}
+
+	return &TxStorer{ServerStorer: s.ServerStorer, parent: s, users: users}, nil
+}
+
+// TxStorer is a transaction of a TxServerStorer
+type TxStorer struct {
+	*ServerStorer
+
+	parent *TxServerStorer
+	users  map[string]*User
+}
+
+// Commit the transaction
+func (t *TxStorer) Commit(context.Context) error {
+	t.parent.Commits++
+	return nil
+}
+
+// Rollback the transaction's changes to the users
+func (t *TxStorer) Rollback(context.Context) error {
+	t.parent.Users = t.users
+	t.parent.Rollbacks++
+	return nil
+}
+
 // New constructs a blank user to later be created
 func (s *ServerStorer) New(context.Context) authboss.User {
 	return &User{}
@@ -246,6 +403,48 @@ func (s *ServerStorer) Save(ctx context.Context, user authboss.User) error {
 	return nil
 }
 
+// LoadByAny finds a user by their email or username
+func (s *ServerStorer) LoadByAny(ctx context.Context, fields []string, identifier string) (authboss.User, error) {
+	for _, u := range s.Users {
+		for _, field := range fields {
+			switch {
+			case field == "email" && u.Email == identifier:
+				return u, nil
+			case field == "username" && u.Username == identifier:
+				return u, nil
+			}
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
+// UserExists checks if any user has the key as their email or username
+func (s *ServerStorer) UserExists(ctx context.Context, key string) (bool, error) {
+	for _, u := range s.Users {
+		if u.Email == key || u.Username == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ChangePID moves the user from their old pid to their new one
+func (s *ServerStorer) ChangePID(ctx context.Context, oldPID string, user authboss.User) error {
+	u := user.(*User)
+	if _, ok := s.Users[u.Email]; ok {
+		return authboss.ErrUserFound
+	}
+	if _, ok := s.Users[oldPID]; !ok {
+		return authboss.ErrUserNotFound
+	}
+
+	delete(s.Users, oldPID)
+	s.Users[u.Email] = u
+	return nil
+}
+
 // NewFromOAuth2 finds a user with the given details, or returns a new one
 func (s *ServerStorer) NewFromOAuth2(ctx context.Context, provider string, details map[string]string) (authboss.OAuth2User, error) {
 	uid := details["uid"]
@@ -279,6 +478,19 @@ func (s *ServerStorer) SaveOAuth2(ctx context.Context, user authboss.OAuth2User)
 	return nil
 }
 
+// LoadByOAuth2Link finds a user by an oauth2 account linked to them
+func (s *ServerStorer) LoadByOAuth2Link(ctx context.Context, provider, uid string) (authboss.OAuth2LinkableUser, error) {
+	for _, v := range s.Users {
+		for _, identity := range v.OAuth2Identities {
+			if identity.Provider == provider && identity.UID == uid {
+				return v, nil
+			}
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
 // LoadByConfirmSelector finds a user by his confirm selector
 func (s *ServerStorer) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
 	for _, v := range s.Users {
@@ -311,6 +523,7 @@ func (s *ServerStorer) AddRememberToken(ctx context.Context, key, token string)
 // DelRememberTokens for a user
 func (s *ServerStorer) DelRememberTokens(ctx context.Context, key string) error {
 	delete(s.RMTokens, key)
+	delete(s.RMDevices, key)
 	return nil
 }
 
@@ -337,6 +550,251 @@ func (s *ServerStorer) UseRememberToken(ctx context.Context, givenKey, token str
 	return authboss.ErrTokenNotFound
 }
 
+// AddRememberFamilyToken for remember me
+func (s *ServerStorer) AddRememberFamilyToken(ctx context.Context, key, family, token string) error {
+	s.RMFamilies[token] = family
+	return s.AddRememberToken(ctx, key, token)
+}
+
+// UseRememberFamilyToken if it exists, marking it used in the process
+func (s *ServerStorer) UseRememberFamilyToken(ctx context.Context, key, token string) (string, error) {
+	if family, ok := s.RMUsed[token]; ok {
+		return family, authboss.ErrTokenReused
+	}
+
+	if err := s.UseRememberToken(ctx, key, token); err != nil {
+		return "", err
+	}
+
+	family := s.RMFamilies[token]
+	delete(s.RMFamilies, token)
+	if len(family) != 0 {
+		s.RMUsed[token] = family
+	}
+	return family, nil
+}
+
+// DelRememberFamily removes all the tokens in the family
+func (s *ServerStorer) DelRememberFamily(ctx context.Context, key, family string) error {
+	for token, f := range s.RMUsed {
+		if f == family {
+			delete(s.RMUsed, token)
+		}
+	}
+
+	var keep []string
+	for _, token := range s.RMTokens[key] {
+		if s.RMFamilies[token] == family {
+			delete(s.RMFamilies, token)
+			continue
+		}
+		keep = append(keep, token)
+	}
+
+	if len(keep) == 0 {
+		delete(s.RMTokens, key)
+	} else {
+		s.RMTokens[key] = keep
+	}
+
+	var devices []authboss.RememberDevice
+	for _, device := range s.RMDevices[key] {
+		if device.Family != family {
+			devices = append(devices, device)
+		}
+	}
+	s.RMDevices[key] = devices
+	return nil
+}
+
+// PutRememberDevice saves the device, replacing the one with its family
+func (s *ServerStorer) PutRememberDevice(ctx context.Context, key string, device authboss.RememberDevice) error {
+	devices := s.RMDevices[key]
+	for i, d := range devices {
+		if d.Family == device.Family {
+			devices[i] = device
+			return nil
+		}
+	}
+
+	s.RMDevices[key] = append(devices, device)
+	return nil
+}
+
+// LoadRememberDevices for a user
+func (s *ServerStorer) LoadRememberDevices(ctx context.Context, key string) ([]authboss.RememberDevice, error) {
+	return s.RMDevices[key], nil
+}
+
+// AddSessionRecord for a user
+func (s *ServerStorer) AddSessionRecord(ctx context.Context, pid string, session authboss.SessionRecord) error {
+	s.Sessions[pid] = append(s.Sessions[pid], session)
+	return nil
+}
+
+// LoadSessionRecords for a user
+func (s *ServerStorer) LoadSessionRecords(ctx context.Context, pid string) ([]authboss.SessionRecord, error) {
+	return s.Sessions[pid], nil
+}
+
+// TouchSessionRecord of a user
+func (s *ServerStorer) TouchSessionRecord(ctx context.Context, pid, id string, lastSeen time.Time) error {
+	for i, session := range s.Sessions[pid] {
+		if session.ID == id {
+			s.Sessions[pid][i].LastSeen = lastSeen
+		}
+	}
+	return nil
+}
+
+// DelSessionRecord of a user
+func (s *ServerStorer) DelSessionRecord(ctx context.Context, pid, id string) error {
+	var keep []authboss.SessionRecord
+	for _, session := range s.Sessions[pid] {
+		if session.ID != id {
+			keep = append(keep, session)
+		}
+	}
+	s.Sessions[pid] = keep
+	return nil
+}
+
+// LoadKnownDevices for a user
+func (s *ServerStorer) LoadKnownDevices(ctx context.Context, pid string) ([]authboss.KnownDevice, error) {
+	return s.Devices[pid], nil
+}
+
+// PutKnownDevice saves the device, replacing the one with its id
+func (s *ServerStorer) PutKnownDevice(ctx context.Context, pid string, device authboss.KnownDevice) error {
+	devices := s.Devices[pid]
+	for i, d := range devices {
+		if d.ID == device.ID {
+			devices[i] = device
+			return nil
+		}
+	}
+
+	s.Devices[pid] = append(devices, device)
+	return nil
+}
+
+// DelKnownDevice of a user
+func (s *ServerStorer) DelKnownDevice(ctx context.Context, pid, id string) error {
+	var keep []authboss.KnownDevice
+	for _, device := range s.Devices[pid] {
+		if device.ID != id {
+			keep = append(keep, device)
+		}
+	}
+	s.Devices[pid] = keep
+	return nil
+}
+
+// AddToken stores an api token
+func (s *ServerStorer) AddToken(ctx context.Context, token authboss.IssuedToken) error {
+	s.Tokens[token.Hash] = token
+	return nil
+}
+
+// LoadToken finds an api token
+func (s *ServerStorer) LoadToken(ctx context.Context, hash string) (authboss.IssuedToken, error) {
+	token, ok := s.Tokens[hash]
+	if !ok {
+		return authboss.IssuedToken{}, authboss.ErrTokenNotFound
+	}
+	return token, nil
+}
+
+// UseToken marks an api token used
+func (s *ServerStorer) UseToken(ctx context.Context, hash string) (authboss.IssuedToken, error) {
+	token, ok := s.Tokens[hash]
+	if !ok {
+		return authboss.IssuedToken{}, authboss.ErrTokenNotFound
+	}
+	if s.UsedTokens[hash] {
+		return token, authboss.ErrTokenReused
+	}
+
+	s.UsedTokens[hash] = true
+	return token, nil
+}
+
+// DelTokenFamily deletes all the api tokens in the family
+func (s *ServerStorer) DelTokenFamily(ctx context.Context, pid, family string) error {
+	for hash, token := range s.Tokens {
+		if token.PID == pid && token.Family == family {
+			delete(s.Tokens, hash)
+			delete(s.UsedTokens, hash)
+		}
+	}
+	return nil
+}
+
+// DelTokens deletes all the api tokens of the user
+func (s *ServerStorer) DelTokens(ctx context.Context, pid string) error {
+	for hash, token := range s.Tokens {
+		if token.PID == pid {
+			delete(s.Tokens, hash)
+			delete(s.UsedTokens, hash)
+		}
+	}
+	return nil
+}
+
+// AddAPIKey stores an api key
+func (s *ServerStorer) AddAPIKey(ctx context.Context, key authboss.APIKey) error {
+	s.APIKeys[key.Hash] = key
+	return nil
+}
+
+// LoadAPIKey finds an api key by its hash
+func (s *ServerStorer) LoadAPIKey(ctx context.Context, hash string) (authboss.APIKey, error) {
+	key, ok := s.APIKeys[hash]
+	if !ok {
+		return authboss.APIKey{}, authboss.ErrTokenNotFound
+	}
+	return key, nil
+}
+
+// LoadAPIKeys of a user, oldest first
+func (s *ServerStorer) LoadAPIKeys(ctx context.Context, pid string) ([]authboss.APIKey, error) {
+	var keys []authboss.APIKey
+	for _, key := range s.APIKeys {
+		if key.PID == pid {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys, nil
+}
+
+// DelAPIKey of a user
+func (s *ServerStorer) DelAPIKey(ctx context.Context, pid, id string) error {
+	for hash, key := range s.APIKeys {
+		if key.PID == pid && key.ID == id {
+			delete(s.APIKeys, hash)
+			return nil
+		}
+	}
+	return authboss.ErrTokenNotFound
+}
+
+// ExportUser puts the user's Exports in order of their names
+func (s *ServerStorer) ExportUser(ctx context.Context, pid string, put func(section string, data interface{}) error) error {
+	sections := make([]string, 0, len(s.Exports[pid]))
+	for section := range s.Exports[pid] {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	for _, section := range sections {
+		if err := put(section, s.Exports[pid][section]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // FailStorer is used for testing module initialize functions that
 // recover more than the base storer
 type FailStorer struct {
@@ -571,6 +1029,61 @@ func (r *Redirector) Redirect(w http.ResponseWriter, req *http.Request, ro authb
 	return nil
 }
 
+// Counter keeps sets in memory, they never expire
+type Counter struct {
+	Sets map[string]map[string]struct{}
+}
+
+// NewCounter constructor
+func NewCounter() *Counter {
+	return &Counter{Sets: make(map[string]map[string]struct{})}
+}
+
+// CountDistinct adds the member to the set and counts it
+func (c *Counter) CountDistinct(ctx context.Context, key, member string, window time.Duration) (int, error) {
+	set, ok := c.Sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		c.Sets[key] = set
+	}
+
+	set[member] = struct{}{}
+	return len(set), nil
+}
+
+// Metrics keeps what it's given in memory, counts are by name and result
+// label (eg. "authboss_logins_total:success")
+type Metrics struct {
+	Gauges       map[string]float64
+	Counts       map[string]int
+	Observations map[string][]float64
+}
+
+// NewMetrics constructor
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Gauges:       make(map[string]float64),
+		Counts:       make(map[string]int),
+		Observations: make(map[string][]float64),
+	}
+}
+
+// Gauge sets the value
+func (m *Metrics) Gauge(name string, value float64) { m.Gauges[name] = value }
+
+// Count adds one
+func (m *Metrics) Count(name string, labels authboss.Labels) {
+	if result, ok := labels[authboss.LabelResult]; ok {
+		name += ":" + result
+	}
+	m.Counts[name]++
+}
+
+// Observe appends the value
+func (m *Metrics) Observe(name string, value float64, labels authboss.Labels) {
+	m.Observations[name] = append(m.Observations[name], value)
+}
+
 // Emailer that holds the options it was given
 type Emailer struct {
 	Email authboss.Email
@@ -600,7 +1113,17 @@ type Values struct {
 	Code        string
 	Recovery    string
 	PhoneNumber string
+	IDToken     string
+	AccessToken string
+	Challenge   string
+	Answer      string
+	BackupEmail string
+	UseBackup   bool
 	Remember    bool
+	DeviceName  string
+	InBody      bool
+	Name        string
+	NewName     string
 
 	Errors []error
 }
@@ -615,6 +1138,26 @@ func (v Values) GetPassword() string {
 	return v.Password
 }
 
+// GetChallengeSolution from values
+func (v Values) GetChallengeSolution() string {
+	return v.Challenge
+}
+
+// GetBackupEmail from values
+func (v Values) GetBackupEmail() string {
+	return v.BackupEmail
+}
+
+// GetUseBackupEmail from values
+func (v Values) GetUseBackupEmail() bool {
+	return v.UseBackup
+}
+
+// GetRecoverAnswer from values
+func (v Values) GetRecoverAnswer() string {
+	return v.Answer
+}
+
 // GetToken from values
 func (v Values) GetToken() string {
 	return v.Token
@@ -635,12 +1178,42 @@ func (v Values) GetRecoveryCode() string {
 	return v.Recovery
 }
 
+// GetIDToken from values
+func (v Values) GetIDToken() string {
+	return v.IDToken
+}
+
+// GetAccessToken from values
+func (v Values) GetAccessToken() string {
+	return v.AccessToken
+}
+
 // GetShouldRemember gets the value that tells
 // the remember module if it should remember the user
 func (v Values) GetShouldRemember() bool {
 	return v.Remember
 }
 
+// GetRememberDeviceName from values
+func (v Values) GetRememberDeviceName() string {
+	return v.DeviceName
+}
+
+// GetRememberInBody from values
+func (v Values) GetRememberInBody() bool {
+	return v.InBody
+}
+
+// GetNewName from values
+func (v Values) GetNewName() string {
+	return v.NewName
+}
+
+// GetName from values
+func (v Values) GetName() string {
+	return v.Name
+}
+
 // Validate the values
 func (v Values) Validate() []error {
 	return v.Errors
@@ -649,6 +1222,7 @@ func (v Values) Validate() []error {
 // ArbValues is arbitrary value storage
 type ArbValues struct {
 	Values map[string]string
+	Extra  authboss.ExtraFields
 	Errors []error
 }
 
@@ -662,11 +1236,21 @@ func (a ArbValues) GetPassword() string {
 	return a.Values["password"]
 }
 
+// GetAccountType gets the account type
+func (a ArbValues) GetAccountType() string {
+	return a.Values["account_type"]
+}
+
 // GetValues returns all values
 func (a ArbValues) GetValues() map[string]string {
 	return a.Values
 }
 
+// GetExtraFields returns the extra fields
+func (a ArbValues) GetExtraFields() authboss.ExtraFields {
+	return a.Extra
+}
+
 // Validate nothing
 func (a ArbValues) Validate() []error {
 	return a.Errors
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"reflect"
+	"sort"
 )
 
 var registeredModules = make(map[string]Moduler)
@@ -14,13 +15,36 @@ type Moduler interface {
 	Init(*Authboss) error
 }
 
+// ModuleMounter can be implemented by modules that need to do something
+// once every module has been initialized, like inspecting what the other
+// modules have set up. OnMount is called at the end of Authboss.Init.
+type ModuleMounter interface {
+	OnMount(*Authboss) error
+}
+
+// ModuleShutdowner can be implemented by modules that have to clean up
+// when the application stops, like stopping background goroutines.
+// OnShutdown is called by Authboss.Shutdown.
+type ModuleShutdowner interface {
+	OnShutdown(context.Context) error
+}
+
+// Shutdowner can be implemented by the Mailer, ServerStorer,
+// ClientStateReadWriters and Metrics to be shut down by Authboss.Shutdown,
+// for example to send the e-mails that are still queued or close
+// connections.
+type Shutdowner interface {
+	Shutdown(context.Context) error
+}
+
 // RegisterModule with the core providing all the necessary information to
 // integrate into authboss.
 func RegisterModule(name string, m Moduler) {
 	registeredModules[name] = m
 }
 
-// RegisteredModules returns a list of modules that are currently registered.
+// RegisteredModules returns a list of modules that are currently registered,
+// sorted by name so that Init loads them in the same order every time.
 func RegisteredModules() []string {
 	mods := make([]string, len(registeredModules))
 	i := 0
@@ -29,25 +53,35 @@ func RegisteredModules() []string {
 		i++
 	}
 
+	sort.Strings(mods)
 	return mods
 }
 
-// LoadedModules returns a list of modules that are currently loaded.
+// LoadedModules returns a list of modules that are currently loaded, sorted
+// by name. Modules disabled with DisableModule aren't listed, see
+// Mount.LoadedModules for the ones of a mount.
 func (a *Authboss) LoadedModules() []string {
-	mods := make([]string, len(a.loadedModules))
-	i := 0
-	for k := range a.loadedModules {
-		mods[i] = k
-		i++
+	a.modulesMut.RLock()
+	defer a.modulesMut.RUnlock()
+
+	mods := make([]string, 0, len(a.loadedModules))
+	for _, name := range sortedModules(a.loadedModules) {
+		if !a.disabledModules[name] {
+			mods = append(mods, name)
+		}
 	}
 
 	return mods
 }
 
-// IsLoaded checks if a specific module is loaded.
+// IsLoaded checks if a specific module is loaded and hasn't been disabled
+// with DisableModule.
 func (a *Authboss) IsLoaded(mod string) bool {
+	a.modulesMut.RLock()
+	defer a.modulesMut.RUnlock()
+
 	_, ok := a.loadedModules[mod]
-	return ok
+	return ok && !a.disabledModules[mod]
 }
 
 // loadModule loads a particular module. It uses reflection to create a new
@@ -92,10 +126,15 @@ func (a *Authboss) loadModule(name string) error {
 // Data looks like:
 // map[modulename] = true
 //
+// Only the modules enabled for the request are listed, see ModuleEnabled.
+//
 // oauth2 providers are also listed here using the syntax:
 // oauth2.google for an example. Be careful since this doesn't actually mean
 // that the oauth2 module has been loaded so you should do a conditional
 // that checks for both.
+//
+// When Config.Modules.PIDField is set it's also put in the data under
+// DataPIDField.
 func ModuleListMiddleware(ab *Authboss) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -111,7 +150,9 @@ func ModuleListMiddleware(ab *Authboss) func(http.Handler) http.Handler {
 
 			loaded := make(map[string]bool, len(ab.loadedModules))
 			for k := range ab.loadedModules {
-				loaded[k] = true
+				if ab.ModuleEnabled(r, k) {
+					loaded[k] = true
+				}
 			}
 
 			for provider := range ab.Config.Modules.OAuth2Providers {
@@ -119,6 +160,9 @@ func ModuleListMiddleware(ab *Authboss) func(http.Handler) http.Handler {
 			}
 
 			data[DataModules] = loaded
+			if len(ab.Config.Modules.PIDField) != 0 {
+				data[DataPIDField] = ab.Config.Modules.PIDField
+			}
 			r = r.WithContext(context.WithValue(ctx, CTXKeyData, data))
 			next.ServeHTTP(w, r)
 		})
@@ -104,3 +104,21 @@ func TestModuleLoadedMiddleware(t *testing.T) {
 		t.Error("modules should include oauth2.google")
 	}
 }
+
+func TestModuleLoadedMiddlewarePIDField(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Modules.PIDField = "employee_id"
+
+	var data HTMLData
+	server := ModuleListMiddleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data = r.Context().Value(CTXKeyData).(HTMLData)
+	}))
+
+	server.ServeHTTP(nil, httptest.NewRequest("GET", "/", nil))
+
+	if field := data[DataPIDField]; field != "employee_id" {
+		t.Error("pid field was wrong:", field)
+	}
+}
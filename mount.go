@@ -0,0 +1,186 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Mount serves the routes of the loaded modules like Config.Core.Router,
+// but only the ones of the modules that are enabled for it. It lets one
+// Authboss serve the same modules differently on separate routers, for
+// example registration only on an admin instance:
+//
+//	public := ab.NewMount("register")
+//	admin := ab.NewMount()
+//
+//	publicMux.Handle("/auth/", http.StripPrefix("/auth", public))
+//	adminMux.Handle("/auth/", http.StripPrefix("/auth", admin))
+//
+// The event hooks the disabled modules added while they were initialized
+// aren't called for the mount's requests either. Modules can be enabled
+// and disabled while it's serving requests.
+type Mount struct {
+	ab *Authboss
+
+	mut      sync.RWMutex
+	disabled map[string]bool
+}
+
+// NewMount creates a Mount of the loaded modules, without the ones that
+// are given.
+func (a *Authboss) NewMount(disabled ...string) *Mount {
+	m := &Mount{ab: a, disabled: make(map[string]bool, len(disabled))}
+	for _, name := range disabled {
+		m.disabled[name] = true
+	}
+	return m
+}
+
+// ServeHTTP serves the request with Config.Core.Router, see Middleware.
+func (m *Mount) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Middleware(m.ab.Config.Core.Router).ServeHTTP(w, r)
+}
+
+// Middleware puts the mount in the request's context (see CTXKeyMount) so
+// that ModuleEnabled and ModuleListMiddleware use it. ServeHTTP already
+// does it for authboss' routes, this is for the app's own handlers that
+// are served next to them.
+func (m *Mount) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), CTXKeyMount, m)))
+	})
+}
+
+// Enable the module on the mount again
+func (m *Mount) Enable(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.disabled, name)
+}
+
+// Disable the module on the mount, its routes respond with 404
+func (m *Mount) Disable(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.disabled[name] = true
+}
+
+// IsLoaded checks if the module is loaded and enabled on the mount
+func (m *Mount) IsLoaded(name string) bool {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return !m.disabled[name] && m.ab.IsLoaded(name)
+}
+
+// LoadedModules returns the modules that are loaded and enabled on the
+// mount.
+func (m *Mount) LoadedModules() []string {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	var mods []string
+	for _, name := range m.ab.LoadedModules() {
+		if !m.disabled[name] {
+			mods = append(mods, name)
+		}
+	}
+	return mods
+}
+
+// EnableModule enables a module that was disabled with DisableModule.
+func (a *Authboss) EnableModule(name string) {
+	a.modulesMut.Lock()
+	defer a.modulesMut.Unlock()
+	delete(a.disabledModules, name)
+}
+
+// DisableModule disables a loaded module on every mount and for requests
+// that aren't served by a Mount. Its routes respond with 404 and the event
+// hooks it added while it was initialized aren't called, until it's
+// enabled again.
+func (a *Authboss) DisableModule(name string) {
+	a.modulesMut.Lock()
+	defer a.modulesMut.Unlock()
+
+	if a.disabledModules == nil {
+		a.disabledModules = make(map[string]bool)
+	}
+	a.disabledModules[name] = true
+}
+
+// ModuleEnabled checks if the module is loaded and enabled for the
+// request, on the Mount that serves it if there's one.
+func (a *Authboss) ModuleEnabled(r *http.Request, name string) bool {
+	if m, ok := r.Context().Value(CTXKeyMount).(*Mount); ok {
+		return m.IsLoaded(name)
+	}
+	return a.IsLoaded(name)
+}
+
+// moduleRouter responds with 404 to the requests for the routes of modules
+// that aren't enabled for them, and rejects the ones for modules that are
+// locked down (see Authboss.Lockdown). The routes are added while the module is
+// initialized, Init sets initModule to its name.
+type moduleRouter struct {
+	Router
+	ab *Authboss
+}
+
+func (r moduleRouter) Get(p string, handler http.Handler) {
+	r.Router.Get(p, r.guard(handler))
+}
+
+func (r moduleRouter) Post(p string, handler http.Handler) {
+	r.Router.Post(p, r.guard(handler))
+}
+
+func (r moduleRouter) Delete(p string, handler http.Handler) {
+	r.Router.Delete(p, r.guard(handler))
+}
+
+func (r moduleRouter) Routes() []string {
+	if lister, ok := r.Router.(RouteLister); ok {
+		return lister.Routes()
+	}
+	return nil
+}
+
+func (r moduleRouter) guard(handler http.Handler) http.Handler {
+	name := r.ab.initModule
+	if len(name) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.ab.ModuleEnabled(req, name) {
+			r.ab.RequestLogger(req).Infof("module %s is disabled, not found: %s", name, req.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.ab.LockedDown(name) {
+			r.ab.respondLockdown(w, req, name)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// moduleEventFilter matches the requests the module is enabled for, it's
+// added to the hooks the module adds while it's initialized
+func (a *Authboss) moduleEventFilter(name string) EventFilter {
+	return func(e Event, r *http.Request) bool {
+		return a.ModuleEnabled(r, name)
+	}
+}
+
+// sortedModules is the names of the modules in the map, sorted
+func sortedModules(modules map[string]Moduler) []string {
+	mods := make([]string, 0, len(modules))
+	for name := range modules {
+		mods = append(mods, name)
+	}
+	sort.Strings(mods)
+	return mods
+}
@@ -0,0 +1,132 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// testMountSetup initializes the test module with a route and an event
+// hook, like a module's Init would
+func testMountSetup(t *testing.T) (ab *Authboss, routeCalls, hookCalls *int) {
+	t.Helper()
+
+	ab = New()
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.Router = testRouter{}
+	ab.Config.Modules.CSRFDisabled = true
+	if err := ab.Init(testModName); err != nil {
+		t.Fatal(err)
+	}
+
+	routeCalls, hookCalls = new(int), new(int)
+	ab.wrapModuleHandlers(nil, testModName)
+	ab.Config.Core.Router.Get("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*routeCalls++
+		if _, err := ab.Events.FireAfter(EventRegister, w, r); err != nil {
+			t.Error(err)
+		}
+	}))
+	ab.Events.After(EventRegister, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		*hookCalls++
+		return false, nil
+	})
+	ab.initModule = ""
+	ab.Events.setInitFilter(nil)
+
+	return ab, routeCalls, hookCalls
+}
+
+func TestMount(t *testing.T) {
+	t.Parallel()
+
+	ab, routeCalls, hookCalls := testMountSetup(t)
+	public := ab.NewMount(testModName)
+	admin := ab.NewMount()
+
+	rec := httptest.NewRecorder()
+	public.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	if rec.Code != http.StatusNotFound || *routeCalls != 0 {
+		t.Error("the module's route should not be served by the public mount:", rec.Code)
+	}
+	if mods := public.LoadedModules(); len(mods) != 0 {
+		t.Error("the public mount should have no modules:", mods)
+	}
+
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	if *routeCalls != 1 || *hookCalls != 1 {
+		t.Error("the admin mount should serve the module:", *routeCalls, *hookCalls)
+	}
+	if mods := admin.LoadedModules(); !reflect.DeepEqual(mods, []string{testModName}) {
+		t.Error("the admin mount should have the module:", mods)
+	}
+
+	public.Enable(testModName)
+	admin.Disable(testModName)
+	public.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	admin.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	if *routeCalls != 2 {
+		t.Error("only the public mount should serve the module now:", *routeCalls)
+	}
+}
+
+func TestDisableModule(t *testing.T) {
+	t.Parallel()
+
+	ab, routeCalls, hookCalls := testMountSetup(t)
+	mount := ab.NewMount()
+
+	ab.DisableModule(testModName)
+	if ab.IsLoaded(testModName) || len(ab.LoadedModules()) != 0 || len(mount.LoadedModules()) != 0 {
+		t.Error("the module should not be listed once it's disabled")
+	}
+
+	rec := httptest.NewRecorder()
+	ab.Config.Core.Router.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	mount.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	if rec.Code != http.StatusNotFound || *routeCalls != 0 {
+		t.Error("the module's route should not be served:", rec.Code)
+	}
+
+	// Hooks the module added are skipped, the app's aren't
+	appHookCalls := 0
+	ab.Events.After(EventRegister, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		appHookCalls++
+		return false, nil
+	})
+	if _, err := ab.Events.FireAfter(EventRegister, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if *hookCalls != 0 || appHookCalls != 1 {
+		t.Error("only the app's hook should be called:", *hookCalls, appHookCalls)
+	}
+
+	ab.EnableModule(testModName)
+	ab.Config.Core.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+	if *routeCalls != 1 || *hookCalls != 1 {
+		t.Error("the module should work again once it's enabled:", *routeCalls, *hookCalls)
+	}
+}
+
+func TestModuleListMiddlewareMount(t *testing.T) {
+	t.Parallel()
+
+	ab, _, _ := testMountSetup(t)
+	mount := ab.NewMount(testModName)
+
+	var modules map[string]bool
+	handler := ModuleListMiddleware(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		modules = r.Context().Value(CTXKeyData).(HTMLData)[DataModules].(map[string]bool)
+	}))
+
+	mount.Middleware(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if modules[testModName] {
+		t.Error("the module is disabled on the mount:", modules)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !modules[testModName] {
+		t.Error("the module should be listed without the mount:", modules)
+	}
+}
@@ -0,0 +1,213 @@
+// Package notify e-mails users when something happens to their account
+// that they should know about, so they can act if it wasn't them.
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// EmailNotifyHTML is the name of the html template for e-mails
+	EmailNotifyHTML = "notify_html"
+	// EmailNotifyTxt is the name of the text template for e-mails
+	EmailNotifyTxt = "notify_txt"
+
+	// DataNotification is the kind of notification in the e-mail data,
+	// one of the Notification constants
+	DataNotification = "notification"
+	// DataIP is the ip address of the request that caused the notification
+	DataIP = "ip"
+	// DataUserAgent is the user agent of the request that caused the
+	// notification
+	DataUserAgent = "user_agent"
+	// DataTime is when the notification was sent
+	DataTime = "time"
+
+	// CookieDevice is the cookie that remembers which users have logged in
+	// from the device
+	CookieDevice = "nd"
+
+	// nDevices is how many users a device's cookie remembers
+	nDevices = 5
+)
+
+// Notification kinds
+const (
+	NotificationLock            = "lock"
+	NotificationPasswordChange  = "password_change"
+	NotificationTwoFactorAdd    = "twofactor_add"
+	NotificationTwoFactorRemove = "twofactor_remove"
+	NotificationNewDevice       = "new_device"
+)
+
+var subjects = map[string]authboss.LocalizationKey{
+	NotificationLock:            authboss.TxtNotifyLock,
+	NotificationPasswordChange:  authboss.TxtNotifyPassword,
+	NotificationTwoFactorAdd:    authboss.TxtNotifyTwoFactorAdd,
+	NotificationTwoFactorRemove: authboss.TxtNotifyTwoFactorRemove,
+	NotificationNewDevice:       authboss.TxtNotifyNewDevice,
+}
+
+// User must have an e-mail address to be notified at
+type User interface {
+	authboss.User
+
+	GetEmail() (email string)
+}
+
+func init() {
+	authboss.RegisterModule("notify", &Notify{})
+}
+
+// Notify module
+type Notify struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what sending notifications needs
+func (n *Notify) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Logger", "Core.MailRenderer", "Core.Mailer")
+}
+
+// Init module
+func (n *Notify) Init(ab *authboss.Authboss) error {
+	n.Authboss = ab
+
+	if err := n.Authboss.Config.Core.MailRenderer.Load(EmailNotifyHTML, EmailNotifyTxt); err != nil {
+		return err
+	}
+
+	modules := n.Config.Modules
+	if modules.NotifyLock {
+		n.Events.After(authboss.EventLock, n.notifier(NotificationLock))
+	}
+	if modules.NotifyPasswordChange {
+		n.Events.After(authboss.EventRecoverEnd, n.notifier(NotificationPasswordChange))
+	}
+	if modules.NotifyTwoFactor {
+		n.Events.After(authboss.EventTwoFactorAdd, n.notifier(NotificationTwoFactorAdd))
+		n.Events.After(authboss.EventTwoFactorRemove, n.notifier(NotificationTwoFactorRemove))
+	}
+	if modules.NotifyNewDevice {
+		n.Events.After(authboss.EventAuth, n.AfterAuth)
+		n.Events.After(authboss.EventOAuth2, n.AfterAuth)
+	}
+
+	return nil
+}
+
+// AfterAuth notifies the user if they haven't logged in from the device
+// before. When the device module is used its detection is relied on,
+// otherwise devices are recognized by a cookie so clearing cookies or using
+// a private window makes a device new again.
+func (n *Notify) AfterAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	user, err := n.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	if device := authboss.CurrentDevice(r); device != nil {
+		if device.New {
+			n.notify(r, user, NotificationNewDevice)
+		}
+		return false, nil
+	}
+
+	device := deviceHash(user.GetPID())
+
+	var known []string
+	if cookie, ok := authboss.GetCookie(r, CookieDevice); ok && len(cookie) != 0 {
+		known = strings.Split(cookie, ",")
+	}
+
+	for i, k := range known {
+		if k == device {
+			known = append(known[:i], known[i+1:]...)
+			authboss.PutCookie(w, CookieDevice, strings.Join(append(known, device), ","))
+			return false, nil
+		}
+	}
+
+	if len(known) >= nDevices {
+		known = known[len(known)-nDevices+1:]
+	}
+	authboss.PutCookie(w, CookieDevice, strings.Join(append(known, device), ","))
+
+	n.notify(r, user, NotificationNewDevice)
+	return false, nil
+}
+
+// notifier creates an event handler that sends the notification to the
+// current user
+func (n *Notify) notifier(notification string) authboss.EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		user, err := n.CurrentUser(r)
+		if err != nil {
+			return false, err
+		}
+
+		n.notify(r, user, notification)
+		return false, nil
+	}
+}
+
+func (n *Notify) notify(r *http.Request, user authboss.User, notification string) {
+	logger := n.RequestLogger(r)
+
+	nu, ok := user.(User)
+	if !ok {
+		logger.Errorf("could not send %s notification, user has no e-mail, given type: %T", notification, user)
+		return
+	}
+
+	data := authboss.HTMLData{
+		DataNotification: notification,
+		DataIP:           n.ClientIP(r),
+		DataUserAgent:    r.UserAgent(),
+		DataTime:         n.Now().UTC(),
+	}
+
+	if n.Config.Modules.MailNoGoroutine {
+		n.SendNotifyEmail(r.Context(), nu.GetEmail(), notification, data)
+	} else {
+		n.Authboss.Background(func() { n.SendNotifyEmail(r.Context(), nu.GetEmail(), notification, data) })
+	}
+}
+
+// SendNotifyEmail sends a notification e-mail to a user
+func (n *Notify) SendNotifyEmail(ctx context.Context, to, notification string, data authboss.HTMLData) {
+	logger := n.Authboss.Logger(ctx)
+
+	mail := n.RequestConfig(ctx).Mail
+	email := authboss.Email{
+		To:       []string{to},
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + n.Localize(ctx, subjects[notification]),
+	}
+
+	logger.Infof("sending %s notification e-mail to: %s", notification, to)
+
+	ro := authboss.EmailResponseOptions{
+		Data:         data,
+		HTMLTemplate: EmailNotifyHTML,
+		TextTemplate: EmailNotifyTxt,
+		Module:       "notify",
+	}
+	if err := n.Authboss.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send %s notification e-mail to %s: %+v", notification, to, err)
+	}
+}
+
+// deviceHash identifies the user in the device cookie without putting
+// their pid in it
+func deviceHash(pid string) string {
+	sum := sha256.Sum256([]byte(pid))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
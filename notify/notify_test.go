@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Modules.NotifyLock = true
+	ab.Config.Modules.NotifyNewDevice = true
+
+	renderer := &mocks.Renderer{}
+	ab.Config.Core.MailRenderer = renderer
+
+	n := &Notify{}
+	if err := n.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(EmailNotifyHTML, EmailNotifyTxt); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	notify *Notify
+	ab     *authboss.Authboss
+
+	mailer  *mocks.Emailer
+	cookies *mocks.ClientStateRW
+	storer  *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.mailer = &mocks.Emailer{}
+	harness.cookies = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Modules.MailNoGoroutine = true
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Mailer = harness.mailer
+	harness.ab.Config.Core.MailRenderer = &mocks.Renderer{}
+	harness.ab.Config.Storage.CookieState = harness.cookies
+	harness.ab.Config.Storage.SessionState = mocks.NewClientRW()
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.notify = &Notify{harness.ab}
+
+	return harness
+}
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	if handled, err := h.notify.notifier(NotificationLock)(httptest.NewRecorder(), r, false); err != nil {
+		t.Fatal(err)
+	} else if handled {
+		t.Error("should not be handled")
+	}
+
+	if to := h.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("e-mail was not sent to the user:", to)
+	}
+	if !strings.Contains(h.mailer.Email.Subject, "Locked") {
+		t.Error("subject was wrong:", h.mailer.Email.Subject)
+	}
+}
+
+func TestAfterAuthNewDevice(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+
+	login := func() {
+		h.mailer.Email = authboss.Email{}
+
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		w := h.ab.NewResponse(httptest.NewRecorder())
+
+		var err error
+		if r, err = h.ab.LoadClientState(w, r); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = h.notify.AfterAuth(w, r, false); err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	login()
+	if len(h.mailer.Email.To) == 0 {
+		t.Error("the first login from a device should be notified")
+	}
+	if cookie := h.cookies.ClientValues[CookieDevice]; cookie != deviceHash(user.Email) {
+		t.Error("the device should have been remembered:", cookie)
+	}
+
+	login()
+	if len(h.mailer.Email.To) != 0 {
+		t.Error("a known device should not be notified")
+	}
+
+	h.cookies.ClientValues[CookieDevice] = "a,b,c,d,e"
+	login()
+	if len(h.mailer.Email.To) == 0 {
+		t.Error("the device should have been forgotten")
+	}
+	if cookie := h.cookies.ClientValues[CookieDevice]; cookie != "b,c,d,e,"+deviceHash(user.Email) {
+		t.Error("the oldest user should have been dropped:", cookie)
+	}
+}
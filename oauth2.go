@@ -2,6 +2,7 @@ package authboss
 
 import (
 	"context"
+	"net/http"
 	"net/url"
 
 	"golang.org/x/oauth2"
@@ -28,4 +29,99 @@ type OAuth2Provider struct {
 	OAuth2Config     *oauth2.Config
 	AdditionalParams url.Values
 	FindUserDetails  func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error)
+
+	// FindProfile if set is called after the code exchange to fetch the
+	// user's profile from the provider, typically from its userinfo
+	// endpoint (see the oauth2 package's OIDCProfile). The profile is given
+	// to OAuth2ProfileUsers when they log in and is in the context of the
+	// EventOAuth2 events under CTXKeyOAuth2Profile.
+	FindProfile func(context.Context, oauth2.Config, *oauth2.Token) (OAuth2Profile, error)
+
+	// PKCE enables Proof Key for Code Exchange (RFC 7636) using the S256
+	// challenge method. The code verifier is kept in the session across the
+	// redirect to the provider and sent along with the code exchange.
+	PKCE bool
+
+	// Nonce sends an OpenID Connect nonce with the authorization request
+	// and checks that the ID token the code is exchanged for has it, so an
+	// ID token can't be replayed. The ID token is verified with the keys of
+	// Native.JWKSURL when it's set, otherwise it's trusted for having come
+	// straight from the provider's token endpoint.
+	Nonce bool
+
+	// FormPost requests the provider returns the authorization response with
+	// response_mode=form_post, which means the callback is a cross-site POST
+	// rather than a redirect. The session cookie must not be SameSite=Lax
+	// or Strict for the state to survive it, see the oauth2 package docs.
+	FormPost bool
+
+	// GenerateClientSecret is called before each code exchange to create the
+	// client secret used instead of OAuth2Config.ClientSecret, for providers
+	// that require a short lived secret (like a signed JWT for Apple).
+	GenerateClientSecret func(context.Context) (string, error)
+
+	// Native enables the native app token exchange endpoint for this
+	// provider, see OAuth2NativeConfig.
+	Native *OAuth2NativeConfig
+
+	// Logout ends the user's session with the provider when they log out,
+	// see OAuth2LogoutConfig.
+	Logout *OAuth2LogoutConfig
+}
+
+// OAuth2Profile is a user's profile with an oauth2 provider, normalized
+// across providers
+type OAuth2Profile struct {
+	Name          string
+	Email         string
+	EmailVerified bool
+	AvatarURL     string
+	// Locale is a BCP 47 language tag like en-US
+	Locale string
+}
+
+// OAuth2NativeConfig allows native (mobile/desktop) apps that sign in with
+// the provider's own SDK to exchange the token they receive for an authboss
+// session, since the redirect based flow is a poor fit for them.
+//
+// ID tokens are verified using the provider's JSON Web Key Set and their
+// issuer and audience claims are checked. Access tokens are validated by
+// calling FindUserDetails with them, which proves the token is valid but
+// not which client it was issued to, so they're only accepted when
+// AllowAccessToken is set.
+type OAuth2NativeConfig struct {
+	// JWKSURL is where the provider publishes the keys that sign its
+	// ID tokens.
+	JWKSURL string
+	// Issuers are the accepted values for the iss claim of ID tokens, they
+	// must be set with JWKSURL.
+	Issuers []string
+	// Audiences are the accepted values for the aud claim of ID tokens,
+	// typically the client ids of each of the native apps. If empty the
+	// ClientID of the OAuth2Config is used.
+	Audiences []string
+
+	// AllowAccessToken permits exchanging an access token instead of
+	// an ID token.
+	AllowAccessToken bool
+}
+
+// OAuth2LogoutConfig makes logging out of the app also log users that
+// signed in with the provider out of it, so that a single sign on session
+// doesn't silently outlive the app's.
+type OAuth2LogoutConfig struct {
+	// RevokeURL is the provider's token revocation endpoint (RFC 7009).
+	// If set the user's refresh token (or access token if there is none)
+	// is revoked on logout. Failing to revoke is logged and doesn't stop
+	// the logout.
+	RevokeURL string
+	// EndSessionURL is the provider's logout endpoint (like OpenID
+	// Connect's end_session_endpoint). If set the user is redirected there
+	// after logging out, with client_id and a post_logout_redirect_uri of
+	// Paths.RootURL + Paths.LogoutOK.
+	EndSessionURL string
+
+	// Client is used to make the revocation request, http.DefaultClient
+	// if nil
+	Client *http.Client
 }
@@ -0,0 +1,177 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+const (
+	appleIssuer   = "https://appleid.apple.com"
+	appleKeysURL  = "https://appleid.apple.com/auth/keys"
+	appleAuthURL  = "https://appleid.apple.com/auth/authorize"
+	appleTokenURL = "https://appleid.apple.com/auth/token"
+
+	// Apple allows up to 6 months but since a new one is made for
+	// every exchange there's no reason for it to live long.
+	appleClientSecretLifetime = 5 * time.Minute
+)
+
+// AppleConfig is the configuration needed for Sign in with Apple,
+// the values are all found in the Apple developer account.
+type AppleConfig struct {
+	// ClientID is the Services ID for the web flow
+	ClientID string
+	// TeamID of the developer account
+	TeamID string
+	// KeyID of the private key
+	KeyID string
+	// PrivateKey is the key downloaded as a .p8 file,
+	// see ParseApplePrivateKey.
+	PrivateKey *ecdsa.PrivateKey
+
+	// Scopes to request, defaults to name and email
+	Scopes []string
+
+	// Now tells the time the client secret is issued and the ID token is
+	// validated at, it's time.Now if it's nil. Set it to ab.Now when
+	// Config.Core.Clock is set.
+	Now func() time.Time
+}
+
+func (a AppleConfig) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+// ParseApplePrivateKey parses the contents of a .p8 key file
+func ParseApplePrivateKey(p8 []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, errors.New("failed to decode apple private key pem")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse apple private key")
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("apple private key must be an ecdsa key, got: %T", key)
+	}
+
+	return ecKey, nil
+}
+
+// AppleProvider creates the provider configuration for Sign in with Apple.
+//
+// Apple has no userinfo endpoint so the user details are read from the
+// ID token returned with the access token, and the user's name comes from
+// the form_post callback the first time they sign in.
+func AppleProvider(cfg AppleConfig) authboss.OAuth2Provider {
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"name", "email"}
+	}
+
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID: cfg.ClientID,
+			Scopes:   scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   appleAuthURL,
+				TokenURL:  appleTokenURL,
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		FormPost:             true,
+		GenerateClientSecret: AppleClientSecret(cfg),
+		FindUserDetails:      AppleUserDetails(jwt.NewKeySet(appleKeysURL), cfg.now),
+	}
+}
+
+// AppleClientSecret creates a GenerateClientSecret function that signs
+// the JWT Apple uses in place of a static client secret.
+func AppleClientSecret(cfg AppleConfig) func(context.Context) (string, error) {
+	signer := jwt.ECDSASigner{Key: cfg.PrivateKey, KID: cfg.KeyID}
+
+	return func(context.Context) (string, error) {
+		now := cfg.now().UTC()
+		return jwt.Sign(jwt.Claims{
+			"iss": cfg.TeamID,
+			"iat": now.Unix(),
+			"exp": now.Add(appleClientSecretLifetime).Unix(),
+			"aud": appleIssuer,
+			"sub": cfg.ClientID,
+		}, signer)
+	}
+}
+
+// AppleUserDetails creates a FindUserDetails function that verifies the
+// ID token in the token response with Apple's published keys at the time
+// now tells, time.Now if it's nil.
+func AppleUserDetails(keySet *jwt.KeySet, now func() time.Time) func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error) {
+	if now == nil {
+		now = time.Now
+	}
+
+	return func(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+		idToken, ok := token.Extra("id_token").(string)
+		if !ok || len(idToken) == 0 {
+			return nil, errors.New("apple token response did not include an id_token")
+		}
+
+		expect := jwt.Expectations{
+			Issuers:   []string{appleIssuer},
+			Audiences: []string{cfg.ClientID},
+			Leeway:    idTokenLeeway,
+		}
+		return parseIDToken(ctx, keySet, idToken, expect, now())
+	}
+}
+
+type formPostUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+// formPostUserDetails adds the name and email from the user field of a
+// form_post callback to the details if they're not already present.
+func formPostUserDetails(r *http.Request, details map[string]string) error {
+	raw := r.PostFormValue(FormValueOAuth2User)
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var user formPostUser
+	if err := json.Unmarshal([]byte(raw), &user); err != nil {
+		return errors.Wrap(err, "failed to parse oauth2 form_post user")
+	}
+
+	name := strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+	if _, ok := details[OAuth2Name]; !ok && len(name) != 0 {
+		details[OAuth2Name] = name
+	}
+	if _, ok := details[OAuth2Email]; !ok && len(user.Email) != 0 {
+		details[OAuth2Email] = user.Email
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func appleKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseApplePrivateKey(t *testing.T) {
+	t.Parallel()
+
+	key := appleKey(t)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	parsed, err := ParseApplePrivateKey(p8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.D.Cmp(key.D) != 0 {
+		t.Error("key was parsed incorrectly")
+	}
+
+	if _, err = ParseApplePrivateKey([]byte("not a key")); err == nil {
+		t.Error("it should fail to parse garbage")
+	}
+}
+
+func TestAppleClientSecret(t *testing.T) {
+	t.Parallel()
+
+	key := appleKey(t)
+	gen := AppleClientSecret(AppleConfig{ClientID: "com.example.web", TeamID: "TEAM", KeyID: "KEY", PrivateKey: key})
+
+	secret, err := gen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, claims, err := jwt.Parse(secret, jwt.StaticKey(&key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Algorithm != jwt.ES256 || header.KeyID != "KEY" {
+		t.Errorf("header was wrong: %#v", header)
+	}
+	if claims.Issuer() != "TEAM" || claims.Subject() != "com.example.web" {
+		t.Errorf("claims were wrong: %#v", claims)
+	}
+
+	expect := jwt.Expectations{Audiences: []string{"https://appleid.apple.com"}}
+	if err = claims.Validate(time.Now(), expect); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppleUserDetails(t *testing.T) {
+	t.Parallel()
+
+	key := appleKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwt.JWKS{Keys: []jwt.JWK{jwt.NewECJWK("apple", &key.PublicKey)}})
+	}))
+	defer server.Close()
+
+	idToken, err := jwt.Sign(jwt.Claims{
+		"iss":   "https://appleid.apple.com",
+		"aud":   "com.example.web",
+		"sub":   "001234.abcd",
+		"email": "user@privaterelay.appleid.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}, jwt.ECDSASigner{Key: key, KID: "apple"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := (&oauth2.Token{AccessToken: "token"}).WithExtra(map[string]interface{}{"id_token": idToken})
+
+	find := AppleUserDetails(jwt.NewKeySet(server.URL), nil)
+	details, err := find(context.Background(), oauth2.Config{ClientID: "com.example.web"}, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details[OAuth2UID] != "001234.abcd" {
+		t.Error("uid was wrong:", details[OAuth2UID])
+	}
+	if details[OAuth2Email] != "user@privaterelay.appleid.com" {
+		t.Error("email was wrong:", details[OAuth2Email])
+	}
+
+	if _, err = find(context.Background(), oauth2.Config{ClientID: "someone.else"}, token); err == nil {
+		t.Error("the wrong audience should fail")
+	}
+	if _, err = find(context.Background(), oauth2.Config{ClientID: "com.example.web"}, &oauth2.Token{}); err == nil {
+		t.Error("a missing id token should fail")
+	}
+}
+
+func TestAppleProvider(t *testing.T) {
+	t.Parallel()
+
+	provider := AppleProvider(AppleConfig{ClientID: "com.example.web", TeamID: "TEAM", KeyID: "KEY", PrivateKey: appleKey(t)})
+
+	if !provider.FormPost {
+		t.Error("apple requires form_post")
+	}
+	if provider.GenerateClientSecret == nil || provider.FindUserDetails == nil {
+		t.Error("client secret and user details functions should be set")
+	}
+	if scopes := provider.OAuth2Config.Scopes; len(scopes) != 2 {
+		t.Error("default scopes were wrong:", scopes)
+	}
+}
+
+func TestFormPostInit(t *testing.T) {
+	// No t.Parallel() since the cfg.RedirectURL is set in Init()
+
+	ab := authboss.New()
+	router := &mocks.Router{}
+	ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{
+		"apple": AppleProvider(AppleConfig{ClientID: "com.example.web", PrivateKey: appleKey(t)}),
+	}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	if err := (&OAuth2{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.HasPosts("/oauth2/callback/apple"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFormPostStart(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	google := testProviders["google"]
+	google.FormPost = true
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"google": google}
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "/oauth2/google", nil)
+
+	if err := h.oauth.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	redirect, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := redirect.Query().Get("response_mode"); mode != "form_post" {
+		t.Error("response mode was wrong:", mode)
+	}
+}
+
+func TestFormPostEnd(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	var secretUsed string
+	google := testProviders["google"]
+	google.FormPost = true
+	google.GenerateClientSecret = func(context.Context) (string, error) { return "generated", nil }
+	google.FindUserDetails = func(_ context.Context, cfg oauth2.Config, _ *oauth2.Token) (map[string]string, error) {
+		secretUsed = cfg.ClientSecret
+		return map[string]string{OAuth2UID: "id"}, nil
+	}
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"google": google}
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	form := url.Values{
+		"state": {"state"},
+		"code":  {"code"},
+		"user":  {`{"name":{"firstName":"Jane","lastName":"Doe"},"email":"jane@example.com"}`},
+	}
+	req := httptest.NewRequest("POST", "/oauth2/callback/google", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	h.putState("state")
+	r, err := h.ab.LoadClientState(w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if secretUsed != "generated" {
+		t.Error("the generated client secret should have been used:", secretUsed)
+	}
+	if google.OAuth2Config.ClientSecret != "hands" {
+		t.Error("the provider's config should not have been modified")
+	}
+
+	user, ok := h.storer.Users["oauth2;;google;;id"]
+	if !ok {
+		t.Fatal("the user should have been saved")
+	}
+	if user.Username != "Jane Doe" || user.Email != "jane@example.com" {
+		t.Errorf("the form_post user details were not used: %#v", user)
+	}
+}
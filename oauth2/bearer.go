@@ -0,0 +1,321 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+var (
+	// ErrInvalidToken is returned by a TokenVerifier when the token is
+	// not valid (expired, revoked, bad signature etc.)
+	ErrInvalidToken = errors.New("invalid bearer token")
+)
+
+// TokenInfo is what is known about a verified bearer token
+type TokenInfo struct {
+	// Subject is the user the token was issued to
+	Subject string
+	// ClientID is the client the token was issued for, may be empty
+	ClientID string
+	// Scopes granted to the token
+	Scopes []string
+	// Expiry of the token, the zero value means unknown
+	Expiry time.Time
+}
+
+// HasScope checks if the token was granted a scope
+func (t TokenInfo) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenVerifier verifies a bearer token. It should return ErrInvalidToken
+// (optionally wrapped) if the token itself is not valid, other errors are
+// treated as failures to verify it at all.
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (TokenInfo, error)
+}
+
+// JWTVerifier verifies self-contained JWT access tokens locally
+type JWTVerifier struct {
+	// KeyFunc returns the jwt.KeyFunc used to find the key for a token,
+	// (*jwt.KeySet).KeyFunc can be used directly.
+	KeyFunc func(ctx context.Context) jwt.KeyFunc
+	// Expectations the claims are validated against
+	Expectations jwt.Expectations
+	// Now tells the time the claims are validated at, it's time.Now if it's
+	// nil. BearerMiddleware sets it to Authboss.Now, see ConfigureClock.
+	Now func() time.Time
+}
+
+// NewJWKSVerifier creates a JWTVerifier using the keys published at
+// jwksURL.
+func NewJWKSVerifier(jwksURL string, expect jwt.Expectations) *JWTVerifier {
+	return &JWTVerifier{
+		KeyFunc:      jwt.NewKeySet(jwksURL).KeyFunc,
+		Expectations: expect,
+	}
+}
+
+// ConfigureClock sets Now
+func (j *JWTVerifier) ConfigureClock(now func() time.Time) {
+	j.Now = now
+}
+
+// VerifyToken checks the signature and claims of the token
+func (j *JWTVerifier) VerifyToken(ctx context.Context, token string) (TokenInfo, error) {
+	_, claims, err := jwt.Parse(token, j.KeyFunc(ctx))
+	if err != nil {
+		if err == jwt.ErrMalformed || err == jwt.ErrSignature || err == jwt.ErrAlgorithm || err == jwt.ErrKeyNotFound {
+			return TokenInfo{}, errors.Wrap(ErrInvalidToken, err.Error())
+		}
+		return TokenInfo{}, err
+	}
+
+	now := time.Now
+	if j.Now != nil {
+		now = j.Now
+	}
+	if err = claims.Validate(now().UTC(), j.Expectations); err != nil {
+		return TokenInfo{}, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	info := TokenInfo{
+		Subject:  claims.Subject(),
+		ClientID: claims.String("client_id"),
+		Scopes:   claimScopes(claims),
+	}
+	info.Expiry, _ = claims.Time("exp")
+
+	return info, nil
+}
+
+// claimScopes reads the space separated scope claim (RFC 8693), falling
+// back to the scp array some providers use instead.
+func claimScopes(claims jwt.Claims) []string {
+	if scope := claims.String("scope"); len(scope) != 0 {
+		return strings.Fields(scope)
+	}
+
+	arr, ok := claims["scp"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	scopes := make([]string, 0, len(arr))
+	for _, s := range arr {
+		if str, ok := s.(string); ok {
+			scopes = append(scopes, str)
+		}
+	}
+	return scopes
+}
+
+// IntrospectionVerifier verifies opaque tokens by asking the provider
+// about them using OAuth 2.0 Token Introspection (RFC 7662).
+type IntrospectionVerifier struct {
+	// URL of the introspection endpoint
+	URL string
+	// ClientID and ClientSecret authenticate the resource server to the
+	// introspection endpoint with HTTP basic auth.
+	ClientID     string
+	ClientSecret string
+
+	// Client is used to make the requests, http.DefaultClient if nil
+	Client *http.Client
+}
+
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	Expiry   int64  `json:"exp"`
+}
+
+// VerifyToken asks the introspection endpoint if the token is active
+func (i *IntrospectionVerifier) VerifyToken(ctx context.Context, token string) (TokenInfo, error) {
+	client := i.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, i.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(i.ClientID) != 0 {
+		req.SetBasicAuth(url.QueryEscape(i.ClientID), url.QueryEscape(i.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return TokenInfo{}, errors.Wrap(err, "failed to introspect token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return TokenInfo{}, errors.Errorf("failed to introspect token, status: %d", resp.StatusCode)
+	}
+
+	byt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return TokenInfo{}, errors.Wrap(err, "failed to read introspection response")
+	}
+
+	var ir introspectionResponse
+	if err = json.Unmarshal(byt, &ir); err != nil {
+		return TokenInfo{}, errors.Wrap(err, "failed to parse introspection response")
+	}
+
+	if !ir.Active {
+		return TokenInfo{}, ErrInvalidToken
+	}
+
+	info := TokenInfo{
+		Subject:  ir.Subject,
+		ClientID: ir.ClientID,
+		Scopes:   strings.Fields(ir.Scope),
+	}
+	if ir.Expiry != 0 {
+		info.Expiry = time.Unix(ir.Expiry, 0).UTC()
+	}
+
+	return info, nil
+}
+
+// BearerVerifier chooses between verifying a token locally as a JWT or
+// with introspection based on the token's shape. Either may be nil in
+// which case tokens of that kind are rejected.
+type BearerVerifier struct {
+	JWT    TokenVerifier
+	Opaque TokenVerifier
+}
+
+// ConfigureClock sets the clock of the verifiers that have one
+func (b BearerVerifier) ConfigureClock(now func() time.Time) {
+	for _, verifier := range []TokenVerifier{b.JWT, b.Opaque} {
+		if c, ok := verifier.(authboss.ClockConfigurer); ok {
+			c.ConfigureClock(now)
+		}
+	}
+}
+
+// VerifyToken with the appropriate verifier
+func (b BearerVerifier) VerifyToken(ctx context.Context, token string) (TokenInfo, error) {
+	verifier := b.Opaque
+	if strings.Count(token, ".") == 2 {
+		verifier = b.JWT
+	}
+
+	if verifier == nil {
+		return TokenInfo{}, ErrInvalidToken
+	}
+	return verifier.VerifyToken(ctx, token)
+}
+
+// TokenInfoFromContext retrieves the TokenInfo stored by BearerMiddleware
+func TokenInfoFromContext(ctx context.Context) (TokenInfo, bool) {
+	info, ok := ctx.Value(authboss.CTXKeyBearerToken).(TokenInfo)
+	return info, ok
+}
+
+// BearerMiddleware authenticates API requests using an OAuth2 access token
+// sent in an "Authorization: Bearer" header. When the token is valid the
+// user is loaded into the request context the same way a session would
+// (so authboss.Middleware2 and CurrentUser work as usual) and the
+// TokenInfo is available with TokenInfoFromContext.
+//
+// If provider is not empty the token's subject is the user's uid with that
+// oauth2 provider, otherwise it's assumed to be the user's pid.
+//
+// Requests without a bearer token are passed through untouched, requests
+// with a token that can't be verified are rejected with a 401. A verifier
+// that tells the time itself (see authboss.ClockConfigurer) is set to
+// ab.Now.
+func BearerMiddleware(ab *authboss.Authboss, verifier TokenVerifier, provider string) func(http.Handler) http.Handler {
+	if c, ok := verifier.(authboss.ClockConfigurer); ok {
+		c.ConfigureClock(ab.Now)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+
+			info, err := verifier.VerifyToken(r.Context(), token)
+			if err != nil {
+				if errors.Cause(err) != ErrInvalidToken {
+					logger.Errorf("failed to verify bearer token: %+v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				logger.Infof("rejected bearer token: %v", err)
+				bearerUnauthorized(w)
+				return
+			}
+
+			pid := info.Subject
+			if len(provider) != 0 {
+				pid = authboss.MakeOAuth2PID(provider, info.Subject)
+			}
+
+			user, err := ab.LoadUser(r.Context(), pid)
+			if err == authboss.ErrUserNotFound {
+				logger.Infof("bearer token subject not found: %s", pid)
+				bearerUnauthorized(w)
+				return
+			} else if err != nil {
+				logger.Errorf("failed to load bearer token user: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authboss.CTXKeyPID, pid)
+			ctx = context.WithValue(ctx, authboss.CTXKeyUser, user)
+			ctx = context.WithValue(ctx, authboss.CTXKeyBearerToken, info)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(prefix):])
+	return token, len(token) != 0
+}
+
+func bearerUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,228 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	verifier := &JWTVerifier{
+		KeyFunc:      func(context.Context) jwt.KeyFunc { return jwt.StaticKey(secret) },
+		Expectations: jwt.Expectations{Audiences: []string{"api"}},
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token, err := jwt.Sign(jwt.Claims{
+		"sub":       "test@test.com",
+		"aud":       "api",
+		"client_id": "client",
+		"scope":     "read write",
+		"exp":       exp,
+	}, jwt.HMACSigner{Key: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := verifier.VerifyToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "test@test.com" {
+		t.Error("subject was wrong:", info.Subject)
+	}
+	if info.ClientID != "client" {
+		t.Error("client id was wrong:", info.ClientID)
+	}
+	if !info.HasScope("read") || !info.HasScope("write") || info.HasScope("admin") {
+		t.Error("scopes were wrong:", info.Scopes)
+	}
+	if info.Expiry.Unix() != exp {
+		t.Error("expiry was wrong:", info.Expiry)
+	}
+
+	bad, err := jwt.Sign(jwt.Claims{"sub": "test@test.com", "aud": "other", "exp": exp}, jwt.HMACSigner{Key: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifier.VerifyToken(context.Background(), bad); errors.Cause(err) != ErrInvalidToken {
+		t.Error("wrong audience should be an invalid token:", err)
+	}
+
+	forged, err := jwt.Sign(jwt.Claims{"sub": "test@test.com", "aud": "api", "exp": exp}, jwt.HMACSigner{Key: []byte("other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifier.VerifyToken(context.Background(), forged); errors.Cause(err) != ErrInvalidToken {
+		t.Error("bad signature should be an invalid token:", err)
+	}
+}
+
+func TestIntrospectionVerifier(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "rs" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostFormValue("token") {
+		case "good":
+			_, _ = w.Write([]byte(`{"active":true,"sub":"test@test.com","client_id":"client","scope":"read","exp":1600000000}`))
+		default:
+			_, _ = w.Write([]byte(`{"active":false}`))
+		}
+	}))
+	defer server.Close()
+
+	verifier := &IntrospectionVerifier{URL: server.URL, ClientID: "rs", ClientSecret: "secret"}
+
+	info, err := verifier.VerifyToken(context.Background(), "good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Subject != "test@test.com" || info.ClientID != "client" || !info.HasScope("read") {
+		t.Errorf("info was wrong: %#v", info)
+	}
+	if info.Expiry.Unix() != 1600000000 {
+		t.Error("expiry was wrong:", info.Expiry)
+	}
+
+	if _, err = verifier.VerifyToken(context.Background(), "bad"); err != ErrInvalidToken {
+		t.Error("inactive token should be invalid:", err)
+	}
+
+	verifier.ClientSecret = "wrong"
+	if _, err = verifier.VerifyToken(context.Background(), "good"); err == nil || err == ErrInvalidToken {
+		t.Error("failed client auth should be an error but not an invalid token:", err)
+	}
+}
+
+type mockVerifier struct {
+	tokens map[string]TokenInfo
+	err    error
+}
+
+func (m mockVerifier) VerifyToken(ctx context.Context, token string) (TokenInfo, error) {
+	if m.err != nil {
+		return TokenInfo{}, m.err
+	}
+	info, ok := m.tokens[token]
+	if !ok {
+		return TokenInfo{}, ErrInvalidToken
+	}
+	return info, nil
+}
+
+func TestBearerVerifier(t *testing.T) {
+	t.Parallel()
+
+	verifier := BearerVerifier{
+		JWT:    mockVerifier{tokens: map[string]TokenInfo{"a.b.c": {Subject: "jwt"}}},
+		Opaque: mockVerifier{tokens: map[string]TokenInfo{"opaque": {Subject: "opaque"}}},
+	}
+
+	if info, err := verifier.VerifyToken(context.Background(), "a.b.c"); err != nil || info.Subject != "jwt" {
+		t.Error("jwt should have been verified by the jwt verifier:", info, err)
+	}
+	if info, err := verifier.VerifyToken(context.Background(), "opaque"); err != nil || info.Subject != "opaque" {
+		t.Error("opaque token should have been verified by introspection:", info, err)
+	}
+
+	verifier.Opaque = nil
+	if _, err := verifier.VerifyToken(context.Background(), "opaque"); err != ErrInvalidToken {
+		t.Error("opaque token should be rejected without a verifier:", err)
+	}
+}
+
+func TestBearerMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	storer := mocks.NewServerStorer()
+	storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	storer.Users["oauth2;;google;;id"] = &mocks.User{Email: "google@test.com"}
+	ab.Config.Storage.Server = storer
+	ab.Config.Core.Logger = mocks.Logger{}
+
+	verifier := mockVerifier{tokens: map[string]TokenInfo{
+		"local":   {Subject: "test@test.com", Scopes: []string{"read"}},
+		"google":  {Subject: "id"},
+		"missing": {Subject: "nobody"},
+	}}
+
+	var called bool
+	var user authboss.User
+	var info TokenInfo
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		user, _ = ab.CurrentUser(r)
+		info, _ = TokenInfoFromContext(r.Context())
+	})
+
+	do := func(mw func(http.Handler) http.Handler, authorization string) *httptest.ResponseRecorder {
+		called, user, info = false, nil, TokenInfo{}
+
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api", nil)
+		if len(authorization) != 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		mw(handler).ServeHTTP(rec, r)
+		return rec
+	}
+
+	local := BearerMiddleware(ab, verifier, "")
+
+	do(local, "")
+	if !called || user != nil {
+		t.Error("requests without a token should pass through with no user")
+	}
+
+	do(local, "Bearer local")
+	if !called {
+		t.Fatal("the handler should have been called")
+	}
+	if user == nil || user.GetPID() != "test@test.com" {
+		t.Error("the user should have been loaded:", user)
+	}
+	if !info.HasScope("read") {
+		t.Error("token info should have been stored:", info)
+	}
+
+	for _, authz := range []string{"Bearer bad", "Bearer missing"} {
+		rec := do(local, authz)
+		if called {
+			t.Errorf("%s: the handler should not have been called", authz)
+		}
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: code was wrong: %d", authz, rec.Code)
+		}
+		if h := rec.Header().Get("WWW-Authenticate"); h != `Bearer error="invalid_token"` {
+			t.Errorf("%s: www-authenticate was wrong: %s", authz, h)
+		}
+	}
+
+	do(BearerMiddleware(ab, verifier, "google"), "bearer google")
+	if user == nil || user.(*mocks.User).Email != "google@test.com" {
+		t.Error("the oauth2 user should have been loaded:", user)
+	}
+
+	rec := do(BearerMiddleware(ab, mockVerifier{err: errors.New("down")}, ""), "Bearer local")
+	if called || rec.Code != http.StatusInternalServerError {
+		t.Error("verification errors should be a 500:", rec.Code)
+	}
+}
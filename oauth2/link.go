@@ -0,0 +1,168 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// LinkStart starts the oauth2 process for a logged in user in order to link
+// the provider's account to them. The callback is the same as for logging
+// in (End), which will link the account instead of logging in.
+//
+// These routes are only registered if the ServerStorer implements
+// authboss.OAuth2LinkServerStorer.
+func (o *OAuth2) LinkStart(w http.ResponseWriter, r *http.Request) error {
+	return o.start(w, r, true)
+}
+
+// link the provider account described by details to the logged in user
+func (o *OAuth2) link(w http.ResponseWriter, r *http.Request, provider string, details map[string]string, token *oauth2.Token, params map[string]string) error {
+	logger := o.Authboss.RequestLogger(r)
+
+	current, err := o.Authboss.LoadCurrentUser(&r)
+	if err == authboss.ErrUserNotFound {
+		return errors.New("oauth2 link finished without a logged in user")
+	} else if err != nil {
+		return err
+	}
+
+	user := authboss.MustBeOAuth2Linkable(current)
+	storer := authboss.EnsureCanOAuth2Link(o.Authboss.Config.Storage.Server)
+
+	uid := details[OAuth2UID]
+	if len(uid) == 0 {
+		return errors.Errorf("oauth2 provider %q returned no uid to link", provider)
+	}
+
+	existing, err := storer.LoadByOAuth2Link(r.Context(), provider, uid)
+	switch {
+	case err == nil && existing.GetPID() != user.GetPID():
+		logger.Infof("user %s tried to link %s account already linked to %s", user.GetPID(), provider, existing.GetPID())
+		return o.linkFailure(w, r, authboss.TxtOAuth2LinkedOther, provider)
+	case err != nil && err != authboss.ErrUserNotFound:
+		return err
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, LinkValues{Provider: provider, UID: uid}))
+
+	handled, err := o.Authboss.Events.FireBefore(authboss.EventOAuth2Link, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	user.PutOAuth2Identity(newIdentity(provider, uid, token))
+	if err = o.Authboss.SaveUser(r.Context(), user); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s linked %s account", user.GetPID(), provider)
+
+	handled, err = o.Authboss.Events.FireAfter(authboss.EventOAuth2Link, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	redirect := o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectLinkOK, user, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LinkOK)
+	if path, ok := o.Authboss.SafeRedirect(params[FormValueOAuth2Redir]); ok {
+		redirect = path
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: redirect,
+		Success:      o.Authboss.Localize(r.Context(), authboss.TxtOAuth2Linked, "Provider", strings.Title(provider)),
+	}
+	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// Unlink removes the link between the logged in user and the provider's
+// account.
+func (o *OAuth2) Unlink(w http.ResponseWriter, r *http.Request) error {
+	logger := o.Authboss.RequestLogger(r)
+	provider := strings.ToLower(filepath.Base(r.URL.Path))
+
+	if _, ok := o.Authboss.Config.Modules.OAuth2Providers[provider]; !ok {
+		return errors.Errorf("oauth2 provider %q not found", provider)
+	}
+
+	user := authboss.MustBeOAuth2Linkable(o.Authboss.LoadCurrentUserP(&r))
+	authboss.EnsureCanOAuth2Link(o.Authboss.Config.Storage.Server)
+
+	identity, ok := authboss.GetOAuth2Identity(user, provider)
+	if !ok {
+		return o.linkFailure(w, r, authboss.TxtOAuth2NotLinked, provider)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, LinkValues{Provider: provider, UID: identity.UID}))
+
+	handled, err := o.Authboss.Events.FireBefore(authboss.EventOAuth2Unlink, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	user.DelOAuth2Identity(provider)
+	if err = o.Authboss.SaveUser(r.Context(), user); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s unlinked %s account", user.GetPID(), provider)
+
+	handled, err = o.Authboss.Events.FireAfter(authboss.EventOAuth2Unlink, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectLinkOK, user, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LinkOK),
+		Success:      o.Authboss.Localize(r.Context(), authboss.TxtOAuth2Unlinked, "Provider", strings.Title(provider)),
+	}
+	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// newIdentity creates an identity keeping the tokens received for it
+func newIdentity(provider, uid string, token *oauth2.Token) authboss.OAuth2Identity {
+	return authboss.OAuth2Identity{
+		Provider:     provider,
+		UID:          uid,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}
+}
+
+func (o *OAuth2) linkFailure(w http.ResponseWriter, r *http.Request, message authboss.LocalizationKey, provider string) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectLinkNotOK, nil, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LinkNotOK),
+		Failure:      o.Authboss.Localize(r.Context(), message, "Provider", strings.Title(provider)),
+		FailureCode:  authboss.ErrorCodeOAuth2Failed,
+	}
+	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// LinkValues is put in the context as CTXKeyValues during the
+// EventOAuth2Link and EventOAuth2Unlink events so handlers can tell which
+// account is being linked or unlinked.
+type LinkValues struct {
+	Provider string
+	UID      string
+}
+
+// Validate is a noop, the values do not come from the user
+func (LinkValues) Validate() []error { return nil }
@@ -0,0 +1,211 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestLinkInit(t *testing.T) {
+	// No t.Parallel() since the cfg.RedirectURL is set in Init()
+
+	ab := authboss.New()
+	oauth := &OAuth2{}
+
+	router := &mocks.Router{}
+	ab.Config.Modules.OAuth2Providers = testProviders
+	ab.Config.Core.Router = router
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	ab.Config.Paths.Mount = "/auth"
+	ab.Config.Paths.RootURL = "https://www.example.com"
+
+	if err := oauth.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	gets := []string{
+		"/oauth2/facebook", "/oauth2/callback/facebook", "/oauth2/link/facebook",
+		"/oauth2/google", "/oauth2/callback/google", "/oauth2/link/google",
+	}
+	if err := router.HasGets(gets...); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/oauth2/unlink/facebook", "/oauth2/unlink/google"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLinkStart(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "/oauth2/link/google", nil)
+
+	if err := h.oauth.LinkStart(w, r); err != nil {
+		t.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if h.redirector.Options.Code != http.StatusTemporaryRedirect {
+		t.Error("code was wrong:", h.redirector.Options.Code)
+	}
+	if v := h.session.ClientValues[authboss.SessionOAuth2Link]; v != "google" {
+		t.Error("the link provider should have been saved in the session:", v)
+	}
+}
+
+func linkCallback(t *testing.T, h *testHarness) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	h.putState("state")
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+}
+
+func TestLinkEnd(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Paths.OAuth2LinkOK = "/linked"
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+	h.session.ClientValues[authboss.SessionKey] = user.Email
+	h.session.ClientValues[authboss.SessionOAuth2Link] = "google"
+
+	var linked LinkValues
+	h.ab.Events.After(authboss.EventOAuth2Link, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		linked = r.Context().Value(authboss.CTXKeyValues).(LinkValues)
+		return false, nil
+	})
+
+	linkCallback(t, h)
+
+	if h.redirector.Options.RedirectPath != "/linked" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+	identity, ok := authboss.GetOAuth2Identity(user, "google")
+	if !ok || identity.UID != "id" {
+		t.Error("the account should have been linked:", user.OAuth2Identities)
+	}
+	if identity.AccessToken != "token" || identity.RefreshToken != "refresh" {
+		t.Errorf("the identity's tokens should have been saved: %#v", identity)
+	}
+	if linked.Provider != "google" || linked.UID != "id" {
+		t.Errorf("event values were wrong: %#v", linked)
+	}
+	if _, ok := h.storer.Users["oauth2;;google;;id"]; ok {
+		t.Error("linking should not create an oauth2 user")
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != user.Email {
+		t.Error("the logged in user should not have changed:", pid)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Link]; ok {
+		t.Error("the link marker should have been removed from the session")
+	}
+}
+
+func TestLinkEndAlreadyLinked(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Paths.OAuth2LinkNotOK = "/notlinked"
+
+	user := &mocks.User{Email: "test@test.com"}
+	other := &mocks.User{Email: "other@test.com", OAuth2Identities: []authboss.OAuth2Identity{{Provider: "google", UID: "id"}}}
+	h.storer.Users[user.Email] = user
+	h.storer.Users[other.Email] = other
+	h.session.ClientValues[authboss.SessionKey] = user.Email
+	h.session.ClientValues[authboss.SessionOAuth2Link] = "google"
+
+	linkCallback(t, h)
+
+	if h.redirector.Options.RedirectPath != "/notlinked" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+	if len(h.redirector.Options.Failure) == 0 {
+		t.Error("there should have been a failure message")
+	}
+	if len(user.OAuth2Identities) != 0 {
+		t.Error("the account should not have been linked:", user.OAuth2Identities)
+	}
+}
+
+func TestEndLinkedLogin(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com", OAuth2Identities: []authboss.OAuth2Identity{{Provider: "google", UID: "id"}}}
+	h.storer.Users[user.Email] = user
+
+	linkCallback(t, h)
+
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != user.Email {
+		t.Error("the linked local user should have been logged in:", pid)
+	}
+	if _, ok := h.storer.Users["oauth2;;google;;id"]; ok {
+		t.Error("an oauth2 user should not have been created")
+	}
+	if identity, _ := authboss.GetOAuth2Identity(user, "google"); identity.AccessToken != "token" {
+		t.Errorf("the identity's tokens should have been updated: %#v", identity)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Paths.OAuth2LinkOK = "/linked"
+	h.ab.Paths.OAuth2LinkNotOK = "/notlinked"
+
+	user := &mocks.User{Email: "test@test.com", OAuth2Identities: []authboss.OAuth2Identity{{Provider: "google", UID: "id"}}}
+	h.storer.Users[user.Email] = user
+	h.session.ClientValues[authboss.SessionKey] = user.Email
+
+	unlink := func(provider string) {
+		rec := httptest.NewRecorder()
+		w := h.ab.NewResponse(rec)
+		r, err := h.ab.LoadClientState(w, httptest.NewRequest("POST", "/oauth2/unlink/"+provider, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := h.oauth.Unlink(w, r); err != nil {
+			t.Error(err)
+		}
+	}
+
+	unlink("facebook")
+	if h.redirector.Options.RedirectPath != "/notlinked" {
+		t.Error("unlinking an account that isn't linked should fail:", h.redirector.Options.RedirectPath)
+	}
+
+	unlink("google")
+	if h.redirector.Options.RedirectPath != "/linked" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+	if _, ok := authboss.GetOAuth2Identity(user, "google"); ok {
+		t.Error("the account should have been unlinked")
+	}
+}
@@ -0,0 +1,124 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// BeforeLogout revokes the tokens of users that signed in with a provider
+// that has an OAuth2LogoutConfig.RevokeURL. It runs before the logout so
+// that the user is still known.
+func (o *OAuth2) BeforeLogout(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	user, cfg := o.logoutProvider(r)
+	if cfg == nil || len(cfg.Logout.RevokeURL) == 0 {
+		return false, nil
+	}
+
+	token, hint := user.GetOAuth2RefreshToken(), "refresh_token"
+	if len(token) == 0 {
+		token, hint = user.GetOAuth2AccessToken(), "access_token"
+	}
+	if len(token) == 0 {
+		return false, nil
+	}
+
+	if err := revokeToken(r.Context(), *cfg, token, hint); err != nil {
+		o.RequestLogger(r).Errorf("failed to revoke oauth2 token of user %s: %+v", user.GetPID(), err)
+	}
+
+	return false, nil
+}
+
+// AfterLogout redirects users that signed in with a provider that has an
+// OAuth2LogoutConfig.EndSessionURL to it so they're logged out of the
+// provider as well.
+func (o *OAuth2) AfterLogout(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if handled {
+		return false, nil
+	}
+
+	user, cfg := o.logoutProvider(r)
+	if cfg == nil || len(cfg.Logout.EndSessionURL) == 0 {
+		return false, nil
+	}
+
+	endSession, err := url.Parse(cfg.Logout.EndSessionURL)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse end session url of provider %s", user.GetOAuth2Provider())
+	}
+
+	query := endSession.Query()
+	query.Set("client_id", cfg.OAuth2Config.ClientID)
+	query.Set("post_logout_redirect_uri", o.RequestConfig(r.Context()).Paths.RootURL+o.RequestConfig(r.Context()).Paths.LogoutOK)
+	endSession.RawQuery = query.Encode()
+
+	o.RequestLogger(r).Infof("user %s logging out of oauth2 provider %s", user.GetPID(), user.GetOAuth2Provider())
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: endSession.String(),
+		Success:      o.Localize(r.Context(), authboss.TxtLoggedOut),
+	}
+	return true, o.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// logoutProvider finds the provider the current user signed in with if it
+// has an OAuth2LogoutConfig
+func (o *OAuth2) logoutProvider(r *http.Request) (authboss.OAuth2User, *authboss.OAuth2Provider) {
+	user, err := o.CurrentUser(r)
+	if err != nil {
+		return nil, nil
+	}
+
+	oauthUser, ok := user.(authboss.OAuth2User)
+	if !ok || !oauthUser.IsOAuth2User() {
+		return nil, nil
+	}
+
+	cfg, ok := o.Config.Modules.OAuth2Providers[oauthUser.GetOAuth2Provider()]
+	if !ok || cfg.Logout == nil {
+		return nil, nil
+	}
+
+	return oauthUser, &cfg
+}
+
+// revokeToken as described in RFC 7009
+func revokeToken(ctx context.Context, cfg authboss.OAuth2Provider, token, hint string) error {
+	client := cfg.Logout.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{
+		"token":           []string{token},
+		"token_type_hint": []string{hint},
+	}
+
+	req, err := http.NewRequest("POST", cfg.Logout.RevokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "failed to create revocation request")
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(cfg.OAuth2Config.ClientID), url.QueryEscape(cfg.OAuth2Config.ClientSecret))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to revoke token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to revoke token, status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
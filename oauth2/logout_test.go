@@ -0,0 +1,98 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func logoutProviders(logout *authboss.OAuth2LogoutConfig) map[string]authboss.OAuth2Provider {
+	google := testProviders["google"]
+	google.Logout = logout
+	return map[string]authboss.OAuth2Provider{"google": google}
+}
+
+func logoutRequest(user authboss.User) *http.Request {
+	r := mocks.Request("POST")
+	return r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+}
+
+func TestBeforeLogoutRevoke(t *testing.T) {
+	t.Parallel()
+
+	var revoked, hint string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "jazz" || pass != "hands" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		revoked, hint = r.PostFormValue("token"), r.PostFormValue("token_type_hint")
+	}))
+	defer server.Close()
+
+	h := testSetup()
+	h.ab.Config.Modules.OAuth2Providers = logoutProviders(&authboss.OAuth2LogoutConfig{RevokeURL: server.URL})
+
+	user := &mocks.User{Email: "test@test.com", OAuth2Provider: "google", OAuth2Token: "token", OAuth2Refresh: "refresh"}
+	if handled, err := h.oauth.BeforeLogout(httptest.NewRecorder(), logoutRequest(user), false); err != nil {
+		t.Fatal(err)
+	} else if handled {
+		t.Error("revoking should not handle the logout")
+	}
+	if revoked != "refresh" || hint != "refresh_token" {
+		t.Error("the refresh token should have been revoked:", revoked, hint)
+	}
+
+	revoked = ""
+	local := &mocks.User{Email: "test@test.com", OAuth2Refresh: "refresh"}
+	if _, err := h.oauth.BeforeLogout(httptest.NewRecorder(), logoutRequest(local), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 0 {
+		t.Error("users that did not sign in with oauth2 should not have tokens revoked")
+	}
+}
+
+func TestAfterLogoutEndSession(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Paths.RootURL = "https://www.example.com"
+	h.ab.Config.Paths.LogoutOK = "/bye"
+	h.ab.Config.Modules.OAuth2Providers = logoutProviders(&authboss.OAuth2LogoutConfig{
+		EndSessionURL: "https://accounts.example.com/logout?tenant=a",
+	})
+
+	user := &mocks.User{Email: "test@test.com", OAuth2Provider: "google"}
+	w := httptest.NewRecorder()
+	if handled, err := h.oauth.AfterLogout(w, logoutRequest(user), false); err != nil {
+		t.Fatal(err)
+	} else if !handled {
+		t.Error("the redirect to the provider should handle the logout")
+	}
+
+	redirect, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+	if redirect.Host != "accounts.example.com" || query.Get("tenant") != "a" {
+		t.Error("redirect was wrong:", redirect)
+	}
+	if query.Get("client_id") != "jazz" || query.Get("post_logout_redirect_uri") != "https://www.example.com/bye" {
+		t.Error("query was wrong:", query)
+	}
+
+	h.redirector.Options = authboss.RedirectOptions{}
+	local := &mocks.User{Email: "test@test.com"}
+	if handled, err := h.oauth.AfterLogout(httptest.NewRecorder(), logoutRequest(local), false); err != nil {
+		t.Fatal(err)
+	} else if handled || len(h.redirector.Options.RedirectPath) != 0 {
+		t.Error("users that did not sign in with oauth2 should not be redirected")
+	}
+}
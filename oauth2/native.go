@@ -0,0 +1,175 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+const (
+	// PageOAuth2Native is for identifying the native token exchange
+	// for the BodyReader.
+	PageOAuth2Native = "oauth2_native"
+
+	idTokenLeeway = time.Minute
+)
+
+var (
+	errNativeNoToken = errors.New("no usable token was provided for oauth2 native exchange")
+)
+
+// NativePost exchanges a token a native app received from an oauth2
+// provider's SDK for an authboss session. An ID token is preferred and
+// verified locally, an access token is only accepted if the provider
+// is configured to allow it and is validated by FindUserDetails.
+func (o *OAuth2) NativePost(w http.ResponseWriter, r *http.Request) error {
+	logger := o.Authboss.RequestLogger(r)
+	provider := strings.ToLower(filepath.Base(r.URL.Path))
+	logger.Infof("oauth2 native token exchange for provider: %s", provider)
+
+	cfg, ok := o.Authboss.Config.Modules.OAuth2Providers[provider]
+	if !ok || cfg.Native == nil {
+		return errors.Errorf("oauth2 provider %q not found", provider)
+	}
+
+	validatable, err := o.Authboss.Core.BodyReader.Read(PageOAuth2Native, r)
+	if err != nil {
+		return err
+	}
+	values := authboss.MustHaveOAuth2NativeValues(validatable)
+
+	token := &oauth2.Token{AccessToken: values.GetAccessToken(), TokenType: "Bearer"}
+
+	var details map[string]string
+	switch {
+	case len(values.GetIDToken()) != 0:
+		details, err = o.verifyIDToken(r.Context(), provider, cfg, values.GetIDToken())
+	case len(values.GetAccessToken()) != 0 && cfg.Native.AllowAccessToken:
+		details, err = cfg.FindUserDetails(r.Context(), *cfg.OAuth2Config, token)
+	default:
+		err = errNativeNoToken
+	}
+	if err != nil {
+		logger.Infof("oauth2 native token exchange failed for provider %s: %+v", provider, err)
+		return o.nativeFailure(w, r, provider)
+	}
+
+	user, pid, err := o.findUser(r.Context(), provider, details, token, nil)
+	if err != nil {
+		return err
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	handled, err := o.Authboss.Events.FireBefore(authboss.EventOAuth2, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	o.Authboss.CountLogin("oauth2", true)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
+
+	handled, err = o.Authboss.Events.FireAfter(authboss.EventOAuth2, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusOK,
+		RedirectPath: o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectOK, user, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LoginOK),
+		Success:      o.Authboss.Localize(r.Context(), authboss.TxtOAuth2LoggedIn, "Provider", strings.Title(provider)),
+	}
+	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (o *OAuth2) nativeFailure(w http.ResponseWriter, r *http.Request, provider string) error {
+	o.Authboss.CountLogin("oauth2", false)
+	r = o.Authboss.WithAttempt(r, authboss.AttemptInfo{Module: "oauth2", Stage: authboss.AttemptStagePassword, Failure: authboss.ErrorCodeOAuth2Failed})
+	handled, err := o.Authboss.Events.FireAfter(authboss.EventOAuth2Fail, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusUnauthorized,
+		RedirectPath: o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectNotOK, nil, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LoginNotOK),
+		Failure:      o.Authboss.Localize(r.Context(), authboss.TxtOAuth2LoginFailed, "Provider", strings.Title(provider)),
+		FailureCode:  authboss.ErrorCodeOAuth2Failed,
+	}
+	return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// verifyIDToken checks the signature and claims of an OpenID Connect
+// ID token and returns the user details contained within.
+func (o *OAuth2) verifyIDToken(ctx context.Context, provider string, cfg authboss.OAuth2Provider, idToken string) (map[string]string, error) {
+	keySet, ok := o.keySets[provider]
+	if !ok {
+		return nil, errors.Errorf("oauth2 provider %q has no jwks configured to verify id tokens", provider)
+	}
+	if len(cfg.Native.Issuers) == 0 {
+		return nil, errors.Errorf("oauth2 provider %q has no issuers configured to verify id tokens", provider)
+	}
+
+	audiences := cfg.Native.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{cfg.OAuth2Config.ClientID}
+	}
+
+	expect := jwt.Expectations{
+		Issuers:   cfg.Native.Issuers,
+		Audiences: audiences,
+		Leeway:    idTokenLeeway,
+	}
+	return parseIDToken(ctx, keySet, idToken, expect, o.Authboss.Now())
+}
+
+// parseIDToken verifies the ID token using the key set and expectations
+// at now and returns the user details contained within.
+func parseIDToken(ctx context.Context, keySet *jwt.KeySet, idToken string, expect jwt.Expectations, now time.Time) (map[string]string, error) {
+	_, claims, err := jwt.Parse(idToken, keySet.KeyFunc(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = claims.Validate(now.UTC(), expect); err != nil {
+		return nil, err
+	}
+
+	return IDTokenDetails(claims)
+}
+
+// IDTokenDetails converts the standard OpenID Connect claims into the
+// details map passed to OAuth2ServerStorer.NewFromOAuth2
+func IDTokenDetails(claims jwt.Claims) (map[string]string, error) {
+	uid := claims.Subject()
+	if len(uid) == 0 {
+		return nil, errors.New("id token is missing the sub claim")
+	}
+
+	details := map[string]string{OAuth2UID: uid}
+	if email := claims.String("email"); len(email) != 0 {
+		details[OAuth2Email] = email
+	}
+	if name := claims.String("name"); len(name) != 0 {
+		details[OAuth2Name] = name
+	}
+
+	return details, nil
+}
@@ -0,0 +1,212 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestNativeInit(t *testing.T) {
+	// No t.Parallel() since the cfg.RedirectURL is set in Init()
+
+	google := testProviders["google"]
+	google.Native = &authboss.OAuth2NativeConfig{JWKSURL: "https://www.example.com/jwks"}
+
+	ab := authboss.New()
+	router := &mocks.Router{}
+	ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"google": google}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	oauth := &OAuth2{}
+	if err := oauth.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.HasPosts("/oauth2/native/google"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := oauth.keySets["google"]; !ok {
+		t.Error("key set should have been created")
+	}
+}
+
+func TestNativeIssuersRequired(t *testing.T) {
+	t.Parallel()
+
+	google := testProviders["google"]
+	google.Native = &authboss.OAuth2NativeConfig{JWKSURL: "https://www.example.com/jwks"}
+
+	ab := authboss.New()
+	ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"google": google}
+
+	oauth := &OAuth2{Authboss: ab, keySets: map[string]*jwt.KeySet{"google": jwt.NewKeySet(google.Native.JWKSURL)}}
+	found := false
+	for _, problem := range oauth.ValidateConfig(ab) {
+		found = found || problem.Field == `Modules.OAuth2Providers["google"].Native.Issuers`
+	}
+	if !found {
+		t.Error("native providers without issuers should be a problem")
+	}
+
+	if _, err := oauth.verifyIDToken(context.Background(), "google", google, "token"); err == nil {
+		t.Error("id tokens should be rejected when there are no issuers")
+	}
+}
+
+type nativeHarness struct {
+	*testHarness
+
+	key    *rsa.PrivateKey
+	server *httptest.Server
+}
+
+func nativeSetup(t *testing.T) *nativeHarness {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwt.JWKS{Keys: []jwt.JWK{jwt.NewRSAJWK("kid", &key.PublicKey)}})
+	}))
+
+	google := testProviders["google"]
+	google.Native = &authboss.OAuth2NativeConfig{
+		JWKSURL: server.URL,
+		Issuers: []string{"https://accounts.google.com"},
+	}
+
+	h := &nativeHarness{testHarness: testSetup(), key: key, server: server}
+	h.ab.Config.Core.Router = &mocks.Router{}
+	h.ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"google": google}
+	h.oauth.keySets = map[string]*jwt.KeySet{"google": jwt.NewKeySet(server.URL)}
+
+	return h
+}
+
+func (n *nativeHarness) idToken(t *testing.T, claims jwt.Claims) string {
+	token, err := jwt.Sign(claims, jwt.RSASigner{Key: n.key, KID: "kid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestNativePostIDToken(t *testing.T) {
+	t.Parallel()
+
+	h := nativeSetup(t)
+	defer h.server.Close()
+
+	token := h.idToken(t, jwt.Claims{
+		"iss":   "https://accounts.google.com",
+		"aud":   "jazz",
+		"sub":   "native-id",
+		"email": "native@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	h.ab.Config.Core.BodyReader = mocks.BodyReader{Return: mocks.Values{IDToken: token}}
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("POST", "/oauth2/native/google", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.NativePost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if h.redirector.Options.Code != http.StatusOK {
+		t.Error("code was wrong:", h.redirector.Options.Code)
+	}
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "oauth2;;google;;native-id" {
+		t.Error("session id should have been set:", s)
+	}
+	user, ok := h.storer.Users["oauth2;;google;;native-id"]
+	if !ok {
+		t.Fatal("user should have been saved")
+	}
+	if user.Email != "native@example.com" {
+		t.Error("email was wrong:", user.Email)
+	}
+}
+
+func TestNativePostFailures(t *testing.T) {
+	t.Parallel()
+
+	h := nativeSetup(t)
+	defer h.server.Close()
+
+	valid := jwt.Claims{
+		"iss": "https://accounts.google.com",
+		"aud": "jazz",
+		"sub": "native-id",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	badAudience := copyClaims(valid)
+	badAudience["aud"] = "someone-else"
+	expired := copyClaims(valid)
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := jwt.Sign(valid, jwt.RSASigner{Key: otherKey, KID: "kid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]mocks.Values{
+		"audience":    {IDToken: h.idToken(t, badAudience)},
+		"expired":     {IDToken: h.idToken(t, expired)},
+		"forged":      {IDToken: forged},
+		"accesstoken": {AccessToken: "token"},
+		"empty":       {},
+	}
+
+	for name, values := range tests {
+		h.ab.Config.Core.BodyReader = mocks.BodyReader{Return: values}
+		h.redirector.Options = authboss.RedirectOptions{}
+
+		rec := httptest.NewRecorder()
+		w := h.ab.NewResponse(rec)
+		r := httptest.NewRequest("POST", "/oauth2/native/google", nil)
+
+		if err := h.oauth.NativePost(w, r); err != nil {
+			t.Errorf("%s: %+v", name, err)
+		}
+
+		w.WriteHeader(http.StatusOK) // Flush headers
+
+		if h.redirector.Options.Code != http.StatusUnauthorized {
+			t.Errorf("%s: code was wrong: %d", name, h.redirector.Options.Code)
+		}
+		if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+			t.Errorf("%s: should not have logged in", name)
+		}
+	}
+}
+
+func copyClaims(c jwt.Claims) jwt.Claims {
+	cpy := make(jwt.Claims, len(c))
+	for k, v := range c {
+		cpy[k] = v
+	}
+	return cpy
+}
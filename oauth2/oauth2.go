@@ -1,6 +1,8 @@
 // Package oauth2 allows users to be created and authenticated
-// via oauth2 services like facebook, google etc. Currently
-// only the web server flow is supported.
+// via oauth2 services like facebook, google etc. The web server flow
+// is supported for browsers, and native apps that sign in using the
+// provider's own SDK can exchange the resulting token for a session
+// (see authboss.OAuth2NativeConfig).
 //
 // The general flow looks like this:
 //   1. User goes to Start handler and has his session packed with goodies
@@ -33,11 +35,17 @@
 // the user, but we have some basic ones included in this package too.
 // The creation of users from the FindUserDetail's map[string]string return
 // is handled as part of the implementation of the OAuth2ServerStorer.
+//
+// Providers using FormPost (like Sign in with Apple, see AppleProvider)
+// return to the callback with a cross-site POST. Browsers will not send
+// SameSite=Lax or Strict cookies with it, so the session cookie must be
+// SameSite=None (and therefore Secure) for the oauth2 state to be found.
 package oauth2
 
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -53,12 +61,29 @@ import (
 	"golang.org/x/oauth2"
 
 	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
 )
 
 // FormValue constants
 const (
 	FormValueOAuth2State = "state"
 	FormValueOAuth2Redir = "redir"
+
+	FormValueOAuth2CodeChallenge       = "code_challenge"
+	FormValueOAuth2CodeChallengeMethod = "code_challenge_method"
+	FormValueOAuth2CodeVerifier        = "code_verifier"
+	FormValueOAuth2ResponseMode        = "response_mode"
+
+	// FormValueOAuth2User is the form_post field Apple uses to send the
+	// user's name, only on the very first authorization.
+	FormValueOAuth2User = "user"
+)
+
+const (
+	pkceVerifierSize = 32
+	pkceMethodS256   = "S256"
+
+	responseModeFormPost = "form_post"
 )
 
 var (
@@ -68,12 +93,34 @@ var (
 // OAuth2 module
 type OAuth2 struct {
 	*authboss.Authboss
+
+	keySets    map[string]*jwt.KeySet
+	usedStates usedStates
 }
 
 func init() {
 	authboss.RegisterModule("oauth2", &OAuth2{})
 }
 
+// ValidateConfig checks the config has what logging in with a provider
+// needs
+func (o *OAuth2) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.Redirector", "Core.Logger",
+		"Storage.Server", "Storage.SessionState")
+	problems = append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.OAuth2ServerStorer)(nil))...)
+
+	// ID tokens from any issuer the keys sign for would be accepted
+	for provider, cfg := range ab.Config.Modules.OAuth2Providers {
+		if cfg.Native != nil && len(cfg.Native.JWKSURL) != 0 && len(cfg.Native.Issuers) == 0 {
+			problems = append(problems, authboss.ConfigProblem{
+				Field:   fmt.Sprintf("Modules.OAuth2Providers[%q].Native.Issuers", provider),
+				Problem: "must be set to verify id tokens",
+			})
+		}
+	}
+	return problems
+}
+
 // Init module
 func (o *OAuth2) Init(ab *authboss.Authboss) error {
 	o.Authboss = ab
@@ -87,6 +134,20 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 	}
 	sort.Strings(keys)
 
+	// Linking is only possible if the storer can find users by their links
+	_, canLink := o.Authboss.Config.Storage.Server.(authboss.OAuth2LinkServerStorer)
+	var linkMiddleware func(http.Handler) http.Handler
+	if canLink {
+		var unauthedResponse authboss.MWRespondOnFailure
+		if ab.Config.Modules.ResponseOnUnauthed != 0 {
+			unauthedResponse = ab.Config.Modules.ResponseOnUnauthed
+		} else if ab.Config.Modules.RoutesRedirectOnUnauthed {
+			unauthedResponse = authboss.RespondRedirect
+		}
+		linkMiddleware = authboss.MountedMiddleware2(ab, true, authboss.RequireFullAuth, unauthedResponse)
+	}
+
+	var federatedLogout bool
 	for _, provider := range keys {
 		cfg := o.Authboss.Config.Modules.OAuth2Providers[provider]
 		provider = strings.ToLower(provider)
@@ -94,14 +155,48 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 		init := fmt.Sprintf("/oauth2/%s", provider)
 		callback := fmt.Sprintf("/oauth2/callback/%s", provider)
 
-		o.Authboss.Config.Core.Router.Get(init, o.Authboss.Core.ErrorHandler.Wrap(o.Start))
-		o.Authboss.Config.Core.Router.Get(callback, o.Authboss.Core.ErrorHandler.Wrap(o.End))
+		// Logging in writes to the storer, so don't start it in read-only mode
+		notOK := o.Authboss.Config.Paths.OAuth2LoginNotOK
+		o.Authboss.Config.Core.Router.Get(init, o.ReadOnlyGuard(notOK, o.Authboss.Core.ErrorHandler.Wrap(o.Start)))
+		o.Authboss.Config.Core.Router.Get(callback, o.ReadOnlyGuard(notOK, o.Authboss.Core.ErrorHandler.Wrap(o.End)))
+		if cfg.FormPost {
+			// The provider posts from its own site, the state protects it
+			o.Authboss.Config.Core.Router.Post(callback, authboss.CSRFExempt(o.Authboss.Core.ErrorHandler.Wrap(o.End)))
+		}
 
 		if mount := o.Authboss.Config.Paths.Mount; len(mount) > 0 {
 			callback = path.Join(mount, callback)
 		}
 
 		cfg.OAuth2Config.RedirectURL = o.Authboss.Config.Paths.RootURL + callback
+
+		if canLink {
+			link := fmt.Sprintf("/oauth2/link/%s", provider)
+			unlink := fmt.Sprintf("/oauth2/unlink/%s", provider)
+			o.Authboss.Config.Core.Router.Get(link, o.ReadOnlyGuard(o.Authboss.Config.Paths.OAuth2LinkNotOK, linkMiddleware(o.Authboss.Core.ErrorHandler.Wrap(o.LinkStart))))
+			o.Authboss.Config.Core.Router.Post(unlink, linkMiddleware(o.Authboss.Core.ErrorHandler.Wrap(o.Unlink)))
+		}
+
+		if cfg.Native != nil {
+			native := fmt.Sprintf("/oauth2/native/%s", provider)
+			o.Authboss.Config.Core.Router.Post(native, authboss.CSRFExempt(o.Authboss.Core.ErrorHandler.Wrap(o.NativePost)))
+
+			if len(cfg.Native.JWKSURL) != 0 {
+				if o.keySets == nil {
+					o.keySets = make(map[string]*jwt.KeySet)
+				}
+				o.keySets[provider] = jwt.NewKeySet(cfg.Native.JWKSURL)
+			}
+		}
+
+		if cfg.Logout != nil {
+			federatedLogout = true
+		}
+	}
+
+	if federatedLogout {
+		o.Events.Before(authboss.EventLogout, o.BeforeLogout)
+		o.Events.After(authboss.EventLogout, o.AfterLogout)
 	}
 
 	return nil
@@ -109,6 +204,10 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 
 // Start the oauth2 process
 func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
+	return o.start(w, r, false)
+}
+
+func (o *OAuth2) start(w http.ResponseWriter, r *http.Request, link bool) error {
 	logger := o.Authboss.RequestLogger(r)
 
 	provider := strings.ToLower(filepath.Base(r.URL.Path))
@@ -118,14 +217,16 @@ func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
 		return errors.Errorf("oauth2 provider %q not found", provider)
 	}
 
-	// Create nonce
-	nonce := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return errors.Wrap(err, "failed to create nonce")
+	state, err := o.putState(w)
+	if err != nil {
+		return err
 	}
 
-	state := base64.URLEncoding.EncodeToString(nonce)
-	authboss.PutSession(w, authboss.SessionOAuth2State, state)
+	if link {
+		authboss.PutSession(w, authboss.SessionOAuth2Link, provider)
+	} else {
+		authboss.DelSession(w, authboss.SessionOAuth2Link)
+	}
 
 	// This clearly ignores the fact that query parameters can have multiple
 	// values but I guess we're ignoring that
@@ -146,7 +247,33 @@ func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
 		authboss.DelSession(w, authboss.SessionOAuth2Params)
 	}
 
-	authCodeUrl := cfg.OAuth2Config.AuthCodeURL(state)
+	var opts []oauth2.AuthCodeOption
+	if cfg.FormPost {
+		opts = append(opts, oauth2.SetAuthURLParam(FormValueOAuth2ResponseMode, responseModeFormPost))
+	}
+	if cfg.Nonce {
+		nonce, err := randomToken()
+		if err != nil {
+			return errors.Wrap(err, "failed to create nonce")
+		}
+
+		authboss.PutSession(w, authboss.SessionOAuth2Nonce, nonce)
+		opts = append(opts, oauth2.SetAuthURLParam(FormValueOAuth2Nonce, nonce))
+	}
+	if cfg.PKCE {
+		verifier, challenge, err := generatePKCE()
+		if err != nil {
+			return err
+		}
+
+		authboss.PutSession(w, authboss.SessionOAuth2PKCEVerifier, verifier)
+		opts = append(opts,
+			oauth2.SetAuthURLParam(FormValueOAuth2CodeChallenge, challenge),
+			oauth2.SetAuthURLParam(FormValueOAuth2CodeChallengeMethod, pkceMethodS256),
+		)
+	}
+
+	authCodeUrl := cfg.OAuth2Config.AuthCodeURL(state, opts...)
 
 	extraParams := cfg.AdditionalParams.Encode()
 	if len(extraParams) > 0 {
@@ -176,16 +303,8 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 		return errors.Errorf("oauth2 provider %q not found", provider)
 	}
 
-	wantState, ok := authboss.GetSession(r, authboss.SessionOAuth2State)
-	if !ok {
-		return errors.New("oauth2 endpoint hit without session state")
-	}
-
-	// Verify we got the same state in the session as was passed to us in the
-	// query parameter.
-	state := r.FormValue(FormValueOAuth2State)
-	if state != wantState {
-		return errOAuthStateValidation
+	if err := o.checkState(r); err != nil {
+		return o.reject(w, r, err)
 	}
 
 	rawParams, ok := authboss.GetSession(r, authboss.SessionOAuth2Params)
@@ -196,14 +315,40 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	var opts []oauth2.AuthCodeOption
+	if cfg.PKCE {
+		verifier, ok := authboss.GetSession(r, authboss.SessionOAuth2PKCEVerifier)
+		if !ok {
+			return errors.New("oauth2 endpoint hit without pkce verifier in session")
+		}
+		opts = append(opts, oauth2.SetAuthURLParam(FormValueOAuth2CodeVerifier, verifier))
+	}
+
+	nonce, _ := authboss.GetSession(r, authboss.SessionOAuth2Nonce)
+
 	authboss.DelSession(w, authboss.SessionOAuth2State)
+	authboss.DelSession(w, authboss.SessionOAuth2StateExpires)
+	authboss.DelSession(w, authboss.SessionOAuth2Nonce)
 	authboss.DelSession(w, authboss.SessionOAuth2Params)
+	authboss.DelSession(w, authboss.SessionOAuth2PKCEVerifier)
+
+	linkProvider, linking := authboss.GetSession(r, authboss.SessionOAuth2Link)
+	authboss.DelSession(w, authboss.SessionOAuth2Link)
+	if linking && linkProvider != provider {
+		return errors.Errorf("oauth2 link started for provider %q but finished for %q", linkProvider, provider)
+	}
 
 	hasErr := r.FormValue("error")
 	if len(hasErr) > 0 {
 		reason := r.FormValue("error_reason")
 		logger.Infof("oauth2 login failed: %s, reason: %s", hasErr, reason)
 
+		if linking {
+			return o.linkFailure(w, r, authboss.TxtOAuth2LinkCanceled, provider)
+		}
+
+		o.Authboss.CountLogin("oauth2", false)
+		r = o.Authboss.WithAttempt(r, authboss.AttemptInfo{Module: "oauth2", Stage: authboss.AttemptStagePassword, Failure: authboss.ErrorCodeOAuth2Failed})
 		handled, err := o.Authboss.Events.FireAfter(authboss.EventOAuth2Fail, w, r)
 		if err != nil {
 			return err
@@ -213,38 +358,65 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 
 		ro := authboss.RedirectOptions{
 			Code:         http.StatusTemporaryRedirect,
-			RedirectPath: o.Authboss.Config.Paths.OAuth2LoginNotOK,
-			Failure:      fmt.Sprintf("%s login cancelled or failed", strings.Title(provider)),
+			RedirectPath: o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectNotOK, nil, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LoginNotOK),
+			Failure:      o.Authboss.Localize(r.Context(), authboss.TxtOAuth2LoginCanceled, "Provider", strings.Title(provider)),
+			FailureCode:  authboss.ErrorCodeOAuth2Failed,
 		}
 		return o.Authboss.Core.Redirector.Redirect(w, r, ro)
 	}
 
+	oauthCfg := cfg.OAuth2Config
+	if cfg.GenerateClientSecret != nil {
+		secret, err := cfg.GenerateClientSecret(r.Context())
+		if err != nil {
+			return errors.Wrap(err, "failed to generate oauth2 client secret")
+		}
+
+		cpy := *oauthCfg
+		cpy.ClientSecret = secret
+		oauthCfg = &cpy
+	}
+
 	// Get the code which we can use to make an access token
 	code := r.FormValue("code")
-	token, err := exchanger(cfg.OAuth2Config, r.Context(), code)
+	token, err := exchanger(oauthCfg, r.Context(), code, opts...)
 	if err != nil {
 		return errors.Wrap(err, "could not validate oauth2 code")
 	}
 
-	details, err := cfg.FindUserDetails(r.Context(), *cfg.OAuth2Config, token)
+	if cfg.Nonce {
+		if err = o.checkNonce(r.Context(), provider, nonce, token); err != nil {
+			return o.reject(w, r, err)
+		}
+	}
+
+	details, err := cfg.FindUserDetails(r.Context(), *oauthCfg, token)
 	if err != nil {
 		return err
 	}
 
-	storer := authboss.EnsureCanOAuth2(o.Authboss.Config.Storage.Server)
-	user, err := storer.NewFromOAuth2(r.Context(), provider, details)
-	if err != nil {
-		return errors.Wrap(err, "failed to create oauth2 user from values")
+	if cfg.FormPost {
+		if err = formPostUserDetails(r, details); err != nil {
+			return err
+		}
 	}
 
-	user.PutOAuth2Provider(provider)
-	user.PutOAuth2AccessToken(token.AccessToken)
-	user.PutOAuth2Expiry(token.Expiry)
-	if len(token.RefreshToken) != 0 {
-		user.PutOAuth2RefreshToken(token.RefreshToken)
+	var profile *authboss.OAuth2Profile
+	if cfg.FindProfile != nil {
+		p, err := cfg.FindProfile(r.Context(), *oauthCfg, token)
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch oauth2 profile")
+		}
+		profile = &p
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyOAuth2Profile, p))
+	}
+
+	if linking {
+		return o.link(w, r, provider, details, token, params)
 	}
 
-	if err := storer.SaveOAuth2(r.Context(), user); err != nil {
+	user, pid, err := o.findUser(r.Context(), provider, details, token, profile)
+	if err != nil {
 		return err
 	}
 
@@ -258,12 +430,13 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// Fully log user in
-	authboss.PutSession(w, authboss.SessionKey, authboss.MakeOAuth2PID(provider, user.GetOAuth2UID()))
+	authboss.PutSession(w, authboss.SessionKey, pid)
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	o.Authboss.CountLogin("oauth2", true)
 
 	// Create a query string from all the pieces we've received
 	// as passthru from the original request.
-	redirect := o.Authboss.Config.Paths.OAuth2LoginOK
+	redirect := o.Authboss.RedirectPath(r, "oauth2", authboss.RedirectOK, user, o.Authboss.RequestConfig(r.Context()).Paths.OAuth2LoginOK)
 	query := make(url.Values)
 	for k, v := range params {
 		switch k {
@@ -272,7 +445,9 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 				r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, RMTrue{}))
 			}
 		case FormValueOAuth2Redir:
-			redirect = v
+			if path, ok := o.Authboss.SafeRedirect(v); ok {
+				redirect = path
+			}
 		default:
 			query.Set(k, v)
 		}
@@ -292,11 +467,106 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
 		RedirectPath: redirect,
-		Success:      fmt.Sprintf("Logged in successfully with %s.", strings.Title(provider)),
+		Success:      o.Authboss.Localize(r.Context(), authboss.TxtOAuth2LoggedIn, "Provider", strings.Title(provider)),
 	}
 	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// findUser returns the user to log in with the provider's details and
+// their pid. If the provider identity has been linked to a user that user
+// is used (and the identity's tokens updated), otherwise the oauth2 user
+// is created or updated. The profile is given to them if it's not nil.
+func (o *OAuth2) findUser(ctx context.Context, provider string, details map[string]string, token *oauth2.Token, profile *authboss.OAuth2Profile) (authboss.User, string, error) {
+	if storer, ok := o.Authboss.Config.Storage.Server.(authboss.OAuth2LinkServerStorer); ok {
+		uid := details[OAuth2UID]
+		user, err := storer.LoadByOAuth2Link(ctx, provider, uid)
+		if err == nil {
+			identity := newIdentity(provider, uid, token)
+			if old, ok := authboss.GetOAuth2Identity(user, provider); ok && len(identity.RefreshToken) == 0 {
+				// Providers often only send a refresh token the first time
+				identity.RefreshToken = old.RefreshToken
+			}
+			user.PutOAuth2Identity(identity)
+			putProfile(user, provider, profile)
+			if err = o.Authboss.SaveUser(ctx, user); err != nil {
+				return nil, "", err
+			}
+			return user, user.GetPID(), nil
+		} else if err != authboss.ErrUserNotFound {
+			return nil, "", err
+		}
+	}
+
+	user, err := o.saveUser(ctx, provider, details, token, profile)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, authboss.MakeOAuth2PID(provider, user.GetOAuth2UID()), nil
+}
+
+// saveUser creates or updates the user from the provider's details
+// and persists the tokens we received for them.
+func (o *OAuth2) saveUser(ctx context.Context, provider string, details map[string]string, token *oauth2.Token, profile *authboss.OAuth2Profile) (authboss.OAuth2User, error) {
+	storer := authboss.EnsureCanOAuth2(o.Authboss.Config.Storage.Server)
+	user, err := storer.NewFromOAuth2(ctx, provider, details)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create oauth2 user from values")
+	}
+
+	user.PutOAuth2Provider(provider)
+	if len(token.AccessToken) != 0 {
+		user.PutOAuth2AccessToken(token.AccessToken)
+		user.PutOAuth2Expiry(token.Expiry)
+	}
+	if len(token.RefreshToken) != 0 {
+		user.PutOAuth2RefreshToken(token.RefreshToken)
+	}
+
+	putProfile(user, provider, profile)
+
+	if err := storer.SaveOAuth2(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// putProfile gives the profile to the user if they're an
+// authboss.OAuth2ProfileUser
+func putProfile(user authboss.User, provider string, profile *authboss.OAuth2Profile) {
+	if profile == nil {
+		return
+	}
+	if profileUser, ok := user.(authboss.OAuth2ProfileUser); ok {
+		profileUser.PutOAuth2Profile(provider, *profile)
+	}
+}
+
+// randomToken creates a random url safe token for the state and nonce
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(raw), nil
+}
+
+// generatePKCE creates a code verifier and its S256 code challenge
+// as described in RFC 7636.
+func generatePKCE() (verifier, challenge string, err error) {
+	raw := make([]byte, pkceVerifierSize)
+	if _, err = io.ReadFull(rand.Reader, raw); err != nil {
+		return "", "", errors.Wrap(err, "failed to create pkce verifier")
+	}
+
+	verifier = base64.RawURLEncoding.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(verifier))
+	challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return verifier, challenge, nil
+}
+
 // RMTrue is a dummy struct implementing authboss.RememberValuer
 // in order to tell the remember me module to remember them.
 type RMTrue struct{}
@@ -2,14 +2,18 @@ package oauth2
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
 	"github.com/volatiletech/authboss/v3/mocks"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/facebook"
@@ -17,8 +21,15 @@ import (
 )
 
 func init() {
+	// The id token has the nonce the tests put in the session
+	idToken, err := jwt.Sign(jwt.Claims{"sub": "id", FormValueOAuth2Nonce: "nonce"}, jwt.HMACSigner{Key: []byte("key")})
+	if err != nil {
+		panic(err)
+	}
+	token := testToken.WithExtra(map[string]interface{}{"id_token": idToken})
+
 	exchanger = func(_ *oauth2.Config, _ context.Context, _ string, _ ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
-		return testToken, nil
+		return token, nil
 	}
 }
 
@@ -109,11 +120,17 @@ func testSetup() *testHarness {
 	harness.ab.Config.Storage.SessionState = harness.session
 	harness.ab.Config.Storage.Server = harness.storer
 
-	harness.oauth = &OAuth2{harness.ab}
+	harness.oauth = &OAuth2{Authboss: harness.ab}
 
 	return harness
 }
 
+// putState puts a state that hasn't expired in the session
+func (h *testHarness) putState(state string) {
+	h.session.ClientValues[authboss.SessionOAuth2State] = state
+	h.session.ClientValues[authboss.SessionOAuth2StateExpires] = strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+}
+
 func TestStart(t *testing.T) {
 	t.Parallel()
 
@@ -180,7 +197,7 @@ func TestEnd(t *testing.T) {
 	rec := httptest.NewRecorder()
 	w := h.ab.NewResponse(rec)
 
-	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.putState("state")
 	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
 	if err != nil {
 		t.Fatal(err)
@@ -204,6 +221,39 @@ func TestEnd(t *testing.T) {
 	}
 }
 
+func TestEndProfile(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	google := testProviders["google"]
+	google.FindProfile = GoogleProfile
+	h.ab.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"google": google}
+
+	var eventProfile authboss.OAuth2Profile
+	h.ab.Events.After(authboss.EventOAuth2, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		eventProfile, _ = r.Context().Value(authboss.CTXKeyOAuth2Profile).(authboss.OAuth2Profile)
+		return false, nil
+	})
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.putState("state")
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	user := h.storer.Users["oauth2;;google;;id"]
+	if user == nil || user.OAuth2Profile.AvatarURL != "https://a/p.png" || !user.OAuth2Profile.EmailVerified {
+		t.Errorf("the profile should be given to the user: %#v", user)
+	}
+	if eventProfile.Locale != "en-US" {
+		t.Error("the profile should be in the event's context:", eventProfile)
+	}
+}
+
 func TestEndBadProvider(t *testing.T) {
 	t.Parallel()
 
@@ -233,7 +283,7 @@ func TestEndBadState(t *testing.T) {
 		t.Error("it should have errored:", e)
 	}
 
-	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.putState("state")
 	r, err = h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=x", nil))
 	if err != nil {
 		t.Fatal(err)
@@ -243,6 +293,117 @@ func TestEndBadState(t *testing.T) {
 	}
 }
 
+func TestEndStateRejected(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	var rejections []error
+	h.ab.Events.After(authboss.EventOAuth2Rejected, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		rejections = append(rejections, r.Context().Value(authboss.CTXKeyOAuth2Rejected).(error))
+		return false, nil
+	})
+
+	end := func() error {
+		t.Helper()
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.oauth.End(w, r)
+	}
+
+	h.putState("state")
+	h.session.ClientValues[authboss.SessionOAuth2StateExpires] = strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	if err := end(); err != errOAuthStateExpired {
+		t.Error("an expired state should be rejected:", err)
+	}
+
+	h.putState("state")
+	if err := end(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed with the session cookie from before the callback
+	h.putState("state")
+	if err := end(); err != errOAuthStateReplayed {
+		t.Error("a used state should be rejected:", err)
+	}
+
+	if len(rejections) != 2 || rejections[0] != errOAuthStateExpired || rejections[1] != errOAuthStateReplayed {
+		t.Error("the rejections should fire the event:", rejections)
+	}
+}
+
+func nonceProviders() map[string]authboss.OAuth2Provider {
+	google := testProviders["google"]
+	google.Nonce = true
+	return map[string]authboss.OAuth2Provider{"google": google}
+}
+
+func TestStartNonce(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = nonceProviders()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.oauth.Start(w, httptest.NewRequest("GET", "/oauth2/google", nil)); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	redirectPathUrl, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := redirectPathUrl.Query().Get(FormValueOAuth2Nonce)
+	if len(nonce) == 0 || h.session.ClientValues[authboss.SessionOAuth2Nonce] != nonce {
+		t.Error("the nonce should be sent and saved in the session:", nonce)
+	}
+	if len(h.session.ClientValues[authboss.SessionOAuth2StateExpires]) == 0 {
+		t.Error("the state's expiry should be saved in the session")
+	}
+}
+
+func TestEndNonce(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Nonce string
+		Err   error
+	}{
+		{"nonce", nil},
+		{"other", errOAuthNonce},
+		{"", errOAuthNonce},
+	}
+
+	for _, test := range tests {
+		h := testSetup()
+		h.ab.Modules.OAuth2Providers = nonceProviders()
+
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		h.putState("state")
+		if len(test.Nonce) != 0 {
+			h.session.ClientValues[authboss.SessionOAuth2Nonce] = test.Nonce
+		}
+		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := h.oauth.End(w, r); err != test.Err {
+			t.Errorf("%q: error was wrong: %v", test.Nonce, err)
+		}
+		w.WriteHeader(http.StatusOK) // Flush headers
+
+		_, loggedIn := h.session.ClientValues[authboss.SessionKey]
+		if loggedIn != (test.Err == nil) {
+			t.Errorf("%q: logged in was wrong: %t", test.Nonce, loggedIn)
+		}
+	}
+}
+
 func TestEndErrors(t *testing.T) {
 	t.Parallel()
 
@@ -251,7 +412,7 @@ func TestEndErrors(t *testing.T) {
 	rec := httptest.NewRecorder()
 	w := h.ab.NewResponse(rec)
 
-	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.putState("state")
 	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state&error=badtimes&error_reason=reason", nil))
 	if err != nil {
 		t.Fatal(err)
@@ -279,7 +440,7 @@ func TestEndHandling(t *testing.T) {
 		rec := httptest.NewRecorder()
 		w := h.ab.NewResponse(rec)
 
-		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.putState("state")
 		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state&error=badtimes&error_reason=reason", nil))
 		if err != nil {
 			t.Fatal(err)
@@ -308,7 +469,7 @@ func TestEndHandling(t *testing.T) {
 		rec := httptest.NewRecorder()
 		w := h.ab.NewResponse(rec)
 
-		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.putState("state")
 		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
 		if err != nil {
 			t.Fatal(err)
@@ -343,7 +504,7 @@ func TestEndHandling(t *testing.T) {
 		rec := httptest.NewRecorder()
 		w := h.ab.NewResponse(rec)
 
-		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.putState("state")
 		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
 		if err != nil {
 			t.Fatal(err)
@@ -372,3 +533,87 @@ func TestEndHandling(t *testing.T) {
 		}
 	})
 }
+
+func pkceProviders() map[string]authboss.OAuth2Provider {
+	google := testProviders["google"]
+	google.PKCE = true
+	return map[string]authboss.OAuth2Provider{"google": google}
+}
+
+func TestStartPKCE(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = pkceProviders()
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "/oauth2/google", nil)
+
+	if err := h.oauth.Start(w, r); err != nil {
+		t.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	redirectPathUrl, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirectPathUrl.Query()
+	if method := query.Get(FormValueOAuth2CodeChallengeMethod); method != "S256" {
+		t.Error("challenge method was wrong:", method)
+	}
+
+	verifier := h.session.ClientValues[authboss.SessionOAuth2PKCEVerifier]
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Error("verifier length was out of bounds:", len(verifier))
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge := query.Get(FormValueOAuth2CodeChallenge); challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Error("challenge did not match the verifier:", challenge)
+	}
+}
+
+func TestEndPKCE(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.OAuth2Providers = pkceProviders()
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	h.putState("state")
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err == nil || !strings.Contains(err.Error(), "pkce verifier") {
+		t.Error("it should have errored about the missing verifier:", err)
+	}
+
+	h.putState("state2")
+	h.session.ClientValues[authboss.SessionOAuth2PKCEVerifier] = "verifier"
+	rec = httptest.NewRecorder()
+	w = h.ab.NewResponse(rec)
+	r, err = h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2PKCEVerifier]; ok {
+		t.Error("verifier should have been removed from the session")
+	}
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "oauth2;;google;;id" {
+		t.Error("session id should have been set:", s)
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/friendsofgo/errors"
 	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
 )
 
 // Constants for returning in the FindUserDetails call
@@ -20,6 +22,9 @@ const (
 const (
 	googleInfoEndpoint   = `https://www.googleapis.com/userinfo/v2/me`
 	facebookInfoEndpoint = `https://graph.facebook.com/me?fields=name,email`
+
+	googleUserInfoEndpoint      = `https://openidconnect.googleapis.com/v1/userinfo`
+	facebookProfileInfoEndpoint = `https://graph.facebook.com/me?fields=name,email,picture.type(large)`
 )
 
 type googleMeResponse struct {
@@ -88,3 +93,83 @@ func FacebookUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.T
 		OAuth2Name:  response.Name,
 	}, nil
 }
+
+// oidcUserInfo is the response of an OpenID Connect userinfo endpoint, some
+// providers send email_verified as a string
+type oidcUserInfo struct {
+	Name          string      `json:"name"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Picture       string      `json:"picture"`
+	Locale        string      `json:"locale"`
+}
+
+// OIDCProfile creates a FindProfile function for an authboss.OAuth2Provider
+// that reads the profile from the provider's OpenID Connect userinfo
+// endpoint. The provider's scopes should include profile and email.
+func OIDCProfile(userInfoURL string) func(context.Context, oauth2.Config, *oauth2.Token) (authboss.OAuth2Profile, error) {
+	return func(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (authboss.OAuth2Profile, error) {
+		var info oidcUserInfo
+		if err := getJSON(ctx, cfg, token, userInfoURL, &info); err != nil {
+			return authboss.OAuth2Profile{}, errors.Wrap(err, "failed to get oauth2 userinfo")
+		}
+
+		verified := info.EmailVerified == true || info.EmailVerified == "true"
+		return authboss.OAuth2Profile{
+			Name:          info.Name,
+			Email:         info.Email,
+			EmailVerified: verified,
+			AvatarURL:     info.Picture,
+			Locale:        info.Locale,
+		}, nil
+	}
+}
+
+// GoogleProfile can be used as a FindProfile function for an
+// authboss.OAuth2Provider
+func GoogleProfile(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (authboss.OAuth2Profile, error) {
+	return OIDCProfile(googleUserInfoEndpoint)(ctx, cfg, token)
+}
+
+type facebookProfileResponse struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Picture struct {
+		Data struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	} `json:"picture"`
+}
+
+// FacebookProfile can be used as a FindProfile function for an
+// authboss.OAuth2Provider. Facebook doesn't say whether the e-mail address
+// was verified or give the user's locale.
+func FacebookProfile(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (authboss.OAuth2Profile, error) {
+	var response facebookProfileResponse
+	if err := getJSON(ctx, cfg, token, facebookProfileInfoEndpoint, &response); err != nil {
+		return authboss.OAuth2Profile{}, errors.Wrap(err, "failed to get facebook profile")
+	}
+
+	return authboss.OAuth2Profile{
+		Name:      response.Name,
+		Email:     response.Email,
+		AvatarURL: response.Picture.Data.URL,
+	}, nil
+}
+
+// getJSON gets the url with the token and decodes the json response
+func getJSON(ctx context.Context, cfg oauth2.Config, token *oauth2.Token, url string, v interface{}) error {
+	client := cfg.Client(ctx, token)
+	resp, err := clientGet(client, url)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	byt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(byt, v)
+}
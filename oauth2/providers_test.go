@@ -9,14 +9,23 @@ import (
 	"time"
 
 	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
 )
 
 func init() {
 	// This has an extra parameter that the Google client wouldn't normally
 	// get, but it'll safely be ignored.
 	clientGet = func(_ *http.Client, url string) (*http.Response, error) {
+		body := `{"id":"id", "email":"email", "name": "name"}`
+		switch url {
+		case googleUserInfoEndpoint:
+			body = `{"sub":"id", "email":"email", "email_verified":true, "name":"name", "picture":"https://a/p.png", "locale":"en-US"}`
+		case facebookProfileInfoEndpoint:
+			body = `{"id":"id", "email":"email", "name":"name", "picture":{"data":{"url":"https://a/p.png"}}}`
+		}
 		return &http.Response{
-			Body: ioutil.NopCloser(strings.NewReader(`{"id":"id", "email":"email", "name": "name"}`)),
+			Body: ioutil.NopCloser(strings.NewReader(body)),
 		}, nil
 	}
 }
@@ -71,3 +80,27 @@ func TestFacebook(t *testing.T) {
 		t.Error("Name wrong:", name)
 	}
 }
+
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+
+	tok := &oauth2.Token{AccessToken: "token", TokenType: "Bearer"}
+
+	profile, err := GoogleProfile(context.Background(), *testProviders["google"].OAuth2Config, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := authboss.OAuth2Profile{Name: "name", Email: "email", EmailVerified: true, AvatarURL: "https://a/p.png", Locale: "en-US"}
+	if profile != want {
+		t.Errorf("google profile was wrong: %#v", profile)
+	}
+
+	profile, err = FacebookProfile(context.Background(), *testProviders["facebook"].OAuth2Config, tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = authboss.OAuth2Profile{Name: "name", Email: "email", AvatarURL: "https://a/p.png"}
+	if profile != want {
+		t.Errorf("facebook profile was wrong: %#v", profile)
+	}
+}
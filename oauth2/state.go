@@ -0,0 +1,142 @@
+package oauth2
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+// FormValueOAuth2Nonce is the OpenID Connect nonce parameter
+const FormValueOAuth2Nonce = "nonce"
+
+var (
+	errOAuthStateMissing  = errors.New("oauth2 endpoint hit without session state")
+	errOAuthStateExpired  = errors.New("oauth2 state has expired")
+	errOAuthStateReplayed = errors.New("oauth2 state was already used")
+	errOAuthNonce         = errors.New("could not validate oauth2 id token nonce")
+)
+
+// putState puts a new state in the session along with when it expires and
+// returns it
+func (o *OAuth2) putState(w http.ResponseWriter) (string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create state")
+	}
+
+	expires := o.Authboss.Now().Add(o.Authboss.Config.Modules.OAuth2StateDuration)
+	authboss.PutSession(w, authboss.SessionOAuth2State, state)
+	authboss.PutSession(w, authboss.SessionOAuth2StateExpires, strconv.FormatInt(expires.Unix(), 10))
+	return state, nil
+}
+
+// checkState checks the callback's state is the session's, that it hasn't
+// expired and that it's only used once
+func (o *OAuth2) checkState(r *http.Request) error {
+	wantState, ok := authboss.GetSession(r, authboss.SessionOAuth2State)
+	if !ok {
+		return errOAuthStateMissing
+	}
+
+	// Verify we got the same state in the session as was passed to us in the
+	// query parameter.
+	state := r.FormValue(FormValueOAuth2State)
+	if subtle.ConstantTimeCompare([]byte(state), []byte(wantState)) != 1 {
+		return errOAuthStateValidation
+	}
+
+	rawExpires, _ := authboss.GetSession(r, authboss.SessionOAuth2StateExpires)
+	unix, err := strconv.ParseInt(rawExpires, 10, 64)
+	now := o.Authboss.Now()
+	expires := time.Unix(unix, 0)
+	if err != nil || now.After(expires) {
+		return errOAuthStateExpired
+	}
+
+	if !o.usedStates.use(state, expires, now) {
+		return errOAuthStateReplayed
+	}
+
+	return nil
+}
+
+// checkNonce checks the ID token the code was exchanged for has the nonce
+// that was sent with the authorization request
+func (o *OAuth2) checkNonce(ctx context.Context, provider string, wantNonce string, token *oauth2.Token) error {
+	idToken, ok := token.Extra("id_token").(string)
+	if !ok || len(idToken) == 0 || len(wantNonce) == 0 {
+		return errOAuthNonce
+	}
+
+	var claims jwt.Claims
+	var err error
+	if keySet, ok := o.keySets[provider]; ok {
+		_, claims, err = jwt.Parse(idToken, keySet.KeyFunc(ctx))
+	} else {
+		// It came straight from the provider's token endpoint
+		_, claims, err = jwt.ParseUnverified(idToken)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to parse oauth2 id token")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.String(FormValueOAuth2Nonce)), []byte(wantNonce)) != 1 {
+		return errOAuthNonce
+	}
+	return nil
+}
+
+// reject fires EventOAuth2Rejected for a callback that failed validation,
+// unless a handler responds the error is returned
+func (o *OAuth2) reject(w http.ResponseWriter, r *http.Request, rejection error) error {
+	o.Authboss.RequestLogger(r).Infof("rejected oauth2 callback: %v", rejection)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyOAuth2Rejected, rejection))
+	handled, err := o.Authboss.Events.FireAfter(authboss.EventOAuth2Rejected, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	return rejection
+}
+
+// usedStates remembers the states that have been used until they expire so
+// a callback can't be replayed with an old session cookie. It's kept in
+// memory, so with several app servers a callback could be replayed once on
+// each of them while its state hasn't expired.
+type usedStates struct {
+	mut  sync.Mutex
+	used map[string]time.Time
+}
+
+// use the state, it returns false if it has been used before
+func (u *usedStates) use(state string, expires, now time.Time) bool {
+	u.mut.Lock()
+	defer u.mut.Unlock()
+
+	if u.used == nil {
+		u.used = make(map[string]time.Time)
+	}
+	for used, exp := range u.used {
+		if now.After(exp) {
+			delete(u.used, used)
+		}
+	}
+
+	if _, ok := u.used[state]; ok {
+		return false
+	}
+	u.used[state] = expires
+	return true
+}
@@ -0,0 +1,122 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+)
+
+// oauth2RefreshLeeway is how long before it expires an access token is
+// refreshed, so it doesn't expire while it's being used
+const oauth2RefreshLeeway = time.Minute
+
+// ErrOAuth2RefreshFailed is returned by OAuth2Token when the user has no
+// refresh token or the provider refuses it, typically because they
+// revoked the app's access. They have to log in with the provider again.
+var ErrOAuth2RefreshFailed = errors.New("oauth2 token could not be refreshed")
+
+// OAuth2Token returns the access token of a user that logged in with an
+// oauth2 provider, for calling the provider's APIs. When it has expired (or
+// is about to) it's refreshed with the user's refresh token first and the
+// new tokens are saved. It can be called from a request or a background job.
+//
+// If the refresh token is missing or refused EventOAuth2RefreshFailed is
+// fired and ErrOAuth2RefreshFailed returned. Like EventMailFailed it's fired
+// outside of a request: the request only carries a context with the user in
+// it under CTXKeyUser and the response writer discards what's written to it.
+func (a *Authboss) OAuth2Token(ctx context.Context, user OAuth2User) (*oauth2.Token, error) {
+	token := &oauth2.Token{
+		AccessToken:  user.GetOAuth2AccessToken(),
+		TokenType:    "Bearer",
+		RefreshToken: user.GetOAuth2RefreshToken(),
+		Expiry:       user.GetOAuth2Expiry(),
+	}
+	if len(token.AccessToken) != 0 && (token.Expiry.IsZero() || a.Now().Add(oauth2RefreshLeeway).Before(token.Expiry)) {
+		return token, nil
+	}
+
+	provider := user.GetOAuth2Provider()
+	if len(token.RefreshToken) == 0 {
+		a.Logger(ctx).Infof("user %s has no oauth2 refresh token for %s", user.GetPID(), provider)
+		return nil, a.oauth2RefreshFailed(ctx, user)
+	}
+
+	cfg, ok := a.Config.Modules.OAuth2Providers[provider]
+	if !ok {
+		return nil, errors.Errorf("oauth2 provider %q not found", provider)
+	}
+
+	oauthCfg := *cfg.OAuth2Config
+	if cfg.GenerateClientSecret != nil {
+		secret, err := cfg.GenerateClientSecret(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate oauth2 client secret")
+		}
+		oauthCfg.ClientSecret = secret
+	}
+
+	// Without an access token the token source always refreshes
+	refreshed, err := oauthCfg.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if retrieveErr, ok := err.(*oauth2.RetrieveError); ok && retrieveErr.Response != nil &&
+		(retrieveErr.Response.StatusCode == http.StatusBadRequest || retrieveErr.Response.StatusCode == http.StatusUnauthorized) {
+		a.Logger(ctx).Infof("oauth2 refresh token of user %s was refused by %s: %v", user.GetPID(), provider, err)
+		return nil, a.oauth2RefreshFailed(ctx, user)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to refresh oauth2 token of user %s", user.GetPID())
+	}
+
+	user.PutOAuth2AccessToken(refreshed.AccessToken)
+	user.PutOAuth2Expiry(refreshed.Expiry)
+	if len(refreshed.RefreshToken) != 0 {
+		// Some providers rotate refresh tokens
+		user.PutOAuth2RefreshToken(refreshed.RefreshToken)
+	}
+	if err = a.SaveUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return refreshed, nil
+}
+
+// OAuth2TokenSource is an oauth2.TokenSource of the user's tokens using
+// OAuth2Token, for creating clients of the provider's APIs with
+// oauth2.NewClient.
+func (a *Authboss) OAuth2TokenSource(ctx context.Context, user OAuth2User) oauth2.TokenSource {
+	return oauth2TokenSource{ab: a, ctx: ctx, user: user}
+}
+
+type oauth2TokenSource struct {
+	ab   *Authboss
+	ctx  context.Context
+	user OAuth2User
+}
+
+func (o oauth2TokenSource) Token() (*oauth2.Token, error) {
+	return o.ab.OAuth2Token(o.ctx, o.user)
+}
+
+// oauth2RefreshFailed fires EventOAuth2RefreshFailed and returns
+// ErrOAuth2RefreshFailed
+func (a *Authboss) oauth2RefreshFailed(ctx context.Context, user OAuth2User) error {
+	ctx = context.WithValue(ctx, CTXKeyUser, user)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request for EventOAuth2RefreshFailed")
+	}
+
+	if _, err := a.Events.FireAfter(EventOAuth2RefreshFailed, discardResponseWriter{}, r); err != nil {
+		return err
+	}
+	return ErrOAuth2RefreshFailed
+}
+
+// discardResponseWriter is the response writer for events fired outside
+// of a request
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
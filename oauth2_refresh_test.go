@@ -0,0 +1,138 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func testOAuth2RefreshSetup(t *testing.T, status int) (*Authboss, *mockServerStorer, *int) {
+	t.Helper()
+
+	refreshes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+			t.Errorf("refresh request was wrong: %v", r.PostForm)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"new","token_type":"Bearer","refresh_token":"rotated","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+
+	storer := newMockServerStorer()
+	ab := New()
+	ab.Config.Storage.Server = storer
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Modules.OAuth2Providers = map[string]OAuth2Provider{
+		"google": {
+			OAuth2Config: &oauth2.Config{
+				ClientID:     "id",
+				ClientSecret: "secret",
+				Endpoint:     oauth2.Endpoint{TokenURL: server.URL, AuthStyle: oauth2.AuthStyleInParams},
+			},
+		},
+	}
+
+	return ab, storer, &refreshes
+}
+
+func TestOAuth2TokenValid(t *testing.T) {
+	t.Parallel()
+
+	ab, _, refreshes := testOAuth2RefreshSetup(t, http.StatusOK)
+	user := &mockUser{
+		OAuth2Provider: "google",
+		OAuth2Token:    "token",
+		OAuth2Refresh:  "refresh",
+		OAuth2Expiry:   time.Now().Add(time.Hour),
+	}
+
+	token, err := ab.OAuth2Token(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "token" || *refreshes != 0 {
+		t.Error("a valid token should not be refreshed:", token.AccessToken, *refreshes)
+	}
+}
+
+func TestOAuth2TokenRefresh(t *testing.T) {
+	t.Parallel()
+
+	ab, storer, refreshes := testOAuth2RefreshSetup(t, http.StatusOK)
+	user := &mockUser{
+		Email:          "test@test.com",
+		OAuth2Provider: "google",
+		OAuth2Token:    "token",
+		OAuth2Refresh:  "refresh",
+		OAuth2Expiry:   time.Now().Add(30 * time.Second),
+	}
+
+	token, err := ab.OAuth2TokenSource(context.Background(), user).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "new" || *refreshes != 1 {
+		t.Error("a token about to expire should be refreshed:", token.AccessToken, *refreshes)
+	}
+
+	saved, ok := storer.Users["test@test.com"]
+	if !ok {
+		t.Fatal("the user should have been saved")
+	}
+	if saved.OAuth2Token != "new" || saved.OAuth2Refresh != "rotated" || !saved.OAuth2Expiry.After(time.Now().Add(time.Minute)) {
+		t.Errorf("the new tokens were not saved: %#v", saved)
+	}
+}
+
+func TestOAuth2TokenRefreshFailed(t *testing.T) {
+	t.Parallel()
+
+	ab, storer, _ := testOAuth2RefreshSetup(t, http.StatusBadRequest)
+	user := &mockUser{
+		Email:          "test@test.com",
+		OAuth2Provider: "google",
+		OAuth2Token:    "token",
+		OAuth2Refresh:  "refresh",
+		OAuth2Expiry:   time.Now().Add(-time.Hour),
+	}
+
+	var failed User
+	ab.Events.After(EventOAuth2RefreshFailed, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		failed = r.Context().Value(CTXKeyUser).(User)
+		return false, nil
+	})
+
+	if _, err := ab.OAuth2Token(context.Background(), user); err != ErrOAuth2RefreshFailed {
+		t.Error("wrong error:", err)
+	}
+	if failed != user {
+		t.Error("the event should have been fired with the user")
+	}
+	if len(storer.Users) != 0 {
+		t.Error("the user should not have been saved")
+	}
+
+	failed = nil
+	user.OAuth2Refresh = ""
+	if _, err := ab.OAuth2Token(context.Background(), user); err != ErrOAuth2RefreshFailed {
+		t.Error("wrong error:", err)
+	}
+	if failed != user {
+		t.Error("the event should be fired when there's no refresh token")
+	}
+}
@@ -0,0 +1,307 @@
+package authboss
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// APIFieldPID is the name of the APIField that is the user's pid, OpenAPI
+// replaces it with OpenAPIInfo.PIDField since it depends on how the
+// BodyReader reads the pid.
+const APIFieldPID = "{pid}"
+
+// APIField is a value an APIOperation reads from the request, or a value
+// of its response
+type APIField struct {
+	Name string
+	// Type is the JSON type, string when it's empty
+	Type     string
+	Required bool
+	// Format is the OpenAPI format of a string, like password or email
+	Format string
+}
+
+// APIOperation documents one of a module's routes for API clients, see
+// APIDocumenter.
+type APIOperation struct {
+	Method string
+	// Path is the route's path without Paths.Mount
+	Path    string
+	Summary string
+
+	// Fields are the values the route reads from the body, or from the
+	// query for GET routes
+	Fields []APIField
+	// Response are the values of the route's response when it succeeds,
+	// when Redirects is set it's the redirect's JSON instead
+	Response  []APIField
+	Redirects bool
+	// ErrorCodes are the codes of the failures the route responds with
+	ErrorCodes []ErrorCode
+}
+
+// APIDocumenter can be implemented by modules to describe their routes
+// for OpenAPI.
+type APIDocumenter interface {
+	APIOperations(*Authboss) []APIOperation
+}
+
+// OpenAPIInfo describes the API documented by OpenAPI
+type OpenAPIInfo struct {
+	Title   string
+	Version string
+	// PIDField is the name of the field the pid is read from, email when
+	// it's empty
+	PIDField string
+	// Extra documents routes that aren't a loaded module's by tag, like
+	// the ones of totp2fa and sms2fa
+	Extra map[string]APIDocumenter
+}
+
+// OpenAPI describes the JSON endpoints of the loaded modules as an OpenAPI
+// 3 document, ready to be marshaled to JSON, for generating the clients of
+// SPAs and mobile apps. The operations come from the modules that are
+// APIDocumenters and info.Extra, when the Router is a RouteLister its
+// other routes are listed without their schemas. Every failure has the
+// defaults.DefaultErrorEnvelope schema.
+func (a *Authboss) OpenAPI(info OpenAPIInfo) map[string]interface{} {
+	if len(info.PIDField) == 0 {
+		info.PIDField = "email"
+	}
+
+	paths := map[string]map[string]interface{}{}
+	add := func(method, route string, operation map[string]interface{}) {
+		p := path.Join("/", a.Config.Paths.Mount, route)
+		if paths[p] == nil {
+			paths[p] = map[string]interface{}{}
+		}
+		paths[p][strings.ToLower(method)] = operation
+	}
+
+	documenters := make(map[string]APIDocumenter, len(info.Extra))
+	for name, documenter := range info.Extra {
+		documenters[name] = documenter
+	}
+	for _, name := range a.LoadedModules() {
+		if documenter, ok := a.loadedModules[name].(APIDocumenter); ok {
+			documenters[name] = documenter
+		}
+	}
+
+	documented := map[string]bool{}
+	for name, documenter := range documenters {
+		for _, op := range documenter.APIOperations(a) {
+			add(op.Method, op.Path, op.openAPI(name, info.PIDField))
+			documented[op.Method+" "+op.Path] = true
+		}
+	}
+
+	if lister, ok := a.Config.Core.Router.(RouteLister); ok {
+		for _, route := range lister.Routes() {
+			method, p := splitRoute(route)
+			if documented[route] || len(method) == 0 {
+				continue
+			}
+			add(method, p, map[string]interface{}{
+				"summary":   "Undocumented route",
+				"responses": map[string]interface{}{"default": errorResponse(nil)},
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   info.Title,
+			"version": info.Version,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": a.Config.Paths.RootURL}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"status": map[string]interface{}{"type": "string", "enum": []string{"failure"}},
+						"error": map[string]interface{}{
+							"type":     "object",
+							"required": []string{"code"},
+							"properties": map[string]interface{}{
+								"code":    map[string]interface{}{"type": "string"},
+								"message": map[string]interface{}{"type": "string"},
+								"fields": map[string]interface{}{
+									"type": "object",
+									"additionalProperties": map[string]interface{}{
+										"type":  "array",
+										"items": map[string]interface{}{"type": "string"},
+									},
+								},
+							},
+						},
+						"location": map[string]interface{}{"type": "string"},
+					},
+				},
+				"Redirect": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"status":   map[string]interface{}{"type": "string", "enum": []string{"success"}},
+						"location": map[string]interface{}{"type": "string"},
+						"message":  map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// openAPI is the OpenAPI operation object
+func (o APIOperation) openAPI(module, pidField string) map[string]interface{} {
+	operation := map[string]interface{}{
+		"summary":     o.Summary,
+		"operationId": module + strings.Title(strings.ToLower(o.Method)) + operationName(o.Path),
+		"tags":        []string{module},
+	}
+
+	if len(o.Fields) != 0 {
+		if o.Method == http.MethodGet {
+			var params []interface{}
+			for _, f := range o.Fields {
+				params = append(params, map[string]interface{}{
+					"name":     f.name(pidField),
+					"in":       "query",
+					"required": f.Required,
+					"schema":   f.schema(),
+				})
+			}
+			operation["parameters"] = params
+		} else {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(objectSchema(o.Fields, pidField)),
+			}
+		}
+	}
+
+	responses := map[string]interface{}{}
+	if o.Redirects {
+		responses[strconv.Itoa(http.StatusTemporaryRedirect)] = map[string]interface{}{
+			"description": "Succeeded, the JSON tells where a browser would have been redirected",
+			"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Redirect"}),
+		}
+	} else {
+		responses[strconv.Itoa(http.StatusOK)] = map[string]interface{}{
+			"description": "Succeeded",
+			"content":     jsonContent(objectSchema(o.Response, pidField)),
+		}
+	}
+
+	byStatus := map[int][]ErrorCode{}
+	for _, code := range o.ErrorCodes {
+		byStatus[code.Status()] = append(byStatus[code.Status()], code)
+	}
+	for status, codes := range byStatus {
+		responses[strconv.Itoa(status)] = errorResponse(codes)
+	}
+	responses["default"] = errorResponse(nil)
+	operation["responses"] = responses
+
+	return operation
+}
+
+func (f APIField) name(pidField string) string {
+	if f.Name == APIFieldPID {
+		return pidField
+	}
+	return f.Name
+}
+
+func (f APIField) schema() map[string]interface{} {
+	schema := map[string]interface{}{"type": "string"}
+	if len(f.Type) != 0 {
+		schema["type"] = f.Type
+	}
+	if len(f.Format) != 0 {
+		schema["format"] = f.Format
+	}
+	return schema
+}
+
+func objectSchema(fields []APIField, pidField string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for _, f := range fields {
+		properties[f.name(pidField)] = f.schema()
+		if f.Required {
+			required = append(required, f.name(pidField))
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) != 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// errorResponse is the response object of failures with the codes, any
+// code when there are none
+func errorResponse(codes []ErrorCode) map[string]interface{} {
+	if len(codes) == 0 {
+		return map[string]interface{}{
+			"description": "Failed",
+			"content":     jsonContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		names[i] = string(code)
+	}
+	sort.Strings(names)
+
+	return map[string]interface{}{
+		"description": "Failed with " + strings.Join(names, ", "),
+		"content": jsonContent(map[string]interface{}{
+			"allOf": []interface{}{
+				map[string]interface{}{"$ref": "#/components/schemas/Error"},
+				map[string]interface{}{
+					"properties": map[string]interface{}{
+						"error": map[string]interface{}{
+							"properties": map[string]interface{}{
+								"code": map[string]interface{}{"enum": names},
+							},
+						},
+					},
+				},
+			},
+		}),
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// operationName is the route's path in camel case, /2fa/totp/validate is
+// 2faTotpValidate
+func operationName(route string) string {
+	var name strings.Builder
+	for _, part := range strings.FieldsFunc(route, func(r rune) bool { return r == '/' || r == '-' || r == '_' }) {
+		name.WriteString(strings.Title(part))
+	}
+	return name.String()
+}
+
+// splitRoute splits a RouteLister route ("POST /login")
+func splitRoute(route string) (method, p string) {
+	i := strings.IndexByte(route, ' ')
+	if i < 0 {
+		return "", route
+	}
+	return route[:i], route[i+1:]
+}
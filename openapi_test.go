@@ -0,0 +1,104 @@
+package authboss
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type testDocumenter []APIOperation
+
+func (t testDocumenter) APIOperations(*Authboss) []APIOperation { return t }
+
+type testListingRouter struct {
+	testRouter
+}
+
+func (t testListingRouter) Routes() []string {
+	var routes []string
+	for route := range t.testRouter {
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Router = testListingRouter{testRouter{}}
+	ab.Config.Paths.Mount = "/auth"
+	ab.Config.Core.Router.Post("/login", http.NotFoundHandler())
+	ab.Config.Core.Router.Get("/other", http.NotFoundHandler())
+
+	doc := ab.OpenAPI(OpenAPIInfo{
+		Title:    "API",
+		Version:  "1",
+		PIDField: "username",
+		Extra: map[string]APIDocumenter{"auth": testDocumenter{{
+			Method:  http.MethodPost,
+			Path:    "/login",
+			Summary: "Log in",
+			Fields: []APIField{
+				{Name: APIFieldPID, Required: true},
+				{Name: "password", Required: true, Format: "password"},
+			},
+			Redirects:  true,
+			ErrorCodes: []ErrorCode{ErrorCodeValidation, ErrorCodeInvalidCredentials},
+		}}},
+	})
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Summary     string `json:"summary"`
+			OperationID string `json:"operationId"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema struct {
+						Required []string `json:"required"`
+					} `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+			Responses map[string]struct {
+				Description string `json:"description"`
+			} `json:"responses"`
+		} `json:"paths"`
+	}
+	if err = json.Unmarshal(b, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.OpenAPI != "3.0.3" {
+		t.Error("version was wrong:", parsed.OpenAPI)
+	}
+
+	login, ok := parsed.Paths["/auth/login"]["post"]
+	if !ok {
+		t.Fatalf("the login operation is missing: %s", b)
+	}
+	if login.OperationID != "authPostLogin" || login.Summary != "Log in" {
+		t.Error("operation was wrong:", login.OperationID, login.Summary)
+	}
+	if required := login.RequestBody.Content["application/json"].Schema.Required; strings.Join(required, ",") != "username,password" {
+		t.Error("required fields were wrong:", required)
+	}
+	for _, status := range []string{"307", "401", "422", "default"} {
+		if _, ok := login.Responses[status]; !ok {
+			t.Error("missing response", status)
+		}
+	}
+	if desc := login.Responses["401"].Description; !strings.Contains(desc, string(ErrorCodeInvalidCredentials)) {
+		t.Error("the error codes should be described:", desc)
+	}
+
+	if other := parsed.Paths["/auth/other"]["get"]; other.Summary != "Undocumented route" {
+		t.Error("routes without documentation should still be listed:", other.Summary)
+	}
+}
@@ -28,6 +28,13 @@ const (
 	PageAdd = "otpadd"
 	// PageClear is for deleting all the otps from the user
 	PageClear = "otpclear"
+	// PagePassword is for setting a password after logging in with an otp,
+	// see Modules.OTPRequirePassword
+	PagePassword = "otppassword"
+
+	// SessionOTPPendingPID is the session key for the user that logged in
+	// with an otp but must set a password before being logged in
+	SessionOTPPendingPID = "otp_pending"
 
 	// DataNumberOTPs shows the number of otps for add/clear operations
 	DataNumberOTPs = "otp_count"
@@ -64,6 +71,12 @@ type OTP struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what one time passwords need
+func (o *OTP) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Storage.Server", "Storage.SessionState")
+}
+
 // Init module
 func (o *OTP) Init(ab *authboss.Authboss) (err error) {
 	o.Authboss = ab
@@ -88,6 +101,15 @@ func (o *OTP) Init(ab *authboss.Authboss) (err error) {
 	o.Authboss.Config.Core.Router.Get("/otp/clear", middleware(o.Authboss.Core.ErrorHandler.Wrap(o.ClearGet)))
 	o.Authboss.Config.Core.Router.Post("/otp/clear", middleware(o.Authboss.Core.ErrorHandler.Wrap(o.ClearPost)))
 
+	if o.Config.Modules.OTPRequirePassword != nil {
+		if err = o.Authboss.Config.Core.ViewRenderer.Load(PagePassword); err != nil {
+			return err
+		}
+
+		o.Authboss.Config.Core.Router.Get("/otp/password", o.Authboss.Core.ErrorHandler.Wrap(o.PasswordGet))
+		o.Authboss.Config.Core.Router.Post("/otp/password", o.Authboss.Core.ErrorHandler.Wrap(o.PasswordPost))
+	}
+
 	return nil
 }
 
@@ -115,15 +137,25 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	creds := authboss.MustHaveUserValues(validatable)
 
 	pid := creds.GetPID()
-	pidUser, err := o.Authboss.Storage.Server.Load(r.Context(), pid)
+	attempt := authboss.AttemptInfo{PID: pid, Module: "otp", Stage: authboss.AttemptStagePassword}
+	r = o.WithAttempt(r, attempt)
+
+	pidUser, err := o.Authboss.LoadByCredential(r.Context(), pid)
 	if err == authboss.ErrUserNotFound {
 		logger.Infof("failed to load user requested by pid: %s", pid)
-		data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
+		o.Authboss.CountLogin("otp", false)
+		data := authboss.HTMLData{
+			authboss.DataErr:     o.Localize(r.Context(), authboss.TxtInvalidCredentials),
+			authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials,
+		}
 		return o.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
 	} else if err != nil {
 		return err
 	}
 
+	// The user may have logged in with another of their credentials
+	pid = pidUser.GetPID()
+
 	otpUser := MustBeOTPable(pidUser)
 	passwords := splitOTPs(otpUser.GetOTPs())
 
@@ -145,6 +177,9 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 
 	var handled bool
 	if matchPassword < 0 {
+		o.Authboss.CountLogin("otp", false)
+		attempt.Failure = authboss.ErrorCodeInvalidCredentials
+		r = o.WithAttempt(r, attempt)
 		handled, err = o.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
 		if err != nil {
 			return err
@@ -153,7 +188,10 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		logger.Infof("user %s failed to log in with otp", pid)
-		data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
+		data := authboss.HTMLData{
+			authboss.DataErr:     o.Localize(r.Context(), authboss.TxtInvalidCredentials),
+			authboss.DataErrCode: authboss.ErrorCodeInvalidCredentials,
+		}
 		return o.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
 	}
 
@@ -161,12 +199,16 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	passwords[matchPassword] = passwords[len(passwords)-1]
 	passwords = passwords[:len(passwords)-1]
 	otpUser.PutOTPs(joinOTPs(passwords))
-	if err = o.Authboss.Config.Storage.Server.Save(r.Context(), pidUser); err != nil {
+	if err = o.Authboss.SaveUser(r.Context(), pidUser); err != nil {
 		return err
 	}
 
 	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
 
+	// Kept for after setting a password or any other step in between, see
+	// Auth.LoginPost
+	o.Authboss.KeepReturnTo(w, r.FormValue(authboss.FormValueRedirect))
+
 	handled, err = o.Events.FireBefore(authboss.EventAuth, w, r)
 	if err != nil {
 		return err
@@ -174,7 +216,30 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
-	handled, err = o.Events.FireBefore(authboss.EventAuthHijack, w, r)
+	if o.mustSetPassword(r, pidUser) {
+		logger.Infof("user %s logged in via otp must set a password", pid)
+		authboss.PutSession(w, SessionOTPPendingPID, pid)
+
+		var query string
+		if len(r.URL.RawQuery) != 0 {
+			query = "?" + r.URL.RawQuery
+		}
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: o.Paths.Mount + "/otp/password" + query,
+		}
+		return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	return o.login(w, r, pidUser)
+}
+
+// login finishes logging in a user whose credentials have been checked
+func (o *OTP) login(w http.ResponseWriter, r *http.Request, user authboss.User) error {
+	logger := o.RequestLogger(r)
+	pid := user.GetPID()
+
+	handled, err := o.Events.FireBefore(authboss.EventAuthHijack, w, r)
 	if err != nil {
 		return err
 	} else if handled {
@@ -184,6 +249,7 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	logger.Infof("user %s logged in via otp", pid)
 	authboss.PutSession(w, authboss.SessionKey, pid)
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	o.Authboss.CountLogin("otp", true)
 
 	handled, err = o.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
 	if err != nil {
@@ -194,12 +260,115 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 
 	ro := authboss.RedirectOptions{
 		Code:             http.StatusTemporaryRedirect,
-		RedirectPath:     o.Authboss.Paths.AuthLoginOK,
+		RedirectPath:     o.Authboss.ReturnTo(w, r, o.Authboss.RedirectPath(r, "otp", authboss.RedirectOK, user, o.Authboss.AccountTypePath(user, o.Authboss.RequestConfig(r.Context()).Paths.AuthLoginOK))),
 		FollowRedirParam: true,
 	}
 	return o.Authboss.Core.Redirector.Redirect(w, r, ro)
 }
 
+// mustSetPassword checks Modules.OTPRequirePassword for users that don't
+// have a password
+func (o *OTP) mustSetPassword(r *http.Request, user authboss.User) bool {
+	policy := o.Config.Modules.OTPRequirePassword
+	if policy == nil {
+		return false
+	}
+
+	authUser, ok := user.(authboss.AuthableUser)
+	if !ok || len(authUser.GetPassword()) != 0 {
+		return false
+	}
+
+	return policy(r, user)
+}
+
+// pendingUser loads the user that must set a password before being logged
+// in, it returns ErrUserNotFound if there isn't one
+func (o *OTP) pendingUser(r *http.Request) (authboss.AuthableUser, error) {
+	pid, ok := authboss.GetSession(r, SessionOTPPendingPID)
+	if !ok || len(pid) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	user, err := o.Authboss.LoadUser(r.Context(), pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return authboss.MustBeAuthable(user), nil
+}
+
+// noPendingUser sends users that haven't logged in with an otp back to
+// the login page
+func (o *OTP) noPendingUser(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: o.Paths.Mount + "/otp/login",
+		Failure:      o.Localize(r.Context(), authboss.TxtLogInFirst),
+		FailureCode:  authboss.ErrorCodeNotAuthorized,
+	}
+	return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// PasswordGet shows the form to set a password after logging in with an otp
+func (o *OTP) PasswordGet(w http.ResponseWriter, r *http.Request) error {
+	if _, err := o.pendingUser(r); err == authboss.ErrUserNotFound {
+		return o.noPendingUser(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	var data authboss.HTMLData
+	if redir := r.URL.Query().Get(authboss.FormValueRedirect); len(redir) != 0 {
+		data = authboss.HTMLData{authboss.FormValueRedirect: redir}
+	}
+	return o.Core.Responder.Respond(w, r, http.StatusOK, PagePassword, data)
+}
+
+// PasswordPost sets the password of a user that logged in with an otp and
+// then finishes logging them in
+func (o *OTP) PasswordPost(w http.ResponseWriter, r *http.Request) error {
+	logger := o.RequestLogger(r)
+
+	user, err := o.pendingUser(r)
+	if err == authboss.ErrUserNotFound {
+		return o.noPendingUser(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	validatable, err := o.Authboss.Core.BodyReader.Read(PagePassword, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("otp password validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: o.LocalizeErrors(r.Context(), errs)}
+		return o.Core.Responder.Respond(w, r, http.StatusOK, PagePassword, data)
+	}
+
+	password := authboss.MustHaveRecoverEndValues(validatable).GetPassword()
+	if err = o.Authboss.UpdatePassword(r.Context(), user, password); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s set a password after logging in via otp", user.GetPID())
+	authboss.DelSession(w, SessionOTPPendingPID)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
+
+	handled, err := o.Authboss.Events.FireAfter(authboss.EventPasswordChange, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	return o.login(w, r, user)
+}
+
 // AddGet shows how many passwords exist and allows the user to create a new one
 func (o *OTP) AddGet(w http.ResponseWriter, r *http.Request) error {
 	return o.showOTPCount(w, r, PageAdd)
@@ -218,7 +387,7 @@ func (o *OTP) AddPost(w http.ResponseWriter, r *http.Request) error {
 	currentOTPs := splitOTPs(otpUser.GetOTPs())
 
 	if len(currentOTPs) >= maxOTPs {
-		data := authboss.HTMLData{authboss.DataValidation: fmt.Sprintf("you cannot have more than %d one time passwords", maxOTPs)}
+		data := authboss.HTMLData{authboss.DataValidation: o.Localize(r.Context(), authboss.TxtTooManyOTPs, "Max", maxOTPs)}
 		return o.Core.Responder.Respond(w, r, http.StatusOK, PageAdd, data)
 	}
 
@@ -231,7 +400,7 @@ func (o *OTP) AddPost(w http.ResponseWriter, r *http.Request) error {
 	currentOTPs = append(currentOTPs, hash)
 	otpUser.PutOTPs(joinOTPs(currentOTPs))
 
-	if err := o.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+	if err := o.Authboss.SaveUser(r.Context(), user); err != nil {
 		return err
 	}
 
@@ -256,7 +425,7 @@ func (o *OTP) ClearPost(w http.ResponseWriter, r *http.Request) error {
 	otpUser := MustBeOTPable(user)
 	otpUser.PutOTPs("")
 
-	if err := o.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+	if err := o.Authboss.SaveUser(r.Context(), user); err != nil {
 		return err
 	}
 
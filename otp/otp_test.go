@@ -260,6 +260,96 @@ func TestLoginPostSuccess(t *testing.T) {
 	})
 }
 
+func TestLoginPostRequirePassword(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.OTPRequirePassword = func(r *http.Request, user authboss.User) bool { return true }
+	h.bodyReader.Return = mocks.Values{
+		PID:      "test@test.com",
+		Password: "3cc94671-958a912d-bd5a3ba7-3326a380",
+	}
+	h.storer.Users["test@test.com"] = &mocks.User{
+		Email: "test@test.com",
+		// 3cc94671-958a912d-bd5a3ba7-3326a380
+		OTPs: "2aIDHxmTIy1W7Uyz9c+iqhOJSE0a2Yna3zTRTs2q/X7Bv3xdVjExoztBEG4sQ2Nn3jcaPxdIuhslvSsjaYK5uA==",
+	}
+
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	if err := h.otp.LoginPost(w, mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if p := h.redirector.Options.RedirectPath; p != "/auth/otp/password" {
+		t.Error("redirect path was wrong:", p)
+	}
+	if pid := h.session.ClientValues[SessionOTPPendingPID]; pid != "test@test.com" {
+		t.Error("the user should be pending:", pid)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the user should not be logged in yet")
+	}
+}
+
+func TestPasswordPost(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.OTPRequirePassword = func(r *http.Request, user authboss.User) bool { return true }
+	h.bodyReader.Return = mocks.Values{Password: "hello world"}
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	h.session.ClientValues[SessionOTPPendingPID] = "test@test.com"
+
+	changed := false
+	h.ab.Events.After(authboss.EventPasswordChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		changed = r.Context().Value(authboss.CTXKeyUser) != nil
+		return false, nil
+	})
+
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.otp.PasswordPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if !changed {
+		t.Error("EventPasswordChange should have been fired with the user")
+	}
+
+	if len(h.storer.Users["test@test.com"].Password) == 0 {
+		t.Error("the password should have been set")
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("the user should be logged in:", pid)
+	}
+	if _, ok := h.session.ClientValues[SessionOTPPendingPID]; ok {
+		t.Error("the pending user should have been deleted")
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/login/ok" {
+		t.Error("redirect path was wrong:", p)
+	}
+
+	h.session.ClientValues = map[string]string{}
+	r, err = h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.otp.PasswordPost(h.ab.NewResponse(httptest.NewRecorder()), r); err != nil {
+		t.Fatal(err)
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/auth/otp/login" {
+		t.Error("users that haven't logged in should be sent to log in:", p)
+	}
+}
+
 func TestLoginPostBadPassword(t *testing.T) {
 	t.Parallel()
 
@@ -11,7 +11,6 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
@@ -151,6 +150,22 @@ func (s *SMS) Setup() error {
 	)
 }
 
+// APIOperations documents the validate route for authboss.OpenAPI, it's
+// given to it in OpenAPIInfo.Extra since SMS isn't a module
+func (s *SMS) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{{
+		Method:  http.MethodPost,
+		Path:    "/2fa/sms/validate",
+		Summary: "Finish logging in with a texted code or a recovery code",
+		Fields: []authboss.APIField{
+			{Name: FormValueCode},
+			{Name: twofactor.DataRecoveryCode},
+		},
+		Redirects:  true,
+		ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation, authboss.ErrorCodeRateLimited},
+	}}
+}
+
 // HijackAuth stores the user's pid in a special temporary session variable
 // and redirects them to the validation endpoint.
 func (s *SMS) HijackAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
@@ -160,13 +175,16 @@ func (s *SMS) HijackAuth(w http.ResponseWriter, r *http.Request, handled bool) (
 
 	user := r.Context().Value(authboss.CTXKeyUser).(User)
 
-	number := user.GetSMSPhoneNumber()
-	if len(number) == 0 {
+	if len(user.GetSMSPhoneNumber()) == 0 {
 		return false, nil
 	}
+	number, err := s.LoadSecret(r.Context(), user, authboss.AttributeSMSPhoneNumber, user.GetSMSPhoneNumber(), user.PutSMSPhoneNumber)
+	if err != nil {
+		return false, err
+	}
 
 	authboss.PutSession(w, SessionSMSPendingPID, user.GetPID())
-	err := s.SendCodeToUser(w, r, user.GetPID(), number)
+	err = s.SendCodeToUser(w, r, user.GetPID(), number)
 	if err != nil && err != errSMSRateLimit {
 		return false, err
 	}
@@ -202,7 +220,7 @@ func (s *SMS) SendCodeToUser(w http.ResponseWriter, r *http.Request, pid, number
 		if err != nil {
 			return err
 		}
-		suppress = time.Now().UTC().Unix()-last < smsRateLimitSeconds
+		suppress = s.Now().UTC().Unix()-last < smsRateLimitSeconds
 	}
 
 	if suppress {
@@ -210,7 +228,7 @@ func (s *SMS) SendCodeToUser(w http.ResponseWriter, r *http.Request, pid, number
 		return errSMSRateLimit
 	}
 
-	authboss.PutSession(w, SessionSMSLast, strconv.FormatInt(time.Now().UTC().Unix(), 10))
+	authboss.PutSession(w, SessionSMSLast, strconv.FormatInt(s.Now().UTC().Unix(), 10))
 	authboss.PutSession(w, SessionSMSSecret, code)
 
 	logger.Infof("sending sms for %s to %s", pid, number)
@@ -263,7 +281,7 @@ func (s *SMS) PostSetup(w http.ResponseWriter, r *http.Request) error {
 	number := smsVals.GetPhoneNumber()
 	if len(number) == 0 {
 		data := authboss.HTMLData{
-			authboss.DataValidation: map[string][]string{FormValuePhoneNumber: {"must provide a phone number"}},
+			authboss.DataValidation: map[string][]string{FormValuePhoneNumber: {s.Authboss.Localize(r.Context(), authboss.TxtPhoneRequired)}},
 		}
 		return s.Core.Responder.Respond(w, r, http.StatusOK, PageSMSSetup, data)
 	}
@@ -297,7 +315,7 @@ func (s *SMSValidator) Post(w http.ResponseWriter, r *http.Request) error {
 	if err == authboss.ErrUserNotFound {
 		pid, ok := authboss.GetSession(r, SessionSMSPendingPID)
 		if ok && len(pid) != 0 {
-			abUser, err = s.Authboss.Config.Storage.Server.Load(r.Context(), pid)
+			abUser, err = s.Authboss.LoadUser(r.Context(), pid)
 		}
 	}
 	if err != nil {
@@ -345,7 +363,11 @@ func (s *SMSValidator) sendCode(w http.ResponseWriter, r *http.Request, user Use
 		}
 
 	case PageSMSValidate, PageSMSRemove:
-		phoneNumber = user.GetSMSPhoneNumber()
+		var err error
+		phoneNumber, err = s.LoadSecret(r.Context(), user, authboss.AttributeSMSPhoneNumber, user.GetSMSPhoneNumber(), user.PutSMSPhoneNumber)
+		if err != nil {
+			return err
+		}
 	}
 
 	if len(phoneNumber) == 0 {
@@ -355,7 +377,10 @@ func (s *SMSValidator) sendCode(w http.ResponseWriter, r *http.Request, user Use
 	var data authboss.HTMLData
 	err := s.SendCodeToUser(w, r, user.GetPID(), phoneNumber)
 	if err == errSMSRateLimit {
-		data = authboss.HTMLData{authboss.DataErr: "please wait a few moments before resending SMS code"}
+		data = authboss.HTMLData{
+			authboss.DataErr:     s.Authboss.Localize(r.Context(), authboss.TxtSMSWait),
+			authboss.DataErrCode: authboss.ErrorCodeRateLimited,
+		}
 	} else if err != nil {
 		return err
 	}
@@ -377,7 +402,7 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 		if verified {
 			logger.Infof("user %s used recovery code instead of sms2fa", user.GetPID())
 			user.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(recoveryCodes))
-			if err := s.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+			if err := s.Authboss.SaveUser(r.Context(), user); err != nil {
 				return err
 			}
 		}
@@ -391,7 +416,16 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 	}
 
 	if !verified {
+		if s.Page == PageSMSValidate {
+			s.Authboss.CountTwoFactorValidation("sms2fa", false)
+		}
 		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		r = s.WithAttempt(r, authboss.AttemptInfo{
+			PID:     user.GetPID(),
+			Module:  "sms2fa",
+			Stage:   authboss.AttemptStageTwoFactor,
+			Failure: authboss.ErrorCodeValidation,
+		})
 		handled, err := s.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
 		if err != nil {
 			return err
@@ -401,7 +435,7 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 
 		logger.Infof("user %s sms 2fa failure (wrong code)", user.GetPID())
 		data := authboss.HTMLData{
-			authboss.DataValidation: map[string][]string{FormValueCode: {"2fa code was invalid"}},
+			authboss.DataValidation: map[string][]string{FormValueCode: {s.Authboss.Localize(r.Context(), authboss.TxtInvalid2FACode)}},
 		}
 		return s.Authboss.Core.Responder.Respond(w, r, http.StatusOK, s.Page, data)
 	}
@@ -425,10 +459,15 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 			return err
 		}
 
+		encrypted, err := s.EncryptSecret(r.Context(), authboss.AttributeSMSPhoneNumber, phoneNumber)
+		if err != nil {
+			return err
+		}
+
 		// Save the user which activates 2fa (phone number should be stored from earlier)
-		user.PutSMSPhoneNumber(phoneNumber)
+		user.PutSMSPhoneNumber(encrypted)
 		user.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(crypted))
-		if err = s.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		if err = s.Authboss.SaveUser(r.Context(), user); err != nil {
 			return err
 		}
 
@@ -438,15 +477,29 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 
 		logger.Infof("user %s enabled sms 2fa", user.GetPID())
 		data = authboss.HTMLData{twofactor.DataRecoveryCodes: codes}
+
+		handled, err := s.Authboss.Events.FireAfter(authboss.EventTwoFactorAdd, w, r)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
 	case PageSMSRemove:
 		user.PutSMSPhoneNumber("")
-		if err := s.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		if err := s.Authboss.SaveUser(r.Context(), user); err != nil {
 			return err
 		}
 
 		authboss.DelSession(w, authboss.Session2FA)
 
 		logger.Infof("user %s disabled sms 2fa", user.GetPID())
+
+		handled, err := s.Authboss.Events.FireAfter(authboss.EventTwoFactorRemove, w, r)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
 	case PageSMSValidate:
 		authboss.PutSession(w, authboss.SessionKey, user.GetPID())
 		authboss.PutSession(w, authboss.Session2FA, "sms")
@@ -456,8 +509,10 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 		authboss.DelSession(w, SessionSMSSecret)
 
 		logger.Infof("user %s sms 2fa success", user.GetPID())
+		s.Authboss.CountTwoFactorValidation("sms2fa", true)
 
 		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		r = s.WithAttempt(r, authboss.AttemptInfo{PID: user.GetPID(), Module: "sms2fa", Stage: authboss.AttemptStageTwoFactor})
 		handled, err := s.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
 		if err != nil {
 			return err
@@ -467,7 +522,7 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 
 		ro := authboss.RedirectOptions{
 			Code:             http.StatusTemporaryRedirect,
-			RedirectPath:     s.Authboss.Config.Paths.AuthLoginOK,
+			RedirectPath:     s.Authboss.ReturnTo(w, r, s.Authboss.RedirectPath(r, "sms2fa", authboss.RedirectOK, user, s.Authboss.AccountTypePath(user, s.Authboss.RequestConfig(r.Context()).Paths.AuthLoginOK))),
 			FollowRedirParam: true,
 		}
 		return s.Authboss.Core.Redirector.Redirect(w, r, ro)
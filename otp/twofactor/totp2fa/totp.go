@@ -56,6 +56,12 @@ const (
 	validationErrInvalidCode = "2fa code was invalid"
 )
 
+// validationMessages are shown to the user for the failed validations
+var validationMessages = map[string]authboss.LocalizationKey{
+	validationErrRepeatCode:  authboss.TxtRepeated2FACode,
+	validationErrInvalidCode: authboss.TxtInvalid2FACode,
+}
+
 var (
 	errNoTOTPEnabled = errors.New("user does not have totp 2fa enabled")
 )
@@ -207,7 +213,10 @@ func (t *TOTP) GetQRCode(w http.ResponseWriter, r *http.Request) error {
 
 	var key *otp.Key
 	if !ok || len(totpSecret) == 0 {
-		totpSecret = user.GetTOTPSecretKey()
+		totpSecret, err = t.LoadSecret(r.Context(), user, authboss.AttributeTOTPSecretKey, user.GetTOTPSecretKey(), user.PutTOTPSecretKey)
+		if err != nil {
+			return err
+		}
 	}
 
 	if len(totpSecret) == 0 {
@@ -275,10 +284,10 @@ func (t *TOTP) PostConfirm(w http.ResponseWriter, r *http.Request) error {
 	totpCodeValues := MustHaveTOTPCodeValues(validator)
 	inputCode := totpCodeValues.GetCode()
 
-	ok = totp.Validate(inputCode, totpSecret)
+	ok = t.validateCode(inputCode, totpSecret)
 	if !ok {
 		data := authboss.HTMLData{
-			authboss.DataValidation: map[string][]string{FormValueCode: {"2fa code was invalid"}},
+			authboss.DataValidation: map[string][]string{FormValueCode: {t.Authboss.Localize(r.Context(), authboss.TxtInvalid2FACode)}},
 			DataTOTPSecret:          totpSecret,
 		}
 		return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPConfirm, data)
@@ -294,13 +303,18 @@ func (t *TOTP) PostConfirm(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	encrypted, err := t.EncryptSecret(r.Context(), authboss.AttributeTOTPSecretKey, totpSecret)
+	if err != nil {
+		return err
+	}
+
 	// Save the user which activates 2fa
-	user.PutTOTPSecretKey(totpSecret)
+	user.PutTOTPSecretKey(encrypted)
 	user.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(crypted))
 	if oneTime, ok := user.(UserOneTime); ok {
 		oneTime.PutTOTPLastCode(inputCode)
 	}
-	if err = t.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+	if err = t.Authboss.SaveUser(r.Context(), user); err != nil {
 		return err
 	}
 
@@ -310,6 +324,13 @@ func (t *TOTP) PostConfirm(w http.ResponseWriter, r *http.Request) error {
 	logger := t.RequestLogger(r)
 	logger.Infof("user %s enabled totp 2fa", user.GetPID())
 
+	handled, err := t.Authboss.Events.FireAfter(authboss.EventTwoFactorAdd, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
 	data := authboss.HTMLData{twofactor.DataRecoveryCodes: codes}
 	return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPConfirmSuccess, data)
 }
@@ -326,29 +347,55 @@ func (t *TOTP) PostRemove(w http.ResponseWriter, r *http.Request) error {
 	user, status, err := t.validate(r)
 	switch {
 	case err == errNoTOTPEnabled:
-		data := authboss.HTMLData{authboss.DataErr: "totp 2fa not active"}
+		data := authboss.HTMLData{
+			authboss.DataErr:     t.Authboss.Localize(r.Context(), authboss.TxtTOTPNotActive),
+			authboss.DataErrCode: authboss.ErrorCodeNotEnabled,
+		}
 		return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPRemove, data)
 	case err != nil:
 		return err
 	case status != validationSuccess:
 		logger.Infof("user %s totp 2fa removal failure (%s)", user.GetPID(), status)
 		data := authboss.HTMLData{
-			authboss.DataValidation: map[string][]string{FormValueCode: {status}},
+			authboss.DataValidation: map[string][]string{FormValueCode: {t.Authboss.Localize(r.Context(), validationMessages[status])}},
 		}
 		return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPRemove, data)
 	}
 
 	authboss.DelSession(w, authboss.Session2FA)
 	user.PutTOTPSecretKey("")
-	if err = t.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+	if err = t.Authboss.SaveUser(r.Context(), user); err != nil {
 		return err
 	}
 
 	logger.Infof("user %s disabled totp 2fa", user.GetPID())
 
+	handled, err := t.Authboss.Events.FireAfter(authboss.EventTwoFactorRemove, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
 	return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPRemoveSuccess, nil)
 }
 
+// APIOperations documents the validate route for authboss.OpenAPI, it's
+// given to it in OpenAPIInfo.Extra since TOTP isn't a module
+func (t *TOTP) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{{
+		Method:  http.MethodPost,
+		Path:    "/2fa/totp/validate",
+		Summary: "Finish logging in with an authenticator app's code or a recovery code",
+		Fields: []authboss.APIField{
+			{Name: FormValueCode},
+			{Name: twofactor.DataRecoveryCode},
+		},
+		Redirects:  true,
+		ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation, authboss.ErrorCodeNotEnabled},
+	}}
+}
+
 // GetValidate shows a page to enter a code into
 func (t *TOTP) GetValidate(w http.ResponseWriter, r *http.Request) error {
 	return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPValidate, nil)
@@ -362,12 +409,22 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 	switch {
 	case err == errNoTOTPEnabled:
 		logger.Infof("user %s totp failure (not enabled)", user.GetPID())
-		data := authboss.HTMLData{authboss.DataErr: "totp 2fa not active"}
+		data := authboss.HTMLData{
+			authboss.DataErr:     t.Authboss.Localize(r.Context(), authboss.TxtTOTPNotActive),
+			authboss.DataErrCode: authboss.ErrorCodeNotEnabled,
+		}
 		return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPValidate, data)
 	case err != nil:
 		return err
 	case status != validationSuccess:
+		t.Authboss.CountTwoFactorValidation("totp2fa", false)
 		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		r = t.WithAttempt(r, authboss.AttemptInfo{
+			PID:     user.GetPID(),
+			Module:  "totp2fa",
+			Stage:   authboss.AttemptStageTwoFactor,
+			Failure: authboss.ErrorCodeValidation,
+		})
 		handled, err := t.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
 		if err != nil {
 			return err
@@ -377,7 +434,7 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 
 		logger.Infof("user %s totp 2fa failure (%s)", user.GetPID(), status)
 		data := authboss.HTMLData{
-			authboss.DataValidation: map[string][]string{FormValueCode: {status}},
+			authboss.DataValidation: map[string][]string{FormValueCode: {t.Authboss.Localize(r.Context(), validationMessages[status])}},
 		}
 		return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageTOTPValidate, data)
 	}
@@ -386,7 +443,7 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 	// this and we need to preserve it. Normally there's no database hit
 	// required because we are only reading the secret and validating.
 	if _, ok := user.(UserOneTime); ok {
-		if err = t.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+		if err = t.Authboss.SaveUser(r.Context(), user); err != nil {
 			return err
 		}
 	}
@@ -399,8 +456,10 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 	authboss.DelSession(w, SessionTOTPSecret)
 
 	logger.Infof("user %s totp 2fa success", user.GetPID())
+	t.Authboss.CountTwoFactorValidation("totp2fa", true)
 
 	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	r = t.WithAttempt(r, authboss.AttemptInfo{PID: user.GetPID(), Module: "totp2fa", Stage: authboss.AttemptStageTwoFactor})
 	handled, err := t.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
 	if err != nil {
 		return err
@@ -410,7 +469,7 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 
 	ro := authboss.RedirectOptions{
 		Code:             http.StatusTemporaryRedirect,
-		RedirectPath:     t.Authboss.Config.Paths.AuthLoginOK,
+		RedirectPath:     t.Authboss.ReturnTo(w, r, t.Authboss.RedirectPath(r, "totp2fa", authboss.RedirectOK, user, t.Authboss.AccountTypePath(user, t.Authboss.RequestConfig(r.Context()).Paths.AuthLoginOK))),
 		FollowRedirParam: true,
 	}
 	return t.Authboss.Core.Redirector.Redirect(w, r, ro)
@@ -421,6 +480,18 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 // if err != nil.
 //
 // validate will set the previously used code to the input
+// validateCode checks a code against the secret at the time of Authboss.Now
+// with the same options as totp.Validate
+func (t *TOTP) validateCode(code, secret string) bool {
+	ok, err := totp.ValidateCustom(code, secret, t.Now().UTC(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      1,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	return err == nil && ok
+}
+
 func (t *TOTP) validate(r *http.Request) (User, string, error) {
 	logger := t.RequestLogger(r)
 
@@ -431,7 +502,7 @@ func (t *TOTP) validate(r *http.Request) (User, string, error) {
 	if err == authboss.ErrUserNotFound {
 		pid, ok := authboss.GetSession(r, SessionTOTPPendingPID)
 		if ok && len(pid) != 0 {
-			abUser, err = t.Authboss.Config.Storage.Server.Load(r.Context(), pid)
+			abUser, err = t.Authboss.LoadUser(r.Context(), pid)
 		}
 	}
 	if err != nil {
@@ -440,10 +511,13 @@ func (t *TOTP) validate(r *http.Request) (User, string, error) {
 
 	user := abUser.(User)
 
-	secret := user.GetTOTPSecretKey()
-	if len(secret) == 0 {
+	if len(user.GetTOTPSecretKey()) == 0 {
 		return user, "", errNoTOTPEnabled
 	}
+	secret, err := t.LoadSecret(r.Context(), user, authboss.AttributeTOTPSecretKey, user.GetTOTPSecretKey(), user.PutTOTPSecretKey)
+	if err != nil {
+		return nil, "", err
+	}
 
 	validator, err := t.Authboss.Config.Core.BodyReader.Read(PageTOTPValidate, r)
 	if err != nil {
@@ -460,7 +534,7 @@ func (t *TOTP) validate(r *http.Request) (User, string, error) {
 		if ok {
 			logger.Infof("user %s used recovery code instead of sms2fa", user.GetPID())
 			user.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(recoveryCodes))
-			if err := t.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+			if err := t.Authboss.SaveUser(r.Context(), user); err != nil {
 				return nil, "", err
 			}
 		}
@@ -478,7 +552,7 @@ func (t *TOTP) validate(r *http.Request) (User, string, error) {
 		oneTime.PutTOTPLastCode(input)
 	}
 
-	if !totp.Validate(input, secret) {
+	if !t.validateCode(input, secret) {
 		return user, validationErrInvalidCode, nil
 	}
 
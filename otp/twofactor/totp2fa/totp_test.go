@@ -13,6 +13,7 @@ import (
 
 	"github.com/pquerna/otp/totp"
 	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/defaults"
 	"github.com/volatiletech/authboss/v3/mocks"
 )
 
@@ -538,6 +539,42 @@ func TestPostValidate(t *testing.T) {
 		}
 	})
 
+	t.Run("OkEncryptsSecret", func(t *testing.T) {
+		h := testSetup()
+		encryptor, err := defaults.NewAESSecretEncryptor(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ab.Config.Core.SecretEncryptor = encryptor
+
+		r, w, _ := h.newHTTP("POST")
+		user := setupMore(h)
+		secret := makeSecretKey(h, user.Email)
+		user.TOTPSecretKey = secret
+
+		code, err := totp.GenerateCode(secret, h.ab.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.bodyReader.Return = mocks.Values{Code: code}
+		h.setSession(SessionTOTPPendingPID, user.Email)
+		h.loadClientState(w, &r)
+
+		if err := h.totp.PostValidate(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.redirector.Options.RedirectPath != h.ab.Paths.AuthLoginOK {
+			t.Error("the code should have been accepted:", h.redirector.Options.RedirectPath)
+		}
+		if user.TOTPSecretKey == secret {
+			t.Error("the plaintext secret should have been encrypted")
+		}
+		if got, _, err := encryptor.DecryptSecret(context.Background(), authboss.AttributeTOTPSecretKey, user.TOTPSecretKey); err != nil || got != secret {
+			t.Error("the secret was wrong:", got, err)
+		}
+	})
+
 	t.Run("OkRecovery", func(t *testing.T) {
 		h := testSetup()
 
@@ -74,7 +74,7 @@ func (rc *Recovery) PostRegen(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	user.PutRecoveryCodes(EncodeRecoveryCodes(hashedCodes))
-	if err = rc.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
+	if err = rc.Authboss.SaveUser(r.Context(), user); err != nil {
 		return err
 	}
 
@@ -57,7 +57,7 @@ func SetupEmailVerify(ab *authboss.Authboss, twofactorKind, setupURL string) (Em
 	default:
 		return e, errors.New("MailRouteMethod must be set to something in the config")
 	}
-	routerMethod("/2fa/"+twofactorKind+"/email/verify/end", middleware(ab.Core.ErrorHandler.Wrap(e.End)))
+	routerMethod("/2fa/"+twofactorKind+"/email/verify/end", authboss.CSRFExempt(middleware(ab.Core.ErrorHandler.Wrap(e.End))))
 
 	if err := e.Authboss.Core.ViewRenderer.Load(PageVerify2FA); err != nil {
 		return e, err
@@ -104,13 +104,13 @@ func (e EmailVerify) PostStart(w http.ResponseWriter, r *http.Request) error {
 	if e.Authboss.Config.Modules.MailNoGoroutine {
 		e.SendVerifyEmail(ctx, user.GetEmail(), token)
 	} else {
-		go e.SendVerifyEmail(ctx, user.GetEmail(), token)
+		e.Authboss.Background(func() { e.SendVerifyEmail(ctx, user.GetEmail(), token) })
 	}
 
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: e.Authboss.Config.Paths.TwoFactorEmailAuthNotOK,
-		Success:      "An e-mail has been sent to confirm 2FA activation.",
+		RedirectPath: e.Authboss.RedirectPath(r, "twofactor", authboss.RedirectNotOK, user, e.Authboss.RequestConfig(r.Context()).Paths.TwoFactorEmailAuthNotOK),
+		Success:      e.Authboss.Localize(ctx, authboss.Txt2FAEmailSent),
 	}
 	return e.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
@@ -119,13 +119,14 @@ func (e EmailVerify) PostStart(w http.ResponseWriter, r *http.Request) error {
 func (e EmailVerify) SendVerifyEmail(ctx context.Context, to, token string) {
 	logger := e.Authboss.Logger(ctx)
 
-	mailURL := e.mailURL(token)
+	mailURL := e.mailURL(ctx, token)
 
+	mail := e.RequestConfig(ctx).Mail
 	email := authboss.Email{
 		To:       []string{to},
-		From:     e.Config.Mail.From,
-		FromName: e.Config.Mail.FromName,
-		Subject:  e.Config.Mail.SubjectPrefix + "Add 2FA to Account",
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + e.Authboss.Localize(ctx, authboss.Txt2FAEmailSubject),
 	}
 
 	logger.Infof("sending add 2fa verification e-mail to: %s", to)
@@ -134,23 +135,25 @@ func (e EmailVerify) SendVerifyEmail(ctx context.Context, to, token string) {
 		Data:         authboss.NewHTMLData(DataVerifyURL, mailURL),
 		HTMLTemplate: EmailVerifyHTML,
 		TextTemplate: EmailVerifyTxt,
+		Module:       "twofactor",
 	}
 	if err := e.Authboss.Email(ctx, email, ro); err != nil {
 		logger.Errorf("failed to send 2fa verification e-mail to %s: %+v", to, err)
 	}
 }
 
-func (e EmailVerify) mailURL(token string) string {
+func (e EmailVerify) mailURL(ctx context.Context, token string) string {
+	config := e.RequestConfig(ctx)
 	query := url.Values{FormValueToken: []string{token}}
 
-	if len(e.Config.Mail.RootURL) != 0 {
+	if len(config.Mail.RootURL) != 0 {
 		return fmt.Sprintf("%s?%s",
-			e.Config.Mail.RootURL+"/2fa/"+e.TwofactorKind+"/email/verify/end",
+			config.Mail.RootURL+"/2fa/"+e.TwofactorKind+"/email/verify/end",
 			query.Encode())
 	}
 
-	p := path.Join(e.Config.Paths.Mount, "/2fa/"+e.TwofactorKind+"/email/verify/end")
-	return fmt.Sprintf("%s%s?%s", e.Config.Paths.RootURL, p, query.Encode())
+	p := path.Join(config.Paths.Mount, "/2fa/"+e.TwofactorKind+"/email/verify/end")
+	return fmt.Sprintf("%s%s?%s", config.Paths.RootURL, p, query.Encode())
 }
 
 // End confirms the token passed in by the user (by the link in the e-mail)
@@ -168,8 +171,9 @@ func (e EmailVerify) End(w http.ResponseWriter, r *http.Request) error {
 	if 1 != subtle.ConstantTimeCompare([]byte(wantToken), []byte(givenToken)) {
 		ro := authboss.RedirectOptions{
 			Code:         http.StatusTemporaryRedirect,
-			Failure:      "invalid 2fa e-mail verification token",
-			RedirectPath: e.Authboss.Config.Paths.TwoFactorEmailAuthNotOK,
+			Failure:      e.Authboss.Localize(r.Context(), authboss.Txt2FAEmailInvalid),
+			FailureCode:  authboss.ErrorCodeInvalidToken,
+			RedirectPath: e.Authboss.RedirectPath(r, "twofactor", authboss.RedirectNotOK, nil, e.Authboss.RequestConfig(r.Context()).Paths.TwoFactorEmailAuthNotOK),
 		}
 		return e.Authboss.Core.Redirector.Redirect(w, r, ro)
 	}
@@ -203,7 +207,8 @@ func (e EmailVerify) Wrap(handler http.Handler) http.Handler {
 		redirURL := path.Join(e.Authboss.Config.Paths.Mount, "2fa", e.TwofactorKind, "email/verify")
 		ro := authboss.RedirectOptions{
 			Code:         http.StatusTemporaryRedirect,
-			Failure:      "You must first authorize adding 2fa by e-mail.",
+			Failure:      e.Authboss.Localize(e.Authboss.LocaleContext(r), authboss.Txt2FAEmailRequired),
+			FailureCode:  authboss.ErrorCodeUnconfirmed,
 			RedirectPath: redirURL,
 		}
 
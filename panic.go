@@ -0,0 +1,124 @@
+package authboss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// PanicError is what a panic in a module's handler is turned into, it's
+// returned to the ErrorHandler and is in the context of EventPanic under
+// CTXKeyPanic. Formatted with %+v it has the stack.
+type PanicError struct {
+	Module string
+	// Value is what was passed to panic
+	Value interface{}
+	// Stack is the goroutine's stack where it panicked
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s handler: %v", p.Module, p.Value)
+}
+
+// Unwrap is the value that was passed to panic if it's an error
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// Format adds the stack for %+v
+func (p *PanicError) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		_, _ = io.WriteString(s, p.Error()+"\n"+string(p.Stack))
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", p.Error())
+	default:
+		_, _ = io.WriteString(s, p.Error())
+	}
+}
+
+// recoverPanics turns panics in the handler into a PanicError, the request
+// is responded to with a 500 unless the handler or an EventPanic handler
+// already responded. http.ErrAbortHandler is panicked again since it's
+// meant to abort the request.
+func (a *Authboss) recoverPanics(module string, handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) (err error) {
+		pw := &panicResponseWriter{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			perr := &PanicError{Module: module, Value: p, Stack: debug.Stack()}
+			r = r.WithContext(context.WithValue(r.Context(), CTXKeyPanic, perr))
+
+			handled, eventErr := a.Events.FireAfter(EventPanic, pw, r)
+			if eventErr != nil {
+				a.RequestLogger(r).Errorf("EventPanic handler failed: %+v", eventErr)
+			}
+			if !handled && !pw.wrote {
+				a.respondPanic(pw, r)
+			}
+			err = perr
+		}()
+
+		return handler(pw, r)
+	}
+}
+
+// respondPanic responds with a 500, API requests get it from the Responder
+// with ErrorCodeInternal
+func (a *Authboss) respondPanic(w http.ResponseWriter, r *http.Request) {
+	if a.Config.Core.NoRender || wantsJSON(r) {
+		data := HTMLData{
+			DataErr:     a.Localize(a.LocaleContext(r), TxtInternalError),
+			DataErrCode: ErrorCodeInternal,
+		}
+		err := a.Config.Core.Responder.Respond(w, r, http.StatusInternalServerError, "error", data)
+		if err == nil {
+			return
+		}
+		a.RequestLogger(r).Errorf("failed to respond to panic: %+v", err)
+	}
+
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// wantsJSON reports whether the request is from an API client: it posted
+// json, asked for it or is an XMLHttpRequest
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+// panicResponseWriter knows whether the response was started before the
+// handler panicked
+type panicResponseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (p *panicResponseWriter) WriteHeader(code int) {
+	p.wrote = true
+	p.ResponseWriter.WriteHeader(code)
+}
+
+func (p *panicResponseWriter) Write(b []byte) (int, error) {
+	p.wrote = true
+	return p.ResponseWriter.Write(b)
+}
+
+// UnderlyingResponseWriter for MustClientStateResponseWriter
+func (p *panicResponseWriter) UnderlyingResponseWriter() http.ResponseWriter {
+	return p.ResponseWriter
+}
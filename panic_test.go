@@ -0,0 +1,113 @@
+package authboss
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testPanicResponder struct {
+	code int
+	data HTMLData
+}
+
+func (t *testPanicResponder) Respond(w http.ResponseWriter, r *http.Request, code int, templateName string, data HTMLData) error {
+	t.code, t.data = code, data
+	w.WriteHeader(code)
+	return nil
+}
+
+type testErrorHandler struct {
+	err *error
+}
+
+func (t testErrorHandler) Wrap(handler func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*t.err = handler(w, r)
+	})
+}
+
+func TestRecoverPanics(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Logger = mockLogger{}
+	responder := &testPanicResponder{}
+	ab.Config.Core.Responder = responder
+
+	var err error
+	handler := moduleErrorHandler{ErrorHandler: testErrorHandler{err: &err}, ab: ab, module: "auth"}.Wrap(
+		func(w http.ResponseWriter, r *http.Request) error {
+			panic(ErrUserNotFound)
+		},
+	)
+
+	var eventPanic *PanicError
+	ab.Events.After(EventPanic, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		eventPanic, _ = r.Context().Value(CTXKeyPanic).(*PanicError)
+		return false, nil
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "Internal Server Error") {
+		t.Error("pages should get a plain 500:", rec.Code, rec.Body.String())
+	}
+
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr.Module != "auth" || !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("the error handler should get the panic: %#v", err)
+	}
+	if eventPanic != perr {
+		t.Error("the event should have the panic in its context")
+	}
+	if formatted := fmt.Sprintf("%+v", perr); !strings.Contains(formatted, "panic in auth handler: user not found\n") || !strings.Contains(formatted, "panic_test.go") {
+		t.Error("the stack should be formatted:", formatted)
+	}
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.Header.Set("Accept", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if responder.code != http.StatusInternalServerError || responder.data[DataErrCode] != ErrorCodeInternal {
+		t.Error("api requests should get the error from the responder:", responder.code, responder.data)
+	}
+}
+
+func TestRecoverPanicsResponded(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Logger = mockLogger{}
+
+	var err error
+	handler := moduleErrorHandler{ErrorHandler: testErrorHandler{err: &err}, ab: ab, module: "auth"}.Wrap(
+		func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusAccepted)
+			panic("half way")
+		},
+	)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Error("a response that was started should be left alone:", rec.Code)
+	}
+	if err == nil {
+		t.Error("the panic should still be returned")
+	}
+
+	handler = moduleErrorHandler{ErrorHandler: testErrorHandler{err: &err}, ab: ab, module: "auth"}.Wrap(
+		func(w http.ResponseWriter, r *http.Request) error {
+			panic(http.ErrAbortHandler)
+		},
+	)
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Error("ErrAbortHandler should abort the request:", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
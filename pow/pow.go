@@ -0,0 +1,191 @@
+// Package pow implements a proof-of-work authboss.ChallengeVerifier, a
+// CAPTCHA alternative that makes each login or recover attempt cost the
+// client some cpu time without any interaction from the user.
+//
+// The client is given a Challenge and must find a counter such that the
+// sha256 hash of "<token>:<counter>" starts with Difficulty zero bits, the
+// solution it submits is "<token>:<counter>" (see Solve).
+package pow
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	// DefaultDifficulty takes about a quarter of a second of hashing in a
+	// browser
+	DefaultDifficulty = 18
+	// DefaultLifetime is how long a challenge can be solved for
+	DefaultLifetime = 5 * time.Minute
+
+	nNonceSize = 16
+)
+
+// Challenge is rendered to the client to solve
+type Challenge struct {
+	Token      string `json:"token"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// Verifier issues and verifies proof-of-work challenges. Challenges are
+// signed so nothing needs to be stored to verify them, solved challenges
+// are remembered in memory until they expire to prevent them from being
+// used twice, which means that behind a load balancer a solution could be
+// replayed once per server.
+type Verifier struct {
+	// Key signs the challenges, it should be random and at least 32 bytes
+	Key []byte
+	// Difficulty is the number of leading zero bits required, every extra
+	// bit doubles the work.
+	Difficulty int
+	// Lifetime of a challenge
+	Lifetime time.Duration
+	// Now tells the time, it's time.Now if it's nil. authboss.Init sets it
+	// to Authboss.Now, see ConfigureClock.
+	Now func() time.Time
+
+	mut  sync.Mutex
+	used map[string]time.Time
+}
+
+// NewVerifier creates a verifier with the default lifetime
+func NewVerifier(key []byte, difficulty int) *Verifier {
+	return &Verifier{
+		Key:        key,
+		Difficulty: difficulty,
+		Lifetime:   DefaultLifetime,
+	}
+}
+
+// ConfigureClock sets Now
+func (v *Verifier) ConfigureClock(now func() time.Time) {
+	v.Now = now
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now == nil {
+		return time.Now()
+	}
+	return v.Now()
+}
+
+// NewChallenge creates a challenge for the page
+func (v *Verifier) NewChallenge(ctx context.Context, page string) (interface{}, error) {
+	nonce := make([]byte, nNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to create challenge nonce")
+	}
+
+	expires := v.now().Add(v.Lifetime).Unix()
+	payload := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString(nonce),
+		strconv.FormatInt(expires, 10),
+		strconv.Itoa(v.Difficulty),
+	}, ".")
+
+	return Challenge{
+		Token:      payload + "." + v.sign(page, payload),
+		Difficulty: v.Difficulty,
+	}, nil
+}
+
+// VerifyChallenge checks the solution was found for a challenge issued for
+// the page that hasn't expired or been used before.
+func (v *Verifier) VerifyChallenge(ctx context.Context, page, solution string) (bool, error) {
+	colon := strings.LastIndexByte(solution, ':')
+	if colon < 0 {
+		return false, nil
+	}
+	token := solution[:colon]
+
+	dot := strings.LastIndexByte(token, '.')
+	if dot < 0 {
+		return false, nil
+	}
+	payload, signature := token[:dot], token[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(v.sign(page, payload))) {
+		return false, nil
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return false, nil
+	}
+	expiresUnix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return false, nil
+	}
+
+	expires := time.Unix(expiresUnix, 0)
+	now := v.now()
+	if !now.Before(expires) || leadingZeros(solution) < difficulty {
+		return false, nil
+	}
+
+	v.mut.Lock()
+	defer v.mut.Unlock()
+
+	if v.used == nil {
+		v.used = make(map[string]time.Time)
+	}
+	for t, exp := range v.used {
+		if !now.Before(exp) {
+			delete(v.used, t)
+		}
+	}
+	if _, ok := v.used[token]; ok {
+		return false, nil
+	}
+	v.used[token] = expires
+
+	return true, nil
+}
+
+// sign the payload, the page is included so that a challenge issued for
+// one page can't be used on another.
+func (v *Verifier) sign(page, payload string) string {
+	mac := hmac.New(sha256.New, v.Key)
+	_, _ = io.WriteString(mac, page+"."+payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Solve finds a solution to the challenge, it's what clients have to do
+// and is useful for testing and Go clients.
+func Solve(c Challenge) string {
+	for counter := 0; ; counter++ {
+		solution := c.Token + ":" + strconv.Itoa(counter)
+		if leadingZeros(solution) >= c.Difficulty {
+			return solution
+		}
+	}
+}
+
+func leadingZeros(solution string) int {
+	sum := sha256.Sum256([]byte(solution))
+
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+
+	return zeros
+}
@@ -0,0 +1,95 @@
+package pow
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newChallenge(t *testing.T, v *Verifier, page string) Challenge {
+	t.Helper()
+
+	c, err := v.NewChallenge(context.Background(), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(Challenge)
+}
+
+func verify(t *testing.T, v *Verifier, page, solution string) bool {
+	t.Helper()
+
+	ok, err := v.VerifyChallenge(context.Background(), page, solution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestVerifier(t *testing.T) {
+	t.Parallel()
+
+	v := NewVerifier([]byte("key"), 8)
+	c := newChallenge(t, v, "login")
+
+	if c.Difficulty != 8 {
+		t.Error("difficulty was wrong:", c.Difficulty)
+	}
+
+	solution := Solve(c)
+	if !verify(t, v, "login", solution) {
+		t.Error("the solution should be accepted")
+	}
+	if verify(t, v, "login", solution) {
+		t.Error("a solution should only be accepted once")
+	}
+}
+
+func TestVerifierRejects(t *testing.T) {
+	t.Parallel()
+
+	v := NewVerifier([]byte("key"), 8)
+
+	solution := Solve(newChallenge(t, v, "login"))
+	if verify(t, v, "recover_start", solution) {
+		t.Error("a challenge for another page should be rejected")
+	}
+	if verify(t, NewVerifier([]byte("other"), 8), "login", solution) {
+		t.Error("a challenge signed with another key should be rejected")
+	}
+
+	c := newChallenge(t, v, "login")
+	counter := 0
+	for leadingZeros(c.Token+":"+strconv.Itoa(counter)) >= c.Difficulty {
+		counter++
+	}
+	if verify(t, v, "login", c.Token+":"+strconv.Itoa(counter)) {
+		t.Error("an unsolved challenge should be rejected")
+	}
+
+	for _, garbage := range []string{"", "nocolon", "no.dots:1", c.Token[1:] + ":1"} {
+		if verify(t, v, "login", garbage) {
+			t.Errorf("%q should be rejected", garbage)
+		}
+	}
+
+	v.Lifetime = -time.Second
+	if verify(t, v, "login", Solve(newChallenge(t, v, "login"))) {
+		t.Error("an expired challenge should be rejected")
+	}
+}
+
+func TestVerifierClock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVerifier([]byte("key"), 8)
+	v.ConfigureClock(func() time.Time { return now })
+
+	solution := Solve(newChallenge(t, v, "login"))
+	now = now.Add(DefaultLifetime)
+	if verify(t, v, "login", solution) {
+		t.Error("a challenge should expire by the verifier's clock")
+	}
+}
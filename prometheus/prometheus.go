@@ -0,0 +1,240 @@
+// Package prometheus implements authboss.Metrics and serves the
+// measurements in the Prometheus text exposition format, so that
+// operators can graph and alert on logins, lockouts and the like without
+// authboss depending on the Prometheus client library.
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// ContentType of the exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of the histogram buckets in seconds,
+// the same as the Prometheus client's defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// help is the description of authboss' metrics
+var help = map[string]string{
+	authboss.GaugeActiveSessions:         "Number of sessions in the session store.",
+	authboss.GaugeRememberTokens:         "Number of remember me tokens stored.",
+	authboss.GaugeExpiredTokens:          "Number of expired tokens that have not been pruned.",
+	authboss.GaugeMailQueueDepth:         "Number of e-mails waiting to be sent.",
+	authboss.CounterLogins:               "Logins by module and result.",
+	authboss.CounterTwoFactorValidations: "Second factor codes checked when logging in by module and result.",
+	authboss.CounterRegistrations:        "Users that registered.",
+	authboss.CounterLockouts:             "Users locked after too many failed logins.",
+	authboss.CounterEmails:               "E-mails sent by result.",
+	authboss.HistogramLoginDuration:      "Seconds spent checking credentials by module.",
+}
+
+type kind string
+
+const (
+	kindGauge     kind = "gauge"
+	kindCounter   kind = "counter"
+	kindHistogram kind = "histogram"
+)
+
+type family struct {
+	kind   kind
+	series map[string]*series
+}
+
+type series struct {
+	labels [][2]string
+
+	// value of a gauge or counter
+	value float64
+
+	// buckets, sum and count of a histogram
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Metrics keeps authboss' measurements in memory, it's safe for
+// concurrent use. Set it as Config.Core.Metrics and serve Handler on the
+// path Prometheus scrapes.
+type Metrics struct {
+	// Buckets are the upper bounds of the histogram buckets, they must be
+	// sorted and can't be changed once something has been observed.
+	Buckets []float64
+
+	mut      sync.Mutex
+	families map[string]*family
+}
+
+// New creates metrics with the DefaultBuckets
+func New() *Metrics {
+	return &Metrics{Buckets: DefaultBuckets}
+}
+
+// Gauge sets the current value of the named gauge
+func (m *Metrics) Gauge(name string, value float64) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.series(name, kindGauge, nil).value = value
+}
+
+// Count adds one to the named counter
+func (m *Metrics) Count(name string, labels authboss.Labels) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.series(name, kindCounter, labels).value++
+}
+
+// Observe adds the value to the named histogram
+func (m *Metrics) Observe(name string, value float64, labels authboss.Labels) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	s := m.series(name, kindHistogram, labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(m.Buckets))
+	}
+	for i, upper := range m.Buckets {
+		if value <= upper {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// series finds or creates the series, the lock must be held
+func (m *Metrics) series(name string, k kind, labels authboss.Labels) *series {
+	if m.families == nil {
+		m.families = make(map[string]*family)
+	}
+
+	f, ok := m.families[name]
+	if !ok {
+		f = &family{kind: k, series: make(map[string]*series)}
+		m.families[name] = f
+	}
+
+	pairs := make([][2]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, [2]string{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+	key := formatLabels(pairs)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: pairs}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Write the metrics to w in the text exposition format
+func (m *Metrics) Write(w io.Writer) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		f := m.families[name]
+		if h, ok := help[name]; ok {
+			buf.WriteString("# HELP " + name + " " + h + "\n")
+		}
+		buf.WriteString("# TYPE " + name + " " + string(f.kind) + "\n")
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != kindHistogram {
+				writeSample(buf, name, key, s.value)
+				continue
+			}
+
+			for i, upper := range m.Buckets {
+				le := append(s.labels[:len(s.labels):len(s.labels)], [2]string{"le", formatFloat(upper)})
+				writeSample(buf, name+"_bucket", formatLabels(le), float64(s.buckets[i]))
+			}
+			le := append(s.labels[:len(s.labels):len(s.labels)], [2]string{"le", "+Inf"})
+			writeSample(buf, name+"_bucket", formatLabels(le), float64(s.count))
+			writeSample(buf, name+"_sum", key, s.sum)
+			writeSample(buf, name+"_count", key, float64(s.count))
+		}
+	}
+
+	return buf.Flush()
+}
+
+// Handler serves the metrics for Prometheus to scrape. If ab is not nil
+// its gauges are collected with CollectMetrics first.
+func (m *Metrics) Handler(ab *authboss.Authboss) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ab != nil {
+			if err := ab.CollectMetrics(r.Context()); err != nil {
+				ab.RequestLogger(r).Errorf("failed to collect metrics: %+v", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", ContentType)
+		if err := m.Write(w); err != nil && ab != nil {
+			ab.RequestLogger(r).Errorf("failed to write metrics: %+v", err)
+		}
+	})
+}
+
+func writeSample(buf *bufio.Writer, name, labels string, value float64) {
+	buf.WriteString(name + labels + " " + formatFloat(value) + "\n")
+}
+
+func formatLabels(pairs [][2]string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, p := range pairs {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(p[0] + `="` + labelEscaper.Replace(p[1]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,67 @@
+package prometheus
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.Buckets = []float64{0.1, 1}
+
+	m.Gauge(authboss.GaugeActiveSessions, 3)
+	m.Count(authboss.CounterLogins, authboss.Labels{authboss.LabelResult: "success", authboss.LabelModule: "auth"})
+	m.Count(authboss.CounterLogins, authboss.Labels{authboss.LabelResult: "success", authboss.LabelModule: "auth"})
+	m.Count(authboss.CounterLogins, authboss.Labels{authboss.LabelResult: "failure", authboss.LabelModule: "auth"})
+	m.Count("custom", authboss.Labels{"name": "a \"quoted\"\nvalue"})
+	m.Observe(authboss.HistogramLoginDuration, 0.05, authboss.Labels{authboss.LabelModule: "auth"})
+	m.Observe(authboss.HistogramLoginDuration, 0.5, authboss.Labels{authboss.LabelModule: "auth"})
+
+	buf := &bytes.Buffer{}
+	if err := m.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP authboss_active_sessions Number of sessions in the session store.
+# TYPE authboss_active_sessions gauge
+authboss_active_sessions 3
+# HELP authboss_login_duration_seconds Seconds spent checking credentials by module.
+# TYPE authboss_login_duration_seconds histogram
+authboss_login_duration_seconds_bucket{module="auth",le="0.1"} 1
+authboss_login_duration_seconds_bucket{module="auth",le="1"} 2
+authboss_login_duration_seconds_bucket{module="auth",le="+Inf"} 2
+authboss_login_duration_seconds_sum{module="auth"} 0.55
+authboss_login_duration_seconds_count{module="auth"} 2
+# HELP authboss_logins_total Logins by module and result.
+# TYPE authboss_logins_total counter
+authboss_logins_total{module="auth",result="failure"} 1
+authboss_logins_total{module="auth",result="success"} 2
+# TYPE custom counter
+custom{name="a \"quoted\"\nvalue"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output was wrong, want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+	m.Count(authboss.CounterLockouts, nil)
+
+	w := httptest.NewRecorder()
+	m.Handler(nil).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Error("content type was wrong:", ct)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte("authboss_lockouts_total 1\n")) {
+		t.Error("lockouts were not written:", w.Body.String())
+	}
+}
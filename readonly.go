@@ -0,0 +1,69 @@
+package authboss
+
+import (
+	"net/http"
+	"path"
+)
+
+// ReadOnlyMessage is the failure shown to users whose request was rejected
+// because Config.Storage.ReadOnly is set, unless the Localizer translates
+// TxtReadOnly.
+var ReadOnlyMessage = "This is temporarily unavailable, please try again later."
+
+// readOnlyRouter guards every POST and DELETE route except logout, which
+// only deletes client state. The rejected requests are redirected back to
+// the route's page.
+type readOnlyRouter struct {
+	Router
+	ab *Authboss
+}
+
+func (r readOnlyRouter) Post(p string, handler http.Handler) {
+	r.Router.Post(p, r.guard(p, handler))
+}
+
+func (r readOnlyRouter) Delete(p string, handler http.Handler) {
+	r.Router.Delete(p, r.guard(p, handler))
+}
+
+func (r readOnlyRouter) Routes() []string {
+	if lister, ok := r.Router.(RouteLister); ok {
+		return lister.Routes()
+	}
+	return nil
+}
+
+func (r readOnlyRouter) guard(p string, handler http.Handler) http.Handler {
+	if p == "/logout" {
+		return handler
+	}
+
+	return r.ab.ReadOnlyGuard(path.Join("/", r.ab.Config.Paths.Mount, p), handler)
+}
+
+// ReadOnlyGuard rejects every request to the handler with ReadOnlyMessage,
+// redirecting to redirectPath, if Config.Storage.ReadOnly is set when this
+// is called. Otherwise the handler is returned as is.
+//
+// Routes other than GET routes are guarded by Init, modules that write to
+// the storer from a GET route have to guard it themselves.
+func (a *Authboss) ReadOnlyGuard(redirectPath string, handler http.Handler) http.Handler {
+	if !a.Config.Storage.ReadOnly {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := a.RequestLogger(r)
+		logger.Infof("rejected %s %s in read-only mode", r.Method, r.URL.Path)
+
+		ro := RedirectOptions{
+			Code:         http.StatusServiceUnavailable,
+			RedirectPath: redirectPath,
+			Failure:      a.Localize(a.LocaleContext(r), LocalizationKey{TxtReadOnly.ID, ReadOnlyMessage}),
+			FailureCode:  ErrorCodeReadOnly,
+		}
+		if err := a.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+			logger.Errorf("failed to redirect in read-only mode: %+v", err)
+		}
+	})
+}
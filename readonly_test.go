@@ -0,0 +1,63 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testRouter map[string]http.Handler
+
+func (t testRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t[r.Method+" "+r.URL.Path].ServeHTTP(w, r)
+}
+func (t testRouter) Get(path string, handler http.Handler)    { t["GET "+path] = handler }
+func (t testRouter) Post(path string, handler http.Handler)   { t["POST "+path] = handler }
+func (t testRouter) Delete(path string, handler http.Handler) { t["DELETE "+path] = handler }
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	redirector := &testRedirector{}
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.Redirector = redirector
+	ab.Config.Core.Router = testRouter{}
+	ab.Config.Paths.Mount = "/auth"
+	ab.Config.Storage.ReadOnly = true
+	// Logout isn't guarded by read-only mode but it is by the csrf token
+	ab.Config.Modules.CSRFDisabled = true
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	called := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })
+	ab.Config.Core.Router.Get("/login", handler)
+	ab.Config.Core.Router.Post("/login", handler)
+	ab.Config.Core.Router.Delete("/logout", handler)
+
+	for _, method := range []string{"GET", "DELETE"} {
+		path := "/login"
+		if method == "DELETE" {
+			path = "/logout"
+		}
+		ab.Config.Core.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+	if called != 2 {
+		t.Error("get routes and logout should not be guarded")
+	}
+
+	rec := httptest.NewRecorder()
+	ab.Config.Core.Router.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+	if called != 2 {
+		t.Error("the post route should have been rejected")
+	}
+	if redirector.Opts.RedirectPath != "/auth/login" || redirector.Opts.Failure != ReadOnlyMessage {
+		t.Errorf("redirect was wrong: %#v", redirector.Opts)
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Error("code was wrong:", rec.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package recover
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// PhoneVerifier is an authboss.RecoverVerifier that asks users for the
+// last digits of the phone number they set up sms 2fa with. Users without
+// a phone number (or that aren't sms2fa.Users) aren't asked anything.
+type PhoneVerifier struct {
+	// Digits is how many of the last digits are asked for, 4 if it's 0
+	Digits int
+	// Authboss decrypts the phone numbers with Config.Core.SecretEncryptor,
+	// it must be set when there is one (see NewPhoneVerifier)
+	Authboss *authboss.Authboss
+}
+
+// NewPhoneVerifier creates a PhoneVerifier asking for the last digits of
+// the phone number, decrypting it with ab's Config.Core.SecretEncryptor.
+func NewPhoneVerifier(ab *authboss.Authboss, digits int) PhoneVerifier {
+	return PhoneVerifier{Digits: digits, Authboss: ab}
+}
+
+// PhonePrompt is the prompt of the PhoneVerifier
+type PhonePrompt struct {
+	// Digits is how many of the last digits of their phone number the user
+	// must enter
+	Digits int
+}
+
+type phoneUser interface {
+	GetSMSPhoneNumber() string
+}
+
+// RecoverPrompt asks for the last digits of the user's phone number
+func (p PhoneVerifier) RecoverPrompt(ctx context.Context, user authboss.RecoverableUser) (interface{}, error) {
+	digits, err := p.lastDigits(ctx, user)
+	if err != nil || len(digits) == 0 {
+		return nil, err
+	}
+
+	return PhonePrompt{Digits: p.digits()}, nil
+}
+
+// VerifyRecover checks the answer is the last digits of the user's phone
+// number, anything but digits in the answer is ignored
+func (p PhoneVerifier) VerifyRecover(ctx context.Context, user authboss.RecoverableUser, answer string) (bool, error) {
+	want, err := p.lastDigits(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	got := onlyDigits(answer)
+
+	return len(want) != 0 && subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1, nil
+}
+
+func (p PhoneVerifier) digits() int {
+	if p.Digits == 0 {
+		return 4
+	}
+	return p.Digits
+}
+
+// lastDigits of the user's phone number, it's empty if they have no phone
+// number with enough digits
+func (p PhoneVerifier) lastDigits(ctx context.Context, user authboss.RecoverableUser) (string, error) {
+	pu, ok := user.(phoneUser)
+	if !ok {
+		return "", nil
+	}
+
+	number := pu.GetSMSPhoneNumber()
+	if p.Authboss != nil {
+		var err error
+		if number, _, err = p.Authboss.DecryptSecret(ctx, authboss.AttributeSMSPhoneNumber, number); err != nil {
+			return "", err
+		}
+	}
+
+	number = onlyDigits(number)
+	if len(number) < p.digits() {
+		return "", nil
+	}
+	return number[len(number)-p.digits():], nil
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, s)
+}
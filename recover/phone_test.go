@@ -0,0 +1,79 @@
+package recover
+
+import (
+	"context"
+	"testing"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/defaults"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestPhoneVerifier(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	verifier := PhoneVerifier{Digits: 3}
+
+	if prompt, err := verifier.RecoverPrompt(ctx, &mocks.User{}); err != nil || prompt != nil {
+		t.Error("users without a phone number should not be asked:", prompt, err)
+	}
+
+	user := &mocks.User{SMSPhoneNumber: "+44 (20) 7946-0958"}
+	if prompt, err := verifier.RecoverPrompt(ctx, user); err != nil || prompt != (PhonePrompt{Digits: 3}) {
+		t.Error("prompt was wrong:", prompt, err)
+	}
+
+	answers := map[string]bool{
+		"958":  true,
+		"9-58": true,
+		"0958": false,
+		"959":  false,
+		"":     false,
+	}
+	for answer, want := range answers {
+		if ok, err := verifier.VerifyRecover(ctx, user, answer); err != nil || ok != want {
+			t.Errorf("%q: want %t, got %t (%v)", answer, want, ok, err)
+		}
+	}
+}
+
+func TestPhoneVerifierEncrypted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	encryptor, err := defaults.NewAESSecretEncryptor(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab := authboss.New()
+	ab.Config.Core.SecretEncryptor = encryptor
+
+	number, err := ab.EncryptSecret(ctx, authboss.AttributeSMSPhoneNumber, "+1 555 123 4567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &mocks.User{SMSPhoneNumber: number}
+
+	if ok, err := NewPhoneVerifier(ab, 4).VerifyRecover(ctx, user, "4567"); err != nil || !ok {
+		t.Error("the answer should be checked against the decrypted number:", ok, err)
+	}
+
+	ab.Config.Modules.RecoverVerifier = PhoneVerifier{}
+	if problems := (&Recover{}).ValidateConfig(ab); !hasProblem(problems, "Modules.RecoverVerifier") {
+		t.Error("a PhoneVerifier without the Authboss should be a problem:", problems)
+	}
+	ab.Config.Modules.RecoverVerifier = NewPhoneVerifier(ab, 4)
+	if problems := (&Recover{}).ValidateConfig(ab); hasProblem(problems, "Modules.RecoverVerifier") {
+		t.Error("a PhoneVerifier with the Authboss should not be a problem:", problems)
+	}
+}
+
+func hasProblem(problems []authboss.ConfigProblem, field string) bool {
+	for _, p := range problems {
+		if p.Field == field {
+			return true
+		}
+	}
+	return false
+}
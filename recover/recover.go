@@ -4,16 +4,13 @@ package recover
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
-	"time"
 
 	"github.com/volatiletech/authboss/v3"
 	"golang.org/x/crypto/bcrypt"
@@ -21,10 +18,12 @@ import (
 
 // Constants for templates etc.
 const (
-	DataRecoverToken = "recover_token"
-	DataRecoverURL   = "recover_url"
+	DataRecoverToken  = "recover_token"
+	DataRecoverURL    = "recover_url"
+	DataRecoverPrompt = "recover_prompt"
 
-	FormValueToken = "token"
+	FormValueToken  = "token"
+	FormValueAnswer = "answer"
 
 	EmailRecoverHTML = "recover_html"
 	EmailRecoverTxt  = "recover_txt"
@@ -32,11 +31,6 @@ const (
 	PageRecoverStart  = "recover_start"
 	PageRecoverMiddle = "recover_middle"
 	PageRecoverEnd    = "recover_end"
-
-	recoverInitiateSuccessFlash = "An email has been sent to you with further instructions on how to reset your password."
-
-	recoverTokenSize  = 64
-	recoverTokenSplit = recoverTokenSize / 2
 )
 
 func init() {
@@ -49,6 +43,20 @@ type Recover struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what recovering needs
+func (r *Recover) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Core.MailRenderer", "Core.Mailer", "Storage.Server")
+	problems = append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.RecoveringServerStorer)(nil))...)
+	if ab.Config.Modules.RecoverVerifier != nil {
+		problems = append(problems, ab.Config.Missing("Storage.Counter")...)
+	}
+	if p, ok := ab.Config.Modules.RecoverVerifier.(PhoneVerifier); ok && p.Authboss == nil && ab.Config.Core.SecretEncryptor != nil {
+		problems = append(problems, authboss.ConfigProblem{Field: "Modules.RecoverVerifier", Problem: "needs the Authboss to decrypt phone numbers with Core.SecretEncryptor, see NewPhoneVerifier"})
+	}
+	return append(problems, ab.Config.MissingLinkURL()...)
+}
+
 // Init module
 func (r *Recover) Init(ab *authboss.Authboss) (err error) {
 	r.Authboss = ab
@@ -61,17 +69,51 @@ func (r *Recover) Init(ab *authboss.Authboss) (err error) {
 		return err
 	}
 
-	r.Authboss.Config.Core.Router.Get("/recover", r.Core.ErrorHandler.Wrap(r.StartGet))
-	r.Authboss.Config.Core.Router.Post("/recover", r.Core.ErrorHandler.Wrap(r.StartPost))
-	r.Authboss.Config.Core.Router.Get("/recover/end", r.Core.ErrorHandler.Wrap(r.EndGet))
-	r.Authboss.Config.Core.Router.Post("/recover/end", r.Core.ErrorHandler.Wrap(r.EndPost))
+	r.Authboss.Config.Core.Router.Get("/recover", r.IPPolicyGuard("/recover", r.Core.ErrorHandler.Wrap(r.StartGet)))
+	r.Authboss.Config.Core.Router.Post("/recover", r.IPPolicyGuard("/recover", r.Core.ErrorHandler.Wrap(r.StartPost)))
+	r.Authboss.Config.Core.Router.Get("/recover/end", r.IPPolicyGuard("/recover/end", r.Core.ErrorHandler.Wrap(r.EndGet)))
+	r.Authboss.Config.Core.Router.Post("/recover/end", r.IPPolicyGuard("/recover/end", r.Core.ErrorHandler.Wrap(r.EndPost)))
 
 	return nil
 }
 
+// APIOperations documents the recover routes for authboss.OpenAPI
+func (r *Recover) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{
+		{
+			Method:     http.MethodPost,
+			Path:       "/recover",
+			Summary:    "E-mail a link to reset the password",
+			Fields:     []authboss.APIField{{Name: authboss.APIFieldPID, Required: true}},
+			Redirects:  true,
+			ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation, authboss.ErrorCodeChallengeRequired, authboss.ErrorCodeMailFailed},
+		},
+		{
+			Method:  http.MethodPost,
+			Path:    "/recover/end",
+			Summary: "Reset the password with the e-mailed token",
+			Fields: []authboss.APIField{
+				{Name: FormValueToken, Required: true},
+				{Name: "password", Required: true, Format: "password"},
+				{Name: authboss.ConfirmPrefix + "password", Required: true, Format: "password"},
+			},
+			Redirects:  true,
+			ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation},
+		},
+	}
+}
+
 // StartGet starts the recover procedure by rendering a form for the user.
 func (r *Recover) StartGet(w http.ResponseWriter, req *http.Request) error {
-	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, nil)
+	var data authboss.HTMLData
+	if r.Authboss.ChallengeRequired(req, PageRecoverStart) {
+		data = authboss.HTMLData{}
+		if err := r.Authboss.AddChallenge(req, PageRecoverStart, data); err != nil {
+			return err
+		}
+	}
+
+	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, data)
 }
 
 // StartPost starts the recover procedure using values provided from the user
@@ -86,19 +128,36 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 
 	if errs := validatable.Validate(); errs != nil {
 		logger.Info("recover validation failed")
-		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		data := authboss.HTMLData{authboss.DataValidation: r.LocalizeErrors(req.Context(), errs)}
+		if err := r.Authboss.AddChallenge(req, PageRecoverStart, data); err != nil {
+			return err
+		}
 		return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, data)
 	}
 
 	recoverVals := authboss.MustHaveRecoverStartValues(validatable)
 
-	user, err := r.Authboss.Storage.Server.Load(req.Context(), recoverVals.GetPID())
+	if ok, err := r.Authboss.VerifyChallenge(req, PageRecoverStart, validatable); err != nil {
+		return err
+	} else if !ok {
+		logger.Infof("recover challenge failed for pid: %s", recoverVals.GetPID())
+		data := authboss.HTMLData{
+			authboss.DataErr:     r.Localize(req.Context(), authboss.TxtCompleteChallenge),
+			authboss.DataErrCode: authboss.ErrorCodeChallengeRequired,
+		}
+		if err := r.Authboss.AddChallenge(req, PageRecoverStart, data); err != nil {
+			return err
+		}
+		return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, data)
+	}
+
+	user, err := r.Authboss.LoadByCredential(req.Context(), recoverVals.GetPID())
 	if err == authboss.ErrUserNotFound {
 		logger.Infof("user %s was attempted to be recovered, user does not exist, faking successful response", recoverVals.GetPID())
 		ro := authboss.RedirectOptions{
 			Code:         http.StatusTemporaryRedirect,
-			RedirectPath: r.Authboss.Config.Paths.RecoverOK,
-			Success:      recoverInitiateSuccessFlash,
+			RedirectPath: r.Authboss.RedirectPath(req, "recover", authboss.RedirectOK, nil, r.Authboss.RequestConfig(req.Context()).Paths.RecoverOK),
+			Success:      r.initiateFlash(req.Context()),
 		}
 		return r.Authboss.Core.Redirector.Redirect(w, req, ro)
 	}
@@ -113,23 +172,41 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 		return nil
 	}
 
-	selector, verifier, token, err := GenerateRecoverCreds()
+	selector, verifier, token, err := generateRecoverCreds(req.Context(), r.Authboss.TokenGenerator())
 	if err != nil {
 		return err
 	}
 
 	ru.PutRecoverSelector(selector)
 	ru.PutRecoverVerifier(verifier)
-	ru.PutRecoverExpiry(time.Now().UTC().Add(r.Config.Modules.RecoverTokenDuration))
+	ru.PutRecoverExpiry(r.Now().UTC().Add(r.Config.Modules.RecoverTokenDuration))
 
-	if err := r.Authboss.Storage.Server.Save(req.Context(), ru); err != nil {
+	if err := r.Authboss.SaveUser(req.Context(), ru); err != nil {
 		return err
 	}
 
-	if r.Authboss.Modules.MailNoGoroutine {
-		r.SendRecoverEmail(req.Context(), ru.GetEmail(), token)
-	} else {
-		go r.SendRecoverEmail(req.Context(), ru.GetEmail(), token)
+	to := ru.GetEmail()
+	if chooser, ok := validatable.(authboss.BackupEmailChooser); ok && chooser.GetUseBackupEmail() {
+		if bu, ok := user.(authboss.BackupEmailUser); ok && len(bu.GetBackupEmail()) != 0 {
+			logger.Infof("sending the recover e-mail of user %s to their backup address", ru.GetPID())
+			to = bu.GetBackupEmail()
+		}
+	}
+
+	err = r.Authboss.SendMail(req.Context(), func(ctx context.Context) error {
+		return r.sendRecoverEmail(ctx, to, token)
+	})
+	if _, ok := err.(authboss.MailError); ok {
+		data := authboss.HTMLData{
+			authboss.DataErr:     r.Localize(req.Context(), authboss.TxtRecoverMailFailed),
+			authboss.DataErrCode: authboss.ErrorCodeMailFailed,
+		}
+		if err := r.Authboss.AddChallenge(req, PageRecoverStart, data); err != nil {
+			return err
+		}
+		return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, data)
+	} else if err != nil {
+		return err
 	}
 
 	_, err = r.Authboss.Events.FireAfter(authboss.EventRecoverStart, w, req)
@@ -140,29 +217,46 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 	logger.Infof("user %s password recovery initiated", ru.GetPID())
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: r.Authboss.Config.Paths.RecoverOK,
-		Success:      recoverInitiateSuccessFlash,
+		RedirectPath: r.Authboss.RedirectPath(req, "recover", authboss.RedirectOK, nil, r.Authboss.RequestConfig(req.Context()).Paths.RecoverOK),
+		Success:      r.initiateFlash(req.Context()),
 	}
 	return r.Authboss.Core.Redirector.Redirect(w, req, ro)
 }
 
+// initiateFlash is the message shown once recovery has started, which
+// must not reveal whether the user exists when preventing user enumeration
+func (r *Recover) initiateFlash(ctx context.Context) string {
+	if r.Config.Modules.PreventUserEnumeration {
+		return r.Localize(ctx, authboss.TxtRecoverGeneric)
+	}
+	return r.Localize(ctx, authboss.TxtRecoverSent)
+}
+
 // SendRecoverEmail to a specific e-mail address passing along the encodedToken
 // in an escaped URL to the templates.
 func (r *Recover) SendRecoverEmail(ctx context.Context, to, encodedToken string) {
+	if err := r.sendRecoverEmail(ctx, to, encodedToken); err != nil {
+		r.Authboss.Logger(ctx).Errorf("%+v", err)
+	}
+}
+
+func (r *Recover) sendRecoverEmail(ctx context.Context, to, encodedToken string) error {
 	logger := r.Authboss.Logger(ctx)
 
-	mailURL := r.mailURL(encodedToken)
+	mailURL := r.mailURL(ctx, encodedToken)
 
+	mail := r.Authboss.RequestConfig(ctx).Mail
 	email := authboss.Email{
 		To:       []string{to},
-		From:     r.Authboss.Config.Mail.From,
-		FromName: r.Authboss.Config.Mail.FromName,
-		Subject:  r.Authboss.Config.Mail.SubjectPrefix + "Password Reset",
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + r.Localize(ctx, authboss.TxtRecoverSubject),
 	}
 
 	ro := authboss.EmailResponseOptions{
 		HTMLTemplate: EmailRecoverHTML,
 		TextTemplate: EmailRecoverTxt,
+		Module:       "recover",
 		Data: authboss.HTMLData{
 			DataRecoverURL: mailURL,
 		},
@@ -170,8 +264,9 @@ func (r *Recover) SendRecoverEmail(ctx context.Context, to, encodedToken string)
 
 	logger.Infof("sending recover e-mail to: %s", to)
 	if err := r.Authboss.Email(ctx, email, ro); err != nil {
-		logger.Errorf("failed to recover send e-mail to %s: %+v", to, err)
+		return fmt.Errorf("failed to send recover e-mail to %s: %w", to, err)
 	}
+	return nil
 }
 
 // EndGet shows a password recovery form, and it should have the token that
@@ -183,13 +278,7 @@ func (r *Recover) EndGet(w http.ResponseWriter, req *http.Request) error {
 	}
 
 	values := authboss.MustHaveRecoverMiddleValues(validatable)
-	token := values.GetToken()
-
-	data := authboss.HTMLData{
-		DataRecoverToken: token,
-	}
-
-	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
+	return r.respondEnd(w, req, values.GetToken(), authboss.HTMLData{})
 }
 
 // EndPost retrieves the token
@@ -208,51 +297,22 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 	if errs := validatable.Validate(); errs != nil {
 		logger.Info("recovery validation failed")
 		data := authboss.HTMLData{
-			authboss.DataValidation: authboss.ErrorMap(errs),
-			DataRecoverToken:        token,
+			authboss.DataValidation: r.LocalizeErrors(req.Context(), errs),
 		}
-		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
+		return r.respondEnd(w, req, token, data)
 	}
 
-	rawToken, err := base64.URLEncoding.DecodeString(token)
+	user, err := r.userByToken(req, token)
 	if err != nil {
-		logger.Infof("invalid recover token submitted, base64 decode failed: %+v", err)
-		return r.invalidToken(PageRecoverEnd, w, req)
-	}
-
-	if len(rawToken) != recoverTokenSize {
-		logger.Infof("invalid recover token submitted, size was wrong: %d", len(rawToken))
-		return r.invalidToken(PageRecoverEnd, w, req)
-	}
-
-	selectorBytes := sha512.Sum512(rawToken[:recoverTokenSplit])
-	verifierBytes := sha512.Sum512(rawToken[recoverTokenSplit:])
-	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
-
-	storer := authboss.EnsureCanRecover(r.Authboss.Config.Storage.Server)
-	user, err := storer.LoadByRecoverSelector(req.Context(), selector)
-	if err == authboss.ErrUserNotFound {
-		logger.Info("invalid recover token submitted, user not found")
-		return r.invalidToken(PageRecoverEnd, w, req)
-	} else if err != nil {
 		return err
-	}
-
-	if time.Now().UTC().After(user.GetRecoverExpiry()) {
-		logger.Infof("invalid recover token submitted, already expired: %+v", err)
+	} else if user == nil {
 		return r.invalidToken(PageRecoverEnd, w, req)
 	}
 
-	dbVerifierBytes, err := base64.StdEncoding.DecodeString(user.GetRecoverVerifier())
-	if err != nil {
-		logger.Infof("invalid recover verifier stored in database: %s", user.GetRecoverVerifier())
-		return r.invalidToken(PageRecoverEnd, w, req)
-	}
-
-	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
-		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
-		logger.Info("stored recover verifier does not match provided one")
-		return r.invalidToken(PageRecoverEnd, w, req)
+	if r.Config.Modules.RecoverVerifier != nil {
+		if ok, err := r.verifyAnswer(w, req, user, validatable); err != nil || !ok {
+			return err
+		}
 	}
 
 	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyUser, user))
@@ -269,18 +329,37 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 	}
 
 	user.PutPassword(string(pass))
-	user.PutRecoverSelector("")             // Don't allow another recovery
-	user.PutRecoverVerifier("")             // Don't allow another recovery
-	user.PutRecoverExpiry(time.Now().UTC()) // Put current time for those DBs that can't handle 0 time
+	user.PutRecoverSelector("")          // Don't allow another recovery
+	user.PutRecoverVerifier("")          // Don't allow another recovery
+	user.PutRecoverExpiry(r.Now().UTC()) // Put current time for those DBs that can't handle 0 time
+
+	err = r.Authboss.InTx(req.Context(), func(ctx context.Context) error {
+		if err := r.Authboss.SaveUser(ctx, user); err != nil {
+			return err
+		}
+
+		// Whoever had the old password may still be logged in
+		return r.Authboss.RevokeSessions(ctx, user.GetPID())
+	})
+	if err != nil {
+		return err
+	}
+	authboss.DelKnownSession(w)
+	authboss.DelKnownCookie(w)
 
-	if err := storer.Save(req.Context(), user); err != nil {
+	_, err = r.Authboss.Events.FireAfter(authboss.EventPasswordReset, w, req)
+	if err != nil {
+		return err
+	}
+	_, err = r.Authboss.Events.FireAfter(authboss.EventSessionDestroy, w, req)
+	if err != nil {
 		return err
 	}
 
-	successMsg := "Successfully updated password"
+	successMsg := authboss.TxtPasswordUpdated
 	if r.Authboss.Config.Modules.RecoverLoginAfterRecovery {
 		authboss.PutSession(w, authboss.SessionKey, user.GetPID())
-		successMsg += " and logged in"
+		successMsg = authboss.TxtPasswordLoggedIn
 	}
 
 	_, err = r.Authboss.Events.FireAfter(authboss.EventRecoverEnd, w, req)
@@ -290,27 +369,156 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: r.Authboss.Config.Paths.RecoverOK,
-		Success:      successMsg,
+		RedirectPath: r.Authboss.RedirectPath(req, "recover", authboss.RedirectOK, user, r.Authboss.RequestConfig(req.Context()).Paths.RecoverOK),
+		Success:      r.Localize(req.Context(), successMsg),
 	}
 	return r.Authboss.Config.Core.Redirector.Redirect(w, req, ro)
 }
 
+// userByToken finds the user the recover token was sent to, the user is nil
+// if the token is invalid or expired
+func (r *Recover) userByToken(req *http.Request, token string) (authboss.RecoverableUser, error) {
+	logger := r.RequestLogger(req)
+
+	tokens := r.Authboss.TokenGenerator()
+	rawToken, err := tokens.Decode(token)
+	if err != nil {
+		logger.Infof("invalid recover token submitted, decode failed: %+v", err)
+		return nil, nil
+	}
+
+	selector, verifier, err := tokens.Split(req.Context(), authboss.TokenRecover, rawToken)
+	if err != nil {
+		logger.Infof("invalid recover token submitted: %+v", err)
+		return nil, nil
+	}
+
+	storer := authboss.EnsureCanRecover(r.Authboss.Storer(req.Context()))
+	user, err := storer.LoadByRecoverSelector(req.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Info("invalid recover token submitted, user not found")
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if r.Now().UTC().After(user.GetRecoverExpiry()) {
+		logger.Info("invalid recover token submitted, already expired")
+		return nil, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(verifier), []byte(user.GetRecoverVerifier())) != 1 {
+		logger.Info("stored recover verifier does not match provided one")
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// verifyAnswer checks the answer to the RecoverVerifier's prompt, it
+// responds and returns false if it's wrong. The attempts are counted by
+// user for the RecoverTokenDuration so that asking for new tokens doesn't
+// allow more guesses, the user's token stops working once they're used up.
+func (r *Recover) verifyAnswer(w http.ResponseWriter, req *http.Request, user authboss.RecoverableUser, validatable authboss.Validator) (bool, error) {
+	logger := r.RequestLogger(req)
+	verifier := r.Config.Modules.RecoverVerifier
+
+	prompt, err := verifier.RecoverPrompt(req.Context(), user)
+	if err != nil {
+		return false, err
+	} else if prompt == nil {
+		return true, nil
+	}
+
+	attemptID := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, attemptID); err != nil {
+		return false, err
+	}
+	attempts, err := r.Config.Storage.Counter.CountDistinct(req.Context(), "recover:"+user.GetPID(), base64.RawURLEncoding.EncodeToString(attemptID), r.Config.Modules.RecoverTokenDuration)
+	if err != nil {
+		return false, err
+	}
+
+	if attempts > r.Config.Modules.RecoverVerifyAttempts {
+		logger.Infof("user %s ran out of recover answer attempts", user.GetPID())
+		return false, r.expireToken(w, req, user)
+	}
+
+	var answer string
+	if answerValues, ok := validatable.(authboss.RecoverAnswerValuer); ok {
+		answer = answerValues.GetRecoverAnswer()
+	}
+
+	ok, err := verifier.VerifyRecover(req.Context(), user, answer)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	logger.Infof("user %s gave a wrong recover answer", user.GetPID())
+	if attempts == r.Config.Modules.RecoverVerifyAttempts {
+		return false, r.expireToken(w, req, user)
+	}
+
+	data := authboss.HTMLData{
+		authboss.DataValidation: map[string][]string{FormValueAnswer: {r.Localize(req.Context(), authboss.TxtRecoverAnswer)}},
+		DataRecoverToken:        authboss.MustHaveRecoverEndValues(validatable).GetToken(),
+		DataRecoverPrompt:       prompt,
+	}
+	return false, r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
+}
+
+// expireToken stops the user's recover token from working
+func (r *Recover) expireToken(w http.ResponseWriter, req *http.Request, user authboss.RecoverableUser) error {
+	user.PutRecoverSelector("")
+	user.PutRecoverVerifier("")
+	user.PutRecoverExpiry(r.Now().UTC())
+	if err := r.Authboss.SaveUser(req.Context(), user); err != nil {
+		return err
+	}
+
+	return r.invalidToken(PageRecoverEnd, w, req)
+}
+
+// respondEnd with the recover end page, adding the RecoverVerifier's
+// prompt for the user of the token if it's valid
+func (r *Recover) respondEnd(w http.ResponseWriter, req *http.Request, token string, data authboss.HTMLData) error {
+	data[DataRecoverToken] = token
+
+	if verifier := r.Config.Modules.RecoverVerifier; verifier != nil {
+		user, err := r.userByToken(req, token)
+		if err != nil {
+			return err
+		}
+
+		if user != nil {
+			prompt, err := verifier.RecoverPrompt(req.Context(), user)
+			if err != nil {
+				return err
+			} else if prompt != nil {
+				data[DataRecoverPrompt] = prompt
+			}
+		}
+	}
+
+	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
+}
+
 func (r *Recover) invalidToken(page string, w http.ResponseWriter, req *http.Request) error {
-	errorsAll := []error{errors.New("recovery token is invalid")}
-	data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errorsAll)}
+	errorsAll := []error{authboss.NewLocalizedError(authboss.TxtRecoverInvalid)}
+	data := authboss.HTMLData{authboss.DataValidation: r.LocalizeErrors(req.Context(), errorsAll)}
 	return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
 }
 
-func (r *Recover) mailURL(token string) string {
+func (r *Recover) mailURL(ctx context.Context, token string) string {
+	config := r.RequestConfig(ctx)
 	query := url.Values{FormValueToken: []string{token}}
 
-	if len(r.Config.Mail.RootURL) != 0 {
-		return fmt.Sprintf("%s?%s", r.Config.Mail.RootURL+"/recover/end", query.Encode())
+	if len(config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", config.Mail.RootURL+"/recover/end", query.Encode())
 	}
 
-	p := path.Join(r.Config.Paths.Mount, "recover/end")
-	return fmt.Sprintf("%s%s?%s", r.Config.Paths.RootURL, p, query.Encode())
+	p := path.Join(config.Paths.Mount, "recover/end")
+	return fmt.Sprintf("%s%s?%s", config.Paths.RootURL, p, query.Encode())
 }
 
 // GenerateRecoverCreds generates pieces needed for user recovery
@@ -319,16 +527,21 @@ func (r *Recover) mailURL(token string) string {
 // verifier: hash of the second half of a 64 byte value
 // (to be stored in database but never used in SELECT query)
 // token: the user-facing base64 encoded selector+verifier
+// They're made by the authboss.DefaultTokenGenerator.
 func GenerateRecoverCreds() (selector, verifier, token string, err error) {
-	rawToken := make([]byte, recoverTokenSize)
-	if _, err = io.ReadFull(rand.Reader, rawToken); err != nil {
+	return generateRecoverCreds(context.Background(), authboss.DefaultTokenGenerator{})
+}
+
+// generateRecoverCreds creates the creds with the TokenGenerator, the
+// selector is scoped to the context's tenant
+func generateRecoverCreds(ctx context.Context, tokens authboss.TokenGenerator) (selector, verifier, token string, err error) {
+	rawToken, err := tokens.NewToken(ctx, authboss.TokenRecover)
+	if err != nil {
+		return "", "", "", err
+	}
+	if selector, verifier, err = tokens.Split(ctx, authboss.TokenRecover, rawToken); err != nil {
 		return "", "", "", err
 	}
-	selectorBytes := sha512.Sum512(rawToken[:recoverTokenSplit])
-	verifierBytes := sha512.Sum512(rawToken[recoverTokenSplit:])
 
-	return base64.StdEncoding.EncodeToString(selectorBytes[:]),
-		base64.StdEncoding.EncodeToString(verifierBytes[:]),
-		base64.URLEncoding.EncodeToString(rawToken),
-		nil
+	return selector, verifier, tokens.Encode(rawToken), nil
 }
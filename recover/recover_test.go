@@ -2,6 +2,7 @@ package recover
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
 	"encoding/base64"
 	"errors"
@@ -161,6 +162,30 @@ func TestStartPostSuccess(t *testing.T) {
 	}
 }
 
+func TestStartPostBackupEmail(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com", BackupEmail: "backup@test.com"}
+	h.storer.Users["nobackup@test.com"] = &mocks.User{Email: "nobackup@test.com"}
+
+	tests := map[string]string{
+		"test@test.com":     "backup@test.com",
+		"nobackup@test.com": "nobackup@test.com",
+	}
+	for pid, want := range tests {
+		h.bodyReader.Return = &mocks.Values{PID: pid, UseBackup: true}
+		if err := h.recover.StartPost(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+			t.Fatal(err)
+		}
+
+		if to := h.mailer.Email.To; len(to) != 1 || to[0] != want {
+			t.Errorf("%s: the e-mail should have been sent to %s: %v", pid, want, to)
+		}
+	}
+}
+
 func TestStartPostFailure(t *testing.T) {
 	t.Parallel()
 
@@ -192,6 +217,35 @@ func TestStartPostFailure(t *testing.T) {
 	}
 }
 
+func TestStartPostPreventEnumeration(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.PreventUserEnumeration = true
+
+	h.bodyReader.Return = &mocks.Values{
+		PID: "test@test.com",
+	}
+
+	if err := h.recover.StartPost(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+		t.Error(err)
+	}
+	unknown := h.redirector.Options
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	if err := h.recover.StartPost(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+		t.Error(err)
+	}
+	known := h.redirector.Options
+
+	if unknown != known {
+		t.Errorf("responses should be the same, got: %#v and %#v", unknown, known)
+	}
+	if known.Success != authboss.TxtRecoverGeneric.Default {
+		t.Error("message was wrong:", known.Success)
+	}
+}
+
 func TestEndGet(t *testing.T) {
 	t.Parallel()
 
@@ -234,11 +288,19 @@ func TestEndPostSuccess(t *testing.T) {
 		RecoverVerifier:    testVerifier,
 		RecoverTokenExpiry: time.Now().UTC().AddDate(0, 0, 1),
 	}
+	h.storer.RMTokens["test@test.com"] = []string{"token"}
+	h.storer.Sessions["test@test.com"] = []authboss.SessionRecord{{ID: "a"}, {ID: "b"}}
+
+	reset := false
+	h.ab.Events.After(authboss.EventPasswordReset, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		reset = true
+		return false, nil
+	})
 
 	r := mocks.Request("POST")
 	w := httptest.NewRecorder()
 
-	if err := h.recover.EndPost(w, r); err != nil {
+	if err := h.recover.EndPost(h.ab.NewResponse(w), r); err != nil {
 		t.Error(err)
 	}
 
@@ -251,6 +313,18 @@ func TestEndPostSuccess(t *testing.T) {
 	if len(h.session.ClientValues[authboss.SessionKey]) != 0 {
 		t.Error("should not have logged in the user")
 	}
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("remember tokens should have been deleted")
+	}
+	if len(h.storer.Sessions["test@test.com"]) != 0 {
+		t.Error("sessions should have been deleted")
+	}
+	if !reset {
+		t.Error("EventPasswordReset should have fired")
+	}
+	if user := h.storer.Users["test@test.com"]; len(user.RecoverSelector) != 0 || len(user.RecoverVerifier) != 0 {
+		t.Error("the recover token should have been cleared")
+	}
 	if !strings.Contains(h.redirector.Options.Success, "updated password") {
 		t.Error("should talk about recovering the password")
 	}
@@ -297,6 +371,86 @@ func TestEndPostSuccessLogin(t *testing.T) {
 	}
 }
 
+func TestEndPostVerifier(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Storage.Counter = mocks.NewCounter()
+	h.ab.Config.Modules.RecoverVerifier = PhoneVerifier{}
+	h.ab.Config.Modules.RecoverVerifyAttempts = 2
+
+	user := &mocks.User{
+		Email:              "test@test.com",
+		Password:           "to-overwrite",
+		SMSPhoneNumber:     "+1 555 123 4567",
+		RecoverSelector:    testSelector,
+		RecoverVerifier:    testVerifier,
+		RecoverTokenExpiry: time.Now().UTC().AddDate(0, 0, 1),
+	}
+	h.storer.Users["test@test.com"] = user
+
+	h.bodyReader.Return = &mocks.Values{Token: testToken}
+	if err := h.recover.EndGet(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+		t.Fatal(err)
+	}
+	if prompt := h.responder.Data[DataRecoverPrompt]; prompt != (PhonePrompt{Digits: 4}) {
+		t.Error("the prompt should be rendered:", prompt)
+	}
+
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Answer: "4568"}
+	if err := h.recover.EndPost(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if errs := h.responder.Data[authboss.DataValidation].(map[string][]string); len(errs[FormValueAnswer]) == 0 {
+		t.Error("a wrong answer should be rejected:", errs)
+	}
+	if user.Password != "to-overwrite" {
+		t.Error("the password should not be reset")
+	}
+
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Answer: "45-67"}
+	w := httptest.NewRecorder()
+	if err := h.recover.EndPost(h.ab.NewResponse(w), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTemporaryRedirect || user.Password == "to-overwrite" {
+		t.Error("the right answer should reset the password")
+	}
+}
+
+func TestEndPostVerifierAttempts(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Storage.Counter = mocks.NewCounter()
+	h.ab.Config.Modules.RecoverVerifier = PhoneVerifier{}
+	h.ab.Config.Modules.RecoverVerifyAttempts = 2
+
+	user := &mocks.User{
+		Email:              "test@test.com",
+		Password:           "to-overwrite",
+		SMSPhoneNumber:     "5551234567",
+		RecoverSelector:    testSelector,
+		RecoverVerifier:    testVerifier,
+		RecoverTokenExpiry: time.Now().UTC().AddDate(0, 0, 1),
+	}
+	h.storer.Users["test@test.com"] = user
+
+	for _, answer := range []string{"0000", "1111", "4567"} {
+		h.bodyReader.Return = &mocks.Values{Token: testToken, Answer: answer}
+		if err := h.recover.EndPost(httptest.NewRecorder(), mocks.Request("POST")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if user.Password != "to-overwrite" {
+		t.Error("the password should not be reset after too many wrong answers")
+	}
+	if len(user.RecoverSelector) != 0 {
+		t.Error("the token should stop working")
+	}
+}
+
 func TestEndPostValidationFailure(t *testing.T) {
 	t.Parallel()
 
@@ -408,16 +562,25 @@ func TestMailURL(t *testing.T) {
 	h.ab.Config.Paths.Mount = "/v1/auth"
 
 	want := "https://api.test.com:6343/v1/auth/recover/end?token=abc"
-	if got := h.recover.mailURL("abc"); got != want {
+	if got := h.recover.mailURL(context.Background(), "abc"); got != want {
 		t.Error("want:", want, "got:", got)
 	}
 
 	h.ab.Config.Mail.RootURL = "https://test.com:3333/testauth"
 
 	want = "https://test.com:3333/testauth/recover/end?token=abc"
-	if got := h.recover.mailURL("abc"); got != want {
+	if got := h.recover.mailURL(context.Background(), "abc"); got != want {
 		t.Error("want:", want, "got:", got)
 	}
+
+	config := h.ab.Config
+	config.Mail.RootURL = "https://brand.test.com/auth"
+	ctx := context.WithValue(context.Background(), authboss.CTXKeyConfig, &config)
+
+	want = "https://brand.test.com/auth/recover/end?token=abc"
+	if got := h.recover.mailURL(ctx, "abc"); got != want {
+		t.Error("the request's config should be used, want:", want, "got:", got)
+	}
 }
 
 func invalidCheck(t *testing.T, h *testHarness, w *httptest.ResponseRecorder) {
@@ -475,12 +638,41 @@ func TestGenerateRecoverCreds(t *testing.T) {
 		t.Error(err)
 	}
 
-	checkSelector := sha512.Sum512(rawToken[:recoverTokenSplit])
+	checkSelector := sha512.Sum512(rawToken[:32])
 	if 0 != bytes.Compare(checkSelector[:], rawSelector) {
 		t.Error("expected selector to match")
 	}
-	checkVerifier := sha512.Sum512(rawToken[recoverTokenSplit:])
+	checkVerifier := sha512.Sum512(rawToken[32:])
 	if 0 != bytes.Compare(checkVerifier[:], rawVerifier) {
 		t.Error("expected verifier to match")
 	}
 }
+
+func TestStartPostMailFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.Mailer = &mocks.Mailer{SendErr: "mailer is down"}
+	h.ab.Config.Modules.MailFailurePolicy = authboss.MailFailureError
+
+	h.bodyReader.Return = &mocks.Values{
+		PID: "test@test.com",
+	}
+	h.storer.Users["test@test.com"] = &mocks.User{
+		Email: "test@test.com",
+	}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageRecoverStart {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtRecoverMailFailed.Default {
+		t.Error("the user should have been told to try again:", h.responder.Data)
+	}
+}
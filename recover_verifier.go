@@ -0,0 +1,18 @@
+package authboss
+
+import "context"
+
+// RecoverVerifier adds a step to password recovery for sites that don't
+// trust the e-mailed token alone: users must also answer a prompt, like
+// the last digits of their phone number or a security question, before
+// their password is reset. See the recover module's PhoneVerifier.
+type RecoverVerifier interface {
+	// RecoverPrompt is what the user is asked, it's rendered on the
+	// recover end page under the recover module's DataRecoverPrompt. If
+	// it's nil the user isn't asked anything, for example because they
+	// have no phone number.
+	RecoverPrompt(ctx context.Context, user RecoverableUser) (interface{}, error)
+	// VerifyRecover checks the user's answer to the prompt, returning
+	// false if it's wrong.
+	VerifyRecover(ctx context.Context, user RecoverableUser, answer string) (bool, error)
+}
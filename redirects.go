@@ -0,0 +1,141 @@
+package authboss
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Outcomes of the modules that users are redirected after, see
+// Config.Paths.Redirects
+const (
+	// RedirectOK is after a module's flow succeeded: logging in, confirming,
+	// registering, recovering, unlocking or logging out.
+	RedirectOK = "ok"
+	// RedirectNotOK is after a module turned the user away: an unconfirmed
+	// or locked account, a failed oauth2 login, too many sessions or an
+	// e-mail that has to be verified first.
+	RedirectNotOK = "not_ok"
+	// RedirectLinkOK is after an oauth2 account was linked or unlinked
+	RedirectLinkOK = "link_ok"
+	// RedirectLinkNotOK is after an oauth2 account couldn't be linked or
+	// unlinked
+	RedirectLinkNotOK = "link_not_ok"
+)
+
+// RedirectKey is a module (by its registered name, or totp2fa and sms2fa)
+// and one of its outcomes
+type RedirectKey struct {
+	Module  string
+	Outcome string
+}
+
+// RedirectFunc decides where to redirect to after an outcome. The user is
+// nil when the module doesn't have one, returning "" uses the path from
+// Config.Paths.
+type RedirectFunc func(r *http.Request, user User) string
+
+// RedirectTo is a RedirectFunc that always redirects to path
+func RedirectTo(path string) RedirectFunc {
+	return func(*http.Request, User) string { return path }
+}
+
+// RedirectPath is where the module redirects to after the outcome: what
+// Config.Paths.Redirects decides for it, or the fallback (the module's path
+// from Config.Paths).
+func (a *Authboss) RedirectPath(r *http.Request, module, outcome string, user User, fallback string) string {
+	if fn := a.RequestConfig(r.Context()).Paths.Redirects[RedirectKey{Module: module, Outcome: outcome}]; fn != nil {
+		if path := fn(r, user); len(path) != 0 {
+			return path
+		}
+	}
+
+	return fallback
+}
+
+// IsLocalRedirect checks that a redirect from the client stays on the site:
+// it has to be a path starting with a single slash. Anything a browser
+// could take to another host (//host, /\host, schemes, control characters
+// that browsers strip) is refused.
+func IsLocalRedirect(redir string) bool {
+	if !strings.HasPrefix(redir, "/") || strings.HasPrefix(redir, "//") || strings.HasPrefix(redir, "/\\") {
+		return false
+	}
+	for _, c := range redir {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+
+	u, err := url.Parse(redir)
+	return err == nil && len(u.Scheme) == 0 && len(u.Host) == 0
+}
+
+// SafeRedirect validates a redirect from the client (like the redir form
+// value), ok is false if it isn't same origin. Absolute urls are allowed
+// when they have the scheme and host of Config.Paths.RootURL, they're made
+// relative.
+func (a *Authboss) SafeRedirect(redir string) (path string, ok bool) {
+	if IsLocalRedirect(redir) {
+		return redir, true
+	}
+	if len(a.Config.Paths.RootURL) == 0 {
+		return "", false
+	}
+
+	u, err := url.Parse(redir)
+	if err != nil || len(u.Host) == 0 {
+		return "", false
+	}
+	root, err := url.Parse(a.Config.Paths.RootURL)
+	if err != nil || !strings.EqualFold(u.Scheme, root.Scheme) || !strings.EqualFold(u.Host, root.Host) || u.User != nil {
+		return "", false
+	}
+
+	path = u.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(u.RawQuery) != 0 {
+		path += "?" + u.RawQuery
+	}
+	if len(u.Fragment) != 0 {
+		path += "#" + u.EscapedFragment()
+	}
+	if !IsLocalRedirect(path) {
+		return "", false
+	}
+	return path, true
+}
+
+// KeepReturnTo puts where the user should be sent back to in the session,
+// so that it survives the detours of a login (confirming the account, a
+// second factor or any other step a module puts in between). It's ignored
+// if it isn't same origin.
+func (a *Authboss) KeepReturnTo(w http.ResponseWriter, redir string) {
+	if path, ok := a.SafeRedirect(redir); ok {
+		PutSession(w, SessionReturnTo, path)
+	}
+}
+
+// ReturnTo is where to send the user at the end of a login: the request's
+// redir value, or else the one kept with KeepReturnTo, or else the
+// fallback. Values that aren't same origin are ignored and the kept one is
+// removed from the session.
+func (a *Authboss) ReturnTo(w http.ResponseWriter, r *http.Request, fallback string) string {
+	redir := r.FormValue(FormValueRedirect)
+	kept, hasKept := GetSession(r, SessionReturnTo)
+	if hasKept || len(redir) != 0 {
+		// The redir value may have been kept earlier in this request
+		DelSession(w, SessionReturnTo)
+	}
+
+	if path, ok := a.SafeRedirect(redir); ok {
+		return path
+	}
+	if path, ok := a.SafeRedirect(kept); ok {
+		return path
+	}
+
+	return fallback
+}
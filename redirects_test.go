@@ -0,0 +1,134 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsLocalRedirect(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Redir string
+		Local bool
+	}{
+		{"/", true},
+		{"/account?tab=security#2fa", true},
+		{"/a//b", true},
+		{"", false},
+		{"account", false},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+		{"/\t/evil.com", false},
+		{"https://evil.com", false},
+		{"javascript:alert(1)", false},
+	}
+
+	for _, test := range tests {
+		if got := IsLocalRedirect(test.Redir); got != test.Local {
+			t.Errorf("%q: want %t, got %t", test.Redir, test.Local, got)
+		}
+	}
+}
+
+func TestSafeRedirect(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Paths.RootURL = "https://site.com"
+
+	tests := []struct {
+		Redir string
+		Path  string
+		OK    bool
+	}{
+		{"/account", "/account", true},
+		{"https://site.com/account?tab=1", "/account?tab=1", true},
+		{"https://SITE.com", "/", true},
+		{"http://site.com/account", "", false},
+		{"https://site.com.evil.com/account", "", false},
+		{"https://user@site.com/account", "", false},
+		{"//site.com/account", "", false},
+	}
+
+	for _, test := range tests {
+		path, ok := ab.SafeRedirect(test.Redir)
+		if path != test.Path || ok != test.OK {
+			t.Errorf("%q: want %q %t, got %q %t", test.Redir, test.Path, test.OK, path, ok)
+		}
+	}
+
+	ab.Config.Paths.RootURL = ""
+	if _, ok := ab.SafeRedirect("https://site.com/account"); ok {
+		t.Error("absolute urls should be refused without a root url")
+	}
+}
+
+func TestRedirectPath(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Paths.Redirects = map[RedirectKey]RedirectFunc{
+		{Module: "auth", Outcome: RedirectOK}: func(r *http.Request, user User) string {
+			if user != nil && user.GetPID() == "admin" {
+				return "/admin"
+			}
+			return ""
+		},
+		{Module: "confirm", Outcome: RedirectOK}: RedirectTo("/welcome"),
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if path := ab.RedirectPath(r, "confirm", RedirectOK, nil, "/"); path != "/welcome" {
+		t.Error("path was wrong:", path)
+	}
+	if path := ab.RedirectPath(r, "auth", RedirectOK, &mockUser{Email: "admin"}, "/"); path != "/admin" {
+		t.Error("path was wrong:", path)
+	}
+	if path := ab.RedirectPath(r, "auth", RedirectOK, &mockUser{Email: "user"}, "/home"); path != "/home" {
+		t.Error("an empty path should fall back:", path)
+	}
+	if path := ab.RedirectPath(r, "confirm", RedirectNotOK, nil, "/confirm"); path != "/confirm" {
+		t.Error("outcomes that aren't configured should fall back:", path)
+	}
+}
+
+func TestReturnTo(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Storage.SessionState = newMockClientStateRW()
+
+	w := ab.NewResponse(httptest.NewRecorder())
+	ab.KeepReturnTo(w, "https://evil.com")
+	ab.KeepReturnTo(w, "/account")
+	if len(w.sessionStateEvents) != 1 || w.sessionStateEvents[0].Value != "/account" {
+		t.Fatal("only the same origin path should be kept:", w.sessionStateEvents)
+	}
+
+	ab.Storage.SessionState = newMockClientStateRW(SessionReturnTo, "/account")
+	r := httptest.NewRequest("GET", "/", nil)
+	w = ab.NewResponse(httptest.NewRecorder())
+	r, err := ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path := ab.ReturnTo(w, r, "/home"); path != "/account" {
+		t.Error("it should return to the kept path:", path)
+	}
+	want := ClientStateEvent{Kind: ClientStateEventDel, Key: SessionReturnTo}
+	if len(w.sessionStateEvents) != 1 || w.sessionStateEvents[0] != want {
+		t.Error("the kept path should be removed:", w.sessionStateEvents)
+	}
+
+	r = httptest.NewRequest("GET", "/?redir=/settings", nil)
+	if path := ab.ReturnTo(w, r, "/home"); path != "/settings" {
+		t.Error("the request's redir should come first:", path)
+	}
+	r = httptest.NewRequest("GET", "/?redir=//evil.com", nil)
+	if path := ab.ReturnTo(w, r, "/home"); path != "/home" {
+		t.Error("it should fall back when redir isn't same origin:", path)
+	}
+}
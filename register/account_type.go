@@ -0,0 +1,51 @@
+package register
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// FormValueAccountType is the name of the form value for the account
+	// type, see Modules.AccountTypes
+	FormValueAccountType = "account_type"
+
+	// DataAccountTypes is the name of the template variable with the
+	// account types users can choose from
+	DataAccountTypes = "account_types"
+)
+
+// accountTypeError is the validation error for an account type that isn't
+// one of Modules.AccountTypes
+type accountTypeError struct {
+	err error
+}
+
+func (a accountTypeError) Name() string  { return FormValueAccountType }
+func (a accountTypeError) Err() error    { return a.err }
+func (a accountTypeError) Error() string { return fmt.Sprintf("%s: %v", FormValueAccountType, a.err) }
+
+// accountType reads the account type from the values when
+// Modules.AccountTypes is set, it must be one of them.
+func (r *Register) accountType(validatable authboss.Validator) (string, error) {
+	accountTypes := r.Config.Modules.AccountTypes
+	if len(accountTypes) == 0 {
+		return "", nil
+	}
+
+	values, ok := validatable.(authboss.AccountTypeValuer)
+	if !ok {
+		panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to AccountTypeValuer: %T", validatable))
+	}
+
+	accountType := values.GetAccountType()
+	for _, t := range accountTypes {
+		if t == accountType {
+			return accountType, nil
+		}
+	}
+
+	return "", accountTypeError{authboss.NewLocalizedError(authboss.TxtAccountType, "AccountTypes", strings.Join(accountTypes, ", "))}
+}
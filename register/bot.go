@@ -0,0 +1,100 @@
+package register
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// FormValueFormTime is the name of the form value for the signed time
+	// the register page was rendered, see Modules.RegisterMinFillTime
+	FormValueFormTime = "form_time"
+
+	// DataFormTime is the name of the template variable with the signed
+	// time the register page was rendered, it must be submitted with the
+	// form as FormValueFormTime.
+	DataFormTime = "form_time"
+
+	nFormTimeSigSize = 64
+	nFormTimeSize    = 8
+)
+
+// isBot checks the honeypot and fill time of a registration. The values
+// are read from the request's form since the honeypot shouldn't be
+// whitelisted into the arbitrary values.
+func (r *Register) isBot(req *http.Request) (bool, string) {
+	if name := r.Config.Modules.RegisterHoneypot; len(name) != 0 && len(req.FormValue(name)) != 0 {
+		return true, "honeypot was filled in"
+	}
+
+	minFillTime := r.Config.Modules.RegisterMinFillTime
+	if minFillTime <= 0 {
+		return false, ""
+	}
+
+	rendered, ok := verifyFormTime(r.Config.Modules.RegisterFormKey, req.FormValue(FormValueFormTime))
+	if !ok {
+		return true, "form time was missing or invalid"
+	}
+	if r.Now().Sub(rendered) < minFillTime {
+		return true, "form was filled in too quickly"
+	}
+
+	return false, ""
+}
+
+// rejectBot fires EventRegisterBot and, unless it was handled, responds
+// as if the registration succeeded
+func (r *Register) rejectBot(w http.ResponseWriter, req *http.Request, reason string) error {
+	logger := r.RequestLogger(req)
+	logger.Infof("rejected registration from a bot: %s", reason)
+
+	handled, err := r.Events.FireAfter(authboss.EventRegisterBot, w, req)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Success:      r.Localize(req.Context(), authboss.TxtRegistered),
+		RedirectPath: r.RedirectPath(req, "register", authboss.RedirectOK, nil, r.RequestConfig(req.Context()).Paths.RegisterOK),
+	}
+	return r.Config.Core.Redirector.Redirect(w, req, ro)
+}
+
+// signFormTime creates a token for the time, it's the signature followed
+// by the time in unix nanoseconds.
+func signFormTime(key []byte, t time.Time) string {
+	rawToken := make([]byte, nFormTimeSigSize+nFormTimeSize)
+	binary.BigEndian.PutUint64(rawToken[nFormTimeSigSize:], uint64(t.UnixNano()))
+
+	mac := hmac.New(sha512.New, key)
+	_, _ = mac.Write(rawToken[nFormTimeSigSize:])
+	copy(rawToken, mac.Sum(nil))
+
+	return base64.URLEncoding.EncodeToString(rawToken)
+}
+
+// verifyFormTime checks the token's signature and returns the time
+func verifyFormTime(key []byte, token string) (time.Time, bool) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(rawToken) != nFormTimeSigSize+nFormTimeSize {
+		return time.Time{}, false
+	}
+
+	mac := hmac.New(sha512.New, key)
+	_, _ = mac.Write(rawToken[nFormTimeSigSize:])
+	if !hmac.Equal(mac.Sum(nil), rawToken[:nFormTimeSigSize]) {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(rawToken[nFormTimeSigSize:]))), true
+}
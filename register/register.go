@@ -26,6 +26,19 @@ type Register struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what registering needs
+func (r *Register) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Storage.Server", "Storage.SessionState")
+	problems = append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.CreatingServerStorer)(nil))...)
+
+	if ab.Config.Modules.RegisterMinFillTime > 0 && len(ab.Config.Modules.RegisterFormKey) == 0 {
+		problems = append(problems, authboss.ConfigProblem{Field: "Modules.RegisterFormKey", Problem: "must be set to use Modules.RegisterMinFillTime"})
+	}
+
+	return problems
+}
+
 // Init the module.
 func (r *Register) Init(ab *authboss.Authboss) (err error) {
 	r.Authboss = ab
@@ -38,17 +51,41 @@ func (r *Register) Init(ab *authboss.Authboss) (err error) {
 		return err
 	}
 
+	if ab.Config.Modules.RegisterMinFillTime > 0 && len(ab.Config.Modules.RegisterFormKey) == 0 {
+		return errors.New("register module needs Modules.RegisterFormKey to use Modules.RegisterMinFillTime")
+	}
+
 	sort.Strings(ab.Config.Modules.RegisterPreserveFields)
 
-	ab.Config.Core.Router.Get("/register", ab.Config.Core.ErrorHandler.Wrap(r.Get))
-	ab.Config.Core.Router.Post("/register", ab.Config.Core.ErrorHandler.Wrap(r.Post))
+	ab.Config.Core.Router.Get("/register", ab.IPPolicyGuard("/register", ab.Config.Core.ErrorHandler.Wrap(r.Get)))
+	ab.Config.Core.Router.Post("/register", ab.IPPolicyGuard("/register", ab.Config.Core.ErrorHandler.Wrap(r.Post)))
+
+	if len(ab.Config.Modules.RegisterVerifyKey) != 0 {
+		return r.initVerify()
+	}
 
 	return nil
 }
 
+// APIOperations documents the register route for authboss.OpenAPI
+func (r *Register) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{{
+		Method:  http.MethodPost,
+		Path:    "/register",
+		Summary: "Create an account",
+		Fields: []authboss.APIField{
+			{Name: authboss.APIFieldPID, Required: true},
+			{Name: "password", Required: true, Format: "password"},
+			{Name: authboss.ConfirmPrefix + "password", Required: true, Format: "password"},
+		},
+		Redirects:  true,
+		ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation},
+	}}
+}
+
 // Get the register page
 func (r *Register) Get(w http.ResponseWriter, req *http.Request) error {
-	return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, nil)
+	return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, r.formData(nil))
 }
 
 // Post to the register page
@@ -59,6 +96,10 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 		return err
 	}
 
+	if bot, reason := r.isBot(req); bot {
+		return r.rejectBot(w, req, reason)
+	}
+
 	var arbitrary map[string]string
 	var preserve map[string]string
 	if arb, ok := validatable.(authboss.ArbitraryValuer); ok {
@@ -72,24 +113,42 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 		}
 	}
 
+	var extra authboss.ExtraFields
+	if ev, ok := validatable.(authboss.ExtraFieldsValuer); ok {
+		extra = ev.GetExtraFields()
+		if preserve == nil {
+			preserve = make(map[string]string)
+		}
+
+		for k, v := range extra.Strings() {
+			if hasString(r.Config.Modules.RegisterPreserveFields, k) {
+				preserve[k] = v
+			}
+		}
+	}
+
 	errs := validatable.Validate()
+	accountType, err := r.accountType(validatable)
+	if err != nil {
+		errs = append(errs, err)
+	}
 	if errs != nil {
 		logger.Info("registration validation failed")
 		data := authboss.HTMLData{
-			authboss.DataValidation: authboss.ErrorMap(errs),
+			authboss.DataValidation: r.LocalizeErrors(req.Context(), errs),
 		}
 		if preserve != nil {
 			data[authboss.DataPreserve] = preserve
 		}
-		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, data)
+		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, r.formData(data))
 	}
 
 	// Get values from request
 	userVals := authboss.MustHaveUserValues(validatable)
-	pid, password := userVals.GetPID(), userVals.GetPassword()
+	pid, password := r.Authboss.NormalizePID(userVals.GetPID()), userVals.GetPassword()
 
 	// Put values into newly created user for storage
-	storer := authboss.EnsureCanCreate(r.Config.Storage.Server)
+	storer := authboss.EnsureCanCreate(r.Storer(req.Context()))
 	user := authboss.MustBeAuthable(storer.New(req.Context()))
 
 	pass, err := bcrypt.GenerateFromPassword([]byte(password), r.Config.Modules.BCryptCost)
@@ -103,23 +162,66 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 	if arbUser, ok := user.(authboss.ArbitraryUser); ok && arbitrary != nil {
 		arbUser.PutArbitrary(arbitrary)
 	}
+	if extraUser, ok := user.(authboss.ExtraFieldsUser); ok && extra != nil {
+		extraUser.PutExtraFields(extra)
+	}
+	if len(accountType) != 0 {
+		authboss.MustHaveAccountType(user).PutAccountType(accountType)
+	}
+
+	if len(r.Config.Modules.RegisterVerifyKey) != 0 {
+		return r.startVerification(w, req, user, arbitrary, extra, accountType)
+	}
 
-	err = storer.Create(req.Context(), user)
+	err = r.create(w, req, user)
 	switch {
 	case err == authboss.ErrUserFound:
 		logger.Infof("user %s attempted to re-register", pid)
+		if r.Config.Modules.PreventUserEnumeration {
+			ro := authboss.RedirectOptions{
+				Code:         http.StatusTemporaryRedirect,
+				RedirectPath: r.RedirectPath(req, "confirm", authboss.RedirectNotOK, nil, r.RequestConfig(req.Context()).Paths.ConfirmNotOK),
+				Success:      r.Localize(req.Context(), authboss.TxtConfirmGeneric),
+			}
+			return r.Config.Core.Redirector.Redirect(w, req, ro)
+		}
+
 		errs = []error{errors.New("user already exists")}
 		data := authboss.HTMLData{
-			authboss.DataValidation: authboss.ErrorMap(errs),
+			authboss.DataValidation: r.LocalizeErrors(req.Context(), errs),
 		}
 		if preserve != nil {
 			data[authboss.DataPreserve] = preserve
 		}
-		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, data)
+		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, r.formData(data))
 	case err != nil:
 		return err
 	}
 
+	return nil
+}
+
+// create the user and finish registering them in a transaction, so that a
+// failure in a module's EventRegister handler (like saving the confirm
+// token) doesn't leave the user half registered
+func (r *Register) create(w http.ResponseWriter, req *http.Request, user authboss.User) error {
+	return r.InTx(req.Context(), func(ctx context.Context) error {
+		if err := authboss.EnsureCanCreate(r.Storer(ctx)).Create(ctx, user); err != nil {
+			return err
+		}
+
+		return r.registered(w, req.WithContext(ctx), user)
+	})
+}
+
+// registered fires EventRegister for a newly created user and logs them in
+// unless a module like confirm handled the event
+func (r *Register) registered(w http.ResponseWriter, req *http.Request, user authboss.User) error {
+	logger := r.RequestLogger(req)
+	pid := user.GetPID()
+
+	r.CountMetric(authboss.CounterRegistrations, nil)
+
 	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyUser, user))
 	handled, err := r.Events.FireAfter(authboss.EventRegister, w, req)
 	if err != nil {
@@ -135,12 +237,33 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 	logger.Infof("registered and logged in user %s", pid)
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		Success:      "Account successfully created, you are now logged in",
-		RedirectPath: r.Config.Paths.RegisterOK,
+		Success:      r.Localize(req.Context(), authboss.TxtRegistered),
+		RedirectPath: r.RedirectPath(req, "register", authboss.RedirectOK, user, r.AccountTypePath(user, r.RequestConfig(req.Context()).Paths.RegisterOK)),
 	}
 	return r.Config.Core.Redirector.Redirect(w, req, ro)
 }
 
+// formData adds the account types and the signed render time to the
+// register page's data when they're used
+func (r *Register) formData(data authboss.HTMLData) authboss.HTMLData {
+	accountTypes := r.Config.Modules.AccountTypes
+	minFillTime := r.Config.Modules.RegisterMinFillTime
+	if len(accountTypes) == 0 && minFillTime <= 0 {
+		return data
+	}
+
+	if data == nil {
+		data = authboss.HTMLData{}
+	}
+	if len(accountTypes) != 0 {
+		data[DataAccountTypes] = accountTypes
+	}
+	if minFillTime > 0 {
+		data[DataFormTime] = signFormTime(r.Config.Modules.RegisterFormKey, r.Now())
+	}
+	return data
+}
+
 // hasString checks to see if a sorted (ascending) array of
 // strings contains a string
 func hasString(arr []string, s string) bool {
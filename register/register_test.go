@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -191,6 +192,45 @@ func TestRegisterPostSuccess(t *testing.T) {
 	})
 }
 
+func TestRegisterPostTx(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	storer := mocks.NewTxServerStorer()
+	h.ab.Config.Storage.Server = storer
+	h.bodyReader.Return = mocks.ArbValues{
+		Values: map[string]string{
+			"email":    "test@test.com",
+			"password": "hello world",
+		},
+	}
+
+	h.ab.Events.After(authboss.EventRegister, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return false, errors.New("failed to save the confirm token")
+	})
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.reg.Post(w, mocks.Request("POST")); err == nil {
+		t.Fatal("the handler's error should be returned")
+	}
+
+	if _, ok := storer.Users["test@test.com"]; ok {
+		t.Error("the user should not have been created")
+	}
+	if storer.Rollbacks != 1 || storer.Commits != 0 {
+		t.Error("the transaction should have been rolled back:", storer.Rollbacks, storer.Commits)
+	}
+
+	h.ab.Events = authboss.NewEvents()
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.reg.Post(w, mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storer.Users["test@test.com"]; !ok || storer.Commits != 1 {
+		t.Error("the user should have been created and committed")
+	}
+}
+
 func TestRegisterPostValidationFailure(t *testing.T) {
 	t.Parallel()
 
@@ -297,6 +337,107 @@ func TestRegisterPostUserExists(t *testing.T) {
 	}
 }
 
+func TestRegisterPostUserExistsPreventEnumeration(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.PreventUserEnumeration = true
+	h.ab.Config.Paths.ConfirmNotOK = "/confirm"
+
+	h.storer.Users["test@test.com"] = &mocks.User{}
+	h.bodyReader.Return = mocks.ArbValues{
+		Values: map[string]string{
+			"email":    "test@test.com",
+			"password": "hello world",
+		},
+	}
+
+	r := mocks.Request("POST")
+	resp := httptest.NewRecorder()
+	w := h.ab.NewResponse(resp)
+
+	if err := h.reg.Post(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if len(h.responder.Page) != 0 {
+		t.Error("should not have rendered the register page:", h.responder.Page)
+	}
+	if h.redirector.Options.RedirectPath != "/confirm" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+	if h.redirector.Options.Success != authboss.TxtConfirmGeneric.Default {
+		t.Error("the message should not reveal the user exists:", h.redirector.Options.Success)
+	}
+}
+
+func TestRegisterPostAccountType(t *testing.T) {
+	t.Parallel()
+
+	setup := func(accountType string) *testHarness {
+		h := testSetup()
+		h.ab.Config.Modules.AccountTypes = []string{"buyer", "seller"}
+		h.ab.Config.Paths.AccountTypeOK = map[string]string{"seller": "/sell"}
+		h.bodyReader.Return = mocks.ArbValues{
+			Values: map[string]string{
+				"email":        "test@test.com",
+				"password":     "hello world",
+				"account_type": accountType,
+			},
+		}
+		return h
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+		h := setup("seller")
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+
+		if err := h.reg.Post(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		user, ok := h.storer.Users["test@test.com"]
+		if !ok {
+			t.Fatal("user was not persisted in the DB")
+		}
+		if user.AccountType != "seller" {
+			t.Error("account type was wrong:", user.AccountType)
+		}
+		if h.redirector.Options.RedirectPath != "/sell" {
+			t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		h := setup("admin")
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+
+		if err := h.reg.Post(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := h.storer.Users["test@test.com"]; ok {
+			t.Error("user should not have been created")
+		}
+		if h.responder.Page != PageRegister {
+			t.Error("rendered wrong page:", h.responder.Page)
+		}
+		errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+		if e := errList[FormValueAccountType]; len(e) != 1 || e[0] != "Must be one of: buyer, seller" {
+			t.Error("validation error wrong:", errList)
+		}
+		if types := h.responder.Data[DataAccountTypes].([]string); len(types) != 2 {
+			t.Error("account types were not rendered:", types)
+		}
+	})
+}
+
 func TestHasString(t *testing.T) {
 	t.Parallel()
 
@@ -316,3 +457,199 @@ func TestHasString(t *testing.T) {
 		t.Error("should not have f")
 	}
 }
+
+func TestRegisterPostVerify(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	mailer := &mocks.Emailer{}
+	h.ab.Config.Core.Mailer = mailer
+	h.ab.Config.Core.MailRenderer = &mocks.Renderer{}
+	h.ab.Modules.MailNoGoroutine = true
+	h.ab.Modules.RegisterVerifyKey = []byte("key")
+	h.ab.Modules.RegisterVerifyDuration = time.Hour
+	h.bodyReader.Return = mocks.ArbValues{
+		Values: map[string]string{
+			"email":    "test@test.com",
+			"password": "hello world",
+		},
+	}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+	if err := h.reg.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Users["test@test.com"]; ok {
+		t.Error("the user should not be created before verifying")
+	}
+	if len(mailer.Email.To) != 1 || mailer.Email.To[0] != "test@test.com" {
+		t.Error("the registration link should have been e-mailed:", mailer.Email.To)
+	}
+	if h.redirector.Options.Success != authboss.TxtConfirmGeneric.Default {
+		t.Error("message was wrong:", h.redirector.Options.Success)
+	}
+}
+
+func TestRegisterPostExtraFields(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.ArbValues{
+		Values: map[string]string{
+			"email":    "test@test.com",
+			"password": "hello world",
+		},
+		Extra: authboss.ExtraFields{"age": int64(30)},
+	}
+
+	if err := h.reg.Post(h.ab.NewResponse(httptest.NewRecorder()), mocks.Request("POST")); err != nil {
+		t.Fatal(err)
+	}
+	if user := h.storer.Users["test@test.com"]; user == nil || user.Extra.Int("age") != 30 {
+		t.Error("the extra fields should be given to the user")
+	}
+
+	// Double opt-in registration parses them from the link's token
+	h = testSetup()
+	h.ab.Modules.RegisterVerifyKey = []byte("key")
+	h.ab.Modules.RegisterExtraFields = []authboss.ExtraField{{Name: "age", Type: authboss.ExtraFieldInt}}
+
+	token, err := sealPending(h.ab.Modules.RegisterVerifyKey, pendingRegistration{
+		PID:     "test@test.com",
+		Extra:   authboss.ExtraFields{"age": int64(30)}.Strings(),
+		Expires: time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+	if err := h.reg.VerifyGet(h.ab.NewResponse(httptest.NewRecorder()), mocks.Request("GET")); err != nil {
+		t.Fatal(err)
+	}
+	if user := h.storer.Users["test@test.com"]; user == nil || user.Extra.Int("age") != 30 {
+		t.Error("the extra fields should be given to the verified user")
+	}
+}
+
+func TestRegisterVerifyGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	key := []byte("key")
+	h.ab.Modules.RegisterVerifyKey = key
+
+	token, err := sealPending(key, pendingRegistration{
+		PID:      "test@test.com",
+		Password: "hash",
+		Expires:  time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+	if err := h.reg.VerifyGet(h.ab.NewResponse(w), r); err != nil {
+		t.Fatal(err)
+	}
+
+	user, ok := h.storer.Users["test@test.com"]
+	if !ok {
+		t.Fatal("the user should have been created")
+	}
+	if user.Password != "hash" || !user.Confirmed {
+		t.Errorf("user was wrong: %#v", user)
+	}
+	if h.redirector.Options.RedirectPath != "/ok" {
+		t.Error("should have logged in the user:", h.redirector.Options.RedirectPath)
+	}
+
+	// The link only works once
+	if err := h.reg.VerifyGet(h.ab.NewResponse(httptest.NewRecorder()), r); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.redirector.Options.Failure) == 0 {
+		t.Error("a used link should fail")
+	}
+
+	expired, err := sealPending(key, pendingRegistration{PID: "new@test.com", Expires: time.Now().Add(-time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.bodyReader.Return = mocks.Values{Token: expired}
+	if err := h.reg.VerifyGet(h.ab.NewResponse(httptest.NewRecorder()), r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.storer.Users["new@test.com"]; ok {
+		t.Error("an expired link should not create the user")
+	}
+
+	if _, ok := openPending([]byte("other key"), token); ok {
+		t.Error("the token should not open with another key")
+	}
+}
+
+func TestRegisterPostBot(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("key")
+	setup := func() (*testHarness, *bool) {
+		h := testSetup()
+		h.ab.Modules.RegisterHoneypot = "website"
+		h.ab.Modules.RegisterMinFillTime = 5 * time.Second
+		h.ab.Modules.RegisterFormKey = key
+		h.bodyReader.Return = mocks.ArbValues{
+			Values: map[string]string{
+				"email":    "test@test.com",
+				"password": "hello world",
+			},
+		}
+
+		fired := false
+		h.ab.Events.After(authboss.EventRegisterBot, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			fired = true
+			return false, nil
+		})
+		return h, &fired
+	}
+
+	tests := map[string][]string{
+		"honeypot":  {"website", "spam", FormValueFormTime, signFormTime(key, time.Now().Add(-time.Minute))},
+		"too fast":  {FormValueFormTime, signFormTime(key, time.Now())},
+		"no time":   {"website", ""},
+		"wrong key": {FormValueFormTime, signFormTime([]byte("other"), time.Now().Add(-time.Minute))},
+	}
+
+	for name, form := range tests {
+		h, fired := setup()
+		if err := h.reg.Post(h.ab.NewResponse(httptest.NewRecorder()), mocks.Request("POST", form...)); err != nil {
+			t.Fatal(name, err)
+		}
+
+		if !*fired {
+			t.Error(name, "should have been rejected as a bot")
+		}
+		if _, ok := h.storer.Users["test@test.com"]; ok {
+			t.Error(name, "should not have created the user")
+		}
+		if h.redirector.Options.RedirectPath != "/ok" {
+			t.Error(name, "the bot should not be told it was rejected")
+		}
+	}
+
+	h, fired := setup()
+	r := mocks.Request("POST", FormValueFormTime, signFormTime(key, time.Now().Add(-time.Minute)))
+	if err := h.reg.Post(h.ab.NewResponse(httptest.NewRecorder()), r); err != nil {
+		t.Fatal(err)
+	}
+	if *fired {
+		t.Error("a person should not be rejected")
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("the user should have been created")
+	}
+}
@@ -0,0 +1,280 @@
+package register
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Double opt-in registration
+const (
+	// PageRegisterVerify is only really used for the BodyReader
+	PageRegisterVerify = "register_verify"
+
+	// EmailRegisterVerifyHTML is the name of the html template for e-mails
+	EmailRegisterVerifyHTML = "register_verify_html"
+	// EmailRegisterVerifyTxt is the name of the text template for e-mails
+	EmailRegisterVerifyTxt = "register_verify_txt"
+
+	// FormValueToken is the name of the form value for the verify token
+	FormValueToken = "token"
+
+	// DataRegisterVerifyURL is the name of the e-mail template variable
+	// that gives the url to send to the user to finish registering.
+	DataRegisterVerifyURL = "url"
+)
+
+// pendingRegistration is what's kept in the verify token until the user
+// follows the link, the password is already hashed
+type pendingRegistration struct {
+	PID         string            `json:"pid"`
+	Password    string            `json:"password"`
+	Arbitrary   map[string]string `json:"arbitrary,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	AccountType string            `json:"account_type,omitempty"`
+	Expires     int64             `json:"expires"`
+}
+
+func (r *Register) initVerify() error {
+	if err := r.Config.Core.MailRenderer.Load(EmailRegisterVerifyHTML, EmailRegisterVerifyTxt); err != nil {
+		return err
+	}
+
+	var callbackMethod func(string, http.Handler)
+	switch r.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = r.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = r.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+	callbackMethod("/register/verify", authboss.CSRFExempt(r.ReadOnlyGuard(r.Paths.ConfirmNotOK, r.Config.Core.ErrorHandler.Wrap(r.VerifyGet))))
+
+	return nil
+}
+
+// startVerification e-mails the user a link to finish registering instead
+// of creating them. The response is the same when the user already exists
+// so that registering can't be used to find accounts.
+func (r *Register) startVerification(w http.ResponseWriter, req *http.Request, user authboss.AuthableUser, arbitrary map[string]string, extra authboss.ExtraFields, accountType string) error {
+	logger := r.RequestLogger(req)
+	pid := user.GetPID()
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: r.RedirectPath(req, "confirm", authboss.RedirectNotOK, nil, r.RequestConfig(req.Context()).Paths.ConfirmNotOK),
+		Success:      r.Localize(req.Context(), authboss.TxtConfirmGeneric),
+	}
+
+	_, err := r.LoadUser(req.Context(), pid)
+	switch {
+	case err == nil:
+		logger.Infof("user %s attempted to re-register", pid)
+		return r.Config.Core.Redirector.Redirect(w, req, ro)
+	case err != authboss.ErrUserNotFound:
+		return err
+	}
+
+	token, err := sealPending(r.Config.Modules.RegisterVerifyKey, pendingRegistration{
+		PID:         pid,
+		Password:    user.GetPassword(),
+		Arbitrary:   arbitrary,
+		Extra:       extra.Strings(),
+		AccountType: accountType,
+		Expires:     r.Now().UTC().Add(r.Config.Modules.RegisterVerifyDuration).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	to := authboss.MustBeConfirmable(user).GetEmail()
+	logger.Infof("sending registration link to user %s", pid)
+	err = r.SendMail(req.Context(), func(ctx context.Context) error {
+		return r.sendVerifyEmail(ctx, to, token)
+	})
+	if _, ok := err.(authboss.MailError); ok {
+		data := authboss.HTMLData{
+			authboss.DataErr:     r.Localize(req.Context(), authboss.TxtRegisterMailFailed),
+			authboss.DataErrCode: authboss.ErrorCodeMailFailed,
+		}
+		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, r.formData(data))
+	} else if err != nil {
+		return err
+	}
+
+	return r.Config.Core.Redirector.Redirect(w, req, ro)
+}
+
+// SendVerifyEmail sends the e-mail with the link to finish registering
+func (r *Register) SendVerifyEmail(ctx context.Context, to, token string) {
+	if err := r.sendVerifyEmail(ctx, to, token); err != nil {
+		r.Logger(ctx).Errorf("%+v", err)
+	}
+}
+
+func (r *Register) sendVerifyEmail(ctx context.Context, to, token string) error {
+	logger := r.Logger(ctx)
+
+	mail := r.RequestConfig(ctx).Mail
+	email := authboss.Email{
+		To:       []string{to},
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + r.Localize(ctx, authboss.TxtConfirmSubject),
+	}
+
+	logger.Infof("sending register verify e-mail to: %s", to)
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataRegisterVerifyURL, r.mailURL(ctx, token)),
+		HTMLTemplate: EmailRegisterVerifyHTML,
+		TextTemplate: EmailRegisterVerifyTxt,
+		Module:       "register",
+	}
+	return errors.Wrapf(r.Email(ctx, email, ro), "failed to send register verify e-mail to %s", to)
+}
+
+// VerifyGet creates the user from the token in a registration link, the
+// user is confirmed since they've proven they own the e-mail address.
+func (r *Register) VerifyGet(w http.ResponseWriter, req *http.Request) error {
+	logger := r.RequestLogger(req)
+
+	validator, err := r.Config.Core.BodyReader.Read(PageRegisterVerify, req)
+	if err != nil {
+		return err
+	}
+
+	if errs := validator.Validate(); errs != nil {
+		logger.Infof("validation failed in Register.VerifyGet, this typically means a bad token: %+v", errs)
+		return r.invalidToken(w, req)
+	}
+
+	values := authboss.MustHaveConfirmValues(validator)
+
+	pending, ok := openPending(r.Config.Modules.RegisterVerifyKey, values.GetToken())
+	if !ok {
+		logger.Info("invalid register verify token submitted")
+		return r.invalidToken(w, req)
+	}
+	if r.Now().UTC().Unix() > pending.Expires {
+		logger.Infof("register verify token for user %s has expired", pending.PID)
+		return r.invalidToken(w, req)
+	}
+
+	storer := authboss.EnsureCanCreate(r.Storer(req.Context()))
+	user := authboss.MustBeAuthable(storer.New(req.Context()))
+
+	user.PutPID(pending.PID)
+	user.PutPassword(pending.Password)
+	authboss.MustBeConfirmable(user).PutConfirmed(true)
+
+	if arbUser, ok := user.(authboss.ArbitraryUser); ok && pending.Arbitrary != nil {
+		arbUser.PutArbitrary(pending.Arbitrary)
+	}
+	if extraUser, ok := user.(authboss.ExtraFieldsUser); ok && pending.Extra != nil {
+		// They were validated when the user registered
+		extra, _ := authboss.ParseExtraFields(r.Config.Modules.RegisterExtraFields, pending.Extra)
+		extraUser.PutExtraFields(extra)
+	}
+	if len(pending.AccountType) != 0 {
+		authboss.MustHaveAccountType(user).PutAccountType(pending.AccountType)
+	}
+
+	err = r.create(w, req, user)
+	if err == authboss.ErrUserFound {
+		logger.Infof("register verify token for user %s was already used", pending.PID)
+		return r.invalidToken(w, req)
+	}
+	return err
+}
+
+func (r *Register) invalidToken(w http.ResponseWriter, req *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      r.Localize(req.Context(), authboss.TxtRegisterLinkInvalid),
+		FailureCode:  authboss.ErrorCodeInvalidToken,
+		RedirectPath: r.RedirectPath(req, "confirm", authboss.RedirectNotOK, nil, r.RequestConfig(req.Context()).Paths.ConfirmNotOK),
+	}
+	return r.Config.Core.Redirector.Redirect(w, req, ro)
+}
+
+func (r *Register) mailURL(ctx context.Context, token string) string {
+	config := r.RequestConfig(ctx)
+	query := url.Values{FormValueToken: []string{token}}
+
+	if len(config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", config.Mail.RootURL+"/register/verify", query.Encode())
+	}
+
+	p := path.Join(config.Paths.Mount, "register/verify")
+	return fmt.Sprintf("%s%s?%s", config.Paths.RootURL, p, query.Encode())
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealPending encrypts the registration into a token, it's the nonce
+// followed by the sealed json.
+func sealPending(key []byte, pending pendingRegistration) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create register verify cipher")
+	}
+
+	plaintext, err := json.Marshal(pending)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal pending registration")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "failed to create register verify nonce")
+	}
+
+	return base64.URLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// openPending decrypts a token made by sealPending
+func openPending(key []byte, token string) (pending pendingRegistration, ok bool) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return pending, false
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil || len(rawToken) < aead.NonceSize() {
+		return pending, false
+	}
+
+	nonce, sealed := rawToken[:aead.NonceSize()], rawToken[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return pending, false
+	}
+
+	if err := json.Unmarshal(plaintext, &pending); err != nil {
+		return pending, false
+	}
+
+	return pending, true
+}
@@ -4,18 +4,43 @@ package remember
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/friendsofgo/errors"
 	"github.com/volatiletech/authboss/v3"
 )
 
 const (
-	nNonceSize = 32
+	// PageRemember is the page used to respond with a remember token in
+	// the body, see Config.Modules.RememberInBody
+	PageRemember = "remember"
+
+	// DataRememberToken is the remember token in the PageRemember response
+	DataRememberToken = "remember_token"
+
+	// HeaderRememberToken is the response header the rotated remember token
+	// is sent in when the client sent its token in the Authorization header
+	HeaderRememberToken = "Remember-Token"
+
+	// authScheme is the Authorization header scheme for remember tokens
+	authScheme = "Remember "
+
+	nFamilySize = 16
+	// nTimesSize is the login and issue times in the token
+	nTimesSize = 16
+	// tokenV1 starts the tokens, it's a NUL byte (which pids never start
+	// with) and the version of the format: v1 is pid;times+nonce with a
+	// nonce of any size. Tokens from before the version was added are
+	// pid;nonce and are treated as logged in and issued now.
+	tokenV1 = "\x00\x01"
 )
 
 func init() {
@@ -27,10 +52,25 @@ type Remember struct {
 	*authboss.Authboss
 }
 
+// ValidateConfig checks the config has what remembering users needs
+func (r *Remember) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Logger", "Storage.Server", "Storage.CookieState")
+	if ab.Config.Modules.RememberInBody {
+		problems = append(problems, ab.Config.Missing("Core.ViewRenderer")...)
+	}
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.RememberingServerStorer)(nil))...)
+}
+
 // Init module
 func (r *Remember) Init(ab *authboss.Authboss) error {
 	r.Authboss = ab
 
+	if r.Config.Modules.RememberInBody {
+		if err := r.Authboss.Config.Core.ViewRenderer.Load(PageRemember); err != nil {
+			return err
+		}
+	}
+
 	r.Events.After(authboss.EventAuth, r.RememberAfterAuth)
 	r.Events.After(authboss.EventOAuth2, r.RememberAfterAuth)
 	r.Events.After(authboss.EventRecoverEnd, r.AfterPasswordReset)
@@ -38,7 +78,9 @@ func (r *Remember) Init(ab *authboss.Authboss) error {
 	return nil
 }
 
-// RememberAfterAuth creates a remember token and saves it in the user's cookies.
+// RememberAfterAuth creates a remember token and saves it in the user's
+// cookies. If Config.Modules.RememberInBody is set and the client asked for
+// it the token is responded with in the body instead.
 func (r *Remember) RememberAfterAuth(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
 	rmIntf := req.Context().Value(authboss.CTXKeyValues)
 	if rmIntf == nil {
@@ -48,16 +90,31 @@ func (r *Remember) RememberAfterAuth(w http.ResponseWriter, req *http.Request, h
 	}
 
 	user := r.Authboss.CurrentUserP(req)
-	hash, token, err := GenerateToken(user.GetPID())
+	now := r.Now()
+	hash, token, err := generateToken(req.Context(), r.Authboss.TokenGenerator(), user.GetPID(), now, now, r.Config.Modules.RememberTokenKey)
 	if err != nil {
 		return false, err
 	}
 
 	storer := authboss.EnsureCanRemember(r.Authboss.Config.Storage.Server)
-	if err = storer.AddRememberToken(req.Context(), user.GetPID(), hash); err != nil {
+	family, err := addToken(req.Context(), storer, user.GetPID(), "", hash)
+	if err != nil {
 		return false, err
 	}
 
+	var name string
+	if namer, ok := rmIntf.(authboss.RememberDeviceValuer); ok {
+		name = namer.GetRememberDeviceName()
+	}
+	if err = touchDevice(req, storer, user.GetPID(), family, name, now); err != nil {
+		return false, err
+	}
+
+	if inBody, ok := rmIntf.(authboss.RememberInBodyValuer); ok && r.Config.Modules.RememberInBody && inBody.GetRememberInBody() {
+		data := authboss.HTMLData{DataRememberToken: token}
+		return true, r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRemember, data)
+	}
+
 	authboss.PutCookie(w, authboss.CookieRemember, token)
 
 	return false, nil
@@ -68,8 +125,9 @@ func (r *Remember) RememberAfterAuth(w http.ResponseWriter, req *http.Request, h
 func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Safely can ignore error here
-			if id, _ := ab.CurrentUserID(r); len(id) == 0 {
+			// Safely can ignore error here, using the token would rotate it
+			// which can't be done in read-only mode.
+			if id, _ := ab.CurrentUserID(r); len(id) == 0 && !ab.Config.Storage.ReadOnly {
 				if err := Authenticate(ab, w, &r); err != nil {
 					logger := ab.RequestLogger(r)
 					logger.Errorf("failed to authenticate user via remember me: %+v", err)
@@ -81,42 +139,57 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 	}
 }
 
-// Authenticate the user using their remember cookie.
+// Authenticate the user using their remember cookie, or the Authorization
+// header when Config.Modules.RememberInBody is set.
 // If the cookie proves unusable it will be deleted. A cookie
 // may be unusable for the following reasons:
 // - Can't decode the base64
 // - Invalid token format
 // - Can't find token in DB
+// - It's past the RememberIdleTimeout or RememberMaxLifetime
 //
 // In order to authenticate it adds to the request context as well as to the
-// cookie and session states.
+// cookie and session states. A token from the header is replaced by sending
+// the new one in the Remember-Token header rather than a cookie.
 func Authenticate(ab *authboss.Authboss, w http.ResponseWriter, req **http.Request) error {
 	logger := ab.RequestLogger(*req)
-	cookie, ok := authboss.GetCookie(*req, authboss.CookieRemember)
-	if !ok {
-		return nil
+	cookie, fromHeader := headerToken(ab, *req)
+	if !fromHeader {
+		var ok bool
+		if cookie, ok = authboss.GetCookie(*req, authboss.CookieRemember); !ok {
+			return nil
+		}
 	}
 
-	rawToken, err := base64.URLEncoding.DecodeString(cookie)
+	rawToken, err := ab.TokenGenerator().Decode(cookie)
 	if err != nil {
 		authboss.DelCookie(w, authboss.CookieRemember)
 		logger.Infof("failed to decode remember me cookie, deleting cookie")
 		return nil
 	}
 
-	index := bytes.IndexByte(rawToken, ';')
-	if index < 0 {
+	now := ab.Now()
+	pid, login, issued, ok := parseToken(rawToken, now)
+	if !ok {
 		authboss.DelCookie(w, authboss.CookieRemember)
 		logger.Infof("failed to decode remember me token, deleting cookie")
 		return nil
 	}
 
-	pid := string(rawToken[:index])
-	sum := sha512.Sum512(rawToken)
-	hash := base64.StdEncoding.EncodeToString(sum[:])
+	hash := hashToken(rawToken, ab.Config.Modules.RememberTokenKey)
 
 	storer := authboss.EnsureCanRemember(ab.Config.Storage.Server)
-	err = storer.UseRememberToken((*req).Context(), pid, hash)
+
+	var family string
+	if familyStorer, ok := storer.(authboss.RememberingFamilyServerStorer); ok {
+		family, err = familyStorer.UseRememberFamilyToken((*req).Context(), pid, hash)
+		if err == authboss.ErrTokenReused {
+			return tokenReused(ab, w, *req, familyStorer, pid, family)
+		}
+	} else {
+		err = storer.UseRememberToken((*req).Context(), pid, hash)
+	}
+
 	switch {
 	case err == authboss.ErrTokenNotFound:
 		logger.Infof("remember me cookie had a token that was not in storage, deleting cookie")
@@ -126,24 +199,158 @@ func Authenticate(ab *authboss.Authboss, w http.ResponseWriter, req **http.Reque
 		return err
 	}
 
-	hash, token, err := GenerateToken(pid)
+	if expired(ab, now, login, issued) {
+		logger.Infof("remember me token for user %s has expired, deleting cookie", pid)
+		authboss.DelCookie(w, authboss.CookieRemember)
+		if familyStorer, ok := storer.(authboss.RememberingFamilyServerStorer); ok && len(family) != 0 {
+			return familyStorer.DelRememberFamily((*req).Context(), pid, family)
+		}
+		return nil
+	}
+
+	hash, token, err := generateToken((*req).Context(), ab.TokenGenerator(), pid, login, now, ab.Config.Modules.RememberTokenKey)
 	if err != nil {
 		return err
 	}
 
-	if err = storer.AddRememberToken((*req).Context(), pid, hash); err != nil {
+	if _, err = addToken((*req).Context(), storer, pid, family, hash); err != nil {
 		return errors.Wrap(err, "failed to save remember me token")
 	}
+	if err = touchDevice(*req, storer, pid, family, "", now); err != nil {
+		return err
+	}
 
 	*req = (*req).WithContext(context.WithValue((*req).Context(), authboss.CTXKeyPID, pid))
 	authboss.PutSession(w, authboss.SessionKey, pid)
 	authboss.PutSession(w, authboss.SessionHalfAuthKey, "true")
+	if _, err = ab.Events.FireAfter(authboss.EventRememberLogin, w, *req); err != nil {
+		return err
+	}
+
+	if fromHeader {
+		w.Header().Set(HeaderRememberToken, token)
+		return nil
+	}
+
 	authboss.DelCookie(w, authboss.CookieRemember)
 	authboss.PutCookie(w, authboss.CookieRemember, token)
 
 	return nil
 }
 
+// headerToken returns the remember token from the Authorization header if
+// Config.Modules.RememberInBody allows it and the client sent one.
+func headerToken(ab *authboss.Authboss, r *http.Request) (string, bool) {
+	if !ab.Config.Modules.RememberInBody {
+		return "", false
+	}
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(authScheme) || !strings.EqualFold(header[:len(authScheme)], authScheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(authScheme):]), true
+}
+
+// expired checks the token against the idle timeout, which slides since
+// every use issues a new token, and the absolute lifetime since the login
+// that started the token's family.
+func expired(ab *authboss.Authboss, now, login, issued time.Time) bool {
+	idle, lifetime := ab.Config.Modules.RememberIdleTimeout, ab.Config.Modules.RememberMaxLifetime
+
+	return (idle > 0 && now.Sub(issued) > idle) || (lifetime > 0 && now.Sub(login) > lifetime)
+}
+
+// tokenReused revokes the whole token family and logs the user out
+// everywhere when a token that was already rotated is used again, since
+// either the user or an attacker has a stolen copy and we can't tell which.
+// The session the thief got from the stolen token goes with them.
+func tokenReused(ab *authboss.Authboss, w http.ResponseWriter, req *http.Request, storer authboss.RememberingFamilyServerStorer, pid, family string) error {
+	logger := ab.RequestLogger(req)
+	logger.Errorf("remember me token reuse detected for user %s, revoking token family and sessions", pid)
+
+	authboss.DelCookie(w, authboss.CookieRemember)
+	authboss.DelAllSession(w, ab.Config.Storage.SessionStateWhitelistKeys)
+	authboss.DelKnownSession(w)
+
+	if err := storer.DelRememberFamily(req.Context(), pid, family); err != nil {
+		return errors.Wrap(err, "failed to revoke remember me token family")
+	}
+	if err := ab.RevokeSessions(req.Context(), pid); err != nil {
+		return errors.Wrap(err, "failed to revoke sessions")
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyValues, ReuseValues{PID: pid, Family: family}))
+	_, err := ab.Events.FireAfter(authboss.EventTokenReuse, w, req)
+	return err
+}
+
+// ReuseValues is put in the context as CTXKeyValues for EventTokenReuse.
+// The user is not logged in during the event, PID identifies them.
+type ReuseValues struct {
+	PID    string
+	Family string
+}
+
+// Validate is a noop, the values do not come from the user
+func (ReuseValues) Validate() []error { return nil }
+
+// addToken adds the token to the family if the storer supports families,
+// starting a new family if family is empty. It returns the family the token
+// was added to.
+func addToken(ctx context.Context, storer authboss.RememberingServerStorer, pid, family, hash string) (string, error) {
+	familyStorer, ok := storer.(authboss.RememberingFamilyServerStorer)
+	if !ok {
+		return "", storer.AddRememberToken(ctx, pid, hash)
+	}
+
+	if len(family) == 0 {
+		var err error
+		if family, err = generateFamily(); err != nil {
+			return "", err
+		}
+	}
+
+	return family, familyStorer.AddRememberFamilyToken(ctx, pid, family, hash)
+}
+
+// touchDevice records that the family's device was just used if the storer
+// keeps devices. The name is only used for a device that's not stored yet.
+func touchDevice(req *http.Request, storer authboss.RememberingServerStorer, pid, family, name string, now time.Time) error {
+	deviceStorer, ok := storer.(authboss.RememberingDeviceServerStorer)
+	if !ok || len(family) == 0 {
+		return nil
+	}
+
+	devices, err := deviceStorer.LoadRememberDevices(req.Context(), pid)
+	if err != nil {
+		return errors.Wrap(err, "failed to load remember me devices")
+	}
+
+	device := authboss.RememberDevice{Family: family, Name: name}
+	for _, d := range devices {
+		if d.Family == family {
+			device = d
+			break
+		}
+	}
+
+	device.UserAgent = req.UserAgent()
+	device.LastUsed = now.UTC()
+
+	return errors.Wrap(deviceStorer.PutRememberDevice(req.Context(), pid, device), "failed to save remember me device")
+}
+
+func generateFamily() (string, error) {
+	family := make([]byte, nFamilySize)
+	if _, err := io.ReadFull(rand.Reader, family); err != nil {
+		return "", errors.Wrap(err, "failed to create remember me token family")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(family), nil
+}
+
 // AfterPasswordReset is called after the password has been reset, since
 // it should invalidate all tokens associated to that user.
 func (r *Remember) AfterPasswordReset(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
@@ -163,16 +370,73 @@ func (r *Remember) AfterPasswordReset(w http.ResponseWriter, req *http.Request,
 	return false, storer.DelRememberTokens(req.Context(), pid)
 }
 
-// GenerateToken creates a remember me token
+// GenerateToken creates a remember me token with the
+// authboss.DefaultTokenGenerator, the hash is the one used when
+// Config.Modules.RememberTokenKey is not set.
 func GenerateToken(pid string) (hash string, token string, err error) {
-	rawToken := make([]byte, nNonceSize+len(pid)+1)
-	copy(rawToken, pid)
-	rawToken[len(pid)] = ';'
+	now := time.Now()
+	return generateToken(context.Background(), authboss.DefaultTokenGenerator{}, pid, now, now, nil)
+}
 
-	if _, err := io.ReadFull(rand.Reader, rawToken[len(pid)+1:]); err != nil {
+// generateToken creates a token for a family that started with a login at
+// the given time, its nonce and encoding are the TokenGenerator's.
+func generateToken(ctx context.Context, tokens authboss.TokenGenerator, pid string, login, issued time.Time, key []byte) (hash string, token string, err error) {
+	nonce, err := tokens.NewToken(ctx, authboss.TokenRemember)
+	if err != nil {
 		return "", "", errors.Wrap(err, "failed to create remember me nonce")
 	}
 
-	sum := sha512.Sum512(rawToken)
-	return base64.StdEncoding.EncodeToString(sum[:]), base64.URLEncoding.EncodeToString(rawToken), nil
+	size := len(tokenV1) + len(pid) + 1 + nTimesSize
+	rawToken := make([]byte, size, size+len(nonce))
+	copy(rawToken, tokenV1)
+	copy(rawToken[len(tokenV1):], pid)
+	rawToken[len(tokenV1)+len(pid)] = ';'
+
+	times := rawToken[size-nTimesSize:]
+	binary.BigEndian.PutUint64(times, uint64(login.Unix()))
+	binary.BigEndian.PutUint64(times[8:], uint64(issued.Unix()))
+	rawToken = append(rawToken, nonce...)
+
+	return hashToken(rawToken, key), tokens.Encode(rawToken), nil
+}
+
+// parseToken reads the pid and the login and issue times from a token.
+// Tokens from before the times were added get now for both.
+func parseToken(rawToken []byte, now time.Time) (pid string, login, issued time.Time, ok bool) {
+	versioned := bytes.HasPrefix(rawToken, []byte(tokenV1))
+	if versioned {
+		rawToken = rawToken[len(tokenV1):]
+	}
+
+	index := bytes.IndexByte(rawToken, ';')
+	if index < 0 {
+		return "", time.Time{}, time.Time{}, false
+	}
+
+	pid = string(rawToken[:index])
+	rest := rawToken[index+1:]
+
+	switch {
+	case !versioned:
+		login, issued = now, now
+	case len(rest) > nTimesSize:
+		login = time.Unix(int64(binary.BigEndian.Uint64(rest)), 0)
+		issued = time.Unix(int64(binary.BigEndian.Uint64(rest[8:])), 0)
+	default:
+		return "", time.Time{}, time.Time{}, false
+	}
+
+	return pid, login, issued, true
+}
+
+// hashToken with HMAC-SHA512 if there's a key, otherwise SHA512
+func hashToken(rawToken, key []byte) string {
+	if len(key) == 0 {
+		sum := sha512.Sum512(rawToken)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha512.New, key)
+	_, _ = mac.Write(rawToken)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
 }
@@ -5,9 +5,11 @@ import (
 	"context"
 	"crypto/sha512"
 	"encoding/base64"
+	"encoding/binary"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/volatiletech/authboss/v3"
 	"github.com/volatiletech/authboss/v3/mocks"
@@ -82,6 +84,100 @@ func TestRememberAfterAuth(t *testing.T) {
 	}
 }
 
+func TestRememberAfterAuthInBody(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.RememberInBody = true
+	responder := &mocks.Responder{}
+	h.ab.Config.Core.Responder = responder
+
+	user := &mocks.User{Email: "test@test.com"}
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{Remember: true, InBody: true}))
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if handled, err := h.remember.RememberAfterAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	} else if !handled {
+		t.Error("it should have responded with the token")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if len(h.storer.RMTokens["test@test.com"]) != 1 {
+		t.Error("token was not persisted:", h.storer.RMTokens)
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("remember me cookie should not be set")
+	}
+	if responder.Page != PageRemember || len(responder.Data[DataRememberToken].(string)) == 0 {
+		t.Errorf("the token should be in the body: %s %#v", responder.Page, responder.Data)
+	}
+}
+
+func TestRememberDevices(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.RememberTokenKey = []byte("key")
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	r := mocks.Request("POST")
+	r.Header.Set("User-Agent", "login-agent")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{Remember: true, DeviceName: "laptop"}))
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if _, err := h.remember.RememberAfterAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	devices := h.storer.RMDevices[user.Email]
+	if len(devices) != 1 {
+		t.Fatal("the device should have been saved:", devices)
+	}
+	device := devices[0]
+	if device.Name != "laptop" || device.UserAgent != "login-agent" || len(device.Family) == 0 || device.LastUsed.IsZero() {
+		t.Errorf("device was wrong: %#v", device)
+	}
+
+	rawToken, _ := base64.URLEncoding.DecodeString(h.cookies.ClientValues[authboss.CookieRemember])
+	if sum := sha512.Sum512(rawToken); h.storer.RMTokens[user.Email][0] == base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Error("the token should be hashed with the key")
+	}
+
+	r = mocks.Request("POST")
+	r.Header.Set("User-Agent", "later-agent")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Context().Value(authboss.CTXKeyPID) != user.Email {
+		t.Fatal("the keyed token should log the user in")
+	}
+
+	devices = h.storer.RMDevices[user.Email]
+	if len(devices) != 1 || devices[0].Name != "laptop" || devices[0].UserAgent != "later-agent" {
+		t.Errorf("device should have been updated: %#v", devices)
+	}
+
+	if err = h.storer.DelRememberFamily(context.Background(), user.Email, device.Family); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.storer.RMDevices[user.Email]) != 0 || len(h.storer.RMTokens[user.Email]) != 0 {
+		t.Error("revoking the family should revoke the device")
+	}
+}
+
 func TestRememberAfterAuthSkip(t *testing.T) {
 	t.Parallel()
 
@@ -166,6 +262,12 @@ func TestAuthenticateSuccess(t *testing.T) {
 	h.storer.RMTokens[user.Email] = []string{hash}
 	h.cookies.ClientValues[authboss.CookieRemember] = token
 
+	var loggedIn interface{}
+	h.ab.Events.After(authboss.EventRememberLogin, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		loggedIn = r.Context().Value(authboss.CTXKeyPID)
+		return false, nil
+	})
+
 	r := mocks.Request("POST")
 	rec := httptest.NewRecorder()
 	w := h.ab.NewResponse(rec)
@@ -182,6 +284,9 @@ func TestAuthenticateSuccess(t *testing.T) {
 
 	w.WriteHeader(http.StatusOK)
 
+	if loggedIn != user.Email {
+		t.Error("EventRememberLogin should have been fired with the pid:", loggedIn)
+	}
 	if cookie := h.cookies.ClientValues[authboss.CookieRemember]; cookie == token {
 		t.Error("the cookie should have been replaced with a new token")
 	}
@@ -204,6 +309,49 @@ func TestAuthenticateSuccess(t *testing.T) {
 	}
 }
 
+func TestAuthenticateHeader(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	hash, token, _ := GenerateToken(user.Email)
+
+	h.storer.Users[user.Email] = user
+	h.storer.RMTokens[user.Email] = []string{hash}
+
+	r := mocks.Request("POST")
+	r.Header.Set("Authorization", "Remember "+token)
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	if err := Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Context().Value(authboss.CTXKeyPID) != nil {
+		t.Error("the header should be ignored unless RememberInBody is set")
+	}
+
+	h.ab.Config.Modules.RememberInBody = true
+	if err := Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if pid, _ := r.Context().Value(authboss.CTXKeyPID).(string); pid != user.Email {
+		t.Error("should have set the context value to log the user in")
+	}
+	if newToken := rec.Header().Get(HeaderRememberToken); len(newToken) == 0 || newToken == token {
+		t.Error("the new token should be in the header:", newToken)
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("remember me cookie should not be set")
+	}
+	if len(h.storer.RMTokens[user.Email]) != 1 || h.storer.RMTokens[user.Email][0] == hash {
+		t.Error("the token should have been rotated:", h.storer.RMTokens[user.Email])
+	}
+}
+
 func TestAuthenticateTokenNotFound(t *testing.T) {
 	t.Parallel()
 
@@ -291,6 +439,113 @@ func TestAuthenticateBadTokens(t *testing.T) {
 	})
 }
 
+func TestAuthenticateFamilyRotation(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	hash, token, _ := GenerateToken(user.Email)
+
+	h.storer.Users[user.Email] = user
+	if err := h.storer.AddRememberFamilyToken(context.Background(), user.Email, "family", hash); err != nil {
+		t.Fatal(err)
+	}
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := h.storer.RMTokens[user.Email]
+	if len(tokens) != 1 {
+		t.Fatal("the token should have been rotated:", tokens)
+	}
+	if family := h.storer.RMFamilies[tokens[0]]; family != "family" {
+		t.Error("the new token should have joined the family:", family)
+	}
+	if family := h.storer.RMUsed[hash]; family != "family" {
+		t.Error("the used token should have been kept:", family)
+	}
+}
+
+func TestAuthenticateTokenReuse(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	hash, token, _ := GenerateToken(user.Email)
+	newHash, _, _ := GenerateToken(user.Email)
+	otherHash, _, _ := GenerateToken(user.Email)
+
+	h.storer.Users[user.Email] = user
+	h.storer.RMTokens[user.Email] = []string{newHash, otherHash}
+	h.storer.RMFamilies[newHash] = "family"
+	h.storer.RMFamilies[otherHash] = "other"
+	h.storer.RMUsed[hash] = "family"
+	h.storer.Sessions[user.Email] = []authboss.SessionRecord{{ID: "thief"}}
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+	h.session.ClientValues[authboss.SessionLastAction] = "1"
+
+	var reuse ReuseValues
+	h.ab.Events.After(authboss.EventTokenReuse, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		reuse = r.Context().Value(authboss.CTXKeyValues).(ReuseValues)
+		return false, nil
+	})
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if reuse.PID != user.Email || reuse.Family != "family" {
+		t.Errorf("the reuse event should have fired: %#v", reuse)
+	}
+	if tokens := h.storer.RMTokens[user.Email]; len(tokens) != 0 {
+		t.Error("the user should have been logged out everywhere:", tokens)
+	}
+	if sessions := h.storer.Sessions[user.Email]; len(sessions) != 0 {
+		t.Error("the session records should have been revoked:", sessions)
+	}
+	if _, ok := h.storer.RMUsed[hash]; ok {
+		t.Error("the used tokens of the family should have been deleted")
+	}
+	if _, ok := h.cookies.ClientValues[authboss.CookieRemember]; ok {
+		t.Error("the cookie should have been deleted")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the user should not have been logged in")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionLastAction]; ok {
+		t.Error("the session should have been cleared")
+	}
+	if r.Context().Value(authboss.CTXKeyPID) != nil {
+		t.Error("the user should not have been put in the context")
+	}
+}
+
 func TestAfterPasswordReset(t *testing.T) {
 	t.Parallel()
 
@@ -338,12 +593,16 @@ func TestGenerateToken(t *testing.T) {
 		t.Error(err)
 	}
 
+	if !bytes.HasPrefix(rawToken, []byte(tokenV1)) {
+		t.Fatalf("the token should start with its version: %v", rawToken)
+	}
+
 	index := bytes.IndexByte(rawToken, ';')
 	if index < 0 {
 		t.Fatalf("problem with the token format: %v", rawToken)
 	}
 
-	bytPID := rawToken[:index]
+	bytPID := rawToken[len(tokenV1):index]
 	if string(bytPID) != "test" {
 		t.Errorf("pid wrong: %s", bytPID)
 	}
@@ -354,3 +613,119 @@ func TestGenerateToken(t *testing.T) {
 		t.Errorf("hash wrong, want: %s, got: %s", hash, gotHash)
 	}
 }
+
+func timedToken(pid string, login, issued time.Time) (hash, token string) {
+	return rawTimedToken(tokenV1+pid, login, issued, 64)
+}
+
+func rawTimedToken(prefix string, login, issued time.Time, nonceSize int) (hash, token string) {
+	rawToken := make([]byte, len(prefix)+1+nTimesSize+nonceSize)
+	copy(rawToken, prefix)
+	rawToken[len(prefix)] = ';'
+	binary.BigEndian.PutUint64(rawToken[len(prefix)+1:], uint64(login.Unix()))
+	binary.BigEndian.PutUint64(rawToken[len(prefix)+9:], uint64(issued.Unix()))
+
+	return hashToken(rawToken, nil), base64.URLEncoding.EncodeToString(rawToken)
+}
+
+func TestParseToken(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Truncate(time.Second)
+	login, issued := now.Add(-20*time.Hour), now.Add(-time.Hour)
+
+	tests := []struct {
+		Name                 string
+		Prefix               string
+		NonceSize            int
+		WantLogin, WantIssue time.Time
+	}{
+		{"Versioned", tokenV1 + "test", 64, login, issued},
+		{"VersionedSmallNonce", tokenV1 + "test", 16, login, issued},
+		// Whatever follows the pid of an unversioned token is a nonce
+		{"Unversioned", "test", 32, now, now},
+	}
+
+	for _, test := range tests {
+		_, token := rawTimedToken(test.Prefix, login, issued, test.NonceSize)
+		rawToken, _ := base64.URLEncoding.DecodeString(token)
+
+		pid, gotLogin, gotIssued, ok := parseToken(rawToken, now)
+		if !ok || pid != "test" {
+			t.Errorf("%s: failed to parse: %q %t", test.Name, pid, ok)
+			continue
+		}
+		if !gotLogin.Equal(test.WantLogin) || !gotIssued.Equal(test.WantIssue) {
+			t.Errorf("%s: times were wrong: %v %v", test.Name, gotLogin, gotIssued)
+		}
+	}
+
+	if _, _, _, ok := parseToken([]byte(tokenV1+"test;short"), now); ok {
+		t.Error("versioned tokens without times should be rejected")
+	}
+}
+
+func TestAuthenticateExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	tests := []struct {
+		Name          string
+		Login, Issued time.Time
+		LoggedIn      bool
+	}{
+		{"Active", now.Add(-20 * time.Hour), now.Add(-30 * time.Minute), true},
+		{"Idle", now.Add(-20 * time.Hour), now.Add(-2 * time.Hour), false},
+		{"Lifetime", now.Add(-25 * time.Hour), now.Add(-time.Minute), false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			h := testSetup()
+			h.ab.Config.Modules.RememberIdleTimeout = time.Hour
+			h.ab.Config.Modules.RememberMaxLifetime = 24 * time.Hour
+
+			user := &mocks.User{Email: "test@test.com"}
+			hash, token := timedToken(user.Email, test.Login, test.Issued)
+
+			h.storer.Users[user.Email] = user
+			if err := h.storer.AddRememberFamilyToken(context.Background(), user.Email, "family", hash); err != nil {
+				t.Fatal(err)
+			}
+			h.cookies.ClientValues[authboss.CookieRemember] = token
+
+			r := mocks.Request("POST")
+			w := h.ab.NewResponse(httptest.NewRecorder())
+			r, err := h.ab.LoadClientState(w, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = Authenticate(h.ab, w, &r); err != nil {
+				t.Fatal(err)
+			}
+			w.WriteHeader(http.StatusOK)
+
+			if loggedIn := r.Context().Value(authboss.CTXKeyPID) != nil; loggedIn != test.LoggedIn {
+				t.Fatal("logged in should be:", test.LoggedIn)
+			}
+			if !test.LoggedIn {
+				if len(h.storer.RMTokens[user.Email]) != 0 || len(h.cookies.ClientValues[authboss.CookieRemember]) != 0 {
+					t.Error("the expired family and cookie should be deleted")
+				}
+				return
+			}
+
+			rawToken, _ := base64.URLEncoding.DecodeString(h.cookies.ClientValues[authboss.CookieRemember])
+			times := rawToken[len(tokenV1)+len(user.Email)+1:]
+			if login := int64(binary.BigEndian.Uint64(times)); login != test.Login.Unix() {
+				t.Error("the rotated token should keep the login time")
+			}
+			if issued := int64(binary.BigEndian.Uint64(times[8:])); issued < now.Unix() {
+				t.Error("the rotated token should be issued now")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Package rename lets logged in users change their username, or their pid
+// if Config.Modules.RenamePID is set.
+//
+// GET /rename shows the user's current name and the new one is posted to
+// it. Names are normalized with Config.Modules.PIDNormalizer and must not
+// be used by another user as their pid or username, which is checked with
+// the RenamingServerStorer's UserExists. Users can only change their name
+// once every Config.Modules.RenameCooldown. EventRename is fired after the
+// change so the app can update whatever refers to the old name.
+//
+// Users must be authboss.RenamableUsers.
+package rename
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// PageRename shows the user's current name, it's also for identifying
+	// the request to change it for parsing & validation
+	PageRename = "rename"
+
+	// FormValueNewName is the name of the form value for the new name
+	FormValueNewName = "new_name"
+
+	// DataName is the user's current name
+	DataName = "name"
+)
+
+func init() {
+	authboss.RegisterModule("rename", &Rename{})
+}
+
+// Rename module
+type Rename struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what changing names needs
+func (n *Rename) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Redirector", "Core.Logger", "Storage.Server")
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.RenamingServerStorer)(nil))...)
+}
+
+// Init module
+func (n *Rename) Init(ab *authboss.Authboss) error {
+	n.Authboss = ab
+
+	if err := n.Config.Core.ViewRenderer.Load(PageRename); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if ab.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = ab.Config.Modules.ResponseOnUnauthed
+	} else if ab.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	middleware := authboss.MountedMiddleware2(ab, true, authboss.RequireFullAuth, unauthedResponse)
+
+	n.Config.Core.Router.Get("/rename", middleware(n.Core.ErrorHandler.Wrap(n.Get)))
+	n.Config.Core.Router.Post("/rename", middleware(n.Core.ErrorHandler.Wrap(n.Post)))
+
+	return nil
+}
+
+// Get shows the current user's name
+func (n *Rename) Get(w http.ResponseWriter, r *http.Request) error {
+	user, err := n.currentUser(r)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{DataName: n.name(user)}
+	return n.Core.Responder.Respond(w, r, http.StatusOK, PageRename, data)
+}
+
+// Post changes the current user's name
+func (n *Rename) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := n.RequestLogger(r)
+
+	user, err := n.currentUser(r)
+	if err != nil {
+		return err
+	}
+	oldName := n.name(user)
+
+	validatable, err := n.Core.BodyReader.Read(PageRename, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("rename validation failed: %+v", errs)
+		data := authboss.HTMLData{
+			authboss.DataValidation: n.LocalizeErrors(r.Context(), errs),
+			DataName:                oldName,
+		}
+		return n.Core.Responder.Respond(w, r, http.StatusOK, PageRename, data)
+	}
+
+	newName := n.NormalizePID(strings.TrimSpace(authboss.MustHaveRenameValues(validatable).GetNewName()))
+	switch {
+	case len(newName) == 0:
+		return n.invalid(w, r, oldName, authboss.TxtRequired)
+	case newName == oldName:
+		return n.invalid(w, r, oldName, authboss.TxtRenameSame)
+	}
+
+	if last := user.GetLastRename(); !last.IsZero() && n.Now().Before(last.Add(n.Config.Modules.RenameCooldown)) {
+		logger.Infof("user %s tried to change their name again too soon", user.GetPID())
+		data := authboss.HTMLData{
+			authboss.DataErr: n.Localize(r.Context(), authboss.TxtRenameCooldown),
+			DataName:         oldName,
+		}
+		return n.Core.Responder.Respond(w, r, http.StatusOK, PageRename, data)
+	}
+
+	storer := authboss.EnsureCanRename(n.Storer(r.Context()))
+	exists, err := storer.UserExists(r.Context(), newName)
+	if err != nil {
+		return err
+	} else if exists {
+		logger.Infof("user %s tried to change their name to one that's taken", user.GetPID())
+		return n.invalid(w, r, oldName, authboss.TxtRenameTaken)
+	}
+
+	user.PutLastRename(n.Now())
+	if n.Config.Modules.RenamePID {
+		user.PutPID(newName)
+		err = storer.ChangePID(r.Context(), oldName, user)
+		if err == authboss.ErrUserFound {
+			// Someone else took it since it was checked
+			user.PutPID(oldName)
+			return n.invalid(w, r, oldName, authboss.TxtRenameTaken)
+		} else if err != nil {
+			return err
+		}
+
+		// Remember tokens and session records are stored under the old pid
+		if err := n.Authboss.RevokeSessions(r.Context(), oldName); err != nil {
+			return err
+		}
+		authboss.PutSession(w, authboss.SessionKey, newName)
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyPID, newName))
+	} else {
+		user.PutUsername(newName)
+		if err := n.Authboss.SaveUser(r.Context(), user); err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("user %s changed their name from %s to %s", user.GetPID(), oldName, newName)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyRenamedFrom, oldName))
+	handled, err := n.Events.FireAfter(authboss.EventRename, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Success:      n.Localize(r.Context(), authboss.TxtRenamed),
+		RedirectPath: n.Authboss.RedirectPath(r, "rename", authboss.RedirectOK, user, n.RequestConfig(r.Context()).Paths.RenameOK),
+	}
+	return n.Core.Redirector.Redirect(w, r, ro)
+}
+
+// name is what's being renamed, the user's pid or their username
+func (n *Rename) name(user authboss.RenamableUser) string {
+	if n.Config.Modules.RenamePID {
+		return user.GetPID()
+	}
+	return user.GetUsername()
+}
+
+func (n *Rename) invalid(w http.ResponseWriter, r *http.Request, oldName string, message authboss.LocalizationKey) error {
+	data := authboss.HTMLData{
+		authboss.DataValidation: map[string][]string{FormValueNewName: {n.Localize(r.Context(), message)}},
+		DataName:                oldName,
+	}
+	return n.Core.Responder.Respond(w, r, http.StatusOK, PageRename, data)
+}
+
+func (n *Rename) currentUser(r *http.Request) (authboss.RenamableUser, error) {
+	user, err := n.CurrentUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ru, ok := user.(authboss.RenamableUser)
+	if !ok {
+		return nil, errors.Errorf("user %s is not an authboss.RenamableUser (%T)", user.GetPID(), user)
+	}
+	return ru, nil
+}
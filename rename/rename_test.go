@@ -0,0 +1,206 @@
+package rename
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	if err := (&Rename{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageRename); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/rename"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/rename"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	rename *Rename
+	ab     *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	clock      *authtest.Clock
+	redirector *mocks.Redirector
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+
+	renamedFrom string
+}
+
+func testSetup() *testHarness {
+	h := &testHarness{}
+
+	h.ab = authboss.New()
+	h.bodyReader = &mocks.BodyReader{}
+	h.clock = authtest.NewClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.redirector = &mocks.Redirector{}
+	h.responder = &mocks.Responder{}
+	h.session = mocks.NewClientRW()
+	h.storer = mocks.NewServerStorer()
+
+	h.ab.Config.Core.BodyReader = h.bodyReader
+	h.ab.Config.Core.Clock = h.clock
+	h.ab.Config.Core.Logger = mocks.Logger{}
+	h.ab.Config.Core.Redirector = h.redirector
+	h.ab.Config.Core.Responder = h.responder
+	h.ab.Config.Modules.PIDNormalizer = strings.ToLower
+	h.ab.Config.Storage.Server = h.storer
+	h.ab.Config.Storage.SessionState = h.session
+
+	h.ab.Events.After(authboss.EventRename, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		h.renamedFrom = r.Context().Value(authboss.CTXKeyRenamedFrom).(string)
+		return false, nil
+	})
+
+	h.rename = &Rename{h.ab}
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com", Username: "test"}
+	h.storer.Users["other@test.com"] = &mocks.User{Email: "other@test.com", Username: "other"}
+
+	return h
+}
+
+func (h *testHarness) post(t *testing.T, name string) {
+	t.Helper()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r := mocks.Request("POST")
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, h.storer.Users["test@test.com"]))
+
+	h.bodyReader.Return = mocks.Values{NewName: name}
+	if err := h.rename.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush the session
+}
+
+func (h *testHarness) validationError() string {
+	errs, _ := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if len(errs[FormValueNewName]) == 0 {
+		return ""
+	}
+	return errs[FormValueNewName][0]
+}
+
+func TestPostUsername(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.storer.Users["test@test.com"]
+
+	h.post(t, " New ")
+	if user.Username != "new" {
+		t.Error("the username should be changed and normalized:", user.Username)
+	}
+	if !user.LastRename.Equal(h.clock.Now()) {
+		t.Error("the time of the change should be saved:", user.LastRename)
+	}
+	if h.renamedFrom != "test" {
+		t.Error("the event should have the old name:", h.renamedFrom)
+	}
+	if opts := h.redirector.Options; opts.RedirectPath != h.ab.Config.Paths.RenameOK || len(opts.Success) == 0 {
+		t.Errorf("redirect was wrong: %#v", opts)
+	}
+}
+
+func TestPostPID(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.RenamePID = true
+	user := h.storer.Users["test@test.com"]
+	h.storer.RMTokens["test@test.com"] = []string{"token"}
+
+	h.post(t, "new@test.com")
+	if h.storer.Users["new@test.com"] != user || h.storer.Users["test@test.com"] != nil {
+		t.Error("the user should be moved to the new pid")
+	}
+	if h.session.ClientValues[authboss.SessionKey] != "new@test.com" {
+		t.Error("the session should have the new pid:", h.session.ClientValues)
+	}
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("the remember tokens of the old pid should be deleted")
+	}
+	if h.renamedFrom != "test@test.com" {
+		t.Error("the event should have the old pid:", h.renamedFrom)
+	}
+}
+
+func TestPostInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name string
+		Want authboss.LocalizationKey
+	}{
+		{"", authboss.TxtRequired},
+		{"TEST", authboss.TxtRenameSame},
+		{"Other", authboss.TxtRenameTaken},
+		{"other@test.com", authboss.TxtRenameTaken},
+	}
+
+	for _, test := range tests {
+		h := testSetup()
+
+		h.post(t, test.Name)
+		if got := h.validationError(); got != test.Want.Default {
+			t.Errorf("%q: validation error was wrong: %q", test.Name, got)
+		}
+		if user := h.storer.Users["test@test.com"]; user.Username != "test" || !user.LastRename.IsZero() {
+			t.Errorf("%q: the user should not be changed: %#v", test.Name, user)
+		}
+	}
+}
+
+func TestPostCooldown(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := h.storer.Users["test@test.com"]
+
+	h.post(t, "first")
+	h.clock.Advance(h.ab.Config.Modules.RenameCooldown - time.Second)
+	h.post(t, "second")
+	if user.Username != "first" {
+		t.Error("the name should not change again during the cooldown")
+	}
+	if h.responder.Data[authboss.DataErr] != authboss.TxtRenameCooldown.Default {
+		t.Error("the cooldown error should be shown:", h.responder.Data)
+	}
+
+	h.clock.Advance(time.Second)
+	h.post(t, "second")
+	if user.Username != "second" {
+		t.Error("the name should change after the cooldown:", user.Username)
+	}
+}
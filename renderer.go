@@ -1,6 +1,20 @@
 package authboss
 
-import "context"
+import (
+	"context"
+
+	"github.com/friendsofgo/errors"
+)
+
+// ErrNoRender is returned when something tries to render a template in
+// NoRender mode, see Config.Core.NoRender.
+var ErrNoRender = errors.New("authboss is in NoRender mode and has no renderer")
+
+// ErrTemplateNotFound can be returned by a MailRenderer for an e-mail
+// template it has no file for, Email makes that body from the other one.
+// The defaults' Responder falls back to the full page when a ViewRenderer
+// returns it for a partial template.
+var ErrTemplateNotFound = errors.New("template not found")
 
 // Renderer is a type that can render a given template with some data.
 type Renderer interface {
@@ -10,3 +24,22 @@ type Renderer interface {
 	// Render the given template
 	Render(ctx context.Context, page string, data HTMLData) (output []byte, contentType string, err error)
 }
+
+// noRenderer stands in for a Renderer that wasn't set in NoRender mode
+// so that mistakes are errors rather than nil pointer panics.
+type noRenderer struct {
+	// mail is set for the MailRenderer, e-mails can't be sent without
+	// templates so it fails when templates are loaded to fail on Init.
+	mail bool
+}
+
+func (n noRenderer) Load(names ...string) error {
+	if n.mail && len(names) != 0 {
+		return errors.Wrapf(ErrNoRender, "a MailRenderer is required to send e-mails %v", names)
+	}
+	return nil
+}
+
+func (n noRenderer) Render(ctx context.Context, page string, data HTMLData) ([]byte, string, error) {
+	return nil, "", errors.Wrapf(ErrNoRender, "failed to render %q", page)
+}
@@ -0,0 +1,27 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+)
+
+// ConfigOverride changes the config for one request, it's given a copy of
+// Authboss.Config. The maps in the copy (like Paths.Redirects) are still
+// shared so they must be replaced rather than changed. An error stops the
+// request, see LoadClientState.
+//
+// Modules use the request's config for Paths (other than Paths.Mount),
+// which includes the redirects and the root url of the links they e-mail,
+// and for Mail. Everything else is read from Authboss.Config.
+type ConfigOverride func(r *http.Request, config *Config) error
+
+// RequestConfig returns the config Config.Core.ConfigOverride made for the
+// request whose context this is, or Authboss.Config when there's none.
+// The Paths.RootURL of requests from trusted proxies is the one they were
+// made to, see ForwardedRootURL, the override can still change it.
+func (a *Authboss) RequestConfig(ctx context.Context) *Config {
+	if config, ok := ctx.Value(CTXKeyConfig).(*Config); ok {
+		return config
+	}
+	return &a.Config
+}
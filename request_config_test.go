@@ -0,0 +1,71 @@
+package authboss
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestConfig(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Mail.From = "auth@example.com"
+	ab.Config.Core.TenantResolver = TenantFromHost()
+	ab.Config.Core.ConfigOverride = func(r *http.Request, config *Config) error {
+		switch Tenant(r.Context()) {
+		case "brand.test":
+			config.Mail.From = "auth@brand.test"
+			config.Paths.RootURL = "https://brand.test"
+		case "unknown.test":
+			return ErrTenantNotFound
+		}
+		return nil
+	}
+
+	r := httptest.NewRequest("GET", "http://brand.test/", nil)
+	if config := ab.RequestConfig(r.Context()); config != &ab.Config {
+		t.Error("the config should be Authboss.Config before the client state is loaded")
+	}
+
+	r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ab.RequestConfig(r.Context())
+	if config.Mail.From != "auth@brand.test" || config.Paths.RootURL != "https://brand.test" {
+		t.Errorf("the config should be overridden: %#v %#v", config.Mail, config.Paths)
+	}
+	if ab.Config.Mail.From != "auth@example.com" {
+		t.Error("Authboss.Config should not change:", ab.Config.Mail.From)
+	}
+
+	r = httptest.NewRequest("GET", "http://unknown.test/", nil)
+	if _, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), r); !errors.Is(err, ErrTenantNotFound) {
+		t.Error("the override's error should be returned:", err)
+	}
+}
+
+func TestRequestConfigRedirectPath(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.ConfigOverride = func(r *http.Request, config *Config) error {
+		config.Paths.Redirects = map[RedirectKey]RedirectFunc{
+			{Module: "auth", Outcome: RedirectOK}: RedirectTo("/brand"),
+		}
+		return nil
+	}
+
+	r, err := ab.LoadClientState(ab.NewResponse(httptest.NewRecorder()), httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := ab.RedirectPath(r, "auth", RedirectOK, nil, "/"); p != "/brand" {
+		t.Error("the request's redirects should be used:", p)
+	}
+	if p := ab.RedirectPath(httptest.NewRequest("GET", "/", nil), "auth", RedirectOK, nil, "/"); p != "/" {
+		t.Error("other requests should use the default:", p)
+	}
+}
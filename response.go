@@ -3,6 +3,7 @@ package authboss
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/friendsofgo/errors"
 )
@@ -40,6 +41,8 @@ type RedirectOptions struct {
 	// if set. They should be mutually exclusive.
 	Success string
 	Failure string
+	// FailureCode is the ErrorCode of the Failure for API clients
+	FailureCode ErrorCode
 
 	// Code is used when it's an API request instead of 200.
 	Code int
@@ -61,10 +64,16 @@ type EmailResponseOptions struct {
 	Data         HTMLData
 	HTMLTemplate string
 	TextTemplate string
+
+	// Module is the name of the module sending the e-mail, it's given to
+	// the MailRenderer as DataModule so it can find the module's templates.
+	Module string
 }
 
 // Email renders the e-mail templates for the given email and
-// sends it using the mailer.
+// sends it using the mailer. Every e-mail is sent with both an html and a
+// text body, when only one of them has a template the other is made
+// from it.
 func (a *Authboss) Email(ctx context.Context, email Email, ro EmailResponseOptions) error {
 	ctxData := ctx.Value(CTXKeyData)
 	if ctxData != nil {
@@ -73,9 +82,21 @@ func (a *Authboss) Email(ctx context.Context, email Email, ro EmailResponseOptio
 		}
 		ro.Data.Merge(ctxData.(HTMLData))
 	}
+	if locale := a.Locale(ctx); len(locale) != 0 {
+		if ro.Data == nil {
+			ro.Data = HTMLData{}
+		}
+		ro.Data[DataLocale] = locale
+	}
+	if len(ro.Module) != 0 {
+		if ro.Data == nil {
+			ro.Data = HTMLData{}
+		}
+		ro.Data[DataModule] = ro.Module
+	}
 	if len(ro.HTMLTemplate) != 0 {
 		htmlBody, _, err := a.Core.MailRenderer.Render(ctx, ro.HTMLTemplate, ro.Data)
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrTemplateNotFound) {
 			return errors.Wrap(err, "failed to render e-mail html body")
 		}
 		email.HTMLBody = string(htmlBody)
@@ -83,11 +104,50 @@ func (a *Authboss) Email(ctx context.Context, email Email, ro EmailResponseOptio
 
 	if len(ro.TextTemplate) != 0 {
 		textBody, _, err := a.Core.MailRenderer.Render(ctx, ro.TextTemplate, ro.Data)
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrTemplateNotFound) {
 			return errors.Wrap(err, "failed to render e-mail text body")
 		}
 		email.TextBody = string(textBody)
 	}
 
-	return a.Core.Mailer.Send(ctx, email)
+	if len(email.Template) == 0 {
+		email.Template = templateName(ro)
+	}
+	if email.TemplateData == nil {
+		email.TemplateData = ro.Data
+	}
+	if len(ro.Module) != 0 && !hasTag(email.Tags, ro.Module) {
+		email.Tags = append(email.Tags[:len(email.Tags):len(email.Tags)], ro.Module)
+	}
+
+	switch {
+	case len(email.TextBody) == 0 && len(email.HTMLBody) != 0:
+		email.TextBody = htmlToText(email.HTMLBody)
+	case len(email.HTMLBody) == 0 && len(email.TextBody) != 0:
+		email.HTMLBody = textToHTML(email.TextBody)
+	}
+
+	ctx, span := a.StartSpan(ctx, "authboss.mailer.Send", nil)
+	err := a.Core.Mailer.Send(ctx, email)
+	span.End(err)
+	a.CountMetric(CounterEmails, Labels{LabelResult: result(err == nil)})
+	return err
+}
+
+// templateName is the name of the e-mail's templates without their _html
+// or _txt suffix
+func templateName(ro EmailResponseOptions) string {
+	if len(ro.HTMLTemplate) != 0 {
+		return strings.TrimSuffix(ro.HTMLTemplate, "_html")
+	}
+	return strings.TrimSuffix(ro.TextTemplate, "_txt")
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
@@ -3,6 +3,8 @@ package authboss
 import (
 	"context"
 	"testing"
+
+	"github.com/friendsofgo/errors"
 )
 
 type testMailer struct{ sent bool }
@@ -41,3 +43,67 @@ func TestEmail(t *testing.T) {
 		t.Error("the e-mail should have been sent")
 	}
 }
+
+type htmlOnlyRenderer struct{ data HTMLData }
+
+func (h *htmlOnlyRenderer) Load(names ...string) error { return nil }
+
+func (h *htmlOnlyRenderer) Render(ctx context.Context, name string, data HTMLData) ([]byte, string, error) {
+	h.data = data
+	if name == "text" {
+		return nil, "", errors.Wrap(ErrTemplateNotFound, "no text template")
+	}
+	return []byte(`<p>Confirm <a href="http://localhost/confirm">here</a></p>`), "text/html", nil
+}
+
+type capturingMailer struct{ email Email }
+
+func (c *capturingMailer) Send(ctx context.Context, email Email) error {
+	c.email = email
+	return nil
+}
+
+func TestEmailMissingPart(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	mailer := &capturingMailer{}
+	renderer := &htmlOnlyRenderer{}
+	ab.Config.Core.Mailer = mailer
+	ab.Config.Core.MailRenderer = renderer
+
+	ro := EmailResponseOptions{
+		HTMLTemplate: "html",
+		TextTemplate: "text",
+		Module:       "confirm",
+	}
+
+	ctx := context.WithValue(context.Background(), CTXKeyLocale, "fr")
+	if err := ab.Email(ctx, Email{To: []string{"test@test.com"}}, ro); err != nil {
+		t.Fatal(err)
+	}
+
+	if mailer.email.TextBody != "Confirm here (http://localhost/confirm)\n" {
+		t.Errorf("text body was wrong: %q", mailer.email.TextBody)
+	}
+	if renderer.data[DataModule] != "confirm" || renderer.data[DataLocale] != "fr" {
+		t.Error("the renderer should get the module and locale:", renderer.data)
+	}
+	if mailer.email.Template != "html" || mailer.email.TemplateData[DataModule] != "confirm" {
+		t.Error("the template should be passed to the mailer:", mailer.email.Template, mailer.email.TemplateData)
+	}
+	if len(mailer.email.Tags) != 1 || mailer.email.Tags[0] != "confirm" {
+		t.Error("the e-mail should be tagged with the module:", mailer.email.Tags)
+	}
+}
+
+func TestTemplateName(t *testing.T) {
+	t.Parallel()
+
+	if name := templateName(EmailResponseOptions{HTMLTemplate: "confirm_html", TextTemplate: "confirm_txt"}); name != "confirm" {
+		t.Error("name was wrong:", name)
+	}
+	if name := templateName(EmailResponseOptions{TextTemplate: "recover_txt"}); name != "recover" {
+		t.Error("name was wrong:", name)
+	}
+}
@@ -0,0 +1,51 @@
+package authboss
+
+import "net/http"
+
+// RiskVerdict is what a RiskAssessor decided about a login
+type RiskVerdict int
+
+// RiskVerdicts
+const (
+	// RiskAllow lets the login go ahead
+	RiskAllow RiskVerdict = iota
+	// RiskChallenge requires a second factor: users with totp or sms 2fa
+	// are asked for it as usual and other users are e-mailed a code
+	RiskChallenge
+	// RiskDeny stops the login
+	RiskDeny
+)
+
+// RiskSignals are what's known about a login when it's assessed
+type RiskSignals struct {
+	// IP is the client's address, see Authboss.ClientIP
+	IP string
+	// UserAgent of the request
+	UserAgent string
+	// GeoHint is where the client is, from Config.Modules.RiskGeoHint (for
+	// example a country from the load balancer's header). It's empty if
+	// that isn't set.
+	GeoHint string
+	// KnownDevice is true if the user has logged in from the device
+	// before, it's always false without device.Middleware.
+	KnownDevice bool
+	// RecentFailures is how many times in a row the user has failed to
+	// log in within Config.Modules.LockWindow, it's always 0 for users
+	// that aren't LockableUsers.
+	RecentFailures int
+}
+
+// RiskAssessor decides what to do about a login once the user's password
+// was checked, for defenses like impossible travel or credential stuffing
+// detection (see the risk module). The user is the one logging in.
+type RiskAssessor interface {
+	AssessLogin(r *http.Request, user User, signals RiskSignals) (RiskVerdict, error)
+}
+
+// RiskAssessorFunc is a func that's a RiskAssessor
+type RiskAssessorFunc func(r *http.Request, user User, signals RiskSignals) (RiskVerdict, error)
+
+// AssessLogin calls f
+func (f RiskAssessorFunc) AssessLogin(r *http.Request, user User, signals RiskSignals) (RiskVerdict, error) {
+	return f(r, user, signals)
+}
@@ -0,0 +1,366 @@
+// Package risk asks the app's authboss.RiskAssessor about every login once
+// the user's password was checked, so suspicious logins (like impossible
+// travel or credential stuffing) can be denied or made to prove themselves
+// with a second factor without changing the auth module.
+//
+// Users with totp or sms 2fa are asked for it as usual when a login is
+// challenged. Other users are e-mailed a code they must enter at
+// /login/verify to finish logging in. The code is kept in the session
+// signed with Modules.RiskCodeKey rather than as is, and the attempts to
+// enter it are counted in the Storage.Counter.
+package risk
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Pages and templates
+const (
+	// PageLoginVerify is the page the e-mailed code is entered on
+	PageLoginVerify = "login_verify"
+
+	// EmailLoginCodeHTML is the name of the html template for the e-mail
+	// with the code
+	EmailLoginCodeHTML = "login_code_html"
+	// EmailLoginCodeTxt is the name of the text template for the e-mail
+	// with the code
+	EmailLoginCodeTxt = "login_code_txt"
+
+	// DataLoginCode is the code in the e-mail's data
+	DataLoginCode = "code"
+	// FormValueCode is the form field the code is posted in
+	FormValueCode = "code"
+
+	// SessionPendingPID is the user that has to enter a code
+	SessionPendingPID = "risk_pending_pid"
+	// SessionCode is the signed code the user has to enter
+	SessionCode = "risk_code"
+
+	pageLogin = "login"
+)
+
+func init() {
+	authboss.RegisterModule("risk", &Risk{})
+}
+
+// User is a user that can be e-mailed a code
+type User interface {
+	authboss.User
+
+	GetEmail() (email string)
+}
+
+// The 2fa users of the otp/twofactor packages, which aren't imported so
+// that their modules aren't registered
+type totpUser interface {
+	GetTOTPSecretKey() string
+}
+type smsUser interface {
+	GetSMSPhoneNumber() string
+}
+
+// Risk module
+type Risk struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks there's an assessor and what the codes need
+func (k *Risk) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Logger", "Core.Mailer", "Core.MailRenderer", "Modules.RiskAssessor", "Modules.RiskCodeKey", "Storage.Counter")
+}
+
+// Init module
+func (k *Risk) Init(ab *authboss.Authboss) error {
+	k.Authboss = ab
+
+	if err := ab.Config.Core.ViewRenderer.Load(PageLoginVerify); err != nil {
+		return err
+	}
+	if err := ab.Config.Core.MailRenderer.Load(EmailLoginCodeHTML, EmailLoginCodeTxt); err != nil {
+		return err
+	}
+
+	ab.Config.Core.Router.Get("/login/verify", ab.IPPolicyGuard("/login/verify", ab.Config.Core.ErrorHandler.Wrap(k.Get)))
+	ab.Config.Core.Router.Post("/login/verify", ab.IPPolicyGuard("/login/verify", ab.Config.Core.ErrorHandler.Wrap(k.Post)))
+
+	// Called before the 2fa modules so that a denied login isn't sent to
+	// their validation pages first
+	return ab.Events.Register(authboss.EventAuthHijack, authboss.Hook{
+		Name:     "risk",
+		Priority: 10,
+		When:     authboss.EventBefore,
+		Handler:  k.HijackAuth,
+	})
+}
+
+// HijackAuth assesses the login and denies or challenges it if the
+// assessor says so
+func (k *Risk) HijackAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if handled {
+		return false, nil
+	}
+
+	logger := k.RequestLogger(r)
+	user := r.Context().Value(authboss.CTXKeyUser).(authboss.User)
+
+	verdict, err := k.Config.Modules.RiskAssessor.AssessLogin(r, user, k.Signals(r, user))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to assess login")
+	}
+
+	switch verdict {
+	case authboss.RiskAllow:
+		return false, nil
+	case authboss.RiskChallenge:
+		if k.hasTwoFactor(user) {
+			logger.Infof("challenging login of user %s with their 2fa", user.GetPID())
+			return false, nil
+		}
+		if emailUser, ok := user.(User); ok && len(emailUser.GetEmail()) != 0 {
+			logger.Infof("challenging login of user %s with an e-mailed code", user.GetPID())
+			return true, k.challenge(w, r, emailUser)
+		}
+		logger.Errorf("user %s can't be e-mailed a login code, denying the login", user.GetPID())
+	default:
+		logger.Infof("denied login of user %s", user.GetPID())
+	}
+
+	data := authboss.HTMLData{
+		authboss.DataErr:     k.Localize(r.Context(), authboss.TxtLoginDenied),
+		authboss.DataErrCode: authboss.ErrorCodeForbidden,
+	}
+	return true, k.Core.Responder.Respond(w, r, http.StatusOK, pageLogin, data)
+}
+
+// Signals of the login for the RiskAssessor
+func (k *Risk) Signals(r *http.Request, user authboss.User) authboss.RiskSignals {
+	attempt := k.Attempt(r)
+	signals := authboss.RiskSignals{
+		IP:        attempt.IP,
+		UserAgent: attempt.UserAgent,
+	}
+
+	if geo := k.Config.Modules.RiskGeoHint; geo != nil {
+		signals.GeoHint = geo(r)
+	}
+	if device := authboss.CurrentDevice(r); device != nil {
+		signals.KnownDevice = !device.New
+	}
+	if lu, ok := user.(authboss.LockableUser); ok && k.Now().Sub(lu.GetLastAttempt()) < k.Config.Modules.LockWindow {
+		signals.RecentFailures = lu.GetAttemptCount()
+	}
+
+	return signals
+}
+
+// hasTwoFactor is true if one of the 2fa modules will ask the user for
+// their second factor
+func (k *Risk) hasTwoFactor(user authboss.User) bool {
+	if tu, ok := user.(totpUser); ok && len(tu.GetTOTPSecretKey()) != 0 && k.IsLoaded("totp2fa") {
+		return true
+	}
+	if su, ok := user.(smsUser); ok && len(su.GetSMSPhoneNumber()) != 0 && k.IsLoaded("sms2fa") {
+		return true
+	}
+	return false
+}
+
+// challenge e-mails the user a code and sends them to the page it's
+// entered on
+func (k *Risk) challenge(w http.ResponseWriter, r *http.Request, user User) error {
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+
+	pid := user.GetPID()
+	expires := k.Now().Add(k.Config.Modules.RiskCodeLifetime).Unix()
+	authboss.PutSession(w, SessionPendingPID, pid)
+	authboss.PutSession(w, SessionCode, strconv.FormatInt(expires, 10)+"."+k.sign(pid, expires, code))
+
+	ctx := r.Context()
+	if k.Config.Modules.MailNoGoroutine {
+		k.SendCodeEmail(ctx, user.GetEmail(), code)
+	} else {
+		k.Background(func() { k.SendCodeEmail(ctx, user.GetEmail(), code) })
+	}
+
+	var query string
+	if len(r.URL.RawQuery) != 0 {
+		query = "?" + r.URL.RawQuery
+	}
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: k.Paths.Mount + "/login/verify" + query,
+		Success:      k.Localize(ctx, authboss.TxtLoginCodeSent),
+	}
+	return k.Core.Redirector.Redirect(w, r, ro)
+}
+
+// SendCodeEmail sends the code to finish logging in to the user
+func (k *Risk) SendCodeEmail(ctx context.Context, to, code string) {
+	logger := k.Logger(ctx)
+
+	mail := k.RequestConfig(ctx).Mail
+	email := authboss.Email{
+		To:       []string{to},
+		From:     mail.From,
+		FromName: mail.FromName,
+		Subject:  mail.SubjectPrefix + k.Localize(ctx, authboss.TxtLoginCodeSubject),
+	}
+
+	logger.Infof("sending login code e-mail to: %s", to)
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.HTMLData{DataLoginCode: code},
+		HTMLTemplate: EmailLoginCodeHTML,
+		TextTemplate: EmailLoginCodeTxt,
+		Module:       "risk",
+	}
+	if err := k.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send login code e-mail to %s: %+v", to, err)
+	}
+}
+
+// Get the page the code is entered on
+func (k *Risk) Get(w http.ResponseWriter, r *http.Request) error {
+	if _, ok := authboss.GetSession(r, SessionPendingPID); !ok {
+		return k.toLogin(w, r, "")
+	}
+	return k.Core.Responder.Respond(w, r, http.StatusOK, PageLoginVerify, nil)
+}
+
+// Post the code, the user is logged in if it's right
+func (k *Risk) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := k.RequestLogger(r)
+
+	pid, ok := authboss.GetSession(r, SessionPendingPID)
+	signed, _ := authboss.GetSession(r, SessionCode)
+	if !ok {
+		return k.toLogin(w, r, "")
+	}
+
+	validatable, err := k.Core.BodyReader.Read(PageLoginVerify, r)
+	if err != nil {
+		return err
+	}
+	code := authboss.MustHaveConfirmValues(validatable).GetToken()
+
+	id, err := attemptID()
+	if err != nil {
+		return err
+	}
+	attempts, err := k.Config.Storage.Counter.CountDistinct(r.Context(), "risk:"+signed, id, k.Config.Modules.RiskCodeLifetime)
+	if err != nil {
+		return errors.Wrap(err, "failed to count login code attempt")
+	}
+	if attempts > k.Config.Modules.RiskCodeAttempts {
+		logger.Infof("user %s entered too many wrong login codes", pid)
+		authboss.DelSession(w, SessionPendingPID)
+		authboss.DelSession(w, SessionCode)
+		return k.toLogin(w, r, k.Localize(r.Context(), authboss.TxtLoginCodeAttempts))
+	}
+
+	if !k.verify(pid, signed, code) {
+		logger.Infof("user %s entered a wrong login code", pid)
+		data := authboss.HTMLData{
+			authboss.DataValidation: map[string][]string{FormValueCode: {k.Localize(r.Context(), authboss.TxtLoginCodeInvalid)}},
+		}
+		return k.Core.Responder.Respond(w, r, http.StatusOK, PageLoginVerify, data)
+	}
+
+	user, err := k.LoadUser(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	authboss.DelSession(w, SessionPendingPID)
+	authboss.DelSession(w, SessionCode)
+
+	logger.Infof("user %s logged in with a login code", pid)
+	k.CountLogin("risk", true)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := k.Events.FireAfter(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:             http.StatusTemporaryRedirect,
+		RedirectPath:     k.ReturnTo(w, r, k.RedirectPath(r, "risk", authboss.RedirectOK, user, k.AccountTypePath(user, k.RequestConfig(r.Context()).Paths.AuthLoginOK))),
+		FollowRedirParam: true,
+	}
+	return k.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (k *Risk) toLogin(w http.ResponseWriter, r *http.Request, failure string) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: k.Paths.Mount + "/login",
+		Failure:      failure,
+	}
+	if len(failure) != 0 {
+		ro.FailureCode = authboss.ErrorCodeRateLimited
+	}
+	return k.Core.Redirector.Redirect(w, r, ro)
+}
+
+// sign the code for the user so that it can be kept in the session
+// without giving it away
+func (k *Risk) sign(pid string, expires int64, code string) string {
+	mac := hmac.New(sha256.New, k.Config.Modules.RiskCodeKey)
+	fmt.Fprintf(mac, "%s\x00%d\x00%s", pid, expires, code)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify the code against the signed code from the session
+func (k *Risk) verify(pid, signed, code string) bool {
+	parts := strings.SplitN(signed, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || k.Now().Unix() >= expires {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(k.sign(pid, expires, strings.TrimSpace(code))), []byte(parts[1])) == 1
+}
+
+// generateCode creates a random six digit code
+func generateCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create login code")
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// attemptID makes each attempt a distinct member of the counter's set
+func attemptID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", errors.Wrap(err, "failed to create login code attempt id")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,257 @@
+package risk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	h.ab.Config.Core.Router = router
+	h.ab.Config.Core.ViewRenderer = renderer
+
+	if err := h.risk.Init(h.ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageLoginVerify); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/login/verify"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/login/verify"); err != nil {
+		t.Error(err)
+	}
+	if hooks := h.ab.Events.Hooks(authboss.EventAuthHijack, authboss.EventBefore); len(hooks) != 1 || hooks[0].Priority <= 0 {
+		t.Errorf("the hook should be called before the 2fa modules': %#v", hooks)
+	}
+}
+
+type testHarness struct {
+	risk *Risk
+	ab   *authboss.Authboss
+
+	clock        *authtest.Clock
+	verdict      authboss.RiskVerdict
+	signals      authboss.RiskSignals
+	mailRenderer *mocks.Renderer
+	redirector   *mocks.Redirector
+	responder    *mocks.Responder
+	session      *mocks.ClientStateRW
+	storer       *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	h := &testHarness{}
+
+	h.ab = authboss.New()
+	h.clock = authtest.NewClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.mailRenderer = &mocks.Renderer{}
+	h.redirector = &mocks.Redirector{}
+	h.responder = &mocks.Responder{}
+	h.session = mocks.NewClientRW()
+	h.storer = mocks.NewServerStorer()
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	h.ab.Config.Core.Clock = h.clock
+	h.ab.Config.Core.Logger = mocks.Logger{}
+	h.ab.Config.Core.Mailer = mocks.NewMailer()
+	h.ab.Config.Core.MailRenderer = h.mailRenderer
+	h.ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	h.ab.Config.Core.Redirector = h.redirector
+	h.ab.Config.Core.Responder = h.responder
+	h.ab.Config.Storage.SessionState = h.session
+	h.ab.Config.Storage.Server = h.storer
+	h.ab.Config.Storage.Counter = mocks.NewCounter()
+	h.ab.Config.Modules.MailNoGoroutine = true
+	h.ab.Config.Modules.RiskCodeKey = []byte("key")
+	h.ab.Config.Modules.RiskAssessor = authboss.RiskAssessorFunc(func(r *http.Request, user authboss.User, signals authboss.RiskSignals) (authboss.RiskVerdict, error) {
+		h.signals = signals
+		return h.verdict, nil
+	})
+
+	h.risk = &Risk{Authboss: h.ab}
+	return h
+}
+
+// login runs the hijack for the user as the auth module does
+func (h *testHarness) login(t *testing.T, user authboss.User) (bool, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := mocks.Request("POST")
+	r.RemoteAddr = "192.0.2.1:1234"
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	handled, err := h.risk.HijackAuth(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush the session
+	return handled, rec
+}
+
+func (h *testHarness) post(t *testing.T, code string) {
+	t.Helper()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r := mocks.Request("POST")
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ab.Config.Core.BodyReader = mocks.BodyReader{Return: mocks.Values{Token: code}}
+
+	if err := h.risk.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestHijackAuthAllow(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.verdict = authboss.RiskAllow
+
+	user := &mocks.User{Email: "test@test.com", AttemptCount: 2, LastAttempt: h.clock.Now().Add(-time.Minute)}
+	if handled, _ := h.login(t, user); handled {
+		t.Error("the login should go ahead")
+	}
+
+	if h.signals.IP != "192.0.2.1" || h.signals.RecentFailures != 2 || h.signals.KnownDevice {
+		t.Errorf("signals were wrong: %#v", h.signals)
+	}
+}
+
+func TestHijackAuthDeny(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.verdict = authboss.RiskDeny
+
+	if handled, _ := h.login(t, &mocks.User{Email: "test@test.com"}); !handled {
+		t.Error("the login should be stopped")
+	}
+	if h.responder.Page != "login" || h.responder.Data[authboss.DataErrCode] != authboss.ErrorCodeForbidden {
+		t.Errorf("the login page should show the denial: %#v", h.responder)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the user should not be logged in")
+	}
+}
+
+func TestHijackAuthChallengeTwoFactor(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.verdict = authboss.RiskChallenge
+
+	// Without the totp2fa module loaded the secret doesn't mean anything
+	user := &mocks.User{Email: "test@test.com", TOTPSecretKey: "secret"}
+	if handled, _ := h.login(t, user); !handled {
+		t.Error("the user should be e-mailed a code")
+	}
+	if _, ok := h.session.ClientValues[SessionPendingPID]; !ok {
+		t.Error("the user should have a pending code")
+	}
+}
+
+func TestChallengeEmail(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.verdict = authboss.RiskChallenge
+
+	if handled, _ := h.login(t, &mocks.User{Email: "test@test.com"}); !handled {
+		t.Fatal("the user should be e-mailed a code")
+	}
+	if h.redirector.Options.RedirectPath != "/auth/login/verify" {
+		t.Error("redirect was wrong:", h.redirector.Options.RedirectPath)
+	}
+
+	code, _ := h.mailRenderer.Data[DataLoginCode].(string)
+	if len(code) != 6 {
+		t.Fatal("the code should have been e-mailed:", code)
+	}
+	for _, key := range []string{SessionPendingPID, SessionCode} {
+		if value := h.session.ClientValues[key]; len(value) == 0 || value == code {
+			t.Errorf("%s was wrong: %q", key, value)
+		}
+	}
+
+	h.post(t, "000000x")
+	if h.responder.Page != PageLoginVerify || h.responder.Data[authboss.DataValidation] == nil {
+		t.Errorf("a wrong code should be rejected: %#v", h.responder)
+	}
+
+	authed := false
+	h.ab.Events.After(authboss.EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		authed = true
+		return false, nil
+	})
+
+	h.post(t, code)
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" || !authed {
+		t.Error("the user should be logged in:", pid)
+	}
+	if _, ok := h.session.ClientValues[SessionPendingPID]; ok {
+		t.Error("the pending code should be deleted")
+	}
+}
+
+func TestChallengeEmailExpired(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.verdict = authboss.RiskChallenge
+	h.login(t, &mocks.User{Email: "test@test.com"})
+	code := h.mailRenderer.Data[DataLoginCode].(string)
+
+	h.clock.Advance(h.ab.Config.Modules.RiskCodeLifetime)
+	h.post(t, code)
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("an expired code should be rejected")
+	}
+}
+
+func TestChallengeEmailAttempts(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.verdict = authboss.RiskChallenge
+	h.login(t, &mocks.User{Email: "test@test.com"})
+	code := h.mailRenderer.Data[DataLoginCode].(string)
+
+	for i := 0; i < h.ab.Config.Modules.RiskCodeAttempts; i++ {
+		h.post(t, "wrong")
+	}
+	h.post(t, code)
+
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the code should not be accepted after too many attempts")
+	}
+	if opts := h.redirector.Options; opts.RedirectPath != "/auth/login" || opts.FailureCode != authboss.ErrorCodeRateLimited {
+		t.Errorf("the user should be sent back to log in: %#v", opts)
+	}
+	if _, ok := h.session.ClientValues[SessionPendingPID]; ok {
+		t.Error("the pending code should be deleted")
+	}
+}
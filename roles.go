@@ -0,0 +1,125 @@
+package authboss
+
+import (
+	"net/http"
+)
+
+// Authorization is what a route requires of the logged in user on top of
+// being logged in, see RequireAuthorization
+type Authorization struct {
+	// Roles the user needs one of
+	Roles []string
+	// Permissions the user needs all of, they're granted by the user's
+	// roles according to Config.Modules.RolePermissions
+	Permissions []string
+}
+
+// Authorize decides whether user may use a route that requires need. It's
+// Config.Modules.Authorize when it's set and HasAuthorization otherwise.
+func (a *Authboss) Authorize(r *http.Request, user User, need Authorization) (bool, error) {
+	if a.Config.Modules.Authorize != nil {
+		return a.Config.Modules.Authorize(r, user, need)
+	}
+
+	return a.HasAuthorization(user, need), nil
+}
+
+// HasAuthorization checks the user has one of need.Roles (if there are
+// any) and all of need.Permissions. Users that aren't RolesUsers have no
+// roles.
+func (a *Authboss) HasAuthorization(user User, need Authorization) bool {
+	var roles []string
+	if ru, ok := user.(RolesUser); ok {
+		roles = ru.GetRoles()
+	}
+
+	if len(need.Roles) != 0 && !hasAny(roles, need.Roles...) {
+		return false
+	}
+
+	for _, permission := range need.Permissions {
+		granted := false
+		for _, role := range roles {
+			if hasAny(a.Config.Modules.RolePermissions[role], permission) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasAny(list []string, want ...string) bool {
+	for _, l := range list {
+		for _, w := range want {
+			if l == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireRoles is Middleware2 that also requires the user to have one of
+// roles, see RequireAuthorization.
+func RequireRoles(ab *Authboss, reqs MWRequirements, failResponse MWRespondOnFailure, roles ...string) func(http.Handler) http.Handler {
+	return RequireAuthorization(ab, reqs, failResponse, Authorization{Roles: roles})
+}
+
+// RequirePermissions is Middleware2 that also requires the user's roles to
+// grant all of permissions, see RequireAuthorization.
+func RequirePermissions(ab *Authboss, reqs MWRequirements, failResponse MWRespondOnFailure, permissions ...string) func(http.Handler) http.Handler {
+	return RequireAuthorization(ab, reqs, failResponse, Authorization{Permissions: permissions})
+}
+
+// RequireAuthorization is Middleware2 that also requires the user to meet
+// need (see Authboss.Authorize). Users who aren't logged in are responded
+// to like Middleware2 does, users who are but aren't authorized get:
+//
+//   - RespondNotFound: a 404
+//   - RespondUnauthorized: a 403
+//   - RespondRedirect: a redirect to Paths.NotAuthorized with a failure
+//     (ErrorCodeForbidden), the defaults' Redirector responds to API
+//     requests with a 403 and the failure as JSON instead
+func RequireAuthorization(ab *Authboss, reqs MWRequirements, failResponse MWRespondOnFailure, need Authorization) func(http.Handler) http.Handler {
+	authenticate := Middleware2(ab, reqs, failResponse)
+
+	return func(next http.Handler) http.Handler {
+		return authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := ab.RequestLogger(r)
+
+			user, _ := r.Context().Value(CTXKeyUser).(User)
+			authorized, err := ab.Authorize(r, user, need)
+			if err != nil {
+				log.Errorf("failed to authorize user: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			} else if authorized {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log.Infof("forbidden for user %s at: %s", user.GetPID(), r.URL.Path)
+			switch failResponse {
+			case RespondNotFound:
+				w.WriteHeader(http.StatusNotFound)
+			case RespondUnauthorized:
+				w.WriteHeader(http.StatusForbidden)
+			case RespondRedirect:
+				ro := RedirectOptions{
+					Code:         http.StatusForbidden,
+					Failure:      ab.Localize(ab.LocaleContext(r), TxtForbidden),
+					FailureCode:  ErrorCodeForbidden,
+					RedirectPath: ab.RequestConfig(r.Context()).Paths.NotAuthorized,
+				}
+				if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+					log.Errorf("failed to redirect during authboss.RequireAuthorization redirect: %+v", err)
+				}
+			}
+		}))
+	}
+}
@@ -0,0 +1,138 @@
+package authboss
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockRolesUser struct {
+	mockUser
+	Roles []string
+}
+
+func (m *mockRolesUser) GetRoles() []string { return m.Roles }
+
+func TestHasAuthorization(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Modules.RolePermissions = map[string][]string{
+		"editor": {"posts.edit"},
+		"admin":  {"posts.edit", "users.edit"},
+	}
+
+	editor := &mockRolesUser{Roles: []string{"editor"}}
+	admin := &mockRolesUser{Roles: []string{"admin"}}
+
+	tests := []struct {
+		User User
+		Need Authorization
+		Want bool
+	}{
+		{editor, Authorization{}, true},
+		{editor, Authorization{Roles: []string{"admin", "editor"}}, true},
+		{editor, Authorization{Roles: []string{"admin"}}, false},
+		{editor, Authorization{Permissions: []string{"posts.edit"}}, true},
+		{editor, Authorization{Permissions: []string{"posts.edit", "users.edit"}}, false},
+		{admin, Authorization{Permissions: []string{"posts.edit", "users.edit"}}, true},
+		{admin, Authorization{Roles: []string{"editor"}, Permissions: []string{"users.edit"}}, false},
+		{&mockUser{}, Authorization{Roles: []string{"editor"}}, false},
+	}
+
+	for i, test := range tests {
+		if got := ab.HasAuthorization(test.User, test.Need); got != test.Want {
+			t.Errorf("%d) want: %t, got: %t", i, test.Want, got)
+		}
+	}
+}
+
+func TestRequireAuthorization(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	redirector := &testRedirector{}
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.Redirector = redirector
+	ab.Config.Paths.NotAuthorized = "/forbidden"
+
+	user := &mockRolesUser{mockUser: mockUser{Email: "test@test.com"}, Roles: []string{"editor"}}
+	serve := func(mw func(http.Handler) http.Handler) (*httptest.ResponseRecorder, bool) {
+		r := httptest.NewRequest("GET", "/", nil)
+		ctx := context.WithValue(r.Context(), CTXKeyPID, "test@test.com")
+		r = r.WithContext(context.WithValue(ctx, CTXKeyUser, user))
+		w := httptest.NewRecorder()
+
+		called := false
+		mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})).ServeHTTP(w, r)
+		return w, called
+	}
+
+	if _, called := serve(RequireRoles(ab, RequireNone, RespondRedirect, "editor")); !called {
+		t.Error("an editor should be let through")
+	}
+
+	w, called := serve(RequireRoles(ab, RequireNone, RespondRedirect, "admin"))
+	if called {
+		t.Error("an editor should not be let through")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Error("code was wrong:", w.Code)
+	}
+	if opts := redirector.Opts; opts.RedirectPath != "/forbidden" || opts.FailureCode != ErrorCodeForbidden || len(opts.Failure) == 0 {
+		t.Errorf("redirect was wrong: %#v", opts)
+	}
+
+	if w, _ := serve(RequirePermissions(ab, RequireNone, RespondNotFound, "posts.edit")); w.Code != http.StatusNotFound {
+		t.Error("code was wrong:", w.Code)
+	}
+	if w, _ := serve(RequirePermissions(ab, RequireNone, RespondUnauthorized, "posts.edit")); w.Code != http.StatusForbidden {
+		t.Error("code was wrong:", w.Code)
+	}
+}
+
+func TestRequireAuthorizationHook(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.Logger = mockLogger{}
+
+	var gotNeed Authorization
+	ab.Config.Modules.Authorize = func(r *http.Request, user User, need Authorization) (bool, error) {
+		gotNeed = need
+		if r.Header.Get("X-Fail") != "" {
+			return false, errors.New("failed")
+		}
+		return user.GetPID() == "test@test.com", nil
+	}
+
+	serve := func(fail bool) (int, bool) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if fail {
+			r.Header.Set("X-Fail", "1")
+		}
+		ctx := context.WithValue(r.Context(), CTXKeyPID, "test@test.com")
+		r = r.WithContext(context.WithValue(ctx, CTXKeyUser, &mockUser{Email: "test@test.com"}))
+		w := httptest.NewRecorder()
+
+		called := false
+		RequireRoles(ab, RequireNone, RespondUnauthorized, "admin")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})).ServeHTTP(w, r)
+		return w.Code, called
+	}
+
+	if _, called := serve(false); !called {
+		t.Error("the hook should have let the user through")
+	}
+	if len(gotNeed.Roles) != 1 || gotNeed.Roles[0] != "admin" {
+		t.Error("the hook should be given the roles:", gotNeed)
+	}
+	if code, called := serve(true); called || code != http.StatusInternalServerError {
+		t.Error("an error should be a 500:", code, called)
+	}
+}
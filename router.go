@@ -12,3 +12,9 @@ type Router interface {
 	Post(path string, handler http.Handler)
 	Delete(path string, handler http.Handler)
 }
+
+// RouteLister can be implemented by a Router to list its routes for
+// Authboss.Introspect, as "METHOD /path".
+type RouteLister interface {
+	Routes() []string
+}
@@ -0,0 +1,33 @@
+package authboss
+
+import "crypto/x509"
+
+// SAMLProvider is a SAML 2.0 identity provider users can log in with
+// using the saml module, like a company's Okta or Azure AD. Most of it
+// comes from the identity provider's metadata.
+type SAMLProvider struct {
+	// EntityID of the identity provider, responses and assertions must be
+	// issued by it
+	EntityID string
+	// SSOURL is the identity provider's single sign on endpoint for the
+	// HTTP-Redirect binding, users are sent there to log in
+	SSOURL string
+	// Certificates the identity provider signs with. Either the response
+	// or the assertion must be signed by one of them, certificates sent
+	// with the signature are never trusted.
+	Certificates []*x509.Certificate
+
+	// AttributeMap maps the names of the attributes in assertions to the
+	// keys of the details given to OAuth2ServerStorer.NewFromOAuth2, like
+	// "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"
+	// to "email". Attributes that aren't in it are passed along by their
+	// own name. The "uid" detail is always the assertion's NameID.
+	AttributeMap map[string]string
+
+	// AllowIdPInitiated accepts responses the identity provider sends
+	// without the user having been sent there first (IdP-initiated logins,
+	// like from an apps dashboard). They can't be tied to the user's
+	// browser so they're easier to abuse, only allow them if the identity
+	// provider is used that way.
+	AllowIdPInitiated bool
+}
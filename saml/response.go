@@ -0,0 +1,235 @@
+package saml
+
+import (
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	statusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// clockSkew is how far the identity provider's clock may be off
+	clockSkew = 3 * time.Minute
+)
+
+// assertion is what a valid response says about the user
+type assertion struct {
+	ID           string
+	NameID       string
+	SessionIndex string
+	Attributes   map[string][]string
+}
+
+// expectations are what a response must match to be valid
+type expectations struct {
+	// Issuer is the identity provider's entity id
+	Issuer string
+	// Audience is the service provider's entity id
+	Audience string
+	// Recipient is the assertion consumer service url
+	Recipient string
+	// RequestID is the AuthnRequest the user was sent with, it's empty if
+	// they weren't and then only AllowIdPInitiated responses are valid
+	RequestID         string
+	AllowIdPInitiated bool
+
+	Certificates []*x509.Certificate
+	Now          time.Time
+}
+
+// errFailed is wrapped by the errors of responses that say the login
+// failed, as opposed to responses that are invalid
+var errFailed = errors.New("identity provider did not log the user in")
+
+// validate the response and return the assertion in it. Everything is
+// read from the elements that were verified so nothing unsigned (like a
+// second, wrapped assertion) can be slipped in.
+func validate(raw []byte, expect expectations) (*assertion, time.Time, error) {
+	var expires time.Time
+
+	root, err := parse(raw)
+	if err != nil {
+		return nil, expires, err
+	}
+	if _, err = ids(root); err != nil {
+		return nil, expires, err
+	}
+
+	if !root.is(nsProtocol, "Response") || root.attr("Version") != "2.0" {
+		return nil, expires, errors.New("not a saml 2.0 response")
+	}
+	if dest := root.attr("Destination"); len(dest) != 0 && dest != expect.Recipient {
+		return nil, expires, errors.Errorf("response is for %q", dest)
+	}
+	if err = checkInResponseTo(root.attr("InResponseTo"), expect); err != nil {
+		return nil, expires, err
+	}
+	if issuer := root.child(nsAssertion, "Issuer"); issuer != nil && issuer.text() != expect.Issuer {
+		return nil, expires, errors.Errorf("response was issued by %q", issuer.text())
+	}
+
+	var code string
+	if status := root.child(nsProtocol, "Status"); status != nil {
+		if statusCode := status.child(nsProtocol, "StatusCode"); statusCode != nil {
+			code = statusCode.attr("Value")
+		}
+	}
+	if code != statusSuccess {
+		return nil, expires, errors.Wrapf(errFailed, "status %q", code)
+	}
+
+	if root.child(nsAssertion, "EncryptedAssertion") != nil {
+		return nil, expires, errors.New("encrypted assertions are not supported")
+	}
+	assertions := root.all(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, expires, errors.New("response must have exactly one assertion")
+	}
+	el := assertions[0]
+
+	if signed(root) {
+		if err = verify(root, expect.Certificates); err != nil {
+			return nil, expires, errors.Wrap(err, "invalid response signature")
+		}
+	}
+	if signed(el) {
+		if err = verify(el, expect.Certificates); err != nil {
+			return nil, expires, errors.Wrap(err, "invalid assertion signature")
+		}
+	} else if !signed(root) {
+		return nil, expires, errors.New("neither the response nor the assertion is signed")
+	}
+
+	if issuer := el.child(nsAssertion, "Issuer"); issuer == nil || issuer.text() != expect.Issuer {
+		return nil, expires, errors.New("assertion was not issued by the identity provider")
+	}
+
+	subject := el.child(nsAssertion, "Subject")
+	if subject == nil {
+		return nil, expires, errors.New("assertion has no subject")
+	}
+	nameID := subject.child(nsAssertion, "NameID")
+	if nameID == nil || len(nameID.text()) == 0 {
+		return nil, expires, errors.New("assertion has no NameID")
+	}
+
+	var confirmed bool
+	for _, confirmation := range subject.all(nsAssertion, "SubjectConfirmation") {
+		if confirmation.attr("Method") != methodBearer {
+			continue
+		}
+		data := confirmation.child(nsAssertion, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != expect.Recipient {
+			continue
+		}
+		notOnOrAfter, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || !expect.Now.Before(notOnOrAfter.Add(clockSkew)) {
+			continue
+		}
+		if checkInResponseTo(data.attr("InResponseTo"), expect) != nil {
+			continue
+		}
+
+		confirmed = true
+		expires = notOnOrAfter
+		break
+	}
+	if !confirmed {
+		return nil, expires, errors.New("assertion has no valid bearer subject confirmation")
+	}
+
+	conditions := el.child(nsAssertion, "Conditions")
+	if conditions == nil {
+		return nil, expires, errors.New("assertion has no conditions")
+	}
+	if notBefore := conditions.attr("NotBefore"); len(notBefore) != 0 {
+		t, err := parseTime(notBefore)
+		if err != nil || expect.Now.Add(clockSkew).Before(t) {
+			return nil, expires, errors.New("assertion is not valid yet")
+		}
+	}
+	if notOnOrAfter := conditions.attr("NotOnOrAfter"); len(notOnOrAfter) != 0 {
+		t, err := parseTime(notOnOrAfter)
+		if err != nil || !expect.Now.Before(t.Add(clockSkew)) {
+			return nil, expires, errors.New("assertion has expired")
+		}
+		if t.Before(expires) {
+			expires = t
+		}
+	}
+	for _, restriction := range conditions.all(nsAssertion, "AudienceRestriction") {
+		var ok bool
+		for _, audience := range restriction.all(nsAssertion, "Audience") {
+			ok = ok || audience.text() == expect.Audience
+		}
+		if !ok {
+			return nil, expires, errors.New("assertion is for another audience")
+		}
+	}
+
+	a := &assertion{ID: el.attr("ID"), NameID: nameID.text(), Attributes: make(map[string][]string)}
+	if authn := el.child(nsAssertion, "AuthnStatement"); authn != nil {
+		a.SessionIndex = authn.attr("SessionIndex")
+	}
+	for _, statement := range el.all(nsAssertion, "AttributeStatement") {
+		for _, attribute := range statement.all(nsAssertion, "Attribute") {
+			name := attribute.attr("Name")
+			for _, value := range attribute.all(nsAssertion, "AttributeValue") {
+				a.Attributes[name] = append(a.Attributes[name], value.text())
+			}
+		}
+	}
+
+	return a, expires, nil
+}
+
+func checkInResponseTo(inResponseTo string, expect expectations) error {
+	switch {
+	case len(inResponseTo) == 0 && !expect.AllowIdPInitiated:
+		return errors.New("identity provider initiated logins are not allowed")
+	case len(inResponseTo) != 0 && inResponseTo != expect.RequestID:
+		return errors.Errorf("response is for request %q", inResponseTo)
+	}
+	return nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// replays remembers the ids of the assertions that have been used until
+// they expire so each can only log in once. It's kept in memory, so with
+// several app servers an assertion could be used once on each of them
+// within the few minutes it's valid for.
+type replays struct {
+	mut  sync.Mutex
+	used map[string]time.Time
+}
+
+// use the assertion id, it returns false if it has been used before
+func (r *replays) use(id string, expires, now time.Time) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.used == nil {
+		r.used = make(map[string]time.Time)
+	}
+	for used, exp := range r.used {
+		if now.After(exp.Add(clockSkew)) {
+			delete(r.used, used)
+		}
+	}
+
+	if _, ok := r.used[id]; ok {
+		return false
+	}
+	r.used[id] = expires
+	return true
+}
@@ -0,0 +1,266 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/response.xml was signed with openssl over xmllint's exclusive
+// canonicalization, so it checks the canonicalization against another
+// implementation
+func fixture(t *testing.T) (string, []*x509.Certificate) {
+	t.Helper()
+
+	doc, err := ioutil.ReadFile("testdata/response.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile("testdata/idp.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(raw)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(doc), []*x509.Certificate{cert}
+}
+
+func fixtureExpectations(certs []*x509.Certificate) expectations {
+	return expectations{
+		Issuer:       "https://idp.example.com",
+		Audience:     "https://sp.example.com/auth/saml/metadata/idp",
+		Recipient:    "https://sp.example.com/auth/saml/acs/idp",
+		RequestID:    "_request1",
+		Certificates: certs,
+		Now:          time.Date(2030, 1, 1, 0, 1, 0, 0, time.UTC),
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	doc, certs := fixture(t)
+	a, expires, err := validate([]byte(doc), fixtureExpectations(certs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.ID != "_assertion1" || a.NameID != "jane@example.com" || a.SessionIndex != "_session1" {
+		t.Errorf("assertion was wrong: %#v", a)
+	}
+	if got := a.Attributes["displayName"]; len(got) != 1 || got[0] != "Jane & Co" {
+		t.Error("attributes were wrong:", a.Attributes)
+	}
+	if !expires.Equal(time.Date(2030, 1, 1, 0, 5, 0, 0, time.UTC)) {
+		t.Error("expiry was wrong:", expires)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	t.Parallel()
+
+	doc, certs := fixture(t)
+	other := testCert(t, testKey(t))
+
+	tests := []struct {
+		Name   string
+		Doc    string
+		Expect func(*expectations)
+	}{
+		{"tampered", strings.Replace(doc, ">jane@example.com</saml:NameID>", ">mallory@example.com</saml:NameID>", 1), nil},
+		{"tampered attribute", strings.Replace(doc, "Jane &amp; Co", "Mallory", 1), nil},
+		{"wrong certificate", doc, func(e *expectations) { e.Certificates = []*x509.Certificate{other} }},
+		{"wrong issuer", doc, func(e *expectations) { e.Issuer = "https://evil.example.com" }},
+		{"wrong audience", doc, func(e *expectations) { e.Audience = "https://other.example.com" }},
+		{"wrong recipient", doc, func(e *expectations) { e.Recipient = "https://other.example.com/acs" }},
+		{"wrong request", doc, func(e *expectations) { e.RequestID = "_request2" }},
+		{"no request", doc, func(e *expectations) { e.RequestID = "" }},
+		{"expired", doc, func(e *expectations) { e.Now = e.Now.Add(time.Hour) }},
+		{"not yet valid", doc, func(e *expectations) { e.Now = e.Now.Add(-time.Hour) }},
+		{"failed", strings.Replace(doc, "status:Success", "status:Responder", 1), nil},
+		{"unsigned", strings.Replace(doc, `ID="_assertion1"`, `ID="_assertion2"`, 1), nil},
+		{"doctype", strings.Replace(doc, "<samlp:Response", `<!DOCTYPE x [<!ENTITY a "b">]><samlp:Response`, 1), nil},
+		{
+			// A second assertion is how signature wrapping attacks try to
+			// get an unsigned assertion read instead of the signed one
+			"wrapped",
+			strings.Replace(doc, "</samlp:Response>", `<saml:Assertion ID="_evil" Version="2.0"><saml:Issuer>https://idp.example.com</saml:Issuer></saml:Assertion></samlp:Response>`, 1),
+			nil,
+		},
+		{
+			"duplicate ids",
+			strings.Replace(doc, "<samlp:Status>", `<samlp:Extensions><x ID="_assertion1"/></samlp:Extensions><samlp:Status>`, 1),
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		expect := fixtureExpectations(certs)
+		if test.Expect != nil {
+			test.Expect(&expect)
+		}
+		if _, _, err := validate([]byte(test.Doc), expect); err == nil {
+			t.Errorf("%s: the response should be rejected", test.Name)
+		}
+	}
+}
+
+func TestValidateIdPInitiated(t *testing.T) {
+	t.Parallel()
+
+	key := testKey(t)
+	cert := testCert(t, key)
+
+	doc := testResponse("")
+	expect := expectations{
+		Issuer:       "https://idp.example.com",
+		Audience:     "https://sp.example.com/saml/metadata/idp",
+		Recipient:    "https://sp.example.com/saml/acs/idp",
+		RequestID:    "_stale",
+		Certificates: []*x509.Certificate{cert},
+		Now:          time.Now(),
+	}
+
+	signed := sign(t, doc, key)
+	if _, _, err := validate([]byte(signed), expect); err == nil {
+		t.Error("identity provider initiated logins should not be allowed by default")
+	}
+
+	expect.AllowIdPInitiated = true
+	a, _, err := validate([]byte(signed), expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NameID != "jane@example.com" {
+		t.Error("name id was wrong:", a.NameID)
+	}
+}
+
+func TestReplays(t *testing.T) {
+	t.Parallel()
+
+	var r replays
+	now := time.Now()
+
+	if !r.use("id", now.Add(time.Minute), now) {
+		t.Error("the first use should be allowed")
+	}
+	if r.use("id", now.Add(time.Minute), now) {
+		t.Error("the second use should not be allowed")
+	}
+	if !r.use("other", now.Add(time.Hour), now.Add(time.Hour)) {
+		t.Error("other ids should be allowed")
+	}
+	if len(r.used) != 1 {
+		t.Error("expired ids should be forgotten:", r.used)
+	}
+}
+
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testCert(t *testing.T, key *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// testResponse has an assertion valid for the next five minutes that
+// still has to be signed, it's for IdP-initiated logins if inResponseTo
+// is empty
+func testResponse(inResponseTo string) string {
+	now := time.Now().UTC()
+	notBefore := now.Add(-time.Minute).Format(time.RFC3339)
+	notOnOrAfter := now.Add(5 * time.Minute).Format(time.RFC3339)
+	irt := ""
+	if len(inResponseTo) != 0 {
+		irt = ` InResponseTo="` + inResponseTo + `"`
+	}
+
+	return `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_r" Version="2.0"` + irt + `>` +
+		`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` +
+		`<Assertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ID="_a" Version="2.0">` +
+		`<Issuer>https://idp.example.com</Issuer>{SIG}` +
+		`<Subject><NameID>jane@example.com</NameID><SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<SubjectConfirmationData Recipient="https://sp.example.com/saml/acs/idp" NotOnOrAfter="` + notOnOrAfter + `"` + irt + `/>` +
+		`</SubjectConfirmation></Subject>` +
+		`<Conditions NotBefore="` + notBefore + `" NotOnOrAfter="` + notOnOrAfter + `"><AudienceRestriction>` +
+		`<Audience>https://sp.example.com/saml/metadata/idp</Audience></AudienceRestriction></Conditions>` +
+		`<AttributeStatement><Attribute Name="email"><AttributeValue xsi:type="xs:string">jane@example.com</AttributeValue></Attribute></AttributeStatement>` +
+		`</Assertion></samlp:Response>`
+}
+
+// sign the assertion of a testResponse with ECDSA and an inclusive
+// namespace prefix list, the way some identity providers do
+func sign(t *testing.T, doc string, key *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	unsigned, err := parse([]byte(strings.Replace(doc, "{SIG}", "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(canonicalize(unsigned.child(nsAssertion, "Assertion"), nil, []string{"xs"}))
+
+	signedInfo := `<ds:SignedInfo><ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + algECDSASHA256 + `"/><ds:Reference URI="#_a"><ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"/><ds:Transform Algorithm="` + algExcC14N + `">` +
+		`<ec:InclusiveNamespaces xmlns:ec="` + nsExcC14 + `" PrefixList="xs"/></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + algSHA256 + `"/><ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) +
+		`</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+	doc = strings.Replace(doc, "{SIG}", `<ds:Signature xmlns:ds="`+nsDSig+`">`+signedInfo+`<ds:SignatureValue>{VALUE}</ds:SignatureValue></ds:Signature>`, 1)
+
+	withSig, err := parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := withSig.child(nsAssertion, "Assertion").child(nsDSig, "Signature").child(nsDSig, "SignedInfo")
+	hashed := crypto.SHA256.New()
+	hashed.Write(canonicalize(si, nil, nil))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, hashed.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, sb := r.Bytes(), s.Bytes()
+	value := make([]byte, 64)
+	copy(value[32-len(rb):32], rb)
+	copy(value[64-len(sb):], sb)
+
+	return strings.Replace(doc, "{VALUE}", base64.StdEncoding.EncodeToString(value), 1)
+}
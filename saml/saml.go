@@ -0,0 +1,377 @@
+// Package saml lets users log in with a SAML 2.0 identity provider (like
+// Okta, Azure AD or ADFS) for enterprise single sign on, the app is the
+// service provider.
+//
+// For each provider in authboss.Config.Modules.SAMLProviders it mounts:
+//
+//	GET  /saml/{provider}           sends the user to log in (SP-initiated)
+//	POST /saml/acs/{provider}       the assertion consumer service
+//	GET  /saml/metadata/{provider}  the service provider's metadata
+//
+// Give the metadata url to the identity provider. Responses it posts to
+// the assertion consumer service must be signed (the response, the
+// assertion or both) by one of the provider's Certificates, and are only
+// accepted for the AuthnRequest the user was sent with unless the provider
+// AllowIdPInitiated. Encrypted assertions aren't supported.
+//
+// Users are created and logged in like the oauth2 module does it: the
+// assertion's NameID and attributes are given to
+// OAuth2ServerStorer.NewFromOAuth2 with the provider's name, the user's
+// pid is authboss.MakeOAuth2PID(provider, nameID) and the EventOAuth2
+// events are fired, so the modules that act on oauth2 logins act on saml
+// ones too.
+//
+// The assertion consumer service is a cross-site POST: the session cookie
+// must be SameSite=None for the AuthnRequest to be found (as with the
+// oauth2 FormPost providers). It's exempt from authboss' CSRF protection
+// but the app's own CSRF middleware has to let it through too.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// FormValue constants
+const (
+	FormValueSAMLRequest  = "SAMLRequest"
+	FormValueSAMLResponse = "SAMLResponse"
+	FormValueRelayState   = "RelayState"
+
+	// FormValueSAMLRedir is where to send the user after they log in, it's
+	// passed to the identity provider and back in the RelayState
+	FormValueSAMLRedir = "redir"
+)
+
+// DetailUID is the detail given to OAuth2ServerStorer.NewFromOAuth2 that
+// holds the assertion's NameID, like the oauth2 providers' uid
+const DetailUID = "uid"
+
+const (
+	bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	nsMetadata      = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	requestIDSize = 20
+)
+
+func init() {
+	authboss.RegisterModule("saml", &SAML{})
+}
+
+// SAML module
+type SAML struct {
+	*authboss.Authboss
+
+	replays replays
+}
+
+// ValidateConfig checks the config has what logging in with a saml
+// identity provider needs
+func (s *SAML) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.Redirector", "Core.Logger",
+		"Storage.Server", "Storage.SessionState", "Paths.RootURL")
+	problems = append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.OAuth2ServerStorer)(nil))...)
+
+	for name, provider := range ab.Config.Modules.SAMLProviders {
+		field := "Modules.SAMLProviders[" + name + "]"
+		if _, ok := ab.Config.Modules.OAuth2Providers[name]; ok {
+			problems = append(problems, authboss.ConfigProblem{Field: field, Problem: "is also the name of an oauth2 provider"})
+		}
+		if len(provider.EntityID) == 0 || len(provider.SSOURL) == 0 || len(provider.Certificates) == 0 {
+			problems = append(problems, authboss.ConfigProblem{Field: field, Problem: "needs an EntityID, SSOURL and Certificates"})
+		}
+	}
+
+	return problems
+}
+
+// Init module
+func (s *SAML) Init(ab *authboss.Authboss) error {
+	s.Authboss = ab
+
+	var names []string
+	for name := range s.Config.Modules.SAMLProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	notOK := s.Config.Paths.SAMLLoginNotOK
+	for _, name := range names {
+		name = strings.ToLower(name)
+
+		// Logging in writes to the storer, so don't start it in read-only mode
+		s.Config.Core.Router.Get("/saml/"+name, s.ReadOnlyGuard(notOK, s.Core.ErrorHandler.Wrap(s.Start)))
+		s.Config.Core.Router.Post("/saml/acs/"+name, authboss.CSRFExempt(s.ReadOnlyGuard(notOK, s.Core.ErrorHandler.Wrap(s.ACS))))
+		s.Config.Core.Router.Get("/saml/metadata/"+name, s.Core.ErrorHandler.Wrap(s.Metadata))
+	}
+
+	return nil
+}
+
+// Start sends the user to the identity provider with an AuthnRequest
+func (s *SAML) Start(w http.ResponseWriter, r *http.Request) error {
+	name, provider, err := s.provider(r)
+	if err != nil {
+		return err
+	}
+	s.RequestLogger(r).Infof("started saml login for provider: %s", name)
+
+	id, err := newRequestID()
+	if err != nil {
+		return err
+	}
+	authboss.PutSession(w, authboss.SessionSAMLRequest, id)
+
+	var req bytes.Buffer
+	req.WriteString(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"`)
+	writeAttr(&req, "ID", id)
+	req.WriteString(` Version="2.0"`)
+	writeAttr(&req, "IssueInstant", s.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	writeAttr(&req, "Destination", provider.SSOURL)
+	writeAttr(&req, "AssertionConsumerServiceURL", s.acsURL(name))
+	writeAttr(&req, "ProtocolBinding", bindingPost)
+	req.WriteString(`><saml:Issuer>`)
+	xml.EscapeText(&req, []byte(s.entityID(name)))
+	req.WriteString(`</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = fw.Write(req.Bytes()); err != nil {
+		return err
+	}
+	if err = fw.Close(); err != nil {
+		return err
+	}
+
+	query := url.Values{FormValueSAMLRequest: []string{base64.StdEncoding.EncodeToString(deflated.Bytes())}}
+	if redir := r.URL.Query().Get(FormValueSAMLRedir); len(redir) != 0 {
+		query.Set(FormValueRelayState, redir)
+	}
+
+	sep := "?"
+	if strings.Contains(provider.SSOURL, "?") {
+		sep = "&"
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: provider.SSOURL + sep + query.Encode(),
+	}
+	return s.Core.Redirector.Redirect(w, r, ro)
+}
+
+// ACS is the assertion consumer service the identity provider posts its
+// response to, it logs the user in if the response is valid.
+func (s *SAML) ACS(w http.ResponseWriter, r *http.Request) error {
+	logger := s.RequestLogger(r)
+
+	name, provider, err := s.provider(r)
+	if err != nil {
+		return err
+	}
+	logger.Infof("finishing saml login for provider: %s", name)
+
+	requestID, _ := authboss.GetSession(r, authboss.SessionSAMLRequest)
+	authboss.DelSession(w, authboss.SessionSAMLRequest)
+
+	raw, err := decodeBase64(r.FormValue(FormValueSAMLResponse))
+	if err != nil || len(raw) == 0 {
+		logger.Infof("saml response for %s could not be decoded", name)
+		return s.fail(w, r, name)
+	}
+
+	now := s.Now()
+	a, expires, err := validate(raw, expectations{
+		Issuer:            provider.EntityID,
+		Audience:          s.entityID(name),
+		Recipient:         s.acsURL(name),
+		RequestID:         requestID,
+		AllowIdPInitiated: provider.AllowIdPInitiated,
+		Certificates:      provider.Certificates,
+		Now:               now,
+	})
+	if err != nil {
+		logger.Infof("rejected saml response from %s: %v", name, err)
+		return s.fail(w, r, name)
+	}
+	if len(a.ID) == 0 || !s.replays.use(name+";"+a.ID, expires, now) {
+		logger.Infof("rejected replayed saml assertion from %s: %s", name, a.ID)
+		return s.fail(w, r, name)
+	}
+
+	user, err := s.saveUser(r.Context(), name, provider, a)
+	if err != nil {
+		return err
+	}
+	pid := authboss.MakeOAuth2PID(name, a.NameID)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	handled, err := s.Events.FireBefore(authboss.EventOAuth2, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	logger.Infof("user %s logged in with saml provider %s", pid, name)
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+	s.CountLogin("saml", true)
+
+	handled, err = s.Events.FireAfter(authboss.EventOAuth2, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	redirect := s.RedirectPath(r, "saml", authboss.RedirectOK, user, s.RequestConfig(r.Context()).Paths.SAMLLoginOK)
+	if relay := r.FormValue(FormValueRelayState); len(relay) != 0 {
+		if p, ok := s.SafeRedirect(relay); ok {
+			redirect = p
+		}
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: redirect,
+		Success:      s.Localize(r.Context(), authboss.TxtOAuth2LoggedIn, "Provider", strings.Title(name)),
+	}
+	return s.Core.Redirector.Redirect(w, r, ro)
+}
+
+// Metadata serves the service provider's metadata for the identity
+// provider
+func (s *SAML) Metadata(w http.ResponseWriter, r *http.Request) error {
+	name, _, err := s.provider(r)
+	if err != nil {
+		return err
+	}
+
+	var md bytes.Buffer
+	md.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	md.WriteString(`<md:EntityDescriptor xmlns:md="` + nsMetadata + `"`)
+	writeAttr(&md, "entityID", s.entityID(name))
+	md.WriteString(`><md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">`)
+	md.WriteString(`<md:AssertionConsumerService Binding="` + bindingPost + `"`)
+	writeAttr(&md, "Location", s.acsURL(name))
+	md.WriteString(` index="0" isDefault="true"/></md:SPSSODescriptor></md:EntityDescriptor>` + "\n")
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(md.Bytes())
+	return err
+}
+
+func (s *SAML) fail(w http.ResponseWriter, r *http.Request, name string) error {
+	s.CountLogin("saml", false)
+	r = s.WithAttempt(r, authboss.AttemptInfo{Module: "saml", Stage: authboss.AttemptStagePassword, Failure: authboss.ErrorCodeOAuth2Failed})
+	handled, err := s.Events.FireAfter(authboss.EventOAuth2Fail, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: s.RedirectPath(r, "saml", authboss.RedirectNotOK, nil, s.RequestConfig(r.Context()).Paths.SAMLLoginNotOK),
+		Failure:      s.Localize(r.Context(), authboss.TxtOAuth2LoginFailed, "Provider", strings.Title(name)),
+		FailureCode:  authboss.ErrorCodeOAuth2Failed,
+	}
+	return s.Core.Redirector.Redirect(w, r, ro)
+}
+
+// saveUser creates or updates the user from the assertion
+func (s *SAML) saveUser(ctx context.Context, name string, provider authboss.SAMLProvider, a *assertion) (authboss.OAuth2User, error) {
+	details := make(map[string]string, len(a.Attributes)+1)
+	for attribute, values := range a.Attributes {
+		if len(values) == 0 {
+			continue
+		}
+		key := attribute
+		if mapped, ok := provider.AttributeMap[attribute]; ok {
+			key = mapped
+		}
+		details[key] = values[0]
+	}
+	details[DetailUID] = a.NameID
+
+	storer := authboss.EnsureCanOAuth2(s.Config.Storage.Server)
+	user, err := storer.NewFromOAuth2(ctx, name, details)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create saml user from values")
+	}
+
+	user.PutOAuth2Provider(name)
+	user.PutOAuth2UID(a.NameID)
+	if err = storer.SaveOAuth2(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *SAML) provider(r *http.Request) (string, authboss.SAMLProvider, error) {
+	name := strings.ToLower(filepath.Base(r.URL.Path))
+	for key, provider := range s.Config.Modules.SAMLProviders {
+		if strings.ToLower(key) == name {
+			return name, provider, nil
+		}
+	}
+
+	return "", authboss.SAMLProvider{}, errors.Errorf("saml provider %q not found", name)
+}
+
+func (s *SAML) entityID(name string) string {
+	if len(s.Config.Modules.SAMLEntityID) != 0 {
+		return s.Config.Modules.SAMLEntityID
+	}
+	return s.url("/saml/metadata/" + name)
+}
+
+func (s *SAML) acsURL(name string) string {
+	return s.url("/saml/acs/" + name)
+}
+
+func (s *SAML) url(route string) string {
+	return s.Config.Paths.RootURL + path.Join(s.Config.Paths.Mount, route)
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, requestIDSize)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", errors.Wrap(err, "failed to create saml request id")
+	}
+
+	// Ids must not start with a digit
+	return "_" + hex.EncodeToString(b), nil
+}
+
+func writeAttr(buf *bytes.Buffer, name, value string) {
+	fmt.Fprintf(buf, ` %s="`, name)
+	_ = xml.EscapeText(buf, []byte(value))
+	buf.WriteByte('"')
+}
@@ -0,0 +1,306 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	router := &mocks.Router{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Modules.SAMLProviders = map[string]authboss.SAMLProvider{"idp": {}}
+
+	s := &SAML{}
+	if err := s.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := router.HasGets("/saml/idp", "/saml/metadata/idp"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/saml/acs/idp"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup(t)
+	if problems := h.saml.ValidateConfig(h.ab); len(problems) != 0 {
+		t.Error("there should be no problems:", problems)
+	}
+
+	h.ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{"idp": {}}
+	h.ab.Config.Modules.SAMLProviders["other"] = authboss.SAMLProvider{}
+	if problems := h.saml.ValidateConfig(h.ab); len(problems) != 2 {
+		t.Error("the name collision and incomplete provider should be problems:", problems)
+	}
+}
+
+type testHarness struct {
+	saml *SAML
+	ab   *authboss.Authboss
+
+	clock      *authtest.Clock
+	redirector *mocks.Redirector
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup(t *testing.T) *testHarness {
+	_, certs := fixture(t)
+
+	h := &testHarness{}
+
+	h.ab = authboss.New()
+	h.clock = authtest.NewClock(time.Date(2030, 1, 1, 0, 1, 0, 0, time.UTC))
+	h.redirector = &mocks.Redirector{}
+	h.session = mocks.NewClientRW()
+	h.storer = mocks.NewServerStorer()
+
+	h.ab.Config.Modules.SAMLProviders = map[string]authboss.SAMLProvider{
+		"idp": {
+			EntityID:     "https://idp.example.com",
+			SSOURL:       "https://idp.example.com/sso",
+			Certificates: certs,
+			AttributeMap: map[string]string{"displayName": "name"},
+		},
+	}
+
+	h.ab.Config.Paths.RootURL = "https://sp.example.com"
+	h.ab.Config.Paths.Mount = "/auth"
+	h.ab.Config.Paths.SAMLLoginOK = "/auth/saml/ok"
+	h.ab.Config.Paths.SAMLLoginNotOK = "/auth/saml/not/ok"
+
+	h.ab.Config.Core.Router = &mocks.Router{}
+	h.ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	h.ab.Config.Core.Clock = h.clock
+	h.ab.Config.Core.Logger = mocks.Logger{}
+	h.ab.Config.Core.Redirector = h.redirector
+	h.ab.Config.Storage.SessionState = h.session
+	h.ab.Config.Storage.Server = h.storer
+
+	h.saml = &SAML{Authboss: h.ab}
+
+	return h
+}
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup(t)
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "/auth/saml/idp?redir=/dashboard", nil)
+
+	if err := h.saml.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	u, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "idp.example.com" || u.Path != "/sso" {
+		t.Error("it should have redirected to the identity provider:", u)
+	}
+	if relay := u.Query().Get(FormValueRelayState); relay != "/dashboard" {
+		t.Error("relay state was wrong:", relay)
+	}
+
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get(FormValueSAMLRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := h.session.ClientValues[authboss.SessionSAMLRequest]
+	if len(id) == 0 || req.attr("ID") != id {
+		t.Errorf("the request id %q should have been saved in the session: %q", req.attr("ID"), id)
+	}
+	if acs := req.attr("AssertionConsumerServiceURL"); acs != "https://sp.example.com/auth/saml/acs/idp" {
+		t.Error("acs url was wrong:", acs)
+	}
+	if issuer := req.child(nsAssertion, "Issuer"); issuer == nil || issuer.text() != "https://sp.example.com/auth/saml/metadata/idp" {
+		t.Error("issuer was wrong")
+	}
+}
+
+func TestStartBadProvider(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup(t)
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r := httptest.NewRequest("GET", "/auth/saml/test", nil)
+
+	if err := h.saml.Start(w, r); err == nil || !strings.Contains(err.Error(), `provider "test" not found`) {
+		t.Error("it should have errored:", err)
+	}
+}
+
+func (h *testHarness) post(t *testing.T, requestID string, form url.Values) {
+	t.Helper()
+
+	h.session.ClientValues[authboss.SessionSAMLRequest] = requestID
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := httptest.NewRequest("POST", "/auth/saml/acs/idp", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.saml.ACS(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK) // Flush headers
+}
+
+func TestACS(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup(t)
+	doc, _ := fixture(t)
+
+	form := url.Values{
+		FormValueSAMLResponse: []string{base64.StdEncoding.EncodeToString([]byte(doc))},
+		FormValueRelayState:   []string{"/dashboard"},
+	}
+	h.post(t, "_request1", form)
+
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/dashboard" || len(opts.Success) == 0 {
+		t.Errorf("it should have redirected to the relay state: %#v", opts)
+	}
+
+	pid := authboss.MakeOAuth2PID("idp", "jane@example.com")
+	if s := h.session.ClientValues[authboss.SessionKey]; s != pid {
+		t.Error("session id should have been set:", s)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionSAMLRequest]; ok {
+		t.Error("the request id should have been deleted")
+	}
+
+	user, ok := h.storer.Users[pid]
+	if !ok {
+		t.Fatal("the user should have been saved")
+	}
+	if user.Email != "jane@example.com" || user.Username != "Jane & Co" {
+		t.Errorf("user details were wrong: %#v", user)
+	}
+
+	// The same assertion can't be used twice
+	delete(h.session.ClientValues, authboss.SessionKey)
+	h.post(t, "_request1", form)
+
+	if opts := h.redirector.Options; opts.RedirectPath != "/auth/saml/not/ok" || len(opts.Failure) == 0 {
+		t.Errorf("the replay should have failed: %#v", opts)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the replay should not have logged in")
+	}
+}
+
+func TestACSFailures(t *testing.T) {
+	t.Parallel()
+
+	doc, _ := fixture(t)
+	encoded := base64.StdEncoding.EncodeToString([]byte(doc))
+
+	tests := []struct {
+		Name      string
+		RequestID string
+		Response  string
+		Advance   time.Duration
+	}{
+		{"not base64", "_request1", "!!!", 0},
+		{"other request", "_request2", encoded, 0},
+		{"no request", "", encoded, 0},
+		{"expired", "_request1", encoded, time.Hour},
+	}
+
+	for _, test := range tests {
+		h := testSetup(t)
+		h.clock.Advance(test.Advance)
+
+		var failed bool
+		h.ab.Events.After(authboss.EventOAuth2Fail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			failed = true
+			return false, nil
+		})
+
+		h.post(t, test.RequestID, url.Values{FormValueSAMLResponse: []string{test.Response}})
+
+		if opts := h.redirector.Options; opts.RedirectPath != "/auth/saml/not/ok" || opts.FailureCode != authboss.ErrorCodeOAuth2Failed {
+			t.Errorf("%s: it should have failed: %#v", test.Name, opts)
+		}
+		if !failed {
+			t.Errorf("%s: the fail event should have fired", test.Name)
+		}
+		if len(h.session.ClientValues[authboss.SessionKey]) != 0 {
+			t.Errorf("%s: it should not have logged in", test.Name)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup(t)
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/auth/saml/metadata/idp", nil)
+
+	if err := h.saml.Metadata(rec, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/samlmetadata+xml" {
+		t.Error("content type was wrong:", ct)
+	}
+
+	md, err := parse(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := md.attr("entityID"); id != "https://sp.example.com/auth/saml/metadata/idp" {
+		t.Error("entity id was wrong:", id)
+	}
+	sp := md.child(nsMetadata, "SPSSODescriptor")
+	if sp == nil {
+		t.Fatal("there should be an SPSSODescriptor")
+	}
+	acs := sp.child(nsMetadata, "AssertionConsumerService")
+	if acs == nil || acs.attr("Location") != "https://sp.example.com/auth/saml/acs/idp" {
+		t.Error("acs location was wrong")
+	}
+}
@@ -0,0 +1,181 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"strings"
+
+	// The hashes of the supported signature and digest methods
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	nsDSig   = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcC14 = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	algExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512      = "http://www.w3.org/2001/04/xmlenc#sha512"
+	algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	algECDSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+var (
+	digestMethods = map[string]crypto.Hash{
+		algSHA256: crypto.SHA256,
+		algSHA512: crypto.SHA512,
+	}
+	signatureMethods = map[string]crypto.Hash{
+		algRSASHA256:   crypto.SHA256,
+		algRSASHA512:   crypto.SHA512,
+		algECDSASHA256: crypto.SHA256,
+		algECDSASHA512: crypto.SHA512,
+	}
+)
+
+// signed reports whether the element has an enveloped signature
+func signed(el *node) bool {
+	return el.child(nsDSig, "Signature") != nil
+}
+
+// verify the enveloped signature of the element with one of the certs.
+// Only what SAML identity providers use is supported: one reference to the
+// element itself, the enveloped signature and exclusive canonicalization
+// transforms and SHA-256 or SHA-512 with RSA or ECDSA. The certificates
+// in the signature's KeyInfo are never trusted.
+func verify(el *node, certs []*x509.Certificate) error {
+	sig := el.child(nsDSig, "Signature")
+	if sig == nil {
+		return errors.New("element is not signed")
+	}
+	signedInfo := sig.child(nsDSig, "SignedInfo")
+	if signedInfo == nil {
+		return errors.New("signature has no SignedInfo")
+	}
+
+	c14n := signedInfo.child(nsDSig, "CanonicalizationMethod")
+	if c14n == nil || c14n.attr("Algorithm") != algExcC14N {
+		return errors.New("signature must use exclusive canonicalization")
+	}
+	sigMethod := signedInfo.child(nsDSig, "SignatureMethod")
+	if sigMethod == nil {
+		return errors.New("signature has no SignatureMethod")
+	}
+	sigHash, ok := signatureMethods[sigMethod.attr("Algorithm")]
+	if !ok {
+		return errors.Errorf("unsupported signature method %q", sigMethod.attr("Algorithm"))
+	}
+
+	refs := signedInfo.all(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return errors.New("signature must have exactly one reference")
+	}
+	ref := refs[0]
+	if id := el.attr("ID"); len(id) == 0 || ref.attr("URI") != "#"+id {
+		return errors.New("signature doesn't reference the signed element")
+	}
+
+	var inclusive []string
+	var canonical bool
+	if transforms := ref.child(nsDSig, "Transforms"); transforms != nil {
+		for _, t := range transforms.all(nsDSig, "Transform") {
+			switch t.attr("Algorithm") {
+			case algEnveloped:
+			case algExcC14N:
+				canonical = true
+				inclusive = prefixList(t)
+			default:
+				return errors.Errorf("unsupported transform %q", t.attr("Algorithm"))
+			}
+		}
+	}
+	if !canonical {
+		return errors.New("signature must use exclusive canonicalization")
+	}
+
+	digestMethod := ref.child(nsDSig, "DigestMethod")
+	if digestMethod == nil {
+		return errors.New("reference has no DigestMethod")
+	}
+	digestHash, ok := digestMethods[digestMethod.attr("Algorithm")]
+	if !ok {
+		return errors.Errorf("unsupported digest method %q", digestMethod.attr("Algorithm"))
+	}
+	digestValue := ref.child(nsDSig, "DigestValue")
+	if digestValue == nil {
+		return errors.New("reference has no DigestValue")
+	}
+	wantDigest, err := decodeBase64(digestValue.text())
+	if err != nil {
+		return errors.Wrap(err, "failed to decode digest")
+	}
+
+	h := digestHash.New()
+	h.Write(canonicalize(el, sig, inclusive))
+	if subtle.ConstantTimeCompare(h.Sum(nil), wantDigest) != 1 {
+		return errors.New("digest of the signed element doesn't match")
+	}
+
+	sigValue := sig.child(nsDSig, "SignatureValue")
+	if sigValue == nil {
+		return errors.New("signature has no SignatureValue")
+	}
+	signature, err := decodeBase64(sigValue.text())
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	h = sigHash.New()
+	h.Write(canonicalize(signedInfo, nil, prefixList(c14n)))
+	hashed := h.Sum(nil)
+	for _, cert := range certs {
+		if checkSignature(cert, sigHash, hashed, signature) {
+			return nil
+		}
+	}
+
+	return errors.New("signature was not made by the identity provider")
+}
+
+func checkSignature(cert *x509.Certificate, hash crypto.Hash, hashed, signature []byte) bool {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, hash, hashed, signature) == nil
+	case *ecdsa.PublicKey:
+		// XML signatures have r and s concatenated rather than DER encoded
+		if len(signature) == 0 || len(signature)%2 != 0 {
+			return false
+		}
+		half := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:half])
+		s := new(big.Int).SetBytes(signature[half:])
+		return ecdsa.Verify(key, hashed, r, s)
+	default:
+		return false
+	}
+}
+
+// prefixList is the InclusiveNamespaces PrefixList of a canonicalization
+// method or transform
+func prefixList(method *node) []string {
+	if in := method.child(nsExcC14, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.attr("PrefixList"))
+	}
+	return nil
+}
+
+// decodeBase64 ignores the line breaks base64 in xml usually has
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
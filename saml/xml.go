@@ -0,0 +1,363 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+const nsXML = "http://www.w3.org/XML/1998/namespace"
+
+// node is an element of a parsed document. It keeps the prefixes and
+// namespace declarations it was written with since canonicalizing it for a
+// signature needs them, encoding/xml's own decoding throws them away.
+type node struct {
+	parent *node
+
+	prefix, local string
+	space         string
+
+	// decls are the namespaces declared on the element by prefix, "" is
+	// the default namespace
+	decls map[string]string
+	attrs []attr
+
+	// children are *node, text or procInst
+	children []interface{}
+}
+
+type attr struct {
+	prefix, local string
+	space         string
+	value         string
+}
+
+type text string
+
+type procInst struct {
+	target, inst string
+}
+
+// parse the document, documents with a DTD are refused since nothing in
+// SAML needs one and they're how entity expansion attacks start
+func parse(doc []byte) (*node, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	dec.Strict = true
+
+	var root, cur *node
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to parse xml")
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root != nil && cur == nil {
+				return nil, errors.New("xml has more than one root element")
+			}
+
+			n := &node{parent: cur, prefix: t.Name.Space, local: t.Name.Local, decls: map[string]string{}}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					n.decls[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					n.decls[""] = a.Value
+				default:
+					n.attrs = append(n.attrs, attr{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+				}
+			}
+
+			var ok bool
+			if n.space, ok = n.lookup(n.prefix); !ok {
+				return nil, errors.Errorf("xml prefix %q is not declared", n.prefix)
+			}
+			for i, a := range n.attrs {
+				if len(a.prefix) == 0 {
+					continue
+				}
+				if n.attrs[i].space, ok = n.lookup(a.prefix); !ok {
+					return nil, errors.Errorf("xml prefix %q is not declared", a.prefix)
+				}
+			}
+
+			if cur == nil {
+				root = n
+			} else {
+				cur.children = append(cur.children, n)
+			}
+			cur = n
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, errors.New("xml end element doesn't match its start")
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, text(t))
+			}
+		case xml.ProcInst:
+			if cur != nil {
+				cur.children = append(cur.children, procInst{target: t.Target, inst: string(t.Inst)})
+			}
+		case xml.Directive:
+			return nil, errors.New("xml directives (like DOCTYPE) are not allowed")
+		}
+	}
+
+	if root == nil || cur != nil {
+		return nil, errors.New("xml document is incomplete")
+	}
+
+	return root, nil
+}
+
+// lookup the namespace of prefix where the element is
+func (n *node) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXML, true
+	}
+
+	for e := n; e != nil; e = e.parent {
+		if space, ok := e.decls[prefix]; ok {
+			return space, true
+		}
+	}
+
+	// No default namespace is the empty namespace
+	return "", len(prefix) == 0
+}
+
+func (n *node) is(space, local string) bool {
+	return n.space == space && n.local == local
+}
+
+// attr returns the value of the attribute without a namespace
+func (n *node) attr(local string) string {
+	for _, a := range n.attrs {
+		if len(a.space) == 0 && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// child is the first child element with the name
+func (n *node) child(space, local string) *node {
+	for _, c := range n.children {
+		if e, ok := c.(*node); ok && e.is(space, local) {
+			return e
+		}
+	}
+	return nil
+}
+
+// all the child elements with the name
+func (n *node) all(space, local string) []*node {
+	var elems []*node
+	for _, c := range n.children {
+		if e, ok := c.(*node); ok && e.is(space, local) {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// text is the text directly inside the element, trimmed
+func (n *node) text() string {
+	var b strings.Builder
+	for _, c := range n.children {
+		if t, ok := c.(text); ok {
+			b.WriteString(string(t))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// ids returns the elements by their ID attribute, it fails if two
+// elements have the same id since which one a signature refers to would
+// be ambiguous (signature wrapping attacks rely on it).
+func ids(root *node) (map[string]*node, error) {
+	found := make(map[string]*node)
+
+	var walk func(n *node) error
+	walk = func(n *node) error {
+		if id := n.attr("ID"); len(id) != 0 {
+			if _, ok := found[id]; ok {
+				return errors.Errorf("xml id %q is used more than once", id)
+			}
+			found[id] = n
+		}
+		for _, c := range n.children {
+			if e, ok := c.(*node); ok {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return found, walk(root)
+}
+
+// canonicalize the element with Exclusive XML Canonicalization without
+// comments (https://www.w3.org/TR/xml-exc-c14n/). The skip element (the
+// signature for the enveloped signature transform) is left out, inclusive
+// are the prefixes of the InclusiveNamespaces PrefixList which are
+// rendered whenever they're in scope ("#default" is the default namespace).
+func canonicalize(n *node, skip *node, inclusive []string) []byte {
+	c := canonicalizer{skip: skip, inclusive: inclusive}
+	c.element(n, map[string]string{})
+	return c.buf.Bytes()
+}
+
+type canonicalizer struct {
+	buf       bytes.Buffer
+	skip      *node
+	inclusive []string
+}
+
+func (c *canonicalizer) element(n *node, rendered map[string]string) {
+	// Namespaces are rendered where they're visibly used (by the element
+	// or its attributes) unless an output ancestor already rendered them
+	used := map[string]bool{n.prefix: true}
+	for _, a := range n.attrs {
+		if len(a.prefix) != 0 {
+			used[a.prefix] = true
+		}
+	}
+	for _, prefix := range c.inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if _, ok := n.lookup(prefix); ok {
+			used[prefix] = true
+		}
+	}
+
+	var prefixes []string
+	inScope := make(map[string]string, len(rendered))
+	for prefix, space := range rendered {
+		inScope[prefix] = space
+	}
+	for prefix := range used {
+		if prefix == "xml" {
+			continue
+		}
+		space, _ := n.lookup(prefix)
+		if have, ok := rendered[prefix]; ok && have == space {
+			continue
+		}
+		// Not having a default namespace only needs saying if an output
+		// ancestor had one
+		if len(prefix) == 0 && len(space) == 0 && len(rendered[""]) == 0 {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+		inScope[prefix] = space
+	}
+	sort.Strings(prefixes)
+
+	attrs := append([]attr(nil), n.attrs...)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	c.buf.WriteByte('<')
+	c.name(n.prefix, n.local)
+	for _, prefix := range prefixes {
+		if len(prefix) == 0 {
+			c.buf.WriteString(` xmlns="`)
+		} else {
+			c.buf.WriteString(" xmlns:" + prefix + `="`)
+		}
+		escapeAttr(&c.buf, inScope[prefix])
+		c.buf.WriteByte('"')
+	}
+	for _, a := range attrs {
+		c.buf.WriteByte(' ')
+		c.name(a.prefix, a.local)
+		c.buf.WriteString(`="`)
+		escapeAttr(&c.buf, a.value)
+		c.buf.WriteByte('"')
+	}
+	c.buf.WriteByte('>')
+
+	for _, child := range n.children {
+		switch ch := child.(type) {
+		case *node:
+			if ch != c.skip {
+				c.element(ch, inScope)
+			}
+		case text:
+			escapeText(&c.buf, string(ch))
+		case procInst:
+			c.buf.WriteString("<?" + ch.target)
+			if len(ch.inst) != 0 {
+				c.buf.WriteString(" " + ch.inst)
+			}
+			c.buf.WriteString("?>")
+		}
+	}
+
+	c.buf.WriteString("</")
+	c.name(n.prefix, n.local)
+	c.buf.WriteByte('>')
+}
+
+func (c *canonicalizer) name(prefix, local string) {
+	if len(prefix) != 0 {
+		c.buf.WriteString(prefix)
+		c.buf.WriteByte(':')
+	}
+	c.buf.WriteString(local)
+}
+
+func escapeText(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func escapeAttr(buf *bytes.Buffer, s string) {
+	for _, r := range s {
+		switch r {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '"':
+			buf.WriteString("&quot;")
+		case '\t':
+			buf.WriteString("&#x9;")
+		case '\n':
+			buf.WriteString("&#xA;")
+		case '\r':
+			buf.WriteString("&#xD;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package authboss
+
+import "context"
+
+// SecretEncryptor encrypts the secrets the modules store with users (the
+// totp2fa secret, the sms2fa phone number) before they're handed to the
+// storer and decrypts them when they're read back, so they aren't kept in
+// plaintext columns. See defaults.AESSecretEncryptor.
+//
+// The name is the secret's attribute (see AttributeTOTPSecretKey), it
+// should be authenticated with the ciphertext so one secret can't be
+// passed off as another.
+type SecretEncryptor interface {
+	EncryptSecret(ctx context.Context, name, secret string) (string, error)
+	// DecryptSecret returns the secret, reencrypt is true when it should
+	// be encrypted again and saved: it's still plaintext because it was
+	// stored before there was an encryptor, or it was encrypted with a
+	// key that's being rotated out.
+	DecryptSecret(ctx context.Context, name, value string) (secret string, reencrypt bool, err error)
+}
+
+// EncryptSecret encrypts a secret that's about to be stored with a user
+// with Config.Core.SecretEncryptor, it's returned as is when there's no
+// encryptor or it's empty (as when a second factor is removed).
+func (a *Authboss) EncryptSecret(ctx context.Context, name, secret string) (string, error) {
+	if a.Config.Core.SecretEncryptor == nil || len(secret) == 0 {
+		return secret, nil
+	}
+	return a.Config.Core.SecretEncryptor.EncryptSecret(ctx, name, secret)
+}
+
+// DecryptSecret decrypts a secret stored with a user, see EncryptSecret
+func (a *Authboss) DecryptSecret(ctx context.Context, name, value string) (secret string, reencrypt bool, err error) {
+	if a.Config.Core.SecretEncryptor == nil || len(value) == 0 {
+		return value, false, nil
+	}
+	return a.Config.Core.SecretEncryptor.DecryptSecret(ctx, name, value)
+}
+
+// LoadSecret decrypts a secret stored with a user and migrates it lazily:
+// when it has to be encrypted again it's put back encrypted with the
+// current key and the user is saved, unless Storage.ReadOnly is set.
+// Secrets that were stored before the SecretEncryptor was set are
+// encrypted the first time they're used this way.
+func (a *Authboss) LoadSecret(ctx context.Context, user User, name, value string, put func(string)) (string, error) {
+	secret, reencrypt, err := a.DecryptSecret(ctx, name, value)
+	if err != nil || !reencrypt || a.Config.Storage.ReadOnly {
+		return secret, err
+	}
+
+	encrypted, err := a.EncryptSecret(ctx, name, secret)
+	if err != nil {
+		return "", err
+	}
+	put(encrypted)
+	if err = a.SaveUser(ctx, user); err != nil {
+		return "", err
+	}
+
+	a.Logger(ctx).Infof("encrypted the %s of user %s again", name, user.GetPID())
+	return secret, nil
+}
@@ -0,0 +1,50 @@
+package authboss
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type testEncryptor struct{}
+
+func (testEncryptor) EncryptSecret(ctx context.Context, name, secret string) (string, error) {
+	return "enc:" + secret, nil
+}
+
+func (testEncryptor) DecryptSecret(ctx context.Context, name, value string) (string, bool, error) {
+	if strings.HasPrefix(value, "enc:") {
+		return strings.TrimPrefix(value, "enc:"), false, nil
+	}
+	return value, true, nil
+}
+
+func TestLoadSecret(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	storer := &mockServerStorer{Users: map[string]*mockUser{}}
+	ab.Config.Storage.Server = storer
+	ab.Config.Core.Logger = mockLogger{}
+	ab.Config.Core.SecretEncryptor = testEncryptor{}
+
+	user := &mockUser{Email: "test@test.com"}
+	storer.Users[user.Email] = user
+
+	stored := "secret"
+	secret, err := ab.LoadSecret(context.Background(), user, AttributeTOTPSecretKey, stored, func(s string) { stored = s })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "secret" {
+		t.Error("secret was wrong:", secret)
+	}
+	if stored != "enc:secret" {
+		t.Error("the plaintext secret should be encrypted:", stored)
+	}
+
+	ab.Config.Core.SecretEncryptor = nil
+	if encrypted, err := ab.EncryptSecret(context.Background(), AttributeTOTPSecretKey, "secret"); err != nil || encrypted != "secret" {
+		t.Error("without an encryptor secrets are stored as they are:", encrypted, err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package sessionlimit limits how many sessions a user can be logged in
+// with at the same time.
+package sessionlimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// SessionRecordKey is the session key that holds the id of the
+	// session's SessionRecord
+	SessionRecordKey = "session_record"
+
+	nIDSize = 32
+)
+
+func init() {
+	authboss.RegisterModule("sessionlimit", &SessionLimit{})
+}
+
+// SessionLimit module
+type SessionLimit struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the storer can record sessions
+func (s *SessionLimit) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Logger", "Storage.Server")
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.SessionServerStorer)(nil))...)
+}
+
+// Init module
+func (s *SessionLimit) Init(ab *authboss.Authboss) error {
+	s.Authboss = ab
+
+	s.Events.Before(authboss.EventAuth, s.BeforeAuth)
+	s.Events.Before(authboss.EventOAuth2, s.BeforeAuth)
+	s.Events.After(authboss.EventAuth, s.AfterAuth)
+	s.Events.After(authboss.EventOAuth2, s.AfterAuth)
+	s.Events.After(authboss.EventRememberLogin, s.AfterAuth)
+	s.Events.After(authboss.EventLogout, s.AfterLogout)
+
+	return nil
+}
+
+// BeforeAuth rejects the login if the user is at the session limit and
+// Modules.SessionLimitRejectNew says so. Stale sessions (see
+// Modules.SessionLimitIdle) are pruned first and don't count.
+func (s *SessionLimit) BeforeAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	limit := s.Config.Modules.SessionLimit
+	rejectNew := s.Config.Modules.SessionLimitRejectNew
+	if limit <= 0 || rejectNew == nil {
+		return false, nil
+	}
+
+	user, err := s.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+	pid := authboss.UserPID(user)
+
+	storer := authboss.EnsureCanRecordSessions(s.Config.Storage.Server)
+	sessions, err := liveSessions(s.Authboss, r.Context(), storer, pid)
+	if err != nil {
+		return false, err
+	}
+
+	if len(sessions) < limit || !rejectNew(r, pid, sessions) {
+		return false, nil
+	}
+
+	logger := s.RequestLogger(r)
+	logger.Infof("user %s prevented from logging in: at the limit of %d sessions", pid, limit)
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      s.Localize(r.Context(), authboss.TxtTooManySessions),
+		FailureCode:  authboss.ErrorCodeTooManySessions,
+		RedirectPath: s.RedirectPath(r, "sessionlimit", authboss.RedirectNotOK, user, s.RequestConfig(r.Context()).Paths.SessionLimitNotOK),
+	}
+	return true, s.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// AfterAuth records the new session and logs out the user's oldest
+// sessions if they're over the limit. It's also called for remember me
+// logins, which resume a login rather than start one so they always make
+// room for themselves instead of being rejected.
+func (s *SessionLimit) AfterAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	user, err := s.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+	pid := authboss.UserPID(user)
+
+	id := make([]byte, nIDSize)
+	if _, err = io.ReadFull(rand.Reader, id); err != nil {
+		return false, errors.Wrap(err, "failed to create session record id")
+	}
+
+	now := s.Now().UTC()
+	record := authboss.SessionRecord{
+		ID:       base64.RawURLEncoding.EncodeToString(id),
+		Created:  now,
+		LastSeen: now,
+	}
+
+	storer := authboss.EnsureCanRecordSessions(s.Config.Storage.Server)
+	if err = storer.AddSessionRecord(r.Context(), pid, record); err != nil {
+		return false, errors.Wrap(err, "failed to add session record")
+	}
+	authboss.PutSession(w, SessionRecordKey, record.ID)
+
+	limit := s.Config.Modules.SessionLimit
+	if limit <= 0 {
+		return false, nil
+	}
+
+	sessions, err := liveSessions(s.Authboss, r.Context(), storer, pid)
+	if err != nil {
+		return false, err
+	}
+
+	var others []authboss.SessionRecord
+	for _, session := range sessions {
+		if session.ID != record.ID {
+			others = append(others, session)
+		}
+	}
+	if len(others) < limit {
+		return false, nil
+	}
+
+	sort.SliceStable(others, func(i, j int) bool { return others[i].Created.Before(others[j].Created) })
+	evict := others[:len(others)-limit+1]
+	for _, session := range evict {
+		if err = storer.DelSessionRecord(r.Context(), pid, session.ID); err != nil {
+			return false, errors.Wrap(err, "failed to delete session record")
+		}
+	}
+
+	logger := s.RequestLogger(r)
+	logger.Infof("logged out %d of user %s's oldest sessions: over the limit of %d sessions", len(evict), pid, limit)
+
+	return false, nil
+}
+
+// AfterLogout deletes the session's record
+func (s *SessionLimit) AfterLogout(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	id, ok := authboss.GetSession(r, SessionRecordKey)
+	if !ok {
+		return false, nil
+	}
+
+	pid, err := sessionPID(s.Authboss, r)
+	if err != nil || len(pid) == 0 {
+		return false, err
+	}
+
+	storer := authboss.EnsureCanRecordSessions(s.Config.Storage.Server)
+	return false, storer.DelSessionRecord(r.Context(), pid, id)
+}
+
+// Middleware logs out sessions whose record has been deleted or gone
+// stale, which is how the oldest sessions are logged out when a user goes
+// over the limit. The user is redirected to Paths.SessionLimitNotOK. It
+// keeps the LastSeen of the records of sessions in use up to date, writing
+// them at most every tenth of Modules.SessionLimitIdle.
+//
+// Sessions without a record, such as those from before the module was
+// enabled, are left alone.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := authboss.GetSession(r, SessionRecordKey)
+			pid, _ := sessionPID(ab, r)
+			if !ok || len(pid) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			storer := authboss.EnsureCanRecordSessions(ab.Config.Storage.Server)
+			sessions, err := storer.LoadSessionRecords(r.Context(), pid)
+			if err != nil {
+				logger := ab.RequestLogger(r)
+				logger.Errorf("failed to load session records: %+v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := ab.Now().UTC()
+			for _, session := range sessions {
+				if session.ID != id || stale(ab, session, now) {
+					continue
+				}
+
+				if idle := ab.Config.Modules.SessionLimitIdle; idle > 0 && now.Sub(lastSeen(session)) >= idle/10 {
+					if err := storer.TouchSessionRecord(r.Context(), pid, id, now); err != nil {
+						logger := ab.RequestLogger(r)
+						logger.Errorf("failed to touch session record: %+v", err)
+					}
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+			logger.Infof("user %s logged out of %s: session was ended by a newer login", pid, r.URL.Path)
+
+			authboss.DelAllSession(w, ab.Config.Storage.SessionStateWhitelistKeys)
+			authboss.DelKnownSession(w)
+			authboss.DelSession(w, SessionRecordKey)
+
+			handled, err := ab.Events.FireAfter(authboss.EventSessionDestroy, w, r)
+			if err != nil {
+				logger.Errorf("failed to fire %s: %+v", authboss.EventSessionDestroy, err)
+			} else if handled {
+				return
+			}
+
+			ro := authboss.RedirectOptions{
+				Code:         http.StatusTemporaryRedirect,
+				Failure:      ab.Localize(ab.LocaleContext(r), authboss.TxtSessionEvicted),
+				FailureCode:  authboss.ErrorCodeNotAuthorized,
+				RedirectPath: ab.RedirectPath(r, "sessionlimit", authboss.RedirectNotOK, nil, ab.RequestConfig(r.Context()).Paths.SessionLimitNotOK),
+			}
+			if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+				logger.Errorf("error redirecting in sessionlimit.Middleware: %+v", err)
+			}
+		})
+	}
+}
+
+// liveSessions loads the user's session records, deleting the stale ones
+func liveSessions(ab *authboss.Authboss, ctx context.Context, storer authboss.SessionServerStorer, pid string) ([]authboss.SessionRecord, error) {
+	sessions, err := storer.LoadSessionRecords(ctx, pid)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load session records")
+	}
+
+	now := ab.Now().UTC()
+	live := sessions[:0:0]
+	for _, session := range sessions {
+		if !stale(ab, session, now) {
+			live = append(live, session)
+			continue
+		}
+
+		if err = storer.DelSessionRecord(ctx, pid, session.ID); err != nil {
+			return nil, errors.Wrap(err, "failed to delete stale session record")
+		}
+	}
+
+	return live, nil
+}
+
+// stale checks if the session hasn't been used in Modules.SessionLimitIdle,
+// records without any times are never stale
+func stale(ab *authboss.Authboss, session authboss.SessionRecord, now time.Time) bool {
+	idle, seen := ab.Config.Modules.SessionLimitIdle, lastSeen(session)
+	return idle > 0 && !seen.IsZero() && now.Sub(seen) >= idle
+}
+
+// lastSeen is when the session was last used, records from before LastSeen
+// was kept only have the login time
+func lastSeen(session authboss.SessionRecord) time.Time {
+	if session.LastSeen.IsZero() {
+		return session.Created
+	}
+	return session.LastSeen
+}
+
+// sessionPID is the pid of the user who logged in the session, which is
+// the impersonator's while they're impersonating someone
+func sessionPID(ab *authboss.Authboss, r *http.Request) (string, error) {
+	if pid, ok := authboss.ImpersonatorID(r); ok {
+		return pid, nil
+	}
+
+	return ab.CurrentUserID(r)
+}
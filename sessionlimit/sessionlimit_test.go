@@ -0,0 +1,291 @@
+package sessionlimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	s := &SessionLimit{}
+	if err := s.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testHarness struct {
+	limit *SessionLimit
+	ab    *authboss.Authboss
+
+	redirector *mocks.Redirector
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.redirector = &mocks.Redirector{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.SessionLimitNotOK = "/limit/not/ok"
+	harness.ab.Modules.SessionLimit = 2
+
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.limit = &SessionLimit{harness.ab}
+
+	return harness
+}
+
+func (h *testHarness) login(t *testing.T, user *mocks.User) (bool, string) {
+	t.Helper()
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if handled, err := h.limit.BeforeAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	} else if handled {
+		return true, ""
+	}
+
+	if _, err := h.limit.AfterAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	return false, h.session.ClientValues[SessionRecordKey]
+}
+
+func TestEvictOldest(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	h.storer.Sessions[user.Email] = []authboss.SessionRecord{
+		{ID: "newer", Created: time.Now().Add(-time.Hour)},
+		{ID: "oldest", Created: time.Now().Add(-2 * time.Hour)},
+	}
+
+	if rejected, id := h.login(t, user); rejected || len(id) == 0 {
+		t.Fatal("the login should have been recorded")
+	}
+
+	sessions := h.storer.Sessions[user.Email]
+	if len(sessions) != 2 {
+		t.Fatal("want 2 sessions, got:", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.ID == "oldest" {
+			t.Error("the oldest session should have been logged out")
+		}
+	}
+}
+
+func TestRejectNew(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.SessionLimitRejectNew = func(r *http.Request, pid string, sessions []authboss.SessionRecord) bool {
+		return true
+	}
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	for i := 0; i < 2; i++ {
+		if rejected, _ := h.login(t, user); rejected {
+			t.Fatal("logins under the limit should be allowed")
+		}
+	}
+
+	if rejected, _ := h.login(t, user); !rejected {
+		t.Error("the login over the limit should have been rejected")
+	}
+	if h.redirector.Options.RedirectPath != "/limit/not/ok" || len(h.redirector.Options.Failure) == 0 {
+		t.Errorf("redirect was wrong: %#v", h.redirector.Options)
+	}
+	if len(h.storer.Sessions[user.Email]) != 2 {
+		t.Error("no session should have been logged out")
+	}
+}
+
+func TestRejectNewStale(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Modules.SessionLimitRejectNew = func(r *http.Request, pid string, sessions []authboss.SessionRecord) bool {
+		return true
+	}
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	gone := time.Now().Add(-2 * h.ab.Modules.SessionLimitIdle)
+	h.storer.Sessions[user.Email] = []authboss.SessionRecord{
+		{ID: "closed", Created: gone, LastSeen: gone},
+		{ID: "expired", Created: gone},
+	}
+
+	if rejected, _ := h.login(t, user); rejected {
+		t.Error("stale sessions should not count towards the limit")
+	}
+	if sessions := h.storer.Sessions[user.Email]; len(sessions) != 1 {
+		t.Error("the stale sessions should have been pruned:", sessions)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Sessions["test@test.com"] = []authboss.SessionRecord{{ID: "current"}}
+
+	serve := func(id string) (bool, *httptest.ResponseRecorder) {
+		h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+		h.session.ClientValues[SessionRecordKey] = id
+
+		rec := httptest.NewRecorder()
+		w := h.ab.NewResponse(rec)
+		r, err := h.ab.LoadClientState(w, mocks.Request("GET"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		called := false
+		Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})).ServeHTTP(w, r)
+		w.WriteHeader(http.StatusOK)
+
+		return called, rec
+	}
+
+	if called, _ := serve("current"); !called {
+		t.Error("a recorded session should be let through")
+	}
+
+	called, rec := serve("evicted")
+	if called {
+		t.Error("an evicted session should be logged out")
+	}
+	if rec.Code != http.StatusTemporaryRedirect || h.redirector.Options.RedirectPath != "/limit/not/ok" {
+		t.Error("the user should have been redirected:", rec.Code)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("the session should have been deleted")
+	}
+}
+
+func TestMiddlewareLastSeen(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	idle := h.ab.Modules.SessionLimitIdle
+	now := time.Now().UTC()
+	h.storer.Sessions["test@test.com"] = []authboss.SessionRecord{
+		{ID: "recent", Created: now.Add(-time.Hour), LastSeen: now.Add(-time.Minute)},
+		{ID: "due", Created: now.Add(-time.Hour), LastSeen: now.Add(-idle / 2)},
+		{ID: "stale", Created: now.Add(-2 * idle), LastSeen: now.Add(-idle)},
+	}
+
+	serve := func(id string) bool {
+		h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+		h.session.ClientValues[SessionRecordKey] = id
+
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		r, err := h.ab.LoadClientState(w, mocks.Request("GET"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		called := false
+		Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})).ServeHTTP(w, r)
+		w.WriteHeader(http.StatusOK)
+		return called
+	}
+
+	if !serve("recent") || !serve("due") {
+		t.Fatal("sessions in use should be let through")
+	}
+	for _, session := range h.storer.Sessions["test@test.com"] {
+		switch session.ID {
+		case "recent":
+			if !session.LastSeen.Equal(now.Add(-time.Minute)) {
+				t.Error("recently seen sessions should not be written:", session.LastSeen)
+			}
+		case "due":
+			if session.LastSeen.Before(now) {
+				t.Error("the session's last seen should have been refreshed:", session.LastSeen)
+			}
+		}
+	}
+
+	if serve("stale") {
+		t.Error("stale sessions should be logged out")
+	}
+}
+
+func TestMiddlewareImpersonating(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Sessions["admin@test.com"] = []authboss.SessionRecord{{ID: "current"}}
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.session.ClientValues[authboss.SessionImpersonator] = "admin@test.com"
+	h.session.ClientValues[SessionRecordKey] = "current"
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("GET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(w, r)
+	if !called {
+		t.Error("the impersonator's session should be let through")
+	}
+}
+
+func TestAfterLogout(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Sessions["test@test.com"] = []authboss.SessionRecord{{ID: "current"}, {ID: "other"}}
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	h.session.ClientValues[SessionRecordKey] = "current"
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.limit.AfterLogout(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+	if sessions := h.storer.Sessions["test@test.com"]; len(sessions) != 1 || sessions[0].ID != "other" {
+		t.Error("the session's record should have been deleted:", sessions)
+	}
+}
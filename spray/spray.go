@@ -0,0 +1,143 @@
+// Package spray detects password sprays, where one password is tried
+// against many accounts to stay under each account's lockout limit.
+//
+// Login attempts are counted in the Storage.Counter by the password and
+// the network they come from. When a password has been tried against
+// Modules.SprayThreshold accounts from a network within Modules.SprayWindow
+// EventPasswordSpray is fired and, for the rest of the window, every login
+// is slowed down by Modules.SprayTarpit and must solve a challenge if
+// Modules.ChallengeVerifier is set.
+package spray
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/authboss/v3"
+)
+
+const (
+	// hashSize is how many bytes of a password's hash are used to count
+	// it, few enough that the counter doesn't store anything useful
+	// for cracking it.
+	hashSize = 4
+
+	pageLogin = "login"
+)
+
+func init() {
+	authboss.RegisterModule("spray", &Spray{})
+}
+
+// Spray module
+type Spray struct {
+	*authboss.Authboss
+
+	mut   sync.Mutex
+	until time.Time
+}
+
+// ValidateConfig checks the config has a counter to count attempts with
+func (s *Spray) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Logger", "Storage.Counter")
+}
+
+// Init module
+func (s *Spray) Init(ab *authboss.Authboss) error {
+	s.Authboss = ab
+
+	if s.Config.Storage.Counter == nil {
+		return errors.New("spray module activated but no Storage.Counter was set")
+	}
+
+	// Without ChallengeRequired a challenge is always required already
+	if required := s.Config.Modules.ChallengeRequired; required != nil {
+		s.Config.Modules.ChallengeRequired = func(r *http.Request, page string) bool {
+			return (page == pageLogin && s.Active()) || required(r, page)
+		}
+	}
+
+	s.Events.Before(authboss.EventAuthAttempt, s.BeforeAuthAttempt)
+
+	return nil
+}
+
+// Active is true while a password spray is ongoing
+func (s *Spray) Active() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.Now().Before(s.until)
+}
+
+// BeforeAuthAttempt counts the attempt and slows it down if a spray
+// is ongoing
+func (s *Spray) BeforeAuthAttempt(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	creds, ok := r.Context().Value(authboss.CTXKeyValues).(authboss.UserValuer)
+	if !ok {
+		return false, nil
+	}
+
+	attempt := s.Attempt(r)
+	key := "spray:" + network(attempt.IP) + ":" + shortHash(creds.GetPassword())
+	accounts, err := s.Config.Storage.Counter.CountDistinct(r.Context(), key, shortHash(s.NormalizePID(attempt.PID)), s.Config.Modules.SprayWindow)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to count password attempt")
+	}
+
+	if accounts >= s.Config.Modules.SprayThreshold {
+		if handled, err := s.detected(w, r); err != nil || handled {
+			return handled, err
+		}
+	}
+
+	if !s.Active() {
+		return false, nil
+	}
+
+	select {
+	case <-time.After(s.Config.Modules.SprayTarpit):
+	case <-r.Context().Done():
+	}
+	return false, nil
+}
+
+// detected starts the friction and fires EventPasswordSpray if the spray
+// wasn't already known
+func (s *Spray) detected(w http.ResponseWriter, r *http.Request) (bool, error) {
+	s.mut.Lock()
+	now := s.Now()
+	wasActive := now.Before(s.until)
+	s.until = now.Add(s.Config.Modules.SprayWindow)
+	s.mut.Unlock()
+
+	if wasActive {
+		return false, nil
+	}
+
+	s.RequestLogger(r).Errorf("password spray detected from network %s", network(s.ClientIP(r)))
+	return s.Events.FireAfter(authboss.EventPasswordSpray, w, r)
+}
+
+// network is the network of the client's address, a /24 for ipv4 and a
+// /48 for ipv6, since sprays rotate through related addresses
+func network(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(sum[:hashSize])
+}
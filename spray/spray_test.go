@@ -0,0 +1,119 @@
+package spray
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/authtest"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	s := &Spray{}
+	if err := s.Init(ab); err == nil {
+		t.Error("should fail without a counter")
+	}
+
+	ab.Config.Storage.Counter = mocks.NewCounter()
+	ab.Config.Modules.ChallengeRequired = func(r *http.Request, page string) bool { return false }
+	if err := s.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	s.until = time.Now().Add(time.Hour)
+	if !ab.Config.Modules.ChallengeRequired(nil, "login") {
+		t.Error("a challenge should be required during a spray")
+	}
+	if ab.Config.Modules.ChallengeRequired(nil, "recover_start") {
+		t.Error("other pages should be left alone")
+	}
+}
+
+func testSetup() (*Spray, *authboss.Authboss) {
+	ab := authboss.New()
+	ab.Config.Core.Logger = mocks.Logger{}
+	ab.Config.Storage.Counter = mocks.NewCounter()
+	ab.Config.Modules.SprayThreshold = 3
+	ab.Config.Modules.SprayTarpit = time.Millisecond
+	ab.Config.Core.Clock = authtest.NewClock(time.Now())
+
+	return &Spray{Authboss: ab}, ab
+}
+
+func attempt(s *Spray, ip, pid, password string) (bool, error) {
+	r := mocks.Request("POST")
+	r.RemoteAddr = ip + ":1234"
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{PID: pid, Password: password}))
+
+	return s.BeforeAuthAttempt(httptest.NewRecorder(), r, false)
+}
+
+func TestBeforeAuthAttempt(t *testing.T) {
+	t.Parallel()
+
+	s, ab := testSetup()
+
+	fired := 0
+	ab.Events.After(authboss.EventPasswordSpray, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		fired++
+		return false, nil
+	})
+
+	// The same user retrying isn't a spray
+	for i := 0; i < 5; i++ {
+		if _, err := attempt(s, "1.2.3.4", "test@test.com", "password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Active() || fired != 0 {
+		t.Error("one account should not be a spray")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := attempt(s, fmt.Sprintf("1.2.3.%d", i), fmt.Sprintf("%d@test.com", i), "password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.Active() || fired != 1 {
+		t.Error("one password from related addresses should be a spray", fired)
+	}
+
+	if _, err := attempt(s, "1.2.3.4", "4@test.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if fired != 1 {
+		t.Error("the event should only fire once per spray:", fired)
+	}
+
+	ab.Config.Core.Clock.(*authtest.Clock).Advance(ab.Config.Modules.SprayWindow)
+	if s.Active() {
+		t.Error("the spray should be over once the window has passed")
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"1.2.3.4:80":             "1.2.3.0",
+		"[2001:db8:1:2::1]:80":   "2001:db8:1::",
+		"not an address":         "not an address",
+		"[2001:db8:1:ff::9]:443": "2001:db8:1::",
+	}
+
+	for addr, want := range tests {
+		r := mocks.Request("GET")
+		r.RemoteAddr = addr
+		if got := network(authboss.New().ClientIP(r)); got != want {
+			t.Errorf("%s: want %s, got %s", addr, want, got)
+		}
+	}
+}
@@ -9,6 +9,7 @@ package authboss
 
 import (
 	"context"
+	"time"
 
 	"github.com/friendsofgo/errors"
 )
@@ -22,6 +23,9 @@ var (
 	// ErrTokenNotFound should be returned from UseToken when the
 	// record is not found.
 	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenReused should be returned from UseRememberFamilyToken when
+	// the token was found but has already been used.
+	ErrTokenReused = errors.New("token reused")
 )
 
 // ServerStorer represents the data store that's capable of loading users
@@ -88,6 +92,17 @@ type OAuth2ServerStorer interface {
 	SaveOAuth2(ctx context.Context, user OAuth2User) error
 }
 
+// OAuth2LinkServerStorer can find users by the oauth2 identities that
+// have been linked to them.
+type OAuth2LinkServerStorer interface {
+	ServerStorer
+
+	// LoadByOAuth2Link finds the user that has the identity (provider, uid)
+	// linked to it, it should return ErrUserNotFound if no user has it
+	// linked. Save is used to persist changes to a user's identities.
+	LoadByOAuth2Link(ctx context.Context, provider, uid string) (OAuth2LinkableUser, error)
+}
+
 // ConfirmingServerStorer can find a user by a confirm token
 type ConfirmingServerStorer interface {
 	ServerStorer
@@ -97,6 +112,37 @@ type ConfirmingServerStorer interface {
 	LoadByConfirmSelector(ctx context.Context, selector string) (ConfirmableUser, error)
 }
 
+// AnyCredentialServerStorer can find a user by any of their credentials,
+// which allows users to log in with either their e-mail address or their
+// username for example. See Config.Modules.CredentialFields.
+type AnyCredentialServerStorer interface {
+	ServerStorer
+
+	// LoadByAny finds the user whose value for any of the fields is
+	// identifier and should return ErrUserNotFound if there is none. The
+	// fields are Config.Modules.CredentialFields, like "email" and
+	// "username", and the identifier has been normalized with
+	// Config.Modules.PIDNormalizer so the stored values should be too.
+	LoadByAny(ctx context.Context, fields []string, identifier string) (User, error)
+}
+
+// RenamingServerStorer can tell whether a name is taken and move users to
+// a new pid, for the rename module.
+type RenamingServerStorer interface {
+	ServerStorer
+
+	// UserExists reports whether a user has key as their pid or their
+	// username. Both are checked since either can be used to log in with
+	// (see Config.Modules.CredentialFields), key has been normalized with
+	// Config.Modules.PIDNormalizer.
+	UserExists(ctx context.Context, key string) (bool, error)
+	// ChangePID stores the user, whose pid has been changed, in place of
+	// the user stored under oldPID. It should return ErrUserFound if a
+	// user already has the new pid. It's only used when
+	// Config.Modules.RenamePID is set.
+	ChangePID(ctx context.Context, oldPID string, user User) error
+}
+
 // RecoveringServerStorer allows users to be recovered by a token
 type RecoveringServerStorer interface {
 	ServerStorer
@@ -119,6 +165,278 @@ type RememberingServerStorer interface {
 	UseRememberToken(ctx context.Context, pid, token string) error
 }
 
+// RememberingFamilyServerStorer is an optional upgrade of the
+// RememberingServerStorer that groups remember tokens into families.
+// A family starts when the user logs in and every token created by using
+// (rotating) a token from the family joins it. Used tokens must be kept
+// rather than deleted, so that when a token that has already been rotated
+// is presented again (which means it was stolen) it can be detected and
+// the whole family revoked.
+type RememberingFamilyServerStorer interface {
+	RememberingServerStorer
+
+	// AddRememberFamilyToken adds the token to the user as the newest
+	// token in family.
+	AddRememberFamilyToken(ctx context.Context, pid, family, token string) error
+	// UseRememberFamilyToken finds the pid-token pair, marks it used and
+	// returns its family. If the token has already been used it must return
+	// the family along with ErrTokenReused. Tokens added with
+	// AddRememberToken have an empty family. If the token could not be
+	// found return ErrTokenNotFound.
+	UseRememberFamilyToken(ctx context.Context, pid, token string) (family string, err error)
+	// DelRememberFamily removes all tokens (used or not) in the family
+	DelRememberFamily(ctx context.Context, pid, family string) error
+}
+
+// RememberDevice describes the device a family of remember tokens was
+// issued to.
+type RememberDevice struct {
+	// Family of the device's tokens, the device is revoked by deleting
+	// the family with DelRememberFamily.
+	Family string
+	// Name the user gave the device, it may be empty
+	Name string
+	// UserAgent of the browser the user logged in with
+	UserAgent string
+	// LastUsed is the last time the device was logged in with a remember
+	// token
+	LastUsed time.Time
+}
+
+// RememberingDeviceServerStorer is an optional upgrade of the
+// RememberingFamilyServerStorer that records the device each family was
+// issued to, so users can be shown the devices they're remembered on and
+// revoke them. DelRememberFamily and DelRememberTokens must also delete
+// the devices of the tokens they delete.
+type RememberingDeviceServerStorer interface {
+	RememberingFamilyServerStorer
+
+	// PutRememberDevice saves the device, replacing the user's device with
+	// the same family if there is one.
+	PutRememberDevice(ctx context.Context, pid string, device RememberDevice) error
+	// LoadRememberDevices returns the user's devices
+	LoadRememberDevices(ctx context.Context, pid string) ([]RememberDevice, error)
+}
+
+// TokenServerStorer stores the access and refresh tokens issued to api
+// clients by the token module. Tokens are stored by their hash, the tokens
+// themselves are never given to the storer.
+//
+// Like remember tokens, refresh tokens belong to a family that starts on
+// login. Refreshing uses up the refresh token and issues the next pair in
+// the same family, used tokens must be kept until they expire so that a
+// refresh token that is presented twice can be detected and the whole
+// family revoked.
+type TokenServerStorer interface {
+	ServerStorer
+
+	// AddToken stores a newly issued token
+	AddToken(ctx context.Context, token IssuedToken) error
+	// LoadToken finds the token by its hash whether it has been used or not.
+	// If the token could not be found return ErrTokenNotFound.
+	LoadToken(ctx context.Context, hash string) (IssuedToken, error)
+	// UseToken marks the token as used. If the token has already been used
+	// it must return the token along with ErrTokenReused. If the token could
+	// not be found return ErrTokenNotFound.
+	UseToken(ctx context.Context, hash string) (IssuedToken, error)
+	// DelTokenFamily removes all tokens (used or not) in the family
+	DelTokenFamily(ctx context.Context, pid, family string) error
+	// DelTokens removes all of the user's tokens (used or not) in every
+	// family, logging out all of their clients
+	DelTokens(ctx context.Context, pid string) error
+}
+
+// Kinds of IssuedToken
+const (
+	TokenKindAccess  = "access"
+	TokenKindRefresh = "refresh"
+)
+
+// APIKeyServerStorer stores the personal access tokens issued by the
+// apikey module. Like other tokens they're stored by their hash, the keys
+// themselves are never given to the storer.
+type APIKeyServerStorer interface {
+	ServerStorer
+
+	// AddAPIKey stores a newly created key
+	AddAPIKey(ctx context.Context, key APIKey) error
+	// LoadAPIKey finds the key by its hash. If the key could not be found
+	// return ErrTokenNotFound.
+	LoadAPIKey(ctx context.Context, hash string) (APIKey, error)
+	// LoadAPIKeys lists the user's keys
+	LoadAPIKeys(ctx context.Context, pid string) ([]APIKey, error)
+	// DelAPIKey removes the user's key. If the user has no key with the id
+	// return ErrTokenNotFound.
+	DelAPIKey(ctx context.Context, pid, id string) error
+}
+
+// SessionRecord is a session a user is logged in with, recorded by the
+// sessionlimit module
+type SessionRecord struct {
+	// ID of the session, it's random and kept in the session itself
+	ID string
+	// Created is when the user logged in
+	Created time.Time
+	// LastSeen is when the session was last used, it's kept up to date by
+	// the sessionlimit middleware
+	LastSeen time.Time
+}
+
+// SessionServerStorer records the sessions each user is logged in with so
+// that the number of them can be limited. Deleting a user's session record
+// logs that session out.
+type SessionServerStorer interface {
+	ServerStorer
+
+	// AddSessionRecord to the user
+	AddSessionRecord(ctx context.Context, pid string, session SessionRecord) error
+	// LoadSessionRecords returns all of the user's sessions
+	LoadSessionRecords(ctx context.Context, pid string) ([]SessionRecord, error)
+	// DelSessionRecord removes the user's session, it should not return
+	// an error if the session doesn't exist
+	DelSessionRecord(ctx context.Context, pid, id string) error
+	// TouchSessionRecord sets the LastSeen of the user's session, it
+	// should not return an error if the session doesn't exist
+	TouchSessionRecord(ctx context.Context, pid, id string, lastSeen time.Time) error
+}
+
+// KnownDevice is a device a user has logged in from, recorded by the
+// device module. Only hashes are stored so that a copy of the store can't
+// be used to impersonate a device.
+type KnownDevice struct {
+	// ID is the hash of the random id in the device's cookie
+	ID string
+	// UserAgentHash is the hash of the device's user agent
+	UserAgentHash string
+	// IPHash is the hash of the ip address the device last logged in from
+	IPHash string
+	// FirstSeen is when the user first logged in from the device
+	FirstSeen time.Time
+	// LastSeen is when the user last logged in from the device
+	LastSeen time.Time
+}
+
+// KnownDeviceStorer records the devices each user has logged in from so
+// that logins from new devices can be detected.
+type KnownDeviceStorer interface {
+	ServerStorer
+
+	// LoadKnownDevices returns all of the user's devices
+	LoadKnownDevices(ctx context.Context, pid string) ([]KnownDevice, error)
+	// PutKnownDevice saves the device, replacing the user's device with the
+	// same ID if there is one.
+	PutKnownDevice(ctx context.Context, pid string, device KnownDevice) error
+	// DelKnownDevice removes the user's device, it should not return an
+	// error if the device doesn't exist
+	DelKnownDevice(ctx context.Context, pid, id string) error
+}
+
+// IssuedToken is a token issued to an api client by the token module
+type IssuedToken struct {
+	// Hash of the token
+	Hash string
+	// Kind is TokenKindAccess or TokenKindRefresh
+	Kind string
+	// PID of the user the token was issued to
+	PID string
+	// Family is shared by all tokens issued since the user logged in
+	Family string
+	// Expires is when the token stops being valid
+	Expires time.Time
+}
+
+// APIKey is a personal access token created by a user with the apikey
+// module
+type APIKey struct {
+	// ID of the key, it's random and used to revoke the key
+	ID string
+	// PID of the user the key belongs to
+	PID string
+	// Name the user gave the key
+	Name string
+	// Hint is the start of the key so users can tell their keys apart
+	Hint string
+	// Hash of the key
+	Hash string
+	// Created is when the key was created
+	Created time.Time
+	// Expires is when the key stops being valid, it never does when it's
+	// zero
+	Expires time.Time
+}
+
+// CounterStorer counts things over a window of time for rate limiting and
+// abuse detection. It's not a ServerStorer upgrade since it's typically
+// backed by something faster than the database (like redis), but it must
+// be shared by all instances of the app for the counts to be global.
+type CounterStorer interface {
+	// CountDistinct adds member to the set under key and returns how many
+	// distinct members the set has. The set is deleted window after its
+	// first member was added.
+	CountDistinct(ctx context.Context, key, member string, window time.Duration) (int, error)
+}
+
+// StatsServerStorer can count what it stores so that CollectMetrics can
+// report it. Counts the storer doesn't keep track of should be left at zero.
+type StatsServerStorer interface {
+	ServerStorer
+
+	// Stats counts the current contents of the store
+	Stats(ctx context.Context) (StorageStats, error)
+}
+
+// ExportingServerStorer adds what the app keeps about a user to the export
+// of their data (see the export module), like their profile fields and
+// audit events.
+type ExportingServerStorer interface {
+	ServerStorer
+
+	// ExportUser calls put for each section of the user's data, the data
+	// is marshaled to JSON and written as soon as it's put so large
+	// sections can be put a piece at a time (by putting each piece under
+	// its own name). Section names must not repeat, nor be one of the
+	// export module's own sections.
+	ExportUser(ctx context.Context, pid string, put func(section string, data interface{}) error) error
+}
+
+// StorageStats are the counts returned by StatsServerStorer
+type StorageStats struct {
+	// ActiveSessions is the number of sessions, for server side session
+	// stores
+	ActiveSessions int
+	// RememberTokens is the number of remember me tokens
+	RememberTokens int
+	// ExpiredTokens is the number of tokens (remember, api, confirm and
+	// recover) that have expired but are still stored
+	ExpiredTokens int
+}
+
+// TxServerStorer is an optional upgrade of the ServerStorer for storers
+// that can make several changes atomically. Flows that write more than
+// once (registering creates the user, then confirm saves its token...) are
+// run in a transaction with Authboss.InTx so that a failure part way
+// doesn't leave half of the changes behind.
+type TxServerStorer interface {
+	ServerStorer
+
+	// BeginTx starts a transaction. The storer it returns makes its
+	// changes in the transaction and must implement the same optional
+	// upgrades (CreatingServerStorer, ConfirmingServerStorer...) as the
+	// TxServerStorer.
+	BeginTx(ctx context.Context) (TxStorer, error)
+}
+
+// TxStorer is a ServerStorer whose changes are made in a transaction, see
+// TxServerStorer
+type TxStorer interface {
+	ServerStorer
+
+	// Commit the changes made in the transaction
+	Commit(ctx context.Context) error
+	// Rollback the changes made in the transaction
+	Rollback(ctx context.Context) error
+}
+
 // EnsureCanCreate makes sure the server storer supports create operations
 func EnsureCanCreate(storer ServerStorer) CreatingServerStorer {
 	s, ok := storer.(CreatingServerStorer)
@@ -171,3 +489,90 @@ func EnsureCanOAuth2(storer ServerStorer) OAuth2ServerStorer {
 
 	return s
 }
+
+// EnsureCanOAuth2Link makes sure the server storer supports
+// finding users by their linked oauth2 accounts
+func EnsureCanOAuth2Link(storer ServerStorer) OAuth2LinkServerStorer {
+	s, ok := storer.(OAuth2LinkServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to OAuth2LinkServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanRecordSessions makes sure the server storer supports
+// recording sessions
+func EnsureCanRecordSessions(storer ServerStorer) SessionServerStorer {
+	s, ok := storer.(SessionServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to SessionServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanKnowDevices makes sure the server storer supports
+// recording known devices
+func EnsureCanKnowDevices(storer ServerStorer) KnownDeviceStorer {
+	s, ok := storer.(KnownDeviceStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to KnownDeviceStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanLoadByAny makes sure the server storer supports
+// loading users by any of their credentials
+func EnsureCanLoadByAny(storer ServerStorer) AnyCredentialServerStorer {
+	s, ok := storer.(AnyCredentialServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to AnyCredentialServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanIssueTokens makes sure the server storer supports
+// storing api tokens
+func EnsureCanIssueTokens(storer ServerStorer) TokenServerStorer {
+	s, ok := storer.(TokenServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to TokenServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanStoreAPIKeys makes sure the server storer supports storing api
+// keys
+func EnsureCanStoreAPIKeys(storer ServerStorer) APIKeyServerStorer {
+	s, ok := storer.(APIKeyServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to APIKeyServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanRename makes sure the server storer supports checking names
+// and changing pids
+func EnsureCanRename(storer ServerStorer) RenamingServerStorer {
+	s, ok := storer.(RenamingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to RenamingServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanExport makes sure the server storer can export users' data
+func EnsureCanExport(storer ServerStorer) ExportingServerStorer {
+	s, ok := storer.(ExportingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to ExportingServerStorer, check your struct")
+	}
+
+	return s
+}
@@ -0,0 +1,355 @@
+// Package mongo implements authboss' storers for MongoDB, as a reference
+// for document databases. Users are documents keyed by their e-mail
+// address, remember and recover tokens have their own collections which
+// MongoDB expires with TTL indexes.
+//
+// It only implements the storers of the core modules: it's a
+// CreatingServerStorer, ConfirmingServerStorer, RecoveringServerStorer and
+// RememberingServerStorer. It isn't a RememberingFamilyServerStorer or
+// RememberingDeviceServerStorer, a TokenServerStorer (token module), an
+// APIKeyServerStorer (apikey module), a SessionServerStorer (sessionlimit
+// module) or a TxServerStorer, and it's not a CounterStorer for locking by
+// IP. Applications using those embed the Storer in their own storer that
+// adds them.
+//
+// To keep authboss free of the driver the storer uses collections through
+// the small Collection interface. The adapter for the official driver's
+// *mongo.Collection isn't shipped, it's a few lines in the docs.
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// DefaultRememberTTL is how long New keeps remember tokens
+const DefaultRememberTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrNotFound must be returned by Collection.FindOne when no document
+	// matches (mongo.ErrNoDocuments)
+	ErrNotFound = errors.New("no documents")
+	// ErrDuplicate must be returned by Collection.InsertOne when a unique
+	// index is violated (mongo.IsDuplicateKeyError)
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+var (
+	_ authboss.CreatingServerStorer    = (*Storer)(nil)
+	_ authboss.ConfirmingServerStorer  = (*Storer)(nil)
+	_ authboss.RecoveringServerStorer  = (*Storer)(nil)
+	_ authboss.RememberingServerStorer = (*Storer)(nil)
+)
+
+// Document is a MongoDB document or filter, it converts to bson.M. Dates
+// read back may be time.Time or anything with a Time() time.Time method
+// (like primitive.DateTime).
+type Document map[string]interface{}
+
+// Index is an index to create on a collection. Keys are ascending.
+// ExpireAfter makes it a TTL index when it's not nil and Partial is its
+// partial filter expression.
+type Index struct {
+	Name        string
+	Keys        []string
+	Unique      bool
+	ExpireAfter *time.Duration
+	Partial     Document
+}
+
+// Collection is the part of a MongoDB collection the storer uses.
+type Collection interface {
+	// FindOne returns the first document matching filter, or ErrNotFound
+	FindOne(ctx context.Context, filter Document) (Document, error)
+	// InsertOne inserts doc, ErrDuplicate is returned when a unique index
+	// is violated
+	InsertOne(ctx context.Context, doc Document) error
+	// UpdateOne $sets the fields of the first document matching filter and
+	// returns how many documents matched. With upsert the document is
+	// inserted when none matched.
+	UpdateOne(ctx context.Context, filter, set Document, upsert bool) (matched int64, err error)
+	// DeleteMany deletes the documents matching filter and returns how many
+	// there were
+	DeleteMany(ctx context.Context, filter Document) (deleted int64, err error)
+	// CreateIndex creates the index if it doesn't exist
+	CreateIndex(ctx context.Context, index Index) error
+}
+
+// Storer is a ServerStorer for the users, remember_tokens and
+// recover_tokens collections. It's also a CreatingServerStorer,
+// ConfirmingServerStorer, RecoveringServerStorer and
+// RememberingServerStorer.
+type Storer struct {
+	Users          Collection
+	RememberTokens Collection
+	RecoverTokens  Collection
+
+	// RememberTTL is how long MongoDB keeps remember tokens, it should be
+	// at least Config.Modules.RememberIdleTimeout
+	RememberTTL time.Duration
+}
+
+// New creates a storer that keeps remember tokens for DefaultRememberTTL,
+// EnsureIndexes has to be called before it's used.
+func New(users, rememberTokens, recoverTokens Collection) *Storer {
+	return &Storer{
+		Users:          users,
+		RememberTokens: rememberTokens,
+		RecoverTokens:  recoverTokens,
+		RememberTTL:    DefaultRememberTTL,
+	}
+}
+
+// EnsureIndexes creates the indexes the storer relies on: the unique
+// confirm selector, the unique remember and recover tokens, and the TTL
+// indexes that expire them.
+func (s *Storer) EnsureIndexes(ctx context.Context) error {
+	now, rememberTTL := time.Duration(0), s.RememberTTL
+	indexes := []struct {
+		collection Collection
+		index      Index
+	}{
+		{s.Users, Index{
+			Name:    "confirm_selector",
+			Keys:    []string{"confirm_selector"},
+			Unique:  true,
+			Partial: Document{"confirm_selector": Document{"$type": "string"}},
+		}},
+		{s.RememberTokens, Index{Name: "pid_token", Keys: []string{"pid", "token"}, Unique: true}},
+		{s.RememberTokens, Index{Name: "created_at_ttl", Keys: []string{"created_at"}, ExpireAfter: &rememberTTL}},
+		{s.RecoverTokens, Index{Name: "selector", Keys: []string{"selector"}, Unique: true}},
+		{s.RecoverTokens, Index{Name: "expires_at_ttl", Keys: []string{"expires_at"}, ExpireAfter: &now}},
+	}
+
+	for _, i := range indexes {
+		if err := i.collection.CreateIndex(ctx, i.index); err != nil {
+			return errors.Wrapf(err, "failed to create index %s", i.index.Name)
+		}
+	}
+	return nil
+}
+
+// New creates a blank user
+func (s *Storer) New(ctx context.Context) authboss.User {
+	return &User{}
+}
+
+// Load the user by their e-mail address
+func (s *Storer) Load(ctx context.Context, key string) (authboss.User, error) {
+	return s.load(ctx, Document{"_id": key})
+}
+
+// LoadByConfirmSelector finds the user with the confirm selector
+func (s *Storer) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	if len(selector) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+	return s.load(ctx, Document{"confirm_selector": selector})
+}
+
+// LoadByRecoverSelector finds the user with the recover selector, expired
+// tokens MongoDB hasn't removed yet are still found (the recover module
+// checks the expiry).
+func (s *Storer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	if len(selector) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	doc, err := s.RecoverTokens.FindOne(ctx, Document{"selector": selector})
+	if err == ErrNotFound {
+		return nil, authboss.ErrUserNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load recover token")
+	}
+
+	return s.load(ctx, Document{"_id": str(doc["pid"])})
+}
+
+func (s *Storer) load(ctx context.Context, filter Document) (*User, error) {
+	doc, err := s.Users.FindOne(ctx, filter)
+	if err == ErrNotFound {
+		return nil, authboss.ErrUserNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load user")
+	}
+
+	u := &User{
+		Email:           str(doc["_id"]),
+		Password:        str(doc["password"]),
+		ConfirmSelector: str(doc["confirm_selector"]),
+		ConfirmVerifier: str(doc["confirm_verifier"]),
+		ConfirmExpiry:   date(doc["confirm_expiry"]),
+		Confirmed:       doc["confirmed"] == true,
+		AttemptCount:    integer(doc["attempt_count"]),
+		LastAttempt:     date(doc["last_attempt"]),
+		Locked:          date(doc["locked"]),
+		OTPs:            str(doc["otps"]),
+		TOTPSecretKey:   str(doc["totp_secret_key"]),
+		TOTPLastCode:    str(doc["totp_last_code"]),
+		SMSPhoneNumber:  str(doc["sms_phone_number"]),
+		RecoveryCodes:   str(doc["recovery_codes"]),
+	}
+
+	token, err := s.RecoverTokens.FindOne(ctx, Document{"pid": u.Email})
+	if err == nil {
+		u.RecoverSelector = str(token["selector"])
+		u.RecoverVerifier = str(token["verifier"])
+		u.RecoverExpiry = date(token["expires_at"])
+	} else if err != ErrNotFound {
+		return nil, errors.Wrap(err, "failed to load recover token")
+	}
+
+	return u, nil
+}
+
+// Create inserts the user, ErrUserFound is returned if the e-mail address
+// is taken.
+func (s *Storer) Create(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	doc := fields(u)
+	doc["_id"] = u.Email
+	if err = s.Users.InsertOne(ctx, doc); err == ErrDuplicate {
+		return authboss.ErrUserFound
+	} else if err != nil {
+		return errors.Wrap(err, "failed to create user")
+	}
+
+	return s.saveRecoverToken(ctx, u)
+}
+
+// Save the user, ErrUserNotFound is returned if they don't exist
+func (s *Storer) Save(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	matched, err := s.Users.UpdateOne(ctx, Document{"_id": u.Email}, fields(u), false)
+	if err != nil {
+		return errors.Wrap(err, "failed to save user")
+	} else if matched == 0 {
+		return authboss.ErrUserNotFound
+	}
+
+	return s.saveRecoverToken(ctx, u)
+}
+
+// saveRecoverToken keeps the user's recover token in its collection, or
+// removes it when the user doesn't have one
+func (s *Storer) saveRecoverToken(ctx context.Context, u *User) error {
+	if len(u.RecoverSelector) == 0 {
+		_, err := s.RecoverTokens.DeleteMany(ctx, Document{"pid": u.Email})
+		return errors.Wrap(err, "failed to delete recover token")
+	}
+
+	token := Document{
+		"selector":   u.RecoverSelector,
+		"verifier":   u.RecoverVerifier,
+		"expires_at": dateValue(u.RecoverExpiry),
+	}
+	_, err := s.RecoverTokens.UpdateOne(ctx, Document{"pid": u.Email}, token, true)
+	return errors.Wrap(err, "failed to save recover token")
+}
+
+// AddRememberToken for the user
+func (s *Storer) AddRememberToken(ctx context.Context, pid, token string) error {
+	err := s.RememberTokens.InsertOne(ctx, Document{"pid": pid, "token": token, "created_at": time.Now().UTC()})
+	return errors.Wrap(err, "failed to add remember token")
+}
+
+// DelRememberTokens removes all of the user's remember tokens
+func (s *Storer) DelRememberTokens(ctx context.Context, pid string) error {
+	_, err := s.RememberTokens.DeleteMany(ctx, Document{"pid": pid})
+	return errors.Wrap(err, "failed to delete remember tokens")
+}
+
+// UseRememberToken deletes the token, ErrTokenNotFound is returned if the
+// user doesn't have it
+func (s *Storer) UseRememberToken(ctx context.Context, pid, token string) error {
+	deleted, err := s.RememberTokens.DeleteMany(ctx, Document{"pid": pid, "token": token})
+	if err != nil {
+		return errors.Wrap(err, "failed to use remember token")
+	} else if deleted == 0 {
+		return authboss.ErrTokenNotFound
+	}
+	return nil
+}
+
+func toUser(user authboss.User) (*User, error) {
+	u, ok := user.(*User)
+	if !ok {
+		return nil, errors.Errorf("mongo storer can only store *mongo.User, got %T", user)
+	}
+	return u, nil
+}
+
+// fields of the user document other than the _id. An empty confirm
+// selector is null so it's left out of the (partial) unique index.
+func fields(u *User) Document {
+	doc := Document{
+		"password":         u.Password,
+		"confirm_selector": nil,
+		"confirm_verifier": u.ConfirmVerifier,
+		"confirm_expiry":   dateValue(u.ConfirmExpiry),
+		"confirmed":        u.Confirmed,
+		"attempt_count":    u.AttemptCount,
+		"last_attempt":     dateValue(u.LastAttempt),
+		"locked":           dateValue(u.Locked),
+		"otps":             u.OTPs,
+		"totp_secret_key":  u.TOTPSecretKey,
+		"totp_last_code":   u.TOTPLastCode,
+		"sms_phone_number": u.SMSPhoneNumber,
+		"recovery_codes":   u.RecoveryCodes,
+	}
+	if len(u.ConfirmSelector) != 0 {
+		doc["confirm_selector"] = u.ConfirmSelector
+	}
+	return doc
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func integer(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	default:
+		return 0
+	}
+}
+
+// dateValue stores zero times as null
+func dateValue(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+func date(v interface{}) time.Time {
+	switch d := v.(type) {
+	case time.Time:
+		return d
+	case interface{ Time() time.Time }:
+		return d.Time()
+	default:
+		return time.Time{}
+	}
+}
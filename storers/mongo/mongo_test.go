@@ -0,0 +1,286 @@
+package mongo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+)
+
+var (
+	_ authboss.AuthableUser            = (*User)(nil)
+	_ authboss.ExpiringConfirmableUser = (*User)(nil)
+	_ authboss.LockableUser            = (*User)(nil)
+	_ authboss.RecoverableUser         = (*User)(nil)
+	_ otp.User                         = (*User)(nil)
+	_ totp2fa.UserOneTime              = (*User)(nil)
+	_ sms2fa.User                      = (*User)(nil)
+)
+
+// memCollection is a Collection in memory that matches filters by equality
+// and enforces unique indexes on _id and the indexes created
+type memCollection struct {
+	mut     sync.Mutex
+	docs    []Document
+	indexes []Index
+}
+
+func (m *memCollection) match(doc, filter Document) bool {
+	for k, v := range filter {
+		if doc[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *memCollection) duplicate(doc Document, skip int) bool {
+	unique := append([]Index{{Keys: []string{"_id"}, Unique: true}}, m.indexes...)
+	for i, other := range m.docs {
+		if i == skip {
+			continue
+		}
+	Indexes:
+		for _, index := range unique {
+			if !index.Unique {
+				continue
+			}
+			for _, k := range index.Keys {
+				if doc[k] == nil || doc[k] != other[k] {
+					continue Indexes
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memCollection) FindOne(ctx context.Context, filter Document) (Document, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for _, doc := range m.docs {
+		if m.match(doc, filter) {
+			return doc, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memCollection) InsertOne(ctx context.Context, doc Document) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.duplicate(doc, -1) {
+		return ErrDuplicate
+	}
+	m.docs = append(m.docs, doc)
+	return nil
+}
+
+func (m *memCollection) UpdateOne(ctx context.Context, filter, set Document, upsert bool) (int64, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for i, doc := range m.docs {
+		if !m.match(doc, filter) {
+			continue
+		}
+		updated := Document{}
+		for k, v := range doc {
+			updated[k] = v
+		}
+		for k, v := range set {
+			updated[k] = v
+		}
+		if m.duplicate(updated, i) {
+			return 0, ErrDuplicate
+		}
+		m.docs[i] = updated
+		return 1, nil
+	}
+
+	if upsert {
+		doc := Document{}
+		for k, v := range filter {
+			doc[k] = v
+		}
+		for k, v := range set {
+			doc[k] = v
+		}
+		m.docs = append(m.docs, doc)
+	}
+	return 0, nil
+}
+
+func (m *memCollection) DeleteMany(ctx context.Context, filter Document) (int64, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	var kept []Document
+	for _, doc := range m.docs {
+		if !m.match(doc, filter) {
+			kept = append(kept, doc)
+		}
+	}
+	deleted := int64(len(m.docs) - len(kept))
+	m.docs = kept
+	return deleted, nil
+}
+
+func (m *memCollection) CreateIndex(ctx context.Context, index Index) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	m.indexes = append(m.indexes, index)
+	return nil
+}
+
+func testStorer(t *testing.T) (*Storer, *memCollection, *memCollection, *memCollection) {
+	t.Helper()
+
+	users, remember, recover := &memCollection{}, &memCollection{}, &memCollection{}
+	s := New(users, remember, recover)
+	if err := s.EnsureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s, users, remember, recover
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	t.Parallel()
+
+	remember, recover := &memCollection{}, &memCollection{}
+	s := New(&memCollection{}, remember, recover)
+	s.RememberTTL = time.Hour
+	if err := s.EnsureIndexes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := remember.indexes[1]
+	if ttl.ExpireAfter == nil || *ttl.ExpireAfter != time.Hour || ttl.Keys[0] != "created_at" {
+		t.Errorf("remember tokens should expire: %#v", ttl)
+	}
+	ttl = recover.indexes[1]
+	if ttl.ExpireAfter == nil || *ttl.ExpireAfter != 0 || ttl.Keys[0] != "expires_at" {
+		t.Errorf("recover tokens should expire at their expiry: %#v", ttl)
+	}
+}
+
+func TestCreateLoadSave(t *testing.T) {
+	t.Parallel()
+
+	s, users, _, recover := testStorer(t)
+	ctx := context.Background()
+
+	locked := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := s.New(ctx).(*User)
+	user.PutPID("test@test.com")
+	user.PutPassword("hash")
+	user.PutAttemptCount(2)
+	user.PutLocked(locked)
+	user.PutTOTPSecretKey("totpkey")
+	if err := s.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, user); err != authboss.ErrUserFound {
+		t.Error("want ErrUserFound, got:", err)
+	}
+	if err := s.Create(ctx, &User{Email: "other@test.com"}); err != nil {
+		t.Error("users without a confirm selector shouldn't collide:", err)
+	}
+
+	loaded, err := s.Load(ctx, "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := loaded.(*User)
+	if u.Password != "hash" || u.AttemptCount != 2 || !u.Locked.Equal(locked) || u.TOTPSecretKey != "totpkey" {
+		t.Errorf("user was wrong: %#v", u)
+	}
+	if users.docs[0]["last_attempt"] != nil || users.docs[0]["confirm_selector"] != nil {
+		t.Error("empty values should be null:", users.docs[0])
+	}
+
+	u.PutRecoverSelector("selector")
+	u.PutRecoverVerifier("verifier")
+	u.PutRecoverExpiry(time.Now().Add(time.Hour))
+	if err = s.Save(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	recoverable, err := s.LoadByRecoverSelector(ctx, "selector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recoverable.GetPID() != "test@test.com" || recoverable.GetRecoverVerifier() != "verifier" {
+		t.Errorf("user was wrong: %#v", recoverable)
+	}
+
+	u.PutRecoverSelector("")
+	if err = s.Save(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if len(recover.docs) != 0 {
+		t.Error("the recover token should have been removed:", recover.docs)
+	}
+
+	if err = s.Save(ctx, &User{Email: "nobody@test.com"}); err != authboss.ErrUserNotFound {
+		t.Error("want ErrUserNotFound, got:", err)
+	}
+	if _, err = s.LoadByConfirmSelector(ctx, ""); err != authboss.ErrUserNotFound {
+		t.Error("want ErrUserNotFound, got:", err)
+	}
+}
+
+func TestConfirmSelector(t *testing.T) {
+	t.Parallel()
+
+	s, _, _, _ := testStorer(t)
+	ctx := context.Background()
+
+	if err := s.Create(ctx, &User{Email: "test@test.com", ConfirmSelector: "selector"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.LoadByConfirmSelector(ctx, "selector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.GetPID() != "test@test.com" {
+		t.Error("wrong user:", user.GetPID())
+	}
+}
+
+func TestRememberTokens(t *testing.T) {
+	t.Parallel()
+
+	s, _, remember, _ := testStorer(t)
+	ctx := context.Background()
+
+	for _, token := range []string{"a", "b"} {
+		if err := s.AddRememberToken(ctx, "test@test.com", token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := remember.docs[0]["created_at"].(time.Time); !ok {
+		t.Error("tokens need a creation date to expire:", remember.docs[0])
+	}
+
+	if err := s.UseRememberToken(ctx, "test@test.com", "a"); err != nil {
+		t.Error(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "a"); err != authboss.ErrTokenNotFound {
+		t.Error("want ErrTokenNotFound, got:", err)
+	}
+	if err := s.DelRememberTokens(ctx, "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(remember.docs) != 0 {
+		t.Error("tokens should have been deleted:", remember.docs)
+	}
+}
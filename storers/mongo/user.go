@@ -0,0 +1,140 @@
+package mongo
+
+import (
+	"time"
+)
+
+// User is a document of the users collection (and its recover token), it
+// implements the user interfaces of the auth, confirm, lock, recover,
+// remember, otp and two factor modules. The e-mail address is the pid.
+type User struct {
+	Email    string
+	Password string
+
+	ConfirmSelector string
+	ConfirmVerifier string
+	ConfirmExpiry   time.Time
+	Confirmed       bool
+
+	AttemptCount int
+	LastAttempt  time.Time
+	Locked       time.Time
+
+	RecoverSelector string
+	RecoverVerifier string
+	RecoverExpiry   time.Time
+
+	OTPs           string
+	TOTPSecretKey  string
+	TOTPLastCode   string
+	SMSPhoneNumber string
+	RecoveryCodes  string
+}
+
+// GetPID from user
+func (u User) GetPID() string { return u.Email }
+
+// GetEmail from user
+func (u User) GetEmail() string { return u.Email }
+
+// GetPassword from user
+func (u User) GetPassword() string { return u.Password }
+
+// GetConfirmSelector from user
+func (u User) GetConfirmSelector() string { return u.ConfirmSelector }
+
+// GetConfirmVerifier from user
+func (u User) GetConfirmVerifier() string { return u.ConfirmVerifier }
+
+// GetConfirmExpiry from user
+func (u User) GetConfirmExpiry() time.Time { return u.ConfirmExpiry }
+
+// GetConfirmed from user
+func (u User) GetConfirmed() bool { return u.Confirmed }
+
+// GetAttemptCount from user
+func (u User) GetAttemptCount() int { return u.AttemptCount }
+
+// GetLastAttempt from user
+func (u User) GetLastAttempt() time.Time { return u.LastAttempt }
+
+// GetLocked from user
+func (u User) GetLocked() time.Time { return u.Locked }
+
+// GetRecoverSelector from user
+func (u User) GetRecoverSelector() string { return u.RecoverSelector }
+
+// GetRecoverVerifier from user
+func (u User) GetRecoverVerifier() string { return u.RecoverVerifier }
+
+// GetRecoverExpiry from user
+func (u User) GetRecoverExpiry() time.Time { return u.RecoverExpiry }
+
+// GetOTPs from user
+func (u User) GetOTPs() string { return u.OTPs }
+
+// GetTOTPSecretKey from user
+func (u User) GetTOTPSecretKey() string { return u.TOTPSecretKey }
+
+// GetTOTPLastCode from user
+func (u User) GetTOTPLastCode() string { return u.TOTPLastCode }
+
+// GetSMSPhoneNumber from user
+func (u User) GetSMSPhoneNumber() string { return u.SMSPhoneNumber }
+
+// GetRecoveryCodes from user
+func (u User) GetRecoveryCodes() string { return u.RecoveryCodes }
+
+// PutPID into user
+func (u *User) PutPID(pid string) { u.Email = pid }
+
+// PutEmail into user
+func (u *User) PutEmail(email string) { u.Email = email }
+
+// PutPassword into user
+func (u *User) PutPassword(password string) { u.Password = password }
+
+// PutConfirmSelector into user
+func (u *User) PutConfirmSelector(selector string) { u.ConfirmSelector = selector }
+
+// PutConfirmVerifier into user
+func (u *User) PutConfirmVerifier(verifier string) { u.ConfirmVerifier = verifier }
+
+// PutConfirmExpiry into user
+func (u *User) PutConfirmExpiry(expiry time.Time) { u.ConfirmExpiry = expiry }
+
+// PutConfirmed into user
+func (u *User) PutConfirmed(confirmed bool) { u.Confirmed = confirmed }
+
+// PutAttemptCount into user
+func (u *User) PutAttemptCount(attempts int) { u.AttemptCount = attempts }
+
+// PutLastAttempt into user
+func (u *User) PutLastAttempt(last time.Time) { u.LastAttempt = last }
+
+// PutLocked into user
+func (u *User) PutLocked(locked time.Time) { u.Locked = locked }
+
+// PutRecoverSelector into user
+func (u *User) PutRecoverSelector(selector string) { u.RecoverSelector = selector }
+
+// PutRecoverVerifier into user
+func (u *User) PutRecoverVerifier(verifier string) { u.RecoverVerifier = verifier }
+
+// PutRecoverExpiry into user
+func (u *User) PutRecoverExpiry(expiry time.Time) { u.RecoverExpiry = expiry }
+
+// PutOTPs into user
+func (u *User) PutOTPs(otps string) { u.OTPs = otps }
+
+// PutTOTPSecretKey into user
+func (u *User) PutTOTPSecretKey(key string) { u.TOTPSecretKey = key }
+
+// PutTOTPLastCode into user
+func (u *User) PutTOTPLastCode(code string) { u.TOTPLastCode = code }
+
+// PutSMSPhoneNumber into user
+func (u *User) PutSMSPhoneNumber(number string) { u.SMSPhoneNumber = number }
+
+// PutRecoveryCodes into user
+func (u *User) PutRecoveryCodes(codes string) { u.RecoveryCodes = codes }
@@ -0,0 +1,172 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Dialect is the flavour of SQL spoken by the database, it decides the
+// placeholders used in queries and the column types of the schema.
+type Dialect int
+
+// Dialects
+const (
+	Postgres Dialect = iota
+	MySQL
+	SQLite
+)
+
+// String returns the name of the dialect
+func (d Dialect) String() string {
+	switch d {
+	case Postgres:
+		return "postgres"
+	case MySQL:
+		return "mysql"
+	case SQLite:
+		return "sqlite"
+	default:
+		return "Dialect(" + strconv.Itoa(int(d)) + ")"
+	}
+}
+
+// rebind replaces the ? placeholders of query with the dialect's
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// types returns the column types for short (indexed) strings, long strings
+// and timestamps
+func (d Dialect) types() (short, long, timestamp string) {
+	switch d {
+	case MySQL:
+		return "VARCHAR(255)", "TEXT", "DATETIME(6)"
+	default:
+		return "TEXT", "TEXT", "TIMESTAMP"
+	}
+}
+
+// Migration is a step of the schema, applied once in order of Version
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// Migrations returns the schema's migrations for the dialect. They're what
+// Storer.Migrate applies, for applications that manage their schema with
+// their own tool they're the statements to copy into it.
+func Migrations(d Dialect) []Migration {
+	short, long, timestamp := d.types()
+
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "create users and remember tokens",
+			Statements: []string{
+				fmt.Sprintf(`CREATE TABLE users (
+	email %[1]s NOT NULL PRIMARY KEY,
+	password %[1]s NOT NULL,
+	confirm_selector %[1]s NULL UNIQUE,
+	confirm_verifier %[1]s NOT NULL,
+	confirm_expiry %[3]s NULL,
+	confirmed BOOLEAN NOT NULL,
+	attempt_count INTEGER NOT NULL,
+	last_attempt %[3]s NULL,
+	locked %[3]s NULL,
+	recover_selector %[1]s NULL UNIQUE,
+	recover_verifier %[1]s NOT NULL,
+	recover_expiry %[3]s NULL,
+	otps %[2]s NOT NULL,
+	totp_secret_key %[1]s NOT NULL,
+	totp_last_code %[1]s NOT NULL,
+	sms_phone_number %[1]s NOT NULL,
+	recovery_codes %[2]s NOT NULL
+)`, short, long, timestamp),
+				fmt.Sprintf(`CREATE TABLE remember_tokens (
+	pid %[1]s NOT NULL,
+	token %[1]s NOT NULL,
+	PRIMARY KEY (pid, token)
+)`, short),
+			},
+		},
+	}
+}
+
+// Migrate brings the schema up to date, migrations that were applied
+// before (recorded in the authboss_migrations table) are skipped.
+func (s *Storer) Migrate(ctx context.Context) error {
+	if _, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS authboss_migrations (version INTEGER NOT NULL PRIMARY KEY)`); err != nil {
+		return errors.Wrap(err, "failed to create migrations table")
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT version FROM authboss_migrations`)
+	if err != nil {
+		return errors.Wrap(err, "failed to load applied migrations")
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "failed to load applied migrations")
+		}
+		applied[version] = true
+	}
+	if err = rows.Close(); err != nil {
+		return errors.Wrap(err, "failed to load applied migrations")
+	}
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "failed to load applied migrations")
+	}
+
+	for _, m := range Migrations(s.Dialect) {
+		if applied[m.Version] {
+			continue
+		}
+		if err = s.migrate(ctx, m); err != nil {
+			return errors.Wrapf(err, "failed to apply migration %d (%s)", m.Version, m.Name)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storer) migrate(ctx context.Context, m Migration) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range m.Statements {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, s.Dialect.rebind(`INSERT INTO authboss_migrations (version) VALUES (?)`), m.Version); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
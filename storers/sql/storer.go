@@ -0,0 +1,254 @@
+// Package sql implements authboss' storers on database/sql against a
+// documented schema (see Migrations), so applications only need a
+// database and a driver to start. It doesn't depend on any ORM: sqlx's
+// DB embeds a *sql.DB and gorm's DB() returns one.
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+const userColumns = `email, password, confirm_selector, confirm_verifier, confirm_expiry, confirmed,
+	attempt_count, last_attempt, locked, recover_selector, recover_verifier, recover_expiry,
+	otps, totp_secret_key, totp_last_code, sms_phone_number, recovery_codes`
+
+var (
+	_ authboss.CreatingServerStorer    = (*Storer)(nil)
+	_ authboss.ConfirmingServerStorer  = (*Storer)(nil)
+	_ authboss.RecoveringServerStorer  = (*Storer)(nil)
+	_ authboss.RememberingServerStorer = (*Storer)(nil)
+	_ authboss.TxServerStorer          = (*Storer)(nil)
+	_ authboss.TxStorer                = Tx{}
+)
+
+// queryer is what *sql.DB and *sql.Tx have in common
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (dbsql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *dbsql.Row
+}
+
+// Storer is a ServerStorer for the users and remember_tokens tables. It's
+// also a CreatingServerStorer, ConfirmingServerStorer,
+// RecoveringServerStorer, RememberingServerStorer and TxServerStorer.
+type Storer struct {
+	DB      *dbsql.DB
+	Dialect Dialect
+
+	tx *dbsql.Tx
+}
+
+// New creates a storer, the schema has to be created with Migrate (or the
+// statements from Migrations) before it's used.
+func New(db *dbsql.DB, dialect Dialect) *Storer {
+	return &Storer{DB: db, Dialect: dialect}
+}
+
+func (s *Storer) conn() queryer {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+func (s *Storer) exec(ctx context.Context, query string, args ...interface{}) (dbsql.Result, error) {
+	return s.conn().ExecContext(ctx, s.Dialect.rebind(query), args...)
+}
+
+func (s *Storer) queryRow(ctx context.Context, query string, args ...interface{}) *dbsql.Row {
+	return s.conn().QueryRowContext(ctx, s.Dialect.rebind(query), args...)
+}
+
+// New creates a blank user
+func (s *Storer) New(ctx context.Context) authboss.User {
+	return &User{}
+}
+
+// Load the user by their e-mail address
+func (s *Storer) Load(ctx context.Context, key string) (authboss.User, error) {
+	return s.loadBy(ctx, "email", key)
+}
+
+// LoadByConfirmSelector finds the user with the confirm selector
+func (s *Storer) LoadByConfirmSelector(ctx context.Context, selector string) (authboss.ConfirmableUser, error) {
+	return s.loadBy(ctx, "confirm_selector", selector)
+}
+
+// LoadByRecoverSelector finds the user with the recover selector
+func (s *Storer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	return s.loadBy(ctx, "recover_selector", selector)
+}
+
+func (s *Storer) loadBy(ctx context.Context, column, value string) (*User, error) {
+	if len(value) == 0 {
+		return nil, authboss.ErrUserNotFound
+	}
+
+	row := s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE `+column+` = ?`, value)
+
+	var u User
+	var confirmSelector, recoverSelector dbsql.NullString
+	var confirmExpiry, lastAttempt, locked, recoverExpiry dbsql.NullTime
+	err := row.Scan(&u.Email, &u.Password, &confirmSelector, &u.ConfirmVerifier, &confirmExpiry, &u.Confirmed,
+		&u.AttemptCount, &lastAttempt, &locked, &recoverSelector, &u.RecoverVerifier, &recoverExpiry,
+		&u.OTPs, &u.TOTPSecretKey, &u.TOTPLastCode, &u.SMSPhoneNumber, &u.RecoveryCodes)
+	if err == dbsql.ErrNoRows {
+		return nil, authboss.ErrUserNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to load user")
+	}
+
+	u.ConfirmSelector = confirmSelector.String
+	u.RecoverSelector = recoverSelector.String
+	u.ConfirmExpiry = confirmExpiry.Time
+	u.LastAttempt = lastAttempt.Time
+	u.Locked = locked.Time
+	u.RecoverExpiry = recoverExpiry.Time
+
+	return &u, nil
+}
+
+// Create inserts the user, ErrUserFound is returned if the e-mail address
+// is taken.
+func (s *Storer) Create(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	if exists, err := s.exists(ctx, u.Email); err != nil {
+		return err
+	} else if exists {
+		return authboss.ErrUserFound
+	}
+
+	_, err = s.exec(ctx, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append([]interface{}{u.Email}, values(u)...)...)
+	return errors.Wrap(err, "failed to create user")
+}
+
+// Save the user, ErrUserNotFound is returned if they don't exist
+func (s *Storer) Save(ctx context.Context, user authboss.User) error {
+	u, err := toUser(user)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.exec(ctx, `UPDATE users SET password = ?, confirm_selector = ?, confirm_verifier = ?,
+	confirm_expiry = ?, confirmed = ?, attempt_count = ?, last_attempt = ?, locked = ?, recover_selector = ?,
+	recover_verifier = ?, recover_expiry = ?, otps = ?, totp_secret_key = ?, totp_last_code = ?,
+	sms_phone_number = ?, recovery_codes = ? WHERE email = ?`, append(values(u), u.Email)...)
+	if err != nil {
+		return errors.Wrap(err, "failed to save user")
+	}
+
+	// MySQL counts the rows changed rather than the rows matched, so a
+	// save that changes nothing has to check the user exists
+	if n, err := result.RowsAffected(); err == nil && n != 0 {
+		return nil
+	}
+	if exists, err := s.exists(ctx, u.Email); err != nil {
+		return err
+	} else if !exists {
+		return authboss.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *Storer) exists(ctx context.Context, pid string) (bool, error) {
+	var one int
+	err := s.queryRow(ctx, `SELECT 1 FROM users WHERE email = ?`, pid).Scan(&one)
+	if err == dbsql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to look up user")
+	}
+	return true, nil
+}
+
+// AddRememberToken for the user
+func (s *Storer) AddRememberToken(ctx context.Context, pid, token string) error {
+	_, err := s.exec(ctx, `INSERT INTO remember_tokens (pid, token) VALUES (?, ?)`, pid, token)
+	return errors.Wrap(err, "failed to add remember token")
+}
+
+// DelRememberTokens removes all of the user's remember tokens
+func (s *Storer) DelRememberTokens(ctx context.Context, pid string) error {
+	_, err := s.exec(ctx, `DELETE FROM remember_tokens WHERE pid = ?`, pid)
+	return errors.Wrap(err, "failed to delete remember tokens")
+}
+
+// UseRememberToken deletes the token, ErrTokenNotFound is returned if the
+// user doesn't have it
+func (s *Storer) UseRememberToken(ctx context.Context, pid, token string) error {
+	result, err := s.exec(ctx, `DELETE FROM remember_tokens WHERE pid = ? AND token = ?`, pid, token)
+	if err != nil {
+		return errors.Wrap(err, "failed to use remember token")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to use remember token")
+	} else if n == 0 {
+		return authboss.ErrTokenNotFound
+	}
+	return nil
+}
+
+// BeginTx starts a transaction, see authboss.InTx
+func (s *Storer) BeginTx(ctx context.Context) (authboss.TxStorer, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return Tx{Storer: &Storer{DB: s.DB, Dialect: s.Dialect, tx: tx}}, nil
+}
+
+// Tx is a Storer that makes its queries in a transaction
+type Tx struct {
+	*Storer
+}
+
+// Commit the transaction
+func (t Tx) Commit(ctx context.Context) error {
+	return t.tx.Commit()
+}
+
+// Rollback the transaction
+func (t Tx) Rollback(ctx context.Context) error {
+	return t.tx.Rollback()
+}
+
+func toUser(user authboss.User) (*User, error) {
+	u, ok := user.(*User)
+	if !ok {
+		return nil, errors.Errorf("sql storer can only store *sql.User, got %T", user)
+	}
+	return u, nil
+}
+
+// values of the user's columns after the e-mail address, in the order of
+// userColumns
+func values(u *User) []interface{} {
+	return []interface{}{
+		u.Password, nullString(u.ConfirmSelector), u.ConfirmVerifier, nullTime(u.ConfirmExpiry), u.Confirmed,
+		u.AttemptCount, nullTime(u.LastAttempt), nullTime(u.Locked), nullString(u.RecoverSelector), u.RecoverVerifier,
+		nullTime(u.RecoverExpiry), u.OTPs, u.TOTPSecretKey, u.TOTPLastCode, u.SMSPhoneNumber, u.RecoveryCodes,
+	}
+}
+
+// nullString stores empty selectors as NULL so they don't collide in the
+// unique index
+func nullString(s string) dbsql.NullString {
+	return dbsql.NullString{String: s, Valid: len(s) != 0}
+}
+
+func nullTime(t time.Time) dbsql.NullTime {
+	return dbsql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
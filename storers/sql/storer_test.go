@@ -0,0 +1,317 @@
+package sql
+
+import (
+	"context"
+	dbsql "database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/otp"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/sms2fa"
+	"github.com/volatiletech/authboss/v3/otp/twofactor/totp2fa"
+)
+
+var (
+	_ authboss.AuthableUser            = (*User)(nil)
+	_ authboss.ExpiringConfirmableUser = (*User)(nil)
+	_ authboss.LockableUser            = (*User)(nil)
+	_ authboss.RecoverableUser         = (*User)(nil)
+	_ otp.User                         = (*User)(nil)
+	_ totp2fa.UserOneTime              = (*User)(nil)
+	_ sms2fa.User                      = (*User)(nil)
+)
+
+// fakeDriver records the queries made and answers them with the results
+// queued by the test, in order
+type fakeDriver struct{}
+
+type fakeDB struct {
+	mut     sync.Mutex
+	queries []string
+	args    [][]driver.Value
+	results []fakeResult
+}
+
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+var fakeDBs sync.Map
+
+func init() {
+	dbsql.Register("authboss-fake", fakeDriver{})
+}
+
+func newFakeDB(t *testing.T, results ...fakeResult) (*dbsql.DB, *fakeDB) {
+	t.Helper()
+
+	fake := &fakeDB{results: results}
+	fakeDBs.Store(t.Name(), fake)
+	db, err := dbsql.Open("authboss-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	return db, fake
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fake, _ := fakeDBs.Load(name)
+	return fakeConn{fake.(*fakeDB)}, nil
+}
+
+func (f *fakeDB) do(query string, args []driver.Value) fakeResult {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.queries = append(f.queries, query)
+	f.args = append(f.args, args)
+	if len(f.results) == 0 {
+		return fakeResult{}
+	}
+	result := f.results[0]
+	f.results = f.results[1:]
+	return result
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) {
+	c.db.do("BEGIN", nil)
+	return fakeTx{c.db}, nil
+}
+
+type fakeTx struct{ db *fakeDB }
+
+func (t fakeTx) Commit() error   { t.db.do("COMMIT", nil); return nil }
+func (t fakeTx) Rollback() error { t.db.do("ROLLBACK", nil); return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result := s.db.do(s.query, args)
+	return driver.RowsAffected(result.affected), result.err
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	result := s.db.do(s.query, args)
+	return &fakeRows{result: result}, result.err
+}
+
+type fakeRows struct {
+	result fakeResult
+	i      int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.i])
+	r.i++
+	return nil
+}
+
+func userRow(email, confirmSelector interface{}, confirmed bool, locked interface{}) fakeResult {
+	return fakeResult{
+		columns: strings.Split(strings.Join(strings.Fields(userColumns), ""), ","),
+		rows: [][]driver.Value{{
+			email, "hash", confirmSelector, "verifier", nil, confirmed,
+			int64(2), nil, locked, nil, "", nil,
+			"", "totpkey", "", "555-5555", "codes",
+		}},
+	}
+}
+
+func one() fakeResult {
+	return fakeResult{columns: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}
+}
+
+func TestRebind(t *testing.T) {
+	t.Parallel()
+
+	query := `SELECT 1 FROM users WHERE email = ? AND token = ?`
+	if got := Postgres.rebind(query); got != `SELECT 1 FROM users WHERE email = $1 AND token = $2` {
+		t.Error("wrong query:", got)
+	}
+	if got := MySQL.rebind(query); got != query {
+		t.Error("wrong query:", got)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	t.Parallel()
+
+	for _, d := range []Dialect{Postgres, MySQL, SQLite} {
+		migrations := Migrations(d)
+		if len(migrations) == 0 || migrations[0].Version != 1 {
+			t.Fatal(d, "should start at version 1")
+		}
+		stmts := strings.Join(migrations[0].Statements, "\n")
+		if !strings.Contains(stmts, "CREATE TABLE users") || !strings.Contains(stmts, "CREATE TABLE remember_tokens") {
+			t.Error(d, "tables are missing:", stmts)
+		}
+		if d == MySQL && strings.Contains(stmts, "email TEXT") {
+			t.Error("mysql can't index text columns:", stmts)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	db, fake := newFakeDB(t)
+	if err := New(db, Postgres).Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := strings.Join(fake.queries, "\n")
+	if !strings.Contains(queries, "CREATE TABLE users") || !strings.HasSuffix(queries, "COMMIT") {
+		t.Error("the migration should have been applied:", queries)
+	}
+	if last := fake.args[len(fake.args)-2]; len(last) != 1 || last[0] != int64(1) {
+		t.Error("the version should have been recorded:", last)
+	}
+
+	db, fake = newFakeDB(t, fakeResult{}, fakeResult{columns: []string{"version"}, rows: [][]driver.Value{{int64(1)}}})
+	if err := New(db, Postgres).Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.queries) != 2 {
+		t.Error("applied migrations should be skipped:", fake.queries)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	locked := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	db, fake := newFakeDB(t, userRow("test@test.com", nil, true, locked))
+	s := New(db, Postgres)
+
+	user, err := s.Load(context.Background(), "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := user.(*User)
+	if u.Email != "test@test.com" || !u.Confirmed || u.AttemptCount != 2 || !u.Locked.Equal(locked) ||
+		u.ConfirmSelector != "" || u.TOTPSecretKey != "totpkey" || u.SMSPhoneNumber != "555-5555" {
+		t.Errorf("user was wrong: %#v", u)
+	}
+	if !strings.HasSuffix(fake.queries[0], "WHERE email = $1") {
+		t.Error("wrong query:", fake.queries[0])
+	}
+
+	if _, err = s.Load(context.Background(), "test@test.com"); err != authboss.ErrUserNotFound {
+		t.Error("want ErrUserNotFound, got:", err)
+	}
+	if _, err = s.LoadByConfirmSelector(context.Background(), ""); err != authboss.ErrUserNotFound {
+		t.Error("want ErrUserNotFound, got:", err)
+	}
+	if len(fake.queries) != 2 {
+		t.Error("an empty selector shouldn't be looked up:", fake.queries)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	db, fake := newFakeDB(t, one())
+	s := New(db, MySQL)
+
+	user := s.New(context.Background()).(*User)
+	user.PutPID("test@test.com")
+	user.PutPassword("hash")
+	if err := s.Create(context.Background(), user); err != authboss.ErrUserFound {
+		t.Error("want ErrUserFound, got:", err)
+	}
+
+	if err := s.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	insert := fake.args[2]
+	if !strings.HasPrefix(fake.queries[2], "INSERT INTO users") || len(insert) != 17 {
+		t.Fatal("the user should have been inserted:", fake.queries[2], insert)
+	}
+	if insert[0] != "test@test.com" || insert[1] != "hash" || insert[2] != nil || insert[4] != nil {
+		t.Error("empty selectors and times should be NULL:", insert)
+	}
+}
+
+func TestSave(t *testing.T) {
+	t.Parallel()
+
+	db, fake := newFakeDB(t, fakeResult{affected: 1}, fakeResult{affected: 0}, one(), fakeResult{affected: 0})
+	s := New(db, SQLite)
+	user := &User{Email: "test@test.com", ConfirmSelector: "selector"}
+
+	if err := s.Save(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if args := fake.args[0]; args[1] != "selector" || args[len(args)-1] != "test@test.com" {
+		t.Error("args were wrong:", args)
+	}
+
+	if err := s.Save(context.Background(), user); err != nil {
+		t.Error("a save that changed nothing should succeed:", err)
+	}
+	if err := s.Save(context.Background(), user); err != authboss.ErrUserNotFound {
+		t.Error("want ErrUserNotFound, got:", err)
+	}
+}
+
+func TestRememberTokens(t *testing.T) {
+	t.Parallel()
+
+	db, fake := newFakeDB(t, fakeResult{}, fakeResult{affected: 1}, fakeResult{affected: 0})
+	s := New(db, Postgres)
+	ctx := context.Background()
+
+	if err := s.AddRememberToken(ctx, "test@test.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "token"); err != nil {
+		t.Error(err)
+	}
+	if err := s.UseRememberToken(ctx, "test@test.com", "token"); err != authboss.ErrTokenNotFound {
+		t.Error("want ErrTokenNotFound, got:", err)
+	}
+	if fake.queries[1] != `DELETE FROM remember_tokens WHERE pid = $1 AND token = $2` {
+		t.Error("wrong query:", fake.queries[1])
+	}
+}
+
+func TestTx(t *testing.T) {
+	t.Parallel()
+
+	db, fake := newFakeDB(t)
+	ab := authboss.New()
+	ab.Config.Storage.Server = New(db, Postgres)
+
+	err := ab.InTx(context.Background(), func(ctx context.Context) error {
+		return ab.Storer(ctx).(Tx).AddRememberToken(ctx, "test@test.com", "token")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.queries) != 3 || fake.queries[0] != "BEGIN" || fake.queries[2] != "COMMIT" {
+		t.Error("the token should have been added in a transaction:", fake.queries)
+	}
+}
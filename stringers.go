@@ -4,9 +4,9 @@ package authboss
 
 import "strconv"
 
-const _Event_name = "EventRegisterEventAuthEventAuthHijackEventOAuth2EventAuthFailEventOAuth2FailEventRecoverStartEventRecoverEndEventGetUserEventGetUserSessionEventPasswordReset"
+const _Event_name = "EventRegisterEventAuthEventAuthHijackEventOAuth2EventAuthFailEventOAuth2FailEventRecoverStartEventRecoverEndEventGetUserEventGetUserSessionEventPasswordResetEventLogoutEventOAuth2LinkEventOAuth2UnlinkEventTokenReuseEventExpireIdleEventExpireLifetimeEventLockEventUnlockEventTwoFactorAddEventTwoFactorRemoveEventNewDeviceEventAuthAttemptEventPasswordSprayEventRegisterBotEventMailFailedEventPasswordChangeEventSessionDestroyEventImpersonateStartEventImpersonateEndEventAccountUpgradeEventRenameEventOAuth2RejectedEventOAuth2RefreshFailedEventPanicEventSuspendEventUnsuspendEventUserChangeEventRememberLogin"
 
-var _Event_index = [...]uint8{0, 13, 22, 37, 48, 61, 76, 93, 108, 120, 139, 157}
+var _Event_index = [...]uint16{0, 13, 22, 37, 48, 61, 76, 93, 108, 120, 139, 157, 168, 183, 200, 215, 230, 249, 258, 269, 286, 306, 320, 336, 354, 370, 385, 404, 423, 444, 463, 482, 493, 512, 536, 546, 558, 572, 587, 605}
 
 func (i Event) String() string {
 	if i < 0 || i >= Event(len(_Event_index)-1) {
@@ -0,0 +1,98 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Suspend the user until the given time, or until Unsuspend is called if
+// until is zero. The reason is kept for the app's admins, users are only
+// told they're suspended. Their sessions, api tokens and api keys are
+// revoked (see RevokeSessions) and the suspend module keeps them from
+// logging in again.
+func (a *Authboss) Suspend(ctx context.Context, pid, reason string, until time.Time) error {
+	user, err := a.LoadUser(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	su := MustBeSuspendable(user)
+	su.PutSuspended(true)
+	su.PutSuspendReason(reason)
+	su.PutSuspendedUntil(until)
+	if err = a.SaveUser(ctx, su); err != nil {
+		return err
+	}
+
+	if err = a.RevokeSessions(ctx, pid); err != nil {
+		return err
+	}
+
+	return a.fireSuspendEvent(ctx, EventSuspend, su)
+}
+
+// Unsuspend lifts the user's suspension
+func (a *Authboss) Unsuspend(ctx context.Context, pid string) error {
+	user, err := a.LoadUser(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	su := MustBeSuspendable(user)
+	su.PutSuspended(false)
+	su.PutSuspendReason("")
+	su.PutSuspendedUntil(time.Time{})
+	if err = a.SaveUser(ctx, su); err != nil {
+		return err
+	}
+
+	return a.fireSuspendEvent(ctx, EventUnsuspend, su)
+}
+
+// IsSuspended checks if the user is suspended at the time, a suspension
+// whose until has passed is over.
+func IsSuspended(su SuspendableUser, now time.Time) bool {
+	if !su.GetSuspended() {
+		return false
+	}
+	until := su.GetSuspendedUntil()
+	return until.IsZero() || until.After(now)
+}
+
+// IsLockedOrSuspended checks if the user is suspended (see IsSuspended)
+// or locked by the lock module, for users that support either. Logins are
+// stopped by their modules, credentials that don't go through the login
+// events (like api tokens and keys) must check it themselves.
+func IsLockedOrSuspended(user User, now time.Time) bool {
+	if su, ok := user.(SuspendableUser); ok && IsSuspended(su, now) {
+		return true
+	}
+	if lu, ok := user.(LockableUser); ok && lu.GetLocked().After(now.UTC()) {
+		return true
+	}
+	return false
+}
+
+// SuspendedMessage is what a suspended user is told, with the end of the
+// suspension if it has one
+func (a *Authboss) SuspendedMessage(ctx context.Context, su SuspendableUser) string {
+	until := su.GetSuspendedUntil()
+	if until.IsZero() {
+		return a.Localize(ctx, TxtSuspended)
+	}
+	return a.Localize(ctx, TxtSuspendedUntil, "Until", until.UTC().Format("January 2, 2006 15:04 MST"))
+}
+
+func (a *Authboss) fireSuspendEvent(ctx context.Context, e Event, su SuspendableUser) error {
+	ctx = context.WithValue(ctx, CTXKeyUser, su)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create request for %s", e)
+	}
+
+	_, err = a.Events.FireAfter(e, discardResponseWriter{}, r)
+	return err
+}
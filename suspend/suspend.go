@@ -0,0 +1,85 @@
+// Package suspend keeps users that an admin suspended (banned) with
+// authboss.Authboss.Suspend from logging in and from the routes behind its
+// Middleware. Unlike the lock module's locks, suspensions aren't caused by
+// failed logins and only end when they're lifted or their time is up.
+package suspend
+
+import (
+	"net/http"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+func init() {
+	authboss.RegisterModule("suspend", &Suspend{})
+}
+
+// Suspend module
+type Suspend struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what the module needs
+func (s *Suspend) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	return ab.Config.Missing("Core.Redirector", "Core.Logger", "Storage.Server")
+}
+
+// Init the module
+func (s *Suspend) Init(ab *authboss.Authboss) error {
+	s.Authboss = ab
+
+	s.Events.Before(authboss.EventAuth, s.BeforeAuth)
+	s.Events.Before(authboss.EventOAuth2, s.BeforeAuth)
+
+	return nil
+}
+
+// BeforeAuth stops suspended users from logging in
+func (s *Suspend) BeforeAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	user, err := s.Authboss.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	su := authboss.MustBeSuspendable(user)
+	if !authboss.IsSuspended(su, s.Now()) {
+		return false, nil
+	}
+
+	s.RequestLogger(r).Infof("user %s prevented from logging in: suspended", user.GetPID())
+	return true, redirect(s.Authboss, w, r, su)
+}
+
+// Middleware ensures that a user is not suspended, or else it will
+// intercept the request and send them to the configured SuspendNotOK page.
+// It loads the user if they haven't been loaded yet and panics if it
+// cannot load the user.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := ab.LoadCurrentUserP(&r)
+
+			su := authboss.MustBeSuspendable(user)
+			if !authboss.IsSuspended(su, ab.Now()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+			logger.Infof("user %s prevented from accessing %s: suspended", user.GetPID(), r.URL.Path)
+			if err := redirect(ab, w, r, su); err != nil {
+				logger.Errorf("error redirecting in suspend.Middleware: %+v", err)
+			}
+		})
+	}
+}
+
+func redirect(ab *authboss.Authboss, w http.ResponseWriter, r *http.Request, su authboss.SuspendableUser) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      ab.SuspendedMessage(ab.LocaleContext(r), su),
+		FailureCode:  authboss.ErrorCodeSuspended,
+		RedirectPath: ab.RedirectPath(r, "suspend", authboss.RedirectNotOK, su, ab.RequestConfig(r.Context()).Paths.SuspendNotOK),
+	}
+	return ab.Config.Core.Redirector.Redirect(w, r, ro)
+}
@@ -0,0 +1,165 @@
+package suspend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+type testHarness struct {
+	suspend    *Suspend
+	ab         *authboss.Authboss
+	redirector *mocks.Redirector
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.redirector = &mocks.Redirector{}
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.SuspendNotOK = "/suspended"
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.suspend = &Suspend{}
+	if err := harness.suspend.Init(harness.ab); err != nil {
+		panic(err)
+	}
+
+	return harness
+}
+
+func (h *testHarness) beforeAuth(user *mocks.User) (bool, *httptest.ResponseRecorder) {
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := h.suspend.BeforeAuth(w, r, false)
+	if err != nil {
+		panic(err)
+	}
+	return handled, w
+}
+
+func TestBeforeAuth(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	if handled, _ := harness.beforeAuth(&mocks.User{Email: "test@test.com"}); handled {
+		t.Error("users that aren't suspended should log in")
+	}
+
+	expired := &mocks.User{Email: "test@test.com", Suspended: true, SuspendedUntil: time.Now().Add(-time.Minute)}
+	if handled, _ := harness.beforeAuth(expired); handled {
+		t.Error("suspensions should be over once their time is up")
+	}
+
+	suspended := &mocks.User{Email: "test@test.com", Suspended: true, SuspendReason: "spam"}
+	handled, w := harness.beforeAuth(suspended)
+	if !handled || w.Code != http.StatusTemporaryRedirect {
+		t.Fatal("suspended users should not log in:", handled, w.Code)
+	}
+
+	opts := harness.redirector.Options
+	if opts.RedirectPath != "/suspended" || opts.FailureCode != authboss.ErrorCodeSuspended {
+		t.Errorf("the redirect was wrong: %#v", opts)
+	}
+	if opts.Failure != authboss.TxtSuspended.Default {
+		t.Error("the message was wrong:", opts.Failure)
+	}
+
+	suspended.SuspendedUntil = time.Date(2030, 1, 2, 15, 4, 0, 0, time.UTC)
+	harness.beforeAuth(suspended)
+	if msg := harness.redirector.Options.Failure; !strings.Contains(msg, "until January 2, 2030 15:04 UTC") {
+		t.Error("the message should say when the suspension ends:", msg)
+	}
+}
+
+func TestSuspend(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	harness.storer.RMTokens["test@test.com"] = []string{"token"}
+
+	var events []authboss.Event
+	for _, e := range []authboss.Event{authboss.EventSuspend, authboss.EventUnsuspend} {
+		e := e
+		harness.ab.Events.After(e, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+			if user, _ := r.Context().Value(authboss.CTXKeyUser).(authboss.User); user == nil || user.GetPID() != "test@test.com" {
+				t.Error("the user should be in the context")
+			}
+			events = append(events, e)
+			return false, nil
+		})
+	}
+
+	until := time.Now().Add(time.Hour)
+	if err := harness.ab.Suspend(context.Background(), "test@test.com", "spam", until); err != nil {
+		t.Fatal(err)
+	}
+
+	user := harness.storer.Users["test@test.com"]
+	if !authboss.IsSuspended(user, time.Now()) || user.SuspendReason != "spam" || !user.SuspendedUntil.Equal(until) {
+		t.Errorf("the user should be suspended: %#v", user)
+	}
+	if authboss.IsSuspended(user, until.Add(time.Second)) {
+		t.Error("the suspension should end")
+	}
+	if len(harness.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("the user's sessions should have been revoked")
+	}
+
+	if err := harness.ab.Unsuspend(context.Background(), "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if user = harness.storer.Users["test@test.com"]; authboss.IsSuspended(user, time.Now()) || len(user.SuspendReason) != 0 {
+		t.Errorf("the suspension should have been lifted: %#v", user)
+	}
+
+	if len(events) != 2 || events[0] != authboss.EventSuspend || events[1] != authboss.EventUnsuspend {
+		t.Error("the events were wrong:", events)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	called := false
+	server := Middleware(harness.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	serve := func(user *mocks.User) {
+		r := mocks.Request("GET")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		server.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve(&mocks.User{Email: "test@test.com"})
+	if !called {
+		t.Error("the user should have been allowed through")
+	}
+
+	called = false
+	serve(&mocks.User{Email: "test@test.com", Suspended: true})
+	if called {
+		t.Error("the user should not have been allowed through")
+	}
+	if p := harness.redirector.Options.RedirectPath; p != "/suspended" {
+		t.Error("redirect path wrong:", p)
+	}
+}
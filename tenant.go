@@ -0,0 +1,116 @@
+package authboss
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrTenantNotFound is returned by a TenantResolver when the request
+// isn't for one of the tenants, LoadClientStateMiddleware responds with a
+// 404.
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// TenantResolver finds the tenant a request is for. The tenant is used in
+// cookie names so it may only contain letters, digits, '.', '-' and '_'.
+type TenantResolver func(r *http.Request) (string, error)
+
+// TenantFromHost uses the request's host without the port as the tenant,
+// for tenants on their own (sub)domains.
+func TenantFromHost() TenantResolver {
+	return func(r *http.Request) (string, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return validTenant(strings.ToLower(host))
+	}
+}
+
+// TenantFromHeader uses the value of the header as the tenant, for tenants
+// set by a proxy in front of the app.
+func TenantFromHeader(header string) TenantResolver {
+	return func(r *http.Request) (string, error) {
+		return validTenant(r.Header.Get(header))
+	}
+}
+
+// TenantFromPath uses the first segment of the request's path as the
+// tenant, like acme for /acme/auth/login.
+func TenantFromPath() TenantResolver {
+	return func(r *http.Request) (string, error) {
+		segment := strings.TrimPrefix(r.URL.Path, "/")
+		if i := strings.IndexByte(segment, '/'); i >= 0 {
+			segment = segment[:i]
+		}
+		return validTenant(segment)
+	}
+}
+
+func validTenant(tenant string) (string, error) {
+	if len(tenant) == 0 {
+		return "", ErrTenantNotFound
+	}
+
+	for _, c := range tenant {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '-', c == '_':
+		default:
+			return "", ErrTenantNotFound
+		}
+	}
+
+	return tenant, nil
+}
+
+// Tenant returns the tenant LoadClientState put in the context (see
+// Config.Core.TenantResolver), it's empty when there are no tenants.
+// Storers serving many tenants use it to scope their lookups.
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(CTXKeyTenant).(string)
+	return tenant
+}
+
+// ResponseTenant returns the tenant of the request the response is for,
+// ClientStateReadWriters use it in WriteState to pick the cookie.
+func ResponseTenant(w http.ResponseWriter) string {
+	for {
+		if c, ok := w.(*ClientStateResponseWriter); ok {
+			return c.tenant
+		}
+
+		u, ok := w.(UnderlyingResponseWriter)
+		if !ok {
+			return ""
+		}
+		w = u.UnderlyingResponseWriter()
+	}
+}
+
+// TenantCookie is what the cookie called name is called for tenant, it's
+// name when there's no tenant.
+func TenantCookie(name, tenant string) string {
+	if len(tenant) == 0 {
+		return name
+	}
+
+	return name + "_" + tenant
+}
+
+// TenantToken scopes the part of a token that's used to look it up (like
+// a confirm selector) to the context's tenant before it's hashed, so a
+// token only works for the tenant it was made for.
+func TenantToken(ctx context.Context, selector []byte) []byte {
+	tenant := Tenant(ctx)
+	if len(tenant) == 0 {
+		return selector
+	}
+
+	scoped := make([]byte, 0, len(tenant)+1+len(selector))
+	scoped = append(scoped, tenant...)
+	scoped = append(scoped, 0)
+	return append(scoped, selector...)
+}
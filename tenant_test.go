@@ -0,0 +1,104 @@
+package authboss
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantResolvers(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "http://Acme.example.com:8080/acme/auth/login", nil)
+	r.Header.Set("X-Tenant", "acme")
+
+	tests := []struct {
+		Resolver TenantResolver
+		Want     string
+	}{
+		{TenantFromHost(), "acme.example.com"},
+		{TenantFromHeader("X-Tenant"), "acme"},
+		{TenantFromPath(), "acme"},
+	}
+
+	for i, test := range tests {
+		if got, err := test.Resolver(r); err != nil || got != test.Want {
+			t.Errorf("%d) want: %s, got: %s (%v)", i, test.Want, got, err)
+		}
+	}
+
+	bad := httptest.NewRequest("GET", "/", nil)
+	bad.Header.Set("X-Tenant", "acme; path=/")
+	if _, err := TenantFromHeader("X-Tenant")(bad); err != ErrTenantNotFound {
+		t.Error("a tenant that can't be in a cookie name should not be found:", err)
+	}
+	if _, err := TenantFromPath()(bad); err != ErrTenantNotFound {
+		t.Error("an empty path should not have a tenant:", err)
+	}
+}
+
+func TestTenantClientState(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	cookies := newMockClientStateRW(TenantCookie(CookieRemember, "acme"), "token")
+	ab.Config.Core.TenantResolver = TenantFromHeader("X-Tenant")
+	ab.Config.Storage.CookieState = cookies
+
+	w := ab.NewResponse(httptest.NewRecorder())
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Tenant", "acme")
+	r, err := ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tenant := Tenant(r.Context()); tenant != "acme" {
+		t.Error("tenant was wrong:", tenant)
+	}
+	if tenant := ResponseTenant(w); tenant != "acme" {
+		t.Error("response tenant was wrong:", tenant)
+	}
+	if token, ok := GetCookie(r, CookieRemember); !ok || token != "token" {
+		t.Error("the tenant's cookie should be read:", token)
+	}
+
+	PutCookie(w, CookieDevice, "device")
+	w.WriteHeader(http.StatusOK)
+	if _, ok := cookies.state["dv_acme"]; !ok {
+		t.Error("the cookie should be written for the tenant:", cookies.state)
+	}
+}
+
+func TestTenantNotFound(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.TenantResolver = TenantFromHeader("X-Tenant")
+
+	called := false
+	rec := httptest.NewRecorder()
+	ab.LoadClientStateMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if called || rec.Code != http.StatusNotFound {
+		t.Error("requests without a tenant should be a 404:", rec.Code)
+	}
+}
+
+func TestTenantToken(t *testing.T) {
+	t.Parallel()
+
+	selector := []byte("selector")
+	if got := TenantToken(context.Background(), selector); !bytes.Equal(got, selector) {
+		t.Error("without a tenant the selector should be unchanged:", got)
+	}
+
+	ctx := context.WithValue(context.Background(), CTXKeyTenant, "acme")
+	if got := TenantToken(ctx, selector); string(got) != "acme\x00selector" {
+		t.Errorf("the selector should be scoped: %q", got)
+	}
+}
@@ -0,0 +1,124 @@
+package token
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+var errInvalidToken = errors.New("invalid access token")
+
+// Middleware authenticates API requests using an access token issued by
+// the token module sent in an "Authorization: Bearer" header. When the
+// token is valid the user is loaded into the request context the same way
+// a session would (so authboss.Middleware2 and CurrentUser work as usual).
+//
+// Requests without a bearer token are passed through untouched, requests
+// with a token that is invalid, expired or revoked, or whose user is
+// locked or suspended, are rejected with a 401.
+func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			logger := ab.RequestLogger(r)
+
+			pid, err := verifyAccessToken(ab, r.Context(), token)
+			if err != nil {
+				if errors.Cause(err) != errInvalidToken {
+					logger.Errorf("failed to verify access token: %+v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				logger.Infof("rejected access token: %v", err)
+				unauthorized(w)
+				return
+			}
+
+			user, err := ab.LoadUser(r.Context(), pid)
+			if err == authboss.ErrUserNotFound {
+				logger.Infof("access token user not found: %s", pid)
+				unauthorized(w)
+				return
+			} else if err != nil {
+				logger.Errorf("failed to load access token user: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if authboss.IsLockedOrSuspended(user, ab.Now()) {
+				logger.Infof("rejected access token of user %s: locked or suspended", pid)
+				unauthorized(w)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authboss.CTXKeyPID, pid)
+			ctx = context.WithValue(ctx, authboss.CTXKeyUser, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// verifyAccessToken returns the pid of the user the token was issued to
+func verifyAccessToken(ab *authboss.Authboss, ctx context.Context, token string) (string, error) {
+	if ab.Config.Modules.TokenSigner != nil {
+		_, claims, err := jwt.Parse(token, jwt.StaticKey(ab.Config.Modules.TokenVerifyKey))
+		if err != nil {
+			return "", errors.Wrap(errInvalidToken, err.Error())
+		}
+
+		expect := jwt.Expectations{
+			Issuers:   []string{ab.Config.Paths.RootURL},
+			Audiences: []string{ab.Config.Paths.RootURL},
+		}
+		if err = claims.Validate(ab.Now(), expect); err != nil {
+			return "", errors.Wrap(errInvalidToken, err.Error())
+		}
+
+		return claims.Subject(), nil
+	}
+
+	storer := authboss.EnsureCanIssueTokens(ab.Config.Storage.Server)
+	issued, err := storer.LoadToken(ctx, hashToken(token))
+	if err == authboss.ErrTokenNotFound {
+		return "", errors.Wrap(errInvalidToken, "token not found")
+	} else if err != nil {
+		return "", err
+	}
+
+	if issued.Kind != authboss.TokenKindAccess {
+		return "", errors.Wrap(errInvalidToken, "not an access token")
+	}
+	if !ab.Now().Before(issued.Expires) {
+		return "", errors.Wrap(errInvalidToken, "token expired")
+	}
+
+	return issued.PID, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(prefix):])
+	return token, len(token) != 0
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,347 @@
+// Package token issues access and refresh tokens to api clients (mobile
+// apps, SPAs with a separate backend) that can't use cookie sessions.
+//
+// Once the module is loaded a successful login (auth, oauth2 or a second
+// factor) responds with a token pair instead of redirecting, so it should
+// be used with a JSON renderer. Access tokens are checked with Middleware,
+// refresh tokens are exchanged for a new pair at /token/refresh and either
+// kind can be revoked at /token/revoke.
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+)
+
+const (
+	// PageToken is the page used to respond with a token pair
+	PageToken = "token"
+	// PageRefresh is for identifying the refresh request for parsing &
+	// validation
+	PageRefresh = "token_refresh"
+	// PageRevoke is for identifying the revoke request for parsing &
+	// validation
+	PageRevoke = "token_revoke"
+
+	// DataAccessToken is the access token in the token response
+	DataAccessToken = "access_token"
+	// DataTokenType is always Bearer
+	DataTokenType = "token_type"
+	// DataExpiresIn is the number of seconds the access token is valid for
+	DataExpiresIn = "expires_in"
+	// DataRefreshToken is the refresh token in the token response
+	DataRefreshToken = "refresh_token"
+
+	nTokenSize  = 32
+	nFamilySize = 16
+)
+
+func init() {
+	authboss.RegisterModule("token", &Token{})
+}
+
+// Token module
+type Token struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks the config has what issuing tokens needs
+func (t *Token) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Router", "Core.ErrorHandler", "Core.ViewRenderer", "Core.BodyReader",
+		"Core.Responder", "Core.Logger", "Storage.Server")
+	return append(problems, ab.Config.NotImplementing("Storage.Server", (*authboss.TokenServerStorer)(nil))...)
+}
+
+// Init module
+func (t *Token) Init(ab *authboss.Authboss) error {
+	t.Authboss = ab
+
+	if err := t.Authboss.Config.Core.ViewRenderer.Load(PageToken, PageRefresh, PageRevoke); err != nil {
+		return err
+	}
+
+	// API clients send their tokens in the body rather than a cookie
+	t.Authboss.Config.Core.Router.Post("/token/refresh", authboss.CSRFExempt(t.Authboss.Core.ErrorHandler.Wrap(t.RefreshPost)))
+	t.Authboss.Config.Core.Router.Post("/token/revoke", authboss.CSRFExempt(t.Authboss.Core.ErrorHandler.Wrap(t.RevokePost)))
+
+	t.Events.After(authboss.EventAuth, t.IssueAfterAuth)
+	t.Events.After(authboss.EventOAuth2, t.IssueAfterAuth)
+
+	return nil
+}
+
+// APIOperations documents the token routes for authboss.OpenAPI, logins
+// respond with the same token pair as refreshing
+func (t *Token) APIOperations(ab *authboss.Authboss) []authboss.APIOperation {
+	return []authboss.APIOperation{
+		{
+			Method:  http.MethodPost,
+			Path:    "/token/refresh",
+			Summary: "Exchange a refresh token for a new token pair",
+			Fields:  []authboss.APIField{{Name: "token", Required: true}},
+			Response: []authboss.APIField{
+				{Name: DataAccessToken, Required: true},
+				{Name: DataTokenType, Required: true},
+				{Name: DataExpiresIn, Type: "integer", Required: true},
+				{Name: DataRefreshToken, Required: true},
+			},
+			ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation, authboss.ErrorCodeInvalidToken},
+		},
+		{
+			Method:     http.MethodPost,
+			Path:       "/token/revoke",
+			Summary:    "Revoke a token and the others issued with it",
+			Fields:     []authboss.APIField{{Name: "token", Required: true}},
+			ErrorCodes: []authboss.ErrorCode{authboss.ErrorCodeValidation},
+		},
+	}
+}
+
+// IssueAfterAuth responds with a new token pair for the user that just
+// logged in, unless another module has already responded.
+func (t *Token) IssueAfterAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if handled {
+		return false, nil
+	}
+
+	pid := authboss.UserPID(t.Authboss.CurrentUserP(r))
+	data, err := t.issue(r.Context(), pid, "")
+	if err != nil {
+		return false, err
+	}
+
+	t.Authboss.RequestLogger(r).Infof("issued api tokens to user %s", pid)
+	return true, t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageToken, data)
+}
+
+// RefreshPost exchanges a refresh token for a new token pair. The refresh
+// token is used up, presenting it again revokes every token issued since
+// the user logged in and fires EventTokenReuse. Locked and suspended users
+// can't refresh their tokens.
+func (t *Token) RefreshPost(w http.ResponseWriter, r *http.Request) error {
+	logger := t.Authboss.RequestLogger(r)
+
+	validatable, err := t.Authboss.Core.BodyReader.Read(PageRefresh, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("refresh token validation failed: %+v", errs)
+		data := authboss.HTMLData{authboss.DataValidation: t.LocalizeErrors(r.Context(), errs)}
+		return t.Authboss.Core.Responder.Respond(w, r, http.StatusBadRequest, PageRefresh, data)
+	}
+
+	values := authboss.MustHaveConfirmValues(validatable)
+	storer := authboss.EnsureCanIssueTokens(t.Authboss.Config.Storage.Server)
+	hash := hashToken(values.GetToken())
+
+	issued, err := storer.LoadToken(r.Context(), hash)
+	if err == authboss.ErrTokenNotFound {
+		logger.Info("refresh token not found")
+		return t.failure(w, r, PageRefresh)
+	} else if err != nil {
+		return err
+	}
+
+	if issued.Kind != authboss.TokenKindRefresh || !t.Now().Before(issued.Expires) {
+		logger.Infof("rejected expired or non-refresh token for user %s", issued.PID)
+		return t.failure(w, r, PageRefresh)
+	}
+
+	if _, err = storer.UseToken(r.Context(), hash); err == authboss.ErrTokenReused {
+		return t.tokenReused(w, r, storer, issued)
+	} else if err != nil {
+		return err
+	}
+
+	user, err := t.LoadUser(r.Context(), issued.PID)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("refresh token user not found: %s", issued.PID)
+		return t.failure(w, r, PageRefresh)
+	} else if err != nil {
+		return err
+	}
+
+	if authboss.IsLockedOrSuspended(user, t.Now()) {
+		logger.Infof("refused to refresh api tokens for user %s: locked or suspended", issued.PID)
+		return t.failure(w, r, PageRefresh)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	data, err := t.issue(r.Context(), issued.PID, issued.Family)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("refreshed api tokens for user %s", issued.PID)
+	return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageToken, data)
+}
+
+// RevokePost revokes the token and every other token issued since the
+// user logged in. As in RFC 7009 it always succeeds so that it can't be
+// used to find out whether tokens are valid.
+func (t *Token) RevokePost(w http.ResponseWriter, r *http.Request) error {
+	logger := t.Authboss.RequestLogger(r)
+
+	validatable, err := t.Authboss.Core.BodyReader.Read(PageRevoke, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("revoke token validation failed: %+v", errs)
+		data := authboss.HTMLData{authboss.DataValidation: t.LocalizeErrors(r.Context(), errs)}
+		return t.Authboss.Core.Responder.Respond(w, r, http.StatusBadRequest, PageRevoke, data)
+	}
+
+	values := authboss.MustHaveConfirmValues(validatable)
+	storer := authboss.EnsureCanIssueTokens(t.Authboss.Config.Storage.Server)
+
+	issued, err := storer.LoadToken(r.Context(), hashToken(values.GetToken()))
+	switch {
+	case err == nil:
+		if err = storer.DelTokenFamily(r.Context(), issued.PID, issued.Family); err != nil {
+			return err
+		}
+		logger.Infof("revoked api tokens for user %s", issued.PID)
+	case err != authboss.ErrTokenNotFound:
+		return err
+	}
+
+	return t.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageRevoke, nil)
+}
+
+// issue a new token pair in the family, starting a new family if it's empty
+func (t *Token) issue(ctx context.Context, pid, family string) (authboss.HTMLData, error) {
+	storer := authboss.EnsureCanIssueTokens(t.Authboss.Config.Storage.Server)
+	modules := t.Authboss.Config.Modules
+	now := t.Now().UTC()
+
+	if len(family) == 0 {
+		var err error
+		if family, err = randomString(nFamilySize); err != nil {
+			return nil, errors.Wrap(err, "failed to create api token family")
+		}
+	}
+
+	refresh, err := randomString(nTokenSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create refresh token")
+	}
+
+	err = storer.AddToken(ctx, authboss.IssuedToken{
+		Hash:    hashToken(refresh),
+		Kind:    authboss.TokenKindRefresh,
+		PID:     pid,
+		Family:  family,
+		Expires: now.Add(modules.TokenRefreshLifetime),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var access string
+	if modules.TokenSigner != nil {
+		access, err = jwt.Sign(jwt.Claims{
+			"iss": t.Authboss.Config.Paths.RootURL,
+			"aud": t.Authboss.Config.Paths.RootURL,
+			"sub": pid,
+			"iat": now.Unix(),
+			"exp": now.Add(modules.TokenAccessLifetime).Unix(),
+		}, modules.TokenSigner)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to sign access token")
+		}
+	} else {
+		if access, err = randomString(nTokenSize); err != nil {
+			return nil, errors.Wrap(err, "failed to create access token")
+		}
+
+		err = storer.AddToken(ctx, authboss.IssuedToken{
+			Hash:    hashToken(access),
+			Kind:    authboss.TokenKindAccess,
+			PID:     pid,
+			Family:  family,
+			Expires: now.Add(modules.TokenAccessLifetime),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return authboss.HTMLData{
+		DataAccessToken:  access,
+		DataTokenType:    "Bearer",
+		DataExpiresIn:    int(modules.TokenAccessLifetime / time.Second),
+		DataRefreshToken: refresh,
+	}, nil
+}
+
+// tokenReused revokes the family of a refresh token that has already been
+// used and the user's sessions, since either the client or an attacker has
+// a stolen copy and we can't tell which.
+func (t *Token) tokenReused(w http.ResponseWriter, r *http.Request, storer authboss.TokenServerStorer, issued authboss.IssuedToken) error {
+	logger := t.Authboss.RequestLogger(r)
+	logger.Errorf("refresh token reuse detected for user %s, revoking token family and sessions", issued.PID)
+
+	if err := storer.DelTokenFamily(r.Context(), issued.PID, issued.Family); err != nil {
+		return errors.Wrap(err, "failed to revoke api token family")
+	}
+	if err := t.Authboss.RevokeSessions(r.Context(), issued.PID); err != nil {
+		return errors.Wrap(err, "failed to revoke sessions")
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, ReuseValues{PID: issued.PID, Family: issued.Family}))
+	handled, err := t.Authboss.Events.FireAfter(authboss.EventTokenReuse, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	return t.failure(w, r, PageRefresh)
+}
+
+func (t *Token) failure(w http.ResponseWriter, r *http.Request, page string) error {
+	data := authboss.HTMLData{
+		authboss.DataErr:     t.Localize(r.Context(), authboss.TxtInvalidToken),
+		authboss.DataErrCode: authboss.ErrorCodeInvalidToken,
+	}
+	return t.Authboss.Core.Responder.Respond(w, r, http.StatusUnauthorized, page, data)
+}
+
+// ReuseValues is put in the context as CTXKeyValues for EventTokenReuse
+// when a refresh token is reused.
+type ReuseValues struct {
+	PID    string
+	Family string
+}
+
+// Validate is a noop, the values do not come from the user
+func (ReuseValues) Validate() []error { return nil }
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha512.Sum512([]byte(token))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
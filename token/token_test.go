@@ -0,0 +1,392 @@
+package token
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/jwt"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+
+	if err := (&Token{}).Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageToken, PageRefresh, PageRevoke); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/token/refresh", "/token/revoke"); err != nil {
+		t.Error(err)
+	}
+}
+
+type testHarness struct {
+	token *Token
+	ab    *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	responder  *mocks.Responder
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.responder = &mocks.Responder{}
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.token = &Token{harness.ab}
+
+	return harness
+}
+
+// login issues a token pair for the user through IssueAfterAuth
+func login(t *testing.T, h *testHarness, user authboss.User) authboss.HTMLData {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	handled, err := h.token.IssueAfterAuth(httptest.NewRecorder(), r, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if !handled {
+		t.Fatal("it should have handled the response")
+	}
+
+	if h.responder.Status != http.StatusOK || h.responder.Page != PageToken {
+		t.Fatalf("response was wrong: %d %s", h.responder.Status, h.responder.Page)
+	}
+
+	return h.responder.Data
+}
+
+func refresh(t *testing.T, h *testHarness, token string) {
+	t.Helper()
+
+	h.bodyReader.Return = mocks.Values{Token: token}
+	if err := h.token.RefreshPost(httptest.NewRecorder(), httptest.NewRequest("POST", "/token/refresh", nil)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIssueAfterAuth(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	data := login(t, h, user)
+
+	if data[DataTokenType] != "Bearer" || data[DataExpiresIn] != 900 {
+		t.Errorf("token response was wrong: %#v", data)
+	}
+	if len(h.storer.Tokens) != 2 {
+		t.Fatal("the access and refresh tokens should be stored:", h.storer.Tokens)
+	}
+
+	refreshToken := h.storer.Tokens[hashToken(data[DataRefreshToken].(string))]
+	accessToken := h.storer.Tokens[hashToken(data[DataAccessToken].(string))]
+	if refreshToken.Kind != authboss.TokenKindRefresh || accessToken.Kind != authboss.TokenKindAccess {
+		t.Error("token kinds were wrong")
+	}
+	if refreshToken.PID != user.Email || len(refreshToken.Family) == 0 || refreshToken.Family != accessToken.Family {
+		t.Errorf("tokens should be in one family for the user: %#v %#v", refreshToken, accessToken)
+	}
+
+	handled, err := h.token.IssueAfterAuth(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil), true)
+	if err != nil || handled {
+		t.Error("it should leave already handled requests alone")
+	}
+}
+
+func TestIssueAfterAuthJWT(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	secret := []byte("secret")
+	h.ab.Config.Modules.TokenSigner = jwt.HMACSigner{Key: secret}
+	h.ab.Config.Modules.TokenVerifyKey = secret
+
+	data := login(t, h, &mocks.User{Email: "test@test.com"})
+
+	if len(h.storer.Tokens) != 1 {
+		t.Error("only the refresh token should be stored:", h.storer.Tokens)
+	}
+
+	_, claims, err := jwt.Parse(data[DataAccessToken].(string), jwt.StaticKey(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject() != "test@test.com" || claims.Issuer() != h.ab.Config.Paths.RootURL {
+		t.Errorf("claims were wrong: %#v", claims)
+	}
+}
+
+func TestRefreshPost(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	first := login(t, h, user)
+	family := h.storer.Tokens[hashToken(first[DataRefreshToken].(string))].Family
+	h.storer.Sessions[user.Email] = []authboss.SessionRecord{{ID: "thief"}}
+
+	refresh(t, h, first[DataRefreshToken].(string))
+
+	if h.responder.Status != http.StatusOK || h.responder.Page != PageToken {
+		t.Fatalf("response was wrong: %d %s", h.responder.Status, h.responder.Page)
+	}
+
+	second := h.responder.Data[DataRefreshToken].(string)
+	if second == first[DataRefreshToken] {
+		t.Error("the refresh token should have been rotated")
+	}
+	if issued := h.storer.Tokens[hashToken(second)]; issued.Family != family {
+		t.Error("the new tokens should be in the same family:", issued.Family)
+	}
+	if !h.storer.UsedTokens[hashToken(first[DataRefreshToken].(string))] {
+		t.Error("the refresh token should have been used up")
+	}
+}
+
+func TestRefreshPostInvalid(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	data := login(t, h, user)
+
+	expired := "expired"
+	h.storer.Tokens[hashToken(expired)] = authboss.IssuedToken{
+		Hash: hashToken(expired), Kind: authboss.TokenKindRefresh, PID: user.Email, Expires: time.Now().Add(-time.Minute),
+	}
+
+	for _, token := range []string{"notfound", expired, data[DataAccessToken].(string)} {
+		refresh(t, h, token)
+
+		if h.responder.Status != http.StatusUnauthorized {
+			t.Errorf("%s: status was wrong: %d", token, h.responder.Status)
+		}
+		if _, ok := h.responder.Data[authboss.DataErr]; !ok {
+			t.Errorf("%s: there should be an error", token)
+		}
+	}
+
+	if h.storer.UsedTokens[hashToken(data[DataAccessToken].(string))] {
+		t.Error("the access token should not be used up by refreshing with it")
+	}
+}
+
+func TestRefreshPostReuse(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	var reuse ReuseValues
+	h.ab.Events.After(authboss.EventTokenReuse, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		reuse = r.Context().Value(authboss.CTXKeyValues).(ReuseValues)
+		return false, nil
+	})
+
+	first := login(t, h, user)
+	family := h.storer.Tokens[hashToken(first[DataRefreshToken].(string))].Family
+	h.storer.Sessions[user.Email] = []authboss.SessionRecord{{ID: "thief"}}
+
+	refresh(t, h, first[DataRefreshToken].(string))
+	refresh(t, h, first[DataRefreshToken].(string))
+
+	if h.responder.Status != http.StatusUnauthorized {
+		t.Error("status was wrong:", h.responder.Status)
+	}
+	if reuse.PID != user.Email || reuse.Family != family {
+		t.Errorf("event values were wrong: %#v", reuse)
+	}
+	if len(h.storer.Tokens) != 0 {
+		t.Error("the token family should have been revoked:", h.storer.Tokens)
+	}
+	if sessions := h.storer.Sessions[user.Email]; len(sessions) != 0 {
+		t.Error("the user's sessions should have been revoked:", sessions)
+	}
+}
+
+func TestRefreshPostLockedOrSuspended(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	for name, bar := range map[string]func(){
+		"locked":    func() { user.Locked = time.Now().Add(time.Hour) },
+		"suspended": func() { user.Locked, user.Suspended = time.Time{}, true },
+	} {
+		user.Locked, user.Suspended = time.Time{}, false
+		data := login(t, h, user)
+		issued := len(h.storer.Tokens)
+
+		bar()
+		refresh(t, h, data[DataRefreshToken].(string))
+
+		if h.responder.Status != http.StatusUnauthorized {
+			t.Errorf("%s: status was wrong: %d", name, h.responder.Status)
+		}
+		if len(h.storer.Tokens) != issued {
+			t.Errorf("%s: no tokens should have been issued", name)
+		}
+	}
+}
+
+func TestRevokePost(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+
+	other := login(t, h, user)
+	data := login(t, h, user)
+
+	h.bodyReader.Return = mocks.Values{Token: data[DataAccessToken].(string)}
+	if err := h.token.RevokePost(httptest.NewRecorder(), httptest.NewRequest("POST", "/token/revoke", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusOK {
+		t.Error("status was wrong:", h.responder.Status)
+	}
+	if _, ok := h.storer.Tokens[hashToken(data[DataRefreshToken].(string))]; ok {
+		t.Error("the refresh token should have been revoked along with the access token")
+	}
+	if _, ok := h.storer.Tokens[hashToken(other[DataRefreshToken].(string))]; !ok {
+		t.Error("tokens from other logins should not be revoked")
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: "notfound"}
+	if err := h.token.RevokePost(httptest.NewRecorder(), httptest.NewRequest("POST", "/token/revoke", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if h.responder.Status != http.StatusOK {
+		t.Error("unknown tokens should still succeed:", h.responder.Status)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	data := login(t, h, user)
+
+	expired := "expired"
+	h.storer.Tokens[hashToken(expired)] = authboss.IssuedToken{
+		Hash: hashToken(expired), Kind: authboss.TokenKindAccess, PID: user.Email, Expires: time.Now().Add(-time.Minute),
+	}
+
+	var loaded authboss.User
+	handler := Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loaded, _ = h.ab.CurrentUser(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(header string) *httptest.ResponseRecorder {
+		loaded = nil
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(header) != 0 {
+			r.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := serve("Bearer " + data[DataAccessToken].(string)); rec.Code != http.StatusOK || loaded != user {
+		t.Error("the user should have been loaded:", rec.Code, loaded)
+	}
+	if rec := serve(""); rec.Code != http.StatusOK || loaded != nil {
+		t.Error("requests without a token should pass through:", rec.Code)
+	}
+
+	for _, token := range []string{"notfound", expired, data[DataRefreshToken].(string)} {
+		rec := serve("Bearer " + token)
+		if rec.Code != http.StatusUnauthorized || loaded != nil {
+			t.Errorf("%s: it should be rejected: %d", token, rec.Code)
+		}
+		if len(rec.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("%s: it should set WWW-Authenticate", token)
+		}
+	}
+	user.Suspended = true
+	if rec := serve("Bearer " + data[DataAccessToken].(string)); rec.Code != http.StatusUnauthorized || loaded != nil {
+		t.Error("suspended users' tokens should be rejected:", rec.Code)
+	}
+}
+
+func TestMiddlewareJWT(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	secret := []byte("secret")
+	h.ab.Config.Modules.TokenSigner = jwt.HMACSigner{Key: secret}
+	h.ab.Config.Modules.TokenVerifyKey = secret
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	data := login(t, h, user)
+
+	other, err := jwt.Sign(jwt.Claims{
+		"iss": "https://someone.else", "aud": h.ab.Config.Paths.RootURL, "sub": user.Email,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}, jwt.HMACSigner{Key: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Middleware(h.ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := h.ab.CurrentUser(r); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	for token, code := range map[string]int{data[DataAccessToken].(string): http.StatusOK, other: http.StatusUnauthorized} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != code {
+			t.Errorf("status was wrong, want %d, got %d", code, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package authboss
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Purposes of the tokens a TokenGenerator creates
+const (
+	TokenConfirm  = "confirm"
+	TokenRecover  = "recover"
+	TokenRemember = "remember"
+)
+
+// ErrMalformedToken is returned by TokenGenerators for tokens they didn't
+// create
+var ErrMalformedToken = errors.New("token is malformed")
+
+// TokenGenerator creates the tokens that are e-mailed to users by the
+// confirm and recover modules and put in the remember module's cookie, so
+// their entropy, encoding and how they're stored can meet other
+// requirements than the defaults. See DefaultTokenGenerator.
+//
+// A confirm or recover token is split into a selector, which the user is
+// looked up by, and a verifier that's compared with the stored one in
+// constant time, so the lookup can't be used to time a guess. Remember
+// tokens are stored by their hash (see Modules.RememberTokenKey) so only
+// their secret and encoding come from the generator.
+type TokenGenerator interface {
+	// NewToken creates the random secret of a token for the purpose
+	// (TokenConfirm, TokenRecover or TokenRemember)
+	NewToken(ctx context.Context, purpose string) ([]byte, error)
+	// Encode a token for the user and Decode what they send back
+	Encode(token []byte) string
+	Decode(token string) ([]byte, error)
+	// Split derives the selector and verifier that are stored for a
+	// token, it returns ErrMalformedToken if the token can't be one of
+	// the generator's.
+	Split(ctx context.Context, purpose string, token []byte) (selector, verifier string, err error)
+}
+
+// DefaultTokenGenerator is the TokenGenerator that's used when
+// Config.Core.TokenGenerator isn't set. Tokens are random bytes that are
+// base64 url encoded, the selector is the SHA512 of their first half
+// (scoped to the tenant, see TenantToken) and the verifier the SHA512 of
+// the second half.
+type DefaultTokenGenerator struct {
+	// Size is how many random bytes tokens have, 64 if it's 0
+	Size int
+	// Key if set makes the selectors and verifiers HMAC-SHA512s with it,
+	// so they can't be matched to tokens without the key
+	Key []byte
+}
+
+// NewToken creates Size random bytes
+func (d DefaultTokenGenerator) NewToken(ctx context.Context, purpose string) ([]byte, error) {
+	token := make([]byte, d.size())
+	if _, err := io.ReadFull(rand.Reader, token); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s token", purpose)
+	}
+	return token, nil
+}
+
+// Encode with base64 url encoding
+func (d DefaultTokenGenerator) Encode(token []byte) string {
+	return base64.URLEncoding.EncodeToString(token)
+}
+
+// Decode with base64 url encoding
+func (d DefaultTokenGenerator) Decode(token string) ([]byte, error) {
+	return base64.URLEncoding.DecodeString(token)
+}
+
+// Split hashes the halves of the token
+func (d DefaultTokenGenerator) Split(ctx context.Context, purpose string, token []byte) (selector, verifier string, err error) {
+	if len(token) != d.size() {
+		return "", "", ErrMalformedToken
+	}
+
+	half := len(token) / 2
+	return d.hash(TenantToken(ctx, token[:half])), d.hash(token[half:]), nil
+}
+
+func (d DefaultTokenGenerator) size() int {
+	if d.Size == 0 {
+		return 64
+	}
+	return d.Size
+}
+
+func (d DefaultTokenGenerator) hash(b []byte) string {
+	if len(d.Key) == 0 {
+		sum := sha512.Sum512(b)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha512.New, d.Key)
+	_, _ = mac.Write(b)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TokenGenerator is Config.Core.TokenGenerator, or the
+// DefaultTokenGenerator if it's not set
+func (a *Authboss) TokenGenerator() TokenGenerator {
+	if a.Config.Core.TokenGenerator == nil {
+		return DefaultTokenGenerator{}
+	}
+	return a.Config.Core.TokenGenerator
+}
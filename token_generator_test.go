@@ -0,0 +1,64 @@
+package authboss
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDefaultTokenGenerator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tokens := DefaultTokenGenerator{}
+
+	token, err := tokens.NewToken(ctx, TokenConfirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 {
+		t.Error("token length wrong:", len(token))
+	}
+
+	decoded, err := tokens.Decode(tokens.Encode(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != string(token) {
+		t.Error("the token should survive encoding")
+	}
+
+	selector, verifier, err := tokens.Split(ctx, TokenConfirm, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selector == verifier {
+		t.Error("the selector and verifier should be of different halves")
+	}
+
+	keyed := DefaultTokenGenerator{Key: []byte("key")}
+	keyedSelector, keyedVerifier, err := keyed.Split(ctx, TokenConfirm, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyedSelector == selector || keyedVerifier == verifier {
+		t.Error("the key should change the selector and verifier")
+	}
+
+	if _, _, err = tokens.Split(ctx, TokenConfirm, token[:32]); err != ErrMalformedToken {
+		t.Error("short tokens should be malformed:", err)
+	}
+}
+
+func TestAuthbossTokenGenerator(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	if _, ok := ab.TokenGenerator().(DefaultTokenGenerator); !ok {
+		t.Error("it should default to the DefaultTokenGenerator")
+	}
+
+	ab.Config.Core.TokenGenerator = DefaultTokenGenerator{Size: 32}
+	if tokens := ab.TokenGenerator().(DefaultTokenGenerator); tokens.Size != 32 {
+		t.Error("it should be the configured generator")
+	}
+}
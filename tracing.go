@@ -0,0 +1,107 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+)
+
+// Span attributes set by authboss
+const (
+	// SpanModule is the module whose handler the span is for
+	SpanModule = "authboss.module"
+	// SpanEvent is the event whose handlers the span is for
+	SpanEvent = "authboss.event"
+	// SpanMethod is the request's http method
+	SpanMethod = "http.method"
+	// SpanPath is the request's path
+	SpanPath = "http.target"
+)
+
+// Tracer starts spans so that deployments can see where the time goes in
+// authboss: a span is started for each handler, event, ServerStorer Load
+// and Save, and Mailer send. It's meant to be implemented with an adapter
+// to OpenTelemetry or a similar library, see Config.Core.Tracer.
+type Tracer interface {
+	// Start a span that's a child of the span in ctx, if any. The returned
+	// context carries the new span.
+	Start(ctx context.Context, name string, attributes Labels) (context.Context, Span)
+}
+
+// Span is a unit of work started by a Tracer
+type Span interface {
+	// End the span, err is what the work failed with or nil
+	End(err error)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+// StartSpan starts a span with Config.Core.Tracer, without one it does
+// nothing and returns ctx.
+func (a *Authboss) StartSpan(ctx context.Context, name string, attributes Labels) (context.Context, Span) {
+	if a.Config.Core.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return a.Config.Core.Tracer.Start(ctx, name, attributes)
+}
+
+// LoadUser loads the user from the ServerStorer in a span
+func (a *Authboss) LoadUser(ctx context.Context, key string) (User, error) {
+	ctx, span := a.StartSpan(ctx, "authboss.storer.Load", nil)
+	user, err := a.Storer(ctx).Load(ctx, key)
+	if err == ErrUserNotFound {
+		span.End(nil)
+	} else {
+		span.End(err)
+	}
+	return user, err
+}
+
+// SaveUser saves the user with the ServerStorer in a span
+func (a *Authboss) SaveUser(ctx context.Context, user User) error {
+	ctx, span := a.StartSpan(ctx, "authboss.storer.Save", nil)
+	err := a.Storer(ctx).Save(ctx, user)
+	span.End(err)
+
+	if cache, ok := ctx.Value(ctxKeyUserCache).(*userCache); ok {
+		cache.forget(user.GetPID())
+	}
+	return err
+}
+
+// moduleErrorHandler puts the module's name and the locale in the context,
+// starts a span for each of a module's handlers and recovers their panics
+// (see PanicError), it's swapped in for the ErrorHandler while the module
+// is initialized.
+type moduleErrorHandler struct {
+	ErrorHandler
+
+	ab     *Authboss
+	module string
+}
+
+func (m moduleErrorHandler) Wrap(handler func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return m.ErrorHandler.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		ctx := context.WithValue(m.ab.LocaleContext(r), CTXKeyModule, m.module)
+		ctx, span := m.ab.StartSpan(ctx, "authboss."+m.module+" "+r.Method+" "+r.URL.Path, Labels{
+			SpanModule: m.module,
+			SpanMethod: r.Method,
+			SpanPath:   r.URL.Path,
+		})
+		err := m.ab.recoverPanics(m.module, handler)(w, r.WithContext(ctx))
+		span.End(err)
+		return err
+	})
+}
+
+// traceEvents is the EventMiddleware that starts a span for each
+// event handler
+func (a *Authboss) traceEvents(e Event, next EventHandler) EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		ctx, span := a.StartSpan(r.Context(), "authboss."+e.String(), Labels{SpanEvent: e.String()})
+		handled, err := next(w, r.WithContext(ctx), handled)
+		span.End(err)
+		return handled, err
+	}
+}
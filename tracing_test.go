@@ -0,0 +1,147 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/friendsofgo/errors"
+)
+
+type mockSpan struct {
+	name       string
+	attributes Labels
+	ended      bool
+	err        error
+}
+
+func (m *mockSpan) End(err error) {
+	m.ended = true
+	m.err = err
+}
+
+type mockTracer struct {
+	spans []*mockSpan
+}
+
+func (m *mockTracer) Start(ctx context.Context, name string, attributes Labels) (context.Context, Span) {
+	span := &mockSpan{name: name, attributes: attributes}
+	m.spans = append(m.spans, span)
+	return ctx, span
+}
+
+type mockErrorHandler struct{}
+
+func (mockErrorHandler) Wrap(handler func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := handler(w, r); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
+
+func TestTracingStorer(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	tracer := &mockTracer{}
+	storer := newMockServerStorer()
+	storer.Users["test@test.com"] = &mockUser{Email: "test@test.com"}
+	ab.Config.Storage.Server = storer
+
+	// Nothing is traced without a tracer
+	if _, err := ab.LoadUser(context.Background(), "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	ab.Config.Core.Tracer = tracer
+
+	user, err := ab.LoadUser(context.Background(), "test@test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ab.SaveUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ab.LoadUser(context.Background(), "nobody"); err != ErrUserNotFound {
+		t.Fatal("wrong error:", err)
+	}
+
+	names := []string{"authboss.storer.Load", "authboss.storer.Save", "authboss.storer.Load"}
+	if len(tracer.spans) != len(names) {
+		t.Fatalf("wrong number of spans: %d", len(tracer.spans))
+	}
+	for i, span := range tracer.spans {
+		if span.name != names[i] || !span.ended {
+			t.Errorf("span %d was wrong: %#v", i, span)
+		}
+		if span.err != nil {
+			t.Errorf("span %d should not have failed, users not being found is normal: %v", i, span.err)
+		}
+	}
+}
+
+func TestTracingHandlers(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	tracer := &mockTracer{}
+	ab.Config.Core.Tracer = tracer
+
+	var module interface{}
+	handler := moduleErrorHandler{ErrorHandler: mockErrorHandler{}, ab: ab, module: "auth"}.Wrap(
+		func(w http.ResponseWriter, r *http.Request) error {
+			module = r.Context().Value(CTXKeyModule)
+			return errors.New("failed")
+		},
+	)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/auth/login", nil))
+
+	if module != "auth" {
+		t.Error("the module should be in the context:", module)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("wrong number of spans: %d", len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != "authboss.auth POST /auth/login" {
+		t.Error("name was wrong:", span.name)
+	}
+	if span.attributes[SpanModule] != "auth" || span.attributes[SpanMethod] != "POST" {
+		t.Error("attributes were wrong:", span.attributes)
+	}
+	if span.err == nil || span.err.Error() != "failed" {
+		t.Error("the handler's error should be recorded:", span.err)
+	}
+}
+
+func TestTracingEvents(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	tracer := &mockTracer{}
+	ab.Config.Core.Tracer = tracer
+	ab.Config.Core.ErrorHandler = mockErrorHandler{}
+
+	if err := ab.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ab.Config.Core.ErrorHandler.(mockErrorHandler); !ok {
+		t.Errorf("the error handler should be restored after init: %T", ab.Config.Core.ErrorHandler)
+	}
+
+	ab.Events.After(EventAuth, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		return false, nil
+	})
+	if _, err := ab.Events.FireAfter(EventAuth, httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("wrong number of spans: %d", len(tracer.spans))
+	}
+	if span := tracer.spans[0]; span.name != "authboss.EventAuth" || span.attributes[SpanEvent] != "EventAuth" {
+		t.Errorf("span was wrong: %#v", span)
+	}
+}
@@ -0,0 +1,60 @@
+package authboss
+
+import (
+	"context"
+
+	"github.com/friendsofgo/errors"
+)
+
+// Storer is the ServerStorer to use with ctx: the transaction's storer when
+// ctx is in one (see InTx) or Config.Storage.Server.
+func (a *Authboss) Storer(ctx context.Context) ServerStorer {
+	if tx, ok := ctx.Value(CTXKeyTx).(TxStorer); ok {
+		return tx
+	}
+	return a.Config.Storage.Server
+}
+
+// InTx calls fn in a transaction when Config.Storage.Server is a
+// TxServerStorer. The context fn is given carries the transaction, so what
+// it loads and saves with Storer (and LoadUser, SaveUser...) is part of it.
+// The transaction is committed if fn returns nil and rolled back if it
+// fails or panics. Without a TxServerStorer, or when ctx already is in a
+// transaction, fn is simply called with ctx.
+//
+// Responses written by fn can't be taken back, so fn should only respond
+// once the writes that can fail are done.
+func (a *Authboss) InTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(CTXKeyTx).(TxStorer); ok {
+		return fn(ctx)
+	}
+
+	txStorer, ok := a.Config.Storage.Server.(TxServerStorer)
+	if !ok {
+		return fn(ctx)
+	}
+
+	tx, err := txStorer.BeginTx(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, CTXKeyTx, tx)); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			a.Logger(ctx).Errorf("failed to roll back transaction: %+v", rbErr)
+		}
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package authboss
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type mockTxServerStorer struct {
+	*mockServerStorer
+
+	Began     int
+	Commits   int
+	Rollbacks int
+}
+
+type mockTxStorer struct {
+	*mockServerStorer
+
+	parent *mockTxServerStorer
+}
+
+func (m *mockTxServerStorer) BeginTx(ctx context.Context) (TxStorer, error) {
+	m.Began++
+	return mockTxStorer{mockServerStorer: m.mockServerStorer, parent: m}, nil
+}
+
+func (m mockTxStorer) Commit(ctx context.Context) error {
+	m.parent.Commits++
+	return nil
+}
+
+func (m mockTxStorer) Rollback(ctx context.Context) error {
+	m.parent.Rollbacks++
+	return nil
+}
+
+func TestInTx(t *testing.T) {
+	t.Parallel()
+
+	storer := &mockTxServerStorer{mockServerStorer: newMockServerStorer()}
+	ab := New()
+	ab.Config.Storage.Server = storer
+
+	ctx := context.Background()
+	if ab.Storer(ctx) != storer {
+		t.Error("outside of a transaction it should be the storer")
+	}
+
+	err := ab.InTx(ctx, func(ctx context.Context) error {
+		if _, ok := ab.Storer(ctx).(mockTxStorer); !ok {
+			t.Error("inside of a transaction it should be the transaction")
+		}
+
+		return ab.InTx(ctx, func(ctx context.Context) error { return nil })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storer.Began != 1 || storer.Commits != 1 || storer.Rollbacks != 0 {
+		t.Error("nested calls should join the transaction:", storer.Began, storer.Commits, storer.Rollbacks)
+	}
+
+	failure := errors.New("failure")
+	if err := ab.InTx(ctx, func(context.Context) error { return failure }); err != failure {
+		t.Error("the error should be returned:", err)
+	}
+	if storer.Commits != 1 || storer.Rollbacks != 1 {
+		t.Error("it should have rolled back:", storer.Commits, storer.Rollbacks)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the panic should be re-raised")
+			}
+		}()
+		_ = ab.InTx(ctx, func(context.Context) error { panic("failure") })
+	}()
+	if storer.Commits != 1 || storer.Rollbacks != 2 {
+		t.Error("it should have rolled back:", storer.Commits, storer.Rollbacks)
+	}
+}
+
+func TestInTxWithoutTransactions(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Storage.Server = newMockServerStorer()
+
+	called := false
+	err := ab.InTx(context.Background(), func(ctx context.Context) error {
+		called = true
+		if ab.Storer(ctx) != ab.Config.Storage.Server {
+			t.Error("it should be the storer")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Error("fn should have been called")
+	}
+}
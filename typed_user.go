@@ -0,0 +1,86 @@
+//go:build go1.18
+// +build go1.18
+
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/friendsofgo/errors"
+)
+
+// UserAs converts user to the application's user type (or one of the
+// user interfaces). Unlike a type assertion it returns an error naming
+// both types when user isn't a T.
+func UserAs[T User](user User) (T, error) {
+	typed, ok := user.(T)
+	if !ok {
+		return typed, errors.Errorf("user is a %T, not a %s", user, reflect.TypeOf((*T)(nil)).Elem())
+	}
+
+	return typed, nil
+}
+
+// CurrentUserAs returns the user put in the request's context by
+// Middleware2 (or LoadCurrentUser) or loaded by LoadCurrentUserMiddleware
+// as a T. ErrUserNotFound is returned when there's no such user.
+//
+//	user, err := authboss.CurrentUserAs[*User](r)
+func CurrentUserAs[T User](r *http.Request) (T, error) {
+	user, ok := r.Context().Value(CTXKeyUser).(User)
+	if !ok {
+		user, ok = cachedUser(r)
+	}
+	if !ok {
+		var zero T
+		return zero, ErrUserNotFound
+	}
+
+	return UserAs[T](user)
+}
+
+// LoadCurrentUserAs is LoadCurrentUser returning a T
+func LoadCurrentUserAs[T User](ab *Authboss, r **http.Request) (T, error) {
+	user, err := ab.LoadCurrentUser(r)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return UserAs[T](user)
+}
+
+// LoadUserAs is Authboss.LoadUser returning a T
+func LoadUserAs[T User](ab *Authboss, ctx context.Context, pid string) (T, error) {
+	user, err := ab.LoadUser(ctx, pid)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return UserAs[T](user)
+}
+
+// WithUser adapts an event handler that wants the event's user as a T,
+// the user the module put in the request's context (CTXKeyUser). The
+// handler isn't called for events without a user, and an error is
+// returned if the user isn't a T.
+//
+//	ab.Events.After(authboss.EventRegister, authboss.WithUser(
+//		func(w http.ResponseWriter, r *http.Request, user *User, handled bool) (bool, error) {
+//			return false, welcome(user.Email)
+//		}))
+func WithUser[T User](fn func(w http.ResponseWriter, r *http.Request, user T, handled bool) (bool, error)) EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		user, err := CurrentUserAs[T](r)
+		if err == ErrUserNotFound {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		return fn(w, r, user, handled)
+	}
+}
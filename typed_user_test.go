@@ -0,0 +1,100 @@
+//go:build go1.18
+// +build go1.18
+
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type otherUser struct{ mockUser }
+
+func TestCurrentUserAs(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := CurrentUserAs[*mockUser](r); err != ErrUserNotFound {
+		t.Error("expected ErrUserNotFound:", err)
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyUser, &mockUser{Email: "test@test.com"}))
+	user, err := CurrentUserAs[*mockUser](r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "test@test.com" {
+		t.Error("user was wrong:", user.Email)
+	}
+
+	if _, err := CurrentUserAs[ConfirmableUser](r); err != nil {
+		t.Error("the user should be confirmable:", err)
+	}
+
+	_, err = CurrentUserAs[*otherUser](r)
+	if err == nil || !strings.Contains(err.Error(), "user is a *authboss.mockUser, not a *authboss.otherUser") {
+		t.Error("error was wrong:", err)
+	}
+}
+
+func TestLoadCurrentUserAs(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	storer := newMockServerStorer()
+	storer.Users["test@test.com"] = &mockUser{Email: "test@test.com"}
+	ab.Config.Storage.Server = storer
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyPID, "test@test.com"))
+
+	user, err := LoadCurrentUserAs[*mockUser](ab, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "test@test.com" {
+		t.Error("user was wrong:", user.Email)
+	}
+	if _, ok := r.Context().Value(CTXKeyUser).(*mockUser); !ok {
+		t.Error("the user should be in the context")
+	}
+
+	if _, err := LoadUserAs[*mockUser](ab, context.Background(), "nope@test.com"); err != ErrUserNotFound {
+		t.Error("expected ErrUserNotFound:", err)
+	}
+}
+
+func TestWithUser(t *testing.T) {
+	t.Parallel()
+
+	var got *mockUser
+	handler := WithUser(func(w http.ResponseWriter, r *http.Request, user *mockUser, handled bool) (bool, error) {
+		got = user
+		return true, nil
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if handled, err := handler(w, r, false); err != nil || handled {
+		t.Error("events without a user should be skipped:", handled, err)
+	}
+
+	user := &mockUser{Email: "test@test.com"}
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyUser, user))
+	if handled, err := handler(w, r, false); err != nil || !handled {
+		t.Error("the handler should have been called:", handled, err)
+	}
+	if got != user {
+		t.Error("the handler should have been given the user")
+	}
+
+	other := WithUser(func(w http.ResponseWriter, r *http.Request, user *otherUser, handled bool) (bool, error) {
+		return true, nil
+	})
+	if _, err := other(w, r, false); err == nil {
+		t.Error("a user of another type should be an error")
+	}
+}
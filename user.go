@@ -1,7 +1,9 @@
 package authboss
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -45,6 +47,15 @@ type ConfirmableUser interface {
 	PutConfirmVerifier(verifier string)
 }
 
+// ExpiringConfirmableUser is a ConfirmableUser whose confirm tokens
+// expire, see Config.Modules.ConfirmTokenDuration
+type ExpiringConfirmableUser interface {
+	ConfirmableUser
+
+	GetConfirmExpiry() (expiry time.Time)
+	PutConfirmExpiry(expiry time.Time)
+}
+
 // LockableUser is a user that can be locked
 type LockableUser interface {
 	User
@@ -58,6 +69,30 @@ type LockableUser interface {
 	PutLocked(locked time.Time)
 }
 
+// SuspendableUser is a user an admin can suspend (ban), see
+// Authboss.Suspend and the suspend module. Unlike the lock of a
+// LockableUser, which is set by failed logins, it's only set and lifted by
+// the app. A suspension with a zero until lasts until it's lifted.
+type SuspendableUser interface {
+	User
+
+	GetSuspended() (suspended bool)
+	GetSuspendReason() (reason string)
+	GetSuspendedUntil() (until time.Time)
+
+	PutSuspended(suspended bool)
+	PutSuspendReason(reason string)
+	PutSuspendedUntil(until time.Time)
+}
+
+// UnlockableUser is a LockableUser that can be e-mailed a link to unlock
+// their account, see Config.Modules.LockUnlockKey
+type UnlockableUser interface {
+	LockableUser
+
+	GetEmail() (email string)
+}
+
 // RecoverableUser is a user that can be recovered via e-mail
 type RecoverableUser interface {
 	AuthableUser
@@ -73,9 +108,34 @@ type RecoverableUser interface {
 	PutRecoverExpiry(expiry time.Time)
 }
 
+// BackupEmailUser has a second e-mail address, verified by the backupemail
+// module, that recover and unlock e-mails can be sent to when the user
+// can't get to their primary mailbox or it was taken over.
+type BackupEmailUser interface {
+	User
+
+	GetBackupEmail() (email string)
+	PutBackupEmail(email string)
+}
+
+// RenamableUser can change their username with the rename module, or
+// their pid if Config.Modules.RenamePID is set. The time of their last
+// change is kept for Config.Modules.RenameCooldown.
+type RenamableUser interface {
+	User
+
+	GetUsername() (username string)
+	GetLastRename() (last time.Time)
+
+	PutUsername(username string)
+	PutLastRename(last time.Time)
+}
+
 // ArbitraryUser allows arbitrary data from the web form through. You should
 // definitely only pull the keys you want from the map, since this is unfiltered
-// input from a web request and is an attack vector.
+// input from a web request and is an attack vector. ExtraFieldsUser is given
+// only the fields declared in Config.Modules.RegisterExtraFields, parsed and
+// validated, instead.
 type ArbitraryUser interface {
 	User
 
@@ -87,6 +147,62 @@ type ArbitraryUser interface {
 	PutArbitrary(arbitrary map[string]string)
 }
 
+// ExtraFieldsUser is given the extra fields of the register form declared
+// in Config.Modules.RegisterExtraFields when it's created
+type ExtraFieldsUser interface {
+	User
+
+	PutExtraFields(fields ExtraFields)
+}
+
+// AccountTypeUser has an account type (eg. buyer or seller) that's chosen
+// when registering, see Config.Modules.AccountTypes
+type AccountTypeUser interface {
+	User
+
+	GetAccountType() (accountType string)
+	PutAccountType(accountType string)
+}
+
+// RolesUser has roles (eg. admin or editor) for RequireRoles and
+// RequirePermissions
+type RolesUser interface {
+	User
+
+	GetRoles() (roles []string)
+}
+
+// AccountType of the user, or the empty string when the user isn't an
+// AccountTypeUser. Policy funcs in the config can use it to treat users
+// differently depending on their type.
+func AccountType(user User) string {
+	if au, ok := user.(AccountTypeUser); ok {
+		return au.GetAccountType()
+	}
+	return ""
+}
+
+// AccountTypePath is where the user should be redirected instead of path
+// according to Config.Paths.AccountTypeOK, or path if their account type
+// isn't in it.
+func (a *Authboss) AccountTypePath(user User, path string) string {
+	if p, ok := a.Config.Paths.AccountTypeOK[AccountType(user)]; ok {
+		return p
+	}
+	return path
+}
+
+// UserChanged fires EventUserChange, call it after saving changes to the
+// current user's data with the attributes that changed (see AttributeEmail)
+// so the modules can react to them, like the confirm module does for
+// Config.Modules.ReconfirmAttributes. When it's handled the response was
+// written and the caller shouldn't write another.
+func (a *Authboss) UserChanged(w http.ResponseWriter, r *http.Request, user User, attributes ...string) (handled bool, err error) {
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyUser, user))
+	r = r.WithContext(context.WithValue(r.Context(), CTXKeyChangedAttributes, attributes))
+	return a.Events.FireAfter(EventUserChange, w, r)
+}
+
 // OAuth2User allows reading and writing values relating to OAuth2
 // Also see MakeOAuthPID/ParseOAuthPID for helpers to fulfill the User
 // part of the interface.
@@ -110,6 +226,43 @@ type OAuth2User interface {
 	PutOAuth2Expiry(expiry time.Time)
 }
 
+// OAuth2ProfileUser is given the profile fetched from the provider with
+// OAuth2Provider.FindProfile each time the user logs in with it, before the
+// user is saved.
+type OAuth2ProfileUser interface {
+	User
+
+	PutOAuth2Profile(provider string, profile OAuth2Profile)
+}
+
+// OAuth2Identity is a user's account with an oauth2 provider along with
+// the tokens last received for it.
+type OAuth2Identity struct {
+	Provider     string
+	UID          string
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// OAuth2LinkableUser is a user that can have identities from several
+// oauth2 providers (eg. Google, GitHub and Apple) linked to it rather than
+// the single uid/provider pair of an OAuth2User. They can then log in with
+// any of those providers.
+type OAuth2LinkableUser interface {
+	User
+
+	// GetOAuth2Identities returns all the linked identities, there is at
+	// most one per provider.
+	GetOAuth2Identities() (identities []OAuth2Identity)
+
+	// PutOAuth2Identity adds the identity, replacing any existing identity
+	// for the same provider.
+	PutOAuth2Identity(identity OAuth2Identity)
+	// DelOAuth2Identity removes the identity for the provider.
+	DelOAuth2Identity(provider string)
+}
+
 // MustBeAuthable forces an upgrade to an AuthableUser or panic.
 func MustBeAuthable(u User) AuthableUser {
 	if au, ok := u.(AuthableUser); ok {
@@ -118,6 +271,14 @@ func MustBeAuthable(u User) AuthableUser {
 	panic(fmt.Sprintf("could not upgrade user to an authable user, type: %T", u))
 }
 
+// MustHaveAccountType forces an upgrade to an AccountTypeUser or panic.
+func MustHaveAccountType(u User) AccountTypeUser {
+	if au, ok := u.(AccountTypeUser); ok {
+		return au
+	}
+	panic(fmt.Sprintf("could not upgrade user to an account type user, given type: %T", u))
+}
+
 // MustBeConfirmable forces an upgrade to a ConfirmableUser or panic.
 func MustBeConfirmable(u User) ConfirmableUser {
 	if cu, ok := u.(ConfirmableUser); ok {
@@ -126,6 +287,15 @@ func MustBeConfirmable(u User) ConfirmableUser {
 	panic(fmt.Sprintf("could not upgrade user to a confirmable user, type: %T", u))
 }
 
+// MustBeExpiringConfirmable forces an upgrade to an ExpiringConfirmableUser
+// or panic.
+func MustBeExpiringConfirmable(u User) ExpiringConfirmableUser {
+	if cu, ok := u.(ExpiringConfirmableUser); ok {
+		return cu
+	}
+	panic(fmt.Sprintf("could not upgrade user to an expiring confirmable user, type: %T", u))
+}
+
 // MustBeLockable forces an upgrade to a LockableUser or panic.
 func MustBeLockable(u User) LockableUser {
 	if lu, ok := u.(LockableUser); ok {
@@ -134,6 +304,22 @@ func MustBeLockable(u User) LockableUser {
 	panic(fmt.Sprintf("could not upgrade user to a lockable user, given type: %T", u))
 }
 
+// MustBeSuspendable forces an upgrade to a SuspendableUser or panic.
+func MustBeSuspendable(u User) SuspendableUser {
+	if su, ok := u.(SuspendableUser); ok {
+		return su
+	}
+	panic(fmt.Sprintf("could not upgrade user to a suspendable user, given type: %T", u))
+}
+
+// MustBeUnlockable forces an upgrade to an UnlockableUser or panic.
+func MustBeUnlockable(u User) UnlockableUser {
+	if uu, ok := u.(UnlockableUser); ok {
+		return uu
+	}
+	panic(fmt.Sprintf("could not upgrade user to an unlockable user, given type: %T", u))
+}
+
 // MustBeRecoverable forces an upgrade to a RecoverableUser or panic.
 func MustBeRecoverable(u User) RecoverableUser {
 	if lu, ok := u.(RecoverableUser); ok {
@@ -150,6 +336,48 @@ func MustBeOAuthable(u User) OAuth2User {
 	panic(fmt.Sprintf("could not upgrade user to an oauthable user, given type: %T", u))
 }
 
+// MustBeOAuth2Linkable forces an upgrade to an OAuth2LinkableUser or panic.
+func MustBeOAuth2Linkable(u User) OAuth2LinkableUser {
+	if lu, ok := u.(OAuth2LinkableUser); ok {
+		return lu
+	}
+	panic(fmt.Sprintf("could not upgrade user to an oauth2 linkable user, given type: %T", u))
+}
+
+// GetOAuth2Identity finds the user's linked identity for the provider
+func GetOAuth2Identity(u OAuth2LinkableUser, provider string) (OAuth2Identity, bool) {
+	for _, identity := range u.GetOAuth2Identities() {
+		if identity.Provider == provider {
+			return identity, true
+		}
+	}
+
+	return OAuth2Identity{}, false
+}
+
+// OAuth2Identities enumerates all the oauth2 identities of a user, that is
+// the uid/provider pair of an OAuth2User and the identities linked to an
+// OAuth2LinkableUser.
+func OAuth2Identities(u User) []OAuth2Identity {
+	var identities []OAuth2Identity
+
+	if ou, ok := u.(OAuth2User); ok && ou.IsOAuth2User() {
+		identities = append(identities, OAuth2Identity{
+			Provider:     ou.GetOAuth2Provider(),
+			UID:          ou.GetOAuth2UID(),
+			AccessToken:  ou.GetOAuth2AccessToken(),
+			RefreshToken: ou.GetOAuth2RefreshToken(),
+			Expiry:       ou.GetOAuth2Expiry(),
+		})
+	}
+
+	if lu, ok := u.(OAuth2LinkableUser); ok {
+		identities = append(identities, lu.GetOAuth2Identities()...)
+	}
+
+	return identities
+}
+
 // MakeOAuth2PID is used to create a pid for users that don't have
 // an e-mail address or username in the normal system. This allows
 // all the modules to continue to working as intended without having
@@ -159,6 +387,17 @@ func MakeOAuth2PID(provider, uid string) string {
 	return fmt.Sprintf("oauth2;;%s;;%s", provider, uid)
 }
 
+// UserPID is the pid the user is loaded with (see ServerStorer.Load),
+// oauth2 users are loaded by their oauth2 pid (see MakeOAuth2PID) rather
+// than GetPID.
+func UserPID(user User) string {
+	if ou, ok := user.(OAuth2User); ok && ou.IsOAuth2User() {
+		return MakeOAuth2PID(ou.GetOAuth2Provider(), ou.GetOAuth2UID())
+	}
+
+	return user.GetPID()
+}
+
 // ParseOAuth2PID returns the uid and provider for a given OAuth2 pid
 func ParseOAuth2PID(pid string) (provider, uid string, err error) {
 	splits := strings.Split(pid, ";;")
@@ -1,6 +1,11 @@
 package authboss
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
 
 func TestOAuth2PIDs(t *testing.T) {
 	t.Parallel()
@@ -52,6 +57,17 @@ func TestOAuth2PIDs(t *testing.T) {
 	}
 }
 
+func TestUserPID(t *testing.T) {
+	t.Parallel()
+
+	if pid := UserPID(&mockUser{Email: "test@test.com"}); pid != "test@test.com" {
+		t.Error("pid was wrong:", pid)
+	}
+	if pid := UserPID(&mockUser{Email: "test@test.com", OAuth2Provider: "google", OAuth2UID: "uid"}); pid != "oauth2;;google;;uid" {
+		t.Error("oauth2 users should have their oauth2 pid:", pid)
+	}
+}
+
 type testAssertionFailUser struct{}
 
 func (testAssertionFailUser) GetPID() string { return "" }
@@ -109,3 +125,72 @@ func TestUserAssertions(t *testing.T) {
 		t.Error("should have panic'd")
 	}
 }
+
+type mockLinkableUser struct {
+	mockUser
+	identities []OAuth2Identity
+}
+
+func (m mockLinkableUser) GetOAuth2Identities() []OAuth2Identity { return m.identities }
+func (m *mockLinkableUser) PutOAuth2Identity(identity OAuth2Identity) {
+	m.identities = append(m.identities, identity)
+}
+func (m *mockLinkableUser) DelOAuth2Identity(provider string) {}
+
+func TestOAuth2Identities(t *testing.T) {
+	t.Parallel()
+
+	user := &mockLinkableUser{
+		mockUser: mockUser{OAuth2Provider: "google", OAuth2UID: "1", OAuth2Token: "token"},
+		identities: []OAuth2Identity{
+			{Provider: "github", UID: "2"},
+			{Provider: "apple", UID: "3"},
+		},
+	}
+
+	identities := OAuth2Identities(user)
+	if len(identities) != 3 {
+		t.Fatal("wrong number of identities:", identities)
+	}
+	if identities[0].Provider != "google" || identities[0].UID != "1" || identities[0].AccessToken != "token" {
+		t.Errorf("the oauth2 user's own identity was wrong: %#v", identities[0])
+	}
+
+	if identity, ok := GetOAuth2Identity(user, "apple"); !ok || identity.UID != "3" {
+		t.Error("should have found the apple identity:", identity)
+	}
+	if _, ok := GetOAuth2Identity(user, "facebook"); ok {
+		t.Error("should not have found a facebook identity")
+	}
+
+	if identities := OAuth2Identities(&mockUser{Email: "test@test.com"}); len(identities) != 0 {
+		t.Error("a local user should have no identities:", identities)
+	}
+}
+
+func TestUserChanged(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	user := &mockUser{Email: "new@test.com"}
+
+	var changed []string
+	ab.Events.After(EventUserChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		if r.Context().Value(CTXKeyUser) != user {
+			t.Error("the user should be in the context")
+		}
+		changed = r.Context().Value(CTXKeyChangedAttributes).([]string)
+		return true, nil
+	})
+
+	handled, err := ab.UserChanged(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil), user, AttributeEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled {
+		t.Error("it should have been handled")
+	}
+	if !reflect.DeepEqual(changed, []string{AttributeEmail}) {
+		t.Error("changed attributes were wrong:", changed)
+	}
+}
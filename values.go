@@ -2,6 +2,7 @@ package authboss
 
 import (
 	"fmt"
+	"mime/multipart"
 	"net/http"
 )
 
@@ -64,6 +65,30 @@ type RecoverEndValuer interface {
 	GetToken() string
 }
 
+// BackupEmailValuer provides the backup e-mail address a user entered,
+// see BackupEmailUser.
+type BackupEmailValuer interface {
+	Validator
+
+	GetBackupEmail() string
+}
+
+// BackupEmailChooser lets users have the recover e-mail sent to their
+// backup address (see BackupEmailUser) instead of their primary one.
+type BackupEmailChooser interface {
+	// Intentionally omitting validator
+
+	GetUseBackupEmail() bool
+}
+
+// RenameValuer provides the new name a user wants, it's their username
+// or their pid depending on Config.Modules.RenamePID.
+type RenameValuer interface {
+	Validator
+
+	GetNewName() string
+}
+
 // RememberValuer allows auth/oauth2 to pass along the remember
 // bool from the user to the remember module unobtrusively.
 type RememberValuer interface {
@@ -75,6 +100,67 @@ type RememberValuer interface {
 	GetShouldRemember() bool
 }
 
+// RememberDeviceValuer is an optional upgrade of the RememberValuer that
+// lets the user name the device they're remembered on, see
+// RememberingDeviceServerStorer.
+type RememberDeviceValuer interface {
+	GetRememberDeviceName() string
+}
+
+// RememberInBodyValuer is an optional upgrade of the RememberValuer for
+// clients that can't store cookies (CLIs, IoT devices). When it returns
+// true and Config.Modules.RememberInBody is set the remember token is
+// sent in the response body instead of a cookie.
+type RememberInBodyValuer interface {
+	GetRememberInBody() bool
+}
+
+// ChallengeValuer provides the client's solution to the challenge from
+// the ChallengeVerifier.
+type ChallengeValuer interface {
+	// Intentionally omitting validator
+
+	GetChallengeSolution() string
+}
+
+// RecoverAnswerValuer provides the user's answer to the prompt of the
+// RecoverVerifier on the recover end page.
+type RecoverAnswerValuer interface {
+	// Intentionally omitting validator
+
+	GetRecoverAnswer() string
+}
+
+// OAuth2NativeValuer provides the token a native app got from an oauth2
+// provider's SDK in order to exchange it for a session.
+type OAuth2NativeValuer interface {
+	Validator
+
+	GetIDToken() string
+	GetAccessToken() string
+}
+
+// AccountTypeValuer provides the account type chosen when registering,
+// see Config.Modules.AccountTypes
+type AccountTypeValuer interface {
+	Validator
+
+	GetAccountType() string
+}
+
+// APIKeyValuer provides the name of the api key a user is creating
+type APIKeyValuer interface {
+	Validator
+
+	GetName() string
+}
+
+// FileValuer provides the files uploaded with a multipart/form-data form,
+// by the form field they were uploaded with.
+type FileValuer interface {
+	GetFiles() map[string][]*multipart.FileHeader
+}
+
 // ArbitraryValuer provides the "rest" of the fields
 // that aren't strictly needed for anything in particular,
 // address, secondary e-mail, etc.
@@ -93,6 +179,15 @@ type ArbitraryValuer interface {
 	GetValues() map[string]string
 }
 
+// ExtraFieldsValuer provides the extra fields of the register form, parsed
+// and validated according to Config.Modules.RegisterExtraFields (see
+// ParseExtraFields). Validate must include their errors.
+type ExtraFieldsValuer interface {
+	Validator
+
+	GetExtraFields() ExtraFields
+}
+
 // MustHaveUserValues upgrades a validatable set of values
 // to ones specific to an authenticating user.
 func MustHaveUserValues(v Validator) UserValuer {
@@ -113,6 +208,16 @@ func MustHaveConfirmValues(v Validator) ConfirmValuer {
 	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to ConfirmValuer: %T", v))
 }
 
+// MustHaveOAuth2NativeValues upgrades a validatable set of values
+// to ones specific to an oauth2 native token exchange.
+func MustHaveOAuth2NativeValues(v Validator) OAuth2NativeValuer {
+	if u, ok := v.(OAuth2NativeValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to OAuth2NativeValuer: %T", v))
+}
+
 // MustHaveRecoverStartValues upgrades a validatable set of values
 // to ones specific to a user that needs to be recovered.
 func MustHaveRecoverStartValues(v Validator) RecoverStartValuer {
@@ -133,6 +238,36 @@ func MustHaveRecoverMiddleValues(v Validator) RecoverMiddleValuer {
 	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to RecoverMiddleValuer: %T", v))
 }
 
+// MustHaveAPIKeyValues upgrades a validatable set of values
+// to ones specific to creating an api key.
+func MustHaveAPIKeyValues(v Validator) APIKeyValuer {
+	if u, ok := v.(APIKeyValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to APIKeyValuer: %T", v))
+}
+
+// MustHaveBackupEmailValues upgrades a validatable set of values
+// to ones specific to the backup e-mail.
+func MustHaveBackupEmailValues(v Validator) BackupEmailValuer {
+	if u, ok := v.(BackupEmailValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("could not upgrade validator to backup email values: %T", v))
+}
+
+// MustHaveRenameValues upgrades a validatable set of values
+// to ones specific to changing a user's name.
+func MustHaveRenameValues(v Validator) RenameValuer {
+	if u, ok := v.(RenameValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("could not upgrade validator to rename values: %T", v))
+}
+
 // MustHaveRecoverEndValues upgrades a validatable set of values
 // to ones specific to a user that needs to be recovered.
 func MustHaveRecoverEndValues(v Validator) RecoverEndValuer {
@@ -0,0 +1,18 @@
+package authboss
+
+import "net/http"
+
+// Webhook is an endpoint the webhook module posts events to, see
+// Config.Modules.Webhooks.
+type Webhook struct {
+	// URL the events are posted to
+	URL string
+	// Secret signs the payloads with HMAC-SHA256, the signature is sent in
+	// the X-Authboss-Signature header as "sha256=<hex>".
+	Secret []byte
+	// Events to post, if it's empty all the events the webhook module
+	// supports are posted.
+	Events []Event
+	// Client to post with, http.DefaultClient is used if it's nil
+	Client *http.Client
+}
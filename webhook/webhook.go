@@ -0,0 +1,241 @@
+// Package webhook posts signed JSON payloads to external URLs when things
+// happen to users (they register, log in, get locked, reset their
+// password, change their second factors) so that other systems can react
+// without code in the app.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/volatiletech/authboss/v3"
+)
+
+// Headers sent with each delivery
+const (
+	// HeaderEvent is the name of the event, like the payload's Event
+	HeaderEvent = "X-Authboss-Event"
+	// HeaderDelivery is the id of the delivery, it's the same for retries
+	// so receivers can ignore deliveries they've already processed
+	HeaderDelivery = "X-Authboss-Delivery"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of the
+	// body under the webhook's Secret, see Sign
+	HeaderSignature = "X-Authboss-Signature"
+)
+
+// Events are the events that can be posted to webhooks
+var Events = []authboss.Event{
+	authboss.EventRegister,
+	authboss.EventAuth,
+	authboss.EventOAuth2,
+	authboss.EventAuthFail,
+	authboss.EventLock,
+	authboss.EventUnlock,
+	authboss.EventPasswordReset,
+	authboss.EventPasswordChange,
+	authboss.EventTwoFactorAdd,
+	authboss.EventTwoFactorRemove,
+}
+
+// Payload is the JSON posted to webhooks
+type Payload struct {
+	// ID of the delivery, see HeaderDelivery
+	ID string `json:"id"`
+	// Event is the event's name, like EventAuth
+	Event string `json:"event"`
+	// PID of the user the event happened to, if it's known
+	PID  string    `json:"pid,omitempty"`
+	IP   string    `json:"ip,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+func init() {
+	authboss.RegisterModule("webhook", &Webhook{})
+}
+
+// Webhook module
+type Webhook struct {
+	*authboss.Authboss
+}
+
+// ValidateConfig checks every webhook has a url
+func (wh *Webhook) ValidateConfig(ab *authboss.Authboss) []authboss.ConfigProblem {
+	problems := ab.Config.Missing("Core.Logger")
+	for i, hook := range ab.Config.Modules.Webhooks {
+		if len(hook.URL) == 0 {
+			problems = append(problems, authboss.ConfigProblem{Field: fmt.Sprintf("Modules.Webhooks[%d].URL", i), Problem: "is not set"})
+		}
+	}
+
+	return problems
+}
+
+// Init module
+func (wh *Webhook) Init(ab *authboss.Authboss) error {
+	wh.Authboss = ab
+
+	for _, hook := range ab.Config.Modules.Webhooks {
+		if len(hook.URL) == 0 {
+			return errors.New("webhook module has a webhook without a url")
+		}
+	}
+
+	for _, e := range Events {
+		if len(wh.hooks(e)) != 0 {
+			wh.Events.After(e, wh.sender(e))
+		}
+	}
+
+	return nil
+}
+
+// hooks returns the webhooks that the event is posted to
+func (wh *Webhook) hooks(e authboss.Event) []authboss.Webhook {
+	var hooks []authboss.Webhook
+	for _, hook := range wh.Config.Modules.Webhooks {
+		if len(hook.Events) == 0 || hasEvent(hook.Events, e) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// sender creates an event handler that posts the event to its webhooks
+func (wh *Webhook) sender(e authboss.Event) authboss.EventHandler {
+	return func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		logger := wh.RequestLogger(r)
+
+		id, err := deliveryID()
+		if err != nil {
+			return false, err
+		}
+
+		payload := Payload{
+			ID:    id,
+			Event: e.String(),
+			PID:   wh.pid(r),
+			IP:    wh.Attempt(r).IP,
+			Time:  wh.Now().UTC(),
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to marshal webhook payload")
+		}
+
+		for _, hook := range wh.hooks(e) {
+			hook := hook
+			wh.Background(func() {
+				if err := wh.Deliver(context.Background(), hook, payload, body); err != nil {
+					logger.Errorf("failed to post %s to webhook %s: %+v", e, hook.URL, err)
+				}
+			})
+		}
+
+		return false, nil
+	}
+}
+
+// pid of the user the event happened to, modules put the user in the
+// context for most events
+func (wh *Webhook) pid(r *http.Request) string {
+	if user, ok := r.Context().Value(authboss.CTXKeyUser).(authboss.User); ok {
+		return user.GetPID()
+	}
+
+	pid, _ := wh.CurrentUserID(r)
+	return pid
+}
+
+// Deliver posts the payload's body to the webhook, retrying with backoff
+// (Modules.WebhookRetries and Modules.WebhookBackoff) until it gets a 2xx.
+func (wh *Webhook) Deliver(ctx context.Context, hook authboss.Webhook, payload Payload, body []byte) error {
+	client := hook.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	backoff := wh.Config.Modules.WebhookBackoff
+	var err error
+	for attempt := 0; attempt <= wh.Config.Modules.WebhookRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		if err = post(ctx, client, hook, payload, body); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func post(ctx context.Context, client *http.Client, hook authboss.Webhook, payload Payload, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook request")
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, payload.Event)
+	req.Header.Set(HeaderDelivery, payload.ID)
+	if len(hook.Secret) != 0 {
+		req.Header.Set(HeaderSignature, Sign(hook.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to post webhook")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign the body with the secret, it's the value of HeaderSignature.
+// Receivers should compute it over the body they received and compare it
+// with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliveryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", errors.Wrap(err, "failed to create webhook delivery id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hasEvent(events []authboss.Event, e authboss.Event) bool {
+	for _, ev := range events {
+		if ev == e {
+			return true
+		}
+	}
+	return false
+}
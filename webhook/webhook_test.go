@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volatiletech/authboss/v3"
+	"github.com/volatiletech/authboss/v3/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	ab.Config.Modules.Webhooks = []authboss.Webhook{
+		{URL: "https://example.com/hook", Events: []authboss.Event{authboss.EventLock}},
+	}
+
+	wh := &Webhook{}
+	if err := wh.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if handled, err := ab.Events.FireAfter(authboss.EventAuth, nil, nil); handled || err != nil {
+		t.Error("only the webhook's events should have handlers")
+	}
+	if len(wh.hooks(authboss.EventLock)) != 1 || len(wh.hooks(authboss.EventAuth)) != 0 {
+		t.Error("the webhook should only get its events")
+	}
+
+	ab.Config.Modules.Webhooks = []authboss.Webhook{{}}
+	if err := wh.Init(ab); err == nil {
+		t.Error("a webhook without a url should fail")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	t.Parallel()
+
+	var mut sync.Mutex
+	var attempts int
+	var got Payload
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(HeaderSignature)
+		if signature != Sign([]byte("secret"), body) {
+			t.Error("the signature was wrong:", signature)
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	ab := authboss.New()
+	ab.Config.Core.Logger = mocks.Logger{}
+	ab.Config.Modules.WebhookBackoff = time.Millisecond
+	ab.Config.Modules.Webhooks = []authboss.Webhook{{URL: server.URL, Secret: []byte("secret")}}
+
+	wh := &Webhook{}
+	if err := wh.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mocks.Request("POST")
+	r.RemoteAddr = "1.2.3.4:1234"
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, &mocks.User{Email: "test@test.com"}))
+	if _, err := ab.Events.FireAfter(authboss.EventLock, httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ab.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	if attempts != 2 {
+		t.Error("the failed delivery should have been retried once:", attempts)
+	}
+	if got.Event != "EventLock" || got.PID != "test@test.com" || got.IP != "1.2.3.4" || len(got.ID) == 0 {
+		t.Errorf("payload was wrong: %#v", got)
+	}
+}